@@ -18,22 +18,28 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/containerd/platforms"
+	"github.com/docker/go-units"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/osscontainertools/kaniko/pkg/buildcontext"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/constants"
 	"github.com/osscontainertools/kaniko/pkg/executor"
+	"github.com/osscontainertools/kaniko/pkg/image/remote"
 	"github.com/osscontainertools/kaniko/pkg/logging"
 	"github.com/osscontainertools/kaniko/pkg/timing"
 	"github.com/osscontainertools/kaniko/pkg/util"
@@ -51,12 +57,14 @@ var (
 	logLevel     string
 	logFormat    string
 	logTimestamp bool
+	optionsFile  string
 )
 
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&logLevel, "verbosity", "v", logging.DefaultLevel, "Log level (trace, debug, info, warn, error, fatal, panic)")
 	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatColor, "Log format (text, color, json)")
 	RootCmd.PersistentFlags().BoolVar(&logTimestamp, "log-timestamp", logging.DefaultLogTimestamp, "Timestamp in log output")
+	RootCmd.PersistentFlags().StringVar(&optionsFile, "config", "", "Path to a YAML or JSON file with flag values keyed by flag name, flags on the command line override it")
 	RootCmd.PersistentFlags().BoolVarP(&force, "force", "", false, "Force building outside of a container")
 
 	addKanikoOptionsFlags()
@@ -104,6 +112,11 @@ func validateFlags() {
 	if _, err := v1.ParsePlatform(opts.CustomPlatform); err != nil {
 		logrus.Fatalf("Invalid platform %q: %v", opts.CustomPlatform, err)
 	}
+	if opts.ExpectedDigest != "" {
+		if _, err := v1.NewHash(opts.ExpectedDigest); err != nil {
+			logrus.Fatalf("Invalid expected digest %q: %v", opts.ExpectedDigest, err)
+		}
+	}
 }
 
 // RootCmd is the kaniko command that is run
@@ -111,10 +124,23 @@ var RootCmd = &cobra.Command{
 	Use: "executor",
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		if cmd.Use == "executor" {
+			if optionsFile != "" {
+				if err := config.LoadOptionsFile(optionsFile, cmd.Flags()); err != nil {
+					return err
+				}
+			}
 
 			if err := logging.Configure(logLevel, logFormat, logTimestamp); err != nil {
 				return err
 			}
+			if err := logging.Redact(opts.SecretPatterns); err != nil {
+				return err
+			}
+			codes, err := parseExitCodes(opts.ExitCodes)
+			if err != nil {
+				return err
+			}
+			exitCodes = codes
 
 			validateFlags()
 
@@ -129,18 +155,80 @@ var RootCmd = &cobra.Command{
 			}
 
 			resolveEnvironmentBuildArgs(opts.BuildArgs, os.Getenv)
+			if err := resolveDestinations(opts.Destinations, opts.BuildArgs, os.Getenv); err != nil {
+				return err
+			}
 
-			if !opts.NoPush && len(opts.Destinations) == 0 {
+			if !opts.NoPush && !opts.Lint && len(opts.Destinations) == 0 {
 				return errors.New("you must provide --destination, or use --no-push")
 			}
+			if opts.PushConcurrency < 1 {
+				return errors.New("--push-concurrency must be at least 1")
+			}
+			if opts.ImageFormat == config.DockerFormat && (opts.Compression == config.ZStd || opts.CacheCompression == config.ZStd) {
+				return errors.New("zstd compressed layers are only supported with OCI media types, they can't be combined with --image-format=docker")
+			}
+			if err := executor.ValidateImagePlatform(opts); err != nil {
+				return err
+			}
+			if opts.StageExtractConcurrency < 1 {
+				return errors.New("--stage-extract-concurrency must be at least 1")
+			}
+			if opts.DigestConcurrency < 1 {
+				return errors.New("--digest-concurrency must be at least 1")
+			}
+			if opts.MaxSymlinkDepth < 1 {
+				return errors.New("--max-symlink-depth must be at least 1")
+			}
+			if opts.StreamLayers && (!opts.SingleSnapshot || opts.Cache || opts.Cleanup || opts.Compression == config.ZStd) {
+				return errors.New("--stream-layers requires --single-snapshot and can't be combined with --cache, --cleanup or --compression=zstd")
+			}
+			if opts.ExtractMemoryLimit != "" {
+				limit, err := units.RAMInBytes(opts.ExtractMemoryLimit)
+				if err != nil || limit <= 0 {
+					return fmt.Errorf("--extract-memory-limit must be a positive size, got %q", opts.ExtractMemoryLimit)
+				}
+				util.SetExtractMemoryLimit(limit)
+			}
+			if opts.MaxImageSize != "" {
+				if size, err := units.RAMInBytes(opts.MaxImageSize); err != nil || size <= 0 {
+					return fmt.Errorf("--max-image-size must be a positive size, got %q", opts.MaxImageSize)
+				}
+			}
+			if opts.WarnSmallLayers > 0 {
+				if size, err := units.RAMInBytes(opts.SmallLayerSize); err != nil || size <= 0 {
+					return fmt.Errorf("--small-layer-size must be a positive size, got %q", opts.SmallLayerSize)
+				}
+			}
+			if opts.SplitCopyLayers != "" {
+				if size, err := units.RAMInBytes(opts.SplitCopyLayers); err != nil || size <= 0 {
+					return fmt.Errorf("--split-copy-layers must be a positive size, got %q", opts.SplitCopyLayers)
+				}
+				if opts.CacheCopyLayers {
+					return errors.New("--split-copy-layers can't be combined with --cache-copy-layers")
+				}
+			}
+			if opts.VerifyBaseSignatures != "" {
+				if _, err := remote.NewSignatureVerifier(opts.VerifyBaseSignatures, opts.RegistryOptions); err != nil {
+					return errors.Wrap(err, "--verify-base-signatures")
+				}
+			}
+			for _, digest := range opts.AllowedBaseDigests {
+				if _, err := v1.NewHash(digest); err != nil {
+					return errors.Wrapf(err, "--allowed-base-digest %q", digest)
+				}
+			}
 			if err := cacheFlagsValid(); err != nil {
 				return errors.Wrap(err, "cache flags invalid")
 			}
+			if err := resolveBuildContexts(); err != nil {
+				return util.UserError(errors.Wrap(err, "error resolving build contexts"))
+			}
 			if err := resolveSourceContext(); err != nil {
-				return errors.Wrap(err, "error resolving source context")
+				return util.UserError(errors.Wrap(err, "error resolving source context"))
 			}
 			if err := resolveDockerfilePath(); err != nil {
-				return errors.Wrap(err, "error resolving dockerfile path")
+				return util.UserError(errors.Wrap(err, "error resolving dockerfile path"))
 			}
 			if len(opts.Destinations) == 0 && opts.ImageNameDigestFile != "" {
 				return errors.New("you must provide --destination if setting ImageNameDigestFile")
@@ -166,10 +254,30 @@ var RootCmd = &cobra.Command{
 					PrefixMatchOnly: false,
 				})
 			}
+			if opts.ReadOnlyContext {
+				if opts.PreserveContext {
+					return errors.New("--preserve-context can't be combined with --read-only-context, which never changes the context")
+				}
+				// the context is neither extracted into, snapshotted nor deleted
+				contextDir, err := filepath.Abs(opts.SrcContext)
+				if err != nil {
+					return errors.Wrap(err, "resolving context path")
+				}
+				util.AddToDefaultIgnoreList(util.IgnoreListEntry{
+					Path:            contextDir,
+					PrefixMatchOnly: false,
+				})
+			}
 		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if opts.Lint {
+			if err := lint(os.Stdout); err != nil {
+				exit(err)
+			}
+			return
+		}
 		if !checkContained() {
 			if !force {
 				exit(errors.New("kaniko should only be run inside of a container, run with the --force flag if you are sure you want to continue"))
@@ -187,6 +295,8 @@ var RootCmd = &cobra.Command{
 		if err := os.Chdir("/"); err != nil {
 			exit(errors.Wrap(err, "error changing to root dir"))
 		}
+		stopSignals := cancelBuildOnSignal()
+		defer stopSignals()
 		image, err := executor.DoBuild(opts)
 		if err != nil {
 			exit(errors.Wrap(err, "error building image"))
@@ -228,45 +338,140 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.DockerfilePath, "dockerfile", "f", "Dockerfile", "Path to the dockerfile to be built.")
 	RootCmd.PersistentFlags().StringVarP(&opts.SrcContext, "context", "c", "/workspace/", "Path to the dockerfile build context.")
 	RootCmd.PersistentFlags().StringVarP(&ctxSubPath, "context-sub-path", "", "", "Sub path within the given context.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ContextDigestFile, "context-digest-file", "", "", "File to record the digests of the build context files in, the next build reuses them for files that are unchanged.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ContextSHA256, "context-sha256", "", "", "Expected sha256 digest of a build context tar downloaded over https.")
+	RootCmd.PersistentFlags().VarP(&opts.ContextHTTPHeaders, "context-http-header", "", "Header in 'Name: value' format sent when downloading the build context over https. Set it repeatedly for multiple headers.")
+	RootCmd.PersistentFlags().IntVarP(&opts.ContextMaxRedirects, "context-max-redirects", "", 10, "Maximum number of redirects followed when downloading the build context over https.")
 	RootCmd.PersistentFlags().StringVarP(&opts.Bucket, "bucket", "b", "", "Name of the GCS bucket from which to access build context as tarball.")
 	RootCmd.PersistentFlags().VarP(&opts.Destinations, "destination", "d", "Registry the final image should be pushed to. Set it repeatedly for multiple destinations.")
+	opts.DestinationAuths = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.DestinationAuths, "destination-auth", "", "Credentials file used to push to a destination in destination=path format, consulted before the default keychain. Set it repeatedly for multiple destinations.")
+	opts.DestinationPlatforms = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.DestinationPlatforms, "destination-platforms", "", "Platforms a destination accepts in destination=platform[,platform] format, the image is not pushed to it if it is built for another platform. Set it repeatedly for multiple destinations.")
 	RootCmd.PersistentFlags().StringVarP(&opts.SnapshotMode, "snapshot-mode", "", "full", "Change the file attributes inspected during snapshotting")
+	RootCmd.PersistentFlags().StringVarP(&opts.Snapshotter, "snapshotter", "", "", "Name of the snapshotter that takes the snapshots of the layers, registered by a custom build of kaniko. Defaults to the built-in one that walks the filesystem.")
 	RootCmd.PersistentFlags().StringVarP(&opts.CustomPlatform, "custom-platform", "", "", "Specify the build platform if different from the current host")
+	RootCmd.PersistentFlags().StringVarP(&opts.ImageOS, "image-os", "", "", "Set the os of the final image instead of the one of the build platform, ie. for scratch images")
+	RootCmd.PersistentFlags().StringVarP(&opts.ImageArch, "image-arch", "", "", "Set the architecture of the final image instead of the one of the build platform, ie. for scratch images")
 	RootCmd.PersistentFlags().VarP(&opts.BuildArgs, "build-arg", "", "This flag allows you to pass in ARG values at build time. Set it repeatedly for multiple values.")
+	RootCmd.PersistentFlags().VarP(&opts.CommandBuildArgs, "command-build-arg", "", "Set a build arg for a single RUN as INDEX:NAME=VALUE, INDEX counts the instructions of the Dockerfile after FROM from 0. Set it repeatedly for multiple values.")
+	RootCmd.PersistentFlags().VarP(&opts.SecretPatterns, "secret-pattern", "", "Replace the matches of this regular expression in the logs with ***, like secrets build args or RUN commands contain. Set it repeatedly for multiple patterns.")
+	RootCmd.PersistentFlags().VarP(&opts.HideHistory, "hide-history", "", "Leave the commands matching this regular expression, like '^RUN rm ', out of the history of the image. Their changes to the filesystem are kept. Set it repeatedly for multiple patterns.")
+	RootCmd.PersistentFlags().VarP(&opts.ExitCodes, "exit-code", "", "Exit with this code for a class of errors as CLASS=CODE, the classes are user, infra, command and internal. Set it repeatedly for multiple classes.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Insecure, "insecure", "", false, "Push to insecure registry using plain HTTP")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipTLSVerify, "skip-tls-verify", "", false, "Push to insecure registry ignoring TLS verify")
 	RootCmd.PersistentFlags().BoolVarP(&opts.InsecurePull, "insecure-pull", "", false, "Pull from insecure registry using plain HTTP")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipTLSVerifyPull, "skip-tls-verify-pull", "", false, "Pull from insecure registry ignoring TLS verify")
 	RootCmd.PersistentFlags().IntVar(&opts.PushRetry, "push-retry", 0, "Number of retries for the push operation")
+	RootCmd.PersistentFlags().IntVar(&opts.PushConcurrency, "push-concurrency", 4, "Number of layers to upload in parallel when pushing the image")
+	RootCmd.PersistentFlags().BoolVar(&opts.PushMountFromCache, "push-mount-from-cache", false, "Mount layers from the cache repo instead of uploading them when it is on the registry of the destination")
+	RootCmd.PersistentFlags().BoolVar(&opts.PushAtomic, "push-atomic", false, "Push the image to all destinations or to none of them, tags pushed before a failure are rolled back.")
+	RootCmd.PersistentFlags().BoolVar(&opts.PushSkipUnchanged, "push-skip-unchanged", false, "Don't push to destinations whose tag points at the built image already.")
+	RootCmd.PersistentFlags().BoolVar(&opts.PushDiffOnly, "push-diff-only", false, "Push only the layers kaniko added, the layers of the base image are referenced as non-distributable layers.")
 	RootCmd.PersistentFlags().BoolVar(&opts.PushIgnoreImmutableTagErrors, "push-ignore-immutable-tag-errors", false, "If true, known tag immutability errors are ignored and the push finishes with success.")
 	RootCmd.PersistentFlags().IntVar(&opts.ImageFSExtractRetry, "image-fs-extract-retry", 0, "Number of retries for image FS extraction")
+	RootCmd.PersistentFlags().StringVarP(&opts.ExtractMemoryLimit, "extract-memory-limit", "", "", "Cap the memory used to decompress and copy layers while they are extracted, for example 512m. Extractions wait for each other to stay below it.")
+	RootCmd.PersistentFlags().IntVar(&opts.StageExtractConcurrency, "stage-extract-concurrency", 4, "Number of images referred to by COPY --from or RUN --mount to download and extract in parallel")
+	RootCmd.PersistentFlags().IntVar(&opts.DigestConcurrency, "digest-concurrency", 4, "Number of build context files to hash in parallel for the cache keys, 1 hashes them while the cache keys are computed")
 	RootCmd.PersistentFlags().IntVar(&opts.ImageDownloadRetry, "image-download-retry", 0, "Number of retries for downloading the remote image")
+	RootCmd.PersistentFlags().DurationVar(&opts.PullTimeout, "pull-timeout", 0, "Timeout of each request to pull an image or read the cache, including its response body, ex: 5m. Defaults to no timeout.")
+	RootCmd.PersistentFlags().DurationVar(&opts.PullTLSHandshakeTimeout, "pull-tls-handshake-timeout", 10*time.Second, "Timeout of the TLS handshake with registries images are pulled from")
+	RootCmd.PersistentFlags().IntVar(&opts.PullMaxIdleConns, "pull-max-idle-conns", 100, "Maximum number of idle connections kept open to registries images are pulled from")
+	RootCmd.PersistentFlags().IntVar(&opts.SnapshotRetry, "snapshot-retry", 0, "Number of retries for files that vanish while taking a snapshot")
+	RootCmd.PersistentFlags().StringVar(&opts.WhiteoutStrategy, "whiteout-strategy", "individual", "How deleted files are represented in layers. Options are individual or opaque")
 	RootCmd.PersistentFlags().StringVarP(&opts.KanikoDir, "kaniko-dir", "", constants.DefaultKanikoPath, "Path to the kaniko directory, this takes precedence over the KANIKO_DIR environment variable.")
 	RootCmd.PersistentFlags().StringVarP(&opts.TarPath, "tar-path", "", "", "Path to save the image in as a tarball instead of pushing")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SingleSnapshot, "single-snapshot", "", false, "Take a single snapshot at the end of the build.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.StreamLayers, "stream-layers", "", false, "Generate the layer of a --single-snapshot build from the filesystem while it is pushed instead of writing it to disk first.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PrintLayerDiffs, "print-layer-diffs", "", false, "Log the paths each layer adds, modifies and deletes with their sizes.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.SkipUnchangedCopies, "skip-unchanged-copies", "", false, "Leave files a COPY or ADD would overwrite with the same content out of its layer.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PreserveSourceOwnership, "preserve-source-ownership", "", false, "Keep the uid and gid of the files in the build context that COPY or ADD copy without --chown.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.FollowContextSymlinks, "follow-context-symlinks", "", false, "Copy the content of the directories symlinks in the build context point to when COPY or ADD copy a directory, instead of the symlinks.")
+	RootCmd.PersistentFlags().IntVarP(&opts.MaxSymlinkDepth, "max-symlink-depth", "", constants.DefaultMaxSymlinkDepth, "Number of symlinks resolving the destination of COPY and ADD may follow before it fails with too many levels of symbolic links.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PreserveAccessTimes, "preserve-access-times", "", false, "Keep the access times of the files COPY and ADD copy instead of setting them to the time of the copy.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PreserveInodeFlags, "preserve-inode-flags", "", false, "Keep the immutable and append-only inode flags of the files COPY and ADD copy, setting them requires CAP_LINUX_IMMUTABLE.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.GitCommitMtimes, "git-commit-mtimes", "", false, "Set the mtime of the files COPY and ADD copy from a git build context to the time of the last commit that changed them.")
+	RootCmd.PersistentFlags().VarP(&opts.CaseCollisions, "case-collisions", "", "What to do about paths COPY or ADD copy that differ from another path only by case (ignore, warn, error), defaults to ignore.")
+	opts.DuplicateDestinations = config.DuplicateDestinationWarn
+	RootCmd.PersistentFlags().VarP(&opts.DuplicateDestinations, "duplicate-destinations", "", "What to do about sources a COPY or ADD copies to the same destination file (ignore, warn, error), defaults to warn.")
+	opts.UnusedBuildArgs = config.UnusedBuildArgWarn
+	RootCmd.PersistentFlags().VarP(&opts.UnusedBuildArgs, "unused-build-args", "", "What to do about build args no ARG of the Dockerfile declares (ignore, warn, error), defaults to warn.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CheckDiskSpace, "check-disk-space", "", false, "Fail early with a clear error if a copied directory or an extracted base image doesn't fit on the disk.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CopyAsRoot, "copy-as-root", "", false, "Copy files from the build context as root:root instead of the active user when --chown is not set, as the Dockerfile specification requires.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CopyBestEffort, "copy-best-effort", "", false, "Skip sources of a COPY or ADD that vanish or can't be read instead of failing, as long as any file is copied.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.StrictContext, "strict-context", "", false, "Fail a COPY or ADD if any of its sources, wildcards included, matches no files.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.KeepEmptyLayers, "keep-empty-layers", "", false, "Add a layer for commands that changed no files, like a COPY whose sources are all excluded.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.DeduplicateAdjacentLayers, "deduplicate-adjacent-layers", "", false, "Drop layers of the final image that are identical to the layer before them.")
+	RootCmd.PersistentFlags().VarP(&opts.NormalizeEnv, "normalize-env", "", "Normalize the env of the final image (dedupe, sort): dedupe keeps the last value of each variable, sort dedupes and sorts it by name. The env is kept as it is by default.")
+	RootCmd.PersistentFlags().StringVarP(&opts.SplitCopyLayers, "split-copy-layers", "", "", "Split the layer of a COPY into several layers whose files add up to at most this size, ie. 100MB.")
+	RootCmd.PersistentFlags().IntVarP(&opts.WarnSmallLayers, "warn-small-layers", "", 0, "Warn if this many or more of the layers the build adds are smaller than --small-layer-size, suggesting to combine their commands. 0 doesn't check the layers.")
+	RootCmd.PersistentFlags().StringVarP(&opts.SmallLayerSize, "small-layer-size", "", constants.DefaultSmallLayerSize, "Compressed size below which --warn-small-layers counts a layer as small.")
+	RootCmd.PersistentFlags().StringVarP(&opts.MaxImageSize, "max-image-size", "", "", "Fail the build if the compressed layers of the image add up to more than this size, ie. 500MB.")
+	RootCmd.PersistentFlags().StringVarP(&opts.DriftCheckImage, "drift-check", "", "", "Fail the build if the files or config of the image differ from those of this image, timestamps aside, listing the differences.")
+	RootCmd.PersistentFlags().VarP(&opts.DriftIgnore, "drift-ignore", "", "Path pattern, ie. /var/log/*, or config field, ie. Labels, --drift-check leaves out. Set it repeatedly for multiple paths and fields.")
+	RootCmd.PersistentFlags().IntVarP(&opts.MaxLayers, "max-layers", "", 0, "Merge the trailing layers of the final image so that it has at most this many layers, 0 means no limit.")
+	RootCmd.PersistentFlags().IntVarP(&opts.MaxInstructions, "max-instructions", "", 0, "Fail if the Dockerfile has more instructions than this, 0 means no limit.")
+	RootCmd.PersistentFlags().IntVarP(&opts.MaxStages, "max-stages", "", 0, "Fail if the Dockerfile has more stages than this, 0 means no limit.")
+	RootCmd.PersistentFlags().IntVarP(&opts.MaxCopySources, "max-copy-sources", "", 0, "Fail if a COPY or ADD of the Dockerfile has more sources than this, 0 means no limit.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Reproducible, "reproducible", "", false, "Strip timestamps out of the image to make it reproducible")
 	RootCmd.PersistentFlags().StringVarP(&opts.Target, "target", "", "", "Set the target build stage to build")
 	RootCmd.PersistentFlags().BoolVarP(&opts.NoPush, "no-push", "", false, "Do not push the image to the registry")
+	RootCmd.PersistentFlags().BoolVarP(&opts.Lint, "lint", "", false, "Check the Dockerfile for issues without building it, exits non-zero if any are found")
 	RootCmd.PersistentFlags().BoolVarP(&opts.NoPushCache, "no-push-cache", "", false, "Do not push the cache layers to the registry")
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheRepo, "cache-repo", "", "", "Specify a repository to use as a cache, otherwise one will be inferred from the destination provided; when prefixed with 'oci:' the repository will be written in OCI image layout format at the path provided")
+	RootCmd.PersistentFlags().StringVarP(&opts.CacheExportDir, "cache-export-dir", "", "", "Export the cached layers the build produces or finds in the cache to an OCI image layout in this directory, named by their cache keys, to be imported with --cache-import-dir. They are exported with --no-push-cache as well.")
+	RootCmd.PersistentFlags().StringVarP(&opts.CacheImportDir, "cache-import-dir", "", "", "Look up cached layers in the OCI image layout in this directory, written by --cache-export-dir, before the cache repo.")
+	RootCmd.PersistentFlags().VarP(&opts.CacheFrom, "cache-from", "", "Image to import the inline cache of a previous build with --cache-inline from, when prefixed with 'oci:' the image is read from the OCI image layout at the path provided. Set it repeatedly for multiple images.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CacheInline, "cache-inline", "", false, "Embed the cache keys of the layers in the manifest of the image, so that it can be used with --cache-from.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CacheLayerKeys, "cache-layer-keys", "", false, "Embed keys of the COPY and ADD layers that don't depend on the instructions before them in the manifest of the image, so that --cache-from can reuse single layers in builds of other Dockerfiles.")
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheDir, "cache-dir", "", "/cache", "Specify a local directory to use as a cache.")
+	RootCmd.PersistentFlags().StringVarP(&opts.CacheBackend, "cache-backend", "", "", "Registered cache backend to read the warmed base images from and to store the cached layers in instead of --cache-dir and --cache-repo, dir stores them in --cache-dir.")
 	RootCmd.PersistentFlags().StringVarP(&opts.DigestFile, "digest-file", "", "", "Specify a file to save the digest of the built image to.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ExpectedDigest, "expected-digest", "", "", "Fail instead of pushing the built image if its digest is not this one, for builds that must be reproducible.")
 	RootCmd.PersistentFlags().StringVarP(&opts.ImageNameDigestFile, "image-name-with-digest-file", "", "", "Specify a file to save the image name w/ digest of the built image to.")
 	RootCmd.PersistentFlags().StringVarP(&opts.ImageNameTagDigestFile, "image-name-tag-with-digest-file", "", "", "Specify a file to save the image name w/ image tag w/ digest of the built image to.")
 	RootCmd.PersistentFlags().StringVarP(&opts.OCILayoutPath, "oci-layout-path", "", "", "Path to save the OCI image layout of the built image.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.SyncExports, "sync-exports", "", false, "Flush the tarball, OCI layout, digest files and SBOM of the build to stable storage before kaniko exits")
+	RootCmd.PersistentFlags().VarP(&opts.CopyOwners, "copy-owner", "", "Own the files COPY and ADD copy below the paths matching a pattern by a user instead of the one of --chown, ie. '/app/data=www-data:www-data'. Set it repeatedly for multiple paths, the last matching one wins.")
+	RootCmd.PersistentFlags().VarP(&opts.CopyTransforms, "copy-transform", "", "Run a transform on the files COPY and ADD copy that match a pattern, and add the files it writes to the layer, ie. '*.css=gzip' writes app.css.gz next to app.css. Set it repeatedly for multiple transforms.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.FsyncCopies, "fsync-copies", "", false, "Flush the files COPY and ADD copy, and those of COPY commands taken from the cache, to stable storage before their layer is built.")
+	RootCmd.PersistentFlags().StringVarP(&opts.DiagnosticsFile, "diagnostics-file", "", "", "Path to write the warnings of the build to as JSON, each with a stable code.")
+	RootCmd.PersistentFlags().StringVarP(&opts.DumpResolvedDockerfile, "dump-resolved-dockerfile", "", "", "Path to write the Dockerfile to with its ARG, ENV and base names expanded, for debugging.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PreprocessDockerfile, "preprocess-dockerfile", "", false, "Evaluate the # kaniko:if NAME==value, # kaniko:else and # kaniko:endif directives of the Dockerfile against the build args before parsing it.")
+	RootCmd.PersistentFlags().StringVarP(&opts.BuildReportPath, "build-report", "", "", "Path to write a JSON report of the built stages to, with their base image, commands, layers, cache hits and duration.")
+	RootCmd.PersistentFlags().StringVarP(&opts.FileProvenancePath, "file-provenance", "", "", "Path to write a JSON map of the paths the final stage produced to, with the command that produced each and the source of the files of a COPY.")
+	RootCmd.PersistentFlags().StringVarP(&opts.SBOMPath, "sbom-path", "", "", "Path to write a CycloneDX SBOM of the OS packages installed in the final image to.")
+	RootCmd.PersistentFlags().StringVarP(&opts.RootFSManifestVerify, "rootfs-manifest-verify", "", "", "Path to a sha256sum manifest the filesystem of the final stage must match, the build fails otherwise.")
+	RootCmd.PersistentFlags().VarP(&opts.RootFSManifestAllow, "rootfs-manifest-allow", "", "Path that is left out of --rootfs-manifest-verify. Set it repeatedly for multiple paths.")
+	RootCmd.PersistentFlags().StringVarP(&opts.VerifyBaseSignatures, "verify-base-signatures", "", "", "Abort the build unless all base images carry a valid cosign signature. Set it to the path of the cosign public key.")
+	RootCmd.PersistentFlags().VarP(&opts.AllowedBaseDigests, "allowed-base-digest", "", "Abort the build unless all base images resolve to one of these digests, ie. sha256:<hex>. Set it repeatedly for multiple digests.")
+	RootCmd.PersistentFlags().StringVarP(&opts.Provenance, "provenance", "", "", "Attach a provenance attestation to the pushed image as OCI referrer. Set it to the path of an in-toto statement, or to 'minimal' to let kaniko generate one.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ProvenancePath, "provenance-file", "", "", "Path to write a SLSA provenance of the image to, generated by kaniko.")
 	RootCmd.PersistentFlags().VarP(&opts.Compression, "compression", "", "Compression algorithm (gzip, zstd)")
 	RootCmd.PersistentFlags().IntVarP(&opts.CompressionLevel, "compression-level", "", -1, "Compression level")
+	RootCmd.PersistentFlags().VarP(&opts.CacheCompression, "cache-compression", "", "Compression algorithm of the cached layers (gzip, zstd), defaults to the value of --compression")
+	RootCmd.PersistentFlags().VarP(&opts.ImageFormat, "image-format", "", "Media types of the pushed and exported image (oci, docker), defaults to the media types of the base image")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Cache, "cache", "", false, "Use cache when building image")
 	RootCmd.PersistentFlags().BoolVarP(&opts.CompressedCaching, "compressed-caching", "", true, "Compress the cached layers. Decreases build time, but increases memory usage.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.PreCleanup, "pre-cleanup", "", false, "Clean the filesystem before the build")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Cleanup, "cleanup", "", false, "Clean the filesystem at the end")
+	RootCmd.PersistentFlags().BoolVarP(&opts.KeepRootOnExit, "keep-root-on-exit", "", false, "Keep the filesystem of the build at the end instead of cleaning it, for debugging")
+	RootCmd.PersistentFlags().StringVarP(&opts.CheckpointTarPath, "checkpoint-tar", "", "", "Path to write a tar of the filesystem of the build to when a command fails, for debugging")
+	RootCmd.PersistentFlags().IntVarP(&opts.CheckpointCommand, "checkpoint-command", "", 0, "Write the --checkpoint-tar after this command of the final stage instead, counting from 1")
+	RootCmd.PersistentFlags().StringVarP(&opts.ResumeStatePath, "resume-state", "", "", "File to record the completed stages of the build in, a rerun of a failed build skips the stages whose inputs are unchanged. The kaniko directory must be kept between the runs.")
 	RootCmd.PersistentFlags().DurationVarP(&opts.CacheTTL, "cache-ttl", "", time.Hour*336, "Cache timeout, requires value and unit of duration -> ex: 6h. Defaults to two weeks.")
 	RootCmd.PersistentFlags().VarP(&opts.InsecureRegistries, "insecure-registry", "", "Insecure registry using plain HTTP to push and pull. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&opts.AllowedRegistries, "allowed-registry", "", "Only pull from and push to registries matching this pattern, ie. *.gcr.io. Set it repeatedly for multiple registries.")
 	RootCmd.PersistentFlags().VarP(&opts.SkipTLSVerifyRegistries, "skip-tls-verify-registry", "", "Insecure registry ignoring TLS verify to push and pull. Set it repeatedly for multiple registries.")
 	opts.RegistriesCertificates = make(map[string]string)
 	RootCmd.PersistentFlags().VarP(&opts.RegistriesCertificates, "registry-certificate", "", "Use the provided certificate for TLS communication with the given registry. Expected format is 'my.registry.url=/path/to/the/server/certificate'.")
 	opts.RegistriesClientCertificates = make(map[string]string)
 	RootCmd.PersistentFlags().VarP(&opts.RegistriesClientCertificates, "registry-client-cert", "", "Use the provided client certificate for mutual TLS (mTLS) communication with the given registry. Expected format is 'my.registry.url=/path/to/client/cert,/path/to/client/key'.")
+	opts.RegistryHeaders = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.RegistryHeaders, "registry-header", "", "Header to send with each pull, push and cache request to a registry, ie. for proxies that route or audit by it. Expected format is 'X-Team=builds'. Set it repeatedly for multiple headers.")
+	RootCmd.PersistentFlags().StringVarP(&opts.RegistryUserAgent, "registry-user-agent", "", "", "User agent to send with each pull, push and cache request to a registry instead of the one of kaniko.")
+	opts.ReferenceRewrites = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.ReferenceRewrites, "rewrite-reference", "", "Rewrite image references starting with the prefix to the replacement before contacting any registry. Expected format is 'docker.io=mirror.example.com'. Set it repeatedly for multiple rules.")
 	opts.RegistryMaps = make(map[string][]string)
 	RootCmd.PersistentFlags().VarP(&opts.RegistryMaps, "registry-map", "", "Registry map of mirror to use as pull-through cache instead. Expected format is 'orignal.registry=new.registry;other-original.registry=other-remap.registry'")
 	RootCmd.PersistentFlags().VarP(&opts.RegistryMirrors, "registry-mirror", "", "Registry mirror to use as pull-through cache instead of docker.io. Set it repeatedly for multiple mirrors.")
@@ -277,12 +482,25 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().BoolVarP(&opts.RunV2, "use-new-run", "", false, "Use the experimental run implementation for detecting changes without requiring file system snapshots.")
 	RootCmd.PersistentFlags().Var(&opts.Git, "git", "Branch to clone if build context is a git repository")
 	RootCmd.PersistentFlags().BoolVarP(&opts.CacheCopyLayers, "cache-copy-layers", "", false, "Caches copy layers")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CacheDecompressedArchives, "cache-decompressed-archives", "", false, "Keep the decompressed tars of the compressed archives ADD unpacks in the cache dir, builds unpacking them again still extract their files.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.CacheRunLayers, "cache-run-layers", "", true, "Caches run layers")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PrefetchBaseImages, "prefetch-base-images", "", false, "Fetch the base image of the next stage in the background while a stage is built.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CacheIgnorePlatform, "cache-ignore-platform", "", false, "Leave the platform of the base image out of the cache keys, so that builds for other architectures share the cached layers.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.CacheRunLayersPortable, "cache-run-layers-portable", "", false, "Cache run layers under a key that only depends on the base image, the command and its args, so that identical RUN commands share cached layers across Dockerfiles")
 	RootCmd.PersistentFlags().VarP(&opts.IgnorePaths, "ignore-path", "", "Ignore these paths when taking a snapshot. Set it repeatedly for multiple paths.")
+	RootCmd.PersistentFlags().VarP(&opts.BaseExcludePaths, "base-exclude-path", "", "Leave this path of the base image out of the filesystem of the build, like /usr/share/doc. Set it repeatedly for multiple paths.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipPushPermissionCheck, "skip-push-permission-check", "", false, "Skip check of the push permission")
 	opts.Annotations = make(map[string]string)
 	RootCmd.PersistentFlags().VarP(&opts.Annotations, "annotation", "", "Set metadata annotations for the image in key=value format. Set it repeatedly for multiple annotations.")
+	opts.BuildContexts = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.BuildContexts, "build-context", "", "Additional named build context in name=path, name=docker-image://image or name=tar://stdin format that can be referenced by COPY --from=name. Set it repeatedly for multiple contexts.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.ReadOnlyContext, "read-only-context", "", false, "Never write to the build context, leave it out of the filesystem of the build and fail the build if a command writes to it.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.PreserveContext, "preserve-context", "", false, "Preserve build context across build stages by taking a snapshot of the full filesystem before build and restore it after we switch stages. Restores in the end too if passed together with 'cleanup'")
+	RootCmd.PersistentFlags().StringVarP(&opts.DefaultDirMode, "default-dir-mode", "", "", "Octal mode applied to directories copied by COPY and ADD without --chmod, instead of keeping the mode of the source.")
+	RootCmd.PersistentFlags().StringVarP(&opts.DefaultFileMode, "default-file-mode", "", "", "Octal mode applied to files copied by COPY and ADD without --chmod, instead of keeping the mode of the source.")
+	RootCmd.PersistentFlags().StringVarP(&opts.MaxCopyMode, "max-copy-mode", "", "", "Octal mode with the permission bits COPY and ADD may grant with --chmod, --default-dir-mode and --default-file-mode.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.HermeticRun, "hermetic-run", "", false, "Run the commands of RUN without network access, where kaniko can create network namespaces.")
+	RootCmd.PersistentFlags().StringVarP(&opts.RunUmask, "run-umask", "", "", "Octal umask between 0 and 0777 of the processes of RUN commands, instead of inheriting the umask of kaniko.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Materialize, "materialize", "", false, "Guarantee that the final state of the file system corresponds to what was specified as the build target, even if we have 100% cache hitrate and wouldn't need to unpack any layers")
 	RootCmd.PersistentFlags().VarP(&opts.CredentialHelpers, "credential-helpers", "", "Use these credential helpers automatically, select from (env, google, ecr, acr, gitlab). Set it repeatedly for multiple helpers, defaults to all, set it to empty string to deactivate.")
 
@@ -326,6 +544,22 @@ func checkContained() bool {
 	return proc.GetContainerRuntime(0, 0) != proc.RuntimeNotFound
 }
 
+// lint prints the issues found in the Dockerfile to w, it only fails if there are any.
+func lint(w io.Writer) error {
+	findings, err := executor.Lint(opts)
+	if err != nil {
+		return errors.Wrap(err, "error linting dockerfile")
+	}
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s:%s\n", opts.DockerfilePath, f)
+	}
+	if len(findings) > 0 {
+		return util.UserError(fmt.Errorf("found %d issues in %s", len(findings), opts.DockerfilePath))
+	}
+	logrus.Infof("No issues found in %s", opts.DockerfilePath)
+	return nil
+}
+
 // checkNoDeprecatedFlags return an error if deprecated flags are used.
 func checkNoDeprecatedFlags() {
 	// In version >=2.0.0 make it fail (`Warn` -> `Fatal`)
@@ -352,6 +586,9 @@ func checkNoDeprecatedFlags() {
 // cacheFlagsValid makes sure the flags passed in related to caching are valid
 func cacheFlagsValid() error {
 	if !opts.Cache {
+		if opts.CacheInline || opts.CacheLayerKeys || len(opts.CacheFrom) > 0 || opts.CacheExportDir != "" || opts.CacheImportDir != "" {
+			logrus.Warn("--cache-inline, --cache-layer-keys, --cache-from, --cache-export-dir and --cache-import-dir have no effect without --cache")
+		}
 		return nil
 	}
 	// If --cache=true and --no-push=true, then cache repo must be provided
@@ -398,6 +635,33 @@ func resolveEnvironmentBuildArgs(arguments []string, resolver func(string) strin
 	}
 }
 
+// resolveDestinations expands $VAR and ${VAR} in the destinations with the build args,
+// falling back to the environment, and validates the resulting references.
+func resolveDestinations(destinations []string, buildArgs []string, resolver func(string) string) error {
+	args := map[string]string{}
+	for _, arg := range buildArgs {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			args[key] = value
+		}
+	}
+	for index, destination := range destinations {
+		resolved := os.Expand(destination, func(key string) string {
+			if value, ok := args[key]; ok {
+				return value
+			}
+			return resolver(key)
+		})
+		if _, err := name.NewTag(resolved, name.WeakValidation); err != nil {
+			return errors.Wrapf(err, "invalid destination %q", resolved)
+		}
+		if resolved != destination {
+			logrus.Infof("Resolved destination %s to %s", destination, resolved)
+		}
+		destinations[index] = resolved
+	}
+	return nil
+}
+
 // copy Dockerfile to /kaniko/Dockerfile so that if it's specified in the .dockerignore
 // it won't be copied into the image
 func copyDockerfile() error {
@@ -414,6 +678,31 @@ func copyDockerfile() error {
 	return nil
 }
 
+// resolveBuildContexts unpacks the named build context read from stdin, if any,
+// and points it to the directory it was unpacked to
+func resolveBuildContexts() error {
+	var stdinContexts []string
+	for name, value := range opts.BuildContexts {
+		if value == buildcontext.StdinBuildContext {
+			stdinContexts = append(stdinContexts, name)
+		}
+	}
+	if len(stdinContexts) == 0 {
+		return nil
+	}
+	if len(stdinContexts) > 1 || opts.SrcContext == buildcontext.StdinBuildContext {
+		return errors.New("only one of --context and --build-context can be read from stdin")
+	}
+	name := stdinContexts[0]
+	dir, err := buildcontext.UnpackNamedContextFromStdin(name)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Build context %s located at %s", name, dir)
+	opts.BuildContexts[name] = dir
+	return nil
+}
+
 // resolveSourceContext unpacks the source context if it is a tar in a bucket or in kaniko container
 // it resets srcContext to be the path to the unpacked build context within the image
 func resolveSourceContext() error {
@@ -437,6 +726,9 @@ func resolveSourceContext() error {
 		GitDepth:             opts.Git.Depth,
 		GitRecurseSubmodules: opts.Git.RecurseSubmodules,
 		InsecureSkipTLS:      opts.Git.InsecureSkipTLS,
+		SHA256:               opts.ContextSHA256,
+		HTTPHeaders:          opts.ContextHTTPHeaders,
+		MaxRedirects:         opts.ContextMaxRedirects,
 	})
 	if err != nil {
 		return err
@@ -466,6 +758,19 @@ func resolveRelativePaths() error {
 		&opts.ImageNameDigestFile,
 		&opts.ImageNameTagDigestFile,
 		&opts.OCILayoutPath,
+		&opts.SBOMPath,
+		&opts.DiagnosticsFile,
+		&opts.BuildReportPath,
+		&opts.FileProvenancePath,
+		&opts.DumpResolvedDockerfile,
+		&opts.RootFSManifestVerify,
+		&opts.ProvenancePath,
+	}
+	if opts.VerifyBaseSignatures != remote.SignaturePolicyKeyless {
+		optsPaths = append(optsPaths, &opts.VerifyBaseSignatures)
+	}
+	if opts.Provenance != executor.ProvenanceMinimal {
+		optsPaths = append(optsPaths, &opts.Provenance)
 	}
 
 	for _, p := range optsPaths {
@@ -482,22 +787,83 @@ func resolveRelativePaths() error {
 		}
 		logrus.Debugf("Resolved relative path %s to %s", relp, *p)
 	}
+
+	for name, value := range opts.BuildContexts {
+		if _, isImage := config.BuildContextImage(value); isImage || shdSkip(value) {
+			continue
+		}
+		abs, err := filepath.Abs(value)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't resolve relative path %s of build context %s to an absolute path", value, name)
+		}
+		logrus.Debugf("Resolved relative path %s of build context %s to %s", value, name, abs)
+		opts.BuildContexts[name] = abs
+	}
 	return nil
 }
 
+// cancelBuildOnSignal cancels the build once kaniko receives SIGTERM or SIGINT, ie. when
+// the pod of a preemptible CI job is terminated. A second signal exits right away.
+func cancelBuildOnSignal() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		logrus.Warnf("Received %s, aborting the build", sig)
+		util.CancelBuild(errors.Wrapf(util.ErrInterrupted, "received %s", sig))
+		if _, ok := <-signals; ok {
+			exitWithCode(errors.Wrapf(util.ErrInterrupted, "received %s again", sig), 1)
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
 func exit(err error) {
+	exitWithCode(err, ExitCode(err))
+}
+
+// exitCodes are the codes kaniko exits with for the classes of errors, see --exit-code.
+var exitCodes map[util.ErrorClass]int
+
+// ExitCode returns the code kaniko exits with for err, which is the code set for
+// the class of err with --exit-code. Without one a failed RUN propagates its exit
+// code and any other error exits with the catch all 1.
+func ExitCode(err error) int {
+	if code, ok := exitCodes[util.ClassifyError(err)]; ok {
+		return code
+	}
 	var execErr *exec.ExitError
 	if errors.As(err, &execErr) {
-		// if there is an exit code propagate it
-		exitWithCode(err, execErr.ExitCode())
+		return execErr.ExitCode()
+	}
+	return 1
+}
+
+// parseExitCodes parses the CLASS=CODE values of --exit-code.
+func parseExitCodes(values map[string]string) (map[util.ErrorClass]int, error) {
+	codes := map[util.ErrorClass]int{}
+	for class, value := range values {
+		if !slices.Contains(util.ErrorClasses, util.ErrorClass(class)) {
+			return nil, fmt.Errorf("--exit-code: unknown error class %q, expected one of %v", class, util.ErrorClasses)
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 1 || code > 255 {
+			return nil, fmt.Errorf("--exit-code: exit code %q of %s must be a number from 1 to 255", value, class)
+		}
+		codes[util.ErrorClass(class)] = code
 	}
-	// otherwise exit with catch all 1
-	exitWithCode(err, 1)
+	return codes, nil
 }
 
 // exits with the given error and exit code
 func exitWithCode(err error, exitCode int) {
-	fmt.Fprintln(os.Stderr, err)
+	fmt.Fprintln(os.Stderr, logging.RedactString(err.Error()))
 	os.Exit(exitCode)
 }
 
@@ -17,9 +17,13 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"os/exec"
 	"testing"
 
+	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/pkg/errors"
 )
 
 func TestSkipPath(t *testing.T) {
@@ -149,3 +153,84 @@ func TestResolveEnvironmentBuildArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveDestinations(t *testing.T) {
+	environment := func(variable string) string {
+		return map[string]string{"GIT_SHA": "0123abc", "TAG": "from-env"}[variable]
+	}
+	tests := []struct {
+		description  string
+		destinations []string
+		buildArgs    []string
+		expected     []string
+		shouldErr    bool
+	}{
+		{
+			description:  "no variables",
+			destinations: []string{"gcr.io/foo/bar:latest"},
+			expected:     []string{"gcr.io/foo/bar:latest"},
+		},
+		{
+			description:  "expand from environment",
+			destinations: []string{"gcr.io/foo/bar:${GIT_SHA}", "gcr.io/foo/bar:latest"},
+			expected:     []string{"gcr.io/foo/bar:0123abc", "gcr.io/foo/bar:latest"},
+		},
+		{
+			description:  "build args take precedence over environment",
+			destinations: []string{"gcr.io/foo/bar:$TAG"},
+			buildArgs:    []string{"TAG=from-arg"},
+			expected:     []string{"gcr.io/foo/bar:from-arg"},
+		},
+		{
+			description:  "invalid resulting reference",
+			destinations: []string{"gcr.io/foo/bar:$UNSET"},
+			shouldErr:    true,
+		},
+		{
+			description:  "invalid characters from variable",
+			destinations: []string{"gcr.io/foo/bar:$TAG"},
+			buildArgs:    []string{"TAG=feature/branch"},
+			shouldErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			err := resolveDestinations(tt.destinations, tt.buildArgs, environment)
+			testutil.CheckError(t, tt.shouldErr, err)
+			if !tt.shouldErr {
+				testutil.CheckDeepEqual(t, tt.expected, tt.destinations)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	runErr := exec.Command("sh", "-c", "exit 7").Run()
+	userErr := errors.Wrap(util.UserError(errors.New("no source files specified")), "error building image")
+	infraErr := errors.Wrap(context.DeadlineExceeded, "error pushing image")
+
+	original := exitCodes
+	defer func() { exitCodes = original }()
+
+	exitCodes = nil
+	testutil.CheckDeepEqual(t, 7, ExitCode(runErr))
+	testutil.CheckDeepEqual(t, 1, ExitCode(userErr))
+	testutil.CheckDeepEqual(t, 1, ExitCode(infraErr))
+
+	codes, err := parseExitCodes(map[string]string{"user": "2", "infra": "3"})
+	testutil.CheckNoError(t, err)
+	exitCodes = codes
+	testutil.CheckDeepEqual(t, 7, ExitCode(runErr))
+	testutil.CheckDeepEqual(t, 2, ExitCode(userErr))
+	testutil.CheckDeepEqual(t, 3, ExitCode(infraErr))
+	testutil.CheckDeepEqual(t, 1, ExitCode(errors.New("unclassified")))
+
+	exitCodes = map[util.ErrorClass]int{util.ErrorClassCommand: 4}
+	testutil.CheckDeepEqual(t, 4, ExitCode(runErr))
+
+	for _, invalid := range []map[string]string{{"usr": "2"}, {"user": "0"}, {"user": "256"}, {"user": "two"}} {
+		_, err := parseExitCodes(invalid)
+		testutil.CheckError(t, true, err)
+	}
+}
@@ -29,6 +29,6 @@ func main() {
 	defer s.Stop()
 
 	if err := cmd.RootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -109,14 +109,23 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheDir, "cache-dir", "c", "/cache", "Directory of the cache.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Force, "force", "f", false, "Force cache overwriting.")
 	RootCmd.PersistentFlags().DurationVarP(&opts.CacheTTL, "cache-ttl", "", time.Hour*336, "Cache timeout in hours. Defaults to two weeks.")
+	RootCmd.PersistentFlags().DurationVar(&opts.PullTimeout, "pull-timeout", 0, "Timeout of each request to pull an image, including its response body, ex: 5m. Defaults to no timeout.")
+	RootCmd.PersistentFlags().DurationVar(&opts.PullTLSHandshakeTimeout, "pull-tls-handshake-timeout", 10*time.Second, "Timeout of the TLS handshake with registries images are pulled from")
+	RootCmd.PersistentFlags().IntVar(&opts.PullMaxIdleConns, "pull-max-idle-conns", 100, "Maximum number of idle connections kept open to registries images are pulled from")
 	RootCmd.PersistentFlags().BoolVarP(&opts.InsecurePull, "insecure-pull", "", false, "Pull from insecure registry using plain HTTP")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipTLSVerifyPull, "skip-tls-verify-pull", "", false, "Pull from insecure registry ignoring TLS verify")
 	RootCmd.PersistentFlags().VarP(&opts.InsecureRegistries, "insecure-registry", "", "Insecure registry using plain HTTP to pull. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&opts.AllowedRegistries, "allowed-registry", "", "Only pull from registries matching this pattern, ie. *.gcr.io. Set it repeatedly for multiple registries.")
 	RootCmd.PersistentFlags().VarP(&opts.SkipTLSVerifyRegistries, "skip-tls-verify-registry", "", "Insecure registry ignoring TLS verify to pull. Set it repeatedly for multiple registries.")
 	opts.RegistriesCertificates = make(map[string]string)
 	RootCmd.PersistentFlags().VarP(&opts.RegistriesCertificates, "registry-certificate", "", "Use the provided certificate for TLS communication with the given registry. Expected format is 'my.registry.url=/path/to/the/server/certificate'.")
 	opts.RegistriesClientCertificates = make(map[string]string)
 	RootCmd.PersistentFlags().VarP(&opts.RegistriesClientCertificates, "registry-client-cert", "", "Use the provided client certificate for mutual TLS (mTLS) communication with the given registry. Expected format is 'my.registry.url=/path/to/client/cert,/path/to/client/key'.")
+	opts.RegistryHeaders = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.RegistryHeaders, "registry-header", "", "Header to send with each pull request to a registry, ie. for proxies that route or audit by it. Expected format is 'X-Team=builds'. Set it repeatedly for multiple headers.")
+	RootCmd.PersistentFlags().StringVarP(&opts.RegistryUserAgent, "registry-user-agent", "", "", "User agent to send with each pull request to a registry instead of the one of kaniko.")
+	opts.ReferenceRewrites = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.ReferenceRewrites, "rewrite-reference", "", "Rewrite image references starting with the prefix to the replacement before contacting any registry. Expected format is 'docker.io=mirror.example.com'. Set it repeatedly for multiple rules.")
 	opts.RegistryMaps = make(map[string][]string)
 	RootCmd.PersistentFlags().VarP(&opts.RegistryMaps, "registry-map", "", "Registry map of mirror to use as pull-through cache instead. Expected format is 'orignal.registry=new.registry;other-original.registry=other-remap.registry'")
 	RootCmd.PersistentFlags().VarP(&opts.RegistryMirrors, "registry-mirror", "", "Registry mirror to use as pull-through cache instead of docker.io. Set it repeatedly for multiple mirrors.")
@@ -124,6 +133,9 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.CustomPlatform, "customPlatform", "", "", "Specify the build platform if different from the current host")
 	RootCmd.PersistentFlags().StringVarP(&opts.DockerfilePath, "dockerfile", "d", "", "Path to the dockerfile to be cached. The kaniko warmer will parse and write out each stage's base image layers to the cache-dir. Using the same dockerfile path as what you plan to build in the kaniko executor is the expected usage.")
 	RootCmd.PersistentFlags().VarP(&opts.BuildArgs, "build-arg", "", "This flag should be used in conjunction with the dockerfile flag for scenarios where dynamic replacement of the base image is required.")
+	RootCmd.PersistentFlags().StringVar(&opts.CacheBackend, "cache-backend", cache.DirBackend, "Backend to store the cached images in, dir stores them in --cache-dir.")
+	RootCmd.PersistentFlags().BoolVar(&opts.PruneCache, "prune-cache", false, "Remove the images older than --cache-ttl from the cache before warming it.")
+	RootCmd.PersistentFlags().BoolVar(&opts.PrimePackageCaches, "prime-package-caches", false, "Also fetch the package indexes of alpine and debian images into the cache mounts of apk and apt in the caches directory of --cache-dir.")
 
 	// Default the custom platform flag to our current platform, and validate it.
 	if opts.CustomPlatform == "" {
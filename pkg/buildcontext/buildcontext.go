@@ -26,6 +26,8 @@ import (
 
 const (
 	TarBuildContextPrefix = "tar://"
+	// StdinBuildContext reads the build context as tar from stdin
+	StdinBuildContext = TarBuildContextPrefix + "stdin"
 )
 
 type BuildOptions struct {
@@ -34,6 +36,12 @@ type BuildOptions struct {
 	GitDepth             int
 	GitRecurseSubmodules bool
 	InsecureSkipTLS      bool
+	// SHA256 is the expected digest of a context downloaded over https
+	SHA256 string
+	// HTTPHeaders are sent with the request downloading a context over https
+	HTTPHeaders []string
+	// MaxRedirects limits the redirects followed downloading a context over https
+	MaxRedirects int
 }
 
 // BuildContext unifies calls to download and unpack the build context.
@@ -63,7 +71,7 @@ func GetBuildContext(srcContext string, opts BuildOptions) (BuildContext, error)
 			if util.ValidAzureBlobStorageHost(srcContext) {
 				return &AzureBlob{context: srcContext}, nil
 			}
-			return &HTTPSTar{context: srcContext}, nil
+			return &HTTPSTar{context: srcContext, opts: opts}, nil
 		case TarBuildContextPrefix:
 			return &Tar{context: context}, nil
 		}
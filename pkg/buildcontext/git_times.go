@@ -0,0 +1,140 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/sirupsen/logrus"
+)
+
+// CommitTimes returns the time of the last commit that changed each file below root
+// that git tracks, keyed by its absolute path. Files with changes that aren't committed
+// are left out. It returns nil if root isn't in a git worktree or it has no commits.
+func CommitTimes(root string) (map[string]time.Time, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	r, err := git.PlainOpenWithOptions(root, &git.PlainOpenOptions{DetectDotGit: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		logrus.Debugf("%s is not in a git repository, keeping the mtimes of copied files", root)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	top := w.Filesystem.Root()
+	prefix, err := filepath.Rel(top, root)
+	if err != nil {
+		return nil, err
+	}
+	prefix = filepath.ToSlash(prefix)
+
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	pending := map[string]bool{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if !inDir(f.Name, prefix) {
+			return nil
+		}
+		if s, ok := status[f.Name]; ok && (s.Worktree != git.Unmodified || s.Staging != git.Unmodified) {
+			return nil
+		}
+		pending[f.Name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	times := map[string]time.Time{}
+	commits, err := r.Log(&git.LogOptions{From: head.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+	err = commits.ForEach(func(c *object.Commit) error {
+		if len(pending) == 0 {
+			return storer.ErrStop
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+		// the parent of the oldest commit of a shallow clone is missing, all
+		// the files still pending are attributed to that commit then.
+		var parentTree *object.Tree
+		if parent, err := c.Parent(0); err == nil {
+			if parentTree, err = parent.Tree(); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, object.ErrParentNotFound) && !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if pending[change.To.Name] {
+				times[filepath.Join(top, filepath.FromSlash(change.To.Name))] = c.Committer.When
+				delete(pending, change.To.Name)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		// the history of a shallow clone ends early, the files still
+		// pending keep the mtimes they have.
+		logrus.Debugf("History of %s is incomplete: %v", top, err)
+	} else if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Found the commit times of %d files in %s", len(times), top)
+	return times, nil
+}
+
+func inDir(name, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func commitFiles(t *testing.T, w *git.Worktree, root string, when time.Time, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig := &object.Signature{Name: "kaniko", Email: "kaniko@example.com", When: when}
+	if _, err := w.Commit("commit", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitTimes(t *testing.T) {
+	root := t.TempDir()
+	r, err := git.PlainInit(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	commitFiles(t, w, root, first, map[string]string{"old.txt": "old", "app/changed.txt": "v1", "app/modified.txt": "v1"})
+	commitFiles(t, w, root, second, map[string]string{"app/changed.txt": "v2"})
+	if err := os.WriteFile(filepath.Join(root, "app", "modified.txt"), []byte("local change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "app", "untracked.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("whole repository", func(t *testing.T) {
+		times, err := CommitTimes(root)
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 2, len(times))
+		testutil.CheckDeepEqual(t, true, times[filepath.Join(root, "old.txt")].Equal(first))
+		testutil.CheckDeepEqual(t, true, times[filepath.Join(root, "app", "changed.txt")].Equal(second))
+	})
+
+	t.Run("subdirectory", func(t *testing.T) {
+		times, err := CommitTimes(filepath.Join(root, "app"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 1, len(times))
+		testutil.CheckDeepEqual(t, true, times[filepath.Join(root, "app", "changed.txt")].Equal(second))
+	})
+
+	t.Run("copied files get the commit times", func(t *testing.T) {
+		times, err := CommitTimes(root)
+		testutil.CheckNoError(t, err)
+		context := util.FileContext{Root: root, CommitTimes: times}
+		untracked := filepath.Join(root, "app", "untracked.txt")
+		untrackedInfo, err := os.Stat(untracked)
+		testutil.CheckNoError(t, err)
+
+		dest := t.TempDir()
+		for _, tc := range []struct {
+			src  string
+			want time.Time
+		}{
+			{src: filepath.Join(root, "old.txt"), want: first},
+			{src: filepath.Join(root, "app", "changed.txt"), want: second},
+			{src: untracked, want: untrackedInfo.ModTime()},
+		} {
+			destPath := filepath.Join(dest, filepath.Base(tc.src))
+			_, err := util.CopyFile(tc.src, destPath, context, util.DoNotChangeUID, util.DoNotChangeGID, 0, true)
+			testutil.CheckNoError(t, err)
+			fi, err := os.Stat(destPath)
+			testutil.CheckNoError(t, err)
+			if !fi.ModTime().Equal(tc.want) {
+				t.Errorf("mtime of %s is %s, want %s", destPath, fi.ModTime(), tc.want)
+			}
+		}
+	})
+
+	t.Run("not a git repository", func(t *testing.T) {
+		times, err := CommitTimes(t.TempDir())
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 0, len(times))
+	})
+}
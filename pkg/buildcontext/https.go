@@ -17,11 +17,15 @@ limitations under the License.
 package buildcontext
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	kConfig "github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/constants"
@@ -32,24 +36,42 @@ import (
 // HTTPSTar struct for https tar.gz files processing
 type HTTPSTar struct {
 	context string
+	opts    BuildOptions
 }
 
-// UnpackTarFromBuildContext downloads context file from https server
+// UnpackTarFromBuildContext downloads context file from https server.
+// The response is decompressed and extracted while it is read, unless
+// --context-sha256 is set: then it is downloaded and verified first, so
+// that nothing is extracted from a context that doesn't match.
 func (h *HTTPSTar) UnpackTarFromBuildContext() (directory string, err error) {
 
 	logrus.Info("Retrieving https tar file")
 
-	// Create directory and target file for downloading the context file
 	directory = kConfig.BuildContextDir
-	tarPath := filepath.Join(directory, constants.ContextTar)
-	file, err := util.CreateTargetTarfile(tarPath)
-	if err != nil {
+	if err = os.MkdirAll(directory, 0750); err != nil {
 		return
 	}
 
-	// Download tar file from remote https server
-	// and save it into the target tar file
-	resp, err := http.Get(h.context) //nolint:noctx
+	req, err := http.NewRequest(http.MethodGet, h.context, nil) //nolint:noctx
+	if err != nil {
+		return
+	}
+	for _, header := range h.opts.HTTPHeaders {
+		key, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return directory, fmt.Errorf("invalid context http header %q, expected 'Name: value'", header)
+		}
+		req.Header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	client := &http.Client{
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if len(via) > h.opts.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", h.opts.MaxRedirects)
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return
 	}
@@ -63,18 +85,60 @@ func (h *HTTPSTar) UnpackTarFromBuildContext() (directory string, err error) {
 		return directory, fmt.Errorf("HTTPSTar bad status from server: %s", resp.Status)
 	}
 
-	if _, err = io.Copy(file, resp.Body); err != nil {
-		return tarPath, err
+	var body io.Reader = resp.Body
+	if h.opts.SHA256 != "" {
+		tarPath := filepath.Join(directory, constants.ContextTar)
+		file, err := downloadVerified(resp.Body, tarPath, h.opts.SHA256)
+		if err != nil {
+			return directory, err
+		}
+		// Remove the tar so it doesn't interfere with subsequent commands
+		defer func() {
+			file.Close()
+			if removeErr := os.Remove(tarPath); err == nil && removeErr != nil {
+				err = removeErr
+			}
+		}()
+		body = file
 	}
 
-	logrus.Info("Retrieved https tar file")
-
-	if err = util.UnpackCompressedTar(tarPath, directory); err != nil {
+	gzr, err := gzip.NewReader(body)
+	if err != nil {
+		return
+	}
+	defer gzr.Close()
+	if _, err = util.UnTar(gzr, directory); err != nil {
 		return
 	}
 
 	logrus.Info("Extracted https tar file")
+	return directory, nil
+}
 
-	// Remove the tar so it doesn't interfere with subsequent commands
-	return directory, os.Remove(tarPath)
+// downloadVerified writes r to the file at path and returns it rewound, if the
+// sha256 digest of the download is expected.
+func downloadVerified(r io.Reader, path, expected string) (*os.File, error) {
+	file, err := util.CreateTargetTarfile(path)
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hasher), r); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(strings.TrimPrefix(expected, "sha256:"), digest) {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("HTTPSTar digest mismatch: expected %s, got sha256:%s", expected, digest)
+	}
+	logrus.Info("Retrieved https tar file")
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return file, nil
 }
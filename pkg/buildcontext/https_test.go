@@ -17,9 +17,21 @@ limitations under the License.
 package buildcontext
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	kConfig "github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
 )
 
 func TestBuildWithHttpsTar(t *testing.T) {
@@ -66,3 +78,132 @@ func TestBuildWithHttpsTar(t *testing.T) {
 		})
 	}
 }
+
+func gzippedContext(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildWithHttpsTar_Extract(t *testing.T) {
+	data := gzippedContext(t, map[string]string{
+		"Dockerfile": "FROM scratch\nCOPY foo /foo\n",
+		"foo":        "bar",
+	})
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/context.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(data)
+	})
+	mux.HandleFunc("/garbage", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a tar"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/context.tar.gz", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		opts    BuildOptions
+		wantErr bool
+		// errContains is a part of the expected error message
+		errContains string
+	}{
+		{
+			name: "with checksum",
+			path: "/context.tar.gz",
+			opts: BuildOptions{SHA256: "sha256:" + digest, HTTPHeaders: []string{"Authorization: Bearer token"}},
+		},
+		{
+			name: "follows redirect",
+			path: "/redirect",
+			opts: BuildOptions{HTTPHeaders: []string{"Authorization: Bearer token"}, MaxRedirects: 1},
+		},
+		{
+			name:    "too many redirects",
+			path:    "/redirect",
+			opts:    BuildOptions{HTTPHeaders: []string{"Authorization: Bearer token"}},
+			wantErr: true,
+		},
+		{
+			name:    "checksum mismatch",
+			path:    "/context.tar.gz",
+			opts:    BuildOptions{SHA256: "0000", HTTPHeaders: []string{"Authorization: Bearer token"}},
+			wantErr: true,
+		},
+		{
+			name:        "checksum verified before extracting",
+			path:        "/garbage",
+			opts:        BuildOptions{SHA256: "0000"},
+			wantErr:     true,
+			errContains: "digest mismatch",
+		},
+		{
+			name:    "missing auth header",
+			path:    "/context.tar.gz",
+			wantErr: true,
+		},
+		{
+			name:    "invalid header",
+			path:    "/context.tar.gz",
+			opts:    BuildOptions{HTTPHeaders: []string{"Authorization"}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			original := kConfig.BuildContextDir
+			defer func() { kConfig.BuildContextDir = original }()
+			kConfig.BuildContextDir = t.TempDir()
+
+			context := &HTTPSTar{context: server.URL + tc.path, opts: tc.opts}
+			dir, err := context.UnpackTarFromBuildContext()
+			testutil.CheckError(t, tc.wantErr, err)
+			if tc.errContains != "" && !strings.Contains(err.Error(), tc.errContains) {
+				t.Errorf("expected error containing %q, got %v", tc.errContains, err)
+			}
+			if _, err := os.Stat(filepath.Join(kConfig.BuildContextDir, constants.ContextTar)); !os.IsNotExist(err) {
+				t.Errorf("expected the downloaded context to be removed, got %v", err)
+			}
+
+			b, readErr := os.ReadFile(filepath.Join(kConfig.BuildContextDir, "foo"))
+			if tc.wantErr {
+				if readErr == nil {
+					t.Errorf("expected no context to be extracted, found foo")
+				}
+				return
+			}
+			testutil.CheckDeepEqual(t, kConfig.BuildContextDir, dir)
+			testutil.CheckNoError(t, readErr)
+			testutil.CheckDeepEqual(t, "bar", string(b))
+			if _, err := os.Stat(filepath.Join(dir, "Dockerfile")); err != nil {
+				t.Errorf("expected Dockerfile to be extracted: %v", err)
+			}
+		})
+	}
+}
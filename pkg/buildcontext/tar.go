@@ -19,7 +19,10 @@ package buildcontext
 import (
 	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	kConfig "github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/util"
@@ -39,13 +42,9 @@ func (t *Tar) UnpackTarFromBuildContext() (string, error) {
 		return "", errors.Wrap(err, "unpacking tar from build context")
 	}
 	if t.context == "stdin" {
-		fi, _ := os.Stdin.Stat()
-		if (fi.Mode() & os.ModeCharDevice) != 0 {
-			return "", fmt.Errorf("no data found.. don't forget to add the '--interactive, -i' flag")
+		if err := checkStdin(); err != nil {
+			return "", err
 		}
-		logrus.Infof("To simulate EOF and exit, press 'Ctrl+D'")
-		// if launched through docker in interactive mode and without piped data
-		// process will be stuck here until EOF is sent
 		gzr, err := gzip.NewReader(os.Stdin)
 		if err != nil {
 			return directory, err
@@ -59,3 +58,39 @@ func (t *Tar) UnpackTarFromBuildContext() (string, error) {
 
 	return directory, util.UnpackCompressedTar(t.context, directory)
 }
+
+// UnpackNamedContextFromStdin unpacks the tar piped to stdin as the named build context name
+// and returns the directory it was unpacked to.
+func UnpackNamedContextFromStdin(name string) (string, error) {
+	if err := checkStdin(); err != nil {
+		return "", err
+	}
+	return unpackNamedContext(name, os.Stdin)
+}
+
+// unpackNamedContext unpacks the possibly compressed tar read from r as the named build context name.
+func unpackNamedContext(name string, r io.Reader) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid build context name %q", name)
+	}
+	directory := filepath.Join(kConfig.NamedContextsDir, name)
+	if err := os.MkdirAll(directory, 0750); err != nil {
+		return "", errors.Wrapf(err, "creating directory for build context %s", name)
+	}
+	if _, err := util.UnpackTarStream(r, directory); err != nil {
+		return "", errors.Wrapf(err, "unpacking build context %s from stdin", name)
+	}
+	return directory, nil
+}
+
+// checkStdin fails if stdin is a terminal instead of piped data.
+func checkStdin() error {
+	fi, _ := os.Stdin.Stat()
+	if (fi.Mode() & os.ModeCharDevice) != 0 {
+		return fmt.Errorf("no data found.. don't forget to add the '--interactive, -i' flag")
+	}
+	logrus.Infof("To simulate EOF and exit, press 'Ctrl+D'")
+	// if launched through docker in interactive mode and without piped data
+	// process will be stuck here until EOF is sent
+	return nil
+}
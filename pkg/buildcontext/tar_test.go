@@ -17,6 +17,7 @@ limitations under the License.
 package buildcontext
 
 import (
+	"archive/tar"
 	"bytes"
 	"compress/gzip"
 	"fmt"
@@ -26,6 +27,7 @@ import (
 	"sync"
 	"testing"
 
+	kConfig "github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
 )
@@ -143,6 +145,34 @@ func TestBuildWithLocalTar(t *testing.T) {
 	}
 }
 
+func Test_unpackNamedContext(t *testing.T) {
+	original := kConfig.NamedContextsDir
+	defer func() { kConfig.NamedContextsDir = original }()
+	kConfig.NamedContextsDir = t.TempDir()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 4, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("meow")); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gw.Close()
+
+	dir, err := unpackNamedContext("assets", &buf)
+	testutil.CheckErrorAndDeepEqual(t, false, err, filepath.Join(kConfig.NamedContextsDir, "assets"), dir)
+	content, err := os.ReadFile(filepath.Join(dir, "file"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "meow", string(content))
+
+	for _, name := range []string{"", "..", "../assets"} {
+		_, err := unpackNamedContext(name, bytes.NewReader(nil))
+		testutil.CheckError(t, true, err)
+	}
+}
+
 func getSHAFromFilePath(f string) (string, error) {
 	data, err := os.ReadFile(f)
 	if err != nil {
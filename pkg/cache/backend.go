@@ -0,0 +1,224 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Backend stores the images of a cache by key, ie. warmed base images by their
+// digest or cached layers, which are images of a single layer, by their cache key.
+type Backend interface {
+	// Get returns the image stored under key, a NotFoundErr if there is none or an
+	// ExpiredErr if it is older than the cache TTL.
+	Get(key string) (v1.Image, error)
+	// Put stores image under key, replacing the image stored under it before.
+	Put(key string, image v1.Image) error
+	// Has returns true if an image is stored under key, even if it is expired.
+	Has(key string) (bool, error)
+	// Prune removes the images older than the cache TTL and returns their keys.
+	Prune() ([]string, error)
+}
+
+// BackendFactory creates a backend for the cache options of the warmer or executor.
+type BackendFactory func(opts *config.CacheOptions) (Backend, error)
+
+// DirBackend is the name of the backend storing images in --cache-dir.
+const DirBackend = "dir"
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]BackendFactory{
+		DirBackend: func(opts *config.CacheOptions) (Backend, error) {
+			return &DirCache{Opts: opts}, nil
+		},
+	}
+)
+
+// RegisterBackend makes the backend created by factory available by name for
+// --cache-backend, for programs embedding kaniko to cache in other stores, ie.
+// object storage. They call it from an init function. It panics if name is empty
+// or registered already.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if name == "" || factory == nil {
+		panic("cache: RegisterBackend needs a name and a factory")
+	}
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("cache: backend %s is registered twice", name))
+	}
+	backends[name] = factory
+}
+
+// NewBackend creates the backend registered by name for opts.
+func NewBackend(name string, opts *config.CacheOptions) (Backend, error) {
+	backendsMu.Lock()
+	factory, ok := backends[name]
+	names := make([]string, 0, len(backends))
+	for n := range backends {
+		names = append(names, n)
+	}
+	backendsMu.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown cache backend %q, registered are %s", name, strings.Join(names, ", "))
+	}
+	return factory(opts)
+}
+
+// BackendCache is the layer cache in the backend of --cache-backend.
+type BackendCache struct {
+	Backend Backend
+}
+
+// RetrieveLayer returns the image of the layer cached under the cache key ck.
+func (b *BackendCache) RetrieveLayer(ck string) (v1.Image, error) {
+	return b.Backend.Get(ck)
+}
+
+// DirCache is the cache in a local directory, --cache-dir, it stores each image as
+// a tarball named by its key with its manifest next to it.
+type DirCache struct {
+	Opts *config.CacheOptions
+}
+
+// Get returns the image stored under key in the cache dir.
+func (d *DirCache) Get(key string) (v1.Image, error) {
+	path := filepath.Join(d.Opts.CacheDir, key)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		msg := fmt.Sprintf("No file found for cache key %v %v", key, err)
+		logrus.Debug(msg)
+		return nil, NotFoundErr{msg: msg}
+	}
+
+	// A stale cache is a bad cache
+	expiry := fi.ModTime().Add(d.Opts.CacheTTL)
+	if expiry.Before(time.Now()) {
+		msg := fmt.Sprintf("Cached image is too old: %v", fi.ModTime())
+		logrus.Debug(msg)
+		return nil, ExpiredErr{msg: msg}
+	}
+
+	logrus.Infof("Found %s in local cache", key)
+	return cachedImageFromPath(path)
+}
+
+// Put writes image and its manifest to temporary files in the cache dir first and
+// renames them to key once complete, so that builds never read a partial image.
+func (d *DirCache) Put(key string, image v1.Image) error {
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	// images are stored without tag, they are only ever looked up by key
+	ref, err := name.NewDigest("cache@" + digest.String())
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(d.Opts.CacheDir, warmingImagePrefix+"*")
+	if err != nil {
+		return err
+	}
+	// defer called in reverse order
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	mfstFile, err := os.CreateTemp(d.Opts.CacheDir, warmingManifestPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mfstFile.Name())
+	defer mfstFile.Close()
+
+	if err := tarball.Write(ref, image, f); err != nil {
+		return errors.Wrapf(err, "writing %s to tar", key)
+	}
+	mfst, err := image.RawManifest()
+	if err != nil {
+		return errors.Wrapf(err, "getting manifest of %s", key)
+	}
+	if _, err := mfstFile.Write(mfst); err != nil {
+		return errors.Wrapf(err, "writing manifest of %s", key)
+	}
+
+	finalPath := filepath.Join(d.Opts.CacheDir, key)
+	if err := os.Rename(f.Name(), finalPath); err != nil {
+		return err
+	}
+	if err := os.Rename(mfstFile.Name(), finalPath+manifestSuffix); err != nil {
+		return errors.Wrap(err, "Failed to rename manifest file")
+	}
+	return nil
+}
+
+// Has returns true if an image is stored under key in the cache dir.
+func (d *DirCache) Has(key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(d.Opts.CacheDir, key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Prune removes the images written to the cache dir longer than the cache TTL
+// ago, together with their manifests.
+func (d *DirCache) Prune() ([]string, error) {
+	files, err := os.ReadDir(d.Opts.CacheDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading cache dir %s", d.Opts.CacheDir)
+	}
+	var pruned []string
+	for _, f := range files {
+		if !isCacheEntry(f) {
+			continue
+		}
+		fi, err := f.Info()
+		if err != nil {
+			return pruned, err
+		}
+		if fi.ModTime().Add(d.Opts.CacheTTL).After(time.Now()) {
+			continue
+		}
+		path := filepath.Join(d.Opts.CacheDir, f.Name())
+		if err := os.Remove(path); err != nil {
+			return pruned, errors.Wrapf(err, "pruning %s", f.Name())
+		}
+		if err := os.Remove(path + manifestSuffix); err != nil && !os.IsNotExist(err) {
+			return pruned, errors.Wrapf(err, "pruning the manifest of %s", f.Name())
+		}
+		logrus.Infof("Pruned %s from the cache, it was written %v", f.Name(), fi.ModTime())
+		pruned = append(pruned, f.Name())
+	}
+	return pruned, nil
+}
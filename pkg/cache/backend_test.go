@@ -0,0 +1,319 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+// memoryBackend keeps the images of a cache in memory.
+type memoryBackend struct {
+	ttl     time.Duration
+	entries map[string]memoryEntry
+	puts    int
+}
+
+type memoryEntry struct {
+	image  v1.Image
+	stored time.Time
+}
+
+func newMemoryBackend(ttl time.Duration) *memoryBackend {
+	return &memoryBackend{ttl: ttl, entries: map[string]memoryEntry{}}
+}
+
+func (m *memoryBackend) Get(key string) (v1.Image, error) {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, NotFoundErr{msg: key}
+	}
+	if e.stored.Add(m.ttl).Before(time.Now()) {
+		return nil, ExpiredErr{msg: key}
+	}
+	return e.image, nil
+}
+
+func (m *memoryBackend) Put(key string, image v1.Image) error {
+	m.puts++
+	m.entries[key] = memoryEntry{image: image, stored: time.Now()}
+	return nil
+}
+
+func (m *memoryBackend) Has(key string) (bool, error) {
+	_, ok := m.entries[key]
+	return ok, nil
+}
+
+func (m *memoryBackend) Prune() ([]string, error) {
+	var pruned []string
+	for key, e := range m.entries {
+		if e.stored.Add(m.ttl).Before(time.Now()) {
+			delete(m.entries, key)
+			pruned = append(pruned, key)
+		}
+	}
+	sort.Strings(pruned)
+	return pruned, nil
+}
+
+func Test_memoryBackend(t *testing.T) {
+	backend := newMemoryBackend(time.Hour)
+	testBackend(t, backend, func(key string) {
+		e := backend.entries[key]
+		e.stored = time.Now().Add(-2 * time.Hour)
+		backend.entries[key] = e
+	})
+}
+
+func Test_DirCache(t *testing.T) {
+	opts := &config.CacheOptions{CacheDir: t.TempDir(), CacheTTL: time.Hour}
+	testBackend(t, &DirCache{Opts: opts}, func(key string) {
+		old := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(filepath.Join(opts.CacheDir, key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// no temporary files are left behind
+	files, err := os.ReadDir(opts.CacheDir)
+	testutil.CheckNoError(t, err)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), warmingImagePrefix) || strings.HasPrefix(f.Name(), warmingManifestPrefix) {
+			t.Errorf("expected no temporary files in the cache dir, got %s", f.Name())
+		}
+	}
+}
+
+// testBackend stores two images in backend, expires the first of them with expire
+// and prunes it.
+func testBackend(t *testing.T, backend Backend, expire func(key string)) {
+	t.Helper()
+	var keys []string
+	for range 2 {
+		image, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := image.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := digest.String()
+		has, err := backend.Has(key)
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, false, has)
+		_, err = backend.Get(key)
+		testutil.CheckDeepEqual(t, true, IsNotFound(err))
+
+		testutil.CheckNoError(t, backend.Put(key, image))
+		has, err = backend.Has(key)
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, true, has)
+		cached, err := backend.Get(key)
+		testutil.CheckNoError(t, err)
+		cachedDigest, err := cached.Digest()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, digest, cachedDigest)
+		keys = append(keys, key)
+	}
+
+	expire(keys[0])
+	_, err := backend.Get(keys[0])
+	testutil.CheckDeepEqual(t, true, IsExpired(err))
+	// expired images are still cached until they are pruned
+	has, err := backend.Has(keys[0])
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, has)
+
+	pruned, err := backend.Prune()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string{keys[0]}, pruned)
+	has, err = backend.Has(keys[0])
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, false, has)
+	has, err = backend.Has(keys[1])
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, has)
+}
+
+// registerMemoryBackend registers backend as the memory backend for the test.
+func registerMemoryBackend(t *testing.T, backend *memoryBackend) {
+	RegisterBackend("memory", func(opts *config.CacheOptions) (Backend, error) {
+		return backend, nil
+	})
+	t.Cleanup(func() {
+		backendsMu.Lock()
+		delete(backends, "memory")
+		backendsMu.Unlock()
+	})
+}
+
+func Test_NewBackend(t *testing.T) {
+	backend := newMemoryBackend(time.Hour)
+	registerMemoryBackend(t, backend)
+
+	got, err := NewBackend("memory", &config.CacheOptions{})
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, got == Backend(backend))
+
+	got, err = NewBackend(DirBackend, &config.CacheOptions{CacheDir: "/cache"})
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "/cache", got.(*DirCache).Opts.CacheDir)
+
+	_, err = NewBackend("s3", &config.CacheOptions{})
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "registered are dir, memory"))
+}
+
+func Test_RegisterBackend_panics_on_duplicates(t *testing.T) {
+	registerMemoryBackend(t, newMemoryBackend(time.Hour))
+	for _, name := range []string{"memory", DirBackend, ""} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected registering %q to panic", name)
+				}
+			}()
+			RegisterBackend(name, func(opts *config.CacheOptions) (Backend, error) {
+				return newMemoryBackend(time.Hour), nil
+			})
+		})
+	}
+}
+
+func Test_LocalSource_backend(t *testing.T) {
+	backend := newMemoryBackend(time.Hour)
+	registerMemoryBackend(t, backend)
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckNoError(t, backend.Put("sha256:base", image))
+
+	// builds read the warmed base images from the backend
+	cached, err := LocalSource(&config.CacheOptions{CacheBackend: "memory"}, "sha256:base")
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, cached == image)
+	_, err = (&BackendCache{Backend: backend}).RetrieveLayer("sha256:other")
+	testutil.CheckDeepEqual(t, true, IsNotFound(err))
+}
+
+func Test_RegistryCache(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	opts := &config.KanikoOptions{CacheRepo: strings.TrimPrefix(server.URL, "http://") + "/cache"}
+	opts.CacheTTL = time.Hour
+	rc := &RegistryCache{Opts: opts}
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entries expire by the creation time of their image
+	image, err = mutate.CreatedAt(image, v1.Time{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	has, err := rc.Has("key")
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, false, has)
+
+	testutil.CheckNoError(t, rc.Put("key", image))
+	has, err = rc.Has("key")
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, has)
+	cached, err := rc.RetrieveLayer("key")
+	testutil.CheckNoError(t, err)
+	digest, err := image.Digest()
+	testutil.CheckNoError(t, err)
+	cachedDigest, err := cached.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, digest, cachedDigest)
+
+	// registries prune by their own retention policies
+	pruned, err := rc.Prune()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string(nil), pruned)
+}
+
+func Test_WarmCache_backend(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/base:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := newMemoryBackend(time.Hour)
+	registerMemoryBackend(t, backend)
+	// an expired image the warmer prunes
+	expired, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.entries["sha256:expired"] = memoryEntry{image: expired, stored: time.Now().Add(-2 * time.Hour)}
+
+	opts := &config.WarmerOptions{CacheOptions: config.CacheOptions{CacheBackend: "memory"}, PruneCache: true}
+	opts.Images = []string{ref.String()}
+	testutil.CheckNoError(t, WarmCache(opts))
+	testutil.CheckDeepEqual(t, []string{digest.String()}, keysOf(backend.entries))
+	testutil.CheckDeepEqual(t, 1, backend.puts)
+
+	// the image is cached already
+	testutil.CheckNoError(t, WarmCache(opts))
+	testutil.CheckDeepEqual(t, 1, backend.puts)
+
+	opts.Force = true
+	testutil.CheckNoError(t, WarmCache(opts))
+	testutil.CheckDeepEqual(t, 2, backend.puts)
+}
+
+func keysOf(entries map[string]memoryEntry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
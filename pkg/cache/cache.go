@@ -18,8 +18,8 @@ package cache
 
 import (
 	"fmt"
+	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -28,6 +28,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/creds"
@@ -41,47 +42,97 @@ type LayerCache interface {
 	RetrieveLayer(string) (v1.Image, error)
 }
 
-// RegistryCache is the registry cache
+// RegistryCache is the registry cache, it stores each entry as an image tagged
+// with its key in the cache repo.
 type RegistryCache struct {
 	Opts *config.KanikoOptions
 }
 
 // RetrieveLayer retrieves a layer from the cache given the cache key ck.
 func (rc *RegistryCache) RetrieveLayer(ck string) (v1.Image, error) {
-	cache, err := Destination(rc.Opts, ck)
+	return rc.Get(ck)
+}
+
+// Get returns the image tagged with key in the cache repo.
+func (rc *RegistryCache) Get(key string) (v1.Image, error) {
+	cacheRef, remoteOpts, err := rc.reference(key, util.MakePullTransport)
 	if err != nil {
-		return nil, errors.Wrap(err, "getting cache destination")
+		return nil, err
+	}
+	logrus.Infof("Checking for cached layer %s...", cacheRef)
+
+	img, err := remote.Image(cacheRef, remoteOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifyImage(img, rc.Opts.CacheTTL, cacheRef.String()); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Put pushes image to the cache repo, tagged with key.
+func (rc *RegistryCache) Put(key string, image v1.Image) error {
+	cacheRef, remoteOpts, err := rc.reference(key, util.MakeTransport)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Pushing %s to cache", cacheRef)
+	return remote.Write(cacheRef, image, remoteOpts...)
+}
+
+// Has returns true if an image is tagged with key in the cache repo.
+func (rc *RegistryCache) Has(key string) (bool, error) {
+	cacheRef, remoteOpts, err := rc.reference(key, util.MakePullTransport)
+	if err != nil {
+		return false, err
+	}
+	_, err = remote.Head(cacheRef, remoteOpts...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Prune removes nothing, registries expire the tags of a cache repo with
+// retention policies of their own. Entries older than the cache TTL are not
+// used regardless.
+func (rc *RegistryCache) Prune() ([]string, error) {
+	return nil, nil
+}
+
+// reference returns the tag of key in the cache repo and the options to reach its
+// registry with a transport made by makeTransport.
+func (rc *RegistryCache) reference(key string, makeTransport func(config.RegistryOptions, string) (http.RoundTripper, error)) (name.Tag, []remote.Option, error) {
+	cache, err := Destination(rc.Opts, key)
+	if err != nil {
+		return name.Tag{}, nil, errors.Wrap(err, "getting cache destination")
 	}
-	logrus.Infof("Checking for cached layer %s...", cache)
 
 	cacheRef, err := name.NewTag(cache, name.WeakValidation)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("getting reference for %s", cache))
+		return name.Tag{}, nil, errors.Wrap(err, fmt.Sprintf("getting reference for %s", cache))
 	}
 
 	registryName := cacheRef.Repository.Registry.Name()
+	if err := util.CheckRegistryAllowed(rc.Opts.RegistryOptions, registryName); err != nil {
+		return name.Tag{}, nil, errors.Wrapf(err, "checking for cached layer %s", cache)
+	}
 	if rc.Opts.Insecure || rc.Opts.InsecureRegistries.Contains(registryName) {
 		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
 		if err != nil {
-			return nil, err
+			return name.Tag{}, nil, err
 		}
 		cacheRef.Repository.Registry = newReg
 	}
 
-	tr, err := util.MakeTransport(rc.Opts.RegistryOptions, registryName)
-	if err != nil {
-		return nil, errors.Wrapf(err, "making transport for registry %q", registryName)
-	}
-
-	img, err := remote.Image(cacheRef, remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain(&rc.Opts.RegistryOptions)))
+	tr, err := makeTransport(rc.Opts.RegistryOptions, registryName)
 	if err != nil {
-		return nil, err
+		return name.Tag{}, nil, errors.Wrapf(err, "making transport for registry %q", registryName)
 	}
-
-	if err = verifyImage(img, rc.Opts.CacheTTL, cache); err != nil {
-		return nil, err
-	}
-	return img, nil
+	return cacheRef, []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain(&rc.Opts.RegistryOptions))}, nil
 }
 
 func verifyImage(img v1.Image, cacheTTL time.Duration, cache string) error {
@@ -166,37 +217,25 @@ func Destination(opts *config.KanikoOptions, cacheKey string) (string, error) {
 		if err != nil {
 			return "", errors.Wrap(err, "getting tag for destination")
 		}
-		return fmt.Sprintf("%s/cache:%s", destRef.Context(), cacheKey), nil
+		return util.RewriteReference(opts.RegistryOptions, fmt.Sprintf("%s/cache:%s", destRef.Context(), cacheKey)), nil
 	}
-	return fmt.Sprintf("%s:%s", cache, cacheKey), nil
+	return util.RewriteReference(opts.RegistryOptions, fmt.Sprintf("%s:%s", cache, cacheKey)), nil
 }
 
-// LocalSource retrieves a source image from a local cache given cacheKey
+// LocalSource retrieves a source image the warmer stored in the backend of
+// --cache-backend, --cache-dir by default, given cacheKey
 func LocalSource(opts *config.CacheOptions, cacheKey string) (v1.Image, error) {
-	cache := opts.CacheDir
-	if cache == "" {
-		return nil, nil
+	if opts.CacheBackend == "" {
+		if opts.CacheDir == "" {
+			return nil, nil
+		}
+		return (&DirCache{Opts: opts}).Get(cacheKey)
 	}
-
-	path := path.Join(cache, cacheKey)
-
-	fi, err := os.Stat(path)
+	backend, err := NewBackend(opts.CacheBackend, opts)
 	if err != nil {
-		msg := fmt.Sprintf("No file found for cache key %v %v", cacheKey, err)
-		logrus.Debug(msg)
-		return nil, NotFoundErr{msg: msg}
-	}
-
-	// A stale cache is a bad cache
-	expiry := fi.ModTime().Add(opts.CacheTTL)
-	if expiry.Before(time.Now()) {
-		msg := fmt.Sprintf("Cached image is too old: %v", fi.ModTime())
-		logrus.Debug(msg)
-		return nil, ExpiredErr{msg: msg}
+		return nil, err
 	}
-
-	logrus.Infof("Found %s in local cache", cacheKey)
-	return cachedImageFromPath(path)
+	return backend.Get(cacheKey)
 }
 
 // cachedImage represents a v1.Tarball that is cached locally in a CAS.
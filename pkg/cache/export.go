@@ -0,0 +1,126 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// refNameAnnotation is the annotation of the descriptors of an OCI image layout
+// index that names them, the cache key of each entry of an exported cache.
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// exportMu serializes the writes to the index of exported caches, cache entries
+// are pushed in parallel.
+var exportMu sync.Mutex
+
+// ExportedCache is a cache in an OCI image layout directory, see --cache-export-dir
+// and --cache-import-dir. The blobs of all entries share the layout, the index
+// records the manifest of each entry with its cache key as ref name, so that the
+// directory can be carried to builds without access to the cache repo.
+type ExportedCache struct {
+	Path string
+	TTL  time.Duration
+	// Fallback retrieves the layers not found in the directory, if set.
+	Fallback LayerCache
+}
+
+// Put adds image to the layout under key, replacing the entry stored under it before.
+func (e *ExportedCache) Put(key string, image v1.Image) error {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	p, err := e.layout(true)
+	if err != nil {
+		return err
+	}
+	if err := p.ReplaceImage(image, match.Name(key), layout.WithAnnotations(map[string]string{refNameAnnotation: key})); err != nil {
+		return errors.Wrapf(err, "exporting cache entry %s to %s", key, e.Path)
+	}
+	logrus.Infof("Exported cached layer %s to %s", key, e.Path)
+	return nil
+}
+
+// RetrieveLayer retrieves the image stored under the cache key ck in the layout,
+// or from Fallback if the layout has none.
+func (e *ExportedCache) RetrieveLayer(ck string) (v1.Image, error) {
+	img, err := e.Get(ck)
+	if IsNotFound(err) && e.Fallback != nil {
+		return e.Fallback.RetrieveLayer(ck)
+	}
+	return img, err
+}
+
+// Get returns the image stored under key in the layout, a NotFoundErr if there is
+// none or an ExpiredErr if it was created longer than the TTL ago.
+func (e *ExportedCache) Get(key string) (v1.Image, error) {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	p, err := e.layout(false)
+	if os.IsNotExist(err) {
+		return nil, NotFoundErr{msg: fmt.Sprintf("no exported cache at %s", e.Path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	index, err := p.ImageIndex()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the index of %s", e.Path)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the index of %s", e.Path)
+	}
+	for _, desc := range manifest.Manifests {
+		if desc.Annotations[refNameAnnotation] != key {
+			continue
+		}
+		img, err := p.Image(desc.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading exported cache entry %s", key)
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading exported cache entry %s", key)
+		}
+		if e.TTL > 0 && cf.Created.Add(e.TTL).Before(time.Now()) {
+			return nil, ExpiredErr{msg: fmt.Sprintf("exported cache entry %s is too old: %v", key, cf.Created)}
+		}
+		logrus.Infof("Found cached layer %s in %s", key, e.Path)
+		return img, nil
+	}
+	return nil, NotFoundErr{msg: fmt.Sprintf("no exported cache entry for key %s in %s", key, e.Path)}
+}
+
+// layout returns the layout at Path, it is created empty if create is set and
+// there is none.
+func (e *ExportedCache) layout(create bool) (layout.Path, error) {
+	p, err := layout.FromPath(e.Path)
+	if err == nil || !create || !os.IsNotExist(err) {
+		return p, err
+	}
+	return layout.Write(e.Path, empty.Index)
+}
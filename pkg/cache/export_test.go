@@ -0,0 +1,95 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_ExportedCache(t *testing.T) {
+	image := func(created time.Time) v1.Image {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	digest := func(img v1.Image) v1.Hash {
+		d, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	path := filepath.Join(t.TempDir(), "cache")
+	ec := &ExportedCache{Path: path, TTL: time.Hour}
+
+	// nothing was exported yet
+	_, err := ec.Get("a")
+	testutil.CheckDeepEqual(t, true, IsNotFound(err))
+
+	first, replaced, other := image(time.Now()), image(time.Now()), image(time.Now().Add(-2*time.Hour))
+	testutil.CheckNoError(t, ec.Put("a", first))
+	testutil.CheckNoError(t, ec.Put("a", replaced))
+	testutil.CheckNoError(t, ec.Put("b", other))
+
+	got, err := ec.Get("a")
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, digest(replaced), digest(got))
+	_, err = ec.Get("b")
+	testutil.CheckDeepEqual(t, true, IsExpired(err))
+	_, err = ec.Get("c")
+	testutil.CheckDeepEqual(t, true, IsNotFound(err))
+
+	// an entry per key is recorded in the index
+	p, err := layout.FromPath(path)
+	testutil.CheckNoError(t, err)
+	index, err := p.ImageIndex()
+	testutil.CheckNoError(t, err)
+	manifest, err := index.IndexManifest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(manifest.Manifests))
+
+	// layers not in the directory are retrieved from the fallback
+	fallback := newMemoryBackend(time.Hour)
+	testutil.CheckNoError(t, fallback.Put("c", first))
+	ec.Fallback = memoryLayerCache{fallback}
+	got, err = ec.RetrieveLayer("c")
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, digest(first), digest(got))
+}
+
+// memoryLayerCache retrieves layers from a memory backend.
+type memoryLayerCache struct {
+	*memoryBackend
+}
+
+func (m memoryLayerCache) RetrieveLayer(key string) (v1.Image, error) {
+	return m.Get(key)
+}
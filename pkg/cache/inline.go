@@ -0,0 +1,142 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/image/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// InlineCacheAnnotation is the manifest annotation of images built with --cache-inline.
+// It maps the cache keys of the layers of the image to the digests of the layers.
+const InlineCacheAnnotation = "com.github.osscontainertools.kaniko.cache.v0"
+
+// LayerCacheAnnotation is the manifest annotation of images built with --cache-layer-keys.
+// It maps the layer cache keys of the COPY and ADD layers of the image, which don't depend
+// on the instructions before them, to the digests of the layers.
+const LayerCacheAnnotation = "com.github.osscontainertools.kaniko.layers.v0"
+
+// InlineCache retrieves layers from the inline cache of the images of --cache-from,
+// layers not found there are retrieved from Fallback.
+type InlineCache struct {
+	Opts     *config.KanikoOptions
+	Fallback LayerCache
+
+	once   sync.Once
+	layers map[string]inlineLayer
+}
+
+type inlineLayer struct {
+	image  v1.Image
+	digest v1.Hash
+}
+
+// RetrieveLayer retrieves a layer from the inline cache given the cache key ck.
+// The cache TTL does not apply, the images to import the cache from are named explicitly.
+func (ic *InlineCache) RetrieveLayer(ck string) (v1.Image, error) {
+	img, err := ic.RetrieveImportedLayer(ck)
+	if IsNotFound(err) && ic.Fallback != nil {
+		return ic.Fallback.RetrieveLayer(ck)
+	}
+	return img, err
+}
+
+// RetrieveImportedLayer retrieves a layer from the images of --cache-from only,
+// without looking it up in Fallback.
+func (ic *InlineCache) RetrieveImportedLayer(ck string) (v1.Image, error) {
+	ic.once.Do(ic.load)
+	l, ok := ic.layers[ck]
+	if !ok {
+		return nil, NotFoundErr{msg: fmt.Sprintf("no inline cache for key %s", ck)}
+	}
+	layer, err := l.image.LayerByDigest(l.digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving inline cached layer %s", l.digest)
+	}
+	logrus.Infof("Found inline cached layer %s", l.digest)
+	return mutate.AppendLayers(empty.Image, layer)
+}
+
+func (ic *InlineCache) load() {
+	ic.layers = map[string]inlineLayer{}
+	for _, ref := range ic.Opts.CacheFrom {
+		var img v1.Image
+		var err error
+		if strings.HasPrefix(ref, "oci:") {
+			img, err = locateImage(strings.TrimPrefix(ref, "oci:"))
+		} else {
+			img, err = remote.RetrieveRemoteImage(ref, ic.Opts.RegistryOptions, ic.Opts.CustomPlatform)
+		}
+		if err != nil {
+			logrus.Warnf("Not importing cache from %s: %v", ref, err)
+			continue
+		}
+		keys, err := InlineCacheKeys(img)
+		layerKeys, layerErr := LayerCacheKeys(img)
+		if err != nil && layerErr != nil {
+			logrus.Warnf("Not importing cache from %s: %v", ref, err)
+			continue
+		}
+		logrus.Infof("Importing %d cached layers from %s", len(keys)+len(layerKeys), ref)
+		for _, keys := range []map[string]v1.Hash{keys, layerKeys} {
+			for ck, digest := range keys {
+				// earlier images take precedence
+				if _, ok := ic.layers[ck]; !ok {
+					ic.layers[ck] = inlineLayer{image: img, digest: digest}
+				}
+			}
+		}
+	}
+}
+
+// InlineCacheKeys returns the digests of the layers of img by their cache key,
+// read from the inline cache annotation.
+func InlineCacheKeys(img v1.Image) (map[string]v1.Hash, error) {
+	return annotatedKeys(img, InlineCacheAnnotation, "inline cache")
+}
+
+// LayerCacheKeys returns the digests of the layers of img by their layer cache key,
+// read from the layer cache annotation.
+func LayerCacheKeys(img v1.Image) (map[string]v1.Hash, error) {
+	return annotatedKeys(img, LayerCacheAnnotation, "layer cache")
+}
+
+func annotatedKeys(img v1.Image, annotation, kind string) (map[string]v1.Hash, error) {
+	mfst, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := mfst.Annotations[annotation]
+	if !ok {
+		return nil, errors.Errorf("image has no %s", kind)
+	}
+	var keys map[string]v1.Hash
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", kind)
+	}
+	return keys, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	manifestSuffix = ".json"
+	// prefixes of the temporary files the warmer writes before renaming them to their key
+	warmingImagePrefix    = "warmingImage."
+	warmingManifestPrefix = "warmingManifest."
+)
+
+// CacheEntry describes an image stored in a local cache directory.
+type CacheEntry struct {
+	// Key is the name of the entry in the cache directory, ie. the digest of a warmed image.
+	Key string
+	// Size is the size of the image tarball on disk.
+	Size int64
+	// ModTime is the time the entry was written, it is compared against the cache TTL.
+	ModTime time.Time
+	// Created is the creation time recorded in the image config.
+	Created time.Time
+	// CreatedBy lists the commands that produced the layers of the image, as far as they are
+	// recorded in the image history.
+	CreatedBy []string
+	// HasManifest is true if a manifest was stored next to the tarball.
+	HasManifest bool
+	// Layers is only populated by Inspect.
+	Layers []CacheLayer
+}
+
+// CacheLayer describes a single layer of a cached image.
+type CacheLayer struct {
+	Digest v1.Hash
+	Size   int64
+}
+
+// List returns the entries of the local cache in dir, sorted by key.
+func List(dir string) ([]CacheEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading cache dir %s", dir)
+	}
+	entries := []CacheEntry{}
+	for _, f := range files {
+		if !isCacheEntry(f) {
+			continue
+		}
+		entry, _, err := readEntry(dir, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// Inspect returns the entry stored under key in the local cache in dir,
+// including the layers of the image.
+func Inspect(dir, key string) (*CacheEntry, error) {
+	entry, img, err := readEntry(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting layers of %s", key)
+	}
+	for _, l := range layers {
+		digest, err := l.Digest()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting layer digest of %s", key)
+		}
+		size, err := l.Size()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting layer size of %s", key)
+		}
+		entry.Layers = append(entry.Layers, CacheLayer{Digest: digest, Size: size})
+	}
+	return entry, nil
+}
+
+// isCacheEntry filters out the manifests stored next to the images
+// and files left behind by an interrupted warmer.
+func isCacheEntry(f os.DirEntry) bool {
+	if !f.Type().IsRegular() {
+		return false
+	}
+	name := f.Name()
+	return !strings.HasSuffix(name, manifestSuffix) &&
+		!strings.HasPrefix(name, warmingImagePrefix) &&
+		!strings.HasPrefix(name, warmingManifestPrefix)
+}
+
+func readEntry(dir, key string) (*CacheEntry, v1.Image, error) {
+	p := filepath.Join(dir, key)
+	fi, err := os.Stat(p)
+	if err != nil {
+		msg := fmt.Sprintf("No file found for cache key %v %v", key, err)
+		return nil, nil, NotFoundErr{msg: msg}
+	}
+	entry := &CacheEntry{
+		Key:     key,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	if _, err := os.Stat(p + manifestSuffix); err == nil {
+		entry.HasManifest = true
+	}
+
+	img, err := cachedImageFromPath(p)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading cache entry %s", key)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading config of cache entry %s", key)
+	}
+	entry.Created = cfg.Created.Time
+	for _, h := range cfg.History {
+		if h.CreatedBy != "" {
+			entry.CreatedBy = append(entry.CreatedBy, h.CreatedBy)
+		}
+	}
+	return entry, img, nil
+}
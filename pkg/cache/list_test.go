@@ -0,0 +1,158 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+// writeCacheEntry writes img to dir the way the warmer does, a tarball named
+// by the image digest and optionally its manifest next to it.
+func writeCacheEntry(t *testing.T, dir string, img v1.Image, withManifest bool) string {
+	t.Helper()
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, digest.String())
+	if err := tarball.WriteToFile(p, nil, img); err != nil {
+		t.Fatal(err)
+	}
+	if withManifest {
+		mfst, err := img.RawManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p+manifestSuffix, mfst, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return digest.String()
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	img1, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img1.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Created = v1.Time{Time: created}
+	cfg.History = []v1.History{
+		{CreatedBy: "RUN apt-get update"},
+		{CreatedBy: "COPY . /app"},
+	}
+	img1, err = mutate.ConfigFile(img1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img2, err := random.Image(512, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key1 := writeCacheEntry(t, dir, img1, true)
+	key2 := writeCacheEntry(t, dir, img2, false)
+	// leftovers of an interrupted warmer are not entries
+	if err := os.WriteFile(filepath.Join(dir, warmingImagePrefix+"123"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List(dir)
+	testutil.CheckNoError(t, err)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	byKey := map[string]CacheEntry{}
+	for _, e := range entries {
+		byKey[e.Key] = e
+	}
+
+	e1, ok := byKey[key1]
+	if !ok {
+		t.Fatalf("expected entry for %s", key1)
+	}
+	testutil.CheckDeepEqual(t, true, e1.HasManifest)
+	testutil.CheckDeepEqual(t, created, e1.Created.UTC())
+	testutil.CheckDeepEqual(t, []string{"RUN apt-get update", "COPY . /app"}, e1.CreatedBy)
+	fi, err := os.Stat(filepath.Join(dir, key1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, fi.Size(), e1.Size)
+	testutil.CheckDeepEqual(t, 0, len(e1.Layers))
+
+	e2, ok := byKey[key2]
+	if !ok {
+		t.Fatalf("expected entry for %s", key2)
+	}
+	testutil.CheckDeepEqual(t, false, e2.HasManifest)
+}
+
+func TestList_missing_dir(t *testing.T) {
+	_, err := List(filepath.Join(t.TempDir(), "missing"))
+	testutil.CheckError(t, true, err)
+}
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := writeCacheEntry(t, dir, img, true)
+
+	entry, err := Inspect(dir, key)
+	testutil.CheckNoError(t, err)
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CacheLayer{}
+	for _, l := range layers {
+		digest, err := l.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := l.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, CacheLayer{Digest: digest, Size: size})
+	}
+	testutil.CheckDeepEqual(t, expected, entry.Layers)
+
+	_, err = Inspect(dir, "sha256:missing")
+	if !IsNotFound(err) {
+		t.Errorf("expected a NotFoundErr, got %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"compress/gzip"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// apkCacheTarget is the target of the cache mount of apk, ie.
+	// RUN --mount=type=cache,target=/var/cache/apk
+	apkCacheTarget = "/var/cache/apk"
+	// aptListsTarget is the target of the cache mount of the apt lists, ie.
+	// RUN --mount=type=cache,target=/var/lib/apt/lists
+	aptListsTarget = "/var/lib/apt/lists"
+)
+
+// The mirrors the package indexes are fetched from, they are the
+// ones the alpine and debian images are configured with.
+var (
+	alpineMirror = "https://dl-cdn.alpinelinux.org/alpine"
+	debianMirror = "http://deb.debian.org"
+)
+
+var (
+	alpineVersion = regexp.MustCompile(`^(\d+\.\d+)`)
+	debianVersion = regexp.MustCompile(`^(\d+)`)
+)
+
+// debianCodenames are the codenames of the debian releases, whose suites
+// are used in the apt sources of the debian images.
+var debianCodenames = map[string]string{
+	"10": "buster",
+	"11": "bullseye",
+	"12": "bookworm",
+	"13": "trixie",
+}
+
+var alpineArchs = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"386":     "x86",
+	"arm/v6":  "armhf",
+	"arm/v7":  "armv7",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+var debianArchs = map[string]string{
+	"amd64":   "amd64",
+	"arm64":   "arm64",
+	"386":     "i386",
+	"arm/v5":  "armel",
+	"arm/v7":  "armhf",
+	"ppc64le": "ppc64el",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// packageIndex is an index file of a package manager, stored in the
+// directory backing the cache mount of target under the name the package
+// manager gives it.
+type packageIndex struct {
+	url    string
+	target string
+	name   string
+	gunzip bool
+}
+
+// PrimePackageCaches fetches the package indexes of the images of a
+// recognized base family, alpine or debian, and stores them in the
+// directories backing the cache mounts of apk and apt in PackageCachesDir,
+// see --prime-package-caches. Images of other families are skipped.
+func PrimePackageCaches(images []string, opts *config.WarmerOptions) error {
+	dir := PackageCachesDir(opts.CacheDir)
+	errs := 0
+	for _, img := range images {
+		indexes, err := packageIndexes(img, opts.CustomPlatform)
+		if err != nil {
+			logrus.Warnf("Error while trying to prime package caches of image: %v %v", img, err)
+			errs++
+			continue
+		}
+		if len(indexes) == 0 {
+			logrus.Debugf("No package caches to prime for image %s", img)
+			continue
+		}
+		for _, index := range indexes {
+			if err := fetchPackageIndex(index, dir); err != nil {
+				logrus.Warnf("Error while trying to prime package caches of image: %v %v", img, err)
+				errs++
+				break
+			}
+		}
+	}
+	if errs > 0 {
+		return errors.Errorf("failed to prime the package caches of %d image(s)", errs)
+	}
+	return nil
+}
+
+// packageIndexes returns the package indexes of image, or none if it isn't
+// of a recognized base family.
+func packageIndexes(image, platform string) ([]packageIndex, error) {
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to verify image name: %s", image)
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		// the version of an image referenced by digest is unknown
+		return nil, nil
+	}
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	arch := p.Architecture
+	if p.Variant != "" {
+		arch += "/" + p.Variant
+	}
+	switch path.Base(ref.Context().RepositoryStr()) {
+	case "alpine":
+		return apkIndexes(tag.TagStr(), arch)
+	case "debian":
+		return aptIndexes(tag.TagStr(), arch)
+	}
+	return nil, nil
+}
+
+// apkIndexes returns the APKINDEX of the main and community repositories of
+// the alpine release of tag, named like apk caches them.
+func apkIndexes(tag, arch string) ([]packageIndex, error) {
+	release := "latest-stable"
+	if m := alpineVersion.FindStringSubmatch(tag); m != nil {
+		release = "v" + m[1]
+	} else if strings.HasPrefix(tag, "edge") {
+		release = "edge"
+	} else if tag != "latest" {
+		return nil, nil
+	}
+	apkArch, ok := alpineArchs[arch]
+	if !ok {
+		return nil, errors.Errorf("unsupported architecture %s for apk", arch)
+	}
+	var indexes []packageIndex
+	for _, repo := range []string{"main", "community"} {
+		repoURL := fmt.Sprintf("%s/%s/%s", alpineMirror, release, repo)
+		// apk names the cached index after the checksum of the repository
+		h := sha1.Sum([]byte(repoURL)) //nolint:gosec
+		indexes = append(indexes, packageIndex{
+			url:    fmt.Sprintf("%s/%s/APKINDEX.tar.gz", repoURL, apkArch),
+			target: apkCacheTarget,
+			name:   fmt.Sprintf("APKINDEX.%s.tar.gz", hex.EncodeToString(h[:4])),
+		})
+	}
+	return indexes, nil
+}
+
+// aptIndexes returns the release and the main packages files of the suites of
+// the debian release of tag, named like apt stores them in its lists.
+func aptIndexes(tag, arch string) ([]packageIndex, error) {
+	codename, _, _ := strings.Cut(tag, "-")
+	if m := debianVersion.FindStringSubmatch(codename); m != nil {
+		codename = debianCodenames[m[1]]
+	}
+	if !isDebianCodename(codename) {
+		return nil, nil
+	}
+	debArch, ok := debianArchs[arch]
+	if !ok {
+		return nil, errors.Errorf("unsupported architecture %s for apt", arch)
+	}
+	suites := [][2]string{{"debian", codename}}
+	if codename != "sid" {
+		suites = append(suites, [2]string{"debian", codename + "-updates"}, [2]string{"debian-security", codename + "-security"})
+	}
+	var indexes []packageIndex
+	for _, s := range suites {
+		dists := fmt.Sprintf("%s/%s/dists/%s", debianMirror, s[0], s[1])
+		indexes = append(indexes,
+			packageIndex{
+				url:    dists + "/InRelease",
+				target: aptListsTarget,
+				name:   aptListName(dists + "/InRelease"),
+			},
+			packageIndex{
+				url:    fmt.Sprintf("%s/main/binary-%s/Packages.gz", dists, debArch),
+				target: aptListsTarget,
+				name:   aptListName(fmt.Sprintf("%s/main/binary-%s/Packages", dists, debArch)),
+				gunzip: true,
+			},
+		)
+	}
+	return indexes, nil
+}
+
+func isDebianCodename(codename string) bool {
+	if codename == "sid" {
+		return true
+	}
+	for _, c := range debianCodenames {
+		if c == codename {
+			return true
+		}
+	}
+	return false
+}
+
+// aptListName returns the name apt gives the list downloaded from url, which
+// is the url without its scheme and with slashes replaced by underscores.
+func aptListName(url string) string {
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		url = rest
+	}
+	return strings.ReplaceAll(url, "/", "_")
+}
+
+// PackageCachesDir is the directory in the cache directory the warmer stores the
+// package indexes in, laid out like the cache mounts in /kaniko/caches.
+func PackageCachesDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "caches")
+}
+
+// Download the index in a temporary file then move it to its final destination
+func fetchPackageIndex(index packageIndex, cachesDir string) error {
+	dir := config.CacheMountDirIn(cachesDir, index.target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	response, err := http.Get(index.url) //nolint:noctx
+	if err != nil {
+		return errors.Wrapf(err, "fetching %s", index.url)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.Errorf("fetching %s: %s", index.url, response.Status)
+	}
+	var body io.Reader = response.Body
+	if index.gunzip {
+		gz, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return errors.Wrapf(err, "decompressing %s", index.url)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	f, err := os.CreateTemp(dir, "warming-index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "fetching %s", index.url)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, index.name)
+	if err := os.Rename(f.Name(), dest); err != nil {
+		return err
+	}
+	logrus.Debugf("Wrote %s to %s", index.url, dest)
+	return nil
+}
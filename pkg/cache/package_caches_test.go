@@ -0,0 +1,150 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"compress/gzip"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func fakePackageMirror(t *testing.T) (*httptest.Server, *[]string) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/Packages.gz") {
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte("packages of " + r.URL.Path))
+			gz.Close()
+			return
+		}
+		w.Write([]byte("index " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+
+	origAlpine, origDebian := alpineMirror, debianMirror
+	t.Cleanup(func() {
+		alpineMirror, debianMirror = origAlpine, origDebian
+	})
+	alpineMirror = server.URL + "/alpine"
+	debianMirror = server.URL
+	return server, &requested
+}
+
+func readCacheMount(t *testing.T, cacheDir string, target string) map[string]string {
+	files := map[string]string{}
+	dir := config.CacheMountDirIn(PackageCachesDir(cacheDir), target)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(content)
+	}
+	return files
+}
+
+func Test_PrimePackageCaches_apk(t *testing.T) {
+	server, requested := fakePackageMirror(t)
+
+	opts := &config.WarmerOptions{CacheOptions: config.CacheOptions{CacheDir: t.TempDir()}, CustomPlatform: "linux/arm64"}
+	if err := PrimePackageCaches([]string{"alpine:3.19.1"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CheckDeepEqual(t, []string{
+		"/alpine/v3.19/main/aarch64/APKINDEX.tar.gz",
+		"/alpine/v3.19/community/aarch64/APKINDEX.tar.gz",
+	}, *requested)
+	// apk names the cached indexes after the first bytes of the sha1 of the repository
+	files := readCacheMount(t, opts.CacheDir, "/var/cache/apk")
+	want := map[string]string{}
+	for _, repo := range []string{"main", "community"} {
+		h := sha1.Sum([]byte(server.URL + "/alpine/v3.19/" + repo)) //nolint:gosec
+		want["APKINDEX."+hex.EncodeToString(h[:4])+".tar.gz"] = "index /alpine/v3.19/" + repo + "/aarch64/APKINDEX.tar.gz"
+	}
+	testutil.CheckDeepEqual(t, want, files)
+}
+
+func Test_PrimePackageCaches_apt(t *testing.T) {
+	server, requested := fakePackageMirror(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	opts := &config.WarmerOptions{CacheOptions: config.CacheOptions{CacheDir: t.TempDir()}, CustomPlatform: "linux/amd64"}
+	if err := PrimePackageCaches([]string{"debian:12-slim"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CheckDeepEqual(t, 6, len(*requested))
+	files := readCacheMount(t, opts.CacheDir, "/var/lib/apt/lists")
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	testutil.CheckDeepEqual(t, []string{
+		host + "_debian-security_dists_bookworm-security_InRelease",
+		host + "_debian-security_dists_bookworm-security_main_binary-amd64_Packages",
+		host + "_debian_dists_bookworm-updates_InRelease",
+		host + "_debian_dists_bookworm-updates_main_binary-amd64_Packages",
+		host + "_debian_dists_bookworm_InRelease",
+		host + "_debian_dists_bookworm_main_binary-amd64_Packages",
+	}, names)
+	testutil.CheckDeepEqual(t, "index /debian/dists/bookworm/InRelease", files[host+"_debian_dists_bookworm_InRelease"])
+	// the packages files are stored decompressed
+	testutil.CheckDeepEqual(t, "packages of /debian/dists/bookworm/main/binary-amd64/Packages.gz", files[host+"_debian_dists_bookworm_main_binary-amd64_Packages"])
+}
+
+func Test_PrimePackageCaches_skips_unrecognized_images(t *testing.T) {
+	_, requested := fakePackageMirror(t)
+
+	opts := &config.WarmerOptions{CacheOptions: config.CacheOptions{CacheDir: t.TempDir()}, CustomPlatform: "linux/amd64"}
+	if err := PrimePackageCaches([]string{"busybox:latest", "debian:unstable-20240101", "alpine@sha256:" + strings.Repeat("a", 64)}, opts); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, 0, len(*requested))
+}
+
+func Test_PrimePackageCaches_fails_on_missing_index(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	origAlpine := alpineMirror
+	defer func() { alpineMirror = origAlpine }()
+	alpineMirror = server.URL
+
+	opts := &config.WarmerOptions{CacheOptions: config.CacheOptions{CacheDir: t.TempDir()}, CustomPlatform: "linux/amd64"}
+	err := PrimePackageCaches([]string{"alpine:3.20"}, opts)
+	testutil.CheckError(t, true, err)
+	entries, _ := os.ReadDir(config.CacheMountDirIn(PackageCachesDir(opts.CacheDir), "/var/cache/apk"))
+	if len(entries) != 0 {
+		t.Errorf("expected no files to be left in the cache mount, got %v", entries)
+	}
+}
@@ -21,8 +21,8 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path"
 	"regexp"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -30,7 +30,6 @@ import (
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/image/remote"
-	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -55,9 +54,25 @@ func WarmCache(opts *config.WarmerOptions) error {
 	logrus.Debugf("%s\n", cacheDir)
 	logrus.Debugf("%s\n", images)
 
+	backendName := opts.CacheBackend
+	if backendName == "" {
+		backendName = DirBackend
+	}
+	backend, err := NewBackend(backendName, &opts.CacheOptions)
+	if err != nil {
+		return err
+	}
+	if opts.PruneCache {
+		pruned, err := backend.Prune()
+		if err != nil {
+			return errors.Wrap(err, "Failed to prune the cache")
+		}
+		logrus.Infof("Pruned %d expired images from the cache", len(pruned))
+	}
+
 	errs := 0
 	for _, img := range images {
-		err := warmToFile(cacheDir, img, opts)
+		err := warmToBackend(backend, img, opts)
 		if err != nil {
 			logrus.Warnf("Error while trying to warm image: %v %v", img, err)
 			errs++
@@ -68,56 +83,31 @@ func WarmCache(opts *config.WarmerOptions) error {
 		return errors.New("Failed to warm any of the given images")
 	}
 
-	return nil
-}
-
-// Download image in temporary files then move files to final destination
-func warmToFile(cacheDir, img string, opts *config.WarmerOptions) error {
-	f, err := os.CreateTemp(cacheDir, "warmingImage.*")
-	if err != nil {
-		return err
+	if opts.PrimePackageCaches {
+		if err := PrimePackageCaches(images, opts); err != nil {
+			return errors.Wrap(err, "Failed to prime package caches")
+		}
 	}
-	// defer called in reverse order
-	defer os.Remove(f.Name())
-	defer f.Close()
 
-	mtfsFile, err := os.CreateTemp(cacheDir, "warmingManifest.*")
-	if err != nil {
-		return err
-	}
-	defer os.Remove(mtfsFile.Name())
-	defer mtfsFile.Close()
+	return nil
+}
 
+// warmToBackend stores img in backend under its digest
+func warmToBackend(backend Backend, img string, opts *config.WarmerOptions) error {
 	cw := &Warmer{
-		Remote:         remote.RetrieveRemoteImage,
-		Local:          LocalSource,
-		TarWriter:      f,
-		ManifestWriter: mtfsFile,
+		Remote:  remote.RetrieveRemoteImage,
+		Backend: backend,
 	}
 
-	digest, err := cw.Warm(img, opts)
+	_, err := cw.Warm(img, opts)
 	if err != nil {
 		if IsAlreadyCached(err) {
 			logrus.Infof("Image already in cache: %v", img)
 			return nil
 		}
-		logrus.Warnf("Error while trying to warm image: %v %v", img, err)
-		return err
-	}
-
-	finalCachePath := path.Join(cacheDir, digest.String())
-	finalMfstPath := finalCachePath + ".json"
-
-	err = os.Rename(f.Name(), finalCachePath)
-	if err != nil {
 		return err
 	}
 
-	err = os.Rename(mtfsFile.Name(), finalMfstPath)
-	if err != nil {
-		return errors.Wrap(err, "Failed to rename manifest file")
-	}
-
 	logrus.Debugf("Wrote %s to cache", img)
 	return nil
 }
@@ -130,23 +120,38 @@ type FetchRemoteImage func(image string, opts config.RegistryOptions, customPlat
 // FetchLocalSource retrieves a Docker image manifest from a local source.
 // github.com/GoogleContainerTools/kaniko/cache.LocalSource can be used as
 // this type.
+//
+// Deprecated: set Warmer.Backend instead.
 type FetchLocalSource func(*config.CacheOptions, string) (v1.Image, error)
 
-// Warmer is used to prepopulate the cache with a Docker image
+// Warmer is used to prepopulate the cache with a Docker image, which it stores in
+// Backend under its digest.
 type Warmer struct {
-	Remote         FetchRemoteImage
-	Local          FetchLocalSource
+	Remote  FetchRemoteImage
+	Backend Backend
+	// Local is asked whether the image is cached if Backend is not set,
+	// LocalSource if it is nil.
+	//
+	// Deprecated: set Backend instead.
+	Local FetchLocalSource
+	// TarWriter and ManifestWriter are written the image and its manifest if
+	// Backend is not set.
+	//
+	// Deprecated: set Backend instead.
 	TarWriter      io.Writer
 	ManifestWriter io.Writer
 }
 
-// Warm retrieves a Docker image and populates the supplied buffer with the image content and manifest
+// Warm retrieves a Docker image and populates the cache with the image content and manifest
 // or returns an AlreadyCachedErr if the image is present in the cache.
 func (w *Warmer) Warm(image string, opts *config.WarmerOptions) (v1.Hash, error) {
 	cacheRef, err := name.ParseReference(image, name.WeakValidation)
 	if err != nil {
 		return v1.Hash{}, errors.Wrapf(err, "Failed to verify image name: %s", image)
 	}
+	if w.Backend == nil && (w.TarWriter == nil || w.ManifestWriter == nil) {
+		return v1.Hash{}, errors.New("the warmer has no cache backend")
+	}
 
 	img, err := w.Remote(image, opts.RegistryOptions, opts.CustomPlatform)
 	if err != nil || img == nil {
@@ -159,12 +164,22 @@ func (w *Warmer) Warm(image string, opts *config.WarmerOptions) (v1.Hash, error)
 	}
 
 	if !opts.Force {
-		_, err := w.Local(&opts.CacheOptions, digest.String())
-		if err == nil || IsExpired(err) {
+		cached, err := w.cached(digest, opts)
+		if err != nil {
+			return v1.Hash{}, errors.Wrapf(err, "Failed to look up %s in the cache", image)
+		}
+		if cached {
 			return v1.Hash{}, AlreadyCachedErr{}
 		}
 	}
 
+	if w.Backend != nil {
+		if err := w.Backend.Put(digest.String(), img); err != nil {
+			return v1.Hash{}, errors.Wrapf(err, "Failed to store %s in the cache", image)
+		}
+		return digest, nil
+	}
+
 	err = tarball.Write(cacheRef, img, w.TarWriter)
 	if err != nil {
 		return v1.Hash{}, errors.Wrapf(err, "Failed to write %s to tar buffer", image)
@@ -182,6 +197,20 @@ func (w *Warmer) Warm(image string, opts *config.WarmerOptions) (v1.Hash, error)
 	return digest, nil
 }
 
+// cached returns true if the image with digest is in the cache, expired or not,
+// as they are replaced once pruned.
+func (w *Warmer) cached(digest v1.Hash, opts *config.WarmerOptions) (bool, error) {
+	if w.Backend != nil {
+		return w.Backend.Has(digest.String())
+	}
+	local := w.Local
+	if local == nil {
+		local = LocalSource
+	}
+	_, err := local(&opts.CacheOptions, digest.String())
+	return err == nil || IsExpired(err), nil
+}
+
 func ParseDockerfile(opts *config.WarmerOptions) ([]string, error) {
 	var err error
 	var d []uint8
@@ -206,32 +235,38 @@ func ParseDockerfile(opts *config.WarmerOptions) ([]string, error) {
 		return nil, errors.Wrap(err, "parsing dockerfile")
 	}
 
-	args := opts.BuildArgs
-	for _, marg := range metaArgs {
-		for _, arg := range marg.Args {
-			args = append(args, fmt.Sprintf("%s=%s", arg.Key, arg.ValueString()))
-		}
+	unresolved := make([]string, len(stages))
+	for i, s := range stages {
+		unresolved[i] = s.BaseName
+	}
+	// like for the build, only ARGs declared before the first FROM and their
+	// defaults are substituted into FROM, ARGs of stages are not
+	if err := dockerfile.ResolveBaseNames(stages, metaArgs, opts.BuildArgs); err != nil {
+		return nil, errors.Wrap(err, "resolving args")
 	}
 outer:
 	for i, s := range stages {
-		resolvedBaseName, err := util.ResolveEnvironmentReplacement(s.BaseName, args, false)
-		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprintf("resolving base name %s", s.BaseName))
-		}
 		// skip stage references ie.
 		// FROM base AS target
+		// stage names are matched case-insensitively, like for the build
 		for j := range i {
-			if stages[j].Name == resolvedBaseName {
+			if stages[j].Name != "" && strings.EqualFold(stages[j].Name, s.BaseName) {
 				continue outer
 			}
 		}
+		if _, err := name.ParseReference(s.BaseName, name.WeakValidation); err != nil {
+			if strings.Contains(unresolved[i], "$") {
+				return nil, fmt.Errorf("base image %q of stage %d resolves to %q, the ARGs it uses must be declared before the first FROM or passed with --build-arg", unresolved[i], i, s.BaseName)
+			}
+			return nil, errors.Wrapf(err, "parsing base image %q of stage %d", s.BaseName, i)
+		}
 		// deduplicate
 		for _, x := range baseNames {
-			if x == resolvedBaseName {
+			if x == s.BaseName {
 				continue outer
 			}
 		}
-		baseNames = append(baseNames, resolvedBaseName)
+		baseNames = append(baseNames, s.BaseName)
 	}
 	return baseNames, nil
 }
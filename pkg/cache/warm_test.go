@@ -19,11 +19,14 @@ package cache
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/fakes"
+	"github.com/osscontainertools/kaniko/testutil"
 )
 
 const (
@@ -31,18 +34,12 @@ const (
 )
 
 func Test_Warmer_Warm_not_in_cache(t *testing.T) {
-	tarBuf := new(bytes.Buffer)
-	manifestBuf := new(bytes.Buffer)
-
+	backend := newMemoryBackend(time.Hour)
 	cw := &Warmer{
 		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
-			return fakes.FakeImage{}, nil
+			return fakes.FakeImage{Hash: v1.Hash{Algorithm: "sha256", Hex: "abc"}}, nil
 		},
-		Local: func(_ *config.CacheOptions, _ string) (v1.Image, error) {
-			return nil, NotFoundErr{}
-		},
-		TarWriter:      tarBuf,
-		ManifestWriter: manifestBuf,
+		Backend: backend,
 	}
 
 	opts := &config.WarmerOptions{}
@@ -53,24 +50,19 @@ func Test_Warmer_Warm_not_in_cache(t *testing.T) {
 		t.FailNow()
 	}
 
-	if len(tarBuf.Bytes()) == 0 {
-		t.Error("expected image to be written but buffer was empty")
+	if backend.puts != 1 {
+		t.Error("expected image to be stored but the backend is empty")
 	}
 }
 
 func Test_Warmer_Warm_in_cache_not_expired(t *testing.T) {
-	tarBuf := new(bytes.Buffer)
-	manifestBuf := new(bytes.Buffer)
-
+	backend := newMemoryBackend(time.Hour)
+	backend.entries["sha256:abc"] = memoryEntry{image: fakes.FakeImage{}, stored: time.Now()}
 	cw := &Warmer{
 		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
-			return fakes.FakeImage{}, nil
-		},
-		Local: func(_ *config.CacheOptions, _ string) (v1.Image, error) {
-			return fakes.FakeImage{}, nil
+			return fakes.FakeImage{Hash: v1.Hash{Algorithm: "sha256", Hex: "abc"}}, nil
 		},
-		TarWriter:      tarBuf,
-		ManifestWriter: manifestBuf,
+		Backend: backend,
 	}
 
 	opts := &config.WarmerOptions{}
@@ -81,24 +73,19 @@ func Test_Warmer_Warm_in_cache_not_expired(t *testing.T) {
 		t.FailNow()
 	}
 
-	if len(tarBuf.Bytes()) != 0 {
-		t.Errorf("expected nothing to be written")
+	if backend.puts != 0 {
+		t.Errorf("expected nothing to be stored")
 	}
 }
 
 func Test_Warmer_Warm_in_cache_expired(t *testing.T) {
-	tarBuf := new(bytes.Buffer)
-	manifestBuf := new(bytes.Buffer)
-
+	backend := newMemoryBackend(time.Hour)
+	backend.entries["sha256:abc"] = memoryEntry{image: fakes.FakeImage{}, stored: time.Now().Add(-2 * time.Hour)}
 	cw := &Warmer{
 		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
-			return fakes.FakeImage{}, nil
-		},
-		Local: func(_ *config.CacheOptions, _ string) (v1.Image, error) {
-			return fakes.FakeImage{}, ExpiredErr{}
+			return fakes.FakeImage{Hash: v1.Hash{Algorithm: "sha256", Hex: "abc"}}, nil
 		},
-		TarWriter:      tarBuf,
-		ManifestWriter: manifestBuf,
+		Backend: backend,
 	}
 
 	opts := &config.WarmerOptions{}
@@ -109,9 +96,53 @@ func Test_Warmer_Warm_in_cache_expired(t *testing.T) {
 		t.FailNow()
 	}
 
-	if len(tarBuf.Bytes()) != 0 {
-		t.Errorf("expected nothing to be written")
+	if backend.puts != 0 {
+		t.Errorf("expected nothing to be stored")
+	}
+}
+
+func Test_Warmer_Warm_writers(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		local       FetchLocalSource
+		written     bool
+	}{
+		{description: "not in cache", local: func(_ *config.CacheOptions, _ string) (v1.Image, error) { return nil, NotFoundErr{} }, written: true},
+		{description: "in cache expired", local: func(_ *config.CacheOptions, _ string) (v1.Image, error) { return fakes.FakeImage{}, ExpiredErr{} }},
+		{description: "local source", written: true},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			tarBuf := new(bytes.Buffer)
+			manifestBuf := new(bytes.Buffer)
+			cw := &Warmer{
+				Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
+					return fakes.FakeImage{}, nil
+				},
+				Local:          tc.local,
+				TarWriter:      tarBuf,
+				ManifestWriter: manifestBuf,
+			}
+
+			// the local source looks in an empty cache directory
+			_, err := cw.Warm(image, &config.WarmerOptions{CacheOptions: config.CacheOptions{CacheDir: t.TempDir()}})
+			if tc.written {
+				testutil.CheckNoError(t, err)
+			} else {
+				testutil.CheckDeepEqual(t, true, IsAlreadyCached(err))
+			}
+			testutil.CheckDeepEqual(t, tc.written, tarBuf.Len() != 0)
+		})
+	}
+}
+
+func Test_Warmer_Warm_no_backend(t *testing.T) {
+	cw := &Warmer{
+		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
+			return fakes.FakeImage{}, nil
+		},
 	}
+	_, err := cw.Warm(image, &config.WarmerOptions{})
+	testutil.CheckError(t, true, err)
 }
 
 func TestParseDockerfile_SingleStageDockerfile(t *testing.T) {
@@ -211,6 +242,79 @@ FROM nginx:$NGINX_VERSION-alpine-slim
 	}
 }
 
+func TestParseDockerfile_BuildArgOverridesBase(t *testing.T) {
+	dockerfile := `ARG BASE=alpine:3.19
+FROM $BASE
+`
+	tmpfile, err := os.CreateTemp("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(dockerfile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.WarmerOptions{DockerfilePath: tmpfile.Name(), BuildArgs: []string{"BASE=busybox:1.36"}}
+	baseNames, err := ParseDockerfile(opts)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(baseNames) != 1 {
+		t.Fatalf("expected 1 base name, got %d", len(baseNames))
+	}
+	if baseNames[0] != "busybox:1.36" {
+		t.Fatalf("expected 'busybox:1.36', got '%s'", baseNames[0])
+	}
+}
+
+func TestParseDockerfile_ArgDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		buildArgs  []string
+		baseNames  []string
+		wantErr    bool
+	}{
+		{
+			name:       "default is the only source",
+			dockerfile: "ARG REGISTRY=docker.io\nARG BASE=$REGISTRY/library/alpine\nARG TAG\nFROM ${BASE}:${TAG:-3.19} AS build\nFROM $BASE:3.20\n",
+			buildArgs:  []string{"UNRELATED=1"},
+			baseNames:  []string{"docker.io/library/alpine:3.19", "docker.io/library/alpine:3.20"},
+		},
+		{
+			name:       "redeclared without default",
+			dockerfile: "ARG BASE=alpine:3.19\nARG BASE\nFROM $BASE\n",
+			baseNames:  []string{"alpine:3.19"},
+		},
+		{
+			name:       "stage referenced in another case",
+			dockerfile: "FROM alpine AS Build\nFROM Build\nFROM build\n",
+			baseNames:  []string{"alpine"},
+		},
+		{
+			name:       "declared after FROM",
+			dockerfile: "ARG TAG=3.19\nFROM alpine:$TAG\nARG BASE=busybox\nFROM $BASE\n",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "Dockerfile")
+			if err := os.WriteFile(path, []byte(tt.dockerfile), 0644); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.WarmerOptions{DockerfilePath: path, BuildArgs: tt.buildArgs}
+			baseNames, err := ParseDockerfile(opts)
+			testutil.CheckErrorAndDeepEqual(t, tt.wantErr, err, tt.baseNames, baseNames)
+		})
+	}
+}
+
 func TestParseDockerfile_MissingsDockerfile(t *testing.T) {
 	opts := &config.WarmerOptions{DockerfilePath: "dummy-nowhere"}
 	baseNames, err := ParseDockerfile(opts)
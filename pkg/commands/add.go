@@ -65,9 +65,24 @@ func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 	}
 
 	srcs, dest, err := util.ResolveEnvAndWildcards(a.cmd.SourcesAndDest, a.fileContext, replacementEnvs)
+	if err != nil {
+		return util.UserError(err)
+	}
+
+	// relative destinations are relative to the root without WORKDIR, like they are for COPY
+	cwd := config.WorkingDir
+	if cwd == "" {
+		cwd = kConfig.RootDir
+	}
+
+	// downloaded files are owned by --copy-owner like copied ones, the other sources
+	// are copied by a COPY that resolves it itself
+	ownerRules, err := resolveOwnerRules(a.fileContext.OwnerRules, kConfig.RootDir, replacementEnvs)
 	if err != nil {
 		return err
 	}
+	owners := a.fileContext
+	owners.OwnerRules = ownerRules
 
 	var unresolvedSrcs []string
 	// If any of the sources are local tar archives:
@@ -78,22 +93,34 @@ func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 	for _, src := range srcs {
 		fullPath := filepath.Join(a.fileContext.Root, src)
 		if util.IsSrcRemoteFileURL(src) {
-			urlDest, err := util.URLDestinationFilepath(src, dest, config.WorkingDir, replacementEnvs)
+			urlDest, err := util.URLDestinationFilepath(src, dest, cwd, replacementEnvs)
 			if err != nil {
 				return err
 			}
+			if err := checkMaxMode(a.cmd.String(), chmod, a.fileContext); err != nil {
+				return err
+			}
+			if err := util.CheckParentDirectories(urlDest); err != nil {
+				return err
+			}
 			logrus.Infof("Adding remote URL %s to %s", src, urlDest)
-			if err := util.DownloadFileToDest(src, urlDest, uid, gid, chmod); err != nil {
+			urlUID, urlGID := owners.OwnerFor(urlDest, uid, gid)
+			if err := util.DownloadFileToDest(src, urlDest, urlUID, urlGID, chmod); err != nil {
 				return errors.Wrap(err, "downloading remote source file")
 			}
 			a.snapshotFiles = append(a.snapshotFiles, urlDest)
 		} else if util.IsFileLocalTarArchive(fullPath) {
-			tarDest, err := util.DestinationFilepath("", dest, config.WorkingDir)
+			tarDest, err := util.DestinationFilepath("", dest, cwd)
 			if err != nil {
 				return errors.Wrap(err, "determining dest for tar")
 			}
 			logrus.Infof("Unpacking local tar archive %s to %s", src, tarDest)
-			extractedFiles, err := util.UnpackLocalTarArchive(fullPath, tarDest)
+			var extractedFiles []string
+			if a.fileContext.ArchiveCache != nil {
+				extractedFiles, err = a.fileContext.ArchiveCache.Unpack(fullPath, tarDest)
+			} else {
+				extractedFiles, err = util.UnpackLocalTarArchive(fullPath, tarDest)
+			}
 			if err != nil {
 				return errors.Wrap(err, "unpacking local tar")
 			}
@@ -103,6 +130,12 @@ func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 			unresolvedSrcs = append(unresolvedSrcs, src)
 		}
 	}
+	if a.fileContext.Fsync {
+		// the files copied below are synced by the copy command
+		if err := syncFiles(a.snapshotFiles); err != nil {
+			return errors.Wrap(err, "syncing added files")
+		}
+	}
 	// With the remaining "normal" sources, create and execute a standard copy command
 	heredocs := a.cmd.SourcesAndDest.SourceContents
 	if len(unresolvedSrcs) == 0 && len(heredocs) == 0 {
@@ -17,14 +17,20 @@ limitations under the License.
 package commands
 
 import (
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"sort"
+	"syscall"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	kConfig "github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
@@ -174,3 +180,108 @@ func Test_AddCommand(t *testing.T) {
 		})
 	}
 }
+
+func Test_AddCommand_sniffsArchives(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string][]byte{
+		"fake.tar": []byte("not a tar archive"),
+	}
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	if _, err := gzw.Write([]byte("compressed, but not a tar archive")); err != nil {
+		t.Fatal(err)
+	}
+	gzw.Close()
+	files["fake.tar.gz"] = gz.Bytes()
+	// a genuine archive is extracted regardless of its name
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	content := []byte("extracted")
+	if err := tw.WriteHeader(&tar.Header{Name: "extracted.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	files["archive"] = archive.Bytes()
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(tempDir, "dest")
+	c := AddCommand{
+		cmd: &instructions.AddCommand{
+			SourcesAndDest: instructions.SourcesAndDest{
+				SourcePaths: []string{"fake.tar", "fake.tar.gz", "archive"},
+				DestPath:    dest + "/",
+			},
+		},
+		fileContext: util.FileContext{Root: tempDir},
+	}
+	err := c.ExecuteCommand(&v1.Config{WorkingDir: tempDir}, dockerfile.NewBuildArgs([]string{}))
+	testutil.CheckNoError(t, err)
+
+	for _, name := range []string{"fake.tar", "fake.tar.gz"} {
+		b, err := os.ReadFile(filepath.Join(dest, name))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, files[name], b)
+	}
+	b, err := os.ReadFile(filepath.Join(dest, "extracted.txt"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, content, b)
+	if _, err := os.Stat(filepath.Join(dest, "archive")); !os.IsNotExist(err) {
+		t.Errorf("expected archive to be extracted and not copied, got %v", err)
+	}
+}
+
+func Test_AddCommand_remoteURLOwnerRules(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a download requires root")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("downloaded"))
+	}))
+	defer server.Close()
+	testDir := t.TempDir()
+	origRootDir := kConfig.RootDir
+	defer func() { kConfig.RootDir = origRootDir }()
+	kConfig.RootDir = testDir
+	// the names of the rules are resolved with the passwd of the image
+	if err := testutil.SetupFiles(testDir, map[string]string{
+		"etc/passwd": "www:x:2000:2000::/var/www:/bin/sh\n",
+		"etc/group":  "www:x:2000:\n",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := AddCommand{
+		cmd: &instructions.AddCommand{
+			SourcesAndDest: instructions.SourcesAndDest{
+				SourcePaths: []string{server.URL + "/app.txt", server.URL + "/data.txt"},
+				DestPath:    "dest/",
+			},
+			Chown: "1000:1000",
+		},
+		fileContext: util.FileContext{Root: testDir, OwnerRules: []util.OwnerRule{
+			{Pattern: "/dest/data.txt", Chown: "www:www"},
+		}},
+	}
+	testutil.CheckNoError(t, c.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{})))
+
+	for path, expected := range map[string]uint32{
+		"dest/app.txt":  1000,
+		"dest/data.txt": 2000,
+	} {
+		fi, err := os.Lstat(filepath.Join(testDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := fi.Sys().(*syscall.Stat_t)
+		if stat.Uid != expected || stat.Gid != expected {
+			t.Errorf("expected %s to be owned by %d:%d, got %d:%d", path, expected, expected, stat.Uid, stat.Gid)
+		}
+	}
+}
@@ -19,10 +19,10 @@ package commands
 import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 type CurrentCacheKey func() (string, error)
@@ -68,9 +68,9 @@ func GetCommand(cmd instructions.Command, fileContext util.FileContext, useNewRu
 	switch c := cmd.(type) {
 	case *instructions.RunCommand:
 		if useNewRun {
-			return &RunMarkerCommand{cmd: c, shdCache: cacheRun}, nil
+			return &RunMarkerCommand{cmd: c, fileContext: fileContext, shdCache: cacheRun}, nil
 		}
-		return &RunCommand{cmd: c, shdCache: cacheRun}, nil
+		return &RunCommand{cmd: c, fileContext: fileContext, shdCache: cacheRun}, nil
 	case *instructions.CopyCommand:
 		return &CopyCommand{cmd: c, fileContext: fileContext, shdCache: cacheCopy}, nil
 	case *instructions.ExposeCommand:
@@ -102,7 +102,7 @@ func GetCommand(cmd instructions.Command, fileContext util.FileContext, useNewRu
 	case *instructions.HealthCheckCommand:
 		return &HealthCheckCommand{cmd: c}, nil
 	case *instructions.MaintainerCommand:
-		logrus.Warnf("%s is deprecated, skipping", cmd.Name())
+		diagnostics.Warnf(diagnostics.DeprecatedInstruction, "%s is deprecated, skipping", cmd.Name())
 		return nil, nil
 	}
 	return nil, errors.Errorf("%s is not a supported command", cmd.Name())
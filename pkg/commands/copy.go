@@ -18,24 +18,30 @@ package commands
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	kConfig "github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/util"
 )
 
 // for testing
 var (
-	getUserGroup       = util.GetUserGroup
+	getUserGroup       = util.GetUserGroupIn
 	getActiveUserGroup = util.GetActiveUserGroup
+	syncFiles          = util.SyncFiles
 )
 
 type CopyCommand struct {
@@ -43,7 +49,9 @@ type CopyCommand struct {
 	cmd           *instructions.CopyCommand
 	fileContext   util.FileContext
 	snapshotFiles []string
-	shdCache      bool
+	// sources are the sources the files were copied from, by destination
+	sources  map[string]string
+	shdCache bool
 }
 
 func (c *CopyCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
@@ -51,96 +59,159 @@ func (c *CopyCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bu
 	uid, gid := int64(-1), int64(-1)
 	var err error
 	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	user := config.User
 	if c.cmd.From != "" {
-		c.fileContext = util.FileContext{Root: filepath.Join(kConfig.KanikoInterStageDepsDir, c.cmd.From)}
-		uid, gid, err = getUserGroup(c.cmd.Chown, replacementEnvs)
+		c.fileContext = fromFileContext(c.cmd.From, c.fileContext)
+		// names are those of the stage the files are copied from
+		uid, gid, err = getUserGroup(c.fileContext.Root, c.cmd.Chown, replacementEnvs)
 		if err != nil {
 			return errors.Wrap(err, "getting user group from chown")
 		}
 	} else {
-		user := config.User
-		if kConfig.EnvBool("FF_KANIKO_COPY_AS_ROOT") {
+		if c.fileContext.CopyAsRoot {
 			// According to spec: https://docs.docker.com/reference/dockerfile/#copy---chown---chmod
 			//   All files and directories copied from the build context
 			//   are created with a default UID and GID of 0.
 			// But this is a breaking change so we keep it optional for now
 			user = "0:0"
 		}
-		uid, gid, err = getActiveUserGroup(user, c.cmd.Chown, replacementEnvs)
-		if err != nil {
-			return errors.Wrap(err, "getting user group from chown")
+		// Without chown, uid and gid stay -1 to keep the ownership of the sources
+		if c.cmd.Chown != "" || !c.fileContext.PreserveOwnership {
+			uid, gid, err = getActiveUserGroup(user, c.cmd.Chown, replacementEnvs)
+			if err != nil {
+				return errors.Wrap(err, "getting user group from chown")
+			}
 		}
 	}
+	if err := c.resolveOwnerRules(replacementEnvs); err != nil {
+		return err
+	}
 
-	// sources from the Copy command are resolved with wildcards {*?[}
-	srcs, dest, err := util.ResolveEnvAndWildcards(c.cmd.SourcesAndDest, c.fileContext, replacementEnvs)
+	copies, dest, err := resolveCopies(c.cmd, c.fileContext, replacementEnvs)
 	if err != nil {
-		return errors.Wrap(err, "resolving src")
+		return err
 	}
 
 	chmod, useDefaultChmod, err := util.GetChmod(c.cmd.Chmod, replacementEnvs)
 	if err != nil {
 		return errors.Wrap(err, "getting permissions from chmod")
 	}
-
-	// For each source, iterate through and copy it over
-	for _, src := range srcs {
-		fullPath := filepath.Join(c.fileContext.Root, src)
-
-		fi, err := os.Lstat(fullPath)
-		if err != nil {
-			return errors.Wrap(err, "could not copy source")
-		}
-		if fi.IsDir() && !strings.HasSuffix(fullPath, string(os.PathSeparator)) {
-			fullPath += "/"
-		}
-		cwd := config.WorkingDir
-		if cwd == "" {
-			cwd = kConfig.RootDir
+	// without --chmod the sources keep their mode, heredocs get the default one
+	if !useDefaultChmod || len(c.cmd.SourcesAndDest.SourceContents) > 0 {
+		if err := checkMaxMode(c.cmd.String(), chmod, c.fileContext); err != nil {
+			return err
 		}
+	}
 
-		destPath, err := util.DestinationFilepath(fullPath, dest, cwd)
-		if err != nil {
-			return errors.Wrap(err, "find destination path")
-		}
+	sources := 0
+	for _, cp := range copies {
+		sources += len(cp.srcs)
+	}
+	if sources == 0 && len(c.cmd.SourcesAndDest.SourceContents) == 0 {
+		diagnostics.Warnf(diagnostics.EmptyCopy, "%s matched no files", c.cmd.String())
+	}
 
-		// If the destination dir is a symlink we need to resolve the path and use
-		// that instead of the symlink path
-		destPath, err = resolveIfSymlink(destPath)
-		if err != nil {
-			return errors.Wrap(err, "resolving dest symlink")
-		}
+	if c.fileContext.BestEffort {
+		c.fileContext.Skipped = &util.SkippedSources{}
+	}
 
-		if fi.IsDir() {
-			copiedFiles, err := util.CopyDir(fullPath, destPath, c.fileContext, uid, gid, chmod, useDefaultChmod)
+	// the source each destination file is copied from
+	dests := map[string]string{}
+	c.sources = dests
+	// For each source, iterate through and copy it over
+	for _, cp := range copies {
+		for _, src := range cp.srcs {
+			fullPath := filepath.Join(c.fileContext.Root, src)
+
+			fi, err := c.fileContext.LstatSource(fullPath)
 			if err != nil {
-				return errors.Wrap(err, "copying dir")
+				if c.fileContext.SkipSource(fullPath, err) {
+					continue
+				}
+				if errors.Is(err, os.ErrNotExist) {
+					err = util.UserError(err)
+				}
+				return errors.Wrap(err, "could not copy source")
+			}
+			if fi.IsDir() && !strings.HasSuffix(fullPath, string(os.PathSeparator)) {
+				fullPath += "/"
 			}
-			c.snapshotFiles = append(c.snapshotFiles, copiedFiles...)
-		} else if util.IsSymlink(fi) {
-			// If file is a symlink, we want to copy the target file to destPath
-			exclude, err := util.CopySymlink(fullPath, destPath, c.fileContext)
+			cwd := config.WorkingDir
+			if cwd == "" {
+				cwd = kConfig.RootDir
+			}
+
+			destPath, err := util.DestinationFilepath(fullPath, cp.dest, cwd)
 			if err != nil {
-				return errors.Wrap(err, "copying symlink")
+				return errors.Wrap(err, "find destination path")
 			}
-			if exclude {
-				continue
+			if err := util.CheckParentDirectories(destPath); err != nil {
+				return err
 			}
-			c.snapshotFiles = append(c.snapshotFiles, destPath)
-		} else {
-			// ... Else, we want to copy over a file
-			exclude, err := util.CopyFile(fullPath, destPath, c.fileContext, uid, gid, chmod, useDefaultChmod)
+
+			// If the destination dir is a symlink we need to resolve the path and use
+			// that instead of the symlink path
+			destPath, err = resolveIfSymlink(destPath, c.fileContext.MaxSymlinkDepth)
 			if err != nil {
-				return errors.Wrap(err, "copying file")
+				return errors.Wrap(err, "resolving dest symlink")
 			}
-			if exclude {
-				continue
+
+			if fi.IsDir() {
+				copiedFiles, err := util.CopyDir(fullPath, destPath, c.fileContext, uid, gid, chmod, useDefaultChmod)
+				if err != nil {
+					return errors.Wrap(err, "copying dir")
+				}
+				c.snapshotFiles = append(c.snapshotFiles, copiedFiles...)
+				for _, f := range copiedFiles {
+					if fi, err := os.Lstat(f); err != nil || fi.IsDir() {
+						continue
+					}
+					rel, err := filepath.Rel(destPath, f)
+					if err != nil {
+						return err
+					}
+					if err := c.checkDuplicateDestination(dests, f, filepath.Join(src, rel)); err != nil {
+						return err
+					}
+				}
+			} else if util.IsSymlink(fi) {
+				// If file is a symlink, we want to copy the target file to destPath
+				exclude, err := util.CopySymlink(fullPath, destPath, c.fileContext)
+				if err != nil {
+					return errors.Wrap(err, "copying symlink")
+				}
+				if exclude {
+					continue
+				}
+				c.snapshotFiles = append(c.snapshotFiles, destPath)
+				if err := c.checkDuplicateDestination(dests, destPath, src); err != nil {
+					return err
+				}
+			} else {
+				// ... Else, we want to copy over a file
+				exclude, err := util.CopyFile(fullPath, destPath, c.fileContext, uid, gid, chmod, useDefaultChmod)
+				if err != nil {
+					return errors.Wrap(err, "copying file")
+				}
+				if exclude {
+					continue
+				}
+				c.snapshotFiles = append(c.snapshotFiles, destPath)
+				if err := c.checkDuplicateDestination(dests, destPath, src); err != nil {
+					return err
+				}
 			}
-			c.snapshotFiles = append(c.snapshotFiles, destPath)
 		}
 	}
 
 	// Heredocs
+	if len(c.cmd.SourcesAndDest.SourceContents) > 0 && c.cmd.From == "" && c.cmd.Chown == "" && c.fileContext.PreserveOwnership {
+		// heredocs have no ownership to keep, they are owned by the active user
+		uid, gid, err = getActiveUserGroup(user, c.cmd.Chown, replacementEnvs)
+		if err != nil {
+			return errors.Wrap(err, "getting user group from chown")
+		}
+	}
 	for _, src := range c.cmd.SourcesAndDest.SourceContents {
 		fullPath := filepath.Join(c.fileContext.Root, src.Path)
 		cwd := config.WorkingDir
@@ -151,6 +222,9 @@ func (c *CopyCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bu
 		if err != nil {
 			return errors.Wrap(err, "find destination path")
 		}
+		if err := util.CheckParentDirectories(destPath); err != nil {
+			return err
+		}
 
 		srcFile := strings.NewReader(src.Data)
 		err = util.CreateFile(destPath, srcFile, chmod, uint32(uid), uint32(gid))
@@ -159,15 +233,144 @@ func (c *CopyCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bu
 		}
 		c.snapshotFiles = append(c.snapshotFiles, destPath)
 	}
+	// sources and heredocs are copied in separate loops, sorting keeps the
+	// files independent of the order they are given in
+	c.snapshotFiles = util.UniquePaths(c.snapshotFiles)
+	if len(c.fileContext.Transforms) > 0 {
+		transformed, err := util.TransformFiles(c.snapshotFiles, c.fileContext.Transforms)
+		if err != nil {
+			return errors.Wrap(err, c.cmd.String())
+		}
+		c.snapshotFiles = util.UniquePaths(append(c.snapshotFiles, transformed...))
+	}
+	sort.Strings(c.snapshotFiles)
+	if err := c.checkCaseCollisions(); err != nil {
+		return err
+	}
+	if c.fileContext.Fsync {
+		// the files are read again to build the layer, its digest must match
+		// the files a retry after a crash finds
+		if err := syncFiles(c.snapshotFiles); err != nil {
+			return errors.Wrap(err, "syncing copied files")
+		}
+	}
+
+	return c.reportSkipped()
+}
 
+// resolveOwnerRules resolves the owners of --copy-owner like --chown, names are those
+// of the stage the files are copied from with --from.
+func (c *CopyCommand) resolveOwnerRules(replacementEnvs []string) error {
+	root := kConfig.RootDir
+	if c.cmd.From != "" {
+		root = c.fileContext.Root
+	}
+	rules, err := resolveOwnerRules(c.fileContext.OwnerRules, root, replacementEnvs)
+	if err != nil {
+		return err
+	}
+	c.fileContext.OwnerRules = rules
+	return nil
+}
+
+// resolveOwnerRules resolves the owners of rules like --chown, with the passwd and
+// group files below root.
+func resolveOwnerRules(rules []util.OwnerRule, root string, replacementEnvs []string) ([]util.OwnerRule, error) {
+	if len(rules) == 0 {
+		return rules, nil
+	}
+	resolved := make([]util.OwnerRule, 0, len(rules))
+	for _, rule := range rules {
+		uid, gid, err := getUserGroup(root, rule.Chown, replacementEnvs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting user group of --copy-owner %s=%s", rule.Pattern, rule.Chown)
+		}
+		rule.UID, rule.GID = uid, gid
+		resolved = append(resolved, rule)
+	}
+	return resolved, nil
+}
+
+// checkMaxMode fails if mode grants permission bits --max-copy-mode doesn't allow.
+func checkMaxMode(cmd string, mode fs.FileMode, fileContext util.FileContext) error {
+	if fileContext.MaxMode == 0 {
+		return nil
+	}
+	if bits := mode &^ fileContext.MaxMode; bits != 0 {
+		return fmt.Errorf("%s: mode %04o grants bits %04o not allowed by --max-copy-mode=%04o", cmd, mode, bits, fileContext.MaxMode)
+	}
 	return nil
 }
 
-// FilesToSnapshot should return an empty array if still nil; no files were changed
+// checkCaseCollisions warns about or fails on copied files that differ from another
+// file in their directory only by case, depending on --case-collisions.
+func (c *CopyCommand) checkCaseCollisions() error {
+	policy := c.fileContext.CaseCollisions
+	if policy != kConfig.CaseCollisionWarn && policy != kConfig.CaseCollisionError {
+		return nil
+	}
+	collisions, err := util.CaseCollisions(c.snapshotFiles)
+	if err != nil {
+		return errors.Wrap(err, "checking case collisions")
+	}
+	for _, collision := range collisions {
+		if policy == kConfig.CaseCollisionError {
+			return fmt.Errorf("%s: %s and %s differ only by case", c.cmd.String(), collision[0], collision[1])
+		}
+		diagnostics.Warnf(diagnostics.CaseCollision, "%s: %s and %s differ only by case, they collide on case-insensitive filesystems", c.cmd.String(), collision[0], collision[1])
+	}
+	return nil
+}
+
+// checkDuplicateDestination records that src was copied to the file dest and
+// warns about or fails on a source copied there before, depending on
+// --duplicate-destinations.
+func (c *CopyCommand) checkDuplicateDestination(dests map[string]string, dest, src string) error {
+	prev, ok := dests[dest]
+	dests[dest] = src
+	policy := c.fileContext.DuplicateDestinations
+	if policy != kConfig.DuplicateDestinationWarn && policy != kConfig.DuplicateDestinationError {
+		return nil
+	}
+	if !ok || prev == src {
+		return nil
+	}
+	if policy == kConfig.DuplicateDestinationError {
+		return util.UserError(fmt.Errorf("%s: %s and %s are copied to the same destination %s", c.cmd.String(), prev, src, dest))
+	}
+	diagnostics.Warnf(diagnostics.DuplicateDestination, "%s: %s and %s are copied to the same destination %s, %s overwrites %s", c.cmd.String(), prev, src, dest, src, prev)
+	return nil
+}
+
+// reportSkipped reports the sources a best effort copy skipped,
+// it fails if no file could be copied at all.
+func (c *CopyCommand) reportSkipped() error {
+	if c.fileContext.Skipped == nil || len(c.fileContext.Skipped.Paths()) == 0 {
+		return nil
+	}
+	skipped := c.fileContext.Skipped.Paths()
+	for _, f := range c.snapshotFiles {
+		if fi, err := os.Lstat(f); err == nil && !fi.IsDir() {
+			diagnostics.Warnf(diagnostics.SkippedSources, "%s skipped %d sources that could not be read: %s", c.cmd.String(), len(skipped), strings.Join(skipped, ", "))
+			return nil
+		}
+	}
+	return fmt.Errorf("no files could be copied, skipped %d sources that could not be read: %s", len(skipped), strings.Join(skipped, ", "))
+}
+
+// FilesToSnapshot should return an empty array if still nil; no files were changed.
+// The files are sorted by path.
 func (c *CopyCommand) FilesToSnapshot() []string {
 	return c.snapshotFiles
 }
 
+// Sources returns the source each file the command copied was copied from, by
+// destination. The sources are relative to the context, or to the root of the
+// stage or image the files are copied from.
+func (c *CopyCommand) Sources() map[string]string {
+	return c.sources
+}
+
 // String returns some information about the command for the image config
 func (c *CopyCommand) String() string {
 	return c.cmd.String()
@@ -208,6 +411,7 @@ type CachingCopyCommand struct {
 	caching
 	img            v1.Image
 	extractedFiles []string
+	sources        map[string]string
 	cmd            *instructions.CopyCommand
 	fileContext    util.FileContext
 	extractFn      util.ExtractFunction
@@ -231,16 +435,127 @@ func (cr *CachingCopyCommand) ExecuteCommand(config *v1.Config, buildArgs *docke
 	}
 
 	cr.layer = layers[0]
-	cr.extractedFiles, err = util.GetFSFromLayers(kConfig.RootDir, layers, util.ExtractFunc(cr.extractFn), util.IncludeWhiteout())
+	opts := []util.FSOpt{util.ExtractFunc(cr.extractFn), util.IncludeWhiteout()}
+	if cr.cmd != nil {
+		if dest, err := cr.destPath(config, buildArgs); err == nil {
+			// the layer of a COPY only changes its destination
+			opts = append(opts, util.IncludePaths(dest))
+		} else {
+			logrus.Debugf("Extracting the whole cached layer, resolving the destination failed: %v", err)
+		}
+	}
+	cr.extractedFiles, err = util.GetFSFromLayers(kConfig.RootDir, layers, opts...)
 
 	logrus.Debugf("ExtractedFiles: %s", cr.extractedFiles)
 	if err != nil {
 		return errors.Wrap(err, "extracting fs from image")
 	}
+	if cr.fileContext.Fsync {
+		if err := syncFiles(cr.extractedFiles); err != nil {
+			return errors.Wrap(err, "syncing extracted files")
+		}
+	}
+	if cr.cmd != nil {
+		if cr.sources, err = cr.resolveSources(config, buildArgs); err != nil {
+			logrus.Debugf("Not recording the sources of the extracted files: %v", err)
+		}
+	}
 
 	return nil
 }
 
+// resolveSources returns the source each extracted file was copied from, by
+// destination, like CopyCommand.Sources. The cached layer only has the destinations,
+// they are matched with the sources the command resolves to.
+func (cr *CachingCopyCommand) resolveSources(config *v1.Config, buildArgs *dockerfile.BuildArgs) (map[string]string, error) {
+	fileContext := copySourceContext(cr.cmd, cr.fileContext)
+	copies, _, err := resolveCopies(cr.cmd, fileContext, buildArgs.ReplacementEnvs(config.Env))
+	if err != nil {
+		return nil, err
+	}
+	cwd := config.WorkingDir
+	if cwd == "" {
+		cwd = kConfig.RootDir
+	}
+	extracted := map[string]bool{}
+	for _, f := range cr.extractedFiles {
+		extracted[f] = true
+	}
+	sources := map[string]string{}
+	for _, cp := range copies {
+		for _, src := range cp.srcs {
+			fullPath := filepath.Join(fileContext.Root, src)
+			fi, err := fileContext.LstatSource(fullPath)
+			if err != nil {
+				// skipped by a best effort copy
+				continue
+			}
+			if fi.IsDir() {
+				fullPath += "/"
+			}
+			destPath, err := util.DestinationFilepath(fullPath, cp.dest, cwd)
+			if err != nil {
+				return nil, err
+			}
+			if destPath, err = resolveIfSymlink(destPath, fileContext.MaxSymlinkDepth); err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				if extracted[destPath] {
+					sources[destPath] = src
+				}
+				continue
+			}
+			for _, f := range cr.extractedFiles {
+				rel, err := filepath.Rel(destPath, f)
+				if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+					continue
+				}
+				if fi, err := os.Lstat(f); err != nil || fi.IsDir() {
+					continue
+				}
+				sources[f] = filepath.Join(src, rel)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// Sources returns the source each extracted file was copied from, by destination,
+// see CopyCommand.Sources.
+func (cr *CachingCopyCommand) Sources() map[string]string {
+	return cr.sources
+}
+
+// destPath returns the destination of the command as path in the layer, with symlinks resolved.
+func (cr *CachingCopyCommand) destPath(config *v1.Config, buildArgs *dockerfile.BuildArgs) (string, error) {
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	dest, err := util.ResolveEnvironmentReplacement(cr.cmd.DestPath, replacementEnvs, true)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dest) {
+		cwd := config.WorkingDir
+		if cwd == "" {
+			cwd = kConfig.RootDir
+		}
+		dest = filepath.Join(cwd, dest)
+	}
+	dest, err = resolveIfSymlink(filepath.Clean(dest), cr.fileContext.MaxSymlinkDepth)
+	if err != nil {
+		return "", err
+	}
+	// the paths in the layer are relative to the root
+	rel, err := filepath.Rel(kConfig.RootDir, dest)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("destination %s is outside of %s", dest, kConfig.RootDir)
+	}
+	return filepath.Join("/", rel), nil
+}
+
 func (cr *CachingCopyCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
 	return copyCmdFilesUsedFromContext(config, buildArgs, cr.cmd, cr.fileContext)
 }
@@ -268,10 +583,16 @@ func (cr *CachingCopyCommand) From() string {
 	return cr.cmd.From
 }
 
-func resolveIfSymlink(destPath string) (string, error) {
+// resolveIfSymlink resolves the symlinks in destPath, of which the last elements
+// may not exist yet. It fails like with ELOOP once it followed more than maxDepth
+// symlinks, or constants.DefaultMaxSymlinkDepth if maxDepth is unset.
+func resolveIfSymlink(destPath string, maxDepth int) (string, error) {
 	if !filepath.IsAbs(destPath) {
 		return "", errors.New("dest path must be abs")
 	}
+	if maxDepth <= 0 {
+		maxDepth = constants.DefaultMaxSymlinkDepth
+	}
 
 	var nonexistentPaths []string
 
@@ -289,7 +610,7 @@ func resolveIfSymlink(destPath string) (string, error) {
 			}
 		}
 
-		newPath, err = filepath.EvalSymlinks(newPath)
+		newPath, err = evalSymlinks(newPath, maxDepth)
 		if err != nil {
 			return "", errors.Wrap(err, "failed to eval symlinks")
 		}
@@ -307,12 +628,54 @@ func resolveIfSymlink(destPath string) (string, error) {
 	return filepath.Clean(newPath), nil
 }
 
+// evalSymlinks returns the absolute path with its symlinks resolved like
+// filepath.EvalSymlinks, but fails with ELOOP once it followed more than maxDepth.
+func evalSymlinks(path string, maxDepth int) (string, error) {
+	resolved := "/"
+	rest := strings.Split(path, "/")
+	followed := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			// resolved has no symlinks left, its parent is the parent of elem
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, elem)
+		fi, err := os.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		followed++
+		if followed > maxDepth {
+			return "", &os.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
 func copyCmdFilesUsedFromContext(
 	config *v1.Config, buildArgs *dockerfile.BuildArgs, cmd *instructions.CopyCommand,
 	fileContext util.FileContext,
 ) ([]string, error) {
 	if cmd.From != "" {
-		fileContext = util.FileContext{Root: filepath.Join(kConfig.KanikoInterStageDepsDir, cmd.From)}
+		fileContext = fromFileContext(cmd.From, fileContext)
 	}
 
 	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
@@ -329,12 +692,156 @@ func copyCmdFilesUsedFromContext(
 		fullPath := filepath.Join(fileContext.Root, src)
 		files = append(files, fullPath)
 	}
+	if _, ok := dockerfile.CopyManifest(cmd); ok {
+		copies, err := manifestSources(srcs, fileContext, replacementEnvs)
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range copies {
+			for _, src := range cp.srcs {
+				files = append(files, filepath.Join(fileContext.Root, src))
+			}
+		}
+	}
 
 	logrus.Debugf("Using files from context: %v", files)
 
 	return files, nil
 }
 
+// resolveCopies resolves the sources of cmd, from the Dockerfile or its manifest,
+// to the sources copied to each destination. dest is the destination of cmd.
+func resolveCopies(cmd *instructions.CopyCommand, fileContext util.FileContext, replacementEnvs []string) (copies []copySources, dest string, err error) {
+	// sources from the Copy command are resolved with wildcards {*?[}
+	srcs, dest, err := util.ResolveEnvAndWildcards(cmd.SourcesAndDest, fileContext, replacementEnvs)
+	if err != nil {
+		return nil, "", util.UserError(errors.Wrap(err, "resolving src"))
+	}
+	copies = []copySources{{srcs: srcs, dest: dest}}
+	if _, ok := dockerfile.CopyManifest(cmd); ok {
+		copies, err = manifestSources(srcs, fileContext, replacementEnvs)
+		if err != nil {
+			return nil, "", util.UserError(errors.Wrap(err, "resolving manifest"))
+		}
+	}
+	if mode, ok := dockerfile.CopyFlatten(cmd); ok {
+		copies, err = flattenSources(copies, fileContext, mode == dockerfile.FlattenLastWins)
+		if err != nil {
+			return nil, "", util.UserError(err)
+		}
+	}
+	return copies, dest, nil
+}
+
+// copySources are sources a COPY copies to the same destination.
+type copySources struct {
+	srcs []string
+	dest string
+}
+
+// manifestSources resolves the src dest pairs listed in the manifest of a
+// COPY --from-manifest, srcs are the resolved sources of the COPY which is
+// the manifest file.
+func manifestSources(srcs []string, fileContext util.FileContext, envs []string) ([]copySources, error) {
+	if len(srcs) != 1 {
+		return nil, fmt.Errorf("--from-manifest must name a single file, got %d", len(srcs))
+	}
+	f, err := os.Open(filepath.Join(fileContext.Root, srcs[0]))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening manifest")
+	}
+	defer f.Close()
+	pairs, err := dockerfile.ParseCopyManifest(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing manifest %s", srcs[0])
+	}
+	var copies []copySources
+	for _, pair := range pairs {
+		pairSrcs, dest, err := util.ResolveEnvAndWildcards(pair, fileContext, envs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving %s of manifest %s", pair.SourcePaths[0], srcs[0])
+		}
+		copies = append(copies, copySources{srcs: pairSrcs, dest: dest})
+	}
+	return copies, nil
+}
+
+// flattenSources replaces the directories among the sources of copies with the files
+// in them and makes the destinations directories, so that every file is copied
+// directly into its destination, see COPY --flatten. Files with the same name fail
+// the copy, unless lastWins, which copies the last of them.
+func flattenSources(copies []copySources, fileContext util.FileContext, lastWins bool) ([]copySources, error) {
+	flattened := make([]copySources, 0, len(copies))
+	for _, cp := range copies {
+		var files []string
+		for _, src := range cp.srcs {
+			fi, err := fileContext.LstatSource(filepath.Join(fileContext.Root, src))
+			if err != nil || !fi.IsDir() {
+				// missing sources fail once they are copied
+				files = append(files, src)
+				continue
+			}
+			dirFiles, err := util.RelativeFiles(src, fileContext.Root)
+			if err != nil {
+				return nil, errors.Wrapf(err, "flattening %s", src)
+			}
+			for _, file := range dirFiles {
+				fullPath := filepath.Join(fileContext.Root, file)
+				if fileContext.ExcludesFile(fullPath) {
+					continue
+				}
+				if fi, err := os.Lstat(fullPath); err == nil && !fi.IsDir() {
+					files = append(files, file)
+				}
+			}
+		}
+		// the index in srcs of the source copied to each name
+		names := map[string]int{}
+		var srcs []string
+		for _, file := range files {
+			name := filepath.Base(file)
+			i, ok := names[name]
+			if !ok {
+				names[name] = len(srcs)
+				srcs = append(srcs, file)
+				continue
+			}
+			if !lastWins {
+				return nil, fmt.Errorf("COPY --flatten copies %s and %s to the same file %s, use --flatten=%s to copy the last of them", srcs[i], file, name, dockerfile.FlattenLastWins)
+			}
+			logrus.Debugf("Copying %s instead of %s to %s", file, srcs[i], name)
+			srcs[i] = file
+		}
+		dest := cp.dest
+		if !strings.HasSuffix(dest, "/") {
+			dest += "/"
+		}
+		flattened = append(flattened, copySources{srcs: srcs, dest: dest})
+	}
+	return flattened, nil
+}
+
+// fromFileContext returns the file context COPY --from=<from> copies from, either
+// a named build context or the dependency directory of a stage or image. The copy
+// settings of fileContext apply to it as well, only the fields that describe the
+// build context itself are cleared.
+func fromFileContext(from string, fileContext util.FileContext) util.FileContext {
+	root, ok := fileContext.NamedContexts[from]
+	if !ok {
+		root = filepath.Join(kConfig.KanikoInterStageDepsDir, from)
+	}
+	fromContext := fileContext
+	fromContext.Root = root
+	fromContext.RootedSymlinks = !ok
+	// .dockerignore, the provided sources and --follow-context-symlinks are about
+	// the build context, the commit times are keyed by the paths of its files
+	fromContext.ExcludedFiles = nil
+	fromContext.Sources = nil
+	fromContext.FollowDirSymlinks = false
+	fromContext.CommitTimes = nil
+	return fromContext
+}
+
 // AbstractCopyCommand can either be a CopyCommand or a CachingCopyCommand.
 type AbstractCopyCommand interface {
 	From() string
@@ -351,3 +858,85 @@ func CastAbstractCopyCommand(cmd interface{}) (AbstractCopyCommand, bool) {
 
 	return nil, false
 }
+
+// CopiedFilesOwner returns the uid:gid that command, a COPY or ADD, gives the files it
+// copies as ExecuteCommand resolves it, "-1:-1" if they keep the owner of their sources.
+// User and group names are looked up in the filesystem of the stage unless stageNames
+// is false because it isn't unpacked yet, then ok is false if the owner depends on them.
+// Other commands copy no files and have no owner.
+func CopiedFilesOwner(command DockerCommand, config *v1.Config, buildArgs *dockerfile.BuildArgs, stageNames bool) (owner string, ok bool, err error) {
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	switch c := command.(type) {
+	case *CopyCommand:
+		return copyOwner(c.cmd, c.fileContext, config.User, replacementEnvs, stageNames)
+	case *CachingCopyCommand:
+		return copyOwner(c.cmd, c.fileContext, config.User, replacementEnvs, stageNames)
+	case *AddCommand:
+		return activeOwner(config.User, c.cmd.Chown, replacementEnvs, stageNames)
+	case *CachingAddCommand:
+		return activeOwner(config.User, c.cmd.Chown, replacementEnvs, stageNames)
+	}
+	return "", true, nil
+}
+
+// CopiedFilesDestination returns the destination of command, a COPY or ADD, with the
+// environment replaced, and whether the sources are copied into it as a directory because
+// it ends in a slash or there are several of them. Other commands have no destination.
+func CopiedFilesDestination(command DockerCommand, config *v1.Config, buildArgs *dockerfile.BuildArgs) (dest string, dir bool, err error) {
+	var sd instructions.SourcesAndDest
+	var fileContext util.FileContext
+	switch c := command.(type) {
+	case *CopyCommand:
+		sd, fileContext = c.cmd.SourcesAndDest, copySourceContext(c.cmd, c.fileContext)
+	case *CachingCopyCommand:
+		sd, fileContext = c.cmd.SourcesAndDest, copySourceContext(c.cmd, c.fileContext)
+	case *AddCommand:
+		sd, fileContext = c.cmd.SourcesAndDest, c.fileContext
+	case *CachingAddCommand:
+		sd, fileContext = c.cmd.SourcesAndDest, c.fileContext
+	default:
+		return "", false, nil
+	}
+	srcs, dest, err := util.ResolveEnvAndWildcards(sd, fileContext, buildArgs.ReplacementEnvs(config.Env))
+	if err != nil {
+		return "", false, err
+	}
+	return dest, len(srcs) > 1 || strings.HasSuffix(dest, "/") || dest == ".", nil
+}
+
+// copySourceContext returns the file context the sources of cmd are resolved in.
+func copySourceContext(cmd *instructions.CopyCommand, fileContext util.FileContext) util.FileContext {
+	if cmd.From != "" {
+		return fromFileContext(cmd.From, fileContext)
+	}
+	return fileContext
+}
+
+func copyOwner(cmd *instructions.CopyCommand, fileContext util.FileContext, user string, replacementEnvs []string, stageNames bool) (string, bool, error) {
+	if cmd.From != "" {
+		// names are those of the stage the files are copied from, which is there already
+		uid, gid, err := util.ResolveUserGroupIn(fromFileContext(cmd.From, fileContext).Root, cmd.Chown, replacementEnvs)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("%d:%d", uid, gid), true, nil
+	}
+	if fileContext.CopyAsRoot {
+		user = "0:0"
+	}
+	if cmd.Chown == "" && fileContext.PreserveOwnership {
+		return fmt.Sprintf("%d:%d", util.DoNotChangeUID, util.DoNotChangeGID), true, nil
+	}
+	return activeOwner(user, cmd.Chown, replacementEnvs, stageNames)
+}
+
+func activeOwner(user string, chown string, replacementEnvs []string, stageNames bool) (string, bool, error) {
+	if !stageNames && !(util.IsNumericUserGroup(user, replacementEnvs) && util.IsNumericUserGroup(chown, replacementEnvs)) {
+		return "", false, nil
+	}
+	uid, gid, err := util.ResolveActiveUserGroup(user, chown, replacementEnvs)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("%d:%d", uid, gid), true, nil
+}
@@ -18,17 +18,23 @@ package commands
 
 import (
 	"archive/tar"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	kConfig "github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
@@ -359,6 +365,45 @@ func copySetUpBuildArgs() *dockerfile.BuildArgs {
 	return buildArgs
 }
 
+func Test_fromFileContext(t *testing.T) {
+	origDepsDir := kConfig.KanikoInterStageDepsDir
+	defer func() { kConfig.KanikoInterStageDepsDir = origDepsDir }()
+	kConfig.KanikoInterStageDepsDir = "/kaniko/deps"
+
+	umask := 022
+	fileContext := util.FileContext{
+		Root:                  "/workspace",
+		ExcludedFiles:         []string{"*.log"},
+		DefaultFileMode:       0o644,
+		MaxMode:               0o755,
+		NamedContexts:         map[string]string{"assets": "/assets"},
+		RunUmask:              &umask,
+		Sources:               memorySources{},
+		CaseCollisions:        kConfig.CaseCollisionError,
+		FollowDirSymlinks:     true,
+		PreserveInodeFlags:    true,
+		MaxSymlinkDepth:       8,
+		Fsync:                 true,
+		Transforms:            []util.CopyTransform{{Pattern: "*.css", Transform: util.GzipTransform}},
+		OwnerRules:            []util.OwnerRule{{Pattern: "/data", Chown: "www"}},
+		CommitTimes:           map[string]time.Time{"/workspace/a.txt": time.Unix(1, 0)},
+		DuplicateDestinations: kConfig.DuplicateDestinationWarn,
+	}
+	// the copy settings are kept, what describes the build context is not
+	want := fileContext
+	want.Root = "/kaniko/deps/0"
+	want.RootedSymlinks = true
+	want.ExcludedFiles = nil
+	want.Sources = nil
+	want.FollowDirSymlinks = false
+	want.CommitTimes = nil
+	testutil.CheckDeepEqual(t, want, fromFileContext("0", fileContext))
+
+	want.Root = "/assets"
+	want.RootedSymlinks = false
+	testutil.CheckDeepEqual(t, want, fromFileContext("assets", fileContext))
+}
+
 func Test_resolveIfSymlink(t *testing.T) {
 	type testCase struct {
 		destPath     string
@@ -407,7 +452,7 @@ func Test_resolveIfSymlink(t *testing.T) {
 
 	for i, c := range cases {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			res, e := resolveIfSymlink(c.destPath)
+			res, e := resolveIfSymlink(c.destPath, 0)
 			if !errors.Is(e, c.err) {
 				t.Errorf("%s: expected %v but got %v", c.destPath, c.err, e)
 			}
@@ -419,6 +464,40 @@ func Test_resolveIfSymlink(t *testing.T) {
 	}
 }
 
+func Test_resolveIfSymlink_maxDepth(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// link5 -> link4 -> ... -> link1 -> target
+	previous := target
+	for i := 1; i <= 5; i++ {
+		link := filepath.Join(tmpDir, fmt.Sprintf("link%d", i))
+		if err := os.Symlink(filepath.Base(previous), link); err != nil {
+			t.Fatal(err)
+		}
+		previous = link
+	}
+	dest := filepath.Join(previous, "foo.txt")
+
+	res, err := resolveIfSymlink(dest, 5)
+	testutil.CheckErrorAndDeepEqual(t, false, err, filepath.Join(target, "foo.txt"), res)
+
+	_, err = resolveIfSymlink(dest, 4)
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, true, errors.Is(err, syscall.ELOOP))
+	testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "too many levels of symbolic links"))
+
+	// a symlink to itself fails with the default depth
+	loop := filepath.Join(tmpDir, "loop")
+	if err := os.Symlink("loop", loop); err != nil {
+		t.Fatal(err)
+	}
+	_, err = resolveIfSymlink(filepath.Join(loop, "foo.txt"), 0)
+	testutil.CheckDeepEqual(t, true, errors.Is(err, syscall.ELOOP))
+}
+
 func Test_CopyEnvAndWildcards(t *testing.T) {
 	setupDirs := func(t *testing.T) (string, string) {
 		testDir := t.TempDir()
@@ -588,6 +667,229 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 		}
 	})
 
+	t.Run("copy empty dir to another dir", func(t *testing.T) {
+		testDir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(testDir, "emptydir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"emptydir/"}, DestPath: "dest/"},
+			},
+			fileContext: util.FileContext{Root: testDir},
+		}
+		cfg := &v1.Config{
+			Env:        []string{},
+			WorkingDir: testDir,
+		}
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckNoError(t, err)
+		// the directory is snapshotted, so that it is in the layer even without files
+		dest := filepath.Join(testDir, "dest")
+		testutil.CheckDeepEqual(t, []string{dest}, cmd.FilesToSnapshot())
+		fi, err := os.Stat(dest)
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, true, fi.IsDir())
+	})
+
+	t.Run("copy to an existing destination", func(t *testing.T) {
+		for _, tc := range []struct {
+			name        string
+			src         string
+			destIsDir   bool
+			expected    string
+			shouldError bool
+		}{
+			{name: "file over dir nests", src: "bam.txt", destIsDir: true, expected: "dest/bam.txt"},
+			{name: "file over file overwrites", src: "bam.txt", expected: "dest"},
+			{name: "dir over dir merges", src: "", destIsDir: true, expected: "dest/bam.txt"},
+			{name: "dir over file errors", src: "", shouldError: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				dest := filepath.Join(testDir, "dest")
+				if tc.destIsDir {
+					if err := os.Mkdir(dest, 0755); err != nil {
+						t.Fatal(err)
+					}
+				} else if err := os.WriteFile(dest, []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{filepath.Join(srcDir, tc.src)}, DestPath: "dest"},
+					},
+					fileContext: util.FileContext{Root: testDir},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, tc.shouldError, err)
+				if tc.shouldError {
+					testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+					// the existing file is kept
+					content, err := os.ReadFile(dest)
+					testutil.CheckErrorAndDeepEqual(t, false, err, "existing", string(content))
+					return
+				}
+				content, err := os.ReadFile(filepath.Join(testDir, tc.expected))
+				testutil.CheckErrorAndDeepEqual(t, false, err, "meow", string(content))
+			})
+		}
+	})
+
+	t.Run("copy file with chmod zero", func(t *testing.T) {
+		for _, tc := range []struct {
+			chmod    string
+			expected fs.FileMode
+		}{
+			{chmod: "0", expected: 0o754},
+			{chmod: "000", expected: 0},
+		} {
+			t.Run(tc.chmod, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				src := filepath.Join(testDir, srcDir, "bam.txt")
+				if err := os.Chmod(src, 0o754); err != nil {
+					t.Fatal(err)
+				}
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{filepath.Join(srcDir, "bam.txt")}, DestPath: "dest"},
+						Chmod:          tc.chmod,
+					},
+					fileContext: util.FileContext{Root: testDir},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+				fi, err := os.Stat(filepath.Join(testDir, "dest"))
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, tc.expected, fi.Mode().Perm())
+			})
+		}
+	})
+
+	t.Run("missing source is a user error", func(t *testing.T) {
+		testDir, _ := setupDirs(t)
+		for _, tc := range []struct {
+			src    string
+			strict bool
+		}{
+			{src: "missing.txt"},
+			{src: "bar/missing-*.txt", strict: true},
+		} {
+			cmd := CopyCommand{
+				cmd: &instructions.CopyCommand{
+					SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{tc.src}, DestPath: "dest/"},
+				},
+				fileContext: util.FileContext{Root: testDir, StrictSources: tc.strict},
+			}
+			err := cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+			testutil.CheckError(t, true, err)
+			testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+		}
+	})
+
+	t.Run("copy files with special characters in their names", func(t *testing.T) {
+		names := []string{"a b.txt", "café.txt", "weird.", "trailing "}
+		for _, tc := range []struct {
+			name string
+			srcs []string
+			dest string
+			want []string
+		}{
+			{name: "literal sources", srcs: names, dest: "dest/", want: names},
+			{name: "wildcard", srcs: []string{"*"}, dest: "dest/", want: names},
+			{name: "wildcard with unicode", srcs: []string{"caf?.txt"}, dest: "dest/", want: []string{"café.txt"}},
+			{name: "file to file with a trailing dot", srcs: []string{"a b.txt"}, dest: "dest/renamed.", want: []string{"renamed."}},
+			{name: "file with a trailing dot to file", srcs: []string{"weird."}, dest: "dest/café.txt", want: []string{"café.txt"}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir := t.TempDir()
+				ctx := filepath.Join(testDir, "ctx")
+				if err := os.MkdirAll(ctx, 0755); err != nil {
+					t.Fatal(err)
+				}
+				for _, name := range names {
+					if err := os.WriteFile(filepath.Join(ctx, name), []byte(name), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: tc.srcs, DestPath: tc.dest},
+					},
+					fileContext: util.FileContext{Root: ctx},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+
+				dest := filepath.Join(testDir, "dest")
+				var want []string
+				for _, name := range tc.want {
+					want = append(want, filepath.Join(dest, name))
+				}
+				got := cmd.FilesToSnapshot()
+				sort.Strings(got)
+				sort.Strings(want)
+				testutil.CheckDeepEqual(t, want, got)
+				entries, err := os.ReadDir(dest)
+				testutil.CheckNoError(t, err)
+				var copied []string
+				for _, e := range entries {
+					copied = append(copied, e.Name())
+				}
+				sort.Strings(tc.want)
+				testutil.CheckDeepEqual(t, tc.want, copied)
+			})
+		}
+	})
+
+	t.Run("copy dir with a negated ignore pattern", func(t *testing.T) {
+		testDir := t.TempDir()
+		for _, f := range []string{"keep/x.txt", "drop/y.txt", "z.txt"} {
+			path := filepath.Join(testDir, "ctx", f)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(f), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"."}, DestPath: filepath.Join(testDir, "dest") + "/"},
+			},
+			fileContext: util.FileContext{
+				Root:          filepath.Join(testDir, "ctx"),
+				ExcludedFiles: []string{"**", "!keep/**"},
+			},
+		}
+		cfg := &v1.Config{
+			Env:        []string{},
+			WorkingDir: testDir,
+		}
+		dest := filepath.Join(testDir, "dest")
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckNoError(t, err)
+		// keep is excluded by ** but copied, it contains a file that is included again
+		testutil.CheckDeepEqual(t, []string{dest, filepath.Join(dest, "keep"), filepath.Join(dest, "keep", "x.txt")}, cmd.FilesToSnapshot())
+		content, err := os.ReadFile(filepath.Join(dest, "keep", "x.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "keep/x.txt", string(content))
+		_, err = os.Stat(filepath.Join(dest, "drop"))
+		testutil.CheckDeepEqual(t, true, os.IsNotExist(err))
+	})
+
 	t.Run("copy dir to another dir - with ignored files", func(t *testing.T) {
 		testDir, srcDir := setupDirs(t)
 		defer os.RemoveAll(testDir)
@@ -1080,6 +1382,171 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 		}
 	})
 
+	t.Run("copy into a deep nonexistent path with chown", func(t *testing.T) {
+		original := getActiveUserGroup
+		defer func() { getActiveUserGroup = original }()
+		getActiveUserGroup = func(_ string, _ string, _ []string) (int64, int64, error) {
+			return 2000, 3000, nil
+		}
+
+		for _, tc := range []struct {
+			name string
+			src  func(srcDir string) string
+			dest string
+			dirs []string
+		}{
+			{name: "file", src: func(srcDir string) string { return srcDir + "/bam.txt" }, dest: "a/b/c/", dirs: []string{"a", "a/b", "a/b/c"}},
+			{name: "dir", src: func(srcDir string) string { return srcDir }, dest: "a/b/c/", dirs: []string{"a", "a/b", "a/b/c"}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				dest := filepath.Join(testDir, "dest")
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{tc.src(srcDir)}, DestPath: filepath.Join(dest, tc.dest)},
+						Chown:          "2000:3000",
+					},
+					fileContext: util.FileContext{Root: testDir},
+				}
+				err := cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+
+				for _, dir := range append([]string{"."}, tc.dirs...) {
+					fi, err := os.Stat(filepath.Join(dest, dir))
+					testutil.CheckNoError(t, err)
+					stat := fi.Sys().(*syscall.Stat_t)
+					if stat.Uid != 2000 || stat.Gid != 3000 {
+						t.Errorf("%s is owned by %d:%d, expected 2000:3000", dir, stat.Uid, stat.Gid)
+					}
+				}
+				fi, err := os.Stat(testDir)
+				testutil.CheckNoError(t, err)
+				if stat := fi.Sys().(*syscall.Stat_t); stat.Uid == 2000 {
+					t.Errorf("the existing parent %s was chowned", testDir)
+				}
+			})
+		}
+	})
+
+	t.Run("copy src file preserving the source ownership", func(t *testing.T) {
+		original := getActiveUserGroup
+		defer func() { getActiveUserGroup = original }()
+		getActiveUserGroup = func(_ string, chownStr string, _ []string) (int64, int64, error) {
+			if chownStr != "" {
+				return 2000, 2000, nil
+			}
+			return 1000, 1000, nil
+		}
+
+		for _, tc := range []struct {
+			name     string
+			preserve bool
+			chown    string
+			expected uint32
+		}{
+			{name: "active user without the option", expected: 1000},
+			{name: "source ownership", preserve: true, expected: 1234},
+			{name: "chown takes precedence", preserve: true, chown: "2000", expected: 2000},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				if err := os.Lchown(filepath.Join(testDir, srcDir, "bam.txt"), 1234, 1234); err != nil {
+					t.Skipf("changing the owner of a source requires root: %v", err)
+				}
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{
+							SourcePaths:    []string{fmt.Sprintf("%s/bam.txt", srcDir)},
+							DestPath:       "dest/",
+							SourceContents: []instructions.SourceContent{{Path: "heredoc.txt", Data: "meow"}},
+						},
+						Chown: tc.chown,
+					},
+					fileContext: util.FileContext{Root: testDir, PreserveOwnership: tc.preserve},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+
+				fi, err := os.Stat(filepath.Join(testDir, "dest", "bam.txt"))
+				testutil.CheckNoError(t, err)
+				stat := fi.Sys().(*syscall.Stat_t)
+				testutil.CheckDeepEqual(t, tc.expected, stat.Uid)
+				testutil.CheckDeepEqual(t, tc.expected, stat.Gid)
+
+				// heredocs have no owner to keep
+				fi, err = os.Stat(filepath.Join(testDir, "dest", "heredoc.txt"))
+				testutil.CheckNoError(t, err)
+				heredocOwner := uint32(1000)
+				if tc.chown != "" {
+					heredocOwner = 2000
+				}
+				testutil.CheckDeepEqual(t, heredocOwner, fi.Sys().(*syscall.Stat_t).Uid)
+			})
+		}
+	})
+
+	t.Run("copy src file as root", func(t *testing.T) {
+		original := getActiveUserGroup
+		defer func() { getActiveUserGroup = original }()
+		getActiveUserGroup = func(userStr string, chownStr string, _ []string) (int64, int64, error) {
+			if chownStr != "" {
+				return 2000, 2000, nil
+			}
+			if userStr == "0:0" {
+				return 0, 0, nil
+			}
+			return 1000, 1000, nil
+		}
+
+		for _, tc := range []struct {
+			name       string
+			copyAsRoot bool
+			chown      string
+			expected   uint32
+		}{
+			{name: "active user without the option", expected: 1000},
+			{name: "root with the option", copyAsRoot: true, expected: 0},
+			{name: "chown takes precedence", copyAsRoot: true, chown: "2000", expected: 2000},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				if os.Getuid() != 0 {
+					t.Skip("changing the owner of a copy requires root")
+				}
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{
+							SourcePaths:    []string{fmt.Sprintf("%s/bam.txt", srcDir)},
+							DestPath:       "dest/",
+							SourceContents: []instructions.SourceContent{{Path: "heredoc.txt", Data: "meow"}},
+						},
+						Chown: tc.chown,
+					},
+					fileContext: util.FileContext{Root: testDir, CopyAsRoot: tc.copyAsRoot},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					User:       "1000:1000",
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+
+				for _, f := range []string{"bam.txt", "heredoc.txt"} {
+					fi, err := os.Stat(filepath.Join(testDir, "dest", f))
+					testutil.CheckNoError(t, err)
+					stat := fi.Sys().(*syscall.Stat_t)
+					testutil.CheckDeepEqual(t, tc.expected, stat.Uid)
+					testutil.CheckDeepEqual(t, tc.expected, stat.Gid)
+				}
+			})
+		}
+	})
+
 	t.Run("copy src file to a dest dir with chown and random user", func(t *testing.T) {
 		testDir, srcDir := setupDirs(t)
 		defer os.RemoveAll(testDir)
@@ -1148,4 +1615,1045 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 		}
 		testutil.CheckDeepEqual(t, "../bam.txt", linkName)
 	})
+	t.Run("copy src file from a named build context", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		defer os.RemoveAll(testDir)
+
+		namedDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(namedDir, "named.txt"), []byte("purr"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dest := filepath.Join(testDir, "copy")
+		fileContext := util.FileContext{
+			Root:          testDir,
+			NamedContexts: map[string]string{"assets": namedDir},
+		}
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"named.txt"}, DestPath: dest + "/"},
+				From:           "assets",
+			},
+			fileContext: fileContext,
+		}
+
+		cfg := &v1.Config{
+			Cmd:        nil,
+			Env:        []string{},
+			WorkingDir: testDir,
+		}
+		buildArgs := dockerfile.NewBuildArgs([]string{})
+		files, err := cmd.FilesUsedFromContext(cfg, buildArgs)
+		testutil.CheckErrorAndDeepEqual(t, false, err, []string{filepath.Join(namedDir, "named.txt")}, files)
+
+		err = cmd.ExecuteCommand(cfg, buildArgs)
+		testutil.CheckNoError(t, err)
+		content, err := os.ReadFile(filepath.Join(dest, "named.txt"))
+		testutil.CheckErrorAndDeepEqual(t, false, err, "purr", string(content))
+		testutil.CheckDeepEqual(t, []string{filepath.Join(dest, "named.txt")}, cmd.FilesToSnapshot())
+		// the sources of the default build context are not visible
+		if _, err := os.Stat(filepath.Join(dest, srcDir)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to not be copied", srcDir)
+		}
+	})
+
+	t.Run("copy with chown from a stage resolves names with the passwd of the stage", func(t *testing.T) {
+		testDir, _ := setupDirs(t)
+		defer os.RemoveAll(testDir)
+
+		origDepsDir, origRootDir := kConfig.KanikoInterStageDepsDir, kConfig.RootDir
+		defer func() { kConfig.KanikoInterStageDepsDir, kConfig.RootDir = origDepsDir, origRootDir }()
+		kConfig.KanikoInterStageDepsDir = t.TempDir()
+		kConfig.RootDir = t.TempDir()
+		// the stage being built knows app by another uid
+		if err := testutil.SetupFiles(kConfig.RootDir, map[string]string{
+			"etc/passwd": "app:x:1000:1000::/home/app:/bin/sh\n",
+			"etc/group":  "appgrp:x:1000:\n",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := testutil.SetupFiles(filepath.Join(kConfig.KanikoInterStageDepsDir, "0"), map[string]string{
+			"etc/passwd": "root:x:0:0:root:/root:/bin/sh\napp:x:1234:1234::/home/app:/bin/sh\n",
+			"etc/group":  "root:x:0:\nappgrp:x:2345:\n",
+			"built.txt":  "built",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		dest := filepath.Join(testDir, "copy")
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"built.txt"}, DestPath: dest + "/"},
+				From:           "0",
+				Chown:          "app:appgrp",
+			},
+			fileContext: util.FileContext{Root: testDir},
+		}
+		err := cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckNoError(t, err)
+		fi, err := os.Stat(filepath.Join(dest, "built.txt"))
+		testutil.CheckNoError(t, err)
+		stat := fi.Sys().(*syscall.Stat_t)
+		testutil.CheckDeepEqual(t, uint32(1234), stat.Uid)
+		testutil.CheckDeepEqual(t, uint32(2345), stat.Gid)
+	})
+
+	t.Run("copy src file from a named build context piped as tar", func(t *testing.T) {
+		testDir, _ := setupDirs(t)
+		defer os.RemoveAll(testDir)
+
+		// the tar is written while it is unpacked, like a tar piped to stdin
+		pr, pw := io.Pipe()
+		go func() {
+			tw := tar.NewWriter(pw)
+			content := []byte("meow")
+			if err := tw.WriteHeader(&tar.Header{Name: "piped/piped.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := tw.Write(content); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(tw.Close())
+		}()
+		namedDir := t.TempDir()
+		_, err := util.UnpackTarStream(pr, namedDir)
+		testutil.CheckNoError(t, err)
+
+		dest := filepath.Join(testDir, "copy")
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"piped/piped.txt"}, DestPath: dest + "/"},
+				From:           "piped",
+			},
+			fileContext: util.FileContext{
+				Root:          testDir,
+				NamedContexts: map[string]string{"piped": namedDir},
+			},
+		}
+		cfg := &v1.Config{
+			Env:        []string{},
+			WorkingDir: testDir,
+		}
+		err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckNoError(t, err)
+		content, err := os.ReadFile(filepath.Join(dest, "piped.txt"))
+		testutil.CheckErrorAndDeepEqual(t, false, err, "meow", string(content))
+	})
+
+	t.Run("copy src dir with default dir and file modes", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		defer os.RemoveAll(testDir)
+
+		dir := filepath.Join(testDir, srcDir, "another")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			name         string
+			chmod        string
+			expectedDir  fs.FileMode
+			expectedFile fs.FileMode
+		}{
+			{name: "without chmod", expectedDir: 0755, expectedFile: 0644},
+			{name: "with chmod", chmod: "0750", expectedDir: 0750, expectedFile: 0750},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				dest := filepath.Join(testDir, "copy", strings.ReplaceAll(tc.name, " ", "-"))
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir}, DestPath: dest},
+						Chmod:          tc.chmod,
+					},
+					fileContext: util.FileContext{Root: testDir, DefaultDirMode: 0755, DefaultFileMode: 0644},
+				}
+
+				cfg := &v1.Config{
+					Cmd:        nil,
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+
+				fi, err := os.Stat(filepath.Join(dest, "another"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				testutil.CheckDeepEqual(t, tc.expectedDir, fi.Mode().Perm())
+				fi, err = os.Stat(filepath.Join(dest, "bam.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				testutil.CheckDeepEqual(t, tc.expectedFile, fi.Mode().Perm())
+			})
+		}
+	})
+
+	// Like docker, the contents of a source directory are copied, the directory itself is
+	// never nested in the destination, whether it has a trailing slash or exists already.
+	t.Run("copy dir to a dest with and without trailing /", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			dest     string
+			existing bool
+		}{
+			{name: "nonexistent dest", dest: "dest"},
+			{name: "nonexistent dest with trailing /", dest: "dest/"},
+			{name: "existing dest", dest: "dest", existing: true},
+			{name: "existing dest with trailing /", dest: "dest/", existing: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				if tc.existing {
+					if err := os.MkdirAll(filepath.Join(testDir, "dest"), 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir}, DestPath: tc.dest},
+					},
+					fileContext: util.FileContext{Root: testDir},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+
+				var actual []string
+				err = filepath.WalkDir(filepath.Join(testDir, "dest"), func(path string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return err
+					}
+					rel, err := filepath.Rel(testDir, path)
+					actual = append(actual, rel)
+					return err
+				})
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, []string{"dest", "dest/bam.txt", "dest/dam.txt", "dest/sym.link"}, actual)
+			})
+		}
+	})
+
+	t.Run("copy dir with an unreadable file", func(t *testing.T) {
+		for _, tc := range []struct {
+			name       string
+			src        string
+			bestEffort bool
+			expectErr  bool
+			expected   []string
+		}{
+			{name: "fails the copy", src: "bar", expectErr: true},
+			{name: "is skipped with best effort", src: "bar", bestEffort: true, expected: []string{"dest", "dest/dam.txt", "dest/sym.link"}},
+			{name: "fails with best effort if nothing is copied", src: "bar/bam.txt", bestEffort: true, expectErr: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				unreadable := filepath.Join(testDir, srcDir, "bam.txt")
+				util.FSys = deniedFS{FS: util.OSFS{}, denied: unreadable}
+				defer func() { util.FSys = util.OSFS{} }()
+
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{tc.src}, DestPath: "dest/"},
+					},
+					fileContext: util.FileContext{Root: testDir, BestEffort: tc.bestEffort},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, tc.expectErr, err)
+				if tc.bestEffort {
+					testutil.CheckDeepEqual(t, []string{unreadable}, cmd.fileContext.Skipped.Paths())
+				}
+				if tc.expectErr {
+					return
+				}
+
+				var actual []string
+				err = filepath.WalkDir(filepath.Join(testDir, "dest"), func(path string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return err
+					}
+					rel, err := filepath.Rel(testDir, path)
+					actual = append(actual, rel)
+					return err
+				})
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, tc.expected, actual)
+			})
+		}
+	})
+
+	t.Run("copy overlapping wildcards", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		opened := map[string]int{}
+		util.FSys = countingFS{FS: util.OSFS{}, opened: opened}
+		defer func() { util.FSys = util.OSFS{} }()
+
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir + "/*.txt", srcDir + "/b*.txt", srcDir + "/bam.txt"}, DestPath: "dest/"},
+			},
+			fileContext: util.FileContext{Root: testDir},
+		}
+		cfg := &v1.Config{
+			Env:        []string{},
+			WorkingDir: testDir,
+		}
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, []string{
+			filepath.Join(testDir, "dest", "bam.txt"),
+			filepath.Join(testDir, "dest", "dam.txt"),
+		}, cmd.FilesToSnapshot())
+		testutil.CheckDeepEqual(t, 1, opened[filepath.Join(testDir, srcDir, "bam.txt")])
+	})
+
+	t.Run("copy sources from a source provider", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		virtual := filepath.Join(testDir, srcDir, "virtual.txt")
+		sources := memorySources{strings.TrimPrefix(virtual, "/"): &fstest.MapFile{Data: []byte("purr"), Mode: 0640}}
+
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir + "/virtual.txt", srcDir + "/bam.txt"}, DestPath: "dest/"},
+			},
+			fileContext: util.FileContext{Root: testDir, Sources: sources},
+		}
+		cfg := &v1.Config{
+			Env:        []string{},
+			WorkingDir: testDir,
+		}
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, []string{
+			filepath.Join(testDir, "dest", "bam.txt"),
+			filepath.Join(testDir, "dest", "virtual.txt"),
+		}, cmd.FilesToSnapshot())
+
+		// the provided file doesn't exist on disk, the other one is read from the filesystem
+		b, err := os.ReadFile(filepath.Join(testDir, "dest", "virtual.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "purr", string(b))
+		fi, err := os.Stat(filepath.Join(testDir, "dest", "virtual.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, fs.FileMode(0640), fi.Mode().Perm())
+		b, err = os.ReadFile(filepath.Join(testDir, "dest", "bam.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "meow", string(b))
+	})
+
+	t.Run("copy sources and heredocs in stable order", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		copyFiles := func(srcs []string, heredocs []instructions.SourceContent) []string {
+			cmd := CopyCommand{
+				cmd: &instructions.CopyCommand{
+					SourcesAndDest: instructions.SourcesAndDest{SourcePaths: srcs, SourceContents: heredocs, DestPath: "dest/"},
+				},
+				fileContext: util.FileContext{Root: testDir},
+			}
+			cfg := &v1.Config{
+				Env:        []string{},
+				WorkingDir: testDir,
+			}
+			err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+			testutil.CheckNoError(t, err)
+			return cmd.FilesToSnapshot()
+		}
+		want := []string{
+			filepath.Join(testDir, "dest", "a.txt"),
+			filepath.Join(testDir, "dest", "bam.txt"),
+			filepath.Join(testDir, "dest", "dam.txt"),
+			filepath.Join(testDir, "dest", "z.txt"),
+		}
+		testutil.CheckDeepEqual(t, want, copyFiles(
+			[]string{srcDir + "/dam.txt", srcDir + "/bam.txt"},
+			[]instructions.SourceContent{{Path: "z.txt", Data: "z"}, {Path: "a.txt", Data: "a"}},
+		))
+		testutil.CheckDeepEqual(t, want, copyFiles(
+			[]string{srcDir + "/bam.txt", srcDir + "/dam.txt"},
+			[]instructions.SourceContent{{Path: "a.txt", Data: "a"}, {Path: "z.txt", Data: "z"}},
+		))
+	})
+
+	t.Run("copy with max mode", func(t *testing.T) {
+		for _, tc := range []struct {
+			chmod   string
+			wantErr bool
+		}{
+			{chmod: "0777", wantErr: true},
+			{chmod: "4755", wantErr: true},
+			{chmod: "0644"},
+			{chmod: "0755"},
+		} {
+			t.Run(tc.chmod, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				line := "COPY --chmod=" + tc.chmod + " " + srcDir + "/bam.txt dest/"
+				stages, _, err := dockerfile.Parse([]byte("FROM scratch\n" + line))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cmd := CopyCommand{
+					cmd:         stages[0].Commands[0].(*instructions.CopyCommand),
+					fileContext: util.FileContext{Root: testDir, MaxMode: 0o755},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, tc.wantErr, err)
+				if tc.wantErr {
+					if !strings.Contains(err.Error(), line) {
+						t.Errorf("expected error to name %q, got %v", line, err)
+					}
+					return
+				}
+				fi, err := os.Stat(filepath.Join(testDir, "dest", "bam.txt"))
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, tc.chmod, fmt.Sprintf("%04o", fi.Mode().Perm()))
+			})
+		}
+	})
+
+	t.Run("copy from a stage with max mode", func(t *testing.T) {
+		stageDir := setupStageDeps(t, map[string]string{"app": "app"})
+		if err := os.Chmod(filepath.Join(stageDir, "app"), 0o4755); err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			chmod   string
+			wantErr bool
+		}{
+			{chmod: "4755", wantErr: true},
+			{chmod: "0755"},
+		} {
+			t.Run(tc.chmod, func(t *testing.T) {
+				testDir := t.TempDir()
+				line := "COPY --from=0 --chmod=" + tc.chmod + " app dest/"
+				stages, _, err := dockerfile.Parse([]byte("FROM scratch\n" + line))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cmd := CopyCommand{
+					cmd:         stages[0].Commands[0].(*instructions.CopyCommand),
+					fileContext: util.FileContext{Root: testDir, MaxMode: 0o755},
+				}
+				err = cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, tc.wantErr, err)
+				if tc.wantErr {
+					testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "not allowed by --max-copy-mode"))
+				}
+			})
+		}
+	})
+
+	t.Run("copy into a path below a file", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			line string
+		}{
+			{name: "file", line: "COPY {{src}}/bam.txt a/b/c"},
+			{name: "directory", line: "COPY {{src}} a/b/c/"},
+			{name: "heredoc", line: "COPY <<EOF a/b/c\nmeow\nEOF"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				if err := os.MkdirAll(filepath.Join(testDir, "a"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(testDir, "a", "b"), []byte("file"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				line := strings.ReplaceAll(tc.line, "{{src}}", srcDir)
+				stages, _, err := dockerfile.Parse([]byte("FROM scratch\n" + line))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cmd := CopyCommand{
+					cmd:         stages[0].Commands[0].(*instructions.CopyCommand),
+					fileContext: util.FileContext{Root: testDir},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, true, err)
+				expected := fmt.Sprintf("cannot create directory %s: not a directory (a file exists)", filepath.Join(testDir, "a", "b"))
+				testutil.CheckDeepEqual(t, expected, err.Error())
+			})
+		}
+	})
+
+	t.Run("copy case collisions", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy  kConfig.CaseCollisionPolicy
+			want    int
+			wantErr bool
+		}{
+			{policy: kConfig.CaseCollisionIgnore},
+			{policy: kConfig.CaseCollisionWarn, want: 2},
+			{policy: kConfig.CaseCollisionError, wantErr: true},
+		} {
+			t.Run(string(tc.policy), func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				// collides with a copied file
+				if err := os.WriteFile(filepath.Join(testDir, srcDir, "BAM.txt"), []byte("MEOW"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				// collides with a file that exists at the destination
+				if err := os.MkdirAll(filepath.Join(testDir, "dest"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(testDir, "dest", "Dam.txt"), []byte("WOOF"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				diagnostics.Reset()
+				defer diagnostics.Reset()
+
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir}, DestPath: "dest/"},
+					},
+					fileContext: util.FileContext{Root: testDir, CaseCollisions: tc.policy},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, tc.wantErr, err)
+				var collisions []string
+				for _, d := range diagnostics.All() {
+					if d.Code == diagnostics.CaseCollision {
+						collisions = append(collisions, d.Message)
+					}
+				}
+				testutil.CheckDeepEqual(t, tc.want, len(collisions))
+			})
+		}
+	})
+
+	t.Run("copy case collisions from a stage", func(t *testing.T) {
+		setupStageDeps(t, map[string]string{"app/README": "a", "app/readme": "b"})
+		testDir := t.TempDir()
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"app"}, DestPath: "dest/"},
+				From:           "0",
+			},
+			fileContext: util.FileContext{Root: testDir, CaseCollisions: kConfig.CaseCollisionError},
+		}
+		err := cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckError(t, true, err)
+		testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "differ only by case"))
+	})
+
+	t.Run("copy duplicate destinations", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy  kConfig.DuplicateDestinationPolicy
+			want    []string
+			wantErr bool
+		}{
+			{policy: kConfig.DuplicateDestinationIgnore},
+			{policy: kConfig.DuplicateDestinationWarn, want: []string{"bar/bam.txt", "other/bam.txt"}},
+			{policy: kConfig.DuplicateDestinationError, wantErr: true},
+		} {
+			t.Run(string(tc.policy), func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				if err := os.MkdirAll(filepath.Join(testDir, "other"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(testDir, "other", "bam.txt"), []byte("purr"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				diagnostics.Reset()
+				defer diagnostics.Reset()
+
+				cmd := CopyCommand{
+					cmd: &instructions.CopyCommand{
+						SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir + "/bam.txt", "other/"}, DestPath: "dest/"},
+					},
+					fileContext: util.FileContext{Root: testDir, DuplicateDestinations: tc.policy},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckError(t, tc.wantErr, err)
+				if tc.wantErr {
+					testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+					testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "bar/bam.txt and other/bam.txt"))
+					return
+				}
+				var duplicates []diagnostics.Diagnostic
+				for _, d := range diagnostics.All() {
+					if d.Code == diagnostics.DuplicateDestination {
+						duplicates = append(duplicates, d)
+					}
+				}
+				if tc.want == nil {
+					testutil.CheckDeepEqual(t, 0, len(duplicates))
+					return
+				}
+				testutil.CheckDeepEqual(t, 1, len(duplicates))
+				for _, src := range tc.want {
+					testutil.CheckDeepEqual(t, true, strings.Contains(duplicates[0].Message, src))
+				}
+				// the last source wins
+				b, err := os.ReadFile(filepath.Join(testDir, "dest", "bam.txt"))
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, "purr", string(b))
+			})
+		}
+	})
+
+	t.Run("copy duplicate destinations from a stage", func(t *testing.T) {
+		setupStageDeps(t, map[string]string{"a/app.txt": "a", "b/app.txt": "b"})
+		testDir := t.TempDir()
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"a/", "b/"}, DestPath: "dest/"},
+				From:           "0",
+			},
+			fileContext: util.FileContext{Root: testDir, DuplicateDestinations: kConfig.DuplicateDestinationError},
+		}
+		err := cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckError(t, true, err)
+		testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "a/app.txt and b/app.txt"))
+	})
+
+	t.Run("copy from a manifest", func(t *testing.T) {
+		for _, line := range []string{"COPY --from-manifest=paths.txt", "COPY --from-manifest paths.txt"} {
+			t.Run(line, func(t *testing.T) {
+				testDir, srcDir := setupDirs(t)
+				manifest := "# src dest\n" +
+					srcDir + "/bam.txt  opt/a/bam\n" +
+					"\n" +
+					srcDir + "/dam.txt\tdest/\n" +
+					srcDir + "/*.link dest/links/\n"
+				if err := os.WriteFile(filepath.Join(testDir, "paths.txt"), []byte(manifest), 0644); err != nil {
+					t.Fatal(err)
+				}
+				stages, _, err := dockerfile.Parse([]byte("FROM scratch\n" + line))
+				if err != nil {
+					t.Fatal(err)
+				}
+				copyCmd := stages[0].Commands[0].(*instructions.CopyCommand)
+				cmd := CopyCommand{
+					cmd:         copyCmd,
+					fileContext: util.FileContext{Root: testDir},
+				}
+				cfg := &v1.Config{
+					Env:        []string{},
+					WorkingDir: testDir,
+				}
+				files, err := cmd.FilesUsedFromContext(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, []string{
+					filepath.Join(testDir, "paths.txt"),
+					filepath.Join(testDir, srcDir, "bam.txt"),
+					filepath.Join(testDir, srcDir, "dam.txt"),
+					filepath.Join(testDir, srcDir, "sym.link"),
+				}, files)
+
+				err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, []string{
+					filepath.Join(testDir, "dest", "dam.txt"),
+					filepath.Join(testDir, "dest", "links", "sym.link"),
+					filepath.Join(testDir, "opt", "a", "bam"),
+				}, cmd.FilesToSnapshot())
+				for path, content := range map[string]string{"opt/a/bam": "meow", "dest/dam.txt": "woof"} {
+					b, err := os.ReadFile(filepath.Join(testDir, path))
+					testutil.CheckNoError(t, err)
+					testutil.CheckDeepEqual(t, content, string(b))
+				}
+			})
+		}
+	})
+
+	t.Run("copy from an invalid manifest", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		if err := os.WriteFile(filepath.Join(testDir, "paths.txt"), []byte(srcDir+"/bam.txt\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stages, _, err := dockerfile.Parse([]byte("FROM scratch\nCOPY --from-manifest=paths.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := CopyCommand{
+			cmd:         stages[0].Commands[0].(*instructions.CopyCommand),
+			fileContext: util.FileContext{Root: testDir},
+		}
+		err = cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+	})
+
+	copyFlattened := func(t *testing.T, testDir, line string) (CopyCommand, error) {
+		stages, _, err := dockerfile.Parse([]byte("FROM scratch\n" + line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := CopyCommand{
+			cmd:         stages[0].Commands[0].(*instructions.CopyCommand),
+			fileContext: util.FileContext{Root: testDir},
+		}
+		return cmd, cmd.ExecuteCommand(&v1.Config{Env: []string{}, WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+	}
+
+	t.Run("copy flattened", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		if err := os.MkdirAll(filepath.Join(testDir, srcDir, "nested", "deeper"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(testDir, srcDir, "nested", "deeper", "purr.txt"), []byte("purr"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := copyFlattened(t, testDir, "COPY --flatten "+srcDir+" dest")
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, []string{
+			filepath.Join(testDir, "dest", "bam.txt"),
+			filepath.Join(testDir, "dest", "dam.txt"),
+			filepath.Join(testDir, "dest", "purr.txt"),
+			filepath.Join(testDir, "dest", "sym.link"),
+		}, cmd.FilesToSnapshot())
+		b, err := os.ReadFile(filepath.Join(testDir, "dest", "purr.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "purr", string(b))
+		_, err = os.Stat(filepath.Join(testDir, "dest", "nested"))
+		testutil.CheckDeepEqual(t, true, os.IsNotExist(err))
+	})
+
+	t.Run("copy flattened with the same names", func(t *testing.T) {
+		testDir, srcDir := setupDirs(t)
+		if err := os.MkdirAll(filepath.Join(testDir, "other"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(testDir, "other", "bam.txt"), []byte("hiss"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := copyFlattened(t, testDir, "COPY --flatten "+srcDir+" other dest/")
+		testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+		testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "same file bam.txt"))
+
+		cmd, err := copyFlattened(t, testDir, "COPY --flatten=last-wins "+srcDir+" other dest/")
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, []string{
+			filepath.Join(testDir, "dest", "bam.txt"),
+			filepath.Join(testDir, "dest", "dam.txt"),
+			filepath.Join(testDir, "dest", "sym.link"),
+		}, cmd.FilesToSnapshot())
+		b, err := os.ReadFile(filepath.Join(testDir, "dest", "bam.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "hiss", string(b))
+	})
+
+	t.Run("copy with fsync", func(t *testing.T) {
+		original := syncFiles
+		defer func() { syncFiles = original }()
+		// the content of the files when they were synced
+		synced := map[string]string{}
+		syncFiles = func(paths []string) error {
+			for _, p := range paths {
+				if b, err := os.ReadFile(p); err == nil {
+					synced[p] = string(b)
+				}
+			}
+			return original(paths)
+		}
+
+		testDir, srcDir := setupDirs(t)
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{srcDir}, DestPath: "dest"},
+			},
+			fileContext: util.FileContext{Root: testDir},
+		}
+		cfg := &v1.Config{WorkingDir: testDir}
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+		testutil.CheckDeepEqual(t, map[string]string{}, synced)
+
+		cmd.fileContext.Fsync = true
+		cmd.snapshotFiles = nil
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+		// the files the layer is built from are synced with their final content
+		testutil.CheckDeepEqual(t, map[string]string{
+			filepath.Join(testDir, "dest", "bam.txt"):  "meow",
+			filepath.Join(testDir, "dest", "dam.txt"):  "woof",
+			filepath.Join(testDir, "dest", "sym.link"): "woof",
+		}, synced)
+	})
+
+	t.Run("copy from a stage with fsync", func(t *testing.T) {
+		original := syncFiles
+		defer func() { syncFiles = original }()
+		var synced []string
+		syncFiles = func(paths []string) error {
+			synced = append(synced, paths...)
+			return original(paths)
+		}
+
+		setupStageDeps(t, map[string]string{"built.txt": "built"})
+		testDir := t.TempDir()
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"built.txt"}, DestPath: "dest/"},
+				From:           "0",
+			},
+			fileContext: util.FileContext{Root: testDir, Fsync: true},
+		}
+		cfg := &v1.Config{WorkingDir: testDir}
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+		testutil.CheckDeepEqual(t, []string{filepath.Join(testDir, "dest", "built.txt")}, synced)
+	})
+
+	t.Run("copy with gzip transform", func(t *testing.T) {
+		testDir := t.TempDir()
+		srcDir := filepath.Join(testDir, "static")
+		if err := os.MkdirAll(srcDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{"app.css": "body {}", "app.js": "alert(1)", "readme.txt": "docs"}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"static"}, DestPath: "dest"},
+			},
+			fileContext: util.FileContext{Root: testDir, Transforms: []util.CopyTransform{
+				{Pattern: "*.css", Transform: util.GzipTransform},
+				{Pattern: "*.js", Transform: util.GzipTransform},
+			}},
+		}
+		cfg := &v1.Config{WorkingDir: testDir}
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+		dest := filepath.Join(testDir, "dest")
+		// the compressed files are snapshotted with the files they were compressed from
+		testutil.CheckDeepEqual(t, []string{
+			dest,
+			filepath.Join(dest, "app.css"),
+			filepath.Join(dest, "app.css.gz"),
+			filepath.Join(dest, "app.js"),
+			filepath.Join(dest, "app.js.gz"),
+			filepath.Join(dest, "readme.txt"),
+		}, cmd.FilesToSnapshot())
+		for _, name := range []string{"app.css", "app.js"} {
+			f, err := os.Open(filepath.Join(dest, name+".gz"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			gz, err := gzip.NewReader(f)
+			testutil.CheckNoError(t, err)
+			content, err := io.ReadAll(gz)
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, files[name], string(content))
+		}
+	})
+
+	t.Run("copy from a stage into a symlink deeper than the max depth", func(t *testing.T) {
+		setupStageDeps(t, map[string]string{"built.txt": "built"})
+		testDir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(testDir, "target"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// link2 -> link1 -> target
+		if err := os.Symlink("target", filepath.Join(testDir, "link1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("link1", filepath.Join(testDir, "link2")); err != nil {
+			t.Fatal(err)
+		}
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"built.txt"}, DestPath: "link2/"},
+				From:           "0",
+			},
+			fileContext: util.FileContext{Root: testDir, MaxSymlinkDepth: 1},
+		}
+		err := cmd.ExecuteCommand(&v1.Config{WorkingDir: testDir}, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckError(t, true, err)
+		testutil.CheckDeepEqual(t, true, errors.Is(err, syscall.ELOOP))
+	})
+
+	t.Run("copy from a stage with gzip transform", func(t *testing.T) {
+		setupStageDeps(t, map[string]string{"static/app.css": "body {}"})
+		testDir := t.TempDir()
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"static/app.css"}, DestPath: "dest/"},
+				From:           "0",
+			},
+			fileContext: util.FileContext{Root: testDir, Transforms: []util.CopyTransform{
+				{Pattern: "*.css", Transform: util.GzipTransform},
+			}},
+		}
+		cfg := &v1.Config{WorkingDir: testDir}
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+		testutil.CheckDeepEqual(t, []string{
+			filepath.Join(testDir, "dest", "app.css"),
+			filepath.Join(testDir, "dest", "app.css.gz"),
+		}, cmd.FilesToSnapshot())
+	})
+
+	t.Run("copy with owner rules", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("changing the owner of a copy requires root")
+		}
+		testDir := t.TempDir()
+		origRootDir := kConfig.RootDir
+		defer func() { kConfig.RootDir = origRootDir }()
+		kConfig.RootDir = testDir
+		// the names of the rules are resolved with the passwd of the image
+		if err := testutil.SetupFiles(testDir, map[string]string{
+			"etc/passwd":           "www:x:2000:2000::/var/www:/bin/sh\n",
+			"etc/group":            "www:x:2000:\n",
+			"src/app/main.txt":     "main",
+			"src/app/data/db.txt":  "db",
+			"src/app/data/sub/log": "log",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"src"}, DestPath: "dest"},
+				Chown:          "1000:1000",
+			},
+			fileContext: util.FileContext{Root: testDir, OwnerRules: []util.OwnerRule{
+				{Pattern: "/dest/*/data", Chown: "www:www"},
+			}},
+		}
+		cfg := &v1.Config{WorkingDir: testDir}
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+
+		for path, expected := range map[string]uint32{
+			"dest/app":              1000,
+			"dest/app/main.txt":     1000,
+			"dest/app/data":         2000,
+			"dest/app/data/db.txt":  2000,
+			"dest/app/data/sub":     2000,
+			"dest/app/data/sub/log": 2000,
+		} {
+			fi, err := os.Lstat(filepath.Join(testDir, path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stat := fi.Sys().(*syscall.Stat_t)
+			if stat.Uid != expected || stat.Gid != expected {
+				t.Errorf("expected %s to be owned by %d:%d, got %d:%d", path, expected, expected, stat.Uid, stat.Gid)
+			}
+		}
+	})
+
+	t.Run("copy from a stage with owner rules", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("changing the owner of a copy requires root")
+		}
+		testDir := t.TempDir()
+		origRootDir := kConfig.RootDir
+		defer func() { kConfig.RootDir = origRootDir }()
+		kConfig.RootDir = testDir
+		// the names of the rules are those of the stage the files are copied from
+		setupStageDeps(t, map[string]string{
+			"etc/passwd":        "www:x:3000:3000::/var/www:/bin/sh\n",
+			"etc/group":         "www:x:3000:\n",
+			"app/main.txt":      "main",
+			"app/data/data.txt": "data",
+		})
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: instructions.SourcesAndDest{SourcePaths: []string{"app"}, DestPath: "dest"},
+				From:           "0",
+				Chown:          "1000:1000",
+			},
+			fileContext: util.FileContext{Root: testDir, OwnerRules: []util.OwnerRule{
+				{Pattern: "/dest/data", Chown: "www:www"},
+			}},
+		}
+		cfg := &v1.Config{WorkingDir: testDir}
+		testutil.CheckNoError(t, cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})))
+
+		for path, expected := range map[string]uint32{
+			"dest/main.txt":      1000,
+			"dest/data":          3000,
+			"dest/data/data.txt": 3000,
+		} {
+			fi, err := os.Lstat(filepath.Join(testDir, path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stat := fi.Sys().(*syscall.Stat_t)
+			if stat.Uid != expected || stat.Gid != expected {
+				t.Errorf("expected %s to be owned by %d:%d, got %d:%d", path, expected, expected, stat.Uid, stat.Gid)
+			}
+		}
+	})
+}
+
+// memorySources supplies COPY sources from memory.
+type memorySources fstest.MapFS
+
+func (m memorySources) Lstat(path string) (fs.FileInfo, error) {
+	return fs.Stat(fstest.MapFS(m), strings.TrimPrefix(path, "/"))
+}
+
+func (m memorySources) Open(path string) (io.ReadCloser, error) {
+	return fstest.MapFS(m).Open(strings.TrimPrefix(path, "/"))
+}
+
+// countingFS counts how often each file is opened.
+type countingFS struct {
+	fs.FS
+	opened map[string]int
+}
+
+func (c countingFS) Open(name string) (fs.File, error) {
+	c.opened[name]++
+	return c.FS.Open(name)
+}
+
+// deniedFS fails to open denied as if it was not readable.
+type deniedFS struct {
+	fs.FS
+	denied string
+}
+
+func (d deniedFS) Open(name string) (fs.File, error) {
+	if name == d.denied {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return d.FS.Open(name)
+}
+
+// setupStageDeps points the inter-stage dependency directory at a temporary
+// directory and writes files to the directory of stage 0 in it, which
+// COPY --from=0 copies from. It returns the directory of stage 0.
+func setupStageDeps(t *testing.T, files map[string]string) string {
+	t.Helper()
+	origDepsDir := kConfig.KanikoInterStageDepsDir
+	t.Cleanup(func() { kConfig.KanikoInterStageDepsDir = origDepsDir })
+	kConfig.KanikoInterStageDepsDir = t.TempDir()
+	stageDir := filepath.Join(kConfig.KanikoInterStageDepsDir, "0")
+	if err := testutil.SetupFiles(stageDir, files); err != nil {
+		t.Fatal(err)
+	}
+	return stageDir
 }
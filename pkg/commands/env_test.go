@@ -68,6 +68,41 @@ func Test_EnvExecute(t *testing.T) {
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEnvs, cfg.Env)
 }
 
+func Test_EnvExecute_quoting(t *testing.T) {
+	dockerFile := `FROM scratch
+ENV A="x y" B='c'
+ENV C="a \"quoted\" value" D=one\ two E='$A' F="$A"
+ENV G="first \
+    second" \
+    H=h
+ENV I legacy  "form"
+`
+	stages, _, err := dockerfile.Parse([]byte(dockerFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &v1.Config{Env: []string{"A=old"}}
+	buildArgs := dockerfile.NewBuildArgs([]string{})
+	for _, c := range stages[0].Commands {
+		envCmd := &EnvCommand{cmd: c.(*instructions.EnvCommand)}
+		if err := envCmd.ExecuteCommand(cfg, buildArgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedEnvs := []string{
+		"A=x y",
+		"B=c",
+		`C=a "quoted" value`,
+		"D=one two",
+		"E=$A",
+		"F=x y",
+		"G=first     second",
+		"H=h",
+		"I=legacy  form",
+	}
+	testutil.CheckDeepEqual(t, expectedEnvs, cfg.Env)
+}
+
 func setUpBuildArgs() *dockerfile.BuildArgs {
 	buildArgs := dockerfile.NewBuildArgs([]string{
 		"buildArg1=foo",
@@ -20,16 +20,21 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	kConfig "github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/pkg/errors"
@@ -38,8 +43,9 @@ import (
 
 type RunCommand struct {
 	BaseCommand
-	cmd      *instructions.RunCommand
-	shdCache bool
+	cmd         *instructions.RunCommand
+	fileContext util.FileContext
+	shdCache    bool
 }
 
 // for testing
@@ -52,32 +58,31 @@ func (r *RunCommand) IsArgsEnvsRequiredInCache() bool {
 }
 
 func (r *RunCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
-	return runCommandWithFlags(config, buildArgs, r.cmd)
+	return runCommandWithFlags(config, buildArgs, r.cmd, r.fileContext)
 }
 
-func runCommandWithFlags(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand) (reterr error) {
+// FilesUsedFromContext returns the files bind mounted from other stages,
+// so that changes to them invalidate the cached layer.
+func (r *RunCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
+	return bindMountFiles(config, buildArgs, r.cmd, r.fileContext)
+}
+
+func runCommandWithFlags(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand, fileContext util.FileContext) (reterr error) {
 	ff_cache := kConfig.EnvBoolDefault("FF_KANIKO_RUN_MOUNT_CACHE", true)
 	for _, f := range cmdRun.FlagsUsed {
 		if !(ff_cache && f == "mount") {
-			logrus.Warnf("#969 kaniko does not support '--%s' flags in RUN statements - relying on unsupported flags can lead to invalid builds", f)
+			diagnostics.Warnf(diagnostics.UnsupportedFlag, "#969 kaniko does not support '--%s' flags in RUN statements - relying on unsupported flags can lead to invalid builds", f)
 		}
 	}
 	if ff_cache && len(cmdRun.FlagsUsed) > 0 {
-		replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
-		expand := func(word string) (string, error) {
-			return util.ResolveEnvironmentReplacement(word, replacementEnvs, false)
-		}
-		err := cmdRun.Expand(expand)
+		mounts, err := expandMounts(config, buildArgs, cmdRun)
 		if err != nil {
 			return err
 		}
-		for _, m := range instructions.GetMounts(cmdRun) {
+		for _, m := range mounts {
 			switch m.Type {
 			case instructions.MountTypeCache:
-				normalized := filepath.Clean(m.Target)
-				h := sha256.Sum256([]byte(normalized))
-				targetHash := hex.EncodeToString(h[:])
-				cacheDir := filepath.Join(kConfig.KanikoCacheDir, targetHash)
+				cacheDir := cacheMountDir(m)
 				err = os.MkdirAll(cacheDir, 0755)
 				if err != nil {
 					return err
@@ -95,49 +100,237 @@ func runCommandWithFlags(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmd
 					}()
 				}
 				err = swapDir(cacheDir, m.Target)
+				if err != nil {
+					return errors.Wrapf(err, "mounting cache %s", m.Target)
+				}
 				defer func() {
 					err := swapDir(m.Target, cacheDir)
 					if err != nil {
 						reterr = err
 					}
 				}()
+			case instructions.MountTypeBind:
+				if m.From == "" {
+					diagnostics.Warnf(diagnostics.UnsupportedFlag, "Kaniko does not support '--mount=type=bind' flags without 'from' in RUN statements - relying on unsupported flags can lead to invalid builds")
+					continue
+				}
+				source, err := bindMountSource(m, fileContext)
+				if err != nil {
+					return errors.Wrapf(err, "resolving the source of %s in %s", m.Target, m.From)
+				}
+				unmount, err := bindMount(source, m.Target)
+				if err != nil {
+					return errors.Wrapf(err, "mounting %s from %s", m.Target, m.From)
+				}
+				defer func() {
+					err := unmount()
+					if err != nil {
+						reterr = err
+					}
+				}()
 			default:
-				logrus.Warnf("Kaniko does not support '--mount=type=%s' flags in RUN statements - relying on unsupported flags can lead to invalid builds", m.Type)
+				diagnostics.Warnf(diagnostics.UnsupportedFlag, "Kaniko does not support '--mount=type=%s' flags in RUN statements - relying on unsupported flags can lead to invalid builds", m.Type)
 			}
 
 		}
 	}
-	return runCommandInExec(config, buildArgs, cmdRun)
+	return runCommandInExec(config, buildArgs, cmdRun, fileContext)
 }
 
-func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand) error {
-	var newCommand []string
-	if cmdRun.PrependShell {
-		// This is the default shell on Linux
-		var shell []string
-		if len(config.Shell) > 0 {
-			shell = config.Shell
-		} else {
-			shell = append(shell, "/bin/sh", "-c")
+// expandMounts expands the --mount flags of cmdRun. Expanding parses the flags again,
+// so the stage references resolved by ResolveCrossStageCommands are carried over.
+func expandMounts(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand) ([]*instructions.Mount, error) {
+	var froms []string
+	for _, m := range instructions.GetMounts(cmdRun) {
+		froms = append(froms, m.From)
+	}
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	expand := func(word string) (string, error) {
+		return util.ResolveEnvironmentReplacement(word, replacementEnvs, false)
+	}
+	if err := cmdRun.Expand(expand); err != nil {
+		return nil, err
+	}
+	mounts := instructions.GetMounts(cmdRun)
+	for i, m := range mounts {
+		if _, err := strconv.Atoi(froms[i]); err == nil {
+			m.From = froms[i]
 		}
+	}
+	return mounts, nil
+}
 
-		cmd := strings.Join(cmdRun.CmdLine, " ")
+// bindMountSource returns the path of the files a bind mount reads from another stage.
+// The source is resolved inside the files of the stage, ".." and symlinks can't leave them.
+func bindMountSource(m *instructions.Mount, fileContext util.FileContext) (string, error) {
+	source, _, err := util.ResolvePathInRoot(fromFileContext(m.From, fileContext).Root, m.Source)
+	return source, err
+}
 
-		// Heredocs
-		if len(cmdRun.Files) == 1 && cmd == fmt.Sprintf("<<%s", cmdRun.Files[0].Name) {
-			// 1713: if we encounter a line like 'RUN <<EOF',
-			// we implicitly want the file body to be executed as a script
-			cmd += " sh"
+func bindMountFiles(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand, fileContext util.FileContext) ([]string, error) {
+	if !kConfig.EnvBoolDefault("FF_KANIKO_RUN_MOUNT_CACHE", true) || len(dockerfile.BindMounts(cmdRun)) == 0 {
+		return nil, nil
+	}
+	mounts, err := expandMounts(config, buildArgs, cmdRun)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range mounts {
+		if m.Type == instructions.MountTypeBind && m.From != "" {
+			source, err := bindMountSource(m, fileContext)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, source)
+		}
+	}
+	return files, nil
+}
+
+// bindMount makes the files at source available at target for the duration of a RUN.
+// They are copied to a scratch directory and swapped in like cache mounts, so the RUN
+// can not modify the stage they come from and they are not part of the snapshot.
+func bindMount(source, target string) (func() error, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(filepath.Clean(target)))
+	scratch := filepath.Join(kConfig.KanikoBindMountDir, hex.EncodeToString(h[:]))
+	if err := os.RemoveAll(scratch); err != nil {
+		return nil, err
+	}
+	var created string
+	if fi.IsDir() {
+		if _, err := util.CopyDir(source, scratch, util.FileContext{}, util.DoNotChangeUID, util.DoNotChangeGID, fs.FileMode(0o644), true); err != nil {
+			return nil, err
+		}
+		created, err = ensureDir(target)
+	} else {
+		if _, err := util.CopyFile(source, scratch, util.FileContext{}, util.DoNotChangeUID, util.DoNotChangeGID, fs.FileMode(0o644), true); err != nil {
+			return nil, err
 		}
-		for _, h := range cmdRun.Files {
-			cmd += "\n" + h.Data + h.Name
+		created, err = ensureFile(target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := swapDir(scratch, target); err != nil {
+		return nil, err
+	}
+	return func() error {
+		if err := swapDir(target, scratch); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(scratch); err != nil {
+			return err
+		}
+		if created != "" {
+			return os.RemoveAll(created)
 		}
+		return nil
+	}, nil
+}
+
+// cacheMountDir returns the directory backing a cache mount. Mounts are shared by id,
+// which defaults to the target like in buildkit.
+func cacheMountDir(m *instructions.Mount) string {
+	key := m.CacheID
+	if key == "" {
+		key = filepath.Clean(m.Target)
+	}
+	return kConfig.CacheMountDir(key)
+}
 
-		newCommand = append(shell, cmd)
+// runShell returns the shell set with SHELL or /bin/sh.
+func runShell(config *v1.Config) []string {
+	// This is the default shell on Linux
+	if len(config.Shell) > 0 {
+		// config.Shell must not be modified by appending to it
+		return append([]string{}, config.Shell...)
+	}
+	return []string{"/bin/sh", "-c"}
+}
+
+// shellCommandLine returns the command line of a shell form RUN, which runs in the
+// shell set with SHELL or /bin/sh. Heredocs are passed on to the shell.
+func shellCommandLine(config *v1.Config, cmdRun *instructions.RunCommand) []string {
+	cmd := strings.Join(cmdRun.CmdLine, " ")
+	for _, h := range cmdRun.Files {
+		cmd += "\n" + h.Data + h.Name
+	}
+	return append(runShell(config), cmd)
+}
+
+// heredocScript returns the heredoc of a RUN that consists of nothing but a
+// single heredoc, ie. 'RUN <<EOF', which is run as script.
+func heredocScript(cmdRun *instructions.RunCommand) *instructions.ShellInlineFile {
+	if len(cmdRun.Files) != 1 {
+		return nil
+	}
+	heredoc := parser.MustParseHeredoc(strings.Join(cmdRun.CmdLine, " "))
+	if heredoc == nil || heredoc.Name != cmdRun.Files[0].Name {
+		return nil
+	}
+	return &cmdRun.Files[0]
+}
+
+// scriptCommandLine writes the heredoc to an executable script and returns the
+// command line running it. Scripts with a shebang line run in their interpreter,
+// others in the shell set with SHELL or /bin/sh.
+func scriptCommandLine(config *v1.Config, h *instructions.ShellInlineFile) ([]string, string, error) {
+	if err := os.MkdirAll(kConfig.KanikoHeredocDir, 0755); err != nil {
+		return nil, "", errors.Wrap(err, "creating heredoc directory")
+	}
+	f, err := os.CreateTemp(kConfig.KanikoHeredocDir, "run-")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "creating heredoc script")
+	}
+	script := f.Name()
+	data := h.Data
+	if h.Chomp {
+		data = parser.ChompHeredocContent(data)
+	}
+	_, err = f.WriteString(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// the script runs as the user of the RUN
+		err = os.Chmod(script, 0755)
+	}
+	if err != nil {
+		os.Remove(script)
+		return nil, "", errors.Wrap(err, "writing heredoc script")
+	}
+	if strings.HasPrefix(data, "#!") {
+		return []string{script}, script, nil
+	}
+	// the shell reads the script from the file instead of the -c argument
+	shell := runShell(config)
+	if len(shell) > 1 && shell[len(shell)-1] == "-c" {
+		shell = shell[:len(shell)-1]
+	}
+	return append(shell, script), script, nil
+}
+
+func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand, fileContext util.FileContext) error {
+	var newCommand []string
+	if h := heredocScript(cmdRun); cmdRun.PrependShell && h != nil {
+		var script string
+		var err error
+		newCommand, script, err = scriptCommandLine(config, h)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(script)
+	} else if cmdRun.PrependShell {
+		newCommand = shellCommandLine(config, cmdRun)
 	} else {
 		if len(cmdRun.Files) > 0 {
 			// https://github.com/GoogleContainerTools/kaniko/issues/1713
-			logrus.Warnf("#1713 kaniko does not support heredoc syntax in 'RUN [\"<command>\", ...]' (Exec Form) statements: %v", cmdRun.Files[0].Name)
+			diagnostics.Warnf(diagnostics.UnsupportedSyntax, "#1713 kaniko does not support heredoc syntax in 'RUN [\"<command>\", ...]' (Exec Form) statements: %v", cmdRun.Files[0].Name)
 		}
 		newCommand = cmdRun.CmdLine
 		// Find and set absolute path of executable by setting PATH temporary
@@ -160,6 +353,7 @@ func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun
 	logrus.Infof("Cmd: %s", newCommand[0])
 	logrus.Infof("Args: %s", newCommand[1:])
 
+	newCommand = umaskCommandLine(newCommand, fileContext.RunUmask)
 	cmd := exec.Command(newCommand[0], newCommand[1:]...)
 
 	cmd.Dir = setWorkDirIfExists(config.WorkingDir)
@@ -187,8 +381,18 @@ func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun
 	if err != nil {
 		return errors.Wrap(err, "adding default HOME variable")
 	}
+	// the variables of --env are only set for the command, they override the
+	// ones of the image as the last value of a variable is used
+	for _, e := range dockerfile.RunEnvs(cmdRun) {
+		resolved, err := util.ResolveEnvironmentReplacement(e, replacementEnvs, false)
+		if err != nil {
+			return errors.Wrapf(err, "resolving --env %s", e)
+		}
+		env = append(env, resolved)
+	}
 
 	cmd.Env = env
+	isolated := fileContext.HermeticRun && isolateNetwork(cmd)
 
 	logrus.Infof("Running: %s", cmd.Args)
 	if err := cmd.Start(); err != nil {
@@ -199,7 +403,16 @@ func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun
 	if err != nil {
 		return errors.Wrap(err, "getting group id for process")
 	}
+	done := make(chan struct{})
+	defer close(done)
+	go terminateOnInterrupt(pgid, done)
 	if err := cmd.Wait(); err != nil {
+		if interrupted := util.CheckInterrupted(); interrupted != nil {
+			return interrupted
+		}
+		if isolated {
+			return errors.Wrap(err, "waiting for process to exit, it had no network access with --hermetic-run")
+		}
 		return errors.Wrap(err, "waiting for process to exit")
 	}
 
@@ -210,6 +423,39 @@ func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun
 	return nil
 }
 
+// umaskCommandLine returns the command line that runs command with umask, if set,
+// by a shell of the image that sets it and execs command. The umask of kaniko is
+// shared by all of its threads, so it is left alone.
+func umaskCommandLine(command []string, umask *int) []string {
+	if umask == nil {
+		return command
+	}
+	return append([]string{"/bin/sh", "-c", fmt.Sprintf(`umask %03o && exec "$@"`, *umask), "sh"}, command...)
+}
+
+// runTerminateGracePeriod is how long the processes of an interrupted RUN may take
+// to exit after SIGTERM before they are killed.
+var runTerminateGracePeriod = 5 * time.Second
+
+// terminateOnInterrupt sends SIGTERM to the process group pgid once the build is
+// interrupted, and SIGKILL if it is still running after the grace period.
+func terminateOnInterrupt(pgid int, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-util.BuildContext().Done():
+	}
+	logrus.Warnf("Build interrupted, terminating the running command")
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(runTerminateGracePeriod):
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
+
 // addDefaultHOME adds the default value for HOME if it isn't already set
 func addDefaultHOME(u string, envs []string) ([]string, error) {
 	for _, env := range envs {
@@ -365,6 +611,26 @@ func swapDir(pathA, pathB string) (err error) {
 	return nil
 }
 
+// ensureFile creates an empty file at target, if it does not exist yet,
+// and returns the first path it created.
+func ensureFile(target string) (string, error) {
+	created, err := ensureDir(filepath.Dir(target))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(target); !os.IsNotExist(err) {
+		return created, err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	if created == "" {
+		created = target
+	}
+	return created, f.Close()
+}
+
 func ensureDir(target string) (string, error) {
 	var firstCreated = ""
 	curr := target
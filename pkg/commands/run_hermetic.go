@@ -0,0 +1,56 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
+	"github.com/sirupsen/logrus"
+)
+
+// networkNamespaces tells if processes can be started in a network namespace of
+// their own, which requires CAP_SYS_ADMIN. It is only checked once.
+var networkNamespaces = sync.OnceValue(func() bool {
+	result := make(chan error)
+	go func() {
+		// the namespace of the locked thread is changed, so it is never unlocked
+		// and exits with the goroutine instead of being reused
+		runtime.LockOSThread()
+		result <- syscall.Unshare(syscall.CLONE_NEWNET)
+	}()
+	if err := <-result; err != nil {
+		logrus.Debugf("Creating a network namespace failed: %v", err)
+		return false
+	}
+	return true
+})
+
+// isolateNetwork starts cmd in a network namespace of its own, with --hermetic-run,
+// so that the command can't reach the network, not even the loopback interface.
+// Where that isn't possible the command runs with the network of kaniko.
+func isolateNetwork(cmd *exec.Cmd) bool {
+	if !networkNamespaces() {
+		diagnostics.Warnf(diagnostics.HermeticRunUnavailable, "--hermetic-run can't isolate %s from the network without CAP_SYS_ADMIN, running it with network access", cmd.Path)
+		return false
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	return true
+}
@@ -28,9 +28,10 @@ import (
 
 type RunMarkerCommand struct {
 	BaseCommand
-	cmd      *instructions.RunCommand
-	Files    []string
-	shdCache bool
+	cmd         *instructions.RunCommand
+	fileContext util.FileContext
+	Files       []string
+	shdCache    bool
 }
 
 func (r *RunMarkerCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
@@ -40,7 +41,7 @@ func (r *RunMarkerCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfi
 	if err != nil {
 		return err
 	}
-	if err := runCommandWithFlags(config, buildArgs, r.cmd); err != nil {
+	if err := runCommandWithFlags(config, buildArgs, r.cmd, r.fileContext); err != nil {
 		return err
 	}
 	_, r.Files, err = util.GetFSInfoMap("/", prevFilesMap)
@@ -51,6 +52,12 @@ func (r *RunMarkerCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfi
 	return nil
 }
 
+// FilesUsedFromContext returns the files bind mounted from other stages,
+// so that changes to them invalidate the cached layer.
+func (r *RunMarkerCommand) FilesUsedFromContext(config *v1.Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
+	return bindMountFiles(config, buildArgs, r.cmd, r.fileContext)
+}
+
 // String returns some information about the command for the image config
 func (r *RunMarkerCommand) String() string {
 	return r.cmd.String()
@@ -19,16 +19,24 @@ package commands
 import (
 	"archive/tar"
 	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	kConfig "github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
 )
 
@@ -315,8 +323,382 @@ func Test_CachingRunCommand_ExecuteCommand(t *testing.T) {
 	}
 }
 
+func Test_shellCommandLine(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		dockerfile string
+		expected   []string
+	}{
+		{
+			name:       "default shell",
+			dockerfile: "FROM scratch\nRUN echo $0",
+			expected:   []string{"/bin/sh", "-c", "echo $0"},
+		},
+		{
+			name:       "SHELL",
+			dockerfile: "FROM scratch\nSHELL [\"/bin/bash\", \"-c\"]\nRUN echo $0",
+			expected:   []string{"/bin/bash", "-c", "echo $0"},
+		},
+		{
+			name:       "SHELL with options",
+			dockerfile: "FROM scratch\nSHELL [\"/bin/bash\", \"-eo\", \"pipefail\", \"-c\"]\nRUN echo $0",
+			expected:   []string{"/bin/bash", "-eo", "pipefail", "-c", "echo $0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stages, _, err := dockerfile.Parse([]byte(tc.dockerfile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &v1.Config{}
+			var run *instructions.RunCommand
+			for _, c := range stages[0].Commands {
+				switch c := c.(type) {
+				case *instructions.ShellCommand:
+					cmd := &ShellCommand{cmd: c}
+					testutil.CheckNoError(t, cmd.ExecuteCommand(config, dockerfile.NewBuildArgs(nil)))
+				case *instructions.RunCommand:
+					run = c
+				}
+			}
+			shell := append([]string{}, config.Shell...)
+			testutil.CheckDeepEqual(t, tc.expected, shellCommandLine(config, run))
+			testutil.CheckDeepEqual(t, shell, append([]string{}, config.Shell...))
+		})
+	}
+}
+
+func TestRunCommand_ExecuteCommand_shell(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nSHELL [\"/bin/bash\", \"-c\"]\nRUN echo $0 > " + out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &v1.Config{}
+	shell := &ShellCommand{cmd: stages[0].Commands[0].(*instructions.ShellCommand)}
+	testutil.CheckNoError(t, shell.ExecuteCommand(config, dockerfile.NewBuildArgs(nil)))
+	run := &RunCommand{cmd: stages[0].Commands[1].(*instructions.RunCommand)}
+	testutil.CheckNoError(t, run.ExecuteCommand(config, dockerfile.NewBuildArgs(nil)))
+
+	b, err := os.ReadFile(out)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "/bin/bash\n", string(b))
+}
+
+func TestRunCommand_ExecuteCommand_heredoc(t *testing.T) {
+	original := kConfig.KanikoHeredocDir
+	defer func() { kConfig.KanikoHeredocDir = original }()
+	kConfig.KanikoHeredocDir = filepath.Join(t.TempDir(), "heredocs")
+
+	for _, tc := range []struct {
+		name     string
+		run      string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name: "script",
+			run:  "RUN <<EOF\nmkdir -p {{dir}}/sub\necho one > {{dir}}/sub/a\necho two > {{dir}}/b\nEOF",
+			expected: map[string]string{
+				"sub/a": "one\n",
+				"b":     "two\n",
+			},
+		},
+		{
+			name:     "script in the shell",
+			run:      "SHELL [\"/bin/bash\", \"-eo\", \"pipefail\", \"-c\"]\nRUN <<EOF\necho before > {{dir}}/before\nfalse\necho after > {{dir}}/after\nEOF",
+			expected: map[string]string{"before": "before\n"},
+			wantErr:  true,
+		},
+		{
+			name:     "shebang",
+			run:      "SHELL [\"/bin/false\", \"-c\"]\nRUN <<EOF\n#!/bin/sh\necho interpreter > {{dir}}/out\nEOF",
+			expected: map[string]string{"out": "interpreter\n"},
+		},
+		{
+			name:     "chomped script",
+			run:      "RUN <<-EOF\n\techo chomped > {{dir}}/out\n\tEOF",
+			expected: map[string]string{"out": "chomped\n"},
+		},
+		{
+			name: "multiple heredocs",
+			run:  "RUN cat <<A > {{dir}}/a && cat <<B > {{dir}}/b\nfirst\nA\nsecond\nB",
+			expected: map[string]string{
+				"a": "first\n",
+				"b": "second\n",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stages, _, err := dockerfile.Parse([]byte("# syntax=docker/dockerfile:1\nFROM scratch\n" + strings.ReplaceAll(tc.run, "{{dir}}", dir)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &v1.Config{}
+			for _, c := range stages[0].Commands {
+				switch c := c.(type) {
+				case *instructions.ShellCommand:
+					cmd := &ShellCommand{cmd: c}
+					testutil.CheckNoError(t, cmd.ExecuteCommand(config, dockerfile.NewBuildArgs(nil)))
+				case *instructions.RunCommand:
+					cmd := &RunCommand{cmd: c}
+					testutil.CheckError(t, tc.wantErr, cmd.ExecuteCommand(config, dockerfile.NewBuildArgs(nil)))
+				}
+			}
+			for f, content := range tc.expected {
+				b, err := os.ReadFile(filepath.Join(dir, f))
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, content, string(b))
+			}
+			if tc.wantErr {
+				// the options of the shell apply to the script
+				if _, err := os.Stat(filepath.Join(dir, "after")); !os.IsNotExist(err) {
+					t.Errorf("expected the script to stop at the failing command, got %v", err)
+				}
+			}
+			// the scripts are removed once they ran
+			scripts, err := os.ReadDir(kConfig.KanikoHeredocDir)
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, 0, len(scripts))
+		})
+	}
+}
+
 func TestSetWorkDirIfExists(t *testing.T) {
 	testDir := t.TempDir()
 	testutil.CheckDeepEqual(t, testDir, setWorkDirIfExists(testDir))
 	testutil.CheckDeepEqual(t, "", setWorkDirIfExists("doesnot-exists"))
 }
+
+func TestRunCommand_ExecuteCommand_cacheMount(t *testing.T) {
+	tmp := t.TempDir()
+	origCacheDir, origSwapDir := kConfig.KanikoCacheDir, kConfig.KanikoSwapDir
+	defer func() {
+		kConfig.KanikoCacheDir, kConfig.KanikoSwapDir = origCacheDir, origSwapDir
+	}()
+	kConfig.KanikoCacheDir = filepath.Join(tmp, "caches")
+	kConfig.KanikoSwapDir = filepath.Join(tmp, "swap")
+
+	root := filepath.Join(tmp, "root")
+	existing := filepath.Join(root, "existing")
+	if err := os.MkdirAll(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(existing, "keep"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(root, "var", "cache", "app")
+	out := filepath.Join(tmp, "out")
+
+	run := func(t *testing.T, line string) {
+		t.Helper()
+		stages, _, err := dockerfile.Parse([]byte("FROM scratch\n" + line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := &RunCommand{cmd: stages[0].Commands[0].(*instructions.RunCommand)}
+		err = cmd.ExecuteCommand(&v1.Config{}, dockerfile.NewBuildArgs(nil))
+		testutil.CheckNoError(t, err)
+	}
+
+	// first build writes to the cache, the target does not end up in the layer
+	run(t, "RUN --mount=type=cache,id=app,target="+created+" echo cached > "+created+"/file")
+	if _, err := os.Stat(filepath.Join(root, "var")); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed after RUN, got %v", created, err)
+	}
+
+	// second build sees the cache under the same id, even at a different target
+	run(t, "RUN --mount=type=cache,id=app,target="+existing+" cat "+existing+"/file > "+out)
+	b, err := os.ReadFile(out)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "cached\n", string(b))
+	if _, err := os.Stat(filepath.Join(existing, "file")); !os.IsNotExist(err) {
+		t.Errorf("expected cached file not to be left in %s, got %v", existing, err)
+	}
+	b, err = os.ReadFile(filepath.Join(existing, "keep"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "keep", string(b))
+
+	// without an id the cache is keyed by the target
+	run(t, "RUN --mount=type=cache,target="+existing+" test ! -e "+existing+"/file")
+}
+
+func TestRunCommand_ExecuteCommand_env(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN --env=WHO=$NAME --env GREETING=hello echo \"$GREETING $WHO\" > " + out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCmd := stages[0].Commands[0].(*instructions.RunCommand)
+	testutil.CheckDeepEqual(t, []string{"WHO=$NAME", "GREETING=hello"}, dockerfile.RunEnvs(runCmd))
+
+	cfg := &v1.Config{Env: []string{"NAME=world", "GREETING=hi"}}
+	cmd := &RunCommand{cmd: runCmd}
+	err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs(nil))
+	testutil.CheckNoError(t, err)
+	b, err := os.ReadFile(out)
+	testutil.CheckNoError(t, err)
+	// the variables are resolved and override the ones of the image for the command only
+	testutil.CheckDeepEqual(t, "hello world\n", string(b))
+	testutil.CheckDeepEqual(t, []string{"NAME=world", "GREETING=hi"}, cfg.Env)
+}
+
+func TestRunCommand_ExecuteCommand_hermetic(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	if !networkNamespaces() {
+		t.Skip("network namespaces require CAP_SYS_ADMIN")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "downloaded")
+	}))
+	defer server.Close()
+
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN curl -fsS --max-time 5 " + server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCmd := stages[0].Commands[0].(*instructions.RunCommand)
+	for _, tc := range []struct {
+		name     string
+		hermetic bool
+		wantErr  bool
+	}{
+		{name: "with network access"},
+		{name: "hermetic", hermetic: true, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &RunCommand{cmd: runCmd, fileContext: util.FileContext{HermeticRun: tc.hermetic}}
+			err := cmd.ExecuteCommand(&v1.Config{Env: []string{"PATH=" + os.Getenv("PATH")}}, dockerfile.NewBuildArgs(nil))
+			testutil.CheckError(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestRunCommand_ExecuteCommand_bindMount(t *testing.T) {
+	tmp := t.TempDir()
+	origDepsDir, origSwapDir, origMountDir := kConfig.KanikoInterStageDepsDir, kConfig.KanikoSwapDir, kConfig.KanikoBindMountDir
+	defer func() {
+		kConfig.KanikoInterStageDepsDir, kConfig.KanikoSwapDir, kConfig.KanikoBindMountDir = origDepsDir, origSwapDir, origMountDir
+	}()
+	kConfig.KanikoInterStageDepsDir = filepath.Join(tmp, "deps")
+	kConfig.KanikoSwapDir = filepath.Join(tmp, "swap")
+	kConfig.KanikoBindMountDir = filepath.Join(tmp, "mounts")
+
+	// files saved from stage 0 for later use
+	stageDir := filepath.Join(kConfig.KanikoInterStageDepsDir, "0")
+	if err := os.MkdirAll(filepath.Join(stageDir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stageDir, "src", "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stageDir, "go.mod"), []byte("module foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(tmp, "root")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "src")
+	out := filepath.Join(tmp, "out")
+
+	stages, _, err := dockerfile.Parse([]byte(`
+FROM scratch AS builder
+FROM scratch
+RUN --mount=type=bind,from=builder,source=/src,target=` + target + ` cat ` + target + `/file > ` + out + ` && echo modified > ` + target + `/file
+RUN --mount=type=bind,from=builder,source=go.mod,target=` + root + `/go.mod cat ` + root + `/go.mod >> ` + out + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockerfile.ResolveCrossStageCommands(stages[1].Commands, map[string]string{"builder": "0"})
+
+	for _, c := range stages[1].Commands {
+		cmd := &RunCommand{cmd: c.(*instructions.RunCommand)}
+		files, err := cmd.FilesUsedFromContext(&v1.Config{}, dockerfile.NewBuildArgs(nil))
+		testutil.CheckNoError(t, err)
+		if len(files) != 1 || !strings.HasPrefix(files[0], stageDir) {
+			t.Errorf("expected mounted files from %s, got %v", stageDir, files)
+		}
+		err = cmd.ExecuteCommand(&v1.Config{}, dockerfile.NewBuildArgs(nil))
+		testutil.CheckNoError(t, err)
+	}
+
+	b, err := os.ReadFile(out)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "hellomodule foo", string(b))
+
+	// the mounts do not persist in the layer
+	entries, err := os.ReadDir(root)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 0, len(entries))
+
+	// and writes to them do not modify the stage
+	b, err = os.ReadFile(filepath.Join(stageDir, "src", "file"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "hello", string(b))
+}
+
+func Test_bindMountSource(t *testing.T) {
+	origDepsDir := kConfig.KanikoInterStageDepsDir
+	defer func() { kConfig.KanikoInterStageDepsDir = origDepsDir }()
+	kConfig.KanikoInterStageDepsDir = t.TempDir()
+	stageDir := filepath.Join(kConfig.KanikoInterStageDepsDir, "0")
+	if err := os.MkdirAll(filepath.Join(stageDir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/src", filepath.Join(stageDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		source string
+		want   string
+	}{
+		{source: "/src", want: filepath.Join(stageDir, "src")},
+		{source: "src/../../../..", want: stageDir},
+		{source: "../../../etc/passwd", want: filepath.Join(stageDir, "etc", "passwd")},
+		// absolute symlinks are resolved in the stage as well
+		{source: "link/file", want: filepath.Join(stageDir, "src", "file")},
+	} {
+		t.Run(tc.source, func(t *testing.T) {
+			m := &instructions.Mount{Type: instructions.MountTypeBind, From: "0", Source: tc.source}
+			source, err := bindMountSource(m, util.FileContext{})
+			testutil.CheckErrorAndDeepEqual(t, false, err, tc.want, source)
+		})
+	}
+}
+
+func TestRunUmask(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		umask int
+		want  os.FileMode
+	}{
+		{umask: 0o027, want: 0o640},
+		{umask: 0o077, want: 0o600},
+		{umask: 0, want: 0o666},
+	} {
+		t.Run(fmt.Sprintf("%03o", tc.umask), func(t *testing.T) {
+			file := filepath.Join(dir, fmt.Sprintf("file-%o", tc.umask))
+			stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN touch " + file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			umask := tc.umask
+			cmd := &RunCommand{
+				cmd:         stages[0].Commands[0].(*instructions.RunCommand),
+				fileContext: util.FileContext{RunUmask: &umask},
+			}
+			err = cmd.ExecuteCommand(&v1.Config{}, dockerfile.NewBuildArgs(nil))
+			testutil.CheckNoError(t, err)
+			fi, err := os.Stat(file)
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, tc.want, fi.Mode().Perm())
+		})
+	}
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/osscontainertools/kaniko/pkg/constants"
 )
@@ -40,6 +43,10 @@ var DockerfilePath = fmt.Sprintf("%s/Dockerfile", KanikoDir)
 // for example, a tarball from a GCS bucket will be unpacked here
 var BuildContextDir = fmt.Sprintf("%s/buildcontext/", KanikoDir)
 
+// NamedContextsDir is the directory named build contexts read from stdin are unpacked into,
+// ie. --build-context assets=tar://stdin
+var NamedContextsDir = fmt.Sprintf("%s/contexts/", KanikoDir)
+
 // KanikoIntermediateStagesDir is where we will store intermediate stages
 // as tarballs in case they are needed later on
 var KanikoIntermediateStagesDir = fmt.Sprintf("%s/stages/", KanikoDir)
@@ -66,10 +73,31 @@ var KanikoLayersDir = func() string {
 // Contents are stored as-is.
 var KanikoCacheDir = fmt.Sprintf("%s/caches/", KanikoDir)
 
+// CacheMountDir returns the directory in KanikoCacheDir backing the cache mounts
+// with id, which defaults to the target of the mount like in buildkit.
+func CacheMountDir(id string) string {
+	return CacheMountDirIn(KanikoCacheDir, id)
+}
+
+// CacheMountDirIn returns the directory backing the cache mounts with id in dir,
+// which is laid out like KanikoCacheDir.
+func CacheMountDirIn(dir string, id string) string {
+	h := sha256.Sum256([]byte(id))
+	return filepath.Join(dir, hex.EncodeToString(h[:]))
+}
+
 // KanikoSwapDir is a temporary directory used to swap out cache
 // and target directories
 var KanikoSwapDir = fmt.Sprintf("%s/swap/", KanikoDir)
 
+// KanikoBindMountDir is where we copy the files of bind mounts to, ie.
+// RUN --mount=type=bind,from=builder,target=/src
+var KanikoBindMountDir = fmt.Sprintf("%s/mounts/", KanikoDir)
+
+// KanikoHeredocDir is where we write the scripts of RUN heredocs to, ie.
+// RUN <<EOF
+var KanikoHeredocDir = fmt.Sprintf("%s/heredocs/", KanikoDir)
+
 // DockerConfigDir is a where registry credentials are stored
 var DockerConfigDir = fmt.Sprintf("%s/.docker/", KanikoDir)
 
@@ -23,20 +23,25 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 // CacheOptions are base image cache options that are set by command line arguments
 type CacheOptions struct {
-	CacheDir string
-	CacheTTL time.Duration
+	CacheDir     string
+	CacheTTL     time.Duration
+	CacheBackend string
 }
 
 // RegistryOptions are all the options related to the registries, set by command line arguments.
 type RegistryOptions struct {
 	RegistryMaps                 multiKeyMultiValueArg
 	RegistryMirrors              multiArg
+	ReferenceRewrites            keyValueArg
 	InsecureRegistries           multiArg
 	SkipTLSVerifyRegistries      multiArg
+	AllowedRegistries            multiArg
 	RegistriesCertificates       keyValueArg
 	RegistriesClientCertificates keyValueArg
 	SkipDefaultRegistryFallback  bool
@@ -45,9 +50,17 @@ type RegistryOptions struct {
 	InsecurePull                 bool
 	SkipTLSVerifyPull            bool
 	PushIgnoreImmutableTagErrors bool
+	PushAtomic                   bool
+	PushSkipUnchanged            bool
 	PushRetry                    int
+	PushConcurrency              int
 	ImageDownloadRetry           int
+	PullTimeout                  time.Duration
+	PullTLSHandshakeTimeout      time.Duration
+	PullMaxIdleConns             int
 	CredentialHelpers            multiArg
+	RegistryHeaders              keyValueArg
+	RegistryUserAgent            string
 }
 
 // KanikoOptions are options that are set by command line arguments
@@ -55,48 +68,149 @@ type KanikoOptions struct {
 	RegistryOptions
 	CacheOptions
 	Destinations                 multiArg
+	DestinationAuths             keyValueArg
+	DestinationPlatforms         keyValueArg
 	BuildArgs                    multiArg
+	CommandBuildArgs             multiArg
+	SecretPatterns               multiArg
+	HideHistory                  multiArg
+	ExitCodes                    keyValueArg
 	Labels                       multiArg
 	Annotations                  keyValueArg
+	BuildContexts                keyValueArg
 	Git                          KanikoGitOptions
 	IgnorePaths                  multiArg
+	BaseExcludePaths             multiArg
 	DockerfilePath               string
 	SrcContext                   string
+	ContextSHA256                string
+	ContextHTTPHeaders           multiArg
+	ContextMaxRedirects          int
 	SnapshotMode                 string
+	Snapshotter                  string
 	SnapshotModeDeprecated       string
 	CustomPlatform               string
 	CustomPlatformDeprecated     string
+	ImageOS                      string
+	ImageArch                    string
 	Bucket                       string
 	TarPath                      string
 	TarPathDeprecated            string
 	KanikoDir                    string
 	Target                       string
 	CacheRepo                    string
+	CacheFrom                    multiArg
+	CacheExportDir               string
+	CacheImportDir               string
 	DigestFile                   string
+	ExpectedDigest               string
 	ImageNameDigestFile          string
 	ImageNameTagDigestFile       string
 	OCILayoutPath                string
+	SBOMPath                     string
+	DiagnosticsFile              string
+	DumpResolvedDockerfile       string
+	PreprocessDockerfile         bool
+	BuildReportPath              string
+	FileProvenancePath           string
+	RootFSManifestVerify         string
+	RootFSManifestAllow          multiArg
+	Provenance                   string
+	ProvenancePath               string
+	VerifyBaseSignatures         string
+	AllowedBaseDigests           multiArg
+	DefaultDirMode               string
+	DefaultFileMode              string
+	RunUmask                     string
+	HermeticRun                  bool
+	MaxCopyMode                  string
 	Compression                  Compression
 	CompressionLevel             int
+	CacheCompression             Compression
+	ImageFormat                  ImageFormat
 	ImageFSExtractRetry          int
+	ExtractMemoryLimit           string
+	StageExtractConcurrency      int
+	DigestConcurrency            int
+	SnapshotRetry                int
+	WhiteoutStrategy             string
 	SingleSnapshot               bool
+	StreamLayers                 bool
+	KeepEmptyLayers              bool
+	SkipUnchangedCopies          bool
+	CopyBestEffort               bool
+	StrictContext                bool
+	CopyAsRoot                   bool
+	CheckDiskSpace               bool
+	ContextDigestFile            string
+	PushDiffOnly                 bool
+	CheckpointTarPath            string
+	CheckpointCommand            int
+	ResumeStatePath              string
+	MaxLayers                    int
+	MaxInstructions              int
+	MaxStages                    int
+	MaxCopySources               int
+	MaxImageSize                 string
+	WarnSmallLayers              int
+	SmallLayerSize               string
+	DriftCheckImage              string
+	DriftIgnore                  multiArg
+	SplitCopyLayers              string
+	CopyTransforms               multiArg
+	CopyOwners                   multiArg
+	PreserveSourceOwnership      bool
+	PreserveInodeFlags           bool
+	PreserveAccessTimes          bool
+	FollowContextSymlinks        bool
+	MaxSymlinkDepth              int
+	GitCommitMtimes              bool
+	CaseCollisions               CaseCollisionPolicy
+	DuplicateDestinations        DuplicateDestinationPolicy
+	UnusedBuildArgs              UnusedBuildArgPolicy
+	NormalizeEnv                 EnvNormalization
+	DeduplicateAdjacentLayers    bool
+	PrintLayerDiffs              bool
 	Reproducible                 bool
 	NoPush                       bool
+	Lint                         bool
 	NoPushCache                  bool
+	SyncExports                  bool
+	FsyncCopies                  bool
 	Cache                        bool
+	CacheInline                  bool
+	CacheLayerKeys               bool
+	PushMountFromCache           bool
 	PreCleanup                   bool
 	Cleanup                      bool
+	KeepRootOnExit               bool
 	CompressedCaching            bool
 	IgnoreVarRun                 bool
 	SkipUnusedStages             bool
 	RunV2                        bool
 	CacheCopyLayers              bool
+	CacheDecompressedArchives    bool
 	CacheRunLayers               bool
+	CacheRunLayersPortable       bool
+	CacheIgnorePlatform          bool
+	PrefetchBaseImages           bool
 	ForceBuildMetadataDeprecated bool
 	InitialFSUnpacked            bool
 	SkipPushPermissionCheck      bool
 	PreserveContext              bool
+	ReadOnlyContext              bool
 	Materialize                  bool
+	// ConfigMutator is invoked with the config of the final image after all commands ran,
+	// it is not exposed as a flag but allows embedders to rewrite the config programmatically.
+	ConfigMutator func(*v1.Config) error
+}
+
+// BuildContextImagePrefix marks a named build context that refers to an image instead of a directory.
+const BuildContextImagePrefix = "docker-image://"
+
+// BuildContextImage returns the image a named build context refers to, if any.
+func BuildContextImage(value string) (string, bool) {
+	return strings.CutPrefix(value, BuildContextImagePrefix)
 }
 
 type KanikoGitOptions struct {
@@ -180,15 +294,156 @@ func (c *Compression) Type() string {
 	return "compression"
 }
 
+// ImageFormat selects the media types of the manifest, config and layers of the built image
+type ImageFormat string
+
+const (
+	OCIFormat    ImageFormat = "oci"
+	DockerFormat ImageFormat = "docker"
+)
+
+func (f *ImageFormat) String() string {
+	return string(*f)
+}
+
+func (f *ImageFormat) Set(v string) error {
+	switch v {
+	case "oci", "docker":
+		*f = ImageFormat(v)
+		return nil
+	default:
+		return errors.New(`must be either "oci" or "docker"`)
+	}
+}
+
+func (f *ImageFormat) Type() string {
+	return "format"
+}
+
+// CaseCollisionPolicy is what a COPY or ADD does about copied paths that differ
+// from another path only by case, which collide on case-insensitive filesystems
+type CaseCollisionPolicy string
+
+const (
+	CaseCollisionIgnore CaseCollisionPolicy = "ignore"
+	CaseCollisionWarn   CaseCollisionPolicy = "warn"
+	CaseCollisionError  CaseCollisionPolicy = "error"
+)
+
+func (p *CaseCollisionPolicy) String() string {
+	return string(*p)
+}
+
+func (p *CaseCollisionPolicy) Set(v string) error {
+	switch v {
+	case "ignore", "warn", "error":
+		*p = CaseCollisionPolicy(v)
+		return nil
+	default:
+		return errors.New(`must be one of "ignore", "warn" or "error"`)
+	}
+}
+
+func (p *CaseCollisionPolicy) Type() string {
+	return "policy"
+}
+
+// DuplicateDestinationPolicy is what a COPY or ADD does about sources it copies
+// to the same destination file, of which the last one wins
+type DuplicateDestinationPolicy string
+
+const (
+	DuplicateDestinationIgnore DuplicateDestinationPolicy = "ignore"
+	DuplicateDestinationWarn   DuplicateDestinationPolicy = "warn"
+	DuplicateDestinationError  DuplicateDestinationPolicy = "error"
+)
+
+func (p *DuplicateDestinationPolicy) String() string {
+	return string(*p)
+}
+
+func (p *DuplicateDestinationPolicy) Set(v string) error {
+	switch v {
+	case "ignore", "warn", "error":
+		*p = DuplicateDestinationPolicy(v)
+		return nil
+	default:
+		return errors.New(`must be one of "ignore", "warn" or "error"`)
+	}
+}
+
+func (p *DuplicateDestinationPolicy) Type() string {
+	return "policy"
+}
+
+// UnusedBuildArgPolicy is what a build does about build args no ARG of the
+// Dockerfile declares, which are often typos
+type UnusedBuildArgPolicy string
+
+const (
+	UnusedBuildArgIgnore UnusedBuildArgPolicy = "ignore"
+	UnusedBuildArgWarn   UnusedBuildArgPolicy = "warn"
+	UnusedBuildArgError  UnusedBuildArgPolicy = "error"
+)
+
+func (p *UnusedBuildArgPolicy) String() string {
+	return string(*p)
+}
+
+func (p *UnusedBuildArgPolicy) Set(v string) error {
+	switch v {
+	case "ignore", "warn", "error":
+		*p = UnusedBuildArgPolicy(v)
+		return nil
+	default:
+		return errors.New(`must be one of "ignore", "warn" or "error"`)
+	}
+}
+
+func (p *UnusedBuildArgPolicy) Type() string {
+	return "policy"
+}
+
+// EnvNormalization is how the env of the final image is normalized, the env is
+// kept as it is if unset.
+type EnvNormalization string
+
+const (
+	// EnvDedupe keeps the last entry of each key at the position of its first.
+	EnvDedupe EnvNormalization = "dedupe"
+	// EnvSort dedupes the env and sorts it by key.
+	EnvSort EnvNormalization = "sort"
+)
+
+func (n *EnvNormalization) String() string {
+	return string(*n)
+}
+
+func (n *EnvNormalization) Set(v string) error {
+	switch v {
+	case "dedupe", "sort":
+		*n = EnvNormalization(v)
+		return nil
+	default:
+		return errors.New(`must be one of "dedupe" or "sort"`)
+	}
+}
+
+func (n *EnvNormalization) Type() string {
+	return "normalization"
+}
+
 // WarmerOptions are options that are set by command line arguments to the cache warmer.
 type WarmerOptions struct {
 	CacheOptions
 	RegistryOptions
-	CustomPlatform string
-	Images         multiArg
-	Force          bool
-	DockerfilePath string
-	BuildArgs      multiArg
+	CustomPlatform     string
+	Images             multiArg
+	Force              bool
+	DockerfilePath     string
+	BuildArgs          multiArg
+	PrimePackageCaches bool
+	PruneCache         bool
 }
 
 func EnvBool(key string) bool {
@@ -0,0 +1,117 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadOptionsFile sets the flags that were not set on the command line to the values
+// of the YAML or JSON file at path. Its keys are flag names, lists set a flag once per
+// item and maps set it once per `key=value` pair, ie.
+//
+//	destination: [gcr.io/my-repo/my-image]
+//	cache: true
+//	registry-certificate:
+//	  my.registry.url: /path/to/the/certificate
+//
+// Keys that are no flags are rejected, they are likely typos.
+func LoadOptionsFile(path string, flags *pflag.FlagSet) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading options file")
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return errors.Wrapf(err, "parsing options file %s", path)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flag := flags.Lookup(key)
+		if flag == nil {
+			return fmt.Errorf("unknown option %q in %s", key, path)
+		}
+		if flag.Changed {
+			// flags on the command line override the file
+			continue
+		}
+		settings, err := flagSettings(values[key])
+		if err != nil {
+			return errors.Wrapf(err, "option %q in %s", key, path)
+		}
+		for _, s := range settings {
+			if err := flags.Set(key, s); err != nil {
+				return errors.Wrapf(err, "option %q in %s", key, path)
+			}
+		}
+	}
+	return nil
+}
+
+// flagSettings returns the values to set a flag to for value of the options file.
+func flagSettings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		settings := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return nil, err
+			}
+			settings = append(settings, s)
+		}
+		return settings, nil
+	case map[string]interface{}:
+		settings := make([]string, 0, len(v))
+		for k, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return nil, err
+			}
+			settings = append(settings, k+"="+s)
+		}
+		sort.Strings(settings)
+		return settings, nil
+	default:
+		s, err := scalar(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+func scalar(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("nested value %v is not supported", value)
+	case nil:
+		return "", nil
+	}
+	return fmt.Sprint(value), nil
+}
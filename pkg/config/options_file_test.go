@@ -0,0 +1,118 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/spf13/pflag"
+)
+
+func optionsFlags(opts *KanikoOptions) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("executor", pflag.ContinueOnError)
+	flags.VarP(&opts.Destinations, "destination", "d", "")
+	flags.BoolVar(&opts.NoPush, "no-push", false, "")
+	flags.StringVar(&opts.DockerfilePath, "dockerfile", "Dockerfile", "")
+	flags.DurationVar(&opts.CacheTTL, "cache-ttl", time.Hour*336, "")
+	flags.VarP(&opts.BuildArgs, "build-arg", "", "")
+	opts.RegistriesCertificates = make(map[string]string)
+	flags.VarP(&opts.RegistriesCertificates, "registry-certificate", "", "")
+	return flags
+}
+
+func writeOptionsFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOptionsFile(t *testing.T) {
+	yamlFile := `
+destination:
+  - gcr.io/foo/bar:latest
+  - gcr.io/foo/bar:v1
+no-push: true
+dockerfile: from/file/Dockerfile
+cache-ttl: 6h
+build-arg: [A=1, B=2]
+registry-certificate:
+  my.registry.url: /path/to/cert
+`
+	jsonFile := `{"destination": ["gcr.io/foo/bar:latest", "gcr.io/foo/bar:v1"], "no-push": true,
+"dockerfile": "from/file/Dockerfile", "cache-ttl": "6h", "build-arg": ["A=1", "B=2"],
+"registry-certificate": {"my.registry.url": "/path/to/cert"}}`
+
+	expected := KanikoOptions{
+		Destinations:   multiArg{"gcr.io/foo/bar:latest", "gcr.io/foo/bar:v1"},
+		NoPush:         true,
+		DockerfilePath: "from/file/Dockerfile",
+		CacheOptions:   CacheOptions{CacheTTL: 6 * time.Hour},
+		BuildArgs:      multiArg{"A=1", "B=2"},
+		RegistryOptions: RegistryOptions{
+			RegistriesCertificates: keyValueArg{"my.registry.url": "/path/to/cert"},
+		},
+	}
+	for name, content := range map[string]string{"kaniko.yaml": yamlFile, "kaniko.json": jsonFile} {
+		t.Run(name, func(t *testing.T) {
+			opts := &KanikoOptions{}
+			flags := optionsFlags(opts)
+			testutil.CheckNoError(t, flags.Parse(nil))
+			testutil.CheckNoError(t, LoadOptionsFile(writeOptionsFile(t, name, content), flags))
+			testutil.CheckDeepEqual(t, expected, *opts)
+		})
+	}
+}
+
+func TestLoadOptionsFile_FlagsOverride(t *testing.T) {
+	opts := &KanikoOptions{}
+	flags := optionsFlags(opts)
+	testutil.CheckNoError(t, flags.Parse([]string{"--dockerfile", "from/flag/Dockerfile"}))
+	path := writeOptionsFile(t, "kaniko.yaml", `
+dockerfile: from/file/Dockerfile
+no-push: true
+`)
+	testutil.CheckNoError(t, LoadOptionsFile(path, flags))
+	testutil.CheckDeepEqual(t, "from/flag/Dockerfile", opts.DockerfilePath)
+	testutil.CheckDeepEqual(t, true, opts.NoPush)
+}
+
+func TestLoadOptionsFile_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{name: "unknown key", content: "no-psuh: true"},
+		{name: "invalid value", content: "no-push: maybe"},
+		{name: "nested value", content: "destination: [[gcr.io/foo/bar]]"},
+		{name: "not a map", content: "- no-push"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &KanikoOptions{}
+			flags := optionsFlags(opts)
+			testutil.CheckNoError(t, flags.Parse(nil))
+			err := LoadOptionsFile(writeOptionsFile(t, "kaniko.yaml", tc.content), flags)
+			testutil.CheckError(t, true, err)
+		})
+	}
+}
@@ -43,6 +43,14 @@ const (
 	GitBuildContextPrefix      = "git://"
 	HTTPSBuildContextPrefix    = "https://"
 
+	// DefaultMaxSymlinkDepth is how many symlinks resolving a path follows before
+	// it fails like with ELOOP, it is the MAXSYMLINKS of Linux.
+	DefaultMaxSymlinkDepth = 40
+
+	// DefaultSmallLayerSize is the compressed size below which --warn-small-layers
+	// counts a layer as small.
+	DefaultSmallLayerSize = "10KB"
+
 	HOME = "HOME"
 	// DefaultHOMEValue is the default value Docker sets for $HOME
 	DefaultHOMEValue = "/root"
@@ -0,0 +1,61 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package creds
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/pkg/errors"
+)
+
+// fileCredentials is the content of a credentials file set with --destination-auth
+type fileCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+type fileKeychain struct {
+	auth authn.Authenticator
+}
+
+// NewFileKeychain returns a keychain that authenticates with the credentials in the
+// JSON file at path, either a username and password or a registry token.
+func NewFileKeychain(path string) (authn.Keychain, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading credentials file")
+	}
+	var c fileCredentials
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrapf(err, "parsing credentials file %s", path)
+	}
+	switch {
+	case c.Token != "":
+		return &fileKeychain{auth: authn.FromConfig(authn.AuthConfig{RegistryToken: c.Token})}, nil
+	case c.Username != "" && c.Password != "":
+		return &fileKeychain{auth: authn.FromConfig(authn.AuthConfig{Username: c.Username, Password: c.Password})}, nil
+	}
+	return nil, fmt.Errorf("credentials file %s must contain a token or a username and password", path)
+}
+
+func (k *fileKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.auth, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Code identifies a kind of diagnostic, codes don't change between releases.
+type Code string
+
+const (
+	// CaseCollision is a copied path that differs from another path only by case,
+	// see --case-collisions.
+	CaseCollision Code = "case-collision"
+	// ChownUnresolved is a user or group of the ownership of files that is not in the
+	// passwd or group file, its numeric id is used.
+	ChownUnresolved Code = "chown-unresolved"
+	// DeprecatedInstruction is an instruction that is deprecated and skipped, ie. MAINTAINER.
+	DeprecatedInstruction Code = "deprecated-instruction"
+	// DuplicateDestination is a destination file a COPY copies several sources to,
+	// see --duplicate-destinations.
+	DuplicateDestination Code = "duplicate-destination"
+	// EmptyCopy is a COPY whose sources matched no files.
+	EmptyCopy Code = "empty-copy"
+	// HermeticRunUnavailable is a RUN that has network access despite --hermetic-run,
+	// as kaniko can't isolate it.
+	HermeticRunUnavailable Code = "hermetic-run-unavailable"
+	// InodeFlagsSkipped is a copied file whose inode flags could not be preserved
+	// with --preserve-inode-flags.
+	InodeFlagsSkipped Code = "inode-flags-skipped"
+	// SmallLayers is a build that added many small layers, see --warn-small-layers.
+	SmallLayers Code = "small-layers"
+	// SkippedSources is a COPY that skipped sources with --copy-best-effort.
+	SkippedSources Code = "skipped-sources"
+	// UnusedBuildArg is a build arg no ARG of the Dockerfile declares, see --unused-build-args.
+	UnusedBuildArg Code = "unused-build-arg"
+	// UnsupportedFlag is an instruction flag kaniko ignores.
+	UnsupportedFlag Code = "unsupported-flag"
+	// UnsupportedSyntax is Dockerfile syntax kaniko ignores.
+	UnsupportedSyntax Code = "unsupported-syntax"
+)
+
+const severityWarning = "warning"
+
+// Diagnostic is a single warning of the build.
+type Diagnostic struct {
+	Code     Code   `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+var (
+	mu          sync.Mutex
+	diagnostics []Diagnostic
+)
+
+// Warnf logs a warning with code and records it.
+func Warnf(code Code, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logrus.WithField("code", code).Warn(msg)
+	mu.Lock()
+	defer mu.Unlock()
+	diagnostics = append(diagnostics, Diagnostic{Code: code, Severity: severityWarning, Message: msg})
+}
+
+// All returns the diagnostics recorded since the last Reset.
+func All() []Diagnostic {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Diagnostic{}, diagnostics...)
+}
+
+// Reset drops the recorded diagnostics.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	diagnostics = nil
+}
+
+// WriteFile writes the recorded diagnostics to path as JSON array.
+func WriteFile(path string) error {
+	b, err := json.MarshalIndent(All(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing diagnostics file")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnostics
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestWriteFile(t *testing.T) {
+	Reset()
+	defer Reset()
+	path := filepath.Join(t.TempDir(), "diagnostics.json")
+
+	testutil.CheckNoError(t, WriteFile(path))
+	b, err := os.ReadFile(path)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "[]\n", string(b))
+
+	Warnf(EmptyCopy, "%s matched no files", "COPY *.txt /dst/")
+	Warnf(DeprecatedInstruction, "MAINTAINER is deprecated, skipping")
+	testutil.CheckNoError(t, WriteFile(path))
+	b, err = os.ReadFile(path)
+	testutil.CheckNoError(t, err)
+	var got []Diagnostic
+	testutil.CheckNoError(t, json.Unmarshal(b, &got))
+	testutil.CheckDeepEqual(t, []Diagnostic{
+		{Code: "empty-copy", Severity: "warning", Message: "COPY *.txt /dst/ matched no files"},
+		{Code: "deprecated-instruction", Severity: "warning", Message: "MAINTAINER is deprecated, skipping"},
+	}, got)
+}
@@ -52,6 +52,8 @@ type BuildArgs struct {
 	referencedArgs map[string]struct{}
 	// args provided by the user on the command line
 	argsFromOptions map[string]*string
+	// args that only apply to a single command, they take precedence over all others
+	commandArgs map[string]*string
 }
 
 func NewBuildArgs(args []string) *BuildArgs {
@@ -67,6 +69,7 @@ func newBuildArgsFromMap(argsFromOptions map[string]*string) *BuildArgs {
 		predefinedArgs:   make(map[string]*string),
 		referencedArgs:   make(map[string]struct{}),
 		argsFromOptions:  argsFromOptions,
+		commandArgs:      make(map[string]*string),
 	}
 }
 
@@ -85,6 +88,9 @@ func (b *BuildArgs) Clone() *BuildArgs {
 	for k := range b.referencedArgs {
 		result.referencedArgs[k] = struct{}{}
 	}
+	for k, v := range b.commandArgs {
+		result.commandArgs[k] = v
+	}
 	return result
 }
 
@@ -114,6 +120,20 @@ func (b *BuildArgs) AddArg(key string, value *string) {
 	b.referencedArgs[key] = struct{}{}
 }
 
+// AddCommandArgs adds args given as ["key=value"] that take precedence over all other args,
+// they are meant to be added to a clone used for a single command.
+func (b *BuildArgs) AddCommandArgs(args []string) {
+	for key, value := range convertKVStringsToMap(args) {
+		if value == nil {
+			continue
+		}
+		b.commandArgs[key] = value
+		if _, ok := b.allowedBuildArgs[key]; !ok {
+			b.allowedBuildArgs[key] = value
+		}
+	}
+}
+
 // GetAllAllowed returns a mapping with all the allowed args
 func (b *BuildArgs) GetAllAllowed() map[string]string {
 	return b.getAllFromMapping(b.allowedBuildArgs)
@@ -152,6 +172,9 @@ func (b *BuildArgs) FilterAllowed(filter []string) []string {
 
 func (b *BuildArgs) getBuildArg(key string, mapping map[string]*string) (string, bool) {
 	defaultValue, exists := mapping[key]
+	if v, ok := b.commandArgs[key]; ok && exists {
+		return *v, ok
+	}
 	// Return override from options if one is defined
 	if v, ok := b.argsFromOptions[key]; ok && v != nil {
 		return *v, ok
@@ -119,3 +119,24 @@ func TestGetAllMeta(t *testing.T) {
 	}
 	testutil.CheckDeepEqual(t, expected, all)
 }
+
+func TestAddCommandArgs(t *testing.T) {
+	buildArgs := newBuildArgsFromMap(map[string]*string{
+		"ArgFromOptions": strPtr("fromopt"),
+	})
+	buildArgs.AddArg("ArgFromOptions", strPtr("default"))
+	buildArgs.AddArg("ArgInDockerfile", strPtr("default"))
+
+	scoped := buildArgs.Clone()
+	scoped.AddCommandArgs([]string{"ArgFromOptions=fromcommand", "ArgOnlyForCommand=fromcommand", "ArgWithoutValue"})
+
+	testutil.CheckDeepEqual(t, map[string]string{
+		"ArgFromOptions":    "fromcommand",
+		"ArgInDockerfile":   "default",
+		"ArgOnlyForCommand": "fromcommand",
+	}, scoped.GetAllAllowed())
+	testutil.CheckDeepEqual(t, map[string]string{
+		"ArgFromOptions":  "fromopt",
+		"ArgInDockerfile": "default",
+	}, buildArgs.GetAllAllowed())
+}
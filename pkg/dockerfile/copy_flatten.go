@@ -0,0 +1,66 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/pkg/errors"
+)
+
+// copyFlattenFlag is the COPY flag copying every source file directly into the
+// destination directory, ie. COPY --flatten or COPY --flatten=last-wins
+const copyFlattenFlag = "--flatten"
+
+// FlattenLastWins is the value of --flatten that copies the last of the sources
+// with the same name instead of failing the COPY.
+const FlattenLastWins = "last-wins"
+
+// copyFlattens are the values of the --flatten flags of the COPY instructions.
+var copyFlattens instructionFlags[string]
+
+// rewriteCopyFlatten removes the --flatten flag of each COPY of the AST, as buildkit
+// doesn't know it, and records its value for CopyFlatten.
+func rewriteCopyFlatten(ast *parser.Node) error {
+	for _, node := range ast.Children {
+		if !strings.EqualFold(node.Value, "copy") {
+			continue
+		}
+		flags := node.Flags[:0:0]
+		for _, flag := range node.Flags {
+			name, value, _ := strings.Cut(flag, "=")
+			if name != copyFlattenFlag {
+				flags = append(flags, flag)
+				continue
+			}
+			if value != "" && value != FlattenLastWins {
+				return parser.WithLocation(errors.Errorf("COPY --flatten only takes %s, got %q", FlattenLastWins, value), node.Location())
+			}
+			copyFlattens.record(node, value)
+		}
+		node.Flags = flags
+	}
+	return nil
+}
+
+// CopyFlatten returns true if cmd copies its source files directly into its
+// destination directory, and the value of its --flatten flag.
+func CopyFlatten(cmd *instructions.CopyCommand) (string, bool) {
+	return copyFlattens.lookup(cmd.String())
+}
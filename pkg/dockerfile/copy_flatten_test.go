@@ -0,0 +1,53 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_CopyFlatten(t *testing.T) {
+	tests := []struct {
+		line    string
+		mode    string
+		flatten bool
+		srcs    []string
+		wantErr bool
+	}{
+		{line: "COPY a b /dest/", srcs: []string{"a", "b"}},
+		{line: "COPY --flatten a b /dest/", flatten: true, srcs: []string{"a", "b"}},
+		{line: "COPY --chown=1:1 --flatten=last-wins a /dest/", mode: FlattenLastWins, flatten: true, srcs: []string{"a"}},
+		{line: "COPY --flatten=first-wins a /dest/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			stages, _, err := Parse([]byte("FROM scratch\n" + tt.line))
+			testutil.CheckError(t, tt.wantErr, err)
+			if tt.wantErr {
+				return
+			}
+			cmd := stages[0].Commands[0].(*instructions.CopyCommand)
+			mode, flatten := CopyFlatten(cmd)
+			testutil.CheckDeepEqual(t, tt.flatten, flatten)
+			testutil.CheckDeepEqual(t, tt.mode, mode)
+			testutil.CheckDeepEqual(t, tt.srcs, cmd.SourcePaths)
+		})
+	}
+}
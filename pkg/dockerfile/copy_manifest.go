@@ -0,0 +1,109 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/pkg/errors"
+)
+
+// copyManifestFlag is the COPY flag naming a file of src dest pairs to copy,
+// ie. COPY --from-manifest=paths.txt or COPY --from-manifest paths.txt
+const copyManifestFlag = "--from-manifest"
+
+// copyManifests are the manifest files of the COPY --from-manifest instructions.
+var copyManifests instructionFlags[string]
+
+// rewriteCopyManifests turns each COPY --from-manifest of the AST into a COPY of
+// the manifest file, as buildkit doesn't know the flag, and records the manifest
+// for CopyManifest.
+func rewriteCopyManifests(ast *parser.Node) error {
+	for _, node := range ast.Children {
+		if !strings.EqualFold(node.Value, "copy") {
+			continue
+		}
+		var manifest string
+		var found bool
+		flags := node.Flags[:0:0]
+		for _, flag := range node.Flags {
+			name, value, hasValue := strings.Cut(flag, "=")
+			if name != copyManifestFlag {
+				flags = append(flags, flag)
+				continue
+			}
+			found = true
+			if hasValue {
+				manifest = value
+			} else if node.Next != nil {
+				manifest = node.Next.Value
+				node.Next = node.Next.Next
+			}
+		}
+		if !found {
+			continue
+		}
+		if manifest == "" {
+			return parser.WithLocation(errors.New("COPY --from-manifest requires a manifest file"), node.Location())
+		}
+		if node.Next != nil || len(node.Heredocs) > 0 {
+			return parser.WithLocation(errors.New("COPY --from-manifest takes no sources or destination, they are listed in the manifest"), node.Location())
+		}
+		copyManifests.record(node, manifest)
+		node.Flags = flags
+		// the manifest is the source, so that it is resolved and used from the
+		// context like any other source
+		node.Next = &parser.Node{Value: manifest, Next: &parser.Node{Value: "/"}}
+	}
+	return nil
+}
+
+// CopyManifest returns the manifest file of a COPY --from-manifest, whose pairs
+// are read with ParseCopyManifest.
+func CopyManifest(cmd *instructions.CopyCommand) (string, bool) {
+	return copyManifests.lookup(cmd.String())
+}
+
+// ParseCopyManifest parses the manifest of a COPY --from-manifest, each line of
+// it is a source, relative to the context, and its destination separated by
+// whitespace. Empty lines and lines starting with # are ignored.
+func ParseCopyManifest(r io.Reader) ([]instructions.SourcesAndDest, error) {
+	var pairs []instructions.SourcesAndDest
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("line %d: expected a source and a destination, got %q", line, text)
+		}
+		pairs = append(pairs, instructions.SourcesAndDest{
+			SourcePaths: []string{fields[0]},
+			DestPath:    fields[1],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
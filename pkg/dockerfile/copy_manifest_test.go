@@ -0,0 +1,74 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_CopyManifest(t *testing.T) {
+	tests := []struct {
+		line     string
+		manifest string
+		chown    string
+		isCopy   bool
+		wantErr  bool
+	}{
+		{line: "COPY --from-manifest=paths.txt", manifest: "paths.txt", isCopy: true},
+		{line: "COPY --chown=1:1 --from-manifest paths.txt", manifest: "paths.txt", chown: "1:1", isCopy: true},
+		{line: "COPY a /a"},
+		{line: "COPY a --from-manifest=b /a"},
+		{line: "COPY --from-manifest", wantErr: true},
+		{line: "COPY --from-manifest=paths.txt /dest", wantErr: true},
+		{line: "COPY --from-manifest paths.txt a /dest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			stages, _, err := Parse([]byte("FROM scratch\n" + tt.line))
+			testutil.CheckError(t, tt.wantErr, err)
+			if tt.wantErr {
+				return
+			}
+			cmd := stages[0].Commands[0].(*instructions.CopyCommand)
+			manifest, ok := CopyManifest(cmd)
+			testutil.CheckDeepEqual(t, tt.isCopy, ok)
+			testutil.CheckDeepEqual(t, tt.manifest, manifest)
+			if ok {
+				// the manifest is the only source of the COPY
+				testutil.CheckDeepEqual(t, []string{tt.manifest}, cmd.SourcePaths)
+				testutil.CheckDeepEqual(t, tt.chown, cmd.Chown)
+			}
+		})
+	}
+}
+
+func Test_ParseCopyManifest(t *testing.T) {
+	pairs, err := ParseCopyManifest(strings.NewReader("# src dest\n\na.txt /a\n  dir/*  /opt/dir/  \n"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []instructions.SourcesAndDest{
+		{SourcePaths: []string{"a.txt"}, DestPath: "/a"},
+		{SourcePaths: []string{"dir/*"}, DestPath: "/opt/dir/"},
+	}, pairs)
+
+	_, err = ParseCopyManifest(strings.NewReader("a.txt /a\nb.txt\n"))
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, true, strings.Contains(err.Error(), "line 2"))
+}
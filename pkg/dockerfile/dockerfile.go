@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -32,33 +33,28 @@ import (
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/linter"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/pkg/errors"
 )
 
 func ParseStages(opts *config.KanikoOptions) ([]instructions.Stage, []instructions.ArgCommand, error) {
-	var err error
-	var d []uint8
-	match, _ := regexp.MatchString("^https?://", opts.DockerfilePath)
-	if match {
-		response, e := http.Get(opts.DockerfilePath) //nolint:noctx
-		if e != nil {
-			return nil, nil, e
-		}
-		d, err = io.ReadAll(response.Body)
-	} else {
-		d, err = os.ReadFile(opts.DockerfilePath)
-	}
-
+	d, evaluated, err := loadDockerfile(opts)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, fmt.Sprintf("reading dockerfile at path %s", opts.DockerfilePath))
+		return nil, nil, err
 	}
 
 	stages, metaArgs, err := Parse(d)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "parsing dockerfile")
 	}
+	if err := checkLimits(stages, metaArgs, opts); err != nil {
+		return nil, nil, err
+	}
+	if err := checkUnusedBuildArgs(stages, metaArgs, evaluated, opts); err != nil {
+		return nil, nil, err
+	}
 
 	metaArgs, err = expandNestedArgs(metaArgs, opts.BuildArgs)
 	if err != nil {
@@ -68,6 +64,27 @@ func ParseStages(opts *config.KanikoOptions) ([]instructions.Stage, []instructio
 	return stages, metaArgs, nil
 }
 
+// ReadDockerfile reads the Dockerfile at path, which can also be an http(s) URL.
+func ReadDockerfile(path string) ([]byte, error) {
+	var err error
+	var d []uint8
+	match, _ := regexp.MatchString("^https?://", path)
+	if match {
+		response, e := http.Get(path) //nolint:noctx
+		if e != nil {
+			return nil, e
+		}
+		d, err = io.ReadAll(response.Body)
+	} else {
+		d, err = os.ReadFile(path)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("reading dockerfile at path %s", path))
+	}
+	return d, nil
+}
+
 // baseImageIndex returns the index of the stage the current stage is built off
 // returns -1 if the current stage isn't built off a previous stage
 func baseImageIndex(currentStage int, stages []instructions.Stage) int {
@@ -91,6 +108,15 @@ func Parse(b []byte) ([]instructions.Stage, []instructions.ArgCommand, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := rewriteCopyManifests(p.AST); err != nil {
+		return nil, nil, err
+	}
+	if err := rewriteRunEnvs(p.AST); err != nil {
+		return nil, nil, err
+	}
+	if err := rewriteCopyFlatten(p.AST); err != nil {
+		return nil, nil, err
+	}
 	stages, metaArgs, err := instructions.Parse(p.AST, &linter.Linter{})
 	if err != nil {
 		return nil, nil, err
@@ -255,10 +281,163 @@ func ResolveCrossStageCommands(cmds []instructions.Command, stageNameToIdx map[s
 					c.From = val
 				}
 			}
+		case *instructions.RunCommand:
+			for _, m := range BindMounts(c) {
+				if val, ok := stageNameToIdx[strings.ToLower(m.From)]; ok {
+					m.From = val
+				}
+			}
 		}
 	}
 }
 
+// BindMounts returns the --mount=type=bind mounts of a RUN instruction
+// that read from another stage or image, like COPY --from.
+func BindMounts(cmd *instructions.RunCommand) []*instructions.Mount {
+	if !slices.Contains(cmd.FlagsUsed, "mount") {
+		return nil
+	}
+	var mounts []*instructions.Mount
+	for _, m := range instructions.GetMounts(cmd) {
+		if m.Type == instructions.MountTypeBind && m.From != "" {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// stageReferences returns the stages or images cmd reads files from.
+func stageReferences(cmd instructions.Command) []string {
+	switch c := cmd.(type) {
+	case *instructions.CopyCommand:
+		if c.From != "" {
+			return []string{c.From}
+		}
+	case *instructions.RunCommand:
+		var froms []string
+		for _, m := range BindMounts(c) {
+			froms = append(froms, m.From)
+		}
+		return froms
+	}
+	return nil
+}
+
+// stageDependencies returns the indices of the stages each stage depends on,
+// through its FROM instruction, COPY --from or RUN --mount=from. Other than FROM,
+// these may reference any stage by name or index, also later ones.
+func stageDependencies(stages []instructions.Stage) [][]int {
+	deps := make([][]int, len(stages))
+	for i, s := range stages {
+		if base := baseImageIndex(i, stages); base != -1 {
+			deps[i] = append(deps[i], base)
+		}
+		for _, cmd := range s.Commands {
+			for _, from := range stageReferences(cmd) {
+				if idx, ok := stageIndex(stages, from); ok {
+					deps[i] = append(deps[i], idx)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// stageIndex returns the index of the stage from refers to by name or index.
+func stageIndex(stages []instructions.Stage, from string) (int, bool) {
+	for i, s := range stages {
+		if s.Name != "" && strings.EqualFold(s.Name, from) {
+			return i, true
+		}
+	}
+	if idx, err := strconv.Atoi(from); err == nil && idx >= 0 && idx < len(stages) {
+		return idx, true
+	}
+	return 0, false
+}
+
+// checkSelfReferences returns an error if a stage reads files from itself, which
+// isn't built yet when it is read.
+func checkSelfReferences(stages []instructions.Stage) error {
+	for i, s := range stages {
+		name := s.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		for _, cmd := range s.Commands {
+			for _, from := range stageReferences(cmd) {
+				if idx, ok := stageIndex(stages, from); !ok || idx != i {
+					continue
+				}
+				if _, isRun := cmd.(*instructions.RunCommand); isRun {
+					return fmt.Errorf("stage %s cannot RUN --mount from itself", name)
+				}
+				return fmt.Errorf("stage %s cannot COPY --from itself", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkStageCycles returns an error naming the stages involved if the
+// stages depend on each other in a cycle, which can never be built.
+func checkStageCycles(stages []instructions.Stage) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	if err := checkSelfReferences(stages); err != nil {
+		return err
+	}
+	deps := stageDependencies(stages)
+	state := make([]int, len(stages))
+	var path []int
+
+	stageName := func(i int) string {
+		if stages[i].Name != "" {
+			return stages[i].Name
+		}
+		return strconv.Itoa(i)
+	}
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range deps[i] {
+			switch state[dep] {
+			case visiting:
+				var cycle []string
+				for j := len(path) - 1; j >= 0; j-- {
+					cycle = append([]string{stageName(path[j])}, cycle...)
+					if path[j] == dep {
+						break
+					}
+				}
+				cycle = append(cycle, stageName(dep))
+				return fmt.Errorf("circular dependency between stages: %s", strings.Join(cycle, " -> "))
+			case unvisited:
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		return nil
+	}
+
+	for i := range stages {
+		if state[i] == unvisited {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // resolveStagesArgs resolves all the args from list of stages
 func resolveStagesArgs(stages []instructions.Stage, args []string) error {
 	for i, s := range stages {
@@ -273,6 +452,114 @@ func resolveStagesArgs(stages []instructions.Stage, args []string) error {
 	return nil
 }
 
+// resolveStageReferences expands the args and env in the stages referenced by
+// COPY --from and RUN --mount=from, so they resolve to a stage like a literal name.
+// Inside a stage, args are the meta ARGs and --build-arg redeclared with ARG.
+func resolveStageReferences(stages []instructions.Stage, metaArgs, buildArgs []string) error {
+	for _, s := range stages {
+		var envs []string
+		for _, cmd := range s.Commands {
+			var err error
+			if envs, err = addStageEnvs(envs, cmd, metaArgs, buildArgs); err != nil {
+				return err
+			}
+			switch c := cmd.(type) {
+			case *instructions.CopyCommand:
+				from, err := expandStageReference(c.From, envs)
+				if err != nil {
+					return err
+				}
+				c.From = from
+			case *instructions.RunCommand:
+				for _, m := range BindMounts(c) {
+					from, err := expandStageReference(m.From, envs)
+					if err != nil {
+						return err
+					}
+					m.From = from
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addStageEnvs returns envs with the variables an ARG or ENV command declares added.
+func addStageEnvs(envs []string, cmd instructions.Command, metaArgs, buildArgs []string) ([]string, error) {
+	switch c := cmd.(type) {
+	case *instructions.ArgCommand:
+		for _, arg := range c.Args {
+			if v, ok := lookupArg(arg.Key, buildArgs); ok {
+				envs = append(envs, arg.Key+"="+v)
+			} else if arg.Value != nil {
+				v, err := util.ResolveEnvironmentReplacement(*arg.Value, envs, false)
+				if err != nil {
+					return nil, err
+				}
+				envs = append(envs, arg.Key+"="+v)
+			} else if v, ok := lookupArg(arg.Key, metaArgs); ok {
+				envs = append(envs, arg.Key+"="+v)
+			}
+		}
+	case *instructions.EnvCommand:
+		for _, kv := range c.Env {
+			v, err := util.ResolveEnvironmentReplacement(kv.Value, envs, false)
+			if err != nil {
+				return nil, err
+			}
+			envs = append(envs, kv.Key+"="+v)
+		}
+	}
+	return envs, nil
+}
+
+// expandStageReference expands the variables in a --from value. A variable that is
+// not set is an error, otherwise the stage would silently resolve to an image.
+func expandStageReference(from string, envs []string) (string, error) {
+	if !strings.Contains(from, "$") {
+		return from, nil
+	}
+	resolved, unmatched, err := shell.NewLex(parser.DefaultEscapeToken).ProcessWord(from, shell.EnvsFromSlice(envs))
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving --from=%s", from)
+	}
+	var unset []string
+	for name := range unmatched {
+		// ${name:-default} and the like are fine without the variable
+		if regexp.MustCompile(`\$(` + regexp.QuoteMeta(name) + `\b|\{` + regexp.QuoteMeta(name) + `\})`).MatchString(from) {
+			unset = append(unset, name)
+		}
+	}
+	if len(unset) > 0 {
+		slices.Sort(unset)
+		return "", errors.Errorf("resolving --from=%s: %s is not set, declare it with ARG in the stage or pass it with --build-arg", from, strings.Join(unset, ", "))
+	}
+	if resolved == "" {
+		return "", errors.Errorf("resolving --from=%s: expands to an empty value", from)
+	}
+	return resolved, nil
+}
+
+// lookupArg returns the non-empty value of key in args.
+func lookupArg(key string, args []string) (string, bool) {
+	for _, a := range args {
+		if k, v, _ := strings.Cut(a, "="); k == key && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// ResolveBaseNames resolves the args in the base names of stages the same way the
+// executor does, meta ARGs can be overridden with --build-arg.
+func ResolveBaseNames(stages []instructions.Stage, metaArgs []instructions.ArgCommand, buildArgs []string) error {
+	metaArgs, err := expandNestedArgs(metaArgs, buildArgs)
+	if err != nil {
+		return errors.Wrap(err, "expanding meta ARGs")
+	}
+	return resolveStagesArgs(stages, unifyArgs(metaArgs, buildArgs))
+}
+
 func MakeKanikoStages(opts *config.KanikoOptions, stages []instructions.Stage, metaArgs []instructions.ArgCommand) ([]config.KanikoStage, error) {
 	targetStage, err := targetStage(stages, opts.Target)
 	if err != nil {
@@ -282,6 +569,12 @@ func MakeKanikoStages(opts *config.KanikoOptions, stages []instructions.Stage, m
 	if err := resolveStagesArgs(stages, args); err != nil {
 		return nil, errors.Wrap(err, "resolving args")
 	}
+	if err := resolveStageReferences(stages, args, opts.BuildArgs); err != nil {
+		return nil, errors.Wrap(err, "resolving args")
+	}
+	if err := checkStageCycles(stages); err != nil {
+		return nil, err
+	}
 	var kanikoStages []config.KanikoStage
 	for index, stage := range stages {
 		if len(stage.Name) > 0 {
@@ -324,22 +617,29 @@ func GetOnBuildInstructions(config *v1.Config, stageNameToIdx map[string]string)
 }
 
 // unifyArgs returns the unified args between metaArgs and --build-arg
-// by default --build-arg overrides metaArgs except when --build-arg is empty
+// by default --build-arg overrides metaArgs except when --build-arg is empty.
+// As in docker, a --build-arg only applies to FROM if it is declared by a meta ARG.
 func unifyArgs(metaArgs []instructions.ArgCommand, buildArgs []string) []string {
 	argsMap := make(map[string]string)
+	declared := make(map[string]bool)
 	for _, marg := range metaArgs {
 		for _, arg := range marg.Args {
+			declared[arg.Key] = true
 			if arg.Value != nil {
 				argsMap[arg.Key] = *arg.Value
 			}
 		}
 	}
-	splitter := "="
 	for _, a := range buildArgs {
-		s := strings.Split(a, splitter)
-		if len(s) > 1 && s[1] != "" {
-			argsMap[s[0]] = s[1]
+		k, v, _ := strings.Cut(a, "=")
+		if v == "" {
+			continue
+		}
+		if !declared[k] {
+			logrus.Debugf("Not using --build-arg %s for FROM, it is not declared before the first FROM", k)
+			continue
 		}
+		argsMap[k] = v
 	}
 	var args []string
 	for k, v := range argsMap {
@@ -394,15 +694,14 @@ func skipUnusedStages(stages []config.KanikoStage, targetStage int, squashStages
 			stagesDependencies[s.BaseImageIndex]++
 		}
 		for _, c := range s.Commands {
-			switch cmd := c.(type) {
-			case *instructions.CopyCommand:
-				if copyFromIndex, err := strconv.Atoi(cmd.From); err == nil {
+			for _, from := range stageReferences(c) {
+				if copyFromIndex, err := strconv.Atoi(from); err == nil {
 					// numeric reference `COPY --from=0`
 					// COPY --from can never be squashed, identical to having 2 dependencies
 					stagesDependencies[copyFromIndex] += 2
 				} else {
 					// named reference `COPY --from=base`
-					if copyFromIndex, ok := stageByName[strings.ToLower(cmd.From)]; ok {
+					if copyFromIndex, ok := stageByName[strings.ToLower(from)]; ok {
 						// There can be references that appear as non-existing stages
 						// ie. `COPY --from=debian` would try refer to `debian` as stage
 						// before falling back to `debian` as a docker image.
@@ -468,6 +468,54 @@ func Test_ResolveStagesArgs(t *testing.T) {
 	}
 }
 
+func Test_ResolveBaseNames(t *testing.T) {
+	dockerfile := `
+	ARG BASE=alpine:3.19
+	ARG VARIANT
+	FROM ${BASE} AS base
+	FROM debian${VARIANT}${UNDECLARED}
+	`
+	tests := []struct {
+		name      string
+		buildArgs []string
+		expected  []string
+	}{
+		{
+			name:     "defaults",
+			expected: []string{"alpine:3.19", "debian"},
+		},
+		{
+			name:      "overridden by build-arg",
+			buildArgs: []string{"BASE=busybox:1.36", "VARIANT=:12"},
+			expected:  []string{"busybox:1.36", "debian:12"},
+		},
+		{
+			name:      "empty build-arg keeps the default",
+			buildArgs: []string{"BASE="},
+			expected:  []string{"alpine:3.19", "debian"},
+		},
+		{
+			name:      "undeclared build-arg is ignored",
+			buildArgs: []string{"UNDECLARED=:12"},
+			expected:  []string{"alpine:3.19", "debian"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stages, metaArgs, err := Parse([]byte(dockerfile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ResolveBaseNames(stages, metaArgs, test.buildArgs)
+			var baseNames []string
+			for _, s := range stages {
+				baseNames = append(baseNames, s.BaseName)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, baseNames)
+		})
+	}
+}
+
 func Test_SkipingUnusedStages(t *testing.T) {
 	tests := []struct {
 		description         string
@@ -703,3 +751,158 @@ func Test_SquashStages(t *testing.T) {
 		}
 	}
 }
+
+func Test_checkStageCycles(t *testing.T) {
+	tests := []struct {
+		name        string
+		dockerfile  string
+		expectedErr string
+	}{
+		{
+			name: "no cycle",
+			dockerfile: `
+FROM alpine AS alpine
+FROM alpine AS builder
+RUN make
+FROM scratch
+COPY --from=builder /out /out
+COPY --from=0 /etc/passwd /etc/passwd
+`,
+		},
+		{
+			name: "two stage cycle",
+			dockerfile: `
+FROM alpine AS a
+COPY --from=b /b /b
+FROM alpine AS b
+COPY --from=a /a /a
+`,
+			expectedErr: "circular dependency between stages: a -> b -> a",
+		},
+		{
+			name: "three stage cycle",
+			dockerfile: `
+FROM alpine AS a
+COPY --from=b /b /b
+FROM alpine AS b
+COPY --from=c /c /c
+FROM alpine AS c
+COPY --from=a /a /a
+`,
+			expectedErr: "circular dependency between stages: a -> b -> c -> a",
+		},
+		{
+			name: "cycle through FROM",
+			dockerfile: `
+FROM alpine AS a
+COPY --from=b /b /b
+FROM a AS b
+`,
+			expectedErr: "circular dependency between stages: a -> b -> a",
+		},
+		{
+			name: "cycle by index",
+			dockerfile: `
+FROM alpine
+COPY --from=1 /b /b
+FROM alpine
+COPY --from=0 /a /a
+`,
+			expectedErr: "circular dependency between stages: 0 -> 1 -> 0",
+		},
+		{
+			name: "cycle through RUN --mount",
+			dockerfile: `
+FROM alpine AS a
+RUN --mount=type=bind,from=b,target=/b ls /b
+FROM alpine AS b
+COPY --from=a /a /a
+`,
+			expectedErr: "circular dependency between stages: a -> b -> a",
+		},
+		{
+			name: "stage copying from itself",
+			dockerfile: `
+FROM alpine AS a
+COPY --from=a /a /b
+`,
+			expectedErr: "stage a cannot COPY --from itself",
+		},
+		{
+			name: "stage copying from itself by index",
+			dockerfile: `
+FROM alpine AS a
+FROM alpine
+COPY --from=a /a /a
+COPY --from=1 /a /b
+`,
+			expectedErr: "stage 1 cannot COPY --from itself",
+		},
+		{
+			name: "stage mounting itself",
+			dockerfile: `
+FROM alpine AS A
+RUN --mount=type=bind,from=a,target=/a ls /a
+`,
+			expectedErr: "stage a cannot RUN --mount from itself",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stages, _, err := Parse([]byte(test.dockerfile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkStageCycles(stages)
+			if test.expectedErr == "" {
+				testutil.CheckNoError(t, err)
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", test.expectedErr)
+			}
+			testutil.CheckDeepEqual(t, test.expectedErr, err.Error())
+		})
+	}
+}
+
+func Test_MakeKanikoStages_FromArgs(t *testing.T) {
+	dockerfile := `
+	FROM alpine:3.11 AS builder
+	RUN echo hi > /hi
+	FROM alpine:3.11 AS other
+	RUN echo other > /hi
+	FROM alpine:3.11
+	ARG STAGE
+	COPY --from=$STAGE /hi /hi
+	`
+	tests := []struct {
+		description string
+		buildArgs   []string
+		wantErr     bool
+	}{
+		{description: "resolved with --build-arg", buildArgs: []string{"STAGE=builder"}},
+		{description: "unresolved", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			stages, metaArgs, err := Parse([]byte(dockerfile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{BuildArgs: test.buildArgs, SkipUnusedStages: true}
+			kanikoStages, err := MakeKanikoStages(opts, stages, metaArgs)
+			testutil.CheckError(t, test.wantErr, err)
+			if test.wantErr {
+				return
+			}
+			// the unreferenced stage is skipped, the referenced one is built
+			testutil.CheckDeepEqual(t, 2, len(kanikoStages))
+			testutil.CheckDeepEqual(t, "builder", kanikoStages[0].Name)
+
+			final := kanikoStages[len(kanikoStages)-1].Commands
+			ResolveCrossStageCommands(final, map[string]string{"builder": "0"})
+			testutil.CheckDeepEqual(t, "0", final[1].(*instructions.CopyCommand).From)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// instructionFlags records the values of a flag the rewrites of the AST remove, as
+// buildkit doesn't know it, by the original instruction. That is what String returns
+// for the command instructions.Parse makes of the instruction.
+type instructionFlags[T any] struct {
+	mu     sync.Mutex
+	values map[string]T
+}
+
+func (f *instructionFlags[T]) record(node *parser.Node, value T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.values == nil {
+		f.values = map[string]T{}
+	}
+	f.values[strings.TrimSpace(node.Original)] = value
+}
+
+func (f *instructionFlags[T]) lookup(instruction string) (T, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[instruction]
+	return value, ok
+}
+
+func (f *instructionFlags[T]) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values = nil
+}
+
+// ResetInstructionFlags drops the flags recorded for the instructions parsed so far,
+// it is called at the start of every build.
+func ResetInstructionFlags() {
+	copyManifests.reset()
+	copyFlattens.reset()
+	runEnvs.reset()
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_ResetInstructionFlags(t *testing.T) {
+	stages, _, err := Parse([]byte("FROM scratch\nRUN --env=A=1 echo hi\nCOPY --flatten a /a\nCOPY --from-manifest=paths.txt"))
+	testutil.CheckError(t, false, err)
+	run := stages[0].Commands[0].(*instructions.RunCommand)
+	flatten := stages[0].Commands[1].(*instructions.CopyCommand)
+	manifest := stages[0].Commands[2].(*instructions.CopyCommand)
+	testutil.CheckDeepEqual(t, []string{"A=1"}, RunEnvs(run))
+	_, ok := CopyFlatten(flatten)
+	testutil.CheckDeepEqual(t, true, ok)
+	_, ok = CopyManifest(manifest)
+	testutil.CheckDeepEqual(t, true, ok)
+
+	ResetInstructionFlags()
+	testutil.CheckDeepEqual(t, []string(nil), RunEnvs(run))
+	_, ok = CopyFlatten(flatten)
+	testutil.CheckDeepEqual(t, false, ok)
+	_, ok = CopyManifest(manifest)
+	testutil.CheckDeepEqual(t, false, ok)
+}
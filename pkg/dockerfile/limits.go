@@ -0,0 +1,63 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"fmt"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+)
+
+// checkLimits fails if the Dockerfile has more instructions or stages, or a COPY or
+// ADD more sources, than --max-instructions, --max-stages and --max-copy-sources
+// allow. A limit of 0 is no limit.
+func checkLimits(stages []instructions.Stage, metaArgs []instructions.ArgCommand, opts *config.KanikoOptions) error {
+	if opts.MaxStages > 0 && len(stages) > opts.MaxStages {
+		return util.UserError(fmt.Errorf("the Dockerfile has %d stages, more than --max-stages=%d allows", len(stages), opts.MaxStages))
+	}
+	// each stage starts with its FROM
+	instructionCount := len(metaArgs) + len(stages)
+	for _, stage := range stages {
+		instructionCount += len(stage.Commands)
+	}
+	if opts.MaxInstructions > 0 && instructionCount > opts.MaxInstructions {
+		return util.UserError(fmt.Errorf("the Dockerfile has %d instructions, more than --max-instructions=%d allows", instructionCount, opts.MaxInstructions))
+	}
+	if opts.MaxCopySources <= 0 {
+		return nil
+	}
+	for _, stage := range stages {
+		for _, cmd := range stage.Commands {
+			var sources instructions.SourcesAndDest
+			switch c := cmd.(type) {
+			case *instructions.CopyCommand:
+				sources = c.SourcesAndDest
+			case *instructions.AddCommand:
+				sources = c.SourcesAndDest
+			default:
+				continue
+			}
+			// wildcards are not expanded yet, a pattern counts as one source
+			if n := len(sources.SourcePaths) + len(sources.SourceContents); n > opts.MaxCopySources {
+				return util.UserError(fmt.Errorf("%s has %d sources, more than --max-copy-sources=%d allows", cmd, n, opts.MaxCopySources))
+			}
+		}
+	}
+	return nil
+}
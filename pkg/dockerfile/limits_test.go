@@ -0,0 +1,70 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_checkLimits(t *testing.T) {
+	dockerfile := `
+ARG BASE=alpine
+FROM ${BASE} AS builder
+COPY a b c /src/
+RUN make
+
+FROM scratch
+COPY --from=builder /out /out
+ADD d e /
+`
+	stages, metaArgs, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    config.KanikoOptions
+		wantErr string
+	}{
+		{name: "no limits"},
+		{name: "instructions at the limit", opts: config.KanikoOptions{MaxInstructions: 7}},
+		{name: "too many instructions", opts: config.KanikoOptions{MaxInstructions: 6}, wantErr: "has 7 instructions, more than --max-instructions=6"},
+		{name: "stages at the limit", opts: config.KanikoOptions{MaxStages: 2}},
+		{name: "too many stages", opts: config.KanikoOptions{MaxStages: 1}, wantErr: "has 2 stages, more than --max-stages=1"},
+		{name: "sources at the limit", opts: config.KanikoOptions{MaxCopySources: 3}},
+		{name: "too many sources", opts: config.KanikoOptions{MaxCopySources: 2}, wantErr: "COPY a b c /src/ has 3 sources, more than --max-copy-sources=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLimits(stages, metaArgs, &tt.opts)
+			if tt.wantErr == "" {
+				testutil.CheckNoError(t, err)
+				return
+			}
+			testutil.CheckError(t, true, err)
+			testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
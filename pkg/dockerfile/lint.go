@@ -0,0 +1,247 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+	"github.com/osscontainertools/kaniko/pkg/config"
+)
+
+// LintFinding is an issue found in a Dockerfile by --lint.
+type LintFinding struct {
+	Line    int
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("line %d: %s", f.Line, f.Message)
+}
+
+// SortFindings sorts findings by line.
+func SortFindings(findings []LintFinding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Line < findings[j].Line
+	})
+}
+
+// LintInstructions reports every instruction of the Dockerfile that can't be parsed,
+// such as unknown instructions, instead of only the first one as Parse does.
+func LintInstructions(b []byte) ([]LintFinding, error) {
+	p, err := parser.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var findings []LintFinding
+	for _, node := range p.AST.Children {
+		if _, err := instructions.ParseInstruction(node); err != nil {
+			findings = append(findings, LintFinding{Line: node.StartLine, Message: err.Error()})
+		}
+	}
+	return findings, nil
+}
+
+// LintStages reports stages that are never built for the target and args that are used
+// without being declared. The base names of the stages are resolved in the process.
+func LintStages(opts *config.KanikoOptions, stages []instructions.Stage, metaArgs []instructions.ArgCommand) ([]LintFinding, error) {
+	findings := undefinedArgsInFrom(stages, metaArgs)
+	if err := ResolveBaseNames(stages, metaArgs, opts.BuildArgs); err != nil {
+		return nil, err
+	}
+	unreachable, err := unreachableStages(stages, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, unreachable...)
+	findings = append(findings, undefinedArgsInStages(stages)...)
+	SortFindings(findings)
+	return findings, nil
+}
+
+func stageLine(stage instructions.Stage) int {
+	if len(stage.Location) == 0 {
+		return 0
+	}
+	return stage.Location[0].Start.Line
+}
+
+func commandLine(cmd instructions.Command) int {
+	if loc := cmd.Location(); len(loc) > 0 {
+		return loc[0].Start.Line
+	}
+	return 0
+}
+
+// unreachableStages reports the stages the target stage doesn't depend on.
+func unreachableStages(stages []instructions.Stage, target string) ([]LintFinding, error) {
+	targetIdx, err := targetStage(stages, target)
+	if err != nil {
+		return nil, err
+	}
+	deps := stageDependencies(stages)
+	reachable := map[int]bool{targetIdx: true}
+	queue := []int{targetIdx}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, d := range deps[i] {
+			if !reachable[d] {
+				reachable[d] = true
+				queue = append(queue, d)
+			}
+		}
+	}
+	var findings []LintFinding
+	for i, stage := range stages {
+		if reachable[i] {
+			continue
+		}
+		name := stage.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		findings = append(findings, LintFinding{
+			Line:    stageLine(stage),
+			Message: fmt.Sprintf("stage %s is unreachable, the target stage doesn't depend on it", name),
+		})
+	}
+	return findings, nil
+}
+
+// predefinedArgNames returns the args that can be used without being declared.
+func predefinedArgNames() map[string]struct{} {
+	names := map[string]struct{}{}
+	b := NewBuildArgs(nil)
+	if err := b.InitPredefinedArgs("", ""); err == nil {
+		for k := range b.predefinedArgs {
+			names[k] = struct{}{}
+		}
+	}
+	return names
+}
+
+// unmatchedArgs returns the args used in word that are not in env.
+func unmatchedArgs(lex *shell.Lex, word string, env []string) ([]string, error) {
+	res, err := lex.ProcessWordWithMatches(word, shell.EnvsFromSlice(env))
+	if err != nil {
+		return nil, err
+	}
+	var unmatched []string
+	for k := range res.Unmatched {
+		unmatched = append(unmatched, k)
+	}
+	sort.Strings(unmatched)
+	return unmatched, nil
+}
+
+// undefinedArgsInFrom reports args used in FROM that are not declared before the first FROM.
+func undefinedArgsInFrom(stages []instructions.Stage, metaArgs []instructions.ArgCommand) []LintFinding {
+	var env []string
+	for k := range predefinedArgNames() {
+		env = append(env, k+"=")
+	}
+	for _, marg := range metaArgs {
+		for _, arg := range marg.Args {
+			env = append(env, arg.Key+"=")
+		}
+	}
+	lex := shell.NewLex(parser.DefaultEscapeToken)
+	var findings []LintFinding
+	for _, stage := range stages {
+		unmatched, err := unmatchedArgs(lex, stage.BaseName, env)
+		if err != nil {
+			findings = append(findings, LintFinding{Line: stageLine(stage), Message: err.Error()})
+			continue
+		}
+		for _, k := range unmatched {
+			findings = append(findings, LintFinding{
+				Line:    stageLine(stage),
+				Message: fmt.Sprintf("undefined ARG %s in FROM, declare it with ARG before the first FROM", k),
+			})
+		}
+	}
+	return findings
+}
+
+// undefinedArgsInStages reports args used by the instructions of a stage that are neither
+// declared with ARG nor set with ENV. The environment of a base image is unknown without
+// pulling it, so only stages that are built from scratch are checked.
+func undefinedArgsInStages(stages []instructions.Stage) []LintFinding {
+	lex := shell.NewLex(parser.DefaultEscapeToken)
+	stageEnv := make([][]string, len(stages))
+	fromScratch := make([]bool, len(stages))
+	var findings []LintFinding
+	for i, stage := range stages {
+		var env []string
+		if base := baseImageIndex(i, stages); base != -1 {
+			fromScratch[i] = fromScratch[base]
+			env = append(env, stageEnv[base]...)
+		} else {
+			fromScratch[i] = strings.EqualFold(stage.BaseName, "scratch")
+		}
+		// ENV is inherited by stages built from this stage, ARG is not
+		args := []string{}
+		for k := range builtinAllowedBuildArgs {
+			args = append(args, k+"=")
+		}
+		for _, cmd := range stage.Commands {
+			e, ok := cmd.(instructions.SupportsSingleWordExpansion)
+			if !ok {
+				continue
+			}
+			line := commandLine(cmd)
+			// the words are checked, but the command is left as is
+			err := e.Expand(func(word string) (string, error) {
+				unmatched, err := unmatchedArgs(lex, word, append(append([]string{}, env...), args...))
+				if err != nil {
+					return "", err
+				}
+				if fromScratch[i] {
+					for _, k := range unmatched {
+						findings = append(findings, LintFinding{
+							Line:    line,
+							Message: fmt.Sprintf("undefined ARG %s in %s, declare it with ARG or ENV", k, strings.ToUpper(cmd.Name())),
+						})
+					}
+				}
+				return word, nil
+			})
+			if err != nil {
+				findings = append(findings, LintFinding{Line: line, Message: err.Error()})
+				continue
+			}
+			switch c := cmd.(type) {
+			case *instructions.ArgCommand:
+				for _, arg := range c.Args {
+					args = append(args, arg.Key+"="+arg.ValueString())
+				}
+			case *instructions.EnvCommand:
+				for _, kv := range c.Env {
+					env = append(env, kv.Key+"="+kv.Value)
+				}
+			}
+		}
+		stageEnv[i] = env
+	}
+	return findings
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// directivePrefix starts the comments Preprocess evaluates
+const directivePrefix = "kaniko:"
+
+var escapeDirective = regexp.MustCompile(`^#\s*escape\s*=\s*(\S)`)
+
+// LoadDockerfile reads the Dockerfile of opts, with its conditional directives
+// evaluated if --preprocess-dockerfile is set.
+func LoadDockerfile(opts *config.KanikoOptions) ([]byte, error) {
+	b, _, err := loadDockerfile(opts)
+	return b, err
+}
+
+// loadDockerfile is LoadDockerfile, it also returns the build args the conditional
+// directives evaluated.
+func loadDockerfile(opts *config.KanikoOptions) ([]byte, []string, error) {
+	b, err := ReadDockerfile(opts.DockerfilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !opts.PreprocessDockerfile {
+		return b, nil, nil
+	}
+	b, evaluated, err := Preprocess(b, opts.BuildArgs)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "preprocessing dockerfile %s", opts.DockerfilePath)
+	}
+	return b, evaluated, nil
+}
+
+type conditional struct {
+	line   int
+	holds  bool
+	inElse bool
+}
+
+// Preprocess evaluates the conditional directives of the Dockerfile b against buildArgs.
+// The instructions between `# kaniko:if NAME==value`, or `!=`, and `# kaniko:endif` are
+// kept only if the condition holds, the ones after a `# kaniko:else` only if it doesn't.
+// Conditions may be nested, build args that are not set are empty. The directives and
+// the lines left out are blanked, so that the remaining lines keep their numbers.
+// It also returns the names of the build args the conditions evaluated.
+func Preprocess(b []byte, buildArgs []string) ([]byte, []string, error) {
+	args := map[string]string{}
+	for _, arg := range buildArgs {
+		name, value, _ := strings.Cut(arg, "=")
+		args[name] = value
+	}
+
+	lines := strings.Split(string(b), "\n")
+	escape := `\`
+	var stack []conditional
+	var evaluated []string
+	continued, instructions := false, false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		// parser directives precede the first instruction
+		if !instructions {
+			if m := escapeDirective.FindStringSubmatch(trimmed); m != nil {
+				escape = m[1]
+			}
+		}
+		directive, isDirective := parseDirective(trimmed)
+		if !isDirective {
+			if !keep(stack) {
+				lines[i] = ""
+			}
+			if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				instructions = true
+				continued = strings.HasSuffix(trimmed, escape)
+			}
+			continue
+		}
+		if continued {
+			return nil, nil, errors.Errorf("line %d: %s is inside an instruction, directives have to surround whole instructions", i+1, trimmed)
+		}
+		lines[i] = ""
+		keyword, condition, _ := strings.Cut(directive, " ")
+		condition = strings.TrimSpace(condition)
+		switch keyword {
+		case "if":
+			name, holds, err := evaluateCondition(condition, args)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "line %d", i+1)
+			}
+			evaluated = append(evaluated, name)
+			stack = append(stack, conditional{line: i + 1, holds: holds})
+		case "else":
+			if len(stack) == 0 || stack[len(stack)-1].inElse {
+				return nil, nil, errors.Errorf("line %d: kaniko:else without kaniko:if", i+1)
+			}
+			stack[len(stack)-1].inElse = true
+		case "endif":
+			if len(stack) == 0 {
+				return nil, nil, errors.Errorf("line %d: kaniko:endif without kaniko:if", i+1)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			return nil, nil, errors.Errorf("line %d: unknown directive %s%s, expected if, else or endif", i+1, directivePrefix, keyword)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, nil, errors.Errorf("line %d: kaniko:if without kaniko:endif", stack[len(stack)-1].line)
+	}
+	return []byte(strings.Join(lines, "\n")), evaluated, nil
+}
+
+// parseDirective returns the directive of a `# kaniko:` comment without its prefix.
+func parseDirective(line string) (string, bool) {
+	comment, ok := strings.CutPrefix(line, "#")
+	if !ok {
+		return "", false
+	}
+	return strings.CutPrefix(strings.TrimSpace(comment), directivePrefix)
+}
+
+// keep returns true if all of the conditions surrounding a line hold.
+func keep(stack []conditional) bool {
+	for _, c := range stack {
+		if c.holds == c.inElse {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateCondition evaluates NAME==value or NAME!=value, the value may be quoted.
+// It returns the name of the build arg and whether the condition holds.
+func evaluateCondition(condition string, args map[string]string) (string, bool, error) {
+	op := "=="
+	name, value, ok := strings.Cut(condition, op)
+	if !ok {
+		op = "!="
+		if name, value, ok = strings.Cut(condition, op); !ok {
+			return "", false, errors.Errorf("condition %q is neither NAME==value nor NAME!=value", condition)
+		}
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", false, errors.Errorf("condition %q has no build arg", condition)
+	}
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	equal := args[name] == value
+	if op == "==" {
+		return name, equal, nil
+	}
+	return name, !equal, nil
+}
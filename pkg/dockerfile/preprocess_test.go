@@ -0,0 +1,120 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_Preprocess(t *testing.T) {
+	dockerfile := `FROM alpine
+# kaniko:if ENV==prod
+COPY prod.conf /etc/app.conf
+# kaniko:else
+COPY dev.conf /etc/app.conf
+#   kaniko:if DEBUG != "true"
+RUN rm /etc/debug
+# kaniko:endif
+# kaniko:endif
+# a comment
+RUN echo done`
+
+	tests := []struct {
+		name      string
+		buildArgs []string
+		expected  string
+	}{
+		{
+			name:      "condition holds",
+			buildArgs: []string{"ENV=prod"},
+			expected: `FROM alpine
+
+COPY prod.conf /etc/app.conf
+
+
+
+
+
+
+# a comment
+RUN echo done`,
+		},
+		{
+			name:      "else with nested condition",
+			buildArgs: []string{"ENV=dev"},
+			expected: `FROM alpine
+
+
+
+COPY dev.conf /etc/app.conf
+
+RUN rm /etc/debug
+
+
+# a comment
+RUN echo done`,
+		},
+		{
+			name:      "unset build arg is empty",
+			buildArgs: []string{"DEBUG=true"},
+			expected: `FROM alpine
+
+
+
+COPY dev.conf /etc/app.conf
+
+
+
+
+# a comment
+RUN echo done`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, evaluated, err := Preprocess([]byte(dockerfile), tc.buildArgs)
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, tc.expected, string(b))
+			testutil.CheckDeepEqual(t, []string{"ENV", "DEBUG"}, evaluated)
+		})
+	}
+}
+
+func Test_Preprocess_invalid(t *testing.T) {
+	for name, dockerfile := range map[string]string{
+		"unterminated":          "FROM alpine\n# kaniko:if A==b\nRUN true",
+		"endif without if":      "FROM alpine\n# kaniko:endif",
+		"else without if":       "FROM alpine\n# kaniko:else",
+		"second else":           "FROM alpine\n# kaniko:if A==b\n# kaniko:else\n# kaniko:else\n# kaniko:endif",
+		"unknown directive":     "FROM alpine\n# kaniko:unless A==b",
+		"invalid condition":     "FROM alpine\n# kaniko:if A\n# kaniko:endif",
+		"inside instruction":    "FROM alpine\nRUN echo \\\n# kaniko:if A==b\n  a\n# kaniko:endif",
+		"escape directive":      "# escape=`\nFROM alpine\nRUN echo `\n# kaniko:if A==b\n  a\n# kaniko:endif",
+		"condition without arg": "FROM alpine\n# kaniko:if ==b\n# kaniko:endif",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := Preprocess([]byte(dockerfile), nil)
+			testutil.CheckError(t, true, err)
+		})
+	}
+
+	// a trailing backslash isn't a continuation with another escape character
+	_, _, err := Preprocess([]byte("# escape=`\nFROM alpine\nRUN dir c:\\\n# kaniko:if A==b\nRUN true\n# kaniko:endif"), nil)
+	testutil.CheckNoError(t, err)
+}
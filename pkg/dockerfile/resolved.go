@@ -0,0 +1,125 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// ResolvedDockerfile returns the instructions of the Dockerfile b with the meta ARGs,
+// --build-arg and the ARG and ENV of each stage expanded the way kaniko expands them,
+// one instruction per line. Base names are resolved like the cache warmer resolves them.
+// Instructions evaluated by a shell, like RUN, and variables that are not set, like the
+// ENV of a base image, are kept as they are.
+func ResolvedDockerfile(b []byte, buildArgs []string) (string, error) {
+	stages, metaArgs, err := Parse(b)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing dockerfile")
+	}
+	if err := ResolveBaseNames(stages, metaArgs, buildArgs); err != nil {
+		return "", errors.Wrap(err, "resolving args")
+	}
+	expandedMetaArgs, err := expandNestedArgs(metaArgs, buildArgs)
+	if err != nil {
+		return "", errors.Wrap(err, "expanding meta ARGs")
+	}
+	args := unifyArgs(expandedMetaArgs, buildArgs)
+
+	var lines []string
+	for _, marg := range metaArgs {
+		lines = append(lines, resolvedArg(&marg, args))
+	}
+	for _, s := range stages {
+		from := "FROM "
+		if s.Platform != "" {
+			platform, err := util.ResolveEnvironmentReplacement(s.Platform, args, false)
+			if err != nil {
+				return "", errors.Wrapf(err, "resolving platform %s", s.Platform)
+			}
+			from += "--platform=" + platform + " "
+		}
+		from += s.BaseName
+		if s.Name != "" {
+			from += " AS " + s.Name
+		}
+		lines = append(lines, "", from)
+
+		var envs []string
+		for _, cmd := range s.Commands {
+			line, err := resolvedInstruction(cmd, envs)
+			if err != nil {
+				return "", err
+			}
+			if envs, err = addStageEnvs(envs, cmd, args, buildArgs); err != nil {
+				return "", err
+			}
+			// args show the value they resolved to, which may be a --build-arg
+			if arg, ok := cmd.(*instructions.ArgCommand); ok {
+				line = resolvedArg(arg, envs)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimPrefix(strings.Join(lines, "\n"), "\n") + "\n", nil
+}
+
+// resolvedArg returns the ARG instruction with the values of envs its args have.
+func resolvedArg(cmd *instructions.ArgCommand, envs []string) string {
+	line := "ARG"
+	for _, arg := range cmd.Args {
+		line += " " + arg.Key
+		if v, ok := lookupArg(arg.Key, envs); ok {
+			if strings.ContainsAny(v, " \t\"'\\$") {
+				v = strconv.Quote(v)
+			}
+			line += "=" + v
+		}
+	}
+	return line
+}
+
+// resolvedInstruction returns cmd with envs expanded if it is an instruction the
+// variables are expanded in, quotes and escapes are kept.
+func resolvedInstruction(cmd instructions.Command, envs []string) (string, error) {
+	code := fmt.Sprint(cmd)
+	switch cmd.(type) {
+	case *instructions.AddCommand, *instructions.ArgCommand, *instructions.CopyCommand,
+		*instructions.EnvCommand, *instructions.ExposeCommand, *instructions.LabelCommand,
+		*instructions.StopSignalCommand, *instructions.UserCommand, *instructions.VolumeCommand,
+		*instructions.WorkdirCommand:
+	default:
+		return code, nil
+	}
+	keyword, rest, _ := strings.Cut(code, " ")
+	lex := shell.NewLex(parser.DefaultEscapeToken)
+	lex.RawQuotes = true
+	lex.RawEscapes = true
+	lex.SkipUnsetEnv = true
+	resolved, _, err := lex.ProcessWord(rest, shell.EnvsFromSlice(envs))
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving %s", code)
+	}
+	return keyword + " " + resolved, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_ResolvedDockerfile(t *testing.T) {
+	dockerfile := `ARG V=1.2
+ARG REGISTRY
+FROM img:$V AS base
+ARG DIR=/app
+ENV HOME=$DIR LOG="$DIR/log"
+WORKDIR $HOME
+COPY --chown=1000 src/ "$LOG/"
+RUN echo $HOME
+
+FROM ${REGISTRY:-docker.io}/base:$V
+USER $UNSET
+`
+	tests := []struct {
+		name      string
+		buildArgs []string
+		expected  string
+	}{
+		{
+			name: "defaults",
+			expected: `ARG V=1.2
+ARG REGISTRY
+
+FROM img:1.2 AS base
+ARG DIR=/app
+ENV HOME=/app LOG="/app/log"
+WORKDIR /app
+COPY --chown=1000 src/ "/app/log/"
+RUN echo $HOME
+
+FROM docker.io/base:1.2
+USER $UNSET
+`,
+		},
+		{
+			name:      "build args",
+			buildArgs: []string{"V=2.0", "REGISTRY=mirror.example.com", "DIR=/srv"},
+			expected: `ARG V=2.0
+ARG REGISTRY=mirror.example.com
+
+FROM img:2.0 AS base
+ARG DIR=/srv
+ENV HOME=/srv LOG="/srv/log"
+WORKDIR /srv
+COPY --chown=1000 src/ "/srv/log/"
+RUN echo $HOME
+
+FROM mirror.example.com/base:2.0
+USER $UNSET
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := ResolvedDockerfile([]byte(dockerfile), tt.buildArgs)
+			testutil.CheckErrorAndDeepEqual(t, false, err, tt.expected, resolved)
+		})
+	}
+}
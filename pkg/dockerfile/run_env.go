@@ -0,0 +1,75 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/pkg/errors"
+)
+
+// runEnvFlag is the RUN flag setting a variable for the command only,
+// ie. RUN --env=KEY=VALUE or RUN --env KEY=VALUE
+const runEnvFlag = "--env"
+
+// runEnvs are the variables of the --env flags of the RUN instructions.
+var runEnvs instructionFlags[[]string]
+
+// rewriteRunEnvs removes the --env flags of each RUN of the AST, as buildkit
+// doesn't know them, and records their variables for RunEnvs.
+func rewriteRunEnvs(ast *parser.Node) error {
+	for _, node := range ast.Children {
+		if !strings.EqualFold(node.Value, "run") {
+			continue
+		}
+		var envs []string
+		flags := node.Flags[:0:0]
+		for _, flag := range node.Flags {
+			name, value, hasValue := strings.Cut(flag, "=")
+			if name != runEnvFlag {
+				flags = append(flags, flag)
+				continue
+			}
+			if !hasValue {
+				// the variable is the first word of the command in shell form
+				if node.Next == nil || node.Next.Next != nil {
+					return parser.WithLocation(errors.New("RUN --env requires a KEY=VALUE variable"), node.Location())
+				}
+				value, node.Next.Value, _ = strings.Cut(strings.TrimSpace(node.Next.Value), " ")
+				node.Next.Value = strings.TrimSpace(node.Next.Value)
+			}
+			if key, _, ok := strings.Cut(value, "="); !ok || key == "" {
+				return parser.WithLocation(errors.Errorf("RUN --env requires a KEY=VALUE variable, got %q", value), node.Location())
+			}
+			envs = append(envs, value)
+		}
+		if len(envs) > 0 {
+			runEnvs.record(node, envs)
+		}
+		node.Flags = flags
+	}
+	return nil
+}
+
+// RunEnvs returns the KEY=VALUE variables the --env flags of cmd set for its
+// command, they are not added to the environment of the image.
+func RunEnvs(cmd *instructions.RunCommand) []string {
+	envs, _ := runEnvs.lookup(cmd.String())
+	return envs
+}
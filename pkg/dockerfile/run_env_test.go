@@ -0,0 +1,56 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_RunEnvs(t *testing.T) {
+	tests := []struct {
+		line    string
+		envs    []string
+		cmdLine []string
+		wantErr bool
+	}{
+		{line: "RUN echo hi", cmdLine: []string{"echo hi"}},
+		{line: "RUN --env=A=1 --env=B=2 echo hi", envs: []string{"A=1", "B=2"}, cmdLine: []string{"echo hi"}},
+		{line: "RUN --mount=type=cache,target=/c --env A=1 echo hi", envs: []string{"A=1"}, cmdLine: []string{"echo hi"}},
+		{line: `RUN --env=A=1 ["echo", "hi"]`, envs: []string{"A=1"}, cmdLine: []string{"echo", "hi"}},
+		{line: `RUN --env="GREETING=hello world" echo --env=B=2`, envs: []string{"GREETING=hello world"}, cmdLine: []string{"echo --env=B=2"}},
+		{line: "RUN --env=A echo hi", wantErr: true},
+		{line: "RUN --env", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			stages, _, err := Parse([]byte("FROM scratch\n" + tt.line))
+			testutil.CheckError(t, tt.wantErr, err)
+			if tt.wantErr {
+				return
+			}
+			cmd := stages[0].Commands[0].(*instructions.RunCommand)
+			testutil.CheckDeepEqual(t, tt.envs, RunEnvs(cmd))
+			testutil.CheckDeepEqual(t, tt.cmdLine, cmd.CmdLine)
+			// the flag is not one buildkit knows about
+			testutil.CheckDeepEqual(t, false, slices.Contains(cmd.FlagsUsed, "env"))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
+	"github.com/osscontainertools/kaniko/pkg/util"
+)
+
+// unusedBuildArgs returns the sorted names of the build args no ARG of the
+// Dockerfile declares. The proxy args are never reported, like by docker, as they
+// are passed to RUN commands without being declared, nor the evaluated ones, which
+// the conditional directives of --preprocess-dockerfile consumed.
+func unusedBuildArgs(stages []instructions.Stage, metaArgs []instructions.ArgCommand, evaluated, buildArgs []string) []string {
+	declared := map[string]struct{}{}
+	for _, name := range evaluated {
+		declared[name] = struct{}{}
+	}
+	declare := func(arg instructions.ArgCommand) {
+		for _, kv := range arg.Args {
+			declared[kv.Key] = struct{}{}
+		}
+	}
+	for _, arg := range metaArgs {
+		declare(arg)
+	}
+	for _, stage := range stages {
+		for _, cmd := range stage.Commands {
+			if arg, ok := cmd.(*instructions.ArgCommand); ok {
+				declare(*arg)
+			}
+		}
+	}
+	var unused []string
+	for _, arg := range buildArgs {
+		key, _, _ := strings.Cut(arg, "=")
+		if _, ok := declared[key]; ok || builtinAllowedBuildArgs[key] {
+			continue
+		}
+		unused = append(unused, key)
+	}
+	// build args given more than once are reported once
+	sort.Strings(unused)
+	return slices.Compact(unused)
+}
+
+// checkUnusedBuildArgs warns about or fails on build args no ARG of the Dockerfile
+// declares, which are often typos, depending on --unused-build-args.
+func checkUnusedBuildArgs(stages []instructions.Stage, metaArgs []instructions.ArgCommand, evaluated []string, opts *config.KanikoOptions) error {
+	policy := opts.UnusedBuildArgs
+	if policy != config.UnusedBuildArgWarn && policy != config.UnusedBuildArgError {
+		return nil
+	}
+	unused := unusedBuildArgs(stages, metaArgs, evaluated, opts.BuildArgs)
+	if len(unused) == 0 {
+		return nil
+	}
+	if policy == config.UnusedBuildArgError {
+		return util.UserError(fmt.Errorf("build args %s are not declared by any ARG of the Dockerfile", strings.Join(unused, ", ")))
+	}
+	diagnostics.Warnf(diagnostics.UnusedBuildArg, "build args %s are not declared by any ARG of the Dockerfile and were not consumed", strings.Join(unused, ", "))
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_checkUnusedBuildArgs(t *testing.T) {
+	dockerfile := `
+ARG BASE=alpine
+FROM ${BASE} AS builder
+ARG VERSION TARGET=release
+RUN make $TARGET
+
+FROM scratch
+ARG COMMIT
+`
+	stages, metaArgs, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildArgs := []string{"BASE=debian", "VERSION=1", "COMMIT", "HTTP_PROXY=http://proxy", "VERSOIN=2", "EXTRA=1", "EXTRA=2"}
+	testutil.CheckDeepEqual(t, []string{"EXTRA", "VERSOIN"}, unusedBuildArgs(stages, metaArgs, nil, buildArgs))
+
+	tests := []struct {
+		name     string
+		policy   config.UnusedBuildArgPolicy
+		wantErr  bool
+		warnings int
+	}{
+		{name: "unset"},
+		{name: "ignore", policy: config.UnusedBuildArgIgnore},
+		{name: "warn", policy: config.UnusedBuildArgWarn, warnings: 1},
+		{name: "error", policy: config.UnusedBuildArgError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics.Reset()
+			defer diagnostics.Reset()
+			err := checkUnusedBuildArgs(stages, metaArgs, nil, &config.KanikoOptions{BuildArgs: buildArgs, UnusedBuildArgs: tt.policy})
+			testutil.CheckError(t, tt.wantErr, err)
+			if tt.wantErr {
+				testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+				if !strings.Contains(err.Error(), "build args EXTRA, VERSOIN are not declared") {
+					t.Errorf("expected the error to name the unused build args, got %v", err)
+				}
+			}
+			warnings := diagnostics.All()
+			testutil.CheckDeepEqual(t, tt.warnings, len(warnings))
+			for _, w := range warnings {
+				testutil.CheckDeepEqual(t, diagnostics.UnusedBuildArg, w.Code)
+			}
+		})
+	}
+
+	// all build args are declared
+	err = checkUnusedBuildArgs(stages, metaArgs, nil, &config.KanikoOptions{BuildArgs: []string{"COMMIT=abc"}, UnusedBuildArgs: config.UnusedBuildArgError})
+	testutil.CheckNoError(t, err)
+}
+
+func Test_checkUnusedBuildArgs_preprocessed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Dockerfile")
+	dockerfile := `FROM alpine
+# kaniko:if VARIANT==slim
+RUN rm -rf /usr/share/doc
+# kaniko:endif
+`
+	if err := os.WriteFile(path, []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath:       path,
+		BuildArgs:            []string{"VARIANT=slim"},
+		UnusedBuildArgs:      config.UnusedBuildArgError,
+		PreprocessDockerfile: true,
+	}
+	// the build arg is consumed by the conditional directive
+	_, _, err := ParseStages(opts)
+	testutil.CheckNoError(t, err)
+
+	// but not without --preprocess-dockerfile
+	opts.PreprocessDockerfile = false
+	_, _, err = ParseStages(opts)
+	testutil.CheckError(t, true, err)
+}
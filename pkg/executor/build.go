@@ -18,8 +18,10 @@ package executor
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -39,13 +41,16 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/osscontainertools/kaniko/pkg/buildcontext"
 	"github.com/osscontainertools/kaniko/pkg/cache"
 	"github.com/osscontainertools/kaniko/pkg/commands"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	image_util "github.com/osscontainertools/kaniko/pkg/image"
 	"github.com/osscontainertools/kaniko/pkg/image/remote"
+	"github.com/osscontainertools/kaniko/pkg/sbom"
 	"github.com/osscontainertools/kaniko/pkg/snapshot"
 	"github.com/osscontainertools/kaniko/pkg/timing"
 	"github.com/osscontainertools/kaniko/pkg/util"
@@ -62,16 +67,13 @@ var (
 )
 
 type cachePusher func(*config.KanikoOptions, string, string, string) error
-type snapShotter interface {
-	Init() error
-	TakeSnapshotFS() (string, error)
-	TakeSnapshot([]string, bool) (string, error)
-}
 
 // stageBuilder contains all fields necessary to build one stage of a Dockerfile
 type stageBuilder struct {
-	stage            config.KanikoStage
-	image            v1.Image
+	stage config.KanikoStage
+	image v1.Image
+	// baseImage is the image the stage is built on
+	baseImage        v1.Image
 	cf               *v1.ConfigFile
 	baseImageDigest  string
 	finalCacheKey    string
@@ -82,9 +84,36 @@ type stageBuilder struct {
 	crossStageDeps   map[int][]string
 	digestToCacheKey map[string]string
 	stageIdxToDigest map[string]string
-	snapshotter      snapShotter
+	snapshotter      Snapshotter
 	layerCache       cache.LayerCache
 	pushLayerToCache cachePusher
+	// commandArgs are the build args of --command-build-arg by index of cmds
+	commandArgs map[int][]string
+	// scopedArgs are the indices of cmds of ARG commands that are scoped to the
+	// command after them, see FF_KANIKO_SCOPED_RUN_ARGS
+	scopedArgs      map[int]bool
+	argsBeforeScope *dockerfile.BuildArgs
+	scopeEnd        int
+	// inlineCacheKeys are the cache keys of the layers of the final stage by history index, see --cache-inline
+	inlineCacheKeys map[int]string
+	// layerCacheKeys are the layer cache keys of the layers of the final stage by history index, see --cache-layer-keys
+	layerCacheKeys map[int]string
+	// cacheHits is the number of commands replaced by their cached version
+	cacheHits int
+	// cacheHitImages are the cached images of the commands replaced by their cached version
+	// by index of cmds, build exports them to --cache-export-dir as well
+	cacheHitImages map[int]cacheHitImage
+	// hideHistory are the patterns of the commands left out of the history, see --hide-history
+	hideHistory []*regexp.Regexp
+	// fsUnpacked is true once the filesystem of the stage is unpacked, the user and group
+	// names in layer cache keys are looked up in it
+	fsUnpacked bool
+}
+
+// cacheHitImage is the image a command was found by in the layer cache under key.
+type cacheHitImage struct {
+	key   string
+	image v1.Image
 }
 
 func makeSnapshotter(opts *config.KanikoOptions) (*snapshot.Snapshotter, error) {
@@ -93,15 +122,24 @@ func makeSnapshotter(opts *config.KanikoOptions) (*snapshot.Snapshotter, error)
 		return nil, err
 	}
 	l := snapshot.NewLayeredMap(hasher)
-	return snapshot.NewSnapshotter(l, config.RootDir), nil
+	snapshotter := snapshot.NewSnapshotter(l, config.RootDir)
+	snapshotter.SetRetries(opts.SnapshotRetry)
+	if err := snapshotter.SetWhiteoutStrategy(opts.WhiteoutStrategy); err != nil {
+		return nil, err
+	}
+	return snapshotter, nil
 }
 
 // newStageBuilder returns a new type stageBuilder which contains all the information required to build the stage
-func newStageBuilder(args *dockerfile.BuildArgs, opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string, stageNameToIdx map[string]string, fileContext util.FileContext) (*stageBuilder, error) {
+func newStageBuilder(args *dockerfile.BuildArgs, opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string, stageNameToIdx map[string]string, fileContext util.FileContext, commandArgs map[instructions.Command][]string) (*stageBuilder, error) {
 	sourceImage, err := image_util.RetrieveSourceImage(stage, opts)
 	if err != nil {
 		return nil, err
 	}
+	sourceImage, err = convertImageFormat(sourceImage, opts.ImageFormat)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting the media types of the base image")
+	}
 
 	_opts := *opts
 	if !stage.Final {
@@ -116,7 +154,7 @@ func newStageBuilder(args *dockerfile.BuildArgs, opts *config.KanikoOptions, sta
 		return nil, err
 	}
 
-	snapshotter, err := makeSnapshotter(opts)
+	snapshotter, err := newSnapshotter(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -147,9 +185,14 @@ func newStageBuilder(args *dockerfile.BuildArgs, opts *config.KanikoOptions, sta
 	if err != nil {
 		return nil, err
 	}
+	layerCache, err := newLayerCache(opts)
+	if err != nil {
+		return nil, err
+	}
 	s := &stageBuilder{
 		stage:            stage,
 		image:            sourceImage,
+		baseImage:        sourceImage,
 		cf:               imageConfig,
 		snapshotter:      snapshotter,
 		baseImageDigest:  digest.String(),
@@ -159,11 +202,23 @@ func newStageBuilder(args *dockerfile.BuildArgs, opts *config.KanikoOptions, sta
 		crossStageDeps:   crossStageDeps,
 		digestToCacheKey: dcm,
 		stageIdxToDigest: sid,
-		layerCache:       newLayerCache(opts),
+		layerCache:       layerCache,
 		pushLayerToCache: pushLayerToCache,
+		commandArgs:      map[int][]string{},
+		scopedArgs:       map[int]bool{},
+	}
+
+	for _, p := range opts.HideHistory {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid --hide-history pattern %q", p)
+		}
+		s.hideHistory = append(s.hideHistory, re)
 	}
 
-	for _, cmd := range s.stage.Commands {
+	scopeRunArgs := config.EnvBool("FF_KANIKO_SCOPED_RUN_ARGS")
+	declaredArgs := map[string]bool{}
+	for i, cmd := range s.stage.Commands {
 		command, err := commands.GetCommand(cmd, fileContext, opts.RunV2, opts.CacheCopyLayers, opts.CacheRunLayers)
 		if err != nil {
 			return nil, err
@@ -171,12 +226,68 @@ func newStageBuilder(args *dockerfile.BuildArgs, opts *config.KanikoOptions, sta
 		if command == nil {
 			continue
 		}
+		if args, ok := commandArgs[cmd]; ok {
+			s.commandArgs[len(s.cmds)] = args
+		}
+		if argCmd, ok := cmd.(*instructions.ArgCommand); ok {
+			if _, isRun := nextCommand(s.stage.Commands, i).(*instructions.RunCommand); isRun && scopeRunArgs && redeclaresArgs(argCmd, declaredArgs) {
+				s.scopedArgs[len(s.cmds)] = true
+			}
+			for _, arg := range argCmd.Args {
+				declaredArgs[arg.Key] = true
+			}
+		}
 		s.cmds = append(s.cmds, command)
 	}
 	s.args.AddMetaArgs(s.stage.MetaArgs)
 	return s, nil
 }
 
+func nextCommand(cmds []instructions.Command, i int) instructions.Command {
+	if i+1 < len(cmds) {
+		return cmds[i+1]
+	}
+	return nil
+}
+
+// redeclaresArgs returns true if cmd gives a new default to args that were declared before.
+func redeclaresArgs(cmd *instructions.ArgCommand, declared map[string]bool) bool {
+	for _, arg := range cmd.Args {
+		if arg.Value == nil || !declared[arg.Key] {
+			return false
+		}
+	}
+	return len(cmd.Args) > 0
+}
+
+// argsFor returns the build args the command at index of cmds runs with. The build args
+// of a scoped ARG are reverted once the command after it ran, and the build args of
+// --command-build-arg only apply to their command.
+func (s *stageBuilder) argsFor(index int) *dockerfile.BuildArgs {
+	if s.argsBeforeScope != nil && index >= s.scopeEnd {
+		s.endArgScope()
+	}
+	if s.scopedArgs[index] {
+		s.argsBeforeScope = s.args.Clone()
+		s.scopeEnd = index + 2
+	}
+	args, ok := s.commandArgs[index]
+	if !ok {
+		return s.args
+	}
+	scoped := s.args.Clone()
+	scoped.AddCommandArgs(args)
+	return scoped
+}
+
+// endArgScope reverts the build args of a scoped ARG.
+func (s *stageBuilder) endArgScope() {
+	if s.argsBeforeScope != nil {
+		s.args = s.argsBeforeScope
+		s.argsBeforeScope = nil
+	}
+}
+
 func initConfig(img partial.WithConfigFile, opts *config.KanikoOptions) (*v1.ConfigFile, error) {
 	imageConfig, err := img.ConfigFile()
 	if err != nil {
@@ -208,15 +319,36 @@ func initConfig(img partial.WithConfigFile, opts *config.KanikoOptions) (*v1.Con
 	return imageConfig, nil
 }
 
-func newLayerCache(opts *config.KanikoOptions) cache.LayerCache {
+func newLayerCache(opts *config.KanikoOptions) (cache.LayerCache, error) {
+	var layerCache cache.LayerCache = &cache.RegistryCache{
+		Opts: opts,
+	}
 	if isOCILayout(opts.CacheRepo) {
-		return &cache.LayoutCache{
+		layerCache = &cache.LayoutCache{
 			Opts: opts,
 		}
 	}
-	return &cache.RegistryCache{
-		Opts: opts,
+	if opts.CacheBackend != "" {
+		backend, err := cache.NewBackend(opts.CacheBackend, &opts.CacheOptions)
+		if err != nil {
+			return nil, err
+		}
+		layerCache = &cache.BackendCache{Backend: backend}
+	}
+	if len(opts.CacheFrom) > 0 {
+		layerCache = &cache.InlineCache{
+			Opts:     opts,
+			Fallback: layerCache,
+		}
 	}
+	if opts.CacheImportDir != "" {
+		layerCache = &cache.ExportedCache{
+			Path:     opts.CacheImportDir,
+			TTL:      opts.CacheTTL,
+			Fallback: layerCache,
+		}
+	}
+	return layerCache, nil
 }
 
 func isOCILayout(path string) bool {
@@ -239,6 +371,14 @@ func (s *stageBuilder) populateCompositeKey(command commands.DockerCommand, file
 			compositeKey.AddKey(fmt.Sprintf("|%d", len(replacementEnvs)))
 			compositeKey.AddKey(replacementEnvs...)
 		}
+		// the umask changes the permissions of the files created by RUN
+		if s.fileContext.RunUmask != nil {
+			compositeKey.AddKey(fmt.Sprintf("|umask=%o", *s.fileContext.RunUmask))
+		}
+		// a layer cached by a RUN with network access must not pass for a hermetic one
+		if s.fileContext.HermeticRun {
+			compositeKey.AddKey("|hermetic")
+		}
 	}
 
 	// Add the next command to the cache key.
@@ -249,9 +389,89 @@ func (s *stageBuilder) populateCompositeKey(command commands.DockerCommand, file
 			return compositeKey, err
 		}
 	}
+	// Default modes change the permissions of the copied files
+	if len(files) > 0 && (s.fileContext.DefaultDirMode != 0 || s.fileContext.DefaultFileMode != 0) {
+		compositeKey.AddKey(fmt.Sprintf("|mode=%o:%o", s.fileContext.DefaultDirMode, s.fileContext.DefaultFileMode))
+	}
+	// the copied files keep the ownership of the context instead of getting the active user's
+	if len(files) > 0 && s.fileContext.PreserveOwnership {
+		compositeKey.AddKey("|preserve-ownership")
+	}
+	// the copied files carry the access times of the context into the layer
+	if len(files) > 0 && s.fileContext.PreserveAtime {
+		compositeKey.AddKey("|preserve-atime")
+	}
+	// the copied files get the times of their last commits instead of their mtimes
+	if len(files) > 0 && s.fileContext.CommitTimes != nil {
+		compositeKey.AddKey("|git-commit-mtimes")
+	}
+	if len(files) > 0 {
+		// the transforms add files to the layer
+		for _, t := range s.fileContext.Transforms {
+			compositeKey.AddKey(fmt.Sprintf("|transform=%s=%s", t.Pattern, t.Transform))
+		}
+		// the owners of the copied files differ by path
+		for _, r := range s.fileContext.OwnerRules {
+			compositeKey.AddKey(fmt.Sprintf("|owner=%s=%s", r.Pattern, r.Chown))
+		}
+	}
 	return compositeKey, nil
 }
 
+// cacheKey returns the key the output layer of command is cached under.
+// With --cache-run-layers-portable the key of a RUN command only depends on the base image,
+// the resolved args and envs and the command itself, so identical RUN instructions on the same
+// base image share their cached layer across Dockerfiles and build contexts.
+func (s *stageBuilder) cacheKey(command commands.DockerCommand, compositeKey CompositeCache, args *dockerfile.BuildArgs, env []string) (string, error) {
+	if !s.opts.CacheRunLayersPortable || !command.IsArgsEnvsRequiredInCache() {
+		return compositeKey.Hash()
+	}
+	portable := NewCompositeCache("portable", s.baseImageDigest)
+	portable.AddKey(s.baseKeys()...)
+	portableKey, err := s.populateCompositeKey(command, nil, *portable, args, env)
+	if err != nil {
+		return "", err
+	}
+	logrus.Debugf("Portable composite key for command %v %v", command.String(), portableKey)
+	return portableKey.Hash()
+}
+
+// layerCacheKey returns the key of the output layer of a command that copies files from the
+// context, which only depends on the command, the working directory and the copied files, so
+// that the layer can be reused by builds whose earlier instructions differ, see --cache-layer-keys.
+// The destination is part of the key as the environment resolves it, along with whether the files
+// are copied into it as a directory. Commands that don't copy files from the context have no layer
+// cache key, nor do those whose files are owned by a user or group name before the filesystem it
+// is looked up in is unpacked.
+func (s *stageBuilder) layerCacheKey(command commands.DockerCommand, files []string, args *dockerfile.BuildArgs, cfg *v1.Config) (string, error) {
+	if len(files) == 0 || command.IsArgsEnvsRequiredInCache() {
+		return "", nil
+	}
+	// the copied files are owned by the active user or --chown
+	owner, ok, err := commands.CopiedFilesOwner(command, cfg, args, s.fsUnpacked)
+	if err != nil || !ok {
+		return "", err
+	}
+	layerKey, err := s.populateCompositeKey(command, files, *NewCompositeCache("layer", cfg.WorkingDir), args, cfg.Env)
+	if err != nil {
+		return "", err
+	}
+	if owner != "" {
+		layerKey.AddKey("|chown=" + owner)
+	}
+	// the command names the destination before the environment is replaced
+	dest, dir, err := commands.CopiedFilesDestination(command, cfg, args)
+	if err != nil {
+		return "", err
+	}
+	layerKey.AddKey("|dest=" + dest)
+	if dir {
+		layerKey.AddKey("|dest-dir")
+	}
+	logrus.Debugf("Layer composite key for command %v %v", command.String(), layerKey)
+	return layerKey.Hash()
+}
+
 func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) error {
 	if !s.opts.Cache {
 		return nil
@@ -260,6 +480,7 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 	// Restore build args back to their original values
 	defer func() {
 		s.args = buildArgs
+		s.argsBeforeScope = nil
 	}()
 
 	stopCache := false
@@ -271,18 +492,19 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 		if command == nil {
 			continue
 		}
-		files, err := command.FilesUsedFromContext(&cfg, s.args)
+		args := s.argsFor(i)
+		files, err := filesUsedFromContext(command, &cfg, args)
 		if err != nil {
 			return errors.Wrap(err, "failed to get files used from context")
 		}
 
-		compositeKey, err = s.populateCompositeKey(command, files, compositeKey, s.args, cfg.Env)
+		compositeKey, err = s.populateCompositeKey(command, files, compositeKey, args, cfg.Env)
 		if err != nil {
 			return err
 		}
 
 		logrus.Debugf("Optimize: composite key for command %v %v", command.String(), compositeKey)
-		ck, err := compositeKey.Hash()
+		ck, err := s.cacheKey(command, compositeKey, args, cfg.Env)
 		if err != nil {
 			return errors.Wrap(err, "failed to hash composite key")
 		}
@@ -298,18 +520,30 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 				logrus.Infof("No cached layer found for cmd %s", command.String())
 				logrus.Debugf("Key missing was: %s", compositeKey.Key())
 				stopCache = true
+				if err := s.useLayerCache(i, command, files, args, &cfg); err != nil {
+					return err
+				}
 				continue
 			}
 
 			if cacheCmd := command.CacheCommand(img); cacheCmd != nil {
 				logrus.Infof("Using caching version of cmd: %s", command.String())
 				s.cmds[i] = cacheCmd
+				s.cacheHits++
+				if s.cacheHitImages == nil {
+					s.cacheHitImages = map[int]cacheHitImage{}
+				}
+				s.cacheHitImages[i] = cacheHitImage{key: ck, image: img}
+			}
+		} else if command.ShouldCacheOutput() {
+			if err := s.useLayerCache(i, command, files, args, &cfg); err != nil {
+				return err
 			}
 		}
 
 		// Mutate the config for any commands that require it.
 		if command.MetadataOnly() {
-			if err := command.ExecuteCommand(&cfg, s.args); err != nil {
+			if err := command.ExecuteCommand(&cfg, args); err != nil {
 				return err
 			}
 		}
@@ -317,15 +551,67 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 	return nil
 }
 
-func (s *stageBuilder) build() error {
-	// Set the initial cache key to be the base image digest, the build args and the SrcContext.
+// initialCompositeKey returns the key the cache keys of the commands of the stage
+// start from, the cache key of the stage the base image was built by or its digest,
+// and the base keys.
+func (s *stageBuilder) initialCompositeKey() *CompositeCache {
 	var compositeKey *CompositeCache
 	if cacheKey, ok := s.digestToCacheKey[s.baseImageDigest]; ok {
 		compositeKey = NewCompositeCache(cacheKey)
 	} else {
 		compositeKey = NewCompositeCache(s.baseImageDigest)
 	}
+	compositeKey.AddKey(s.baseKeys()...)
+	return compositeKey
+}
 
+// baseKeys returns the keys of the filesystem the commands run on besides the base
+// image: its platform and the paths --base-exclude-path leaves out when unpacking it.
+func (s *stageBuilder) baseKeys() []string {
+	var keys []string
+	if platform := s.platformKey(); platform != "" {
+		keys = append(keys, platform)
+	}
+	excludes := append([]string(nil), s.opts.BaseExcludePaths...)
+	sort.Strings(excludes)
+	for _, p := range excludes {
+		keys = append(keys, "|base-exclude="+p)
+	}
+	return keys
+}
+
+// platformKey returns the platform of the base image to mix into cache keys, or
+// nothing with --cache-ignore-platform. The digest of scratch is the same for every
+// platform, and base images may lack a platform, so the platform of the build is
+// used for them. Otherwise builds for other architectures could share layers.
+func (s *stageBuilder) platformKey() string {
+	if s.opts.CacheIgnorePlatform {
+		return ""
+	}
+	var platform v1.Platform
+	if s.cf != nil {
+		platform = v1.Platform{OS: s.cf.OS, Architecture: s.cf.Architecture, Variant: s.cf.Variant}
+	}
+	if platform.Architecture == "" {
+		platform = v1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+		if s.opts.CustomPlatform != "" {
+			if p, err := v1.ParsePlatform(s.opts.CustomPlatform); err == nil {
+				platform = *p
+			}
+		}
+	}
+	return "|platform=" + platform.String()
+}
+
+func (s *stageBuilder) build() error {
+	// Set the initial cache key to be the base image digest, its platform, the build args and the SrcContext.
+	compositeKey := s.initialCompositeKey()
+
+	s.fsUnpacked = s.stage.Index == 0 && s.opts.InitialFSUnpacked
+	// the cache keys optimize computes find the digests of the context files
+	if s.opts.Cache && s.opts.DigestConcurrency > 1 {
+		s.precomputeContextDigests()
+	}
 	// Apply optimizations to the instructions.
 	if err := s.optimize(*compositeKey, s.cf.Config); err != nil {
 		return errors.Wrap(err, "failed to optimize instructions")
@@ -343,7 +629,7 @@ func (s *stageBuilder) build() error {
 	if len(s.crossStageDeps[s.stage.Index]) > 0 {
 		shouldUnpack = true
 	}
-	if s.stage.Final && s.opts.Materialize {
+	if s.stage.Final && (s.opts.Materialize || s.opts.SBOMPath != "" || s.opts.RootFSManifestVerify != "") {
 		shouldUnpack = true
 	}
 	if s.stage.Index == 0 && s.opts.InitialFSUnpacked {
@@ -354,13 +640,18 @@ func (s *stageBuilder) build() error {
 		t := timing.Start("FS Unpacking")
 
 		retryFunc := func() error {
-			_, err := getFSFromImage(config.RootDir, s.image, util.ExtractFile)
+			fsOpts := []util.FSOpt{util.ExcludePaths(s.opts.BaseExcludePaths...)}
+			if s.opts.CheckDiskSpace {
+				fsOpts = append(fsOpts, util.CheckExtractDiskSpace())
+			}
+			_, err := getFSFromImage(config.RootDir, s.image, util.ExtractFile, fsOpts...)
 			return err
 		}
 
 		if err := util.Retry(retryFunc, s.opts.ImageFSExtractRetry, 1000); err != nil {
 			return errors.Wrap(err, "failed to get filesystem from image")
 		}
+		s.fsUnpacked = true
 
 		timing.DefaultRun.Stop(t)
 	} else {
@@ -380,21 +671,32 @@ func (s *stageBuilder) build() error {
 		if command == nil {
 			continue
 		}
+		if err := util.CheckInterrupted(); err != nil {
+			return err
+		}
 
 		t := timing.Start("Command: " + command.String())
+		args := s.argsFor(index)
 
 		// If the command uses files from the context, add them.
-		files, err := command.FilesUsedFromContext(&s.cf.Config, s.args)
+		files, err := filesUsedFromContext(command, &s.cf.Config, args)
 		if err != nil {
 			return errors.Wrap(err, "failed to get files used from context")
 		}
 
 		if s.opts.Cache {
-			*compositeKey, err = s.populateCompositeKey(command, files, *compositeKey, s.args, s.cf.Config.Env)
+			*compositeKey, err = s.populateCompositeKey(command, files, *compositeKey, args, s.cf.Config.Env)
 			if err != nil && s.opts.Cache {
 				return err
 			}
 		}
+		// the files used from the context are replaced by the ones to snapshot below
+		var layerKey string
+		if s.opts.Cache && s.opts.CacheLayerKeys && s.stage.Final {
+			if layerKey, err = s.layerCacheKey(command, files, args, &s.cf.Config); err != nil {
+				return errors.Wrap(err, "failed to hash layer cache key")
+			}
+		}
 
 		logrus.Info(command.String())
 
@@ -406,46 +708,108 @@ func (s *stageBuilder) build() error {
 				return false
 			}
 		}()
-		if !initSnapshotTaken && !isCacheCommand && !command.ProvidesFilesToSnapshot() {
+		if !initSnapshotTaken && !isCacheCommand && (!command.ProvidesFilesToSnapshot() || s.opts.PrintLayerDiffs) {
 			// Take initial snapshot if command does not expect to return
-			// a list of files.
+			// a list of files, or to tell added from modified files in layer diffs.
 			if err := s.initSnapshotWithTimings(); err != nil {
 				return err
 			}
 			initSnapshotTaken = true
 		}
 
-		if err := command.ExecuteCommand(&s.cf.Config, s.args); err != nil {
+		if err := command.ExecuteCommand(&s.cf.Config, args); err != nil {
+			s.checkpointOnFailure()
 			return errors.Wrap(err, "failed to execute command")
 		}
+		if s.checkpointDue(index) {
+			if err := writeCheckpoint(s.opts.CheckpointTarPath); err != nil {
+				return err
+			}
+		}
 		files = command.FilesToSnapshot()
 		timing.DefaultRun.Stop(t)
+		// the paths of commands without files, like RUN, are read from their layer
+		recordOrigins := s.opts.FileProvenancePath != "" && s.stage.Final
+		if recordOrigins && files != nil {
+			fileOrigins.addFiles(s.stage.Index, index, command, files)
+		}
 
 		if !s.shouldTakeSnapshot(index, command.MetadataOnly()) {
 			logrus.Debugf("Build: skipping snapshot for [%v]", command.String())
 			continue
 		}
+		emptyLayer := s.isEmptyLayer(command, files)
+		splitFiles, err := s.splitCopyFiles(command, files)
+		if err != nil {
+			return err
+		}
+		inlineCache := s.opts.Cache && s.opts.CacheInline && s.stage.Final && (isCacheCommand || command.ShouldCacheOutput())
+		saveLayerKey := layerKey != "" && (isCacheCommand || command.ShouldCacheOutput())
+		var history int
+		if inlineCache || saveLayerKey {
+			if history, err = s.historyLen(); err != nil {
+				return err
+			}
+		}
 		if isCacheCommand {
+			// the export covers the whole build, not only the layers built by it
+			if hit, ok := s.cacheHitImages[index]; ok && s.opts.CacheExportDir != "" {
+				cacheGroup.Go(func() error {
+					return (&cache.ExportedCache{Path: s.opts.CacheExportDir}).Put(hit.key, hit.image)
+				})
+			}
 			v := command.(commands.Cached)
 			layer := v.Layer()
 			if layer == nil {
 				// a cache image without a layer indicates that no files were changed, ie. by 'WORKDIR /' prior to v1.25.0
 				// We continue to handle this case here as users might still have cache entries lying around
 				logrus.Info("No files were changed, appending empty layer to config. No layer added to image.")
+			} else if emptyLayer {
+				if err := s.saveEmptyLayerHistory(command.String()); err != nil {
+					return errors.Wrap(err, "failed to save history")
+				}
 			} else {
+				if s.opts.PrintLayerDiffs {
+					printLayerDiff(command.String(), layer.Uncompressed, s.snapshotter.Paths())
+				}
+				if recordOrigins && files == nil {
+					if err := fileOrigins.addLayer(s.stage.Index, index, command, layer.Uncompressed); err != nil {
+						return err
+					}
+				}
 				if err := s.saveLayerToImage(layer, command.String()); err != nil {
 					return errors.Wrap(err, "failed to save layer")
 				}
 			}
+		} else if len(splitFiles) > 1 {
+			if err := s.saveSplitSnapshotsToImage(command.String(), splitFiles); err != nil {
+				return err
+			}
+		} else if s.streamsSnapshot() {
+			if err := s.saveStreamedSnapshotToImage(command.String()); err != nil {
+				return errors.Wrap(err, "failed to save streamed snapshot to image")
+			}
 		} else {
+			var before map[string]struct{}
+			if s.opts.PrintLayerDiffs {
+				before = s.snapshotter.Paths()
+			}
 			tarPath, err := s.takeSnapshot(files, command.ShouldDetectDeletedFiles())
 			if err != nil {
 				return errors.Wrap(err, "failed to take snapshot")
 			}
+			if s.opts.PrintLayerDiffs && tarPath != "" && !emptyLayer {
+				printLayerDiff(command.String(), func() (io.ReadCloser, error) { return os.Open(tarPath) }, before)
+			}
+			if recordOrigins && files == nil && tarPath != "" {
+				if err := fileOrigins.addLayer(s.stage.Index, index, command, func() (io.ReadCloser, error) { return os.Open(tarPath) }); err != nil {
+					return err
+				}
+			}
 
 			if s.opts.Cache {
 				logrus.Debugf("Build: composite key for command %v %v", command.String(), compositeKey)
-				ck, err := compositeKey.Hash()
+				ck, err := s.cacheKey(command, *compositeKey, args, s.cf.Config.Env)
 				if err != nil {
 					return errors.Wrap(err, "failed to hash composite key")
 				}
@@ -453,17 +817,32 @@ func (s *stageBuilder) build() error {
 				logrus.Debugf("Build: cache key for command %v %v", command.String(), ck)
 
 				// Push layer to cache (in parallel) now along with new config file
-				if command.ShouldCacheOutput() && !s.opts.NoPushCache {
+				if command.ShouldCacheOutput() && (!s.opts.NoPushCache || s.opts.CacheExportDir != "") {
 					cacheGroup.Go(func() error {
 						return s.pushLayerToCache(s.opts, ck, tarPath, command.String())
 					})
 				}
 			}
-			if err := s.saveSnapshotToImage(command.String(), tarPath); err != nil {
+			if emptyLayer {
+				if err := s.saveEmptyLayerHistory(command.String()); err != nil {
+					return errors.Wrap(err, "failed to save history")
+				}
+			} else if err := s.saveSnapshotToImage(command.String(), tarPath); err != nil {
 				return errors.Wrap(err, "failed to save snapshot to image")
 			}
 		}
+		if inlineCache {
+			if err := s.saveInlineCacheKey(command, *compositeKey, args, history); err != nil {
+				return err
+			}
+		}
+		if saveLayerKey {
+			if err := s.saveLayerCacheKey(layerKey, history); err != nil {
+				return err
+			}
+		}
 	}
+	s.endArgScope()
 
 	if err := cacheGroup.Wait(); err != nil {
 		logrus.Warnf("Error uploading layer to cache: %s", err)
@@ -472,6 +851,81 @@ func (s *stageBuilder) build() error {
 	return nil
 }
 
+func (s *stageBuilder) historyLen() (int, error) {
+	cf, err := s.image.ConfigFile()
+	if err != nil {
+		return 0, errors.Wrap(err, "getting image config")
+	}
+	return len(cf.History), nil
+}
+
+// saveInlineCacheKey records the cache key of the layer command added to the image,
+// history is the length of the history before command.
+func (s *stageBuilder) saveInlineCacheKey(command commands.DockerCommand, compositeKey CompositeCache, args *dockerfile.BuildArgs, history int) error {
+	n, err := s.historyLen()
+	if err != nil {
+		return err
+	}
+	if n == history {
+		return nil
+	}
+	ck, err := s.cacheKey(command, compositeKey, args, s.cf.Config.Env)
+	if err != nil {
+		return errors.Wrap(err, "failed to hash composite key")
+	}
+	if s.inlineCacheKeys == nil {
+		s.inlineCacheKeys = map[int]string{}
+	}
+	s.inlineCacheKeys[n-1] = ck
+	return nil
+}
+
+// saveLayerCacheKey records the layer cache key lk of the layer a command added to the image,
+// history is the length of the history before the command.
+func (s *stageBuilder) saveLayerCacheKey(lk string, history int) error {
+	n, err := s.historyLen()
+	if err != nil {
+		return err
+	}
+	if n == history {
+		return nil
+	}
+	if s.layerCacheKeys == nil {
+		s.layerCacheKeys = map[int]string{}
+	}
+	s.layerCacheKeys[n-1] = lk
+	return nil
+}
+
+// useLayerCache replaces the command at index i, whose layer isn't cached under its cache key,
+// with its caching version if its layer is found by its layer cache key in the images of
+// --cache-from. The layer of a command that copies files from the context can be reused
+// even if the instructions before it differ, see --cache-layer-keys.
+func (s *stageBuilder) useLayerCache(i int, command commands.DockerCommand, files []string, args *dockerfile.BuildArgs, cfg *v1.Config) error {
+	inlineCache, ok := s.layerCache.(*cache.InlineCache)
+	if !s.opts.CacheLayerKeys || !ok {
+		return nil
+	}
+	lk, err := s.layerCacheKey(command, files, args, cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to hash layer cache key")
+	}
+	if lk == "" {
+		return nil
+	}
+	img, err := inlineCache.RetrieveImportedLayer(lk)
+	if err != nil {
+		logrus.Debugf("No layer found for cmd %s by its layer cache key %s: %s", command.String(), lk, err)
+		return nil
+	}
+	if cacheCmd := command.CacheCommand(img); cacheCmd != nil {
+		logrus.Infof("Using caching version of cmd: %s, found by its layer cache key", command.String())
+		s.cmds[i] = cacheCmd
+		s.cacheHits++
+	}
+	return nil
+}
+
 func (s *stageBuilder) takeSnapshot(files []string, shdDelete bool) (string, error) {
 	var snapshot string
 	var err error
@@ -488,6 +942,47 @@ func (s *stageBuilder) takeSnapshot(files []string, shdDelete bool) (string, err
 	return snapshot, err
 }
 
+// streamsSnapshot returns true if the snapshot is added as a layer that is generated
+// from the filesystem when it is read, see --stream-layers. Only the final stage does,
+// as the filesystem of the other stages is gone by the time the image is pushed.
+func (s *stageBuilder) streamsSnapshot() bool {
+	if !s.opts.StreamLayers || !s.opts.SingleSnapshot || !s.stage.Final {
+		return false
+	}
+	if _, ok := s.snapshotter.(StreamingSnapshotter); !ok {
+		logrus.Warnf("Snapshotter %s can't stream layers, writing the layer to disk", s.opts.Snapshotter)
+		return false
+	}
+	return true
+}
+
+// saveStreamedSnapshotToImage adds a snapshot of the filesystem to the image as a
+// layer that isn't written to disk.
+func (s *stageBuilder) saveStreamedSnapshotToImage(createdBy string) error {
+	var before map[string]struct{}
+	if s.opts.PrintLayerDiffs {
+		before = s.snapshotter.Paths()
+	}
+	t := timing.Start("Snapshotting FS")
+	open, err := s.snapshotter.(StreamingSnapshotter).StreamSnapshotFS()
+	timing.DefaultRun.Stop(t)
+	if err != nil {
+		return err
+	}
+	if s.opts.PrintLayerDiffs {
+		printLayerDiff(createdBy, open, before)
+	}
+	imageMediaType, err := s.image.MediaType()
+	if err != nil {
+		return err
+	}
+	mediaType := types.DockerLayer
+	if extractMediaTypeVendor(imageMediaType) == types.OCIVendorPrefix {
+		mediaType = types.OCILayer
+	}
+	return s.saveLayerToImage(newStreamedLayer(open, s.opts.CompressionLevel, mediaType), createdBy)
+}
+
 func (s *stageBuilder) shouldTakeSnapshot(index int, isMetadatCmd bool) bool {
 	isLastCommand := index == len(s.cmds)-1
 
@@ -505,6 +1000,51 @@ func (s *stageBuilder) shouldTakeSnapshot(index int, isMetadatCmd bool) bool {
 	return !isMetadatCmd
 }
 
+// isEmptyLayer returns true if command changed no files, in which case it only adds
+// to the history of the image, unless --keep-empty-layers is set. The layer of a RUN
+// command is always kept, cached or not, so that both builds produce the same image.
+func (s *stageBuilder) isEmptyLayer(command commands.DockerCommand, files []string) bool {
+	if s.opts.KeepEmptyLayers || s.opts.SingleSnapshot || len(files) > 0 || !command.ProvidesFilesToSnapshot() {
+		return false
+	}
+	switch command.(type) {
+	case *commands.RunMarkerCommand, *commands.CachingRunCommand:
+		return false
+	}
+	return true
+}
+
+// hiddenFromHistory returns true if createdBy matches a pattern of --hide-history.
+func (s *stageBuilder) hiddenFromHistory(createdBy string) bool {
+	for _, re := range s.hideHistory {
+		if re.MatchString(createdBy) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveEmptyLayerHistory records createdBy in the history of the image without adding a layer.
+// Nothing is recorded for a command hidden from the history.
+func (s *stageBuilder) saveEmptyLayerHistory(createdBy string) error {
+	logrus.Infof("No files were changed by %s, no layer added to image", createdBy)
+	if s.hiddenFromHistory(createdBy) {
+		logrus.Debugf("Leaving %s out of the history", createdBy)
+		return nil
+	}
+	var err error
+	s.image, err = mutate.Append(s.image,
+		mutate.Addendum{
+			History: v1.History{
+				Author:     constants.Author,
+				CreatedBy:  createdBy,
+				EmptyLayer: true,
+			},
+		},
+	)
+	return err
+}
+
 func (s *stageBuilder) saveSnapshotToImage(createdBy string, tarPath string) error {
 	layer, err := s.saveSnapshotToLayer(tarPath)
 	if err != nil {
@@ -608,6 +1148,12 @@ func (s *stageBuilder) convertLayerMediaType(layer v1.Layer) (v1.Layer, error) {
 	if err != nil {
 		return nil, err
 	}
+	if layerMediaType == types.OCILayerZStd && s.opts.Compression != config.ZStd &&
+		extractMediaTypeVendor(imageMediaType) == types.OCIVendorPrefix {
+		// Layers retrieved from a zstd compressed cache are recompressed to honour --compression
+		layerOpts := append(s.getLayerOptionFromOpts(), tarball.WithMediaType(types.OCILayer))
+		return tarball.LayerFromOpener(layer.Uncompressed, layerOpts...)
+	}
 	if extractMediaTypeVendor(layerMediaType) != extractMediaTypeVendor(imageMediaType) {
 		layerOpts := s.getLayerOptionFromOpts()
 		targetMediaType := convertMediaType(layerMediaType)
@@ -656,6 +1202,11 @@ func (s *stageBuilder) saveLayerToImage(layer v1.Layer, createdBy string) error
 		layer = el
 	}
 
+	// every layer needs an entry in the history, the one of a hidden command doesn't tell what created it
+	if s.hiddenFromHistory(createdBy) {
+		logrus.Debugf("Leaving %s out of the history", createdBy)
+		createdBy = ""
+	}
 	s.image, err = mutate.Append(s.image,
 		mutate.Addendum{
 			Layer: layer,
@@ -707,6 +1258,22 @@ func CalculateDependencies(stages []config.KanikoStage, opts *config.KanikoOptio
 						return nil, err
 					}
 					depGraph[i] = append(depGraph[i], resolved...)
+					if cmd.Chown != "" {
+						// the names of --chown are resolved with the users and groups of the stage
+						depGraph[i] = append(depGraph[i], "/etc/passwd", "/etc/group")
+					}
+				}
+			case *instructions.RunCommand:
+				for _, m := range dockerfile.BindMounts(cmd) {
+					i, err := strconv.Atoi(m.From)
+					if err != nil {
+						continue
+					}
+					source, err := util.ResolveEnvironmentReplacement(m.Source, ba.ReplacementEnvs(cfg.Config.Env), true)
+					if err != nil {
+						return nil, err
+					}
+					depGraph[i] = append(depGraph[i], filepath.Join("/", source))
 				}
 			case *instructions.EnvCommand:
 				if err := util.UpdateConfigEnv(cmd.Env, &cfg.Config, ba.ReplacementEnvs(cfg.Config.Env)); err != nil {
@@ -733,18 +1300,101 @@ func CalculateDependencies(stages []config.KanikoStage, opts *config.KanikoOptio
 
 // DoBuild executes building the Dockerfile
 func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
+	diagnostics.Reset()
+	stageReports.reset()
+	fileOrigins.reset()
+	image, err := doBuild(opts)
+	// the files of the completed stages are kept for the rerun to skip them
+	if errors.Is(err, util.ErrInterrupted) && opts.ResumeStatePath == "" {
+		removeTempDirs()
+	}
+	if err == nil && opts.FileProvenancePath != "" {
+		if err := fileOrigins.writeFile(opts.FileProvenancePath); err != nil {
+			return nil, err
+		}
+	}
+	if opts.BuildReportPath != "" {
+		// the stages built before a failure are reported as well
+		if werr := stageReports.writeFile(opts.BuildReportPath); werr != nil {
+			if err == nil {
+				return nil, werr
+			}
+			logrus.Warnf("Failed to write build report: %v", werr)
+		}
+	}
+	if opts.DiagnosticsFile != "" {
+		// the diagnostics are written for failed builds as well
+		if werr := diagnostics.WriteFile(opts.DiagnosticsFile); werr != nil {
+			if err == nil {
+				return nil, werr
+			}
+			logrus.Warnf("Failed to write diagnostics: %v", werr)
+		}
+	}
+	return image, err
+}
+
+// dumpResolvedDockerfile writes the Dockerfile with its args and env expanded to
+// --dump-resolved-dockerfile.
+func dumpResolvedDockerfile(opts *config.KanikoOptions) error {
+	b, err := dockerfile.LoadDockerfile(opts)
+	if err != nil {
+		return err
+	}
+	resolved, err := dockerfile.ResolvedDockerfile(b, opts.BuildArgs)
+	if err != nil {
+		return errors.Wrap(err, "resolving dockerfile")
+	}
+	if err := os.WriteFile(opts.DumpResolvedDockerfile, []byte(resolved), 0644); err != nil {
+		return errors.Wrap(err, "writing resolved dockerfile")
+	}
+	return nil
+}
+
+// removeTempDirs removes the directories kaniko stores intermediate files of a build in.
+// Directories that are the kaniko directory itself and the cache mounts are kept.
+func removeTempDirs() {
+	for _, dir := range []string{
+		config.KanikoIntermediateStagesDir,
+		config.KanikoInterStageDepsDir,
+		config.KanikoLayersDir,
+		config.KanikoSwapDir,
+		config.KanikoBindMountDir,
+		config.KanikoHeredocDir,
+		prefetchDir(),
+	} {
+		if filepath.Clean(dir) == filepath.Clean(config.KanikoDir) {
+			continue
+		}
+		logrus.Debugf("Removing %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Warnf("Failed to remove %s: %v", dir, err)
+		}
+	}
+}
+
+func doBuild(opts *config.KanikoOptions) (_ v1.Image, err error) {
 	t := timing.Start("Total Build Time")
+	contextDigests.Reset()
+	usedContextFiles.reset()
+	provenanceMaterials.reset()
+	dockerfile.ResetInstructionFlags()
 	digestToCacheKey := make(map[string]string)
 	stageIdxToDigest := make(map[string]string)
 
 	stages, metaArgs, err := dockerfile.ParseStages(opts)
 	if err != nil {
-		return nil, err
+		return nil, util.UserError(err)
+	}
+	if opts.DumpResolvedDockerfile != "" {
+		if err := dumpResolvedDockerfile(opts); err != nil {
+			return nil, err
+		}
 	}
 
 	kanikoStages, err := dockerfile.MakeKanikoStages(opts, stages, metaArgs)
 	if err != nil {
-		return nil, err
+		return nil, util.UserError(err)
 	}
 	stageNameToIdx := ResolveCrossStageInstructions(kanikoStages)
 
@@ -752,6 +1402,64 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 	if err != nil {
 		return nil, err
 	}
+	if fileContext.DefaultDirMode, err = parseDefaultMode(opts.DefaultDirMode); err != nil {
+		return nil, errors.Wrap(err, "parsing --default-dir-mode")
+	}
+	if fileContext.DefaultFileMode, err = parseDefaultMode(opts.DefaultFileMode); err != nil {
+		return nil, errors.Wrap(err, "parsing --default-file-mode")
+	}
+	if fileContext.MaxMode, err = parseDefaultMode(opts.MaxCopyMode); err != nil {
+		return nil, errors.Wrap(err, "parsing --max-copy-mode")
+	}
+	if fileContext.MaxMode != 0 {
+		if bits := fileContext.DefaultDirMode &^ fileContext.MaxMode; bits != 0 {
+			return nil, errors.Errorf("--default-dir-mode=%o grants bits %o not allowed by --max-copy-mode=%o", fileContext.DefaultDirMode, bits, fileContext.MaxMode)
+		}
+		if bits := fileContext.DefaultFileMode &^ fileContext.MaxMode; bits != 0 {
+			return nil, errors.Errorf("--default-file-mode=%o grants bits %o not allowed by --max-copy-mode=%o", fileContext.DefaultFileMode, bits, fileContext.MaxMode)
+		}
+	}
+	if fileContext.RunUmask, err = parseRunUmask(opts.RunUmask); err != nil {
+		return nil, errors.Wrap(err, "parsing --run-umask")
+	}
+	fileContext.HermeticRun = opts.HermeticRun
+	if opts.CacheDecompressedArchives && opts.CacheDir != "" {
+		fileContext.ArchiveCache = &util.ArchiveCache{Dir: filepath.Join(opts.CacheDir, "archives"), TTL: opts.CacheTTL}
+	}
+	fileContext.NamedContexts = namedContextDirs(opts.BuildContexts)
+	fileContext.SkipUnchanged = opts.SkipUnchangedCopies
+	fileContext.BestEffort = opts.CopyBestEffort
+	fileContext.StrictSources = opts.StrictContext
+	fileContext.PreserveOwnership = opts.PreserveSourceOwnership
+	fileContext.CheckDiskSpace = opts.CheckDiskSpace
+	fileContext.CopyAsRoot = opts.CopyAsRoot || config.EnvBool("FF_KANIKO_COPY_AS_ROOT")
+	fileContext.CaseCollisions = opts.CaseCollisions
+	fileContext.DuplicateDestinations = opts.DuplicateDestinations
+	fileContext.PreserveInodeFlags = opts.PreserveInodeFlags
+	fileContext.FollowDirSymlinks = opts.FollowContextSymlinks
+	fileContext.PreserveAtime = opts.PreserveAccessTimes
+	fileContext.MaxSymlinkDepth = opts.MaxSymlinkDepth
+	fileContext.Fsync = opts.FsyncCopies
+	if fileContext.Transforms, err = util.ParseCopyTransforms(opts.CopyTransforms); err != nil {
+		return nil, util.UserError(err)
+	}
+	if fileContext.OwnerRules, err = util.ParseOwnerRules(opts.CopyOwners); err != nil {
+		return nil, util.UserError(err)
+	}
+	if opts.GitCommitMtimes {
+		if fileContext.CommitTimes, err = buildcontext.CommitTimes(fileContext.Root); err != nil {
+			return nil, errors.Wrap(err, "getting commit times of the build context")
+		}
+	}
+	commandArgs, err := commandBuildArgs(stages, opts.CommandBuildArgs)
+	if err != nil {
+		return nil, err
+	}
+	if generatesProvenance(opts) {
+		if err := provenanceMaterials.addContext(fileContext); err != nil {
+			return nil, err
+		}
+	}
 
 	// Some stages may refer to other random images, not previous stages
 	if err := fetchExtraStages(kanikoStages, opts); err != nil {
@@ -773,6 +1481,27 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 		return nil, err
 	}
 
+	if opts.ContextDigestFile != "" {
+		if err := loadContextRecord(opts.ContextDigestFile, fileContext); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				return
+			}
+			if err := saveContextRecord(opts.ContextDigestFile); err != nil {
+				logrus.Warnf("Failed to write context digest file %s: %v", opts.ContextDigestFile, err)
+			}
+		}()
+	}
+
+	var guard *contextGuard
+	if opts.ReadOnlyContext {
+		if guard, err = newContextGuard(opts.SrcContext); err != nil {
+			return nil, err
+		}
+	}
+
 	var tarball string
 	err = util.InitIgnoreList()
 	if err != nil {
@@ -800,14 +1529,33 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 		}
 	}
 
-	for _, stage := range kanikoStages {
+	diffBases := map[int]diffBase{}
+	var prefetcher *basePrefetcher
+	if opts.PrefetchBaseImages {
+		prefetcher = newBasePrefetcher(opts)
+		// the prefetched layers are kept in prefetchDir, a successful build waited for them
+		defer prefetcher.cancel()
+	}
+	var resume *resumeState
+	if opts.ResumeStatePath != "" {
+		resume = loadResumeState(opts.ResumeStatePath)
+	}
+	for i, stage := range kanikoStages {
+		if prefetcher != nil {
+			prefetcher.wait(stage)
+			if i+1 < len(kanikoStages) {
+				prefetcher.prefetch(kanikoStages[i+1])
+			}
+		}
+		stageStart := time.Now()
 		sb, err := newStageBuilder(
 			args, opts, stage,
 			crossStageDependencies,
 			digestToCacheKey,
 			stageIdxToDigest,
 			stageNameToIdx,
-			fileContext)
+			fileContext,
+			commandArgs)
 
 		logrus.Infof("Building stage '%v' [idx: '%v', base-idx: '%v']",
 			stage.BaseName, stage.Index, stage.BaseImageIndex)
@@ -815,12 +1563,65 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 		if err != nil {
 			return nil, err
 		}
+		if generatesProvenance(opts) && !stage.BaseImageStoredLocally && stage.BaseName != constants.NoBaseImage {
+			if err := provenanceMaterials.addImage(stage.BaseName, sb.baseImage); err != nil {
+				return nil, err
+			}
+		}
 		args = sb.args
-		if err := sb.build(); err != nil {
+		if opts.PushDiffOnly {
+			if stage.BaseImageStoredLocally {
+				diffBases[stage.Index] = diffBases[stage.BaseImageIndex]
+			} else if diffBases[stage.Index], err = newDiffBase(stage, sb, opts); err != nil {
+				return nil, err
+			}
+		}
+		var inputKey string
+		if resume != nil && !stage.Final {
+			key, stageArgs, err := sb.inputKey(crossStageDependencies[stage.Index])
+			if err != nil {
+				return nil, errors.Wrapf(err, "computing the inputs of stage %d", stage.Index)
+			}
+			if done, ok := resume.completed(stage, key); ok {
+				logrus.Infof("Skipping stage %d, it completed with the same inputs before", stage.Index)
+				stageIdxToDigest[strconv.Itoa(stage.Index)] = done.Digest
+				digestToCacheKey[done.Digest] = done.CacheKey
+				args = stageArgs
+				continue
+			}
+			inputKey = key
+		}
+		err = sb.build()
+		// the inode flags of copied files only apply to the commands of the stage
+		if clearErr := util.ClearInodeFlags(); err == nil {
+			err = clearErr
+		}
+		if err != nil {
+			if opts.KeepRootOnExit {
+				logrus.Infof("Keeping filesystem of failed stage '%v' at %s", stage.BaseName, config.RootDir)
+			}
 			return nil, errors.Wrap(err, "error building stage")
 		}
+		if guard != nil {
+			if err := guard.check(); err != nil {
+				return nil, errors.Wrapf(err, "building stage '%v'", stage.BaseName)
+			}
+		}
+		if opts.BuildReportPath != "" {
+			if err := stageReports.addStage(sb, stageStart); err != nil {
+				return nil, err
+			}
+		}
 
 		reviewConfig(stage, &sb.cf.Config)
+		if stage.Final && opts.ConfigMutator != nil {
+			if err := opts.ConfigMutator(&sb.cf.Config); err != nil {
+				return nil, errors.Wrap(err, "mutating image config")
+			}
+		}
+		if stage.Final && opts.NormalizeEnv != "" {
+			sb.cf.Config.Env = normalizeEnv(sb.cf.Config.Env, opts.NormalizeEnv == config.EnvSort)
+		}
 
 		sourceImage, err := mutate.Config(sb.image, sb.cf.Config)
 		if err != nil {
@@ -838,6 +1639,9 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 			configFile.OS = strings.Split(opts.CustomPlatform, "/")[0]
 			configFile.Architecture = strings.Split(opts.CustomPlatform, "/")[1]
 		}
+		if stage.Final {
+			overrideImagePlatform(configFile, opts)
+		}
 		sourceImage, err = mutate.ConfigFile(sourceImage, configFile)
 		if err != nil {
 			return nil, err
@@ -864,10 +1668,76 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 					return nil, err
 				}
 			}
+			if opts.DeduplicateAdjacentLayers {
+				sourceImage, err = deduplicateAdjacentLayers(sourceImage)
+				if err != nil {
+					return nil, errors.Wrap(err, "deduplicating layers")
+				}
+			}
+			if opts.MaxLayers > 0 {
+				baseLayers, err := sb.baseImage.Layers()
+				if err != nil {
+					return nil, err
+				}
+				sourceImage, err = limitLayers(sourceImage, opts.MaxLayers, len(baseLayers), sb.saveSnapshotToLayer)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if opts.MaxImageSize != "" {
+				if err := checkImageSize(sourceImage, opts.MaxImageSize); err != nil {
+					return nil, err
+				}
+			}
+			if opts.WarnSmallLayers > 0 {
+				baseLayers, err := sb.baseImage.Layers()
+				if err != nil {
+					return nil, err
+				}
+				if err := checkSmallLayers(sourceImage, len(baseLayers), opts.WarnSmallLayers, opts.SmallLayerSize); err != nil {
+					return nil, err
+				}
+			}
+			if opts.DriftCheckImage != "" {
+				if err := checkDrift(sourceImage, opts); err != nil {
+					return nil, err
+				}
+			}
+			if opts.Cache && opts.CacheInline {
+				sourceImage, err = addInlineCache(sourceImage, cache.InlineCacheAnnotation, sb.inlineCacheKeys)
+				if err != nil {
+					return nil, errors.Wrap(err, "adding inline cache")
+				}
+			}
+			if opts.Cache && opts.CacheLayerKeys {
+				sourceImage, err = addInlineCache(sourceImage, cache.LayerCacheAnnotation, sb.layerCacheKeys)
+				if err != nil {
+					return nil, errors.Wrap(err, "adding layer cache keys")
+				}
+			}
 			if len(opts.Annotations) > 0 {
 				sourceImage = mutate.Annotations(sourceImage, opts.Annotations).(v1.Image)
 			}
-			if opts.Cleanup {
+			if opts.PushDiffOnly {
+				sourceImage, err = diffOnlyImage(sourceImage, diffBases[stage.Index])
+				if err != nil {
+					return nil, errors.Wrap(err, "creating diff only image")
+				}
+			}
+			if err := verifyFinalRootFS(opts); err != nil {
+				return nil, err
+			}
+			if opts.SBOMPath != "" {
+				if err := sbom.WriteFile(opts.SBOMPath, config.RootDir); err != nil {
+					return nil, errors.Wrap(err, "writing sbom")
+				}
+				if err := syncExport(opts, opts.SBOMPath); err != nil {
+					return nil, err
+				}
+			}
+			if opts.KeepRootOnExit {
+				logrus.Infof("Keeping filesystem of the build at %s", config.RootDir)
+			} else if opts.Cleanup {
 				if err = util.DeleteFilesystem(); err != nil {
 					return nil, err
 				}
@@ -882,6 +1752,12 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 					logrus.Info("Context restored")
 				}
 			}
+			if resume != nil {
+				// there is nothing to resume once the build completed
+				if err := os.Remove(opts.ResumeStatePath); err != nil && !os.IsNotExist(err) {
+					logrus.Warnf("Failed to remove resume state %s: %v", opts.ResumeStatePath, err)
+				}
+			}
 			timing.DefaultRun.Stop(t)
 			return sourceImage, nil
 		}
@@ -909,6 +1785,11 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 				return nil, errors.Wrap(err, "could not save file")
 			}
 		}
+		if resume != nil {
+			if err := resume.record(stage, inputKey, d.String(), sb.finalCacheKey, opts.ResumeStatePath); err != nil {
+				return nil, errors.Wrapf(err, "recording stage %d in %s", stage.Index, opts.ResumeStatePath)
+			}
+		}
 
 		// Delete the filesystem
 		if err := util.DeleteFilesystem(); err != nil {
@@ -929,27 +1810,66 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 	return nil, err
 }
 
+// commandBuildArgs maps the values of --command-build-arg, INDEX:NAME=VALUE, to the RUN
+// command they apply to. INDEX counts the instructions of all stages after their FROM from 0.
+func commandBuildArgs(stages []instructions.Stage, values []string) (map[instructions.Command][]string, error) {
+	cmds := []instructions.Command{}
+	for _, stage := range stages {
+		cmds = append(cmds, stage.Commands...)
+	}
+	result := map[instructions.Command][]string{}
+	for _, value := range values {
+		index, arg, ok := strings.Cut(value, ":")
+		i, err := strconv.Atoi(index)
+		if !ok || err != nil || !strings.Contains(arg, "=") {
+			return nil, fmt.Errorf("--command-build-arg %q is not of the form INDEX:NAME=VALUE", value)
+		}
+		if i < 0 || i >= len(cmds) {
+			return nil, fmt.Errorf("--command-build-arg %q: there is no instruction %d, the Dockerfile has %d", value, i, len(cmds))
+		}
+		if _, ok := cmds[i].(*instructions.RunCommand); !ok {
+			return nil, fmt.Errorf("--command-build-arg %q: instruction %d is %s, not RUN", value, i, strings.ToUpper(cmds[i].Name()))
+		}
+		result[cmds[i]] = append(result[cmds[i]], arg)
+	}
+	return result, nil
+}
+
 // filesToSave returns all the files matching the given pattern in deps.
-// If a file is a symlink, it also returns the target file.
+// If a file is a symlink, it also returns the target file. Symlinks are resolved
+// inside config.RootDir and the links followed on the way are returned as well.
 func filesToSave(deps []string) ([]string, error) {
 	srcFiles := []string{}
 	for _, src := range deps {
-		srcs, err := filepath.Glob(filepath.Join(config.RootDir, src))
+		src = filepath.Clean(src)
+		dir, links, err := util.ResolvePathInRoot(config.RootDir, filepath.Dir(src))
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving %s", src)
+		}
+		srcFiles = append(srcFiles, links...)
+		srcs, err := filepath.Glob(filepath.Join(dir, filepath.Base(src)))
 		if err != nil {
 			return nil, err
 		}
 		for _, f := range srcs {
-			if link, err := util.EvalSymLink(f); err == nil {
-				link, err = filepath.Rel(config.RootDir, link)
-				if err != nil {
-					return nil, errors.Wrap(err, fmt.Sprintf("could not find relative path to %s", config.RootDir))
-				}
-				srcFiles = append(srcFiles, link)
-			}
 			f, err = filepath.Rel(config.RootDir, f)
 			if err != nil {
 				return nil, errors.Wrap(err, fmt.Sprintf("could not find relative path to %s", config.RootDir))
 			}
+			if _, err := util.GetSymLink(filepath.Join(config.RootDir, f)); err == nil {
+				target, links, err := util.ResolvePathInRoot(config.RootDir, f)
+				if err != nil {
+					return nil, errors.Wrapf(err, "resolving symlink %s", f)
+				}
+				srcFiles = append(srcFiles, links...)
+				if util.FilepathExists(target) {
+					target, err = filepath.Rel(config.RootDir, target)
+					if err != nil {
+						return nil, errors.Wrap(err, fmt.Sprintf("could not find relative path to %s", config.RootDir))
+					}
+					srcFiles = append(srcFiles, target)
+				}
+			}
 			srcFiles = append(srcFiles, f)
 		}
 	}
@@ -1001,42 +1921,134 @@ func deduplicatePaths(paths []string) []string {
 	return deduped
 }
 
+// namedContextDirs returns the named build contexts that refer to a directory.
+func namedContextDirs(buildContexts map[string]string) map[string]string {
+	dirs := map[string]string{}
+	for name, value := range buildContexts {
+		if _, isImage := config.BuildContextImage(value); !isImage {
+			dirs[name] = value
+		}
+	}
+	return dirs
+}
+
+// parseDefaultMode parses an octal file mode, an empty string means no default mode.
+func parseDefaultMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(m), nil
+}
+
+// parseRunUmask parses the octal umask of --run-umask, nil if it isn't set.
+func parseRunUmask(umask string) (*int, error) {
+	if umask == "" {
+		return nil, nil
+	}
+	m, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil {
+		return nil, err
+	}
+	if m > 0o777 {
+		return nil, errors.Errorf("umask %s is not between 0 and 0777", umask)
+	}
+	mask := int(m)
+	return &mask, nil
+}
+
 func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) error {
 	t := timing.Start("Fetching Extra Stages")
 	defer timing.DefaultRun.Stop(t)
 
+	// the images are extracted concurrently with the ignore list initialized once
+	if err := util.ResetIgnoreList(); err != nil {
+		return err
+	}
+
 	var names []string
+	fetched := map[string]bool{}
+	extractGroup := errgroup.Group{}
+	if opts.StageExtractConcurrency > 0 {
+		extractGroup.SetLimit(opts.StageExtractConcurrency)
+	}
 
 	for _, s := range stages {
 		for _, cmd := range s.Commands {
-			c, ok := cmd.(*instructions.CopyCommand)
-			if !ok || c.From == "" {
-				continue
+			var froms []string
+			switch c := cmd.(type) {
+			case *instructions.CopyCommand:
+				if c.From != "" {
+					froms = append(froms, c.From)
+				}
+			case *instructions.RunCommand:
+				for _, m := range dockerfile.BindMounts(c) {
+					froms = append(froms, m.From)
+				}
 			}
+			for _, from := range froms {
+				// FROMs at this point are guaranteed to be either an integer referring to a previous stage,
+				// the name of a previous stage, or a name of a remote image.
 
-			// FROMs at this point are guaranteed to be either an integer referring to a previous stage,
-			// the name of a previous stage, or a name of a remote image.
-
-			// If it is an integer stage index, validate that it is actually a previous index
-			if fromIndex, err := strconv.Atoi(c.From); err == nil && s.Index > fromIndex && fromIndex >= 0 {
-				continue
-			}
-			// Check if the name is the alias of a previous stage
-			if fromPreviousStage(c, names) {
-				continue
-			}
+				// If it is an integer stage index, validate that it is actually a previous index
+				if fromIndex, err := strconv.Atoi(from); err == nil && s.Index > fromIndex && fromIndex >= 0 {
+					continue
+				}
+				// Check if the name is the alias of a previous stage
+				if fromPreviousStage(from, names) {
+					continue
+				}
+				// Each image is extracted once, no matter how many instructions refer to it
+				if fetched[from] {
+					continue
+				}
+				fetched[from] = true
+
+				image, layerSelector := splitLayerSelector(from)
+				if value, ok := opts.BuildContexts[image]; ok {
+					ref, isImage := config.BuildContextImage(value)
+					if !isImage {
+						if layerSelector != "" {
+							_ = extractGroup.Wait()
+							return errors.Errorf("build context %s is a directory, it has no layer %s", image, layerSelector)
+						}
+						// directories are copied from in place
+						continue
+					}
+					logrus.Debugf("Found build context %s referring to image %s", from, ref)
+					image = ref
+				}
 
-			// This must be an image name, fetch it.
-			logrus.Debugf("Found extra base image stage %s", c.From)
-			sourceImage, err := remote.RetrieveRemoteImage(c.From, opts.RegistryOptions, opts.CustomPlatform)
-			if err != nil {
-				return err
-			}
-			if err := saveStageAsTarball(c.From, sourceImage); err != nil {
-				return err
-			}
-			if err := extractImageToDependencyDir(c.From, sourceImage); err != nil {
-				return err
+				// This must be an image name, fetch it.
+				logrus.Debugf("Found extra base image stage %s", from)
+				sourceImage, err := remote.RetrieveRemoteImage(image, opts.RegistryOptions, opts.CustomPlatform)
+				if err != nil {
+					_ = extractGroup.Wait()
+					return err
+				}
+				if generatesProvenance(opts) {
+					if err := provenanceMaterials.addImage(image, sourceImage); err != nil {
+						_ = extractGroup.Wait()
+						return err
+					}
+				}
+				if layerSelector != "" {
+					if sourceImage, err = selectLayer(sourceImage, layerSelector); err != nil {
+						_ = extractGroup.Wait()
+						return errors.Wrapf(err, "selecting layer of %s", image)
+					}
+				}
+				// The layers are only downloaded when the image is saved and extracted,
+				// which happens for distinct images in parallel.
+				extractGroup.Go(func() error {
+					if err := saveStageAsTarball(from, sourceImage); err != nil {
+						return err
+					}
+					return extractImageToDependencyDir(from, sourceImage)
+				})
 			}
 		}
 		// Store the name of the current stage in the list with names, if applicable.
@@ -1044,12 +2056,12 @@ func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) e
 			names = append(names, s.Name)
 		}
 	}
-	return nil
+	return extractGroup.Wait()
 }
 
-func fromPreviousStage(copyCommand *instructions.CopyCommand, previousStageNames []string) bool {
+func fromPreviousStage(from string, previousStageNames []string) bool {
 	for _, previousStageName := range previousStageNames {
-		if previousStageName == copyCommand.From {
+		if previousStageName == from {
 			return true
 		}
 	}
@@ -1064,7 +2076,7 @@ func extractImageToDependencyDir(name string, image v1.Image) error {
 		return err
 	}
 	logrus.Debugf("Trying to extract to %s", dependencyDir)
-	_, err := util.GetFSFromImage(dependencyDir, image, util.ExtractFile)
+	_, err := getFSFromImage(dependencyDir, image, util.ExtractFile, util.KeepIgnoreList())
 	return err
 }
 
@@ -1142,6 +2154,31 @@ func reviewConfig(stage config.KanikoStage, config *v1.Config) {
 	}
 }
 
+// normalizeEnv returns env with a single entry for each variable, the value of its
+// last entry at the position of its first, see --normalize-env. If sorted is set the
+// entries are sorted by name. Entries without "=" are named by the whole entry.
+func normalizeEnv(env []string, sorted bool) []string {
+	names := make([]string, 0, len(env))
+	values := map[string]string{}
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		} else {
+			logrus.Debugf("Dropping the earlier value of %s from the env, %s wins", name, e)
+		}
+		values[name] = e
+	}
+	if sorted {
+		sort.Strings(names)
+	}
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		normalized = append(normalized, values[name])
+	}
+	return normalized
+}
+
 // iterates over a list of KanikoStage and resolves instructions referring to earlier stages
 // returns a mapping of stage name to stage id, f.e - ["first": "0", "second": "1", "target": "2"]
 func ResolveCrossStageInstructions(stages []config.KanikoStage) map[string]string {
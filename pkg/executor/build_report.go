@@ -0,0 +1,98 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stageReport is the entry of a built stage in the build report, see --build-report.
+type stageReport struct {
+	Index           int     `json:"index"`
+	Name            string  `json:"name,omitempty"`
+	BaseImage       string  `json:"baseImage"`
+	BaseImageDigest string  `json:"baseImageDigest"`
+	Commands        int     `json:"commands"`
+	Layers          int     `json:"layers"`
+	CacheHits       int     `json:"cacheHits"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// buildReport collects the stages of the build in the order they were built.
+type buildReport struct {
+	mu     sync.Mutex
+	stages []stageReport
+}
+
+// stageReports is the report of the current build.
+var stageReports = &buildReport{}
+
+func (r *buildReport) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages = nil
+}
+
+// addStage records the stage sb built, which took the time since start.
+func (r *buildReport) addStage(sb *stageBuilder, start time.Time) error {
+	layers, err := sb.image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "getting layers of stage")
+	}
+	baseLayers, err := sb.baseImage.Layers()
+	if err != nil {
+		return errors.Wrap(err, "getting layers of base image")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages = append(r.stages, stageReport{
+		Index:           sb.stage.Index,
+		Name:            sb.stage.Name,
+		BaseImage:       sb.stage.BaseName,
+		BaseImageDigest: sb.baseImageDigest,
+		Commands:        len(sb.cmds),
+		Layers:          len(layers) - len(baseLayers),
+		CacheHits:       sb.cacheHits,
+		DurationSeconds: time.Since(start).Seconds(),
+	})
+	return nil
+}
+
+// writeFile writes the report to path as JSON.
+func (r *buildReport) writeFile(path string) error {
+	r.mu.Lock()
+	stages := r.stages
+	r.mu.Unlock()
+	if stages == nil {
+		stages = []stageReport{}
+	}
+	b, err := json.MarshalIndent(struct {
+		Stages []stageReport `json:"stages"`
+	}{stages}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing build report")
+	}
+	return nil
+}
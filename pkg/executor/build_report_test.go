@@ -0,0 +1,77 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_BuildReport(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch AS first
+COPY foo/bam.txt copied/
+COPY exec copied/
+ENV FOO=bar
+
+FROM scratch
+COPY --from=first copied/exec /exec`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	opts := &config.KanikoOptions{
+		DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:      filepath.Join(testDir, "workspace"),
+		SnapshotMode:    constants.SnapshotModeFull,
+		BuildReportPath: reportPath,
+	}
+	_, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	b, err := os.ReadFile(reportPath)
+	testutil.CheckNoError(t, err)
+	var report struct {
+		Stages []map[string]any `json:"stages"`
+	}
+	testutil.CheckNoError(t, json.Unmarshal(b, &report))
+	testutil.CheckDeepEqual(t, 2, len(report.Stages))
+
+	for i, want := range []map[string]any{
+		{"index": 0.0, "name": "first", "baseImage": "scratch", "commands": 3.0, "layers": 2.0, "cacheHits": 0.0},
+		{"index": 1.0, "baseImage": "scratch", "commands": 1.0, "layers": 1.0, "cacheHits": 0.0},
+	} {
+		stage := report.Stages[i]
+		for key, value := range want {
+			testutil.CheckDeepEqual(t, value, stage[key])
+		}
+		if digest, _ := stage["baseImageDigest"].(string); digest == "" {
+			t.Errorf("expected stage %d to have the digest of its base image, got %v", i, stage["baseImageDigest"])
+		}
+		if d, ok := stage["durationSeconds"].(float64); !ok || d <= 0 {
+			t.Errorf("expected stage %d to have a duration, got %v", i, stage["durationSeconds"])
+		}
+	}
+}
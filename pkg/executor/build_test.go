@@ -19,28 +19,48 @@ package executor
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/containerd/platforms"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/osscontainertools/kaniko/pkg/cache"
 	"github.com/osscontainertools/kaniko/pkg/commands"
 	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/dockerfile"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 )
 
 func Test_reviewConfig(t *testing.T) {
@@ -405,6 +425,7 @@ func Test_filesToSave(t *testing.T) {
 		args  []string
 		want  []string
 		files []string
+		links map[string]string
 	}{
 		{
 			name:  "simple",
@@ -430,6 +451,20 @@ func Test_filesToSave(t *testing.T) {
 			files: []string{"foo/bar", "foo/baz", "foo/bat/baz"},
 			want:  []string{"foo"},
 		},
+		{
+			name:  "absolute symlink",
+			args:  []string{"link"},
+			files: []string{"usr/lib/file"},
+			links: map[string]string{"link": "/usr/lib/file"},
+			want:  []string{"link", "usr/lib/file"},
+		},
+		{
+			name:  "absolute symlink in parent dir",
+			args:  []string{"lib/file"},
+			files: []string{"usr/lib/file"},
+			links: map[string]string{"lib": "/usr/lib"},
+			want:  []string{"lib", "usr/lib/file"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -454,6 +489,11 @@ func Test_filesToSave(t *testing.T) {
 				}
 				fp.Close()
 			}
+			for link, target := range tt.links {
+				if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+					t.Errorf("error making link: %s", err)
+				}
+			}
 
 			got, err := filesToSave(tt.args)
 			if err != nil {
@@ -560,7 +600,8 @@ func TestInitializeConfig(t *testing.T) {
 
 func Test_newLayerCache_defaultCache(t *testing.T) {
 	t.Run("default layer cache is registry cache", func(t *testing.T) {
-		layerCache := newLayerCache(&config.KanikoOptions{CacheRepo: "some-cache-repo"})
+		layerCache, err := newLayerCache(&config.KanikoOptions{CacheRepo: "some-cache-repo"})
+		testutil.CheckNoError(t, err)
 		foundCache, ok := layerCache.(*cache.RegistryCache)
 		if !ok {
 			t.Error("expected layer cache to be a registry cache")
@@ -575,7 +616,8 @@ func Test_newLayerCache_defaultCache(t *testing.T) {
 
 func Test_newLayerCache_layoutCache(t *testing.T) {
 	t.Run("when cache repo has 'oci:' prefix layer cache is layout cache", func(t *testing.T) {
-		layerCache := newLayerCache(&config.KanikoOptions{CacheRepo: "oci:/some-cache-repo"})
+		layerCache, err := newLayerCache(&config.KanikoOptions{CacheRepo: "oci:/some-cache-repo"})
+		testutil.CheckNoError(t, err)
 		foundCache, ok := layerCache.(*cache.LayoutCache)
 		if !ok {
 			t.Error("expected layer cache to be a layout cache")
@@ -908,7 +950,246 @@ func Test_stageBuilder_populateCompositeKey(t *testing.T) {
 	}
 }
 
+func Test_stageBuilder_cacheKey(t *testing.T) {
+	run := func(t *testing.T) commands.DockerCommand {
+		instructions, err := dockerfile.ParseCommands([]string{"RUN apt-get install -y curl"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		command, err := commands.GetCommand(instructions[0], util.FileContext{Root: "workspace"}, false, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return command
+	}
+	key := func(t *testing.T, portable bool, digest string, preceding string) string {
+		sb := &stageBuilder{
+			opts:            &config.KanikoOptions{CacheRunLayersPortable: portable},
+			baseImageDigest: digest,
+			fileContext:     util.FileContext{Root: "workspace"},
+		}
+		ck := NewCompositeCache(digest)
+		ck.AddKey(preceding)
+		command := run(t)
+		args := dockerfile.NewBuildArgs([]string{})
+		populated, err := sb.populateCompositeKey(command, []string{}, *ck, args, []string{"PATH=/usr/bin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := sb.cacheKey(command, populated, args, []string{"PATH=/usr/bin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	t.Run("default key depends on preceding commands", func(t *testing.T) {
+		if key(t, false, "sha256:base", "COPY a /a") == key(t, false, "sha256:base", "COPY b /b") {
+			t.Error("expected keys to differ")
+		}
+	})
+	t.Run("portable key ignores preceding commands", func(t *testing.T) {
+		testutil.CheckDeepEqual(t, key(t, true, "sha256:base", "COPY a /a"), key(t, true, "sha256:base", "COPY b /b"))
+	})
+	t.Run("portable key depends on base image", func(t *testing.T) {
+		if key(t, true, "sha256:base", "COPY a /a") == key(t, true, "sha256:other", "COPY a /a") {
+			t.Error("expected keys to differ")
+		}
+	})
+}
+
+func Test_stageBuilder_cacheKeyPlatform(t *testing.T) {
+	instructions, err := dockerfile.ParseCommands([]string{"RUN make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := commands.GetCommand(instructions[0], util.FileContext{Root: "workspace"}, false, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// key builds command on a base image with the same digest for platform, like scratch
+	key := func(t *testing.T, opts *config.KanikoOptions, platform string) string {
+		cf := &v1.ConfigFile{}
+		if platform != "" {
+			p, err := v1.ParsePlatform(platform)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cf.OS, cf.Architecture, cf.Variant = p.OS, p.Architecture, p.Variant
+		}
+		sb := &stageBuilder{
+			opts:            opts,
+			cf:              cf,
+			baseImageDigest: "sha256:base",
+			fileContext:     util.FileContext{Root: "workspace"},
+		}
+		args := dockerfile.NewBuildArgs([]string{})
+		populated, err := sb.populateCompositeKey(command, nil, *sb.initialCompositeKey(), args, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := sb.cacheKey(command, populated, args, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	for _, opts := range []*config.KanikoOptions{{}, {CacheRunLayersPortable: true}} {
+		t.Run(fmt.Sprintf("portable %v", opts.CacheRunLayersPortable), func(t *testing.T) {
+			seen := map[string]string{}
+			for _, platform := range []string{"linux/amd64", "linux/arm64", "linux/arm/v7", "linux/arm/v6"} {
+				k := key(t, opts, platform)
+				if other, ok := seen[k]; ok {
+					t.Errorf("expected the keys for %s and %s to differ", other, platform)
+				}
+				seen[k] = platform
+			}
+			// base images without a platform are built for --custom-platform
+			testutil.CheckDeepEqual(t, key(t, opts, "linux/arm64"), key(t, &config.KanikoOptions{CustomPlatform: "linux/arm64", CacheRunLayersPortable: opts.CacheRunLayersPortable}, ""))
+
+			ignoring := &config.KanikoOptions{CacheIgnorePlatform: true, CacheRunLayersPortable: opts.CacheRunLayersPortable}
+			testutil.CheckDeepEqual(t, key(t, ignoring, "linux/amd64"), key(t, ignoring, "linux/arm64"))
+		})
+	}
+}
+
+func Test_stageBuilder_cacheKeyBaseExcludePaths(t *testing.T) {
+	instructions, err := dockerfile.ParseCommands([]string{"RUN make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := commands.GetCommand(instructions[0], util.FileContext{Root: "workspace"}, false, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(t *testing.T, portable bool, excludes ...string) string {
+		sb := &stageBuilder{
+			opts:            &config.KanikoOptions{BaseExcludePaths: excludes, CacheRunLayersPortable: portable},
+			cf:              &v1.ConfigFile{},
+			baseImageDigest: "sha256:base",
+			fileContext:     util.FileContext{Root: "workspace"},
+		}
+		args := dockerfile.NewBuildArgs([]string{})
+		populated, err := sb.populateCompositeKey(command, nil, *sb.initialCompositeKey(), args, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := sb.cacheKey(command, populated, args, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	for _, portable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("portable %v", portable), func(t *testing.T) {
+			// the commands run on a filesystem without the excluded paths
+			if key(t, portable) == key(t, portable, "/usr/share/doc") {
+				t.Error("expected the keys with and without excluded paths to differ")
+			}
+			if key(t, portable, "/usr/share/doc") == key(t, portable, "/var/cache") {
+				t.Error("expected the keys with other excluded paths to differ")
+			}
+			testutil.CheckDeepEqual(t, key(t, portable, "/var/cache", "/usr/share/doc"), key(t, portable, "/usr/share/doc", "/var/cache"))
+		})
+	}
+}
+
+func Test_stageBuilder_layerCacheKeyOwner(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key := func(t *testing.T, command string, fc util.FileContext, user string, fsUnpacked bool) string {
+		instructions, err := dockerfile.ParseCommands([]string{command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := commands.GetCommand(instructions[0], fc, false, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := &stageBuilder{opts: &config.KanikoOptions{}, fileContext: fc, fsUnpacked: fsUnpacked}
+		k, err := sb.layerCacheKey(cmd, []string{filepath.Join(dir, "foo.txt")}, dockerfile.NewBuildArgs([]string{}), &v1.Config{User: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	fc := util.FileContext{Root: dir}
+
+	for _, instruction := range []string{"COPY", "ADD"} {
+		t.Run(instruction, func(t *testing.T) {
+			command := instruction + " foo.txt bar.txt"
+			chown := instruction + " --chown=3000 foo.txt bar.txt"
+			if key(t, command, fc, "1000", false) == key(t, command, fc, "2000", false) {
+				t.Error("expected the keys for USER 1000 and USER 2000 to differ")
+			}
+			testutil.CheckDeepEqual(t, key(t, command, fc, "1000", false), key(t, command, fc, "1000", true))
+			// --chown overrides the user
+			testutil.CheckDeepEqual(t, key(t, chown, fc, "1000", false), key(t, chown, fc, "2000", false))
+			// names can't be looked up before the filesystem of the stage is unpacked
+			testutil.CheckDeepEqual(t, "", key(t, command, fc, "www", false))
+		})
+	}
+
+	t.Run("copy as root", func(t *testing.T) {
+		asRoot := util.FileContext{Root: dir, CopyAsRoot: true}
+		testutil.CheckDeepEqual(t, key(t, "COPY foo.txt bar.txt", asRoot, "1000", false), key(t, "COPY foo.txt bar.txt", asRoot, "2000", false))
+		testutil.CheckDeepEqual(t, key(t, "COPY foo.txt bar.txt", asRoot, "www", false), key(t, "COPY foo.txt bar.txt", asRoot, "0", false))
+	})
+}
+
+func Test_stageBuilder_layerCacheKeyDestination(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"foo.txt", "bar.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fc := util.FileContext{Root: dir}
+	key := func(t *testing.T, command string, env ...string) string {
+		instructions, err := dockerfile.ParseCommands([]string{command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := commands.GetCommand(instructions[0], fc, false, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := &stageBuilder{opts: &config.KanikoOptions{}, fileContext: fc}
+		args := dockerfile.NewBuildArgs([]string{})
+		cfg := &v1.Config{User: "1000", Env: env}
+		files, err := filesUsedFromContext(cmd, cfg, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := sb.layerCacheKey(cmd, files, args, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	for _, instruction := range []string{"COPY", "ADD"} {
+		t.Run(instruction, func(t *testing.T) {
+			command := instruction + " foo.txt $DEST"
+			// foo.txt is copied to /app or into /app/foo.txt
+			if key(t, command, "DEST=/app") == key(t, command, "DEST=/app/") {
+				t.Error("expected the keys for a file and a directory destination to differ")
+			}
+			if key(t, command, "DEST=/app") == key(t, command, "DEST=/srv") {
+				t.Error("expected the keys for other destinations to differ")
+			}
+			testutil.CheckDeepEqual(t, key(t, command, "DEST=/app/"), key(t, command, "DEST=/app/", "OTHER=1"))
+		})
+	}
+}
+
 func Test_stageBuilder_build(t *testing.T) {
+	// the cache keys start from the base image digest and the platform of the build
+	hostPlatform := "|platform=" + runtime.GOOS + "/" + runtime.GOARCH
 	type testcase struct {
 		description        string
 		opts               *config.KanikoOptions
@@ -923,7 +1204,7 @@ func Test_stageBuilder_build(t *testing.T) {
 		config             *v1.ConfigFile
 		stage              config.KanikoStage
 		crossStageDeps     map[int][]string
-		mockGetFSFromImage func(root string, img v1.Image, extract util.ExtractFunction) ([]string, error)
+		mockGetFSFromImage func(root string, img v1.Image, extract util.ExtractFunction, opts ...util.FSOpt) ([]string, error)
 		shouldInitSnapshot bool
 	}
 
@@ -932,7 +1213,7 @@ func Test_stageBuilder_build(t *testing.T) {
 			dir, files := tempDirAndFile(t)
 			file := files[0]
 			filePath := filepath.Join(dir, file)
-			ch := NewCompositeCache("", "meow")
+			ch := NewCompositeCache("", hostPlatform, "meow")
 
 			ch.AddPath(filePath, util.FileContext{})
 			hash, err := ch.Hash()
@@ -962,7 +1243,7 @@ func Test_stageBuilder_build(t *testing.T) {
 			dir, files := tempDirAndFile(t)
 			file := files[0]
 			filePath := filepath.Join(dir, file)
-			ch := NewCompositeCache("", "meow")
+			ch := NewCompositeCache("", hostPlatform, "meow")
 
 			ch.AddPath(filePath, util.FileContext{})
 			hash, err := ch.Hash()
@@ -995,7 +1276,7 @@ func Test_stageBuilder_build(t *testing.T) {
 			dir, files := tempDirAndFile(t)
 			file := files[0]
 			filePath := filepath.Join(dir, file)
-			ch := NewCompositeCache("", "meow")
+			ch := NewCompositeCache("", hostPlatform, "meow")
 
 			ch.AddPath(filePath, util.FileContext{})
 			hash, err := ch.Hash()
@@ -1051,7 +1332,7 @@ func Test_stageBuilder_build(t *testing.T) {
 
 			tarContent := generateTar(t, dir, filename)
 
-			ch := NewCompositeCache("", fmt.Sprintf("COPY %s foo.txt", filename))
+			ch := NewCompositeCache("", hostPlatform, fmt.Sprintf("COPY %s foo.txt", filename))
 			ch.AddPath(filepath, util.FileContext{})
 
 			hash, err := ch.Hash()
@@ -1118,7 +1399,7 @@ func Test_stageBuilder_build(t *testing.T) {
 			tarContent := []byte{}
 			destDir := t.TempDir()
 			filePath := filepath.Join(dir, filename)
-			ch := NewCompositeCache("", fmt.Sprintf("COPY %s foo.txt", filename))
+			ch := NewCompositeCache("", hostPlatform, fmt.Sprintf("COPY %s foo.txt", filename))
 			ch.AddPath(filePath, util.FileContext{})
 
 			hash, err := ch.Hash()
@@ -1177,7 +1458,7 @@ COPY %s foo.txt
 			destDir := t.TempDir()
 			filePath := filepath.Join(dir, filename)
 
-			ch := NewCompositeCache("", "RUN foobar")
+			ch := NewCompositeCache("", hostPlatform, "RUN foobar")
 
 			hash1, err := ch.Hash()
 			if err != nil {
@@ -1191,7 +1472,7 @@ COPY %s foo.txt
 			if err != nil {
 				t.Errorf("couldn't create hash %v", err)
 			}
-			ch = NewCompositeCache("", fmt.Sprintf("COPY %s foo.txt", filename))
+			ch = NewCompositeCache("", hostPlatform, fmt.Sprintf("COPY %s foo.txt", filename))
 			ch.AddKey(fmt.Sprintf("COPY %s bar.txt", filename))
 			ch.AddPath(filePath, util.FileContext{})
 
@@ -1252,7 +1533,7 @@ COPY %s bar.txt
 
 			filePath := filepath.Join(dir, filename)
 
-			ch := NewCompositeCache("", fmt.Sprintf("COPY %s bar.txt", filename))
+			ch := NewCompositeCache("", hostPlatform, fmt.Sprintf("COPY %s bar.txt", filename))
 			ch.AddPath(filePath, util.FileContext{})
 
 			// copy hash
@@ -1318,7 +1599,7 @@ RUN foobar
 		}(),
 		func() testcase {
 			dir, _ := tempDirAndFile(t)
-			ch := NewCompositeCache("")
+			ch := NewCompositeCache("", hostPlatform)
 			ch.AddKey("|1")
 			ch.AddKey("test=value")
 			ch.AddKey("RUN foobar")
@@ -1356,7 +1637,7 @@ RUN foobar
 		func() testcase {
 			dir, _ := tempDirAndFile(t)
 
-			ch := NewCompositeCache("")
+			ch := NewCompositeCache("", hostPlatform)
 			ch.AddKey("|1")
 			ch.AddKey("arg=value")
 			ch.AddKey("RUN $arg")
@@ -1394,14 +1675,14 @@ RUN foobar
 		func() testcase {
 			dir, _ := tempDirAndFile(t)
 
-			ch1 := NewCompositeCache("")
+			ch1 := NewCompositeCache("", hostPlatform)
 			ch1.AddKey("RUN value")
 			hash1, err := ch1.Hash()
 			if err != nil {
 				t.Errorf("couldn't create hash %v", err)
 			}
 
-			ch2 := NewCompositeCache("")
+			ch2 := NewCompositeCache("", hostPlatform)
 			ch2.AddKey("|1")
 			ch2.AddKey("arg=anotherValue")
 			ch2.AddKey("RUN $arg")
@@ -1441,7 +1722,7 @@ RUN foobar
 			opts:           &config.KanikoOptions{InitialFSUnpacked: true},
 			stage:          config.KanikoStage{Index: 0},
 			crossStageDeps: map[int][]string{0: {"some-dep"}},
-			mockGetFSFromImage: func(root string, img v1.Image, extract util.ExtractFunction) ([]string, error) {
+			mockGetFSFromImage: func(root string, img v1.Image, extract util.ExtractFunction, opts ...util.FSOpt) ([]string, error) {
 				return nil, fmt.Errorf("getFSFromImage shouldn't be called if fs is already unpacked")
 			},
 		},
@@ -1702,6 +1983,38 @@ func Test_stageBuild_populateCompositeKeyForCopyCommand(t *testing.T) {
 	}
 }
 
+func Test_stageBuilder_populateCompositeKeyCommitTimes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key := func(t *testing.T, command string, files []string, commitTimes map[string]time.Time) string {
+		instructions, err := dockerfile.ParseCommands([]string{command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fc := util.FileContext{Root: dir, CommitTimes: commitTimes}
+		cmd, err := commands.GetCommand(instructions[0], fc, false, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := &stageBuilder{opts: &config.KanikoOptions{}, fileContext: fc}
+		ck, err := sb.populateCompositeKey(cmd, files, CompositeCache{}, dockerfile.NewBuildArgs([]string{}), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ck.Key()
+	}
+	files := []string{filepath.Join(dir, "foo.txt")}
+	commitTimes := map[string]time.Time{}
+
+	if key(t, "COPY foo.txt bar.txt", files, nil) == key(t, "COPY foo.txt bar.txt", files, commitTimes) {
+		t.Error("expected the keys with and without commit times to differ")
+	}
+	// commands without files don't copy any mtimes
+	testutil.CheckDeepEqual(t, key(t, "RUN make", nil, nil), key(t, "RUN make", nil, commitTimes))
+}
+
 func Test_ResolveCrossStageInstructions(t *testing.T) {
 	df := `
 	FROM scratch
@@ -1761,7 +2074,7 @@ func Test_stageBuilder_saveSnapshotToLayer(t *testing.T) {
 		crossStageDeps   map[int][]string
 		digestToCacheKey map[string]string
 		stageIdxToDigest map[string]string
-		snapshotter      snapShotter
+		snapshotter      Snapshotter
 		layerCache       cache.LayerCache
 		pushLayerToCache cachePusher
 	}
@@ -1891,7 +2204,7 @@ func Test_stageBuilder_convertLayerMediaType(t *testing.T) {
 		crossStageDeps   map[int][]string
 		digestToCacheKey map[string]string
 		stageIdxToDigest map[string]string
-		snapshotter      snapShotter
+		snapshotter      Snapshotter
 		layerCache       cache.LayerCache
 		pushLayerToCache cachePusher
 	}
@@ -1970,6 +2283,34 @@ func Test_stageBuilder_convertLayerMediaType(t *testing.T) {
 			},
 			expectedMediaType: types.DockerLayer,
 		},
+		{
+			name: "oci image w/ zstd cached layer and gzip compression",
+			fields: fields{
+				image: ociFakeImage{},
+				opts:  &config.KanikoOptions{},
+			},
+			args: args{
+				layer: fakeLayer{
+					mediaType: types.OCILayerZStd,
+				},
+			},
+			expectedMediaType: types.OCILayer,
+		},
+		{
+			name: "oci image w/ zstd cached layer and zstd compression",
+			fields: fields{
+				image: ociFakeImage{},
+				opts: &config.KanikoOptions{
+					Compression: config.ZStd,
+				},
+			},
+			args: args{
+				layer: fakeLayer{
+					mediaType: types.OCILayerZStd,
+				},
+			},
+			expectedMediaType: types.OCILayerZStd,
+		},
 		{
 			name: "docker image w/ uncovertable oci image",
 			fields: fields{
@@ -2017,3 +2358,1088 @@ func Test_stageBuilder_convertLayerMediaType(t *testing.T) {
 		})
 	}
 }
+
+func Test_namedContextDirs(t *testing.T) {
+	dirs := namedContextDirs(map[string]string{
+		"assets": "/workspace/assets",
+		"tools":  config.BuildContextImagePrefix + "alpine:3.20",
+	})
+	testutil.CheckDeepEqual(t, map[string]string{"assets": "/workspace/assets"}, dirs)
+}
+
+func TestDoBuild_ConfigMutator(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch AS first
+ENV SECRET=hunter2
+COPY foo/bam.txt copied/
+
+FROM scratch
+ENV SECRET=hunter2 KEEP=1
+COPY --from=first copied/bam.txt output/bam.txt`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	destination := strings.TrimPrefix(server.URL, "http://") + "/test:latest"
+
+	calls := 0
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		Destinations:   []string{destination},
+		ConfigMutator: func(cfg *v1.Config) error {
+			calls++
+			env := []string{}
+			for _, e := range cfg.Env {
+				if !strings.HasPrefix(e, "SECRET=") {
+					env = append(env, e)
+				}
+			}
+			cfg.Env = env
+			return nil
+		},
+	}
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 1, calls)
+	testutil.CheckNoError(t, DoPush(image, opts))
+
+	ref, err := name.ParseReference(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := remote.Image(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := pushed.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range cfg.Config.Env {
+		if strings.HasPrefix(e, "SECRET=") {
+			t.Errorf("expected SECRET to be stripped from the pushed config, got %v", cfg.Config.Env)
+		}
+	}
+	if !slices.Contains(cfg.Config.Env, "KEEP=1") {
+		t.Errorf("expected KEEP to be kept in the pushed config, got %v", cfg.Config.Env)
+	}
+	expected, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := pushed.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, expected, actual)
+
+	t.Run("error", func(t *testing.T) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte("FROM scratch\nENV A=b"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		opts := &config.KanikoOptions{
+			DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:     filepath.Join(testDir, "workspace"),
+			SnapshotMode:   constants.SnapshotModeFull,
+			ConfigMutator: func(cfg *v1.Config) error {
+				return fmt.Errorf("refusing config")
+			},
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckError(t, true, err)
+	})
+}
+
+func TestDoBuild_NormalizeEnv(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://")
+	// a base image with duplicate env keys, as some tools write them
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err = mutate.Config(base, v1.Config{Env: []string{"ZED=1", "FOO=old", "ZED=2", "BAR=1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseRef, err := name.NewTag(repo + "/base:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(baseRef, base); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		normalization config.EnvNormalization
+		expected      []string
+	}{
+		{name: "unset", expected: []string{"ZED=1", "FOO=new", "ZED=2", "BAR=1"}},
+		{name: "dedupe", normalization: config.EnvDedupe, expected: []string{"ZED=2", "FOO=new", "BAR=1"}},
+		{name: "sort", normalization: config.EnvSort, expected: []string{"BAR=1", "FOO=new", "ZED=2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			dockerFile := fmt.Sprintf("FROM %s\nENV FOO=new", baseRef)
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			destination := repo + "/test:" + tc.name
+			opts := &config.KanikoOptions{
+				DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:      filepath.Join(testDir, "workspace"),
+				SnapshotMode:    constants.SnapshotModeFull,
+				Destinations:    []string{destination},
+				NormalizeEnv:    tc.normalization,
+				RegistryOptions: config.RegistryOptions{InsecurePull: true},
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+			testutil.CheckNoError(t, DoPush(image, opts))
+
+			ref, err := name.ParseReference(destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pushed, err := remote.Image(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := pushed.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckDeepEqual(t, tc.expected, cfg.Config.Env)
+		})
+	}
+}
+
+func Test_normalizeEnv(t *testing.T) {
+	env := []string{"B=1", "A=1", "B=2", "NOVALUE", "A=", "NOVALUE"}
+	testutil.CheckDeepEqual(t, []string{"B=2", "A=", "NOVALUE"}, normalizeEnv(env, false))
+	testutil.CheckDeepEqual(t, []string{"A=", "B=2", "NOVALUE"}, normalizeEnv(env, true))
+	testutil.CheckDeepEqual(t, []string{}, normalizeEnv(nil, true))
+}
+
+func TestDoBuild_KeepRootOnExit(t *testing.T) {
+	for _, keep := range []bool{true, false} {
+		t.Run(fmt.Sprintf("keep %v", keep), func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			dockerFile := `
+FROM scratch
+COPY foo/bam.txt built/bam.txt`
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:     filepath.Join(testDir, "workspace"),
+				SnapshotMode:   constants.SnapshotModeFull,
+				Cleanup:        true,
+				KeepRootOnExit: keep,
+			}
+			_, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+
+			_, err = os.Stat(filepath.Join(testDir, "built", "bam.txt"))
+			if keep {
+				testutil.CheckNoError(t, err)
+			} else if !os.IsNotExist(err) {
+				t.Errorf("expected the filesystem to be cleaned, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDoBuild_SkipUnchangedCopies(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip %v", skip), func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			dockerFile := `
+FROM scratch
+COPY foo copied/
+COPY foo copied/
+COPY foo/bam.txt copied/
+COPY exec copied/bam.txt`
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath:      filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:          filepath.Join(testDir, "workspace"),
+				SnapshotMode:        constants.SnapshotModeFull,
+				SkipUnchangedCopies: skip,
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+
+			layers, err := image.Layers()
+			testutil.CheckNoError(t, err)
+			cf, err := image.ConfigFile()
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, 4, len(cf.History))
+			content, err := os.ReadFile(filepath.Join(testDir, "copied", "bam.txt"))
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, "woof", string(content))
+			if !skip {
+				testutil.CheckDeepEqual(t, 4, len(layers))
+				return
+			}
+			// copying the same files again changes nothing, a different file still is copied
+			testutil.CheckDeepEqual(t, 2, len(layers))
+			emptyLayers := []bool{}
+			for _, h := range cf.History {
+				emptyLayers = append(emptyLayers, h.EmptyLayer)
+			}
+			testutil.CheckDeepEqual(t, []bool{false, true, true, false}, emptyLayers)
+		})
+	}
+}
+
+func TestDoBuild_EmptyLayers(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep %v", keep), func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			dockerFile := `
+FROM scratch
+COPY foo/* copied/`
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", ".dockerignore"), []byte("foo/*\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:      filepath.Join(testDir, "workspace"),
+				SnapshotMode:    constants.SnapshotModeFull,
+				KeepEmptyLayers: keep,
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+
+			layers, err := image.Layers()
+			testutil.CheckNoError(t, err)
+			cf, err := image.ConfigFile()
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, 1, len(cf.History))
+			testutil.CheckDeepEqual(t, "COPY foo/* copied/", cf.History[0].CreatedBy)
+			if keep {
+				testutil.CheckDeepEqual(t, 1, len(layers))
+				testutil.CheckDeepEqual(t, false, cf.History[0].EmptyLayer)
+			} else {
+				testutil.CheckDeepEqual(t, 0, len(layers))
+				testutil.CheckDeepEqual(t, true, cf.History[0].EmptyLayer)
+				testutil.CheckDeepEqual(t, 0, len(cf.RootFS.DiffIDs))
+			}
+		})
+	}
+}
+
+func TestDoBuild_HideHistory(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := fmt.Sprintf(`
+FROM scratch
+COPY foo/bam.txt copied/
+RUN ["/bin/sh", "-c", "rm %[1]s/copied/bam.txt && echo cleaned > %[1]s/copied/cleaned"]
+ENV HIDDEN=true
+COPY exec copied/`, testDir)
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		HideHistory:    []string{"^RUN ", "HIDDEN"},
+	}
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	cf, err := image.ConfigFile()
+	testutil.CheckNoError(t, err)
+	// the RUN keeps its layer, but not what created it, the ENV is left out
+	testutil.CheckDeepEqual(t, 3, len(layers))
+	createdBy := []string{}
+	for _, h := range cf.History {
+		testutil.CheckDeepEqual(t, false, h.EmptyLayer)
+		createdBy = append(createdBy, h.CreatedBy)
+	}
+	testutil.CheckDeepEqual(t, []string{"COPY foo/bam.txt copied/", "", "COPY exec copied/"}, createdBy)
+	testutil.CheckDeepEqual(t, "HIDDEN=true", cf.Config.Env[len(cf.Config.Env)-1])
+
+	content, err := os.ReadFile(filepath.Join(testDir, "copied", "cleaned"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "cleaned\n", string(content))
+	if _, err := os.Stat(filepath.Join(testDir, "copied", "bam.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the file the hidden RUN removed to be gone, got %v", err)
+	}
+}
+
+func TestDoBuild_RunEnv(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := fmt.Sprintf(`
+FROM scratch
+ARG VERSION
+RUN --env=APP_VERSION=$VERSION ["/bin/sh", "-c", "echo $APP_VERSION > %s/version"]`, testDir)
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		BuildArgs:      []string{"VERSION=1.2.3"},
+	}
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(testDir, "version"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "1.2.3\n", string(content))
+	cf, err := image.ConfigFile()
+	testutil.CheckNoError(t, err)
+	for _, env := range cf.Config.Env {
+		if strings.HasPrefix(env, "APP_VERSION=") {
+			t.Errorf("expected the variable of RUN --env not to be in the image config, got %v", cf.Config.Env)
+		}
+	}
+}
+
+func Test_stageBuilder_argsFor(t *testing.T) {
+	dockerFile := `
+FROM scratch
+ARG A=stage
+ARG B=stage
+RUN echo one
+ARG A=scoped
+RUN echo two
+RUN echo three`
+	stages, _, err := dockerfile.Parse([]byte(dockerFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		scoped      bool
+		commandArgs []string
+		want        []string
+	}{
+		{
+			name: "ARG applies to all following commands",
+			want: []string{"A=stage B=stage", "A=scoped B=stage", "A=scoped B=stage"},
+		},
+		{
+			name:   "redeclared ARG before RUN is scoped to it",
+			scoped: true,
+			want:   []string{"A=stage B=stage", "A=scoped B=stage", "A=stage B=stage"},
+		},
+		{
+			name:        "command build arg applies to one RUN",
+			commandArgs: []string{"2:B=cli", "2:C=cli"},
+			want:        []string{"A=stage B=cli C=cli", "A=scoped B=stage", "A=scoped B=stage"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FF_KANIKO_SCOPED_RUN_ARGS", strconv.FormatBool(tt.scoped))
+			commandArgs, err := commandBuildArgs(stages, tt.commandArgs)
+			testutil.CheckNoError(t, err)
+			opts := &config.KanikoOptions{SnapshotMode: constants.SnapshotModeFull}
+			sb, err := newStageBuilder(dockerfile.NewBuildArgs(nil), opts, config.KanikoStage{Stage: stages[0]}, nil, nil, nil, nil, util.FileContext{}, commandArgs)
+			testutil.CheckNoError(t, err)
+
+			got := []string{}
+			for i, command := range sb.cmds {
+				args := sb.argsFor(i)
+				if command.MetadataOnly() {
+					testutil.CheckNoError(t, command.ExecuteCommand(&sb.cf.Config, args))
+					continue
+				}
+				allowed := args.GetAllAllowed()
+				keys := []string{}
+				for k, v := range allowed {
+					if v != "" {
+						keys = append(keys, k+"="+v)
+					}
+				}
+				sort.Strings(keys)
+				got = append(got, strings.Join(keys, " "))
+			}
+			sb.endArgScope()
+			testutil.CheckDeepEqual(t, tt.want, got)
+		})
+	}
+}
+
+func Test_commandBuildArgs_invalid(t *testing.T) {
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nARG A=1\nRUN echo $A\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"1", "one:A=2", "1:A", "0:A=2", "2:A=2"} {
+		_, err := commandBuildArgs(stages, []string{value})
+		testutil.CheckError(t, true, err)
+	}
+}
+
+func TestDoBuild_InlineCache(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo copied/
+COPY exec copied/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/app:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(cacheFrom ...string) (v1.Image, int) {
+		hook := logrustest.NewGlobal()
+		defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		opts := &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			Cache:           true,
+			CacheCopyLayers: true,
+			CacheInline:     true,
+			CacheFrom:       cacheFrom,
+			CacheRepo:       "oci:" + t.TempDir(),
+			NoPushCache:     true,
+		}
+		image, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+		hits := 0
+		for _, e := range hook.AllEntries() {
+			if strings.HasPrefix(e.Message, "Using caching version of cmd") {
+				hits++
+			}
+		}
+		return image, hits
+	}
+
+	image, hits := build()
+	testutil.CheckDeepEqual(t, 0, hits)
+	keys, err := cache.InlineCacheKeys(image)
+	testutil.CheckNoError(t, err)
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(keys))
+	testutil.CheckDeepEqual(t, 2, len(layers))
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second build imports the cache from the pushed image
+	cached, hits := build(ref.String())
+	testutil.CheckDeepEqual(t, 2, hits)
+	cachedKeys, err := cache.InlineCacheKeys(cached)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, keys, cachedKeys)
+	cachedLayers, err := cached.Layers()
+	testutil.CheckNoError(t, err)
+	for i := range layers {
+		expected, err := layers[i].Digest()
+		testutil.CheckNoError(t, err)
+		actual, err := cachedLayers[i].Digest()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, expected, actual)
+	}
+}
+
+func TestDoBuild_CacheLayerKeys(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/app:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(dockerFile string, cacheFrom ...string) (v1.Image, int) {
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		hook := logrustest.NewGlobal()
+		defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		opts := &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			Cache:           true,
+			CacheCopyLayers: true,
+			CacheLayerKeys:  true,
+			CacheFrom:       cacheFrom,
+			CacheRepo:       "oci:" + t.TempDir(),
+			NoPushCache:     true,
+		}
+		image, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+		hits := 0
+		for _, e := range hook.AllEntries() {
+			if strings.HasPrefix(e.Message, "Using caching version of cmd") {
+				hits++
+			}
+		}
+		return image, hits
+	}
+
+	image, hits := build(`
+FROM scratch
+COPY exec copied/
+COPY foo copied/`)
+	testutil.CheckDeepEqual(t, 0, hits)
+	keys, err := cache.LayerCacheKeys(image)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(keys))
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first COPY differs, so the composite cache key of the second one does as well,
+	// its layer is still found by its layer cache key
+	cached, hits := build(`
+FROM scratch
+COPY foo/bam.txt other/
+COPY foo copied/`, ref.String())
+	testutil.CheckDeepEqual(t, 1, hits)
+	cachedLayers, err := cached.Layers()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(cachedLayers))
+	expected, err := layers[1].Digest()
+	testutil.CheckNoError(t, err)
+	actual, err := cachedLayers[1].Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, expected, actual)
+	content, err := os.ReadFile(filepath.Join(testDir, "copied", "bam.txt"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "meow", string(content))
+	// the reused layer keeps its layer cache key
+	cachedKeys, err := cache.LayerCacheKeys(cached)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(cachedKeys))
+	found := false
+	for _, d := range cachedKeys {
+		found = found || d == expected
+	}
+	testutil.CheckDeepEqual(t, true, found)
+}
+
+func TestDoBuild_BuildArgOverridesBase(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/base"
+	bases := map[string]v1.Image{}
+	for _, tag := range []string{"default", "override"} {
+		ref, err := name.NewTag(repo + ":" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+		bases[tag] = img
+	}
+	dockerFile := fmt.Sprintf(`
+ARG BASE=%s:default
+FROM $BASE
+COPY foo copied/`, repo)
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		buildArgs []string
+		base      string
+	}{
+		{name: "default", base: "default"},
+		{name: "overridden", buildArgs: []string{"BASE=" + repo + ":override"}, base: "override"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &config.KanikoOptions{
+				DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:      filepath.Join(testDir, "workspace"),
+				SnapshotMode:    constants.SnapshotModeFull,
+				BuildArgs:       tc.buildArgs,
+				RegistryOptions: config.RegistryOptions{InsecurePull: true},
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+			layers, err := image.Layers()
+			testutil.CheckNoError(t, err)
+			baseLayers, err := bases[tc.base].Layers()
+			testutil.CheckNoError(t, err)
+			expected, err := baseLayers[0].Digest()
+			testutil.CheckNoError(t, err)
+			actual, err := layers[0].Digest()
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, expected, actual)
+		})
+	}
+}
+
+func TestDoBuild_CacheExportDir(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	marker := filepath.Join(t.TempDir(), "ran")
+	dockerFile := fmt.Sprintf(`
+FROM scratch
+COPY foo copied/
+RUN touch %s`, marker)
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	exportDir := filepath.Join(t.TempDir(), "cache")
+	newOpts := func() *config.KanikoOptions {
+		return &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			Cache:           true,
+			CacheCopyLayers: true,
+			CacheRunLayers:  true,
+			NoPushCache:     true,
+			CacheOptions:    config.CacheOptions{CacheTTL: time.Hour},
+		}
+	}
+
+	// without a cache repo the layers are only exported
+	opts := newOpts()
+	opts.CacheExportDir = exportDir
+	opts.CacheInline = true
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+	keys, err := cache.InlineCacheKeys(image)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(keys))
+
+	// the index names the manifest of each cached layer by its cache key
+	exportedKeys := func(dir string) []string {
+		p, err := layout.FromPath(dir)
+		testutil.CheckNoError(t, err)
+		index, err := p.ImageIndex()
+		testutil.CheckNoError(t, err)
+		manifest, err := index.IndexManifest()
+		testutil.CheckNoError(t, err)
+		var exported []string
+		for _, desc := range manifest.Manifests {
+			exported = append(exported, desc.Annotations["org.opencontainers.image.ref.name"])
+		}
+		sort.Strings(exported)
+		return exported
+	}
+	var expected []string
+	for ck := range keys {
+		expected = append(expected, ck)
+	}
+	sort.Strings(expected)
+	testutil.CheckDeepEqual(t, expected, exportedKeys(exportDir))
+
+	// a build importing the exported cache reuses both layers
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	// the layers found in the cache are exported as well
+	reexportDir := filepath.Join(t.TempDir(), "cache")
+	opts = newOpts()
+	opts.CacheImportDir = exportDir
+	opts.CacheExportDir = reexportDir
+	rebuilt, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the RUN layer to be imported from the exported cache, but the command ran")
+	}
+	testutil.CheckDeepEqual(t, expected, exportedKeys(reexportDir))
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	rebuiltLayers, err := rebuilt.Layers()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, len(layers), len(rebuiltLayers))
+	for i := range layers {
+		expected, err := layers[i].Digest()
+		testutil.CheckNoError(t, err)
+		actual, err := rebuiltLayers[i].Digest()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, expected, actual)
+	}
+}
+
+func TestDoBuild_CacheFrom(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	marker := filepath.Join(t.TempDir(), "ran")
+	dockerFile := fmt.Sprintf(`
+FROM scratch
+COPY foo copied/
+RUN touch %s`, marker)
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/cache:prepared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newOpts := func() *config.KanikoOptions {
+		return &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			Cache:           true,
+			CacheCopyLayers: true,
+			CacheRunLayers:  true,
+			CacheRepo:       "oci:" + t.TempDir(),
+			NoPushCache:     true,
+		}
+	}
+
+	// the cache keys of the build
+	opts := newOpts()
+	opts.CacheInline = true
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+	keys, err := cache.InlineCacheKeys(image)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(keys))
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	// the keys of the COPY and the RUN layer
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	var ordered []string
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		testutil.CheckNoError(t, err)
+		for ck, d := range keys {
+			if d == digest {
+				ordered = append(ordered, ck)
+			}
+		}
+	}
+	testutil.CheckDeepEqual(t, 2, len(ordered))
+
+	// a prepared cache image whose layers are stored under these keys,
+	// their files are below the destination of the COPY
+	var prepared []v1.Layer
+	annotation := map[string]v1.Hash{}
+	cacheImage := empty.Image
+	for i, ck := range ordered {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		content := []byte(fmt.Sprintf("prepared %d", i))
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("copied/prepared-%d", i), Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		testutil.CheckNoError(t, err)
+		digest, err := layer.Digest()
+		testutil.CheckNoError(t, err)
+		cacheImage, err = mutate.AppendLayers(cacheImage, layer)
+		testutil.CheckNoError(t, err)
+		prepared = append(prepared, layer)
+		annotation[ck] = digest
+	}
+	b, err := json.Marshal(annotation)
+	testutil.CheckNoError(t, err)
+	cacheImage = mutate.Annotations(cacheImage, map[string]string{cache.InlineCacheAnnotation: string(b)}).(v1.Image)
+	if err := remote.Write(ref, cacheImage); err != nil {
+		t.Fatal(err)
+	}
+
+	opts = newOpts()
+	opts.CacheFrom = []string{ref.String()}
+	image, err = DoBuild(opts)
+	testutil.CheckNoError(t, err)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected RUN to be served from the cache, got %v", err)
+	}
+	layers, err = image.Layers()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, len(prepared), len(layers))
+	for i := range prepared {
+		expected, err := prepared[i].Digest()
+		testutil.CheckNoError(t, err)
+		actual, err := layers[i].Digest()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, expected, actual)
+	}
+}
+
+func TestDoBuild_ExtraStagesExtractedOnce(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://")
+	for image, file := range map[string]string{"tools": "bin/tool", "other": "etc/other"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(&tar.Header{Name: file, Mode: 0o644, Size: int64(len(image))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(image)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		testutil.CheckNoError(t, err)
+		img, err := mutate.AppendLayers(empty.Image, layer)
+		testutil.CheckNoError(t, err)
+		ref, err := name.NewTag(repo + "/" + image)
+		testutil.CheckNoError(t, err)
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	extracted := map[string]int{}
+	original := getFSFromImage
+	defer func() { getFSFromImage = original }()
+	getFSFromImage = func(root string, img v1.Image, extract util.ExtractFunction, opts ...util.FSOpt) ([]string, error) {
+		if strings.HasPrefix(root, config.KanikoInterStageDepsDir) {
+			mu.Lock()
+			extracted[root]++
+			mu.Unlock()
+		}
+		return original(root, img, extract)
+	}
+
+	dockerFile := fmt.Sprintf(`
+FROM scratch
+COPY --from=%[1]s/tools /bin/tool out/a
+COPY --from=%[1]s/tools /bin/tool out/b
+COPY --from=%[1]s/other /etc/other out/`, repo)
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath:          filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:              filepath.Join(testDir, "workspace"),
+		SnapshotMode:            constants.SnapshotModeFull,
+		StageExtractConcurrency: 2,
+		RegistryOptions:         config.RegistryOptions{InsecurePull: true},
+	}
+	_, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	testutil.CheckDeepEqual(t, map[string]int{
+		filepath.Join(config.KanikoInterStageDepsDir, repo+"/tools"): 1,
+		filepath.Join(config.KanikoInterStageDepsDir, repo+"/other"): 1,
+	}, extracted)
+	for file, content := range map[string]string{"a": "tools", "b": "tools", "other": "other"} {
+		b, err := os.ReadFile(filepath.Join(testDir, "out", file))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, content, string(b))
+	}
+}
+
+func TestDoBuild_Interrupted(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	defer util.ResetBuildContext()
+
+	kanikoDir := filepath.Join(testDir, "kaniko")
+	originalDeps, originalLayers, originalCaches := config.KanikoInterStageDepsDir, config.KanikoLayersDir, config.KanikoCacheDir
+	defer func() {
+		config.KanikoInterStageDepsDir, config.KanikoLayersDir, config.KanikoCacheDir = originalDeps, originalLayers, originalCaches
+	}()
+	config.KanikoInterStageDepsDir = filepath.Join(kanikoDir, "deps")
+	config.KanikoLayersDir = filepath.Join(kanikoDir, "layers")
+	config.KanikoCacheDir = filepath.Join(kanikoDir, "caches")
+	for _, dir := range []string{config.KanikoLayersDir, config.KanikoCacheDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the build is interrupted when the second stage is unpacked for its RUN
+	original := getFSFromImage
+	defer func() { getFSFromImage = original }()
+	getFSFromImage = func(root string, img v1.Image, extract util.ExtractFunction, opts ...util.FSOpt) ([]string, error) {
+		if _, err := os.Stat(filepath.Join(config.KanikoInterStageDepsDir, "0")); err != nil {
+			// the first stage is unpacked for its dependencies
+			return original(root, img, extract, opts...)
+		}
+		util.CancelBuild(fmt.Errorf("%w: received terminated", util.ErrInterrupted))
+		return original(root, img, extract, opts...)
+	}
+
+	dockerFile := `
+FROM scratch AS first
+COPY foo/bam.txt copied/
+FROM scratch
+COPY --from=first copied/bam.txt out/
+RUN true`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+	}
+	_, err := DoBuild(opts)
+	if !errors.Is(err, util.ErrInterrupted) {
+		t.Fatalf("expected the build to be interrupted, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(testDir, "out")); !os.IsNotExist(err) {
+		t.Errorf("expected the commands after the interruption to not run, got %v", err)
+	}
+	for _, dir := range []string{config.KanikoInterStageDepsDir, config.KanikoLayersDir} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", dir, err)
+		}
+	}
+	for _, dir := range []string{kanikoDir, config.KanikoCacheDir} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("expected %s to be kept: %v", dir, err)
+		}
+	}
+}
+
+func TestDoBuild_DiagnosticsFile(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+MAINTAINER kaniko
+COPY missing* copied/
+COPY --chown=4242:4343 foo/bam.txt copied/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	diagnosticsFile := filepath.Join(t.TempDir(), "diagnostics.json")
+	opts := &config.KanikoOptions{
+		DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:      filepath.Join(testDir, "workspace"),
+		SnapshotMode:    constants.SnapshotModeFull,
+		DiagnosticsFile: diagnosticsFile,
+	}
+	_, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	b, err := os.ReadFile(diagnosticsFile)
+	testutil.CheckNoError(t, err)
+	var got []diagnostics.Diagnostic
+	testutil.CheckNoError(t, json.Unmarshal(b, &got))
+	var codes []diagnostics.Code
+	for _, d := range got {
+		codes = append(codes, d.Code)
+	}
+	testutil.CheckDeepEqual(t, []diagnostics.Code{diagnostics.DeprecatedInstruction, diagnostics.EmptyCopy, diagnostics.ChownUnresolved}, codes)
+	testutil.CheckDeepEqual(t, "user 4242 and group 4343 of 4242:4343 not found, using the numeric ids 4242:4343", got[2].Message)
+}
+
+func TestDoBuild_PreprocessDockerfile(t *testing.T) {
+	dockerFile := `
+FROM scratch
+COPY exec always/
+# kaniko:if WITH_FOO==yes
+COPY foo/bam.txt optional/
+# kaniko:endif`
+	for _, tc := range []struct {
+		name      string
+		buildArgs []string
+		layers    int
+	}{
+		{name: "included", buildArgs: []string{"WITH_FOO=yes"}, layers: 2},
+		{name: "skipped", buildArgs: []string{"WITH_FOO=no"}, layers: 1},
+		{name: "skipped without build arg", layers: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath:       filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:           filepath.Join(testDir, "workspace"),
+				SnapshotMode:         constants.SnapshotModeFull,
+				BuildArgs:            tc.buildArgs,
+				PreprocessDockerfile: true,
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+			layers, err := image.Layers()
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, tc.layers, len(layers))
+			_, err = os.Stat(filepath.Join(testDir, "optional", "bam.txt"))
+			testutil.CheckDeepEqual(t, tc.layers == 2, err == nil)
+		})
+	}
+}
+
+func Test_parseRunUmask(t *testing.T) {
+	for _, tc := range []struct {
+		umask     string
+		want      *int
+		shouldErr bool
+	}{
+		{umask: ""},
+		{umask: "022", want: intPtr(0o022)},
+		{umask: "0", want: intPtr(0)},
+		{umask: "0777", want: intPtr(0o777)},
+		{umask: "1000", shouldErr: true},
+		{umask: "-1", shouldErr: true},
+		{umask: "9", shouldErr: true},
+	} {
+		t.Run(tc.umask, func(t *testing.T) {
+			got, err := parseRunUmask(tc.umask)
+			testutil.CheckErrorAndDeepEqual(t, tc.shouldErr, err, tc.want, got)
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// checkpointDue returns true if the build root should be written to the
+// checkpoint tar after the command at index, see --checkpoint-tar.
+func (s *stageBuilder) checkpointDue(index int) bool {
+	return s.opts.CheckpointTarPath != "" && s.stage.Final && s.opts.CheckpointCommand == index+1
+}
+
+// checkpointOnFailure writes the checkpoint tar when a command fails, unless
+// the checkpoint is taken at a given command.
+func (s *stageBuilder) checkpointOnFailure() {
+	if s.opts.CheckpointTarPath == "" || s.opts.CheckpointCommand > 0 {
+		return
+	}
+	if err := writeCheckpoint(s.opts.CheckpointTarPath); err != nil {
+		logrus.Warnf("Failed to write checkpoint: %v", err)
+	}
+}
+
+// writeCheckpoint writes a tar of the build root to path. Ignored paths are
+// left out, like they are from the layers.
+func writeCheckpoint(path string) (err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "creating checkpoint tar")
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	t := util.NewTar(f)
+	defer t.Close()
+
+	err = filepath.WalkDir(config.RootDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == config.RootDir || p == path {
+			return nil
+		}
+		if util.CheckIgnoreList(p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return t.AddFileToTar(p)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "writing checkpoint tar %s", path)
+	}
+	logrus.Infof("Wrote checkpoint of %s to %s", config.RootDir, path)
+	return nil
+}
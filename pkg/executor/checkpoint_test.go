@@ -0,0 +1,86 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_stageBuilder_checkpointDue(t *testing.T) {
+	s := &stageBuilder{
+		opts:  &config.KanikoOptions{CheckpointTarPath: "/checkpoint.tar", CheckpointCommand: 2},
+		stage: config.KanikoStage{Final: true},
+	}
+	testutil.CheckDeepEqual(t, false, s.checkpointDue(0))
+	testutil.CheckDeepEqual(t, true, s.checkpointDue(1))
+
+	s.stage = config.KanikoStage{Stage: instructions.Stage{Name: "builder"}}
+	testutil.CheckDeepEqual(t, false, s.checkpointDue(1))
+}
+
+func Test_writeCheckpoint(t *testing.T) {
+	root := t.TempDir()
+	original := config.RootDir
+	config.RootDir = root
+	defer func() { config.RootDir = original }()
+
+	if err := os.MkdirAll(filepath.Join(root, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "app", "file"), []byte("built"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "checkpoint.tar")
+	testutil.CheckNoError(t, writeCheckpoint(path))
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	contents := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[hdr.Name] = string(b)
+	}
+	sort.Strings(names)
+	// the checkpoint does not contain itself
+	testutil.CheckDeepEqual(t, []string{"app/", "app/file"}, names)
+	testutil.CheckDeepEqual(t, "built", contents["app/file"])
+}
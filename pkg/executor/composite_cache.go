@@ -19,6 +19,7 @@ package executor
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -58,7 +59,14 @@ func (s *CompositeCache) Hash() (string, error) {
 
 func (s *CompositeCache) AddPath(p string, context util.FileContext) error {
 	sha := sha256.New()
-	fi, err := os.Lstat(p)
+	fi, provided, err := context.ProvidedSource(p)
+	if err != nil {
+		return errors.Wrap(err, "could not add path")
+	}
+	if provided {
+		return s.addProvidedSource(p, fi, context)
+	}
+	fi, err = os.Lstat(p)
 	if err != nil {
 		return errors.Wrap(err, "could not add path")
 	}
@@ -80,7 +88,7 @@ func (s *CompositeCache) AddPath(p string, context util.FileContext) error {
 	if context.ExcludesFile(p) {
 		return nil
 	}
-	fh, err := util.CacheHasher()(p)
+	fh, err := contextDigests.Get(p)
 	if err != nil {
 		return err
 	}
@@ -92,20 +100,37 @@ func (s *CompositeCache) AddPath(p string, context util.FileContext) error {
 	return nil
 }
 
+// addProvidedSource adds the content and mode of a source supplied by the
+// SourceProvider of context to the key.
+func (s *CompositeCache) addProvidedSource(p string, fi os.FileInfo, context util.FileContext) error {
+	if context.ExcludesFile(p) {
+		return nil
+	}
+	r, err := context.Sources.Open(p)
+	if err != nil {
+		return errors.Wrap(err, "could not add path")
+	}
+	defer r.Close()
+	sha := sha256.New()
+	if _, err := io.Copy(sha, r); err != nil {
+		return err
+	}
+	fmt.Fprintf(sha, "%v", fi.Mode())
+	s.keys = append(s.keys, fmt.Sprintf("%x", sha.Sum(nil)))
+	return nil
+}
+
 // HashDir returns a hash of the directory.
 func hashDir(p string, context util.FileContext) (bool, string, error) {
 	sha := sha256.New()
 	empty := true
-	if err := fs.WalkDir(util.FSys, p, func(path string, _ fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+	addPath := func(path string) error {
 		exclude := context.ExcludesFile(path)
 		if exclude {
 			return nil
 		}
 
-		fileHash, err := util.CacheHasher()(path)
+		fileHash, err := contextDigests.Get(path)
 		if err != nil {
 			return err
 		}
@@ -129,6 +154,23 @@ func hashDir(p string, context util.FileContext) (bool, string, error) {
 		}
 		empty = false
 		return nil
+	}
+	if context.FollowDirSymlinks {
+		// the content of the directories symlinks point to is copied, so it is in the key
+		files, _, err := util.RelativeFilesFollowingSymlinks(p)
+		if err != nil {
+			return false, "", err
+		}
+		for _, file := range files {
+			if err := addPath(filepath.Join(p, file)); err != nil {
+				return false, "", err
+			}
+		}
+	} else if err := fs.WalkDir(util.FSys, p, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return addPath(path)
 	}); err != nil {
 		return false, "", err
 	}
@@ -0,0 +1,101 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// maxContextChanges is the number of changed paths a context write error names.
+const maxContextChanges = 10
+
+// contextGuard detects writes to the build context, see --read-only-context.
+type contextGuard struct {
+	root  string
+	files map[string]os.FileInfo
+}
+
+func newContextGuard(root string) (*contextGuard, error) {
+	files, err := contextFiles(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "walking build context %s", root)
+	}
+	return &contextGuard{root: root, files: files}, nil
+}
+
+// check returns an error naming the paths of the context that were added,
+// modified or deleted since the guard was created.
+func (g *contextGuard) check() error {
+	files, err := contextFiles(g.root)
+	if err != nil {
+		return errors.Wrapf(err, "walking build context %s", g.root)
+	}
+	changed := []string{}
+	for path, fi := range files {
+		if before, ok := g.files[path]; !ok || !sameFile(before, fi) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range g.files {
+		if _, ok := files[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	if len(changed) > maxContextChanges {
+		changed = append(changed[:maxContextChanges], "...")
+	}
+	return errors.Errorf("the build wrote to the read-only build context %s: %s", g.root, strings.Join(changed, ", "))
+}
+
+func contextFiles(root string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[path] = fi
+		return nil
+	})
+	return files, err
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	if a.Mode() != b.Mode() || !a.ModTime().Equal(b.ModTime()) || a.Size() != b.Size() {
+		return false
+	}
+	sa, okA := a.Sys().(*syscall.Stat_t)
+	sb, okB := b.Sys().(*syscall.Stat_t)
+	if okA && okB {
+		return sa.Uid == sb.Uid && sa.Gid == sb.Gid
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_ReadOnlyContext(t *testing.T) {
+	t.Run("build from a read-only context", func(t *testing.T) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		workspace := filepath.Join(testDir, "workspace")
+		dockerFile := `
+FROM scratch
+COPY foo copied/
+COPY exec copied/`
+		if err := os.WriteFile(filepath.Join(workspace, "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		makeReadOnly(t, workspace)
+		opts := &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(workspace, "Dockerfile"),
+			SrcContext:      workspace,
+			SnapshotMode:    constants.SnapshotModeFull,
+			ReadOnlyContext: true,
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+		content, err := os.ReadFile(filepath.Join(testDir, "copied", "bam.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "meow", string(content))
+	})
+
+	t.Run("write to the context is detected", func(t *testing.T) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		workspace := filepath.Join(testDir, "workspace")
+		dockerFile := `
+FROM scratch
+COPY foo/bam.txt workspace/new.txt`
+		if err := os.WriteFile(filepath.Join(workspace, "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		opts := &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(workspace, "Dockerfile"),
+			SrcContext:      workspace,
+			SnapshotMode:    constants.SnapshotModeFull,
+			ReadOnlyContext: true,
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckError(t, true, err)
+		if !strings.Contains(err.Error(), "read-only build context") || !strings.Contains(err.Error(), filepath.Join(workspace, "new.txt")) {
+			t.Errorf("expected the error to name the written path, got %v", err)
+		}
+	})
+}
+
+// makeReadOnly removes the write permissions of everything under root.
+func makeReadOnly(t *testing.T, root string) {
+	t.Helper()
+	paths := []string{}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type()&fs.ModeSymlink == 0 {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, fi.Mode().Perm()&^0o222); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, path := range paths {
+			os.Chmod(path, 0o755)
+		}
+	})
+}
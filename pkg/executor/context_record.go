@@ -0,0 +1,215 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/commands"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// contextRecord is the file written with --context-digest-file. The next build reuses
+// the digests of the context files that are unchanged and, if the whole context is
+// unchanged, the files the commands use from it.
+type contextRecord struct {
+	Fingerprint string                    `json:"fingerprint"`
+	Files       map[string][]string       `json:"files"`
+	Digests     map[string]recordedDigest `json:"digests"`
+}
+
+type recordedDigest struct {
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	UID     uint32      `json:"uid"`
+	GID     uint32      `json:"gid"`
+	Digest  string      `json:"digest"`
+}
+
+// usedContextFiles remembers the files commands use from the context for the
+// --context-digest-file of a single build, it is reset by DoBuild.
+var usedContextFiles = &contextFilesCache{}
+
+type contextFilesCache struct {
+	mu          sync.Mutex
+	enabled     bool
+	root        string
+	fingerprint string
+	// recorded are the files of the last build, they are only set if
+	// the context is unchanged since
+	recorded map[string][]string
+	files    map[string][]string
+}
+
+func (c *contextFilesCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled, c.root, c.fingerprint = false, "", ""
+	c.recorded, c.files = nil, nil
+}
+
+func (c *contextFilesCache) get(key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files, ok := c.recorded[key]
+	if ok {
+		c.files[key] = files
+	}
+	return files, ok
+}
+
+func (c *contextFilesCache) put(key string, files []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return
+	}
+	// files of other stages or named contexts may change without the context changing
+	for _, f := range files {
+		if !util.HasFilepathPrefix(f, c.root, false) {
+			return
+		}
+	}
+	c.files[key] = files
+}
+
+// contextFingerprint returns a hash of the paths, modes, sizes, mtimes and owners of
+// the files in the context and of the patterns excluding files from it.
+func contextFingerprint(context util.FileContext) (string, error) {
+	files, err := contextFiles(context.Root)
+	if err != nil {
+		return "", errors.Wrapf(err, "walking build context %s", context.Root)
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		fi := files[path]
+		fmt.Fprintf(h, "%s\x00%v\x00%d\x00%d", path, fi.Mode(), fi.Size(), fi.ModTime().UnixNano())
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+			fmt.Fprintf(h, "\x00%d\x00%d", stat.Uid, stat.Gid)
+		}
+		h.Write([]byte{'\n'})
+	}
+	fmt.Fprintf(h, "%q", context.ExcludedFiles)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// loadContextRecord restores the digests recorded in path and, if the fingerprint
+// of the context is unchanged, the files used from it. A missing or unreadable
+// record means everything is computed from scratch.
+func loadContextRecord(path string, context util.FileContext) error {
+	fingerprint, err := contextFingerprint(context)
+	if err != nil {
+		return err
+	}
+	usedContextFiles.mu.Lock()
+	usedContextFiles.enabled = true
+	usedContextFiles.root = context.Root
+	usedContextFiles.fingerprint = fingerprint
+	usedContextFiles.files = map[string][]string{}
+	usedContextFiles.mu.Unlock()
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	var record contextRecord
+	if err == nil {
+		err = json.Unmarshal(b, &record)
+	}
+	if err != nil {
+		logrus.Warnf("Ignoring context digest file %s: %v", path, err)
+		return nil
+	}
+
+	entries := make(map[string]digestEntry, len(record.Digests))
+	for p, d := range record.Digests {
+		entries[p] = digestEntry{mtime: d.ModTime, size: d.Size, mode: d.Mode, uid: d.UID, gid: d.GID, digest: d.Digest}
+	}
+	contextDigests.restore(entries)
+	if record.Fingerprint == fingerprint {
+		logrus.Infof("Build context is unchanged, reusing the files used from it recorded in %s", path)
+		usedContextFiles.mu.Lock()
+		usedContextFiles.recorded = record.Files
+		usedContextFiles.mu.Unlock()
+	} else {
+		logrus.Debugf("Build context changed since %s was written", path)
+	}
+	return nil
+}
+
+// saveContextRecord writes the digests and the files used from the context of this build to path.
+func saveContextRecord(path string) error {
+	usedContextFiles.mu.Lock()
+	record := contextRecord{
+		Fingerprint: usedContextFiles.fingerprint,
+		Files:       usedContextFiles.files,
+		Digests:     map[string]recordedDigest{},
+	}
+	usedContextFiles.mu.Unlock()
+	for p, e := range contextDigests.snapshot() {
+		record.Digests[p] = recordedDigest{ModTime: e.mtime, Size: e.size, Mode: e.mode, UID: e.uid, GID: e.gid, Digest: e.digest}
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "creating directory for context digest file")
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// filesUsedFromContext returns the files command uses from the context, they are
+// reused from the --context-digest-file if the context is unchanged.
+func filesUsedFromContext(command commands.DockerCommand, cfg *v1.Config, args *dockerfile.BuildArgs) ([]string, error) {
+	key := contextFilesKey(command, cfg, args)
+	if files, ok := usedContextFiles.get(key); ok {
+		return files, nil
+	}
+	files, err := command.FilesUsedFromContext(cfg, args)
+	if err != nil {
+		return nil, err
+	}
+	usedContextFiles.put(key, files)
+	return files, nil
+}
+
+// contextFilesKey identifies a command together with everything it expands its sources with.
+func contextFilesKey(command commands.DockerCommand, cfg *v1.Config, args *dockerfile.BuildArgs) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", command.String(), cfg.WorkingDir, strings.Join(args.ReplacementEnvs(cfg.Env), "\x00"))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
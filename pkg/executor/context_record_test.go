@@ -0,0 +1,131 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+type countingCommand struct {
+	MockDockerCommand
+	calls *int
+}
+
+func (c countingCommand) FilesUsedFromContext(cfg *v1.Config, args *dockerfile.BuildArgs) ([]string, error) {
+	*c.calls++
+	return c.MockDockerCommand.FilesUsedFromContext(cfg, args)
+}
+
+func Test_contextRecord(t *testing.T) {
+	originalDigests := contextDigests
+	defer func() {
+		contextDigests = originalDigests
+		usedContextFiles.reset()
+	}()
+	hashes := 0
+	contextDigests = newDigestCache(func(p string) (string, error) {
+		hashes++
+		return util.CacheHasher()(p)
+	})
+
+	root := t.TempDir()
+	record := filepath.Join(t.TempDir(), "context.json")
+	past := time.Now().Add(-time.Hour)
+	writeFile := func(name, content string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		// adding a file modifies the directory
+		if err := os.Chtimes(root, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("a", "meow", past)
+
+	lists := 0
+	context := util.FileContext{Root: root}
+	cmd := countingCommand{
+		MockDockerCommand: MockDockerCommand{command: "COPY a /a", contextFiles: []string{filepath.Join(root, "a")}},
+		calls:             &lists,
+	}
+	// build resolves the files of cmd and hashes them like a build with --context-digest-file
+	build := func() {
+		t.Helper()
+		contextDigests.Reset()
+		usedContextFiles.reset()
+		testutil.CheckNoError(t, loadContextRecord(record, context))
+		files, err := filesUsedFromContext(cmd, &v1.Config{}, dockerfile.NewBuildArgs(nil))
+		testutil.CheckNoError(t, err)
+		for _, f := range files {
+			testutil.CheckNoError(t, NewCompositeCache().AddPath(f, context))
+		}
+		testutil.CheckNoError(t, saveContextRecord(record))
+	}
+
+	build()
+	testutil.CheckDeepEqual(t, 1, lists)
+	testutil.CheckDeepEqual(t, 1, hashes)
+
+	// nothing is recomputed for an unchanged context
+	build()
+	testutil.CheckDeepEqual(t, 1, lists)
+	testutil.CheckDeepEqual(t, 1, hashes)
+	build()
+	testutil.CheckDeepEqual(t, 1, lists)
+	testutil.CheckDeepEqual(t, 1, hashes)
+
+	// a new file changes the context, the digest of the unchanged file is reused
+	writeFile("b", "woof", past.Add(time.Minute))
+	build()
+	testutil.CheckDeepEqual(t, 2, lists)
+	testutil.CheckDeepEqual(t, 1, hashes)
+
+	// a modified file is rehashed
+	writeFile("a", "purr", past.Add(2*time.Minute))
+	build()
+	testutil.CheckDeepEqual(t, 3, lists)
+	testutil.CheckDeepEqual(t, 2, hashes)
+
+	// other excludes change the files used from the context
+	context.ExcludedFiles = []string{"b"}
+	build()
+	testutil.CheckDeepEqual(t, 4, lists)
+	testutil.CheckDeepEqual(t, 2, hashes)
+}
+
+func Test_contextRecord_only_records_context_files(t *testing.T) {
+	defer usedContextFiles.reset()
+	root := t.TempDir()
+	testutil.CheckNoError(t, loadContextRecord(filepath.Join(t.TempDir(), "context.json"), util.FileContext{Root: root}))
+
+	usedContextFiles.put("context", []string{filepath.Join(root, "a")})
+	usedContextFiles.put("stage", []string{filepath.Join(root, "a"), "/kaniko/deps/0/b"})
+	testutil.CheckDeepEqual(t, map[string][]string{"context": {filepath.Join(root, "a")}}, usedContextFiles.files)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// layerSelectorSeparator separates the image a COPY or RUN --mount copies from
+// from the single layer of it to use, as in --from=alpine#layer=0.
+const layerSelectorSeparator = "#layer="
+
+// splitLayerSelector returns the image of from and the selector of its layer,
+// which is empty if from uses all of the image.
+func splitLayerSelector(from string) (string, string) {
+	image, selector, _ := strings.Cut(from, layerSelectorSeparator)
+	return image, selector
+}
+
+// selectLayer returns an image with only the layer of image the selector names.
+// The selector is either the index of the layer, counting from the base and from
+// the top if it is negative, or the digest or diff id of the layer.
+func selectLayer(image v1.Image, selector string) (v1.Image, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	layer, err := findLayer(layers, selector)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Using layer %s of the image for layer selector %s", digest, selector)
+	return mutate.AppendLayers(empty.Image, layer)
+}
+
+func findLayer(layers []v1.Layer, selector string) (v1.Layer, error) {
+	if i, err := strconv.Atoi(selector); err == nil {
+		if i < 0 {
+			i += len(layers)
+		}
+		if i < 0 || i >= len(layers) {
+			return nil, errors.Errorf("layer %s is out of range, the image has %d layers", selector, len(layers))
+		}
+		return layers[i], nil
+	}
+	hash, err := v1.NewHash(selector)
+	if err != nil {
+		return nil, errors.Errorf("layer selector %s is neither an index nor a digest", selector)
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+		if digest == hash || diffID == hash {
+			return layer, nil
+		}
+	}
+	return nil, errors.Errorf("the image has no layer %s", selector)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_findLayer(t *testing.T) {
+	first := testTarLayer(t, testTarEntry{name: "first.txt", typeflag: tar.TypeReg, content: "first"})
+	second := testTarLayer(t, testTarEntry{name: "second.txt", typeflag: tar.TypeReg, content: "second"})
+	layers := []v1.Layer{first, second}
+	digest, err := second.Digest()
+	testutil.CheckNoError(t, err)
+	diffID, err := first.DiffID()
+	testutil.CheckNoError(t, err)
+
+	for _, tc := range []struct {
+		selector string
+		want     v1.Layer
+		wantErr  bool
+	}{
+		{selector: "0", want: first},
+		{selector: "1", want: second},
+		{selector: "-1", want: second},
+		{selector: "-2", want: first},
+		{selector: digest.String(), want: second},
+		{selector: diffID.String(), want: first},
+		{selector: "2", wantErr: true},
+		{selector: "-3", wantErr: true},
+		{selector: "sha256:" + strings.Repeat("0", 64), wantErr: true},
+		{selector: "top", wantErr: true},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			layer, err := findLayer(layers, tc.selector)
+			testutil.CheckError(t, tc.wantErr, err)
+			if !tc.wantErr && layer != tc.want {
+				t.Errorf("findLayer(%s) returned the wrong layer", tc.selector)
+			}
+		})
+	}
+}
+
+func TestDoBuild_CopyFromImageLayer(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/layered:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := mutate.AppendLayers(empty.Image,
+		testTarLayer(t, testTarEntry{name: "etc/", typeflag: tar.TypeDir}, testTarEntry{name: "etc/base.conf", typeflag: tar.TypeReg, content: "base"}),
+		testTarLayer(t, testTarEntry{name: "etc/", typeflag: tar.TypeDir}, testTarEntry{name: "etc/app.conf", typeflag: tar.TypeReg, content: "app"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, source); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(t *testing.T, dockerFile string) (v1.Image, error) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return DoBuild(&config.KanikoOptions{
+			DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:     filepath.Join(testDir, "workspace"),
+			SnapshotMode:   constants.SnapshotModeFull,
+		})
+	}
+
+	t.Run("file of the layer", func(t *testing.T) {
+		image, err := build(t, fmt.Sprintf("FROM scratch\nCOPY --from=%s#layer=1 /etc/app.conf /app.conf", ref))
+		testutil.CheckNoError(t, err)
+		layers, err := image.Layers()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 1, len(layers))
+		rc, err := layers[0].Uncompressed()
+		testutil.CheckNoError(t, err)
+		defer rc.Close()
+		entries := readTarEntries(t, rc)
+		testutil.CheckDeepEqual(t, "app", entries["app.conf"])
+	})
+
+	t.Run("file of another layer", func(t *testing.T) {
+		_, err := build(t, fmt.Sprintf("FROM scratch\nCOPY --from=%s#layer=-1 /etc/base.conf /base.conf", ref))
+		testutil.CheckError(t, true, err)
+	})
+
+	t.Run("layer out of range", func(t *testing.T) {
+		_, err := build(t, fmt.Sprintf("FROM scratch\nCOPY --from=%s#layer=2 /etc/app.conf /app.conf", ref))
+		testutil.CheckError(t, true, err)
+	})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package executor
 
 import (
+	"archive/tar"
 	"fmt"
 	"io/fs"
 	"os"
@@ -170,6 +171,131 @@ COPY --from=first / output/`
 		testutil.CheckDeepEqual(t, "bam.txt", files[1].Name())
 	})
 
+	t.Run("copy a symlink and its target across multistage", func(t *testing.T) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		setupAbsoluteLinks(t, testDir)
+		dockerFile := `
+FROM scratch as first
+COPY foo copied
+COPY links links
+
+From scratch as second
+COPY --from=first copied/bam.link copied/bam.txt links/abs.link output/`
+		os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755)
+		opts := &config.KanikoOptions{
+			DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:     filepath.Join(testDir, "workspace"),
+			SnapshotMode:   constants.SnapshotModeFull,
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+
+		link, err := os.Readlink(filepath.Join(testDir, "output", "bam.link"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "bam.txt", link)
+		content, err := os.ReadFile(filepath.Join(testDir, "output", "bam.link"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "meow", string(content))
+
+		link, err = os.Readlink(filepath.Join(testDir, "output", "abs.link"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "/copied/bam.txt", link)
+	})
+
+	t.Run("copy through an absolute symlink across multistage", func(t *testing.T) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		setupAbsoluteLinks(t, testDir)
+		dockerFile := `
+FROM scratch as first
+COPY foo copied
+COPY links links
+
+From scratch as second
+COPY --from=first links/dir.link/bam.txt output/`
+		os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755)
+		opts := &config.KanikoOptions{
+			DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:     filepath.Join(testDir, "workspace"),
+			SnapshotMode:   constants.SnapshotModeFull,
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(testDir, "output", "bam.txt"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "meow", string(content))
+	})
+
+	t.Run("copy an extracted archive across multistage", func(t *testing.T) {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		setupArchive(t, testDir)
+		dockerFile := `
+FROM scratch as first
+ADD app.tar app
+
+From scratch as second
+COPY --from=first /app/bin output/`
+		os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755)
+		opts := &config.KanikoOptions{
+			DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:     filepath.Join(testDir, "workspace"),
+			SnapshotMode:   constants.SnapshotModeFull,
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(testDir, "output", "tool"))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "#!/bin/sh", string(content))
+	})
+}
+
+// setupArchive adds an archive to the workspace that ADD extracts
+//   - app.tar
+//   - bin/tool
+func setupArchive(t *testing.T, testDir string) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(testDir, "workspace", "app.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	content := []byte("#!/bin/sh")
+	if err := tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupAbsoluteLinks adds links with absolute targets to the workspace, they only
+// resolve inside the root of the stage they are copied to.
+//   - links
+//   - abs.link -> /copied/bam.txt
+//   - dir.link -> /copied
+func setupAbsoluteLinks(t *testing.T, testDir string) {
+	t.Helper()
+	links := filepath.Join(testDir, "workspace", "links")
+	if err := os.MkdirAll(links, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/copied/bam.txt", filepath.Join(links, "abs.link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/copied", filepath.Join(links, "dir.link")); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func setupMultistageTests(t *testing.T) (string, func()) {
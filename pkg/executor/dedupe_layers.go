@@ -0,0 +1,96 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// deduplicateAdjacentLayers drops a layer that is identical to the layer before it. Applying the
+// same diff twice in a row yields the same filesystem as applying it once, identical layers
+// that are not adjacent are kept since the layers in between may change the same paths.
+// The history entry of a dropped layer is kept and marked as empty layer.
+func deduplicateAdjacentLayers(image v1.Image) (v1.Image, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layers")
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting config file")
+	}
+	cf = cf.DeepCopy()
+
+	// history entries that are not empty layers belong to the layers in order
+	layerHistory := []int{}
+	for i, h := range cf.History {
+		if !h.EmptyLayer {
+			layerHistory = append(layerHistory, i)
+		}
+	}
+	if len(cf.History) > 0 && len(layerHistory) != len(layers) {
+		logrus.Warnf("Not deduplicating layers, the history of the image doesn't match its %d layers", len(layers))
+		return image, nil
+	}
+
+	kept := []v1.Layer{}
+	diffIDs := []v1.Hash{}
+	var previous v1.Hash
+	for i, l := range layers {
+		digest, err := l.Digest()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting layer digest")
+		}
+		if i > 0 && digest == previous {
+			logrus.Infof("Dropping layer %s, it is identical to the layer before it", digest)
+			if len(cf.History) > 0 {
+				cf.History[layerHistory[i]].EmptyLayer = true
+			}
+			continue
+		}
+		previous = digest
+		diffID, err := l.DiffID()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting layer diff id")
+		}
+		kept = append(kept, l)
+		diffIDs = append(diffIDs, diffID)
+	}
+	if len(kept) == len(layers) {
+		return image, nil
+	}
+
+	mt, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	deduped, err := mutate.AppendLayers(mutate.MediaType(empty.Image, mt), kept...)
+	if err != nil {
+		return nil, err
+	}
+	deduped = mutate.ConfigMediaType(deduped, m.Config.MediaType)
+	cf.RootFS.DiffIDs = diffIDs
+	return mutate.ConfigFile(deduped, cf)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_DeduplicateAdjacentLayers(t *testing.T) {
+	for _, dedupe := range []bool{false, true} {
+		t.Run(fmt.Sprintf("deduplicate %v", dedupe), func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			dockerFile := `
+FROM scratch
+COPY foo/bam.txt copied/
+COPY foo/bam.txt copied/
+COPY exec copied/`
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath:            filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:                filepath.Join(testDir, "workspace"),
+				SnapshotMode:              constants.SnapshotModeFull,
+				Reproducible:              true,
+				DeduplicateAdjacentLayers: dedupe,
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+
+			m, err := image.Manifest()
+			testutil.CheckNoError(t, err)
+			cf, err := image.ConfigFile()
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, 3, len(cf.History))
+			if m.Layers[0].Digest != m.Layers[1].Digest && !dedupe {
+				t.Fatalf("expected the layers of both COPY commands to be identical, got %s and %s", m.Layers[0].Digest, m.Layers[1].Digest)
+			}
+
+			references := map[string]int{}
+			for _, l := range m.Layers {
+				references[l.Digest.String()]++
+			}
+			if !dedupe {
+				testutil.CheckDeepEqual(t, 3, len(m.Layers))
+				testutil.CheckDeepEqual(t, 2, references[m.Layers[0].Digest.String()])
+				testutil.CheckDeepEqual(t, false, cf.History[1].EmptyLayer)
+				return
+			}
+			testutil.CheckDeepEqual(t, 2, len(m.Layers))
+			testutil.CheckDeepEqual(t, 1, references[m.Layers[0].Digest.String()])
+			testutil.CheckDeepEqual(t, 2, len(cf.RootFS.DiffIDs))
+			for i, l := range m.Layers {
+				layer, err := image.LayerByDigest(l.Digest)
+				testutil.CheckNoError(t, err)
+				diffID, err := layer.DiffID()
+				testutil.CheckNoError(t, err)
+				testutil.CheckDeepEqual(t, cf.RootFS.DiffIDs[i], diffID)
+			}
+			testutil.CheckDeepEqual(t, false, cf.History[0].EmptyLayer)
+			testutil.CheckDeepEqual(t, true, cf.History[1].EmptyLayer)
+			testutil.CheckDeepEqual(t, "COPY foo/bam.txt copied/", cf.History[1].CreatedBy)
+			testutil.CheckDeepEqual(t, false, cf.History[2].EmptyLayer)
+		})
+	}
+}
+
+func Test_deduplicateAdjacentLayers_nonAdjacent(t *testing.T) {
+	a, err := random.Layer(64, types.DockerLayer)
+	testutil.CheckNoError(t, err)
+	b, err := random.Layer(64, types.DockerLayer)
+	testutil.CheckNoError(t, err)
+
+	// the second a may restore files b changed, only the repeated a right after a is dropped
+	image, err := mutate.AppendLayers(empty.Image, a, a, b, a)
+	testutil.CheckNoError(t, err)
+	deduped, err := deduplicateAdjacentLayers(image)
+	testutil.CheckNoError(t, err)
+
+	layers, err := deduped.Layers()
+	testutil.CheckNoError(t, err)
+	var digests []v1.Hash
+	for _, l := range layers {
+		d, err := l.Digest()
+		testutil.CheckNoError(t, err)
+		digests = append(digests, d)
+	}
+	da, err := a.Digest()
+	testutil.CheckNoError(t, err)
+	db, err := b.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []v1.Hash{da, db, da}, digests)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strings"
+
+	"github.com/containerd/platforms"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// platformDestinations returns the destinations that accept the platform of the
+// image, the one of the build unless --image-os or --image-arch set it. A
+// destination without platforms set with --destination-platforms accepts every
+// platform.
+func platformDestinations(opts *config.KanikoOptions) ([]string, error) {
+	if len(opts.DestinationPlatforms) == 0 {
+		return opts.Destinations, nil
+	}
+	built := platforms.DefaultSpec()
+	if opts.CustomPlatform != "" {
+		var err error
+		if built, err = platforms.Parse(opts.CustomPlatform); err != nil {
+			return nil, errors.Wrapf(err, "parsing platform %s", opts.CustomPlatform)
+		}
+	}
+	// the image is pushed with the os and architecture of --image-os and --image-arch
+	image := &v1.ConfigFile{OS: built.OS, Architecture: built.Architecture, Variant: built.Variant}
+	overrideImagePlatform(image, opts)
+	built.OS, built.Architecture, built.Variant = image.OS, image.Architecture, image.Variant
+	var destinations []string
+	for _, destination := range opts.Destinations {
+		allowed, ok := opts.DestinationPlatforms[destination]
+		if !ok {
+			destinations = append(destinations, destination)
+			continue
+		}
+		accepted := false
+		for _, p := range strings.Split(allowed, ",") {
+			spec, err := platforms.Parse(strings.TrimSpace(p))
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing platform %s of destination %s", p, destination)
+			}
+			if platforms.NewMatcher(spec).Match(built) {
+				accepted = true
+			}
+		}
+		if !accepted {
+			logrus.Infof("Not pushing to %s, it only accepts %s", destination, allowed)
+			continue
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	image_util "github.com/osscontainertools/kaniko/pkg/image"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// annotationBaseName and annotationBaseDigest are the OCI annotations naming the base of an image
+	annotationBaseName   = "org.opencontainers.image.base.name"
+	annotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// diffBase is the image a stage is built on that was not built by kaniko,
+// see --push-diff-only.
+type diffBase struct {
+	name  string
+	image v1.Image
+}
+
+func newDiffBase(stage config.KanikoStage, sb *stageBuilder, opts *config.KanikoOptions) (diffBase, error) {
+	name, err := image_util.BaseImageName(stage, opts)
+	if err != nil {
+		return diffBase{}, err
+	}
+	return diffBase{name: name, image: sb.baseImage}, nil
+}
+
+// foreignLayer is a layer of the base that is listed in the manifest, but not
+// pushed along with the image.
+type foreignLayer struct {
+	v1.Layer
+	mediaType types.MediaType
+}
+
+func (l *foreignLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// nonDistributable returns the non-distributable media type of a layer of media type mt.
+func nonDistributable(mt types.MediaType) (types.MediaType, bool) {
+	switch mt {
+	case types.DockerLayer:
+		return types.DockerForeignLayer, true
+	case types.OCILayer:
+		return types.OCIRestrictedLayer, true
+	case types.OCIUncompressedLayer:
+		return types.OCIUncompressedRestrictedLayer, true
+	}
+	return "", false
+}
+
+// diffOnlyImage returns image with the layers of base marked as non-distributable,
+// so that only the layers kaniko added are pushed. The base layers point to the
+// registry of base with their urls and the manifest names base by digest.
+func diffOnlyImage(image v1.Image, base diffBase) (v1.Image, error) {
+	baseLayers, err := base.image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting base image layers")
+	}
+	if len(baseLayers) == 0 {
+		logrus.Info("Pushing all layers, the base image has no layers")
+		return image, nil
+	}
+	ref, err := name.ParseReference(base.name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing base image name %s", base.name)
+	}
+	baseDigest, err := base.image.Digest()
+	if err != nil {
+		return nil, err
+	}
+	inBase := map[v1.Hash]bool{}
+	for _, l := range baseLayers {
+		digest, err := l.Digest()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting base layer digest")
+		}
+		inBase[digest] = true
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layers")
+	}
+	adds := make([]mutate.Addendum, 0, len(layers))
+	skipped := 0
+	for _, l := range layers {
+		digest, err := l.Digest()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting layer digest")
+		}
+		mt, err := l.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		foreign, ok := nonDistributable(mt)
+		if !inBase[digest] || !ok {
+			adds = append(adds, mutate.Addendum{Layer: l})
+			continue
+		}
+		skipped++
+		url := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", ref.Context().Scheme(), ref.Context().RegistryStr(), ref.Context().RepositoryStr(), digest)
+		adds = append(adds, mutate.Addendum{
+			Layer:     &foreignLayer{Layer: l, mediaType: foreign},
+			MediaType: foreign,
+			URLs:      []string{url},
+		})
+	}
+	logrus.Infof("Not pushing %d layers of the base image %s", skipped, base.name)
+
+	mt, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	diff, err := mutate.Append(mutate.MediaType(empty.Image, mt), adds...)
+	if err != nil {
+		return nil, err
+	}
+	diff = mutate.ConfigMediaType(diff, m.Config.MediaType)
+	if diff, err = mutate.ConfigFile(diff, cf); err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{}
+	for k, v := range m.Annotations {
+		annotations[k] = v
+	}
+	annotations[annotationBaseName] = base.name
+	annotations[annotationBaseDigest] = baseDigest.String()
+	return mutate.Annotations(diff, annotations).(v1.Image), nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_diffOnlyImage(t *testing.T) {
+	var mu sync.Mutex
+	uploaded := map[string][]string{}
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if digest := r.URL.Query().Get("digest"); r.Method == http.MethodPut && digest != "" {
+			repo, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/blobs/")
+			mu.Lock()
+			uploaded[repo] = append(uploaded[repo], digest)
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	baseRef, err := name.NewTag(host + "/base:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(baseRef, base); err != nil {
+		t.Fatal(err)
+	}
+	pulled, err := remote.Image(baseRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := mutate.AppendLayers(pulled, added)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := diffOnlyImage(image, diffBase{name: baseRef.String(), image: pulled})
+	testutil.CheckNoError(t, err)
+	opts := &config.KanikoOptions{Destinations: []string{host + "/app:latest"}}
+	testutil.CheckNoError(t, DoPush(diff, opts))
+
+	m, err := diff.Manifest()
+	testutil.CheckNoError(t, err)
+	baseManifest, err := pulled.Manifest()
+	testutil.CheckNoError(t, err)
+	baseDigest, err := pulled.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, baseRef.String(), m.Annotations[annotationBaseName])
+	testutil.CheckDeepEqual(t, baseDigest.String(), m.Annotations[annotationBaseDigest])
+	testutil.CheckDeepEqual(t, 3, len(m.Layers))
+	for i, l := range baseManifest.Layers {
+		testutil.CheckDeepEqual(t, l.Digest, m.Layers[i].Digest)
+		testutil.CheckDeepEqual(t, types.DockerForeignLayer, m.Layers[i].MediaType)
+		testutil.CheckDeepEqual(t, []string{"http://" + host + "/v2/base/blobs/" + l.Digest.String()}, m.Layers[i].URLs)
+	}
+	addedDigest, err := added.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, addedDigest, m.Layers[2].Digest)
+	testutil.CheckDeepEqual(t, types.DockerLayer, m.Layers[2].MediaType)
+
+	// only the added layer and the config are uploaded
+	configDigest, err := diff.ConfigName()
+	testutil.CheckNoError(t, err)
+	got := map[string]bool{}
+	for _, d := range uploaded["app"] {
+		got[d] = true
+	}
+	testutil.CheckDeepEqual(t, map[string]bool{addedDigest.String(): true, configDigest.String(): true}, got)
+
+	// the config is unchanged, the image has the same filesystem
+	cf, err := diff.ConfigFile()
+	testutil.CheckNoError(t, err)
+	want, err := image.ConfigFile()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, want.RootFS.DiffIDs, cf.RootFS.DiffIDs)
+}
+
+func Test_diffOnlyImage_scratch(t *testing.T) {
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := diffOnlyImage(image, diffBase{name: "scratch", image: empty.Image})
+	testutil.CheckNoError(t, err)
+	if diff != image {
+		t.Error("expected the image of a base without layers to be pushed as is")
+	}
+}
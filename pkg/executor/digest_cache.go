@@ -0,0 +1,178 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/osscontainertools/kaniko/pkg/timing"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// racyWindow is how old a file must be before its digest is cached. The mtime of a file
+// modified right after it was hashed can be the same as before, so the digest of a
+// file that was modified this recently is always recomputed.
+const racyWindow = time.Second
+
+// contextDigests caches the digests of the build context files for the cache keys
+// of a single build, it is reset by DoBuild.
+var contextDigests = newDigestCache(util.CacheHasher())
+
+type digestEntry struct {
+	mtime  time.Time
+	size   int64
+	mode   os.FileMode
+	uid    uint32
+	gid    uint32
+	digest string
+}
+
+// digestCache caches file digests keyed by path, the digest of a file is reused as
+// long as its mtime, size, mode and owner are unchanged.
+type digestCache struct {
+	hasher func(string) (string, error)
+
+	mu      sync.Mutex
+	entries map[string]digestEntry
+}
+
+func newDigestCache(hasher func(string) (string, error)) *digestCache {
+	return &digestCache{hasher: hasher, entries: map[string]digestEntry{}}
+}
+
+// Get returns the digest of the file at p.
+func (c *digestCache) Get(p string) (string, error) {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+	entry := digestEntry{mtime: fi.ModTime(), size: fi.Size(), mode: fi.Mode()}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		entry.uid, entry.gid = stat.Uid, stat.Gid
+	}
+
+	c.mu.Lock()
+	cached, ok := c.entries[p]
+	c.mu.Unlock()
+	if ok && cached.mtime.Equal(entry.mtime) && cached.size == entry.size && cached.mode == entry.mode && cached.uid == entry.uid && cached.gid == entry.gid {
+		return cached.digest, nil
+	}
+
+	now := time.Now()
+	timer := timing.Start("Hashing context files")
+	digest, err := c.hasher(p)
+	timing.DefaultRun.Stop(timer)
+	if err != nil {
+		return "", err
+	}
+	if entry.mtime.Before(now.Add(-racyWindow)) {
+		entry.digest = digest
+		c.mu.Lock()
+		c.entries[p] = entry
+		c.mu.Unlock()
+	}
+	return digest, nil
+}
+
+// Reset drops all cached digests.
+func (c *digestCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]digestEntry{}
+}
+
+// snapshot returns a copy of the cached digests.
+func (c *digestCache) snapshot() map[string]digestEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make(map[string]digestEntry, len(c.entries))
+	for p, e := range c.entries {
+		entries[p] = e
+	}
+	return entries
+}
+
+// restore adds entries to the cached digests, they are still only reused for
+// files whose mtime, size, mode and owner are unchanged.
+func (c *digestCache) restore(entries map[string]digestEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p, e := range entries {
+		c.entries[p] = e
+	}
+}
+
+// precomputeContextDigests hashes the context files the commands of the stage use in
+// parallel, before their cache keys are computed one after another. The files are
+// determined with the args and config the stage starts with, the cache keys still
+// check each cached digest against its file.
+func (s *stageBuilder) precomputeContextDigests() {
+	var paths []string
+	for _, command := range s.cmds {
+		if command == nil {
+			continue
+		}
+		files, err := filesUsedFromContext(command, &s.cf.Config, s.args)
+		if err != nil {
+			logrus.Debugf("Not precomputing digests for %s: %v", command.String(), err)
+			continue
+		}
+		paths = append(paths, files...)
+	}
+	contextDigests.precompute(paths, s.fileContext, s.opts.DigestConcurrency)
+}
+
+// precompute hashes the files at paths, and the files in the directories among them,
+// with up to concurrency files at a time. Files that fail to hash are left to Get.
+func (c *digestCache) precompute(paths []string, context util.FileContext, concurrency int) {
+	seen := map[string]bool{}
+	var files []string
+	for _, p := range paths {
+		if _, provided, err := context.ProvidedSource(p); err != nil || provided {
+			continue
+		}
+		_ = fs.WalkDir(util.FSys, p, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil || context.ExcludesFile(path) {
+				return nil
+			}
+			if !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	timer := timing.Start("Precomputing context digests")
+	defer timing.DefaultRun.Stop(timer)
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for _, f := range files {
+		g.Go(func() error {
+			if _, err := c.Get(f); err != nil {
+				logrus.Debugf("Not precomputing digest of %s: %v", f, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/commands"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_digestCache(t *testing.T) {
+	calls := 0
+	c := newDigestCache(func(p string) (string, error) {
+		calls++
+		return util.CacheHasher()(p)
+	})
+	path := filepath.Join(t.TempDir(), "file")
+	writeFile := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+
+	writeFile("meow", past)
+	first, err := c.Get(path)
+	testutil.CheckNoError(t, err)
+	second, err := c.Get(path)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, first, second)
+	testutil.CheckDeepEqual(t, 1, calls)
+
+	// same size, but a different mtime invalidates the digest
+	writeFile("woof", past.Add(time.Minute))
+	changed, err := c.Get(path)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, calls)
+	if changed == first {
+		t.Errorf("expected digest to change after the file was modified, got %s", changed)
+	}
+
+	// files modified just now are always rehashed
+	writeFile("purr", time.Now())
+	for range 2 {
+		_, err := c.Get(path)
+		testutil.CheckNoError(t, err)
+	}
+	testutil.CheckDeepEqual(t, 4, calls)
+
+	c.Reset()
+	writeFile("hiss", past)
+	for range 2 {
+		_, err := c.Get(path)
+		testutil.CheckNoError(t, err)
+	}
+	testutil.CheckDeepEqual(t, 5, calls)
+}
+
+func BenchmarkCompositeCache_AddPath(b *testing.B) {
+	dir := b.TempDir()
+	past := time.Now().Add(-time.Hour)
+	content := make([]byte, 64*1024)
+	for i := range 200 {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.Chtimes(path, past, past); err != nil {
+			b.Fatal(err)
+		}
+	}
+	context := util.FileContext{Root: dir}
+
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached %t", cached), func(b *testing.B) {
+			contextDigests.Reset()
+			for b.Loop() {
+				if !cached {
+					contextDigests.Reset()
+				}
+				if err := NewCompositeCache().AddPath(dir, context); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// setupDigestContext writes files nested in dirs, old enough for their digests to be cached.
+func setupDigestContext(t testing.TB, dirs, files int) string {
+	root := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	content := make([]byte, 64*1024)
+	for d := range dirs {
+		for f := range files {
+			path := filepath.Join(root, fmt.Sprintf("dir%d", d), fmt.Sprintf("file%d", f))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			content[0] = byte(d*files + f)
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, past, past); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Chtimes(filepath.Join(root, fmt.Sprintf("dir%d", d)), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func Test_digestCache_precompute(t *testing.T) {
+	root := setupDigestContext(t, 4, 10)
+	context := util.FileContext{Root: root, ExcludedFiles: []string{"dir1/file3"}}
+	// the sources of the commands of a stage, overlapping
+	sources := [][]string{
+		{filepath.Join(root, "dir0")},
+		{filepath.Join(root, "dir1"), filepath.Join(root, "dir2", "file5")},
+		{filepath.Join(root, "dir2")},
+		{filepath.Join(root, "dir3", "file0"), filepath.Join(root, "dir3", "file1")},
+	}
+
+	var calls atomic.Int32
+	original := contextDigests
+	defer func() { contextDigests = original }()
+	contextDigests = newDigestCache(func(p string) (string, error) {
+		calls.Add(1)
+		return util.CacheHasher()(p)
+	})
+	keys := func() []string {
+		var keys []string
+		for _, paths := range sources {
+			key := NewCompositeCache()
+			for _, p := range paths {
+				testutil.CheckNoError(t, key.AddPath(p, context))
+			}
+			hash, err := key.Hash()
+			testutil.CheckNoError(t, err)
+			keys = append(keys, hash)
+		}
+		return keys
+	}
+
+	serial := keys()
+
+	contextDigests.Reset()
+	var all []string
+	for _, paths := range sources {
+		all = append(all, paths...)
+	}
+	contextDigests.precompute(all, context, 8)
+	precomputed := calls.Load()
+	// the cache keys are the same, and find all digests precomputed
+	testutil.CheckDeepEqual(t, serial, keys())
+	testutil.CheckDeepEqual(t, precomputed, calls.Load())
+}
+
+func Test_stageBuilder_optimize_precomputedDigests(t *testing.T) {
+	root := setupDigestContext(t, 3, 10)
+	var calls atomic.Int32
+	original := contextDigests
+	defer func() { contextDigests = original }()
+	contextDigests = newDigestCache(func(p string) (string, error) {
+		calls.Add(1)
+		return util.CacheHasher()(p)
+	})
+
+	cf := &v1.ConfigFile{}
+	sb := &stageBuilder{
+		opts:        &config.KanikoOptions{Cache: true, DigestConcurrency: 4},
+		cf:          cf,
+		snapshotter: &fakeSnapShotter{},
+		layerCache:  &fakeLayerCache{},
+		args:        dockerfile.NewBuildArgs([]string{}),
+		fileContext: util.FileContext{Root: root},
+		cmds: []commands.DockerCommand{
+			MockDockerCommand{command: "COPY dir0 dir1 /", contextFiles: []string{filepath.Join(root, "dir0"), filepath.Join(root, "dir1")}},
+			MockDockerCommand{command: "COPY dir2 /", contextFiles: []string{filepath.Join(root, "dir2")}},
+		},
+	}
+	sb.precomputeContextDigests()
+	precomputed := calls.Load()
+	// the directories and their files
+	testutil.CheckDeepEqual(t, int32(33), precomputed)
+	// the cache keys of optimize hash no file again
+	testutil.CheckNoError(t, sb.optimize(CompositeCache{}, cf.Config))
+	testutil.CheckDeepEqual(t, precomputed, calls.Load())
+}
+
+func BenchmarkDigestCache_precompute(b *testing.B) {
+	root := setupDigestContext(b, 8, 50)
+	context := util.FileContext{Root: root}
+	for _, concurrency := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency %d", concurrency), func(b *testing.B) {
+			for b.Loop() {
+				c := newDigestCache(util.CacheHasher())
+				c.precompute([]string{root}, context, concurrency)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/image/remote"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// imageFile is the state of a path of the filesystem of an image that is compared
+// for drift, timestamps are left out as they change with every build.
+type imageFile struct {
+	typeflag byte
+	mode     int64
+	uid, gid int
+	linkname string
+	digest   string
+}
+
+// driftReport lists how the filesystem and config of an image differ from those of
+// a reference image, see --drift-check.
+type driftReport struct {
+	added   []string
+	removed []string
+	changed []string
+	config  []string
+}
+
+func (r driftReport) empty() bool {
+	return len(r.added) == 0 && len(r.removed) == 0 && len(r.changed) == 0 && len(r.config) == 0
+}
+
+func (r driftReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d added, %d removed, %d changed files and %d changed config fields", len(r.added), len(r.removed), len(r.changed), len(r.config))
+	for _, p := range r.added {
+		fmt.Fprintf(&b, "\n  A %s", p)
+	}
+	for _, p := range r.removed {
+		fmt.Fprintf(&b, "\n  D %s", p)
+	}
+	for _, p := range r.changed {
+		fmt.Fprintf(&b, "\n  M %s", p)
+	}
+	for _, c := range r.config {
+		fmt.Fprintf(&b, "\n  config %s", c)
+	}
+	return b.String()
+}
+
+// checkDrift returns an error with the drift report of image if it differs from the
+// image of --drift-check.
+func checkDrift(image v1.Image, opts *config.KanikoOptions) error {
+	reference, err := remote.RetrieveRemoteImage(opts.DriftCheckImage, opts.RegistryOptions, opts.CustomPlatform)
+	if err != nil {
+		return errors.Wrapf(err, "retrieving drift check image %s", opts.DriftCheckImage)
+	}
+	report, err := diffImages(reference, image, opts.DriftIgnore)
+	if err != nil {
+		return errors.Wrapf(err, "comparing the image with %s", opts.DriftCheckImage)
+	}
+	if report.empty() {
+		logrus.Infof("Image has not drifted from %s", opts.DriftCheckImage)
+		return nil
+	}
+	return util.UserError(fmt.Errorf("image drifted from %s: %s", opts.DriftCheckImage, report))
+}
+
+// diffImages returns how the filesystem and config of image differ from those of
+// reference. Paths matching a pattern of ignore, or below a directory matching
+// one, and config fields named by ignore are left out.
+func diffImages(reference, image v1.Image, ignore []string) (driftReport, error) {
+	var report driftReport
+	want, err := imageFiles(reference)
+	if err != nil {
+		return report, errors.Wrap(err, "reading the files of the reference image")
+	}
+	got, err := imageFiles(image)
+	if err != nil {
+		return report, errors.Wrap(err, "reading the files of the image")
+	}
+	for p, g := range got {
+		if driftIgnored(p, ignore) {
+			continue
+		}
+		w, ok := want[p]
+		if !ok {
+			report.added = append(report.added, p)
+		} else if changes := fileChanges(w, g); len(changes) > 0 {
+			report.changed = append(report.changed, fmt.Sprintf("%s (%s)", p, strings.Join(changes, ", ")))
+		}
+	}
+	for p := range want {
+		if _, ok := got[p]; !ok && !driftIgnored(p, ignore) {
+			report.removed = append(report.removed, p)
+		}
+	}
+	sort.Strings(report.added)
+	sort.Strings(report.removed)
+	sort.Strings(report.changed)
+
+	wantCf, err := reference.ConfigFile()
+	if err != nil {
+		return report, err
+	}
+	gotCf, err := image.ConfigFile()
+	if err != nil {
+		return report, err
+	}
+	for _, f := range configFields(wantCf, gotCf) {
+		if slices.Contains(ignore, f.name) || configFieldEqual(f.want, f.got) {
+			continue
+		}
+		report.config = append(report.config, fmt.Sprintf("%s: %v -> %v", f.name, f.want, f.got))
+	}
+	return report, nil
+}
+
+// imageFiles returns the state of each path of the flattened filesystem of image.
+func imageFiles(image v1.Image) (map[string]imageFile, error) {
+	rc := mutate.Extract(image)
+	defer rc.Close()
+	files := map[string]imageFile{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := imageFile{typeflag: hdr.Typeflag, mode: hdr.Mode, uid: hdr.Uid, gid: hdr.Gid, linkname: hdr.Linkname}
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, err
+			}
+			f.digest = hex.EncodeToString(h.Sum(nil))
+		}
+		files[filepath.Join("/", hdr.Name)] = f
+	}
+}
+
+// fileChanges names what differs between the states want and got of a path.
+func fileChanges(want, got imageFile) []string {
+	var changes []string
+	if want.typeflag != got.typeflag {
+		changes = append(changes, "type")
+	}
+	if want.digest != got.digest {
+		changes = append(changes, "content")
+	}
+	if want.linkname != got.linkname {
+		changes = append(changes, "link")
+	}
+	if want.mode != got.mode {
+		changes = append(changes, fmt.Sprintf("mode %o -> %o", want.mode, got.mode))
+	}
+	if want.uid != got.uid || want.gid != got.gid {
+		changes = append(changes, fmt.Sprintf("owner %d:%d -> %d:%d", want.uid, want.gid, got.uid, got.gid))
+	}
+	return changes
+}
+
+// driftIgnored returns true if p, or a directory above it, matches a path pattern of ignore.
+func driftIgnored(p string, ignore []string) bool {
+	for _, pattern := range ignore {
+		if !strings.HasPrefix(pattern, "/") {
+			continue
+		}
+		for dir := p; dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configFieldEqual returns true if want and got are equal, empty and unset lists and
+// maps are equal.
+func configFieldEqual(want, got any) bool {
+	w, g := reflect.ValueOf(want), reflect.ValueOf(got)
+	switch w.Kind() {
+	case reflect.Slice, reflect.Map:
+		if w.Len() == 0 && g.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+type configField struct {
+	name      string
+	want, got any
+}
+
+// configFields returns the fields of the configs that are compared for drift, the
+// creation time and the history change with every build and are left out.
+func configFields(want, got *v1.ConfigFile) []configField {
+	return []configField{
+		{"Architecture", want.Architecture, got.Architecture},
+		{"OS", want.OS, got.OS},
+		{"Variant", want.Variant, got.Variant},
+		{"User", want.Config.User, got.Config.User},
+		{"Env", want.Config.Env, got.Config.Env},
+		{"Entrypoint", want.Config.Entrypoint, got.Config.Entrypoint},
+		{"Cmd", want.Config.Cmd, got.Config.Cmd},
+		{"WorkingDir", want.Config.WorkingDir, got.Config.WorkingDir},
+		{"Labels", want.Config.Labels, got.Config.Labels},
+		{"ExposedPorts", want.Config.ExposedPorts, got.Config.ExposedPorts},
+		{"Volumes", want.Config.Volumes, got.Config.Volumes},
+		{"StopSignal", want.Config.StopSignal, got.Config.StopSignal},
+		{"Shell", want.Config.Shell, got.Config.Shell},
+		{"Healthcheck", want.Config.Healthcheck, got.Config.Healthcheck},
+		{"OnBuild", want.Config.OnBuild, got.Config.OnBuild},
+	}
+}
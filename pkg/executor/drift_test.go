@@ -0,0 +1,86 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_DriftCheck(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref, err := name.NewTag(strings.TrimPrefix(server.URL, "http://") + "/app:reference")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(t *testing.T, dockerFile string, driftCheck string) error {
+		t.Helper()
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		opts := &config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			DriftCheckImage: driftCheck,
+			DriftIgnore:     []string{"/ignored"},
+		}
+		image, err := DoBuild(opts)
+		if err == nil && driftCheck == "" {
+			err = remote.Write(ref, image)
+		}
+		return err
+	}
+	dockerFile := `
+FROM scratch
+ENV FOO=bar
+COPY foo/bam.txt copied/`
+	testutil.CheckNoError(t, build(t, dockerFile, ""))
+
+	// a rebuild only differs by its timestamps
+	testutil.CheckNoError(t, build(t, dockerFile, ref.String()))
+	// as do builds with changes to ignored paths
+	testutil.CheckNoError(t, build(t, dockerFile+"\nCOPY exec ignored/", ref.String()))
+
+	err = build(t, dockerFile+"\nCOPY exec copied/", ref.String())
+	testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+	if !strings.Contains(err.Error(), "1 added, 0 removed, 0 changed files and 0 changed config fields\n  A /copied/exec") {
+		t.Errorf("expected the drift report to list the added file, got %v", err)
+	}
+
+	err = build(t, strings.Replace(dockerFile, "FOO=bar", "FOO=baz", 1), ref.String())
+	if !strings.Contains(err.Error(), "0 added, 0 removed, 0 changed files and 1 changed config fields\n  config Env: ") || !strings.HasSuffix(err.Error(), "FOO=baz]") {
+		t.Errorf("expected the drift report to list the changed env, got %v", err)
+	}
+}
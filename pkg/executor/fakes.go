@@ -38,6 +38,9 @@ func (f *fakeSnapShotter) Init() error {
 	f.initialized = true
 	return nil
 }
+func (f *fakeSnapShotter) Paths() map[string]struct{} {
+	return map[string]struct{}{}
+}
 func (f *fakeSnapShotter) TakeSnapshotFS() (string, error) {
 	return f.tarPath, nil
 }
@@ -0,0 +1,148 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/moby/go-archive"
+	"github.com/osscontainertools/kaniko/pkg/commands"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// fileOrigin is the entry of a path of the final image in the file provenance,
+// see --file-provenance.
+type fileOrigin struct {
+	Stage       int    `json:"stage"`
+	Command     int    `json:"command"`
+	Instruction string `json:"instruction"`
+	From        string `json:"from,omitempty"`
+	Source      string `json:"source,omitempty"`
+}
+
+// fileProvenance collects the command that last produced each path of the final stage.
+type fileProvenance struct {
+	mu    sync.Mutex
+	files map[string]fileOrigin
+}
+
+// fileOrigins is the file provenance of the current build.
+var fileOrigins = &fileProvenance{}
+
+func (p *fileProvenance) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files = map[string]fileOrigin{}
+}
+
+// copiedSources is implemented by commands that know the source each file they
+// copied came from, the COPY commands.
+type copiedSources interface {
+	Sources() map[string]string
+	From() string
+}
+
+// addFiles records the files command, the command at index of stage, produced.
+// The files of a COPY, cached or not, are recorded with the source they were copied from.
+func (p *fileProvenance) addFiles(stage, index int, command commands.DockerCommand, files []string) {
+	var sources map[string]string
+	var from string
+	if c, ok := command.(copiedSources); ok {
+		sources, from = c.Sources(), c.From()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range files {
+		origin := fileOrigin{Stage: stage, Command: index, Instruction: command.String()}
+		if src, ok := sources[f]; ok {
+			origin.From, origin.Source = from, src
+		}
+		p.files[imagePath(f)] = origin
+	}
+}
+
+// addLayer records the paths the layer tar opened with open adds or modifies for
+// command, the command at index of stage, and forgets the paths it deletes.
+func (p *fileProvenance) addLayer(stage, index int, command commands.DockerCommand, open func() (io.ReadCloser, error)) error {
+	rc, err := open()
+	if err != nil {
+		return errors.Wrapf(err, "reading layer of %s", command.String())
+	}
+	defer rc.Close()
+	origin := fileOrigin{Stage: stage, Command: index, Instruction: command.String()}
+	tr := tar.NewReader(rc)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join("/", hdr.Name)
+		dir, base := filepath.Split(path)
+		if strings.HasPrefix(base, archive.WhiteoutPrefix) {
+			deleted := filepath.Join(dir, strings.TrimPrefix(base, archive.WhiteoutPrefix))
+			for f := range p.files {
+				if f == deleted || strings.HasPrefix(f, deleted+"/") {
+					delete(p.files, f)
+				}
+			}
+			continue
+		}
+		p.files[path] = origin
+	}
+}
+
+// writeFile writes the file provenance to path as JSON.
+func (p *fileProvenance) writeFile(path string) error {
+	p.mu.Lock()
+	files := p.files
+	p.mu.Unlock()
+	if files == nil {
+		files = map[string]fileOrigin{}
+	}
+	b, err := json.MarshalIndent(struct {
+		Files map[string]fileOrigin `json:"files"`
+	}{files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing file provenance")
+	}
+	return nil
+}
+
+// imagePath returns the path in the image of the file at path in the filesystem
+// of the build.
+func imagePath(path string) string {
+	rel, err := filepath.Rel(config.RootDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Clean(path)
+	}
+	return filepath.Join("/", rel)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osscontainertools/kaniko/pkg/cache"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_FileProvenance(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+ENV FOO=bar
+COPY foo/bam.txt copied/
+COPY foo copied/foo/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	provenancePath := filepath.Join(t.TempDir(), "provenance.json")
+	opts := &config.KanikoOptions{
+		DockerfilePath:     filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:         filepath.Join(testDir, "workspace"),
+		SnapshotMode:       constants.SnapshotModeFull,
+		FileProvenancePath: provenancePath,
+	}
+	_, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	b, err := os.ReadFile(provenancePath)
+	testutil.CheckNoError(t, err)
+	var provenance struct {
+		Files map[string]fileOrigin `json:"files"`
+	}
+	testutil.CheckNoError(t, json.Unmarshal(b, &provenance))
+	testutil.CheckDeepEqual(t, fileOrigin{
+		Command:     1,
+		Instruction: "COPY foo/bam.txt copied/",
+		Source:      "foo/bam.txt",
+	}, provenance.Files["/copied/bam.txt"])
+	testutil.CheckDeepEqual(t, fileOrigin{
+		Command:     2,
+		Instruction: "COPY foo copied/foo/",
+		Source:      "foo/bam.txt",
+	}, provenance.Files["/copied/foo/bam.txt"])
+}
+
+func TestDoBuild_FileProvenance_cached(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt copied/
+COPY foo copied/foo/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	build := func() map[string]fileOrigin {
+		provenancePath := filepath.Join(t.TempDir(), "provenance.json")
+		opts := &config.KanikoOptions{
+			DockerfilePath:     filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:         filepath.Join(testDir, "workspace"),
+			SnapshotMode:       constants.SnapshotModeFull,
+			FileProvenancePath: provenancePath,
+			Cache:              true,
+			CacheCopyLayers:    true,
+			CacheRepo:          "oci:" + filepath.Join(cacheDir, "repo"),
+			CacheOptions:       config.CacheOptions{CacheDir: cacheDir, CacheTTL: time.Hour, CacheBackend: cache.DirBackend},
+		}
+		_, err := DoBuild(opts)
+		testutil.CheckNoError(t, err)
+
+		b, err := os.ReadFile(provenancePath)
+		testutil.CheckNoError(t, err)
+		var provenance struct {
+			Files map[string]fileOrigin `json:"files"`
+		}
+		testutil.CheckNoError(t, json.Unmarshal(b, &provenance))
+		return provenance.Files
+	}
+
+	build()
+	// the second build extracts the layers of both COPY commands from the cache
+	cached := build()
+	testutil.CheckDeepEqual(t, fileOrigin{
+		Command:     0,
+		Instruction: "COPY foo/bam.txt copied/",
+		Source:      "foo/bam.txt",
+	}, cached["/copied/bam.txt"])
+	testutil.CheckDeepEqual(t, fileOrigin{
+		Command:     1,
+		Instruction: "COPY foo copied/foo/",
+		Source:      "foo/bam.txt",
+	}, cached["/copied/foo/bam.txt"])
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// formatVendor returns the media type vendor prefix of format.
+func formatVendor(format config.ImageFormat) string {
+	if format == config.DockerFormat {
+		return types.DockerVendorPrefix
+	}
+	return types.OCIVendorPrefix
+}
+
+// formatMediaTypes returns the manifest and config media types of format.
+func formatMediaTypes(format config.ImageFormat) (types.MediaType, types.MediaType) {
+	if format == config.DockerFormat {
+		return types.DockerManifestSchema2, types.DockerConfigJSON
+	}
+	return types.OCIManifestSchema1, types.OCIConfigJSON
+}
+
+// convertImageFormat returns image with the manifest, config and layer media types of format.
+// Layers only change their media type, except for zstd compressed layers which have no
+// docker equivalent and are recompressed with gzip. An empty format keeps the image as is.
+func convertImageFormat(image v1.Image, format config.ImageFormat) (v1.Image, error) {
+	if format == "" {
+		return image, nil
+	}
+	mt, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	if extractMediaTypeVendor(mt) == formatVendor(format) {
+		return image, nil
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layers")
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting config file")
+	}
+	addenda := make([]mutate.Addendum, 0, len(layers))
+	for _, l := range layers {
+		layerMediaType, err := l.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if layerMediaType == types.OCILayerZStd && format == config.DockerFormat {
+			l, err = tarball.LayerFromOpener(l.Uncompressed, tarball.WithMediaType(types.DockerLayer))
+			if err != nil {
+				return nil, errors.Wrap(err, "recompressing zstd layer")
+			}
+			addenda = append(addenda, mutate.Addendum{Layer: l})
+			continue
+		}
+		targetMediaType := layerMediaType
+		if extractMediaTypeVendor(layerMediaType) != formatVendor(format) {
+			targetMediaType = convertMediaType(layerMediaType)
+		}
+		if targetMediaType == "" {
+			return nil, fmt.Errorf("layer with media type %v cannot be converted to the %s format", layerMediaType, format)
+		}
+		addenda = append(addenda, mutate.Addendum{Layer: l, MediaType: targetMediaType})
+	}
+
+	manifestMediaType, configMediaType := formatMediaTypes(format)
+	converted, err := mutate.Append(mutate.MediaType(empty.Image, manifestMediaType), addenda...)
+	if err != nil {
+		return nil, err
+	}
+	converted = mutate.ConfigMediaType(converted, configMediaType)
+	return mutate.ConfigFile(converted, cf.DeepCopy())
+}
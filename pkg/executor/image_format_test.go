@@ -0,0 +1,141 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func checkMediaTypes(t *testing.T, image v1.Image, manifest, cfg, layer types.MediaType) {
+	t.Helper()
+	m, err := image.Manifest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, manifest, m.MediaType)
+	testutil.CheckDeepEqual(t, cfg, m.Config.MediaType)
+	for _, l := range m.Layers {
+		testutil.CheckDeepEqual(t, layer, l.MediaType)
+	}
+}
+
+func TestDoBuild_ImageFormat(t *testing.T) {
+	for _, tc := range []struct {
+		format   config.ImageFormat
+		manifest types.MediaType
+		config   types.MediaType
+		layer    types.MediaType
+	}{
+		{format: "", manifest: types.DockerManifestSchema2, config: types.DockerConfigJSON, layer: types.DockerLayer},
+		{format: config.OCIFormat, manifest: types.OCIManifestSchema1, config: types.OCIConfigJSON, layer: types.OCILayer},
+		{format: config.DockerFormat, manifest: types.DockerManifestSchema2, config: types.DockerConfigJSON, layer: types.DockerLayer},
+	} {
+		t.Run("format "+string(tc.format), func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+			dockerFile := `
+FROM scratch
+COPY foo/bam.txt copied/`
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:     filepath.Join(testDir, "workspace"),
+				SnapshotMode:   constants.SnapshotModeFull,
+				ImageFormat:    tc.format,
+			}
+			image, err := DoBuild(opts)
+			testutil.CheckNoError(t, err)
+			checkMediaTypes(t, image, tc.manifest, tc.config, tc.layer)
+		})
+	}
+}
+
+func TestConvertImageFormat(t *testing.T) {
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oci, err := convertImageFormat(image, config.OCIFormat)
+	testutil.CheckNoError(t, err)
+	checkMediaTypes(t, oci, types.OCIManifestSchema1, types.OCIConfigJSON, types.OCILayer)
+	// the layers are relabeled, not recompressed
+	m, err := oci.Manifest()
+	testutil.CheckNoError(t, err)
+	for i, l := range layers {
+		digest, err := l.Digest()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, digest, m.Layers[i].Digest)
+	}
+
+	docker, err := convertImageFormat(oci, config.DockerFormat)
+	testutil.CheckNoError(t, err)
+	checkMediaTypes(t, docker, types.DockerManifestSchema2, types.DockerConfigJSON, types.DockerLayer)
+	expected, err := image.Digest()
+	testutil.CheckNoError(t, err)
+	got, err := docker.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, expected, got)
+
+	unchanged, err := convertImageFormat(image, "")
+	testutil.CheckNoError(t, err)
+	if unchanged != image {
+		t.Fatal("expected the image to be kept without a format")
+	}
+}
+
+func TestConvertImageFormat_ZStd(t *testing.T) {
+	base, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstdLayer, err := tarball.LayerFromOpener(base.Uncompressed, tarball.WithCompression("zstd"), tarball.WithMediaType(types.OCILayerZStd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := mutate.AppendLayers(mutate.MediaType(empty.Image, types.OCIManifestSchema1), zstdLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docker, err := convertImageFormat(image, config.DockerFormat)
+	testutil.CheckNoError(t, err)
+	checkMediaTypes(t, docker, types.DockerManifestSchema2, types.DockerConfigJSON, types.DockerLayer)
+	layers, err := docker.Layers()
+	testutil.CheckNoError(t, err)
+	expected, err := zstdLayer.DiffID()
+	testutil.CheckNoError(t, err)
+	got, err := layers[0].DiffID()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, expected, got)
+}
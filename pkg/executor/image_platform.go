@@ -0,0 +1,71 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/config"
+)
+
+// knownImageOS and knownImageArch are the values of GOOS and GOARCH, which the
+// OCI image spec uses for the os and architecture of an image.
+var (
+	knownImageOS = []string{
+		"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "js",
+		"linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows",
+	}
+	knownImageArch = []string{
+		"386", "amd64", "arm", "arm64", "loong64", "mips", "mips64", "mips64le",
+		"mipsle", "ppc64", "ppc64le", "riscv64", "s390x", "wasm",
+	}
+)
+
+// ValidateImagePlatform returns an error if --image-os or --image-arch is not a known value.
+func ValidateImagePlatform(opts *config.KanikoOptions) error {
+	if err := validatePlatformValue("--image-os", opts.ImageOS, knownImageOS); err != nil {
+		return err
+	}
+	return validatePlatformValue("--image-arch", opts.ImageArch, knownImageArch)
+}
+
+func validatePlatformValue(flag, value string, known []string) error {
+	if value == "" {
+		return nil
+	}
+	i := sort.SearchStrings(known, value)
+	if i < len(known) && known[i] == value {
+		return nil
+	}
+	return fmt.Errorf("%s must be one of %s, got %q", flag, strings.Join(known, ", "), value)
+}
+
+// overrideImagePlatform sets the os and architecture of the final image to
+// --image-os and --image-arch, where they are set, instead of those of the build.
+func overrideImagePlatform(configFile *v1.ConfigFile, opts *config.KanikoOptions) {
+	if opts.ImageOS != "" {
+		configFile.OS = opts.ImageOS
+	}
+	if opts.ImageArch != "" && opts.ImageArch != configFile.Architecture {
+		configFile.Architecture = opts.ImageArch
+		// the variant of the build architecture doesn't apply to another one
+		configFile.Variant = ""
+	}
+}
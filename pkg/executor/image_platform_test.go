@@ -0,0 +1,96 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestValidateImagePlatform(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		os      string
+		arch    string
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "known values", os: "linux", arch: "arm64"},
+		{name: "unknown os", os: "linus", wantErr: true},
+		{name: "unknown arch", arch: "aarch64", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateImagePlatform(&config.KanikoOptions{ImageOS: tc.os, ImageArch: tc.arch})
+			testutil.CheckError(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestOverrideImagePlatformPushed(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	destination := strings.TrimPrefix(server.URL, "http://") + "/scratch:latest"
+
+	opts := &config.KanikoOptions{
+		Destinations: []string{destination},
+		ImageOS:      "linux",
+		ImageArch:    "riscv64",
+	}
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile.OS = "linux"
+	configFile.Architecture = "arm"
+	configFile.Variant = "v7"
+	overrideImagePlatform(configFile, opts)
+	image, err = mutate.ConfigFile(image, configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckNoError(t, DoPush(image, opts))
+
+	ref, err := name.NewTag(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := remote.Image(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushedConfig, err := pushed.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, v1.Platform{OS: "linux", Architecture: "riscv64"}, *pushedConfig.Platform())
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-units"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// largestLayersReported is how many of the largest layers an image over its size
+// budget is reported with.
+const largestLayersReported = 5
+
+// imageLayerSize is the compressed size of a layer of an image and the command
+// that created it.
+type imageLayerSize struct {
+	createdBy string
+	size      int64
+}
+
+// checkImageSize returns an error listing the largest layers of image if their
+// compressed sizes add up to more than maxSize, see --max-image-size.
+func checkImageSize(image v1.Image, maxSize string) error {
+	limit, err := units.RAMInBytes(maxSize)
+	if err != nil || limit <= 0 {
+		return fmt.Errorf("--max-image-size must be a positive size, got %q", maxSize)
+	}
+	layers, err := imageLayerSizes(image)
+	if err != nil {
+		return errors.Wrap(err, "getting the size of the image")
+	}
+	var total int64
+	for _, l := range layers {
+		total += l.size
+	}
+	if total <= limit {
+		logrus.Infof("Image is %s, within --max-image-size=%s", units.HumanSize(float64(total)), maxSize)
+		return nil
+	}
+
+	sort.SliceStable(layers, func(i, j int) bool { return layers[i].size > layers[j].size })
+	if len(layers) > largestLayersReported {
+		layers = layers[:largestLayersReported]
+	}
+	var report strings.Builder
+	for _, l := range layers {
+		createdBy := l.createdBy
+		if createdBy == "" {
+			createdBy = "<unknown>"
+		}
+		fmt.Fprintf(&report, "\n  %10s  %s", units.HumanSize(float64(l.size)), createdBy)
+	}
+	return util.UserError(errors.Errorf("image is %s, more than --max-image-size=%s allows, its largest layers are:%s", units.HumanSize(float64(total)), maxSize, report.String()))
+}
+
+// imageLayerSizes returns the compressed size of each layer of image with the
+// command of its history entry, if the history matches the layers.
+func imageLayerSizes(image v1.Image) ([]imageLayerSize, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	// history entries that are not empty layers belong to the layers in order
+	var createdBy []string
+	for _, h := range cf.History {
+		if !h.EmptyLayer {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+	if len(createdBy) != len(layers) {
+		createdBy = make([]string, len(layers))
+	}
+	sizes := make([]imageLayerSize, 0, len(layers))
+	for i, l := range layers {
+		size, err := l.Size()
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, imageLayerSize{createdBy: createdBy[i], size: size})
+	}
+	return sizes, nil
+}
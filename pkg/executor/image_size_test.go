@@ -0,0 +1,92 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_checkImageSize(t *testing.T) {
+	image := empty.Image
+	for i, size := range []int{100, 700, 50, 300, 10, 20, 400} {
+		var err error
+		image, err = mutate.Append(image, mutate.Addendum{
+			Layer:   static.NewLayer([]byte(strings.Repeat("x", size)), types.OCILayer),
+			History: v1.History{CreatedBy: fmt.Sprintf("RUN step %d", i)},
+		})
+		testutil.CheckNoError(t, err)
+	}
+
+	testutil.CheckNoError(t, checkImageSize(image, "2KB"))
+	err := checkImageSize(image, "1KB")
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+	// the largest layers are listed first, the smallest are left out
+	var listed []string
+	for _, line := range strings.Split(err.Error(), "\n")[1:] {
+		fields := strings.Fields(line)
+		listed = append(listed, strings.Join(fields[len(fields)-3:], " "))
+	}
+	testutil.CheckDeepEqual(t, []string{"RUN step 1", "RUN step 6", "RUN step 3", "RUN step 0", "RUN step 2"}, listed)
+
+	testutil.CheckError(t, true, checkImageSize(image, "-1"))
+}
+
+func TestDoBuild_MaxImageSize(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt first/
+ENV EMPTY=layer
+COPY exec second/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		MaxImageSize:   "10B",
+	}
+	_, err := DoBuild(opts)
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+	for _, cmd := range []string{"COPY foo/bam.txt first/", "COPY exec second/"} {
+		if !strings.Contains(err.Error(), cmd) {
+			t.Errorf("expected %s among the largest layers, got %v", cmd, err)
+		}
+	}
+
+	opts.MaxImageSize = "10MB"
+	_, err = DoBuild(opts)
+	testutil.CheckNoError(t, err)
+}
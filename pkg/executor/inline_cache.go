@@ -0,0 +1,60 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// addInlineCache annotates the manifest of image with the digests of its layers by
+// cache key, keys are the cache keys by history index. Layers are looked up after
+// all changes to the image, so that the digests are the ones that are pushed.
+func addInlineCache(image v1.Image, annotation string, keys map[int]string) (v1.Image, error) {
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	// the layers of the build are the last ones, walking backwards keeps them aligned
+	// with their history even if the history of the base image is incomplete
+	digests := map[string]v1.Hash{}
+	layer := len(layers) - 1
+	for i := len(cf.History) - 1; i >= 0 && layer >= 0; i-- {
+		if cf.History[i].EmptyLayer {
+			continue
+		}
+		if ck, ok := keys[i]; ok {
+			d, err := layers[layer].Digest()
+			if err != nil {
+				return nil, err
+			}
+			digests[ck] = d
+		}
+		layer--
+	}
+	b, err := json.Marshal(digests)
+	if err != nil {
+		return nil, err
+	}
+	return mutate.Annotations(image, map[string]string{annotation: string(b)}).(v1.Image), nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/moby/go-archive"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/sirupsen/logrus"
+)
+
+// maxLayerDiffPaths limits the paths logged for a layer, the largest ones are logged.
+const maxLayerDiffPaths = 50
+
+type layerChangeKind string
+
+const (
+	layerChangeAdded    layerChangeKind = "A"
+	layerChangeModified layerChangeKind = "M"
+	layerChangeDeleted  layerChangeKind = "D"
+)
+
+type layerChange struct {
+	kind layerChangeKind
+	path string
+	size int64
+}
+
+// diffLayer returns the paths the layer tar read from r adds, modifies and deletes.
+// A path is modified if it is in before, the paths of the filesystem prior to the layer.
+// Directories are only returned when added, as the parents of every path are part of a layer.
+func diffLayer(r io.Reader, before map[string]struct{}) ([]layerChange, error) {
+	var changes []layerChange
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join("/", hdr.Name)
+		dir, base := filepath.Split(path)
+		if strings.HasPrefix(base, archive.WhiteoutPrefix) {
+			changes = append(changes, layerChange{
+				kind: layerChangeDeleted,
+				path: filepath.Join(dir, strings.TrimPrefix(base, archive.WhiteoutPrefix)),
+			})
+			continue
+		}
+		_, existed := before[filepath.Join(config.RootDir, path)]
+		switch {
+		case !existed:
+			changes = append(changes, layerChange{kind: layerChangeAdded, path: path, size: hdr.Size})
+		case hdr.Typeflag != tar.TypeDir:
+			changes = append(changes, layerChange{kind: layerChangeModified, path: path, size: hdr.Size})
+		}
+	}
+	return changes, nil
+}
+
+// printLayerDiff logs the changes of the layer of createdBy, failing to read the layer
+// only prevents the diff from being logged.
+func printLayerDiff(createdBy string, open func() (io.ReadCloser, error), before map[string]struct{}) {
+	rc, err := open()
+	if err != nil {
+		logrus.Warnf("Failed to read layer of %s for its diff: %v", createdBy, err)
+		return
+	}
+	defer rc.Close()
+	changes, err := diffLayer(rc, before)
+	if err != nil {
+		logrus.Warnf("Failed to read layer of %s for its diff: %v", createdBy, err)
+		return
+	}
+	logLayerDiff(createdBy, changes)
+}
+
+// logLayerDiff logs the changes of the layer of createdBy, for huge layers only the largest paths.
+func logLayerDiff(createdBy string, changes []layerChange) {
+	counts := map[layerChangeKind]int{}
+	var size int64
+	for _, c := range changes {
+		counts[c.kind]++
+		size += c.size
+	}
+	logrus.Infof("Layer of %s: %d added, %d modified, %d deleted, %d bytes",
+		createdBy, counts[layerChangeAdded], counts[layerChangeModified], counts[layerChangeDeleted], size)
+
+	sorted := make([]layerChange, len(changes))
+	copy(sorted, changes)
+	if len(sorted) > maxLayerDiffPaths {
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].size > sorted[j].size })
+		sorted = sorted[:maxLayerDiffPaths]
+	}
+	for _, c := range sorted {
+		logrus.Infof("  %s %s (%d bytes)", c.kind, c.path, c.size)
+	}
+	if omitted := len(changes) - len(sorted); omitted > 0 {
+		logrus.Infof("  ... and %d more paths", omitted)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDoBuild_PrintLayerDiffs(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/ copied/
+COPY exec copied/bam.txt`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	hook := logrustest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	opts := &config.KanikoOptions{
+		DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:      filepath.Join(testDir, "workspace"),
+		SnapshotMode:    constants.SnapshotModeFull,
+		PrintLayerDiffs: true,
+	}
+	_, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	var diffs []string
+	for _, e := range hook.AllEntries() {
+		if strings.HasPrefix(e.Message, "Layer of ") || strings.HasPrefix(e.Message, "  ") {
+			diffs = append(diffs, e.Message)
+		}
+	}
+	testutil.CheckDeepEqual(t, []string{
+		"Layer of COPY foo/ copied/: 3 added, 0 modified, 0 deleted, 4 bytes",
+		"  A /copied (0 bytes)",
+		"  A /copied/bam.link (0 bytes)",
+		"  A /copied/bam.txt (4 bytes)",
+		"Layer of COPY exec copied/bam.txt: 0 added, 1 modified, 0 deleted, 4 bytes",
+		"  M /copied/bam.txt (4 bytes)",
+	}, diffs)
+}
+
+func Test_logLayerDiff_summarizesHugeLayers(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < maxLayerDiffPaths+10; i++ {
+		content := bytes.Repeat([]byte("a"), i)
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("file%d", i), Size: int64(len(content)), Mode: 0644, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/.wh.gone", Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	changes, err := diffLayer(&buf, map[string]struct{}{})
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, maxLayerDiffPaths+11, len(changes))
+	deleted := changes[len(changes)-1]
+	testutil.CheckDeepEqual(t, layerChangeDeleted, deleted.kind)
+	testutil.CheckDeepEqual(t, "/dir/gone", deleted.path)
+
+	hook := logrustest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	logLayerDiff("RUN make", changes)
+	entries := hook.AllEntries()
+	// the summary, the largest paths and the number of omitted paths
+	testutil.CheckDeepEqual(t, maxLayerDiffPaths+2, len(entries))
+	testutil.CheckDeepEqual(t, "  A /file59 (59 bytes)", entries[1].Message)
+	testutil.CheckDeepEqual(t, "  ... and 11 more paths", entries[len(entries)-1].Message)
+}
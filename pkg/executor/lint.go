@@ -0,0 +1,128 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/pkg/commands"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/pkg/util"
+)
+
+// Lint checks the Dockerfile without building it, it returns the issues found for --lint.
+func Lint(opts *config.KanikoOptions) ([]dockerfile.LintFinding, error) {
+	b, err := dockerfile.LoadDockerfile(opts)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := dockerfile.LintInstructions(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 {
+		// the stages can't be parsed
+		return findings, nil
+	}
+
+	stages, metaArgs, err := dockerfile.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	stageFindings, err := dockerfile.LintStages(opts, stages, metaArgs)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, stageFindings...)
+
+	fileContext, err := util.NewFileContextFromDockerfile(opts.DockerfilePath, opts.SrcContext)
+	if err != nil {
+		return nil, err
+	}
+	fileContext.NamedContexts = namedContextDirs(opts.BuildContexts)
+	for _, stage := range stages {
+		findings = append(findings, lintCopySources(stage, metaArgs, opts, fileContext)...)
+	}
+	dockerfile.SortFindings(findings)
+	return findings, nil
+}
+
+// lintCopySources reports COPY sources of the stage that don't exist in the build context.
+// ARG and ENV are evaluated the same way as during the build, so that sources like
+// ${SRC} are resolved.
+func lintCopySources(stage instructions.Stage, metaArgs []instructions.ArgCommand, opts *config.KanikoOptions, fileContext util.FileContext) []dockerfile.LintFinding {
+	var findings []dockerfile.LintFinding
+	var cfg v1.Config
+	args := dockerfile.NewBuildArgs(opts.BuildArgs)
+	args.AddMetaArgs(metaArgs)
+	for _, cmd := range stage.Commands {
+		line := 0
+		if loc := cmd.Location(); len(loc) > 0 {
+			line = loc[0].Start.Line
+		}
+		switch c := cmd.(type) {
+		case *instructions.ArgCommand, *instructions.EnvCommand:
+			dc, err := commands.GetCommand(cmd, fileContext, false, false, false)
+			if err != nil {
+				findings = append(findings, dockerfile.LintFinding{Line: line, Message: err.Error()})
+				continue
+			}
+			if err := dc.ExecuteCommand(&cfg, args); err != nil {
+				findings = append(findings, dockerfile.LintFinding{Line: line, Message: err.Error()})
+			}
+		case *instructions.CopyCommand:
+			if _, ok := fileContext.NamedContexts[c.From]; c.From != "" && !ok {
+				// copies from a stage or image, which only exists during the build
+				continue
+			}
+			dc, err := commands.GetCommand(cmd, fileContext, false, false, false)
+			if err != nil {
+				findings = append(findings, dockerfile.LintFinding{Line: line, Message: err.Error()})
+				continue
+			}
+			files, err := dc.FilesUsedFromContext(&cfg, args)
+			var pathErr *fs.PathError
+			if errors.Is(err, fs.ErrNotExist) && errors.As(err, &pathErr) {
+				findings = append(findings, missingSource(line, fileContext, pathErr.Path))
+				continue
+			} else if err != nil {
+				findings = append(findings, dockerfile.LintFinding{Line: line, Message: err.Error()})
+				continue
+			}
+			for _, f := range files {
+				if _, err := os.Lstat(f); errors.Is(err, fs.ErrNotExist) {
+					findings = append(findings, missingSource(line, fileContext, f))
+				}
+			}
+		}
+	}
+	return findings
+}
+
+func missingSource(line int, fileContext util.FileContext, path string) dockerfile.LintFinding {
+	if rel, err := filepath.Rel(fileContext.Root, path); err == nil {
+		path = rel
+	}
+	return dockerfile.LintFinding{Line: line, Message: fmt.Sprintf("COPY source %s does not exist in the build context", path)}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		buildArgs  []string
+		target     string
+		expected   []dockerfile.LintFinding
+	}{
+		{
+			name: "no issues",
+			dockerfile: `ARG BASE=scratch
+FROM $BASE
+ARG SRC=exists
+ENV DEST=/app
+COPY $SRC $DEST/
+LABEL dest=$DEST`,
+		},
+		{
+			name: "missing COPY source",
+			dockerfile: `FROM scratch
+COPY exists /
+COPY missing /`,
+			expected: []dockerfile.LintFinding{{Line: 3, Message: "COPY source missing does not exist in the build context"}},
+		},
+		{
+			name: "missing COPY source from build arg",
+			dockerfile: `FROM scratch
+ARG SRC=exists
+COPY $SRC /`,
+			buildArgs: []string{"SRC=missing"},
+			expected:  []dockerfile.LintFinding{{Line: 3, Message: "COPY source missing does not exist in the build context"}},
+		},
+		{
+			name: "undefined ARG in FROM",
+			dockerfile: `FROM alpine:$VERSION
+COPY exists /`,
+			expected: []dockerfile.LintFinding{{Line: 1, Message: "undefined ARG VERSION in FROM, declare it with ARG before the first FROM"}},
+		},
+		{
+			name: "undefined ARG in stage",
+			dockerfile: `ARG VERSION=1
+FROM scratch
+LABEL version=$VERSION`,
+			expected: []dockerfile.LintFinding{{Line: 3, Message: "undefined ARG VERSION in LABEL, declare it with ARG or ENV"}},
+		},
+		{
+			name: "unknown instruction",
+			dockerfile: `FROM scratch
+COPPY exists /
+COPY exists /
+FOO bar`,
+			expected: []dockerfile.LintFinding{{Line: 2}, {Line: 4}},
+		},
+		{
+			name: "unreachable stage",
+			dockerfile: `FROM scratch AS unused
+FROM scratch AS base
+FROM base
+COPY exists /`,
+			expected: []dockerfile.LintFinding{{Line: 1, Message: "stage unused is unreachable, the target stage doesn't depend on it"}},
+		},
+		{
+			name: "stages after the target are unreachable",
+			dockerfile: `FROM scratch AS base
+FROM scratch AS other`,
+			target:   "base",
+			expected: []dockerfile.LintFinding{{Line: 2, Message: "stage other is unreachable, the target stage doesn't depend on it"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "exists"), []byte("meow"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			dockerfilePath := filepath.Join(dir, "Dockerfile")
+			if err := os.WriteFile(dockerfilePath, []byte(test.dockerfile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath: dockerfilePath,
+				SrcContext:     dir,
+				BuildArgs:      test.buildArgs,
+				Target:         test.target,
+			}
+			findings, err := Lint(opts)
+			testutil.CheckNoError(t, err)
+			if len(findings) != len(test.expected) {
+				t.Fatalf("expected %d findings, got %v", len(test.expected), findings)
+			}
+			for i, expected := range test.expected {
+				testutil.CheckDeepEqual(t, expected.Line, findings[i].Line)
+				if expected.Message != "" {
+					testutil.CheckDeepEqual(t, expected.Message, findings[i].Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/moby/go-archive"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// limitLayers merges the trailing layers of image into one, so that it has at most
+// maxLayers layers, see --max-layers. The first baseLayers layers, those of the image
+// the stage is built on, are kept. newLayer creates a layer from a tar file.
+func limitLayers(image v1.Image, maxLayers, baseLayers int, newLayer func(string) (v1.Layer, error)) (v1.Image, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layers")
+	}
+	if len(layers) <= maxLayers {
+		return image, nil
+	}
+	if maxLayers <= baseLayers {
+		return nil, fmt.Errorf("--max-layers=%d leaves no room for the layers of the build, the base image has %d layers", maxLayers, baseLayers)
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting config file")
+	}
+	cf = cf.DeepCopy()
+
+	// history entries that are not empty layers belong to the layers in order
+	layerHistory := []int{}
+	for i, h := range cf.History {
+		if !h.EmptyLayer {
+			layerHistory = append(layerHistory, i)
+		}
+	}
+	if len(cf.History) > 0 && len(layerHistory) != len(layers) {
+		return nil, fmt.Errorf("can't merge layers to fit --max-layers=%d, the history of the image doesn't match its %d layers", maxLayers, len(layers))
+	}
+
+	merged := layers[maxLayers-1:]
+	logrus.Infof("Merging the last %d layers into one to fit --max-layers=%d", len(merged), maxLayers)
+	tarPath, err := mergeLayers(merged)
+	if err != nil {
+		return nil, errors.Wrap(err, "merging layers")
+	}
+	layer, err := newLayer(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	kept := append(append([]v1.Layer{}, layers[:maxLayers-1]...), layer)
+
+	diffIDs := cf.RootFS.DiffIDs[:maxLayers-1]
+	diffID, err := layer.DiffID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layer diff id")
+	}
+	cf.RootFS.DiffIDs = append(diffIDs, diffID)
+	if len(cf.History) > 0 {
+		// the merged layer belongs to the history entry of the last of them
+		for _, i := range layerHistory[maxLayers-1 : len(layerHistory)-1] {
+			cf.History[i].EmptyLayer = true
+		}
+		last := &cf.History[layerHistory[len(layerHistory)-1]]
+		last.Comment = strings.TrimSpace(fmt.Sprintf("%s merged %d layers to fit --max-layers", last.Comment, len(merged)))
+	}
+
+	mt, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	limited, err := mutate.AppendLayers(mutate.MediaType(empty.Image, mt), kept...)
+	if err != nil {
+		return nil, err
+	}
+	limited = mutate.ConfigMediaType(limited, m.Config.MediaType)
+	return mutate.ConfigFile(limited, cf)
+}
+
+// mergedEntry is the position of an entry of a layer in the layers being merged.
+type mergedEntry struct {
+	layer, index int
+	dir          bool
+}
+
+// mergeLayers writes the filesystem changes of layers applied in order as a single
+// layer to a tar file and returns its path. Whiteouts are kept since they may delete
+// files of the layers below and come first, the entries follow in the order of the
+// layers they were last written in.
+func mergeLayers(layers []v1.Layer) (string, error) {
+	entries := map[string]mergedEntry{}
+	var whiteouts []*tar.Header
+	seenWhiteouts := map[string]bool{}
+	removeUnder := func(dir string) {
+		for p := range entries {
+			if strings.HasPrefix(p, dir+"/") {
+				delete(entries, p)
+			}
+		}
+	}
+	err := walkLayers(layers, func(layer, index int, hdr *tar.Header, _ io.Reader) error {
+		p := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(p)
+		dir = path.Clean(dir)
+		switch {
+		case base == archive.WhiteoutOpaqueDir:
+			removeUnder(dir)
+		case strings.HasPrefix(base, archive.WhiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, archive.WhiteoutPrefix))
+			delete(entries, target)
+			removeUnder(target)
+		default:
+			if previous, ok := entries[p]; ok && previous.dir && hdr.Typeflag != tar.TypeDir {
+				removeUnder(p)
+			}
+			entries[p] = mergedEntry{layer: layer, index: index, dir: hdr.Typeflag == tar.TypeDir}
+			return nil
+		}
+		if !seenWhiteouts[p] {
+			seenWhiteouts[p] = true
+			whiteouts = append(whiteouts, hdr)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(config.KanikoLayersDir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(config.KanikoLayersDir, "")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, hdr := range whiteouts {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+	}
+	err = walkLayers(layers, func(layer, index int, hdr *tar.Header, r io.Reader) error {
+		e, ok := entries[path.Clean("/"+hdr.Name)]
+		if !ok || e.layer != layer || e.index != index {
+			return nil
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// walkLayers calls fn with each entry of the uncompressed layers in order.
+func walkLayers(layers []v1.Layer, fn func(layer, index int, hdr *tar.Header, r io.Reader) error) error {
+	for i, l := range layers {
+		rc, err := l.Uncompressed()
+		if err != nil {
+			return errors.Wrap(err, "reading layer")
+		}
+		tr := tar.NewReader(rc)
+		for index := 0; ; index++ {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rc.Close()
+				return errors.Wrap(err, "reading layer")
+			}
+			if err := fn(i, index, hdr, tr); err != nil {
+				rc.Close()
+				return err
+			}
+		}
+		rc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+}
+
+func testTarLayer(t *testing.T, entries ...testTarEntry) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+// readTarEntries returns the names of the entries of a tar file, mapped to their content.
+func readTarEntries(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	entries := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(b)
+	}
+}
+
+func Test_mergeLayers(t *testing.T) {
+	original := config.KanikoLayersDir
+	defer func() { config.KanikoLayersDir = original }()
+	config.KanikoLayersDir = t.TempDir()
+
+	layers := []v1.Layer{
+		testTarLayer(t,
+			testTarEntry{name: "app/", typeflag: tar.TypeDir},
+			testTarEntry{name: "app/a", typeflag: tar.TypeReg, content: "a1"},
+			testTarEntry{name: "app/b", typeflag: tar.TypeReg, content: "b"},
+			testTarEntry{name: "cache/", typeflag: tar.TypeDir},
+			testTarEntry{name: "cache/x", typeflag: tar.TypeReg, content: "x"},
+			testTarEntry{name: "link/", typeflag: tar.TypeDir},
+			testTarEntry{name: "link/y", typeflag: tar.TypeReg, content: "y"},
+		),
+		testTarLayer(t,
+			testTarEntry{name: "app/a", typeflag: tar.TypeReg, content: "a2"},
+			testTarEntry{name: "app/.wh.b", typeflag: tar.TypeReg},
+			testTarEntry{name: "cache/.wh..wh..opq", typeflag: tar.TypeReg},
+			testTarEntry{name: "cache/z", typeflag: tar.TypeReg, content: "z"},
+			testTarEntry{name: "link", typeflag: tar.TypeReg, content: "file"},
+			testTarEntry{name: ".wh.base", typeflag: tar.TypeReg},
+		),
+	}
+	tarPath, err := mergeLayers(layers)
+	testutil.CheckNoError(t, err)
+	f, err := os.Open(tarPath)
+	testutil.CheckNoError(t, err)
+	defer f.Close()
+
+	testutil.CheckDeepEqual(t, map[string]string{
+		"app/":               "",
+		"app/a":              "a2",
+		"app/.wh.b":          "",
+		"cache/":             "",
+		"cache/.wh..wh..opq": "",
+		"cache/z":            "z",
+		"link":               "file",
+		".wh.base":           "",
+	}, readTarEntries(t, f))
+}
+
+func Test_limitLayers_baseImage(t *testing.T) {
+	image, err := random.Image(64, 3)
+	testutil.CheckNoError(t, err)
+	newLayer := func(string) (v1.Layer, error) {
+		t.Fatal("no layer should be merged")
+		return nil, nil
+	}
+
+	limited, err := limitLayers(image, 3, 3, newLayer)
+	testutil.CheckNoError(t, err)
+	if limited != image {
+		t.Fatal("expected an image within the limit to be returned unchanged")
+	}
+	_, err = limitLayers(image, 2, 2, newLayer)
+	testutil.CheckError(t, true, err)
+}
+
+func TestDoBuild_MaxLayers(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt first/
+COPY exec second/
+COPY foo/bam.txt third/
+COPY exec third/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		MaxLayers:      2,
+	}
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(layers))
+	cf, err := image.ConfigFile()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(cf.RootFS.DiffIDs))
+	testutil.CheckDeepEqual(t, 4, len(cf.History))
+	var emptyLayers []bool
+	for _, h := range cf.History {
+		emptyLayers = append(emptyLayers, h.EmptyLayer)
+	}
+	testutil.CheckDeepEqual(t, []bool{false, true, true, false}, emptyLayers)
+
+	var files []string
+	for _, l := range layers {
+		rc, err := l.Uncompressed()
+		testutil.CheckNoError(t, err)
+		for name := range readTarEntries(t, rc) {
+			files = append(files, filepath.Clean(name))
+		}
+		rc.Close()
+	}
+	sort.Strings(files)
+	for _, want := range []string{"first/bam.txt", "second/exec", "third/bam.txt", "third/exec"} {
+		if i := sort.SearchStrings(files, want); i == len(files) || files[i] != want {
+			t.Errorf("expected %s in the layers of the image, got %v", want, files)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	image_util "github.com/osscontainertools/kaniko/pkg/image"
+	"github.com/osscontainertools/kaniko/pkg/image/remote"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// for testing
+var prefetchRemoteImage = remote.PrefetchRemoteImage
+
+// prefetchDir is where the layers of prefetched base images are downloaded to, they
+// are kept until kaniko exits as pushing the image may read them.
+func prefetchDir() string {
+	return filepath.Join(config.KanikoDir, "prefetch")
+}
+
+// basePrefetcher fetches the base images of upcoming stages in the background,
+// see --prefetch-base-images.
+type basePrefetcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   *config.KanikoOptions
+
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+func newBasePrefetcher(opts *config.KanikoOptions) *basePrefetcher {
+	ctx, cancel := context.WithCancel(util.BuildContext())
+	return &basePrefetcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		opts:    opts,
+		pending: map[string]chan struct{}{},
+	}
+}
+
+// baseImage returns the name of the remote base image of stage, if it has one.
+func (p *basePrefetcher) baseImage(stage config.KanikoStage) (string, bool) {
+	if stage.BaseImageStoredLocally {
+		return "", false
+	}
+	name, err := image_util.BaseImageName(stage, p.opts)
+	if err != nil || name == constants.NoBaseImage {
+		return "", false
+	}
+	return name, true
+}
+
+// prefetch starts fetching the base image of stage, unless it is built by another stage
+// or fetched already. Failures are left to the stage that uses the image to report.
+func (p *basePrefetcher) prefetch(stage config.KanikoStage) {
+	name, ok := p.baseImage(stage)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[name]; ok {
+		return
+	}
+	done := make(chan struct{})
+	p.pending[name] = done
+	go func() {
+		defer close(done)
+		logrus.Debugf("Prefetching base image %s", name)
+		if err := prefetchRemoteImage(p.ctx, name, p.opts.RegistryOptions, p.opts.CustomPlatform, prefetchDir()); err != nil {
+			logrus.Debugf("Prefetching base image %s failed: %v", name, err)
+		}
+	}()
+}
+
+// wait blocks until the prefetch of the base image of stage is done, if there is one,
+// so that the stage doesn't fetch it a second time.
+func (p *basePrefetcher) wait(stage config.KanikoStage) {
+	name, ok := p.baseImage(stage)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	done, ok := p.pending[name]
+	p.mu.Unlock()
+	if ok {
+		<-done
+	}
+}
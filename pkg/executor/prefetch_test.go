@@ -0,0 +1,148 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/platforms"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_PrefetchBaseImages(t *testing.T) {
+	for _, tc := range []struct {
+		prefetch bool
+		want     []string
+	}{
+		{prefetch: true, want: []string{"fetch", "stage"}},
+		{prefetch: false, want: []string{"stage", "fetch"}},
+	} {
+		t.Run(map[bool]string{true: "prefetch", false: "no prefetch"}[tc.prefetch], func(t *testing.T) {
+			testDir, fn := setupMultistageTests(t)
+			defer fn()
+
+			var second v1.Image
+			var layerPath string
+			// events records when the layer of the second base image is fetched
+			// from the registry and when its stage starts to unpack it
+			var mu sync.Mutex
+			var events []string
+			record := func(event string) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, event)
+			}
+			reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet && layerPath != "" && r.URL.Path == layerPath {
+					record("fetch")
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			host := strings.TrimPrefix(server.URL, "http://")
+			for _, repo := range []string{"first", "second"} {
+				img, err := random.Image(1024, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ref, err := name.ParseReference(host + "/" + repo + ":latest")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := remote.Write(ref, img); err != nil {
+					t.Fatal(err)
+				}
+				second = img
+			}
+			layers, err := second.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest, err := layers[0].Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			layerPath = "/v2/second/blobs/" + digest.String()
+			secondDigest, err := second.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			original := getFSFromImage
+			defer func() { getFSFromImage = original }()
+			getFSFromImage = func(root string, img v1.Image, extract util.ExtractFunction, opts ...util.FSOpt) ([]string, error) {
+				if d, err := img.Digest(); err == nil && d == secondDigest {
+					record("stage")
+				}
+				return original(root, img, extract, opts...)
+			}
+
+			// the prefetches are canceled once the build is done
+			var prefetchCtx context.Context
+			originalPrefetch := prefetchRemoteImage
+			defer func() { prefetchRemoteImage = originalPrefetch }()
+			prefetchRemoteImage = func(ctx context.Context, image string, opts config.RegistryOptions, platform string, dir string) error {
+				mu.Lock()
+				prefetchCtx = ctx
+				mu.Unlock()
+				return originalPrefetch(ctx, image, opts, platform, dir)
+			}
+
+			dockerFile := `
+FROM ` + host + `/first
+COPY foo/bam.txt copied/
+FROM ` + host + `/second
+COPY foo/bam.txt copied/`
+			if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			opts := &config.KanikoOptions{
+				DockerfilePath:     filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:         filepath.Join(testDir, "workspace"),
+				SnapshotMode:       constants.SnapshotModeFull,
+				CustomPlatform:     platforms.Format(platforms.Normalize(platforms.DefaultSpec())),
+				PreserveContext:    true,
+				PrefetchBaseImages: tc.prefetch,
+			}
+			_, err = DoBuild(opts)
+			testutil.CheckNoError(t, err)
+			// the layer is fetched once, before the stage starts if it is prefetched
+			testutil.CheckDeepEqual(t, tc.want, events)
+			if tc.prefetch && (prefetchCtx == nil || prefetchCtx.Err() == nil) {
+				t.Errorf("expected the prefetch context to be canceled after the build")
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/pkg/version"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ProvenanceMinimal makes kaniko generate the provenance instead of reading it from a file
+	ProvenanceMinimal = "minimal"
+
+	// provenanceMediaType is the media type of in-toto statements. It is used as
+	// layer and config media type of the artifact, registries derive the
+	// artifactType of a referrer from the latter.
+	provenanceMediaType = "application/vnd.in-toto+json"
+
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	kanikoBuildType     = "https://github.com/osscontainertools/kaniko/buildtypes/executor/v1"
+	kanikoBuilderID     = "https://github.com/osscontainertools/kaniko"
+)
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string                   `json:"buildType"`
+	ExternalParameters   map[string]string        `json:"externalParameters"`
+	ResolvedDependencies []slsaResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type slsaResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaRunDetails struct {
+	Builder slsaBuilder `json:"builder"`
+}
+
+type slsaBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// provenanceMaterials are the images and the build context the build used, they are
+// listed as resolved dependencies of the generated provenance.
+var provenanceMaterials = &materials{}
+
+type materials struct {
+	mu      sync.Mutex
+	images  map[string]v1.Hash
+	context *slsaResourceDescriptor
+}
+
+func (m *materials) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.images = map[string]v1.Hash{}
+	m.context = nil
+}
+
+// addImage records the digest of the image ref was resolved to.
+func (m *materials) addImage(ref string, image v1.Image) error {
+	digest, err := image.Digest()
+	if err != nil {
+		return errors.Wrapf(err, "getting digest of %s", ref)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.images == nil {
+		m.images = map[string]v1.Hash{}
+	}
+	m.images[ref] = digest
+	return nil
+}
+
+// addContext records the digest of the files of the build context.
+func (m *materials) addContext(context util.FileContext) error {
+	digest, err := contextDigest(context)
+	if err != nil {
+		return errors.Wrapf(err, "computing digest of build context %s", context.Root)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.context = &slsaResourceDescriptor{URI: context.Root, Digest: map[string]string{digest.Algorithm: digest.Hex}}
+	return nil
+}
+
+func (m *materials) dependencies() []slsaResourceDescriptor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deps []slsaResourceDescriptor
+	if m.context != nil {
+		deps = append(deps, *m.context)
+	}
+	refs := make([]string, 0, len(m.images))
+	for ref := range m.images {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		digest := m.images[ref]
+		deps = append(deps, slsaResourceDescriptor{URI: ref, Digest: map[string]string{digest.Algorithm: digest.Hex}})
+	}
+	return deps
+}
+
+// contextDigest hashes the paths and content of the files of the context that
+// are not excluded, their mtimes are left out so that a checkout has the same digest.
+func contextDigest(context util.FileContext) (v1.Hash, error) {
+	var lines []string
+	hasher := util.CacheHasher()
+	err := filepath.WalkDir(context.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == context.Root || context.ExcludesFile(path) {
+			return nil
+		}
+		h, err := hasher(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(context.Root, path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, rel+"\x00"+h)
+		return nil
+	})
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	sort.Strings(lines)
+	digest, _, err := v1.SHA256(strings.NewReader(strings.Join(lines, "\n")))
+	return digest, err
+}
+
+// generatesProvenance returns true if the build generates a provenance, its
+// materials are only recorded then.
+func generatesProvenance(opts *config.KanikoOptions) bool {
+	return opts.Provenance == ProvenanceMinimal || opts.ProvenancePath != ""
+}
+
+// provenanceStatement returns the provenance to attach to image, either read from
+// the file configured with --provenance or generated from the build options.
+func provenanceStatement(image v1.Image, repo name.Repository, opts *config.KanikoOptions) ([]byte, error) {
+	if opts.Provenance != ProvenanceMinimal {
+		b, err := os.ReadFile(opts.Provenance)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading provenance")
+		}
+		if !json.Valid(b) {
+			return nil, errors.Errorf("provenance %s is not valid JSON", opts.Provenance)
+		}
+		return b, nil
+	}
+	return generateProvenance(image, []string{repo.Name()}, opts)
+}
+
+// generateProvenance returns a SLSA provenance of image, named after each of names.
+// Build args are deliberately left out of the generated provenance, they may contain secrets.
+func generateProvenance(image v1.Image, names []string, opts *config.KanikoOptions) ([]byte, error) {
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{
+		"dockerfile": opts.DockerfilePath,
+		"context":    opts.SrcContext,
+	}
+	if opts.Target != "" {
+		params["target"] = opts.Target
+	}
+	if opts.CustomPlatform != "" {
+		params["platform"] = opts.CustomPlatform
+	}
+	subjectDigest := map[string]string{digest.Algorithm: digest.Hex}
+	subjects := []inTotoSubject{}
+	for _, n := range names {
+		subjects = append(subjects, inTotoSubject{Name: n, Digest: subjectDigest})
+	}
+	if len(subjects) == 0 {
+		subjects = append(subjects, inTotoSubject{Digest: subjectDigest})
+	}
+	return json.Marshal(inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       subjects,
+		PredicateType: slsaProvenanceType,
+		Predicate: slsaProvenance{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType:            kanikoBuildType,
+				ExternalParameters:   params,
+				ResolvedDependencies: provenanceMaterials.dependencies(),
+			},
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{
+					ID:      kanikoBuilderID,
+					Version: map[string]string{"kaniko": version.Version()},
+				},
+			},
+		},
+	})
+}
+
+// writeProvenanceFile writes the generated provenance of image, named after the
+// destinations, to --provenance-file.
+func writeProvenanceFile(image v1.Image, destRefs []name.Tag, opts *config.KanikoOptions) error {
+	var names []string
+	for _, destRef := range destRefs {
+		if !slices.Contains(names, destRef.Repository.Name()) {
+			names = append(names, destRef.Repository.Name())
+		}
+	}
+	statement, err := generateProvenance(image, names, opts)
+	if err != nil {
+		return errors.Wrap(err, "generating provenance")
+	}
+	if err := os.WriteFile(opts.ProvenancePath, statement, 0644); err != nil {
+		return errors.Wrap(err, "writing provenance file")
+	}
+	return syncExport(opts, opts.ProvenancePath)
+}
+
+// provenanceArtifact wraps the statement in an OCI artifact whose subject is image.
+func provenanceArtifact(image v1.Image, statement []byte) (v1.Image, error) {
+	subject, err := partial.Descriptor(image)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting image descriptor")
+	}
+	mt, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	subject.MediaType = mt
+
+	artifact, err := mutate.AppendLayers(empty.Image, static.NewLayer(statement, provenanceMediaType))
+	if err != nil {
+		return nil, err
+	}
+	artifact = mutate.MediaType(artifact, types.OCIManifestSchema1)
+	artifact = mutate.ConfigMediaType(artifact, provenanceMediaType)
+	return mutate.Subject(artifact, *subject).(v1.Image), nil
+}
+
+// pushProvenance pushes the provenance of image as a referrer of the image pushed to destRef.
+// Registries without support for the referrers API are handled by the fallback tag schema.
+func pushProvenance(image v1.Image, destRef name.Tag, opts *config.KanikoOptions, remoteOpts ...remote.Option) error {
+	statement, err := provenanceStatement(image, destRef.Repository, opts)
+	if err != nil {
+		return err
+	}
+	artifact, err := provenanceArtifact(image, statement)
+	if err != nil {
+		return errors.Wrap(err, "creating provenance artifact")
+	}
+	dig, err := artifact.Digest()
+	if err != nil {
+		return err
+	}
+	ref := destRef.Context().Digest(dig.String())
+	if err := remote.Write(ref, artifact, remoteOpts...); err != nil {
+		return errors.Wrapf(err, "pushing provenance to %s", ref)
+	}
+	logrus.Infof("Pushed provenance %s", ref)
+	return nil
+}
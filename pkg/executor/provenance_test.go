@@ -0,0 +1,231 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoPushProvenance(t *testing.T) {
+	provenanceFile := filepath.Join(t.TempDir(), "provenance.json")
+	if err := os.WriteFile(provenanceFile, []byte(`{"_type":"https://in-toto.io/Statement/v1","custom":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		provenance string
+		referrers  bool
+	}{
+		{name: "generated provenance", provenance: ProvenanceMinimal, referrers: true},
+		{name: "provenance from file", provenance: provenanceFile, referrers: true},
+		{name: "registry without referrers API", provenance: ProvenanceMinimal, referrers: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(registry.New(
+				registry.Logger(log.New(io.Discard, "", 0)),
+				registry.WithReferrersSupport(tc.referrers),
+			))
+			defer server.Close()
+
+			image, err := random.Image(1024, 2)
+			if err != nil {
+				t.Fatalf("could not create image: %s", err)
+			}
+			repo := strings.TrimPrefix(server.URL, "http://") + "/test"
+			opts := &config.KanikoOptions{
+				Destinations:   []string{repo + ":latest"},
+				DockerfilePath: "/workspace/Dockerfile",
+				Provenance:     tc.provenance,
+			}
+			testutil.CheckNoError(t, DoPush(image, opts))
+
+			dig, err := image.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := name.NewDigest(repo + "@" + dig.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			idx, err := remote.Referrers(subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mfst, err := idx.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mfst.Manifests) != 1 {
+				t.Fatalf("expected 1 referrer, got %d", len(mfst.Manifests))
+			}
+			// the registered media type of in-toto statements, not one of kaniko's
+			testutil.CheckDeepEqual(t, "application/vnd.in-toto+json", mfst.Manifests[0].ArtifactType)
+
+			artifact, err := remote.Image(subject.Context().Digest(mfst.Manifests[0].Digest.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			artifactMfst, err := artifact.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if artifactMfst.Subject == nil {
+				t.Fatal("expected the artifact to have a subject")
+			}
+			testutil.CheckDeepEqual(t, dig, artifactMfst.Subject.Digest)
+			testutil.CheckDeepEqual(t, types.MediaType("application/vnd.in-toto+json"), artifactMfst.Config.MediaType)
+			testutil.CheckDeepEqual(t, 1, len(artifactMfst.Layers))
+			testutil.CheckDeepEqual(t, types.MediaType("application/vnd.in-toto+json"), artifactMfst.Layers[0].MediaType)
+
+			layers, err := artifact.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc, err := layers[0].Uncompressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			var statement map[string]interface{}
+			if err := json.NewDecoder(rc).Decode(&statement); err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckDeepEqual(t, inTotoStatementType, statement["_type"])
+			if tc.provenance == ProvenanceMinimal {
+				subjects := statement["subject"].([]interface{})
+				digest := subjects[0].(map[string]interface{})["digest"].(map[string]interface{})
+				testutil.CheckDeepEqual(t, dig.Hex, digest["sha256"])
+			} else {
+				testutil.CheckDeepEqual(t, true, statement["custom"])
+			}
+		})
+	}
+}
+
+func TestProvenanceStatement_invalid_file(t *testing.T) {
+	provenanceFile := filepath.Join(t.TempDir(), "provenance.json")
+	if err := os.WriteFile(provenanceFile, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provenanceStatement(image, name.Repository{}, &config.KanikoOptions{Provenance: provenanceFile})
+	testutil.CheckError(t, true, err)
+}
+
+func TestWriteProvenanceFile(t *testing.T) {
+	contextDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(contextDir, "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDigest, err := base.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provenanceMaterials.reset()
+	defer provenanceMaterials.reset()
+	testutil.CheckNoError(t, provenanceMaterials.addImage("alpine:3.20", base))
+	testutil.CheckNoError(t, provenanceMaterials.addContext(util.FileContext{Root: contextDir}))
+
+	image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dig, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		NoPush:         true,
+		DockerfilePath: "/workspace/Dockerfile",
+		ProvenancePath: filepath.Join(t.TempDir(), "provenance.json"),
+	}
+	testutil.CheckNoError(t, DoPush(image, opts))
+
+	b, err := os.ReadFile(opts.ProvenancePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(b, &statement); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, []inTotoSubject{{Digest: map[string]string{"sha256": dig.Hex}}}, statement.Subject)
+	deps := statement.Predicate.BuildDefinition.ResolvedDependencies
+	if len(deps) != 2 {
+		t.Fatalf("expected the context and the base image as dependencies, got %v", deps)
+	}
+	testutil.CheckDeepEqual(t, contextDir, deps[0].URI)
+	testutil.CheckDeepEqual(t, slsaResourceDescriptor{URI: "alpine:3.20", Digest: map[string]string{"sha256": baseDigest.Hex}}, deps[1])
+}
+
+func TestContextDigest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ignored"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	context := util.FileContext{Root: dir, ExcludedFiles: []string{"ignored"}}
+	before, err := contextDigest(context)
+	testutil.CheckNoError(t, err)
+
+	// the mtime and the excluded files don't change the digest, the content does
+	if err := os.Chtimes(filepath.Join(dir, "file"), time.Now(), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ignored"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unchanged, err := contextDigest(context)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, before, unchanged)
+
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := contextDigest(context)
+	testutil.CheckNoError(t, err)
+	if changed == before {
+		t.Errorf("expected the digest to change with the content, got %s", changed)
+	}
+}
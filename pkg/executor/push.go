@@ -26,6 +26,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -85,12 +86,16 @@ func (w *withUserAgent) RoundTrip(r *http.Request) (*http.Response, error) {
 var (
 	newOsFs                   = afero.NewOsFs()
 	checkRemotePushPermission = remote.CheckPushPermission
+	syncPath                  = util.SyncPath
 )
 
 // CheckPushPermissions checks that the configured credentials can be used to
 // push to every specified destination.
 func CheckPushPermissions(opts *config.KanikoOptions) error {
-	targets := opts.Destinations
+	targets, err := platformDestinations(opts)
+	if err != nil {
+		return err
+	}
 	// When no push and no push cache are set, we don't need to check permissions
 	if opts.SkipPushPermissionCheck {
 		targets = []string{}
@@ -108,7 +113,7 @@ func CheckPushPermissions(opts *config.KanikoOptions) error {
 
 	checked := map[string]bool{}
 	for _, destination := range targets {
-		destRef, err := name.NewTag(destination, name.WeakValidation)
+		destRef, err := name.NewTag(util.RewriteReference(opts.RegistryOptions, destination), name.WeakValidation)
 		if err != nil {
 			return errors.Wrap(err, "getting tag for destination")
 		}
@@ -117,6 +122,9 @@ func CheckPushPermissions(opts *config.KanikoOptions) error {
 		}
 
 		registryName := destRef.Repository.Registry.Name()
+		if err := util.CheckRegistryAllowed(opts.RegistryOptions, registryName); err != nil {
+			return errors.Wrapf(err, "checking push permission for %q", destRef)
+		}
 		if opts.Insecure || opts.InsecureRegistries.Contains(registryName) {
 			newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
 			if err != nil {
@@ -129,7 +137,11 @@ func CheckPushPermissions(opts *config.KanikoOptions) error {
 			return errors.Wrapf(err, "making transport for registry %q", registryName)
 		}
 		tr := newRetry(rt)
-		if err := checkRemotePushPermission(destRef, creds.GetKeychain(&opts.RegistryOptions), tr); err != nil {
+		keychain, err := pushKeychain(destRef, opts)
+		if err != nil {
+			return errors.Wrapf(err, "checking push permission for %q", destRef)
+		}
+		if err := checkRemotePushPermission(destRef, keychain, tr); err != nil {
 			return errors.Wrapf(err, "checking push permission for %q", destRef)
 		}
 		checked[destRef.Context().String()] = true
@@ -137,6 +149,72 @@ func CheckPushPermissions(opts *config.KanikoOptions) error {
 	return nil
 }
 
+// pushKeychain returns the keychain for pushing to destRef, which uses the credentials
+// file set for the destination with --destination-auth before the default keychain.
+func pushKeychain(destRef name.Tag, opts *config.KanikoOptions) (authn.Keychain, error) {
+	for destination, path := range opts.DestinationAuths {
+		ref, err := name.NewTag(util.RewriteReference(opts.RegistryOptions, destination), name.WeakValidation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting tag for --destination-auth %s", destination)
+		}
+		if ref.Name() == destRef.Name() {
+			return creds.NewFileKeychain(path)
+		}
+	}
+	return creds.GetKeychain(&opts.RegistryOptions), nil
+}
+
+// cacheRepository returns the repository cached layers are pushed to.
+func cacheRepository(opts *config.KanikoOptions) (name.Repository, error) {
+	destination, err := cache.Destination(opts, "latest")
+	if err != nil {
+		return name.Repository{}, errors.Wrap(err, "getting cache destination")
+	}
+	ref, err := name.NewTag(destination, name.WeakValidation)
+	if err != nil {
+		return name.Repository{}, errors.Wrap(err, "getting tag for cache destination")
+	}
+	return ref.Context(), nil
+}
+
+// cacheMountableImage makes remote.Write mount the layers of the image from the cache repo,
+// which holds every layer that was cached, instead of uploading them. The registry falls back
+// to an upload for layers it cannot mount. Layers pulled from a registry are already mounted
+// from their own repository.
+type cacheMountableImage struct {
+	v1.Image
+	cacheRepo name.Repository
+}
+
+func (ci *cacheMountableImage) mountable(l v1.Layer) v1.Layer {
+	if _, ok := l.(*remote.MountableLayer); ok {
+		return l
+	}
+	return &remote.MountableLayer{Layer: l, Reference: ci.cacheRepo.Tag("latest")}
+}
+
+// Layers implements v1.Image
+func (ci *cacheMountableImage) Layers() ([]v1.Layer, error) {
+	ls, err := ci.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	mls := make([]v1.Layer, 0, len(ls))
+	for _, l := range ls {
+		mls = append(mls, ci.mountable(l))
+	}
+	return mls, nil
+}
+
+// LayerByDigest implements v1.Image
+func (ci *cacheMountableImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	l, err := ci.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return ci.mountable(l), nil
+}
+
 func getDigest(image v1.Image) ([]byte, error) {
 	digest, err := image.Digest()
 	if err != nil {
@@ -145,6 +223,19 @@ func getDigest(image v1.Image) ([]byte, error) {
 	return []byte(digest.String()), nil
 }
 
+// checkExpectedDigest returns an error if the digest of image is not expected, see --expected-digest.
+func checkExpectedDigest(image v1.Image, expected string) error {
+	digest, err := image.Digest()
+	if err != nil {
+		return errors.Wrap(err, "error fetching digest")
+	}
+	if digest.String() != expected {
+		return util.UserError(errors.Errorf("digest of the built image %s doesn't match the expected digest %s", digest, expected))
+	}
+	logrus.Infof("Digest of the built image matches the expected digest %s", expected)
+	return nil
+}
+
 func writeDigestFile(path string, digestByteArray []byte) error {
 	if strings.HasPrefix(path, "https://") {
 		// Do a HTTP PUT to the URL; this could be a pre-signed URL to S3 or GCS or Azure
@@ -168,6 +259,18 @@ func writeDigestFile(path string, digestByteArray []byte) error {
 	return os.WriteFile(path, digestByteArray, 0644)
 }
 
+// syncExport flushes an output of the build written to path with --sync-exports,
+// files uploaded with a HTTP PUT are left alone.
+func syncExport(opts *config.KanikoOptions, path string) error {
+	if !opts.SyncExports || strings.HasPrefix(path, "https://") {
+		return nil
+	}
+	if err := syncPath(path); err != nil {
+		return errors.Wrapf(err, "syncing %s", path)
+	}
+	return nil
+}
+
 // DoPush is responsible for pushing image to the destinations specified in opts.
 // A dummy destination would be set when --no-push is set to true and --tar-path
 // is not empty with empty --destinations.
@@ -180,6 +283,12 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		return errors.New("must provide at least one destination to push")
 	}
 
+	if opts.ExpectedDigest != "" {
+		if err := checkExpectedDigest(image, opts.ExpectedDigest); err != nil {
+			return err
+		}
+	}
+
 	if opts.DigestFile != "" || opts.ImageNameDigestFile != "" || opts.ImageNameTagDigestFile != "" {
 		var err error
 		digestByteArray, err = getDigest(image)
@@ -193,6 +302,9 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		if err != nil {
 			return errors.Wrap(err, "writing digest to file failed")
 		}
+		if err := syncExport(opts, opts.DigestFile); err != nil {
+			return err
+		}
 	}
 
 	if opts.OCILayoutPath != "" {
@@ -203,6 +315,9 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		if err := path.AppendImage(image); err != nil {
 			return errors.Wrap(err, "appending image")
 		}
+		if err := syncExport(opts, opts.OCILayoutPath); err != nil {
+			return err
+		}
 	}
 
 	if opts.NoPush && len(opts.Destinations) == 0 {
@@ -211,9 +326,13 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		}
 	}
 
+	destinations, err := platformDestinations(opts)
+	if err != nil {
+		return err
+	}
 	destRefs := []name.Tag{}
-	for _, destination := range opts.Destinations {
-		destRef, err := name.NewTag(destination, name.WeakValidation)
+	for _, destination := range destinations {
+		destRef, err := name.NewTag(util.RewriteReference(opts.RegistryOptions, destination), name.WeakValidation)
 		if err != nil {
 			return errors.Wrap(err, "getting tag for destination")
 		}
@@ -234,6 +353,9 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		if err != nil {
 			return errors.Wrap(err, "writing image name with digest to file failed")
 		}
+		if err := syncExport(opts, opts.ImageNameDigestFile); err != nil {
+			return err
+		}
 	}
 
 	if opts.ImageNameTagDigestFile != "" {
@@ -241,6 +363,15 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		if err != nil {
 			return errors.Wrap(err, "writing image name with image tag and digest to file failed")
 		}
+		if err := syncExport(opts, opts.ImageNameTagDigestFile); err != nil {
+			return err
+		}
+	}
+
+	if opts.ProvenancePath != "" {
+		if err := writeProvenanceFile(image, destRefs, opts); err != nil {
+			return err
+		}
 	}
 
 	if opts.TarPath != "" {
@@ -253,6 +384,9 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		if err != nil {
 			return errors.Wrap(err, "writing tarball to file failed")
 		}
+		if err := syncExport(opts, opts.TarPath); err != nil {
+			return err
+		}
 	}
 
 	if opts.NoPush {
@@ -260,29 +394,36 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		return nil
 	}
 
-	// continue pushing unless an error occurs
+	// refuse the whole push before pushing to any of the destinations
 	for _, destRef := range destRefs {
-		registryName := destRef.Repository.Registry.Name()
-		if opts.Insecure || opts.InsecureRegistries.Contains(registryName) {
-			newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
-			if err != nil {
-				return errors.Wrap(err, "getting new insecure registry")
-			}
-			destRef.Repository.Registry = newReg
+		if err := util.CheckRegistryAllowed(opts.RegistryOptions, destRef.Repository.Registry.Name()); err != nil {
+			return errors.Wrapf(err, "failed to push to destination %s", destRef)
 		}
+	}
 
-		pushAuth, err := creds.GetKeychain(&opts.RegistryOptions).Resolve(destRef.Context().Registry)
+	targets := make([]*pushTarget, 0, len(destRefs))
+	for _, destRef := range destRefs {
+		target, err := newPushTarget(destRef, image, opts)
 		if err != nil {
-			return errors.Wrap(err, "resolving pushAuth")
+			return err
 		}
+		targets = append(targets, target)
+	}
 
-		localRt, err := util.MakeTransport(opts.RegistryOptions, registryName)
-		if err != nil {
-			return errors.Wrapf(err, "making transport for registry %q", registryName)
+	if opts.PushAtomic {
+		if err := pushAtomic(image, targets, opts); err != nil {
+			return err
 		}
-		tr := newRetry(localRt)
-		rt := &withUserAgent{t: tr}
+		timing.DefaultRun.Stop(t)
+		return writeImageOutputs(image, destRefs)
+	}
 
+	// continue pushing unless an error occurs
+	for _, target := range targets {
+		destRef := target.ref
+		if target.upToDate(image, opts) {
+			continue
+		}
 		logrus.Infof("Pushing image to %s", destRef.String())
 
 		retryFunc := func() error {
@@ -291,20 +432,12 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 				return err
 			}
 			digest := destRef.Context().Digest(dig.String())
-			if err := remote.Write(destRef, image, remote.WithAuth(pushAuth), remote.WithTransport(rt)); err != nil {
-				if !opts.PushIgnoreImmutableTagErrors {
+			if err := remote.Write(destRef, target.image, target.remoteOpts...); err != nil {
+				if !opts.PushIgnoreImmutableTagErrors || !isTagImmutableError(err) {
 					return err
 				}
-
-				// check for known "tag immutable" errors
-				errStr := err.Error()
-				for _, candidate := range errTagImmutable {
-					if strings.Contains(errStr, candidate) {
-						logrus.Infof("Immutable tag error ignored for %s", digest)
-						return nil
-					}
-				}
-				return err
+				logrus.Infof("Immutable tag error ignored for %s", digest)
+				return nil
 			}
 			logrus.Infof("Pushed %s", digest)
 			return nil
@@ -313,11 +446,208 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		if err := util.Retry(retryFunc, opts.PushRetry, 1000); err != nil {
 			return errors.Wrap(err, fmt.Sprintf("failed to push to destination %s", destRef))
 		}
+
+		if err := target.pushProvenance(image, opts); err != nil {
+			return err
+		}
 	}
 	timing.DefaultRun.Stop(t)
 	return writeImageOutputs(image, destRefs)
 }
 
+// pushTarget is a destination with the options to push to it.
+type pushTarget struct {
+	ref        name.Tag
+	image      v1.Image
+	remoteOpts []remote.Option
+}
+
+func newPushTarget(destRef name.Tag, image v1.Image, opts *config.KanikoOptions) (*pushTarget, error) {
+	registryName := destRef.Repository.Registry.Name()
+	if opts.Insecure || opts.InsecureRegistries.Contains(registryName) {
+		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting new insecure registry")
+		}
+		destRef.Repository.Registry = newReg
+	}
+
+	keychain, err := pushKeychain(destRef, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to push to destination %s", destRef)
+	}
+	pushAuth, err := keychain.Resolve(destRef.Context().Registry)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving pushAuth")
+	}
+
+	localRt, err := util.MakeTransport(opts.RegistryOptions, registryName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "making transport for registry %q", registryName)
+	}
+	tr := newRetry(localRt)
+	rt := &withUserAgent{t: tr}
+
+	remoteOpts := []remote.Option{remote.WithAuth(pushAuth), remote.WithTransport(rt), remote.WithContext(util.BuildContext())}
+	// Layers are uploaded by a bounded pool of workers, the manifest is only
+	// pushed once all of them succeeded and the first failure cancels the others.
+	if opts.PushConcurrency > 0 {
+		remoteOpts = append(remoteOpts, remote.WithJobs(opts.PushConcurrency))
+	}
+
+	pushImage := image
+	if opts.PushMountFromCache && opts.Cache && !isOCILayout(opts.CacheRepo) {
+		cacheRepo, err := cacheRepository(opts)
+		if err != nil {
+			return nil, err
+		}
+		if cacheRepo.RegistryStr() == destRef.RegistryStr() {
+			pushImage = &cacheMountableImage{Image: image, cacheRepo: cacheRepo}
+		}
+	}
+	return &pushTarget{ref: destRef, image: pushImage, remoteOpts: remoteOpts}, nil
+}
+
+// upToDate returns true if the tag of the target points at image already, with
+// --push-skip-unchanged, so that neither the manifest nor the provenance are pushed
+// again. The tag is pushed if it can't be looked up.
+func (p *pushTarget) upToDate(image v1.Image, opts *config.KanikoOptions) bool {
+	if !opts.PushSkipUnchanged {
+		return false
+	}
+	dig, err := image.Digest()
+	if err != nil {
+		return false
+	}
+	desc, err := remote.Head(p.ref, p.remoteOpts...)
+	if err != nil {
+		logrus.Debugf("Looking up the digest of %s failed: %v", p.ref, err)
+		return false
+	}
+	if desc.Digest != dig {
+		return false
+	}
+	logrus.Infof("%s is up to date at %s, not pushing it", p.ref, dig)
+	return true
+}
+
+func (p *pushTarget) pushProvenance(image v1.Image, opts *config.KanikoOptions) error {
+	if opts.Provenance == "" {
+		return nil
+	}
+	provenanceFunc := func() error {
+		return pushProvenance(image, p.ref, opts, p.remoteOpts...)
+	}
+	if err := util.Retry(provenanceFunc, opts.PushRetry, 1000); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to push provenance to destination %s", p.ref))
+	}
+	return nil
+}
+
+// isTagImmutableError returns true for the known errors of registries refusing to overwrite a tag.
+func isTagImmutableError(err error) bool {
+	errStr := err.Error()
+	for _, candidate := range errTagImmutable {
+		if strings.Contains(errStr, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushAtomic pushes image to all targets or to none of them. The blobs and manifest are
+// uploaded by digest first, which leaves the tags untouched. Only then the tags are pushed,
+// if one of them fails the tags pushed before are restored to the manifest they pointed
+// to or deleted if they did not exist, where the registry allows it.
+func pushAtomic(image v1.Image, targets []*pushTarget, opts *config.KanikoOptions) error {
+	dig, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	// tags that point at the image already are neither uploaded nor tagged
+	var changed []*pushTarget
+	for _, target := range targets {
+		if !target.upToDate(image, opts) {
+			changed = append(changed, target)
+		}
+	}
+	targets = changed
+	for _, target := range targets {
+		digest := target.ref.Context().Digest(dig.String())
+		logrus.Infof("Uploading image to %s", digest)
+		uploadFunc := func() error {
+			return remote.Write(digest, target.image, target.remoteOpts...)
+		}
+		if err := util.Retry(uploadFunc, opts.PushRetry, 1000); err != nil {
+			return errors.Wrapf(err, "failed to push to destination %s, no tags were pushed", target.ref)
+		}
+	}
+
+	type previousTag struct {
+		target     *pushTarget
+		descriptor *remote.Descriptor
+	}
+	var tagged []previousTag
+	rollback := func() []string {
+		var failed []string
+		for i := len(tagged) - 1; i >= 0; i-- {
+			p := tagged[i]
+			var err error
+			if p.descriptor != nil {
+				logrus.Infof("Restoring %s to %s", p.target.ref, p.descriptor.Digest)
+				err = remote.Tag(p.target.ref, p.descriptor, p.target.remoteOpts...)
+			} else {
+				logrus.Infof("Deleting %s", p.target.ref)
+				err = remote.Delete(p.target.ref, p.target.remoteOpts...)
+			}
+			if err != nil {
+				logrus.Warnf("Failed to roll back %s: %v", p.target.ref, err)
+				failed = append(failed, p.target.ref.String())
+			}
+		}
+		return failed
+	}
+
+	for _, target := range targets {
+		previous, err := remote.Get(target.ref, target.remoteOpts...)
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			previous, err = nil, nil
+		}
+		if err != nil {
+			failed := rollback()
+			return atomicPushError(errors.Wrapf(err, "getting the manifest of %s", target.ref), failed)
+		}
+		tagFunc := func() error {
+			return remote.Tag(target.ref, target.image, target.remoteOpts...)
+		}
+		if err := util.Retry(tagFunc, opts.PushRetry, 1000); err != nil {
+			if opts.PushIgnoreImmutableTagErrors && isTagImmutableError(err) {
+				logrus.Infof("Immutable tag error ignored for %s", target.ref)
+				continue
+			}
+			failed := rollback()
+			return atomicPushError(errors.Wrapf(err, "failed to push to destination %s", target.ref), failed)
+		}
+		tagged = append(tagged, previousTag{target: target, descriptor: previous})
+		logrus.Infof("Pushed %s", target.ref.Context().Digest(dig.String()))
+	}
+
+	for _, target := range targets {
+		if err := target.pushProvenance(image, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func atomicPushError(err error, failedRollbacks []string) error {
+	if len(failedRollbacks) > 0 {
+		return errors.Wrapf(err, "atomic push failed, could not roll back %s", strings.Join(failedRollbacks, ", "))
+	}
+	return errors.Wrap(err, "atomic push failed, all tags were rolled back")
+}
+
 func writeImageOutputs(image v1.Image, destRefs []name.Tag) error {
 	dir := os.Getenv("BUILDER_OUTPUT")
 	if dir == "" {
@@ -361,25 +691,37 @@ func pushLayerToCache(opts *config.KanikoOptions, cacheKey string, tarPath strin
 		layerOpts = append(layerOpts, tarball.WithCompressionLevel(opts.CompressionLevel))
 	}
 
-	switch opts.Compression {
+	compression := opts.Compression
+	if opts.CacheCompression != "" {
+		compression = opts.CacheCompression
+	}
+	// The media type is stored with the cached layer, so that entries of either
+	// compression can be read back regardless of the current setting.
+	switch compression {
 	case config.ZStd:
 		layerOpts = append(layerOpts, tarball.WithCompression("zstd"), tarball.WithMediaType(types.OCILayerZStd))
 
 	case config.GZip:
 		// layer already gzipped by default
 	}
+	if opts.ImageFormat != "" && compression != config.ZStd {
+		layerMediaType := types.DockerLayer
+		if opts.ImageFormat == config.OCIFormat {
+			layerMediaType = types.OCILayer
+		}
+		layerOpts = append(layerOpts, tarball.WithMediaType(layerMediaType))
+	}
 
 	layer, err := tarball.LayerFromFile(tarPath, layerOpts...)
 	if err != nil {
 		return err
 	}
 
-	cache, err := cache.Destination(opts, cacheKey)
-	if err != nil {
-		return errors.Wrap(err, "getting cache destination")
-	}
-	logrus.Infof("Pushing layer %s to cache now", cache)
 	empty := empty.Image
+	if opts.ImageFormat != "" {
+		manifestMediaType, configMediaType := formatMediaTypes(opts.ImageFormat)
+		empty = mutate.ConfigMediaType(mutate.MediaType(empty, manifestMediaType), configMediaType)
+	}
 	empty, err = mutate.CreatedAt(empty, v1.Time{Time: time.Now()})
 	if err != nil {
 		return errors.Wrap(err, "setting empty image created time")
@@ -397,9 +739,35 @@ func pushLayerToCache(opts *config.KanikoOptions, cacheKey string, tarPath strin
 	if err != nil {
 		return errors.Wrap(err, "appending layer onto empty image")
 	}
+	if opts.CacheExportDir != "" {
+		if err := (&cache.ExportedCache{Path: opts.CacheExportDir}).Put(cacheKey, empty); err != nil {
+			return err
+		}
+		if opts.NoPushCache {
+			return nil
+		}
+	}
+	if opts.CacheBackend != "" {
+		if opts.NoPushCache {
+			return nil
+		}
+		backend, err := cache.NewBackend(opts.CacheBackend, &opts.CacheOptions)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Storing layer %s in the %s cache backend", cacheKey, opts.CacheBackend)
+		return backend.Put(cacheKey, empty)
+	}
+
+	cache, err := cache.Destination(opts, cacheKey)
+	if err != nil {
+		return errors.Wrap(err, "getting cache destination")
+	}
+	logrus.Infof("Pushing layer %s to cache now", cache)
 	cacheOpts := *opts
 	cacheOpts.TarPath = ""              // tarPath doesn't make sense for Docker layers
 	cacheOpts.NoPush = opts.NoPushCache // we do not want to push cache if --no-push-cache is set.
+	cacheOpts.SyncExports = false       // the cache is no build output, syncing the layout per layer is too slow
 	cacheOpts.Destinations = []string{cache}
 	cacheOpts.InsecureRegistries = opts.InsecureRegistries
 	cacheOpts.SkipTLSVerifyRegistries = opts.SkipTLSVerifyRegistries
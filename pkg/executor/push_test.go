@@ -17,20 +17,36 @@ limitations under the License.
 package executor
 
 import (
+	"archive/tar"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/osscontainertools/kaniko/pkg/cache"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
@@ -223,6 +239,65 @@ func TestImageNameDigestFile(t *testing.T) {
 
 }
 
+func TestDoPushExpectedDigest(t *testing.T) {
+	// building the same image twice gives the same digest
+	build := func() v1.Image {
+		image, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{
+			Architecture: "amd64",
+			OS:           "linux",
+			Config:       v1.Config{Env: []string{"FOO=bar"}},
+		})
+		if err != nil {
+			t.Fatalf("could not create image: %s", err)
+		}
+		return image
+	}
+	digest, err := build().Digest()
+	if err != nil {
+		t.Fatalf("could not get image digest: %s", err)
+	}
+	other, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create image: %s", err)
+	}
+	otherDigest, err := other.Digest()
+	if err != nil {
+		t.Fatalf("could not get image digest: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		expected    string
+		shouldError bool
+	}{
+		{name: "matching digest", expected: digest.String()},
+		{name: "wrong digest", expected: otherDigest.String(), shouldError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			digestFile := filepath.Join(t.TempDir(), "digest")
+			opts := config.KanikoOptions{
+				NoPush:         true,
+				Destinations:   []string{"gcr.io/foo/bar:latest"},
+				DigestFile:     digestFile,
+				ExpectedDigest: tc.expected,
+			}
+			err := DoPush(build(), &opts)
+			testutil.CheckError(t, tc.shouldError, err)
+			if tc.shouldError {
+				if class := util.ClassifyError(err); class != util.ErrorClassUser {
+					t.Errorf("expected a user error, got %s", class)
+				}
+				if _, err := os.Stat(digestFile); !os.IsNotExist(err) {
+					t.Errorf("expected no digest file to be written, got %v", err)
+				}
+				return
+			}
+			got, err := os.ReadFile(digestFile)
+			testutil.CheckErrorAndDeepEqual(t, false, err, digest.String(), string(got))
+		})
+	}
+}
+
 func TestDoPushWithOpts(t *testing.T) {
 	tarPath := "image.tar"
 
@@ -505,3 +580,666 @@ func TestWriteDigestFile(t *testing.T) {
 		}
 	})
 }
+
+func TestDoPushConcurrency(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		concurrency int
+		failBlobs   bool
+	}{
+		{name: "serial", concurrency: 1},
+		{name: "parallel", concurrency: 3},
+		{name: "failing blob upload", concurrency: 3, failBlobs: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu              sync.Mutex
+				inFlight        int
+				maxInFlight     int
+				blobsDone       int
+				manifestPut     bool
+				blobAfterCommit bool
+			)
+			reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				isBlobUpload := strings.Contains(r.URL.Path, "/blobs/uploads/")
+				isManifestPut := r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/")
+				if isBlobUpload {
+					mu.Lock()
+					inFlight++
+					if inFlight > maxInFlight {
+						maxInFlight = inFlight
+					}
+					if manifestPut {
+						blobAfterCommit = true
+					}
+					mu.Unlock()
+					// give the other workers a chance to start their uploads
+					time.Sleep(20 * time.Millisecond)
+					defer func() {
+						mu.Lock()
+						inFlight--
+						blobsDone++
+						mu.Unlock()
+					}()
+					if tc.failBlobs {
+						http.Error(w, "upload failed", http.StatusBadRequest)
+						return
+					}
+				}
+				if isManifestPut {
+					mu.Lock()
+					manifestPut = true
+					mu.Unlock()
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			image, err := random.Image(1024, 8)
+			if err != nil {
+				t.Fatalf("could not create image: %s", err)
+			}
+			opts := &config.KanikoOptions{
+				RegistryOptions: config.RegistryOptions{PushConcurrency: tc.concurrency},
+				Destinations:    []string{strings.TrimPrefix(server.URL, "http://") + "/test:latest"},
+			}
+			err = DoPush(image, opts)
+			testutil.CheckError(t, tc.failBlobs, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if blobsDone == 0 {
+				t.Fatal("expected blobs to be uploaded")
+			}
+			if maxInFlight > tc.concurrency {
+				t.Errorf("expected at most %d concurrent blob uploads, got %d", tc.concurrency, maxInFlight)
+			}
+			if !tc.failBlobs && tc.concurrency > 1 && maxInFlight < 2 {
+				t.Errorf("expected blobs to be uploaded in parallel, got %d concurrent uploads", maxInFlight)
+			}
+			if blobAfterCommit {
+				t.Error("expected the manifest to be pushed after all blobs")
+			}
+			testutil.CheckDeepEqual(t, !tc.failBlobs, manifestPut)
+		})
+	}
+}
+
+func TestPushLayerToCacheCompression(t *testing.T) {
+	dir, err := os.MkdirTemp("", "kaniko-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tarPath := filepath.Join(dir, "layer.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	content := []byte("hello cache")
+	if err := tw.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	opts := &config.KanikoOptions{
+		CacheRepo:    "oci:" + filepath.Join(dir, "cache"),
+		CacheOptions: config.CacheOptions{CacheTTL: time.Hour},
+	}
+	tests := []struct {
+		key                       string
+		compression               config.Compression
+		cacheCompression          config.Compression
+		imageFormat               config.ImageFormat
+		expectedMediaType         types.MediaType
+		expectedManifestMediaType types.MediaType
+	}{
+		{key: "gzip", expectedMediaType: types.DockerLayer, expectedManifestMediaType: types.DockerManifestSchema2},
+		{key: "zstd", cacheCompression: config.ZStd, expectedMediaType: types.OCILayerZStd, expectedManifestMediaType: types.DockerManifestSchema2},
+		{key: "follows-compression", compression: config.ZStd, expectedMediaType: types.OCILayerZStd, expectedManifestMediaType: types.DockerManifestSchema2},
+		{key: "overrides-compression", compression: config.ZStd, cacheCompression: config.GZip, expectedMediaType: types.DockerLayer, expectedManifestMediaType: types.DockerManifestSchema2},
+		{key: "oci-format", imageFormat: config.OCIFormat, expectedMediaType: types.OCILayer, expectedManifestMediaType: types.OCIManifestSchema1},
+		{key: "oci-format-zstd", cacheCompression: config.ZStd, imageFormat: config.OCIFormat, expectedMediaType: types.OCILayerZStd, expectedManifestMediaType: types.OCIManifestSchema1},
+		{key: "docker-format", imageFormat: config.DockerFormat, expectedMediaType: types.DockerLayer, expectedManifestMediaType: types.DockerManifestSchema2},
+	}
+	for _, tc := range tests {
+		opts.Compression = tc.compression
+		opts.CacheCompression = tc.cacheCompression
+		opts.ImageFormat = tc.imageFormat
+		if err := pushLayerToCache(opts, tc.key, tarPath, "RUN echo "+tc.key); err != nil {
+			t.Fatalf("pushing %s: %v", tc.key, err)
+		}
+	}
+
+	// entries written with either compression are read back by their stored media type
+	lc := &cache.LayoutCache{Opts: opts}
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			img, err := lc.RetrieveLayer(tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifestMediaType, err := img.MediaType()
+			testutil.CheckErrorAndDeepEqual(t, false, err, tc.expectedManifestMediaType, manifestMediaType)
+			layers, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mt, err := layers[0].MediaType()
+			testutil.CheckErrorAndDeepEqual(t, false, err, tc.expectedMediaType, mt)
+			rc, err := layers[0].Uncompressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			tr := tar.NewReader(rc)
+			if _, err := tr.Next(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(tr)
+			testutil.CheckErrorAndDeepEqual(t, false, err, content, got)
+		})
+	}
+}
+
+func TestPushLayerToCacheBackend(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "layer.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// the layers are stored in the backend instead of the cache repo
+	opts := &config.KanikoOptions{
+		CacheRepo:    "oci:" + filepath.Join(dir, "cache"),
+		CacheOptions: config.CacheOptions{CacheDir: filepath.Join(dir, "backend"), CacheTTL: time.Hour, CacheBackend: cache.DirBackend},
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckNoError(t, pushLayerToCache(opts, "key", tarPath, "RUN echo"))
+	layerCache, err := newLayerCache(opts)
+	testutil.CheckNoError(t, err)
+	_, ok := layerCache.(*cache.BackendCache)
+	testutil.CheckDeepEqual(t, true, ok)
+	_, err = layerCache.RetrieveLayer("key")
+	testutil.CheckNoError(t, err)
+	if _, err := os.Stat(filepath.Join(dir, "cache")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written to the cache repo, got %v", err)
+	}
+
+	opts.CacheBackend = "unknown"
+	_, err = newLayerCache(opts)
+	testutil.CheckError(t, true, err)
+}
+
+func TestDoPushAllowedRegistries(t *testing.T) {
+	requests := 0
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &config.KanikoOptions{Destinations: []string{host + "/test:latest"}}
+	opts.AllowedRegistries = []string{"registry.example.com"}
+	err = DoPush(image, opts)
+	if !errors.Is(err, util.ErrRegistryNotAllowed) {
+		t.Fatalf("expected the push to be rejected, got %v", err)
+	}
+	err = CheckPushPermissions(opts)
+	if !errors.Is(err, util.ErrRegistryNotAllowed) {
+		t.Fatalf("expected the permission check to be rejected, got %v", err)
+	}
+	testutil.CheckDeepEqual(t, 0, requests)
+
+	opts.AllowedRegistries = []string{"registry.example.com", "127.0.0.1:*"}
+	testutil.CheckNoError(t, DoPush(image, opts))
+	if requests == 0 {
+		t.Fatal("expected the image to be pushed")
+	}
+}
+
+func TestDoPushDestinationAuths(t *testing.T) {
+	var mu sync.Mutex
+	authByRepo := map[string]map[string]bool{}
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if repo, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/"); ok {
+			mu.Lock()
+			if authByRepo[repo] == nil {
+				authByRepo[repo] = map[string]bool{}
+			}
+			authByRepo[repo][auth] = true
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	dir := t.TempDir()
+	basicFile := filepath.Join(dir, "basic.json")
+	if err := os.WriteFile(basicFile, []byte(`{"username": "alice", "password": "secret"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token.json")
+	if err := os.WriteFile(tokenFile, []byte(`{"token": "bobs-token"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		Destinations: []string{host + "/alice:latest", host + "/bob:latest"},
+		DestinationAuths: map[string]string{
+			host + "/alice:latest": basicFile,
+			host + "/bob:latest":   tokenFile,
+		},
+	}
+	testutil.CheckNoError(t, CheckPushPermissions(opts))
+	testutil.CheckNoError(t, DoPush(image, opts))
+
+	testutil.CheckDeepEqual(t, map[string]map[string]bool{
+		"alice": {"Basic YWxpY2U6c2VjcmV0": true},
+		"bob":   {"Bearer bobs-token": true},
+	}, authByRepo)
+
+	opts.DestinationAuths[host+"/bob:latest"] = filepath.Join(dir, "missing.json")
+	testutil.CheckError(t, true, DoPush(image, opts))
+}
+
+func TestDoPushDestinationPlatforms(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	opts := &config.KanikoOptions{
+		Destinations: []string{host + "/all:latest", host + "/amd64:latest"},
+		DestinationPlatforms: map[string]string{
+			host + "/amd64:latest": "linux/amd64",
+		},
+	}
+	pushed := map[string]v1.Hash{}
+	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+		image, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := image.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushed[platform] = digest
+		opts.CustomPlatform = platform
+		testutil.CheckNoError(t, CheckPushPermissions(opts))
+		testutil.CheckNoError(t, DoPush(image, opts))
+	}
+
+	digestOf := func(destination string) v1.Hash {
+		ref, err := name.NewTag(destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := remote.Head(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return desc.Digest
+	}
+	// the amd64 only destination didn't receive the arm64 build
+	testutil.CheckDeepEqual(t, pushed["linux/amd64"], digestOf(host+"/amd64:latest"))
+	testutil.CheckDeepEqual(t, pushed["linux/arm64"], digestOf(host+"/all:latest"))
+
+	// the platform of the image is the one of --image-arch
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.CustomPlatform, opts.ImageArch = "linux/arm64", "amd64"
+	testutil.CheckNoError(t, DoPush(image, opts))
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, digest, digestOf(host+"/amd64:latest"))
+	opts.ImageArch = ""
+
+	opts.DestinationPlatforms[host+"/amd64:latest"] = "not/a/valid/platform"
+	testutil.CheckError(t, true, DoPush(empty.Image, opts))
+}
+
+func TestDoPushMountFromCache(t *testing.T) {
+	var mu sync.Mutex
+	var mounted, uploaded []string
+	cacheBlobs := map[string]bool{}
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		digest := r.URL.Query().Get("digest")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v2/test/cache/blobs/uploads/") && r.Method == http.MethodPut:
+			cacheBlobs[digest] = true
+		case strings.HasPrefix(r.URL.Path, "/v2/test/blobs/") && r.Method == http.MethodHead:
+			// blobs are only known to the cache repo
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case r.URL.Path == "/v2/test/blobs/uploads/" && r.Method == http.MethodPost:
+			if mount := r.URL.Query().Get("mount"); r.URL.Query().Get("from") == "test/cache" && cacheBlobs[mount] {
+				mounted = append(mounted, mount)
+				w.Header().Set("Location", "/v2/test/blobs/"+mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		case strings.HasPrefix(r.URL.Path, "/v2/test/blobs/uploads/") && r.Method == http.MethodPut:
+			uploaded = append(uploaded, digest)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	shared, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedDigest, err := shared.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := mutate.AppendLayers(empty.Image, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(mustTag(t, host+"/test/cache:shared"), cached); err != nil {
+		t.Fatal(err)
+	}
+	unique, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uniqueDigest, err := unique.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := mutate.AppendLayers(empty.Image, shared, unique)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mount := range []bool{true, false} {
+		t.Run(fmt.Sprintf("mount %v", mount), func(t *testing.T) {
+			mounted, uploaded = nil, nil
+			opts := &config.KanikoOptions{
+				Destinations:       []string{fmt.Sprintf("%s/test:mount-%v", host, mount)},
+				Cache:              true,
+				PushMountFromCache: mount,
+			}
+			testutil.CheckNoError(t, DoPush(image, opts))
+
+			if mount {
+				testutil.CheckDeepEqual(t, []string{sharedDigest.String()}, mounted)
+				if slices.Contains(uploaded, sharedDigest.String()) {
+					t.Errorf("expected the cached layer to be mounted instead of uploaded")
+				}
+			} else {
+				testutil.CheckDeepEqual(t, 0, len(mounted))
+				if !slices.Contains(uploaded, sharedDigest.String()) {
+					t.Errorf("expected the cached layer to be uploaded")
+				}
+			}
+			// layers missing from the cache repo are uploaded
+			if !slices.Contains(uploaded, uniqueDigest.String()) {
+				t.Errorf("expected the layer missing from the cache to be uploaded")
+			}
+		})
+	}
+}
+
+func TestDoPush_SyncExports(t *testing.T) {
+	original := syncPath
+	defer func() { syncPath = original }()
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create image: %s", err)
+	}
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sync %v", enabled), func(t *testing.T) {
+			var synced []string
+			syncPath = func(path string) error {
+				synced = append(synced, path)
+				return original(path)
+			}
+			dir := t.TempDir()
+			opts := config.KanikoOptions{
+				NoPush:        true,
+				TarPath:       filepath.Join(dir, "image.tar"),
+				OCILayoutPath: filepath.Join(dir, "layout"),
+				DigestFile:    filepath.Join(dir, "digest"),
+				SyncExports:   enabled,
+			}
+			testutil.CheckNoError(t, DoPush(image, &opts))
+			var expected []string
+			if enabled {
+				expected = []string{opts.DigestFile, opts.OCILayoutPath, opts.TarPath}
+			}
+			testutil.CheckDeepEqual(t, expected, synced)
+
+			// the synced outputs are complete
+			_, err := tarball.ImageFromPath(opts.TarPath, nil)
+			testutil.CheckNoError(t, err)
+			_, err = layout.ImageIndexFromPath(opts.OCILayoutPath)
+			testutil.CheckNoError(t, err)
+		})
+	}
+}
+
+func TestDoPushAtomic(t *testing.T) {
+	failTag := ""
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failTag != "" && r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/"+failTag) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	existing, err := name.NewTag(host + "/app:existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(existing, previous); err != nil {
+		t.Fatal(err)
+	}
+	previousDigest, err := previous.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		Destinations: []string{existing.String(), host + "/app:new", host + "/app:failing"},
+	}
+	opts.PushAtomic = true
+
+	failTag = "failing"
+	testutil.CheckError(t, true, DoPush(image, opts))
+	desc, err := remote.Head(existing)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, previousDigest, desc.Digest)
+	for _, tag := range []string{"new", "failing"} {
+		if _, err := remote.Head(existing.Context().Tag(tag)); err == nil {
+			t.Errorf("expected tag %s to be rolled back", tag)
+		}
+	}
+
+	failTag = ""
+	testutil.CheckNoError(t, DoPush(image, opts))
+	for _, dest := range opts.Destinations {
+		ref, err := name.NewTag(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := remote.Head(ref)
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, digest, desc.Digest)
+	}
+}
+
+func TestDoPushSkipUnchanged(t *testing.T) {
+	var manifestPuts []string
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			manifestPuts = append(manifestPuts, path.Base(r.URL.Path))
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := name.NewTag(host + "/app:current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(current, image); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, atomic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("atomic=%t", atomic), func(t *testing.T) {
+			manifestPuts = nil
+			opts := &config.KanikoOptions{Destinations: []string{current.String()}}
+			opts.PushSkipUnchanged = true
+			opts.PushAtomic = atomic
+			testutil.CheckNoError(t, DoPush(image, opts))
+			testutil.CheckDeepEqual(t, []string(nil), manifestPuts)
+
+			// a tag pointing at another image is pushed
+			other := host + "/app:other"
+			if err := remote.Write(current.Context().Tag("other"), empty.Image); err != nil {
+				t.Fatal(err)
+			}
+			manifestPuts = nil
+			opts.Destinations = []string{current.String(), other}
+			testutil.CheckNoError(t, DoPush(image, opts))
+			testutil.CheckDeepEqual(t, true, len(manifestPuts) > 0)
+			for _, tag := range manifestPuts {
+				if tag == "current" {
+					t.Errorf("expected the up to date tag current not to be pushed, got %v", manifestPuts)
+				}
+			}
+		})
+	}
+}
+
+func TestDoPushRegistryHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var userAgents, teams []string
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.UserAgent())
+		teams = append(teams, r.Header.Get("X-Team"))
+		mu.Unlock()
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{Destinations: []string{host + "/app:latest"}}
+	opts.RegistryHeaders = map[string]string{"X-Team": "builds"}
+	opts.RegistryUserAgent = "corp-builder/1.0"
+	testutil.CheckNoError(t, DoPush(image, opts))
+
+	if len(userAgents) == 0 {
+		t.Fatal("expected requests to the registry")
+	}
+	for i := range userAgents {
+		testutil.CheckDeepEqual(t, "corp-builder/1.0", userAgents[i])
+		testutil.CheckDeepEqual(t, "builds", teams[i])
+	}
+}
+
+func TestDoPushRewriteReference(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		RegistryOptions: config.RegistryOptions{
+			ReferenceRewrites: map[string]string{"docker.io/library": host + "/mirror"},
+		},
+		Destinations: []string{"docker.io/library/alpine:pushed"},
+	}
+	testutil.CheckNoError(t, CheckPushPermissions(opts))
+	testutil.CheckNoError(t, DoPush(image, opts))
+
+	ref, err := name.NewTag(host+"/mirror/alpine:pushed", name.WeakValidation)
+	testutil.CheckNoError(t, err)
+	pushed, err := remote.Image(ref)
+	testutil.CheckNoError(t, err)
+	want, err := image.Digest()
+	testutil.CheckNoError(t, err)
+	got, err := pushed.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, want, got)
+}
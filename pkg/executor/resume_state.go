@@ -0,0 +1,227 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// resumeState is the file written with --resume-state. It records the stages of
+// a build that completed, so that a rerun of the build after it failed or was
+// interrupted skips the stages whose inputs are unchanged.
+type resumeState struct {
+	Stages []completedStage `json:"stages"`
+}
+
+// completedStage is a stage recorded in the resume state. Key is the hash of its
+// inputs, Digest and CacheKey are those of its image, which later stages map their
+// base image to. Deps and Saved are the digests of the files the stage saved for
+// later stages and of its image saved for the stages based on it.
+type completedStage struct {
+	Index    int    `json:"index"`
+	Key      string `json:"key"`
+	Digest   string `json:"digest"`
+	CacheKey string `json:"cacheKey"`
+	Deps     string `json:"deps"`
+	Saved    string `json:"saved,omitempty"`
+}
+
+// loadResumeState reads the resume state at path. A missing or unreadable state
+// means no stage is skipped.
+func loadResumeState(path string) *resumeState {
+	state := &resumeState{}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state
+	}
+	if err == nil {
+		err = json.Unmarshal(b, state)
+	}
+	if err != nil {
+		logrus.Warnf("Ignoring resume state %s: %v", path, err)
+		return &resumeState{}
+	}
+	return state
+}
+
+// completed returns the recorded stage at index if it completed with the inputs
+// key and the files it saved are still in the kaniko directory, unchanged.
+func (r *resumeState) completed(stage config.KanikoStage, key string) (completedStage, bool) {
+	for _, done := range r.Stages {
+		if done.Index != stage.Index {
+			continue
+		}
+		if done.Key != key {
+			logrus.Infof("Inputs of stage %d changed since it completed, building it", stage.Index)
+			return completedStage{}, false
+		}
+		deps, err := savedFilesDigest(filepath.Join(config.KanikoInterStageDepsDir, strconv.Itoa(stage.Index)))
+		if err != nil || deps != done.Deps {
+			logrus.Infof("Files saved by stage %d are missing or changed, building it", stage.Index)
+			return completedStage{}, false
+		}
+		if stage.SaveStage {
+			saved, err := savedFilesDigest(filepath.Join(config.KanikoIntermediateStagesDir, strconv.Itoa(stage.Index)))
+			if err != nil || saved != done.Saved {
+				logrus.Infof("Saved image of stage %d is missing or changed, building it", stage.Index)
+				return completedStage{}, false
+			}
+		}
+		return done, true
+	}
+	return completedStage{}, false
+}
+
+// record adds the completed stage to the state, replacing an earlier record of it,
+// and writes the state to path.
+func (r *resumeState) record(stage config.KanikoStage, key, digest, cacheKey, path string) (err error) {
+	done := completedStage{Index: stage.Index, Key: key, Digest: digest, CacheKey: cacheKey}
+	if done.Deps, err = savedFilesDigest(filepath.Join(config.KanikoInterStageDepsDir, strconv.Itoa(stage.Index))); err != nil {
+		return errors.Wrapf(err, "hashing the files saved by stage %d", stage.Index)
+	}
+	if stage.SaveStage {
+		if done.Saved, err = savedFilesDigest(filepath.Join(config.KanikoIntermediateStagesDir, strconv.Itoa(stage.Index))); err != nil {
+			return errors.Wrapf(err, "hashing the saved image of stage %d", stage.Index)
+		}
+	}
+	stages := []completedStage{done}
+	for _, s := range r.Stages {
+		if s.Index != stage.Index {
+			stages = append(stages, s)
+		}
+	}
+	sort.Slice(stages, func(i, j int) bool { return stages[i].Index < stages[j].Index })
+	r.Stages = stages
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "creating directory for resume state")
+	}
+	// the state is replaced at once, an interrupted write leaves the previous one
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "writing resume state")
+	}
+	return os.Rename(tmp, path)
+}
+
+// savedFilesDigest returns a hash of the paths, modes, link targets and contents
+// of the files under path.
+func savedFilesDigest(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%v\x00", rel, fi.Mode())
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			h.Write([]byte(target))
+		case fi.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		h.Write([]byte{'\n'})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// inputKey returns the hash of the inputs of the stage for --resume-state: the
+// composite keys of its commands, which cover its base image, the build args and
+// the files it copies, and the files later stages use from it. It also returns the
+// build args after the commands of the stage, which a skipped stage passes on.
+func (s *stageBuilder) inputKey(deps []string) (string, *dockerfile.BuildArgs, error) {
+	var buildArgs = s.args.Clone()
+	// Restore build args back to their original values
+	defer func() {
+		s.args = buildArgs
+		s.argsBeforeScope = nil
+	}()
+
+	compositeKey := *s.initialCompositeKey()
+	cfg := s.cf.Config
+	for i, command := range s.cmds {
+		if command == nil {
+			continue
+		}
+		args := s.argsFor(i)
+		files, err := filesUsedFromContext(command, &cfg, args)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "failed to get files used from context")
+		}
+		compositeKey, err = s.populateCompositeKey(command, files, compositeKey, args, cfg.Env)
+		if err != nil {
+			return "", nil, err
+		}
+		if command.MetadataOnly() {
+			if err := command.ExecuteCommand(&cfg, args); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	s.endArgScope()
+	after := s.args.Clone()
+
+	compositeKey.AddKey(fmt.Sprintf("|deps=%d", len(deps)))
+	compositeKey.AddKey(deps...)
+	if s.stage.SaveStage {
+		compositeKey.AddKey("|saved")
+	}
+	key, err := compositeKey.Hash()
+	if err != nil {
+		return "", nil, err
+	}
+	return key, after, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDoBuild_ResumeState(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	workspace := filepath.Join(testDir, "workspace")
+	dockerFile := `
+FROM scratch AS first
+COPY foo/bam.txt first/
+FROM scratch
+COPY --from=first first/bam.txt second/
+COPY late.txt second/`
+	if err := os.WriteFile(filepath.Join(workspace, "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	// build returns the error of the build and the stages it skipped
+	build := func() (error, []string) {
+		hook := logrustest.NewGlobal()
+		defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		_, err := DoBuild(&config.KanikoOptions{
+			DockerfilePath:  filepath.Join(workspace, "Dockerfile"),
+			SrcContext:      workspace,
+			SnapshotMode:    constants.SnapshotModeFull,
+			ResumeStatePath: statePath,
+			PreserveContext: true,
+		})
+		var skipped []string
+		for _, e := range hook.AllEntries() {
+			if strings.HasPrefix(e.Message, "Skipping stage") {
+				skipped = append(skipped, e.Message)
+			}
+		}
+		return err, skipped
+	}
+
+	// the second stage fails as late.txt is missing, the first one is recorded
+	err, skipped := build()
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, 0, len(skipped))
+	state := loadResumeState(statePath)
+	testutil.CheckDeepEqual(t, 1, len(state.Stages))
+	testutil.CheckDeepEqual(t, 0, state.Stages[0].Index)
+
+	// the first stage is built again as the file it copies changed
+	if err := os.WriteFile(filepath.Join(workspace, "foo", "bam.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err, skipped = build()
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, 0, len(skipped))
+
+	// the rerun skips the first stage and builds the second one
+	if err := os.WriteFile(filepath.Join(workspace, "late.txt"), []byte("late"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err, skipped = build()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string{"Skipping stage 0, it completed with the same inputs before"}, skipped)
+	b, err := os.ReadFile(filepath.Join(testDir, "second", "bam.txt"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "changed", string(b))
+	if _, err := os.Stat(filepath.Join(testDir, "second", "late.txt")); err != nil {
+		t.Errorf("expected the second stage to copy late.txt: %v", err)
+	}
+
+	// there is nothing to resume once the build completed
+	_, err = os.Stat(statePath)
+	testutil.CheckDeepEqual(t, true, os.IsNotExist(err))
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// readRootFSManifest reads a manifest in the format of sha256sum, ie. `<hex digest>  <path>`
+// per line, paths are relative to the root of the filesystem.
+func readRootFSManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening rootfs manifest")
+	}
+	defer f.Close()
+
+	digests := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, file, ok := strings.Cut(line, " ")
+		file = strings.TrimPrefix(strings.TrimLeft(file, " "), "*")
+		if _, err := hex.DecodeString(digest); !ok || err != nil || len(digest) != sha256.Size*2 || file == "" {
+			return nil, fmt.Errorf("invalid line %d of rootfs manifest %s, expected `<sha256 digest>  <path>`", n, path)
+		}
+		digests[filepath.Join("/", filepath.Clean(file))] = strings.ToLower(digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading rootfs manifest")
+	}
+	return digests, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyRootFS compares the regular files of the filesystem at root with the manifest at
+// manifestPath. Files with another digest, files missing from the filesystem and files that
+// are not in the manifest fail the verification, unless they are one of the allowed paths
+// or below one. Paths of the ignore list are not verified.
+func verifyRootFS(root, manifestPath string, allowed []string) error {
+	expected, err := readRootFSManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	isAllowed := func(p string) bool {
+		for _, a := range allowed {
+			a = filepath.Join("/", filepath.Clean(a))
+			if p == a || a == "/" || strings.HasPrefix(p, a+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var problems []string
+	seen := map[string]bool{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && util.CheckIgnoreList(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		p := filepath.Join("/", rel)
+		if isAllowed(p) {
+			return nil
+		}
+		seen[p] = true
+		want, ok := expected[p]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not in the manifest", p))
+			return nil
+		}
+		got, err := sha256File(path)
+		if err != nil {
+			return errors.Wrapf(err, "hashing %s", p)
+		}
+		if got != want {
+			problems = append(problems, fmt.Sprintf("%s has digest sha256:%s, expected sha256:%s", p, got, want))
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "walking the filesystem")
+	}
+	for p := range expected {
+		if !seen[p] && !isAllowed(p) {
+			problems = append(problems, fmt.Sprintf("%s is missing", p))
+		}
+	}
+	if len(problems) == 0 {
+		logrus.Infof("Verified the filesystem against %s", manifestPath)
+		return nil
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		logrus.Errorf("Filesystem verification: %s", p)
+	}
+	return fmt.Errorf("the filesystem does not match %s: %s", manifestPath, strings.Join(problems, "; "))
+}
+
+// verifyFinalRootFS verifies the filesystem of the final stage if --rootfs-manifest-verify is set.
+func verifyFinalRootFS(opts *config.KanikoOptions) error {
+	if opts.RootFSManifestVerify == "" {
+		return nil
+	}
+	return verifyRootFS(config.RootDir, opts.RootFSManifestVerify, opts.RootFSManifestAllow)
+}
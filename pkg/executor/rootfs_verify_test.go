@@ -0,0 +1,155 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestVerifyRootFS(t *testing.T) {
+	files := map[string]string{
+		"bin/app":         "app",
+		"etc/app.conf":    "conf",
+		"var/log/app.log": "log",
+	}
+	digest := func(content string) string {
+		h := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(h[:])
+	}
+
+	for _, tc := range []struct {
+		name     string
+		manifest []string
+		tamper   map[string]string
+		allowed  []string
+		wantErr  string
+	}{
+		{
+			name: "matching manifest",
+			manifest: []string{
+				"# generated with sha256sum",
+				digest("app") + "  ./bin/app",
+				digest("conf") + " */etc/app.conf",
+			},
+			allowed: []string{"/var/log"},
+		},
+		{
+			name:     "tampered file",
+			manifest: []string{digest("app") + "  bin/app", digest("conf") + "  etc/app.conf", digest("log") + "  var/log/app.log"},
+			tamper:   map[string]string{"etc/app.conf": "tampered"},
+			wantErr:  "/etc/app.conf has digest sha256:" + digest("tampered"),
+		},
+		{
+			name:     "unexpected file",
+			manifest: []string{digest("app") + "  bin/app", digest("conf") + "  etc/app.conf"},
+			wantErr:  "/var/log/app.log is not in the manifest",
+		},
+		{
+			name:     "missing file",
+			manifest: []string{digest("app") + "  bin/app", digest("conf") + "  etc/app.conf", digest("x") + "  etc/missing"},
+			allowed:  []string{"/var/log"},
+			wantErr:  "/etc/missing is missing",
+		},
+		{
+			name:     "allowed missing file",
+			manifest: []string{digest("app") + "  bin/app", digest("conf") + "  etc/app.conf", digest("x") + "  var/log/old.log"},
+			allowed:  []string{"/var/log"},
+		},
+		{
+			name:     "invalid manifest",
+			manifest: []string{"not a digest  bin/app"},
+			wantErr:  "invalid line 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range files {
+				if c, ok := tc.tamper[name]; ok {
+					content = c
+				}
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			manifest := filepath.Join(t.TempDir(), "rootfs.sha256")
+			if err := os.WriteFile(manifest, []byte(strings.Join(tc.manifest, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := verifyRootFS(root, manifest, tc.allowed)
+			testutil.CheckError(t, tc.wantErr != "", err)
+			if tc.wantErr != "" && !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDoBuild_RootFSManifestVerify(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt copied/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(testDir, "workspace", "foo", "bam.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(content)
+	manifest := filepath.Join(t.TempDir(), "rootfs.sha256")
+	if err := os.WriteFile(manifest, []byte(fmt.Sprintf("%x  copied/bam.txt\n", h)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		allowed []string
+		wantErr bool
+	}{
+		{name: "unexpected files", wantErr: true},
+		{name: "matching", allowed: []string{"/workspace", "/kaniko"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &config.KanikoOptions{
+				DockerfilePath:       filepath.Join(testDir, "workspace", "Dockerfile"),
+				SrcContext:           filepath.Join(testDir, "workspace"),
+				SnapshotMode:         constants.SnapshotModeFull,
+				RootFSManifestVerify: manifest,
+				RootFSManifestAllow:  tc.allowed,
+			}
+			_, err := DoBuild(opts)
+			testutil.CheckError(t, tc.wantErr, err)
+		})
+	}
+}
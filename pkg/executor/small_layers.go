@@ -0,0 +1,75 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/go-units"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
+	"github.com/pkg/errors"
+)
+
+// checkSmallLayers warns if count or more of the layers the build added to image,
+// those after its baseLayers, are smaller than size, see --warn-small-layers. Each
+// layer costs a round trip to push and pull and an overlay to mount, their
+// commands can often be combined into one.
+func checkSmallLayers(image v1.Image, baseLayers int, count int, size string) error {
+	if size == "" {
+		size = constants.DefaultSmallLayerSize
+	}
+	limit, err := units.RAMInBytes(size)
+	if err != nil || limit <= 0 {
+		return fmt.Errorf("--small-layer-size must be a positive size, got %q", size)
+	}
+	layers, err := imageLayerSizes(image)
+	if err != nil {
+		return errors.Wrap(err, "getting the size of the image")
+	}
+	if baseLayers < len(layers) {
+		layers = layers[baseLayers:]
+	} else {
+		layers = nil
+	}
+	var small []imageLayerSize
+	for _, l := range layers {
+		if l.size < limit {
+			small = append(small, l)
+		}
+	}
+	if len(small) < count {
+		return nil
+	}
+
+	var report strings.Builder
+	for i, l := range small {
+		if i == largestLayersReported {
+			fmt.Fprintf(&report, "\n  ... and %d more", len(small)-i)
+			break
+		}
+		createdBy := l.createdBy
+		if createdBy == "" {
+			createdBy = "<unknown>"
+		}
+		fmt.Fprintf(&report, "\n  %10s  %s", units.HumanSize(float64(l.size)), createdBy)
+	}
+	diagnostics.Warnf(diagnostics.SmallLayers, "%d of the %d layers the build added are smaller than %s, consider combining their commands, ie. RUN commands with &&:%s", len(small), len(layers), size, report.String())
+	return nil
+}
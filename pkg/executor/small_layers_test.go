@@ -0,0 +1,76 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func TestDoBuild_WarnSmallLayers(t *testing.T) {
+	build := func(t *testing.T, dockerFile string) []diagnostics.Diagnostic {
+		t.Helper()
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		diagnostics.Reset()
+		defer diagnostics.Reset()
+		_, err := DoBuild(&config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			WarnSmallLayers: 3,
+		})
+		testutil.CheckNoError(t, err)
+		var warnings []diagnostics.Diagnostic
+		for _, d := range diagnostics.All() {
+			if d.Code == diagnostics.SmallLayers {
+				warnings = append(warnings, d)
+			}
+		}
+		return warnings
+	}
+
+	t.Run("many tiny layers", func(t *testing.T) {
+		warnings := build(t, `
+FROM scratch
+COPY foo/bam.txt a/
+COPY exec b/
+COPY foo/bam.txt c/
+COPY exec d/`)
+		testutil.CheckDeepEqual(t, 1, len(warnings))
+		msg := warnings[0].Message
+		if !strings.Contains(msg, "4 of the 4 layers the build added are smaller than 10KB") || !strings.Contains(msg, "COPY exec d/") {
+			t.Errorf("expected the advisory to count the small layers and name their commands, got %s", msg)
+		}
+	})
+	t.Run("compact build", func(t *testing.T) {
+		warnings := build(t, `
+FROM scratch
+COPY foo/bam.txt exec a/`)
+		testutil.CheckDeepEqual(t, 0, len(warnings))
+	})
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// Snapshotter takes the snapshots of the filesystem the layers of a stage are made of.
+// The default one walks the filesystem and compares it to the previous snapshot,
+// others can be registered with RegisterSnapshotter and chosen with --snapshotter.
+type Snapshotter interface {
+	// Init records the filesystem the next snapshot is compared to, it is called
+	// before the first command that doesn't tell which files it changes, like RUN.
+	Init() error
+	// TakeSnapshotFS returns the path of a tarball with all changes of the
+	// filesystem since the last snapshot, or "" if there are none.
+	TakeSnapshotFS() (string, error)
+	// TakeSnapshot returns the path of a tarball with the changes of files, which
+	// are the FilesToSnapshot of the commands since the last snapshot, or "" if
+	// there are none. With shdCheckDelete it looks for deleted files as well.
+	TakeSnapshot(files []string, shdCheckDelete bool) (string, error)
+	// Paths returns the paths of the filesystem as of the last snapshot, they
+	// are used to tell added from modified files by --print-layer-diffs.
+	Paths() map[string]struct{}
+}
+
+// StreamingSnapshotter is a Snapshotter that can also snapshot the filesystem
+// without writing the tarball to disk, see --stream-layers.
+type StreamingSnapshotter interface {
+	Snapshotter
+	// StreamSnapshotFS returns a function that generates the tarball TakeSnapshotFS
+	// would write each time it is called.
+	StreamSnapshotFS() (func() (io.ReadCloser, error), error)
+}
+
+// SnapshotterFactory returns a Snapshotter for a stage built with opts.
+type SnapshotterFactory func(opts *config.KanikoOptions) (Snapshotter, error)
+
+var (
+	snapshottersMu sync.RWMutex
+	snapshotters   = map[string]SnapshotterFactory{}
+)
+
+// RegisterSnapshotter makes a snapshotter available under name for --snapshotter,
+// builds of kaniko with other snapshotters call it from an init function.
+// It panics if name is empty or registered already.
+func RegisterSnapshotter(name string, factory SnapshotterFactory) {
+	snapshottersMu.Lock()
+	defer snapshottersMu.Unlock()
+	if name == "" || factory == nil {
+		panic("executor: RegisterSnapshotter needs a name and a factory")
+	}
+	if _, ok := snapshotters[name]; ok {
+		panic(fmt.Sprintf("executor: snapshotter %s is registered twice", name))
+	}
+	snapshotters[name] = factory
+}
+
+// newSnapshotter returns the snapshotter set with --snapshotter, the one walking
+// the filesystem by default.
+func newSnapshotter(opts *config.KanikoOptions) (Snapshotter, error) {
+	if opts.Snapshotter == "" {
+		return makeSnapshotter(opts)
+	}
+	snapshottersMu.RLock()
+	factory, ok := snapshotters[opts.Snapshotter]
+	snapshottersMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unknown snapshotter %q, registered are %v", opts.Snapshotter, registeredSnapshotters())
+	}
+	return factory(opts)
+}
+
+func registeredSnapshotters() []string {
+	snapshottersMu.RLock()
+	defer snapshottersMu.RUnlock()
+	names := make([]string, 0, len(snapshotters))
+	for name := range snapshotters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+// recordingSnapshotter records the files it is asked to snapshot and leaves
+// the snapshots to the default snapshotter.
+type recordingSnapshotter struct {
+	Snapshotter
+	files [][]string
+}
+
+func (r *recordingSnapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string, error) {
+	r.files = append(r.files, files)
+	return r.Snapshotter.TakeSnapshot(files, shdCheckDelete)
+}
+
+func TestDoBuild_Snapshotter(t *testing.T) {
+	var recorder *recordingSnapshotter
+	RegisterSnapshotter(t.Name(), func(opts *config.KanikoOptions) (Snapshotter, error) {
+		snapshotter, err := makeSnapshotter(opts)
+		if err != nil {
+			return nil, err
+		}
+		recorder = &recordingSnapshotter{Snapshotter: snapshotter}
+		return recorder, nil
+	})
+	defer func() {
+		snapshottersMu.Lock()
+		delete(snapshotters, t.Name())
+		snapshottersMu.Unlock()
+	}()
+
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt out/
+COPY exec out/bin/`
+	if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		Snapshotter:    t.Name(),
+	}
+	image, err := DoBuild(opts)
+	testutil.CheckNoError(t, err)
+	if recorder == nil {
+		t.Fatal("expected the registered snapshotter to be used")
+	}
+	testutil.CheckDeepEqual(t, [][]string{
+		{filepath.Join(config.RootDir, "out", "bam.txt")},
+		{filepath.Join(config.RootDir, "out", "bin", "exec")},
+	}, recorder.files)
+	layers, err := image.Layers()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(layers))
+
+	opts.Snapshotter = "missing"
+	_, err = DoBuild(opts)
+	testutil.CheckError(t, true, err)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/osscontainertools/kaniko/pkg/commands"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// splitCopyFiles returns the files a COPY copied in groups that add up to at most
+// the size of --split-copy-layers, each of which is snapshotted to a layer of its
+// own. A file larger than the size is a group by itself. The files are grouped by
+// path, so the same files always end up in the same layers. It returns nil if the
+// layer of the command isn't split.
+func (s *stageBuilder) splitCopyFiles(command commands.DockerCommand, files []string) ([][]string, error) {
+	if s.opts.SplitCopyLayers == "" || s.opts.SingleSnapshot || files == nil || command.ShouldCacheOutput() {
+		return nil, nil
+	}
+	if _, ok := command.(*commands.CopyCommand); !ok {
+		return nil, nil
+	}
+	limit, err := units.RAMInBytes(s.opts.SplitCopyLayers)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("--split-copy-layers must be a positive size, got %q", s.opts.SplitCopyLayers)
+	}
+	return groupFilesBySize(files, limit), nil
+}
+
+// groupFilesBySize groups the sorted files so that the regular files of each group
+// add up to at most limit bytes.
+func groupFilesBySize(files []string, limit int64) [][]string {
+	sorted := append([]string{}, files...)
+	sort.Strings(sorted)
+	var groups [][]string
+	var group []string
+	var size int64
+	for _, file := range sorted {
+		var fileSize int64
+		if fi, err := os.Lstat(file); err == nil && fi.Mode().IsRegular() {
+			fileSize = fi.Size()
+		}
+		if len(group) > 0 && size+fileSize > limit {
+			groups = append(groups, group)
+			group, size = nil, 0
+		}
+		group = append(group, file)
+		size += fileSize
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// saveSplitSnapshotsToImage adds a layer for each group of files of a COPY to the image.
+func (s *stageBuilder) saveSplitSnapshotsToImage(createdBy string, groups [][]string) error {
+	logrus.Infof("Splitting the layer of %s into %d layers to fit --split-copy-layers=%s", createdBy, len(groups), s.opts.SplitCopyLayers)
+	for i, group := range groups {
+		if i == 0 {
+			// volumes are snapshotted with the next command, once is enough
+			group = append(group, util.Volumes()...)
+		}
+		var before map[string]struct{}
+		if s.opts.PrintLayerDiffs {
+			before = s.snapshotter.Paths()
+		}
+		tarPath, err := s.snapshotter.TakeSnapshot(group, false)
+		if err != nil {
+			return errors.Wrap(err, "failed to take snapshot")
+		}
+		if s.opts.PrintLayerDiffs {
+			printLayerDiff(createdBy, func() (io.ReadCloser, error) { return os.Open(tarPath) }, before)
+		}
+		if err := s.saveSnapshotToImage(createdBy, tarPath); err != nil {
+			return errors.Wrap(err, "failed to save snapshot to image")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_groupFilesBySize(t *testing.T) {
+	dir := t.TempDir()
+	sizes := map[string]int{"a": 100, "b": 100, "c": 300, "d": 50, "e": 50}
+	var files []string
+	for name, size := range sizes {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	// the directory counts for nothing
+	files = append(files, dir)
+
+	in := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		return paths
+	}
+	testutil.CheckDeepEqual(t, [][]string{
+		append([]string{dir}, in("a", "b")...),
+		// larger than the limit on its own
+		in("c"),
+		in("d", "e"),
+	}, groupFilesBySize(files, 200))
+	testutil.CheckDeepEqual(t, [][]string{append([]string{dir}, in("a", "b", "c", "d", "e")...)}, groupFilesBySize(files, 1000))
+}
+
+func TestDoBuild_SplitCopyLayers(t *testing.T) {
+	build := func(t *testing.T, split string) v1.Image {
+		testDir, fn := setupMultistageTests(t)
+		t.Cleanup(fn)
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			path := filepath.Join(testDir, "workspace", "tree", name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(strings.Repeat(name, 100)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dockerFile := "FROM scratch\nCOPY tree /tree/"
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		image, err := DoBuild(&config.KanikoOptions{
+			DockerfilePath:  filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:      filepath.Join(testDir, "workspace"),
+			SnapshotMode:    constants.SnapshotModeFull,
+			SplitCopyLayers: split,
+		})
+		testutil.CheckNoError(t, err)
+		return image
+	}
+	// contents returns the files of the image and the paths in each of its layers
+	contents := func(t *testing.T, image v1.Image) (map[string]string, [][]string) {
+		layers, err := image.Layers()
+		testutil.CheckNoError(t, err)
+		files := map[string]string{}
+		var grouping [][]string
+		for _, l := range layers {
+			rc, err := l.Uncompressed()
+			testutil.CheckNoError(t, err)
+			var paths []string
+			for name, content := range readTarEntries(t, rc) {
+				files[filepath.Clean(name)] = content
+				paths = append(paths, filepath.Clean(name))
+			}
+			rc.Close()
+			sort.Strings(paths)
+			grouping = append(grouping, paths)
+		}
+		return files, grouping
+	}
+
+	whole, wholeGrouping := contents(t, build(t, ""))
+	testutil.CheckDeepEqual(t, 1, len(wholeGrouping))
+	split, grouping := contents(t, build(t, "250B"))
+	testutil.CheckDeepEqual(t, [][]string{
+		{"/", "tree", "tree/a", "tree/b"},
+		{"/", "tree", "tree/c", "tree/d"},
+		{"/", "tree", "tree/e"},
+	}, grouping)
+	// the filesystem is the same, only spread over more layers
+	testutil.CheckDeepEqual(t, whole, split)
+	// the files are grouped the same way by every build
+	_, again := contents(t, build(t, "250B"))
+	testutil.CheckDeepEqual(t, grouping, again)
+
+	cf, err := build(t, "250B").ConfigFile()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 3, len(cf.History))
+	for _, h := range cf.History {
+		testutil.CheckDeepEqual(t, "COPY tree /tree/", h.CreatedBy)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/timing"
+	"github.com/sirupsen/logrus"
+)
+
+// streamedLayer is a layer whose tar is generated each time it is read instead of
+// being stored on disk, see --stream-layers. Pushing it again, ie. on a retry,
+// generates the tar again, so it must not change between reads.
+type streamedLayer struct {
+	open      func() (io.ReadCloser, error)
+	level     int
+	mediaType types.MediaType
+
+	once   sync.Once
+	err    error
+	digest v1.Hash
+	diffID v1.Hash
+	size   int64
+}
+
+var _ v1.Layer = (*streamedLayer)(nil)
+
+// newStreamedLayer returns a gzip compressed layer of the tar generated by open,
+// a level of 0 compresses it like the layers written to disk.
+func newStreamedLayer(open func() (io.ReadCloser, error), level int, mediaType types.MediaType) *streamedLayer {
+	if level == 0 {
+		level = gzip.BestSpeed
+	}
+	return &streamedLayer{open: open, level: level, mediaType: mediaType}
+}
+
+// compute computes the digest, the diff id and the size of the layer in a single
+// pass over the tar, the first time any of them is needed.
+func (l *streamedLayer) compute() error {
+	l.once.Do(func() {
+		t := timing.Start("Computing digests of streamed layer")
+		defer timing.DefaultRun.Stop(t)
+		rc, err := l.open()
+		if err != nil {
+			l.err = err
+			return
+		}
+		defer rc.Close()
+		diffID := sha256.New()
+		compressed := &countingHash{Hash: sha256.New()}
+		zw, err := gzip.NewWriterLevel(compressed, l.level)
+		if err != nil {
+			l.err = err
+			return
+		}
+		if _, err := io.Copy(io.MultiWriter(diffID, zw), rc); err != nil {
+			l.err = err
+			return
+		}
+		if err := zw.Close(); err != nil {
+			l.err = err
+			return
+		}
+		l.diffID = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(diffID.Sum(nil))}
+		l.digest = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(compressed.Sum(nil))}
+		l.size = compressed.n
+		logrus.Debugf("Streamed layer %s has diff id %s and %d bytes", l.digest, l.diffID, l.size)
+	})
+	return l.err
+}
+
+func (l *streamedLayer) Digest() (v1.Hash, error) {
+	if err := l.compute(); err != nil {
+		return v1.Hash{}, err
+	}
+	return l.digest, nil
+}
+
+func (l *streamedLayer) DiffID() (v1.Hash, error) {
+	if err := l.compute(); err != nil {
+		return v1.Hash{}, err
+	}
+	return l.diffID, nil
+}
+
+func (l *streamedLayer) Size() (int64, error) {
+	if err := l.compute(); err != nil {
+		return 0, err
+	}
+	return l.size, nil
+}
+
+func (l *streamedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+func (l *streamedLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.open()
+}
+
+// Compressed generates the tar again and compresses it while it is read.
+func (l *streamedLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer rc.Close()
+		zw, err := gzip.NewWriterLevel(pw, l.level)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(zw, rc); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+	return pr, nil
+}
+
+// countingHash is a hash that counts the bytes written to it.
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	n, err := c.Hash.Write(p)
+	c.n += int64(n)
+	return n, err
+}
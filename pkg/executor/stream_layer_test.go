@@ -0,0 +1,206 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+// streamDirectory returns a function generating a tarball of dir and the number of
+// times it was called.
+func streamDirectory(t testing.TB, dir string) (func() (io.ReadCloser, error), *int32) {
+	var opened int32
+	return func() (io.ReadCloser, error) {
+		atomic.AddInt32(&opened, 1)
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(util.CreateTarballOfDirectory(dir, pw))
+		}()
+		return pr, nil
+	}, &opened
+}
+
+func setupStreamedFiles(t testing.TB, size int) string {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat(name, size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_streamedLayer_push(t *testing.T) {
+	var failedUploads int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	// the first upload of the layer fails, so that it is streamed again
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch && strings.Contains(r.URL.Path, "/blobs/uploads/") && atomic.AddInt32(&failedUploads, 1) == 1 {
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(server.URL, "http://") + "/streamed")
+	testutil.CheckNoError(t, err)
+
+	dir := setupStreamedFiles(t, 1<<16)
+	open, opened := streamDirectory(t, dir)
+	layer := newStreamedLayer(open, 0, types.OCILayer)
+	err = remote.WriteLayer(repo, layer, remote.WithRetryBackoff(remote.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}))
+	testutil.CheckNoError(t, err)
+	if atomic.LoadInt32(&failedUploads) < 2 {
+		t.Fatalf("expected the upload of the layer to be retried, got %d uploads", failedUploads)
+	}
+	// the digests are computed in a single pass, each upload generates the tar again
+	testutil.CheckDeepEqual(t, int32(3), atomic.LoadInt32(opened))
+
+	digest, err := layer.Digest()
+	testutil.CheckNoError(t, err)
+	diffID, err := layer.DiffID()
+	testutil.CheckNoError(t, err)
+	size, err := layer.Size()
+	testutil.CheckNoError(t, err)
+
+	// the registry stores the streamed bytes under the digest computed before
+	pulled, err := remote.Layer(repo.Digest(digest.String()))
+	testutil.CheckNoError(t, err)
+	rc, err := pulled.Compressed()
+	testutil.CheckNoError(t, err)
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	rc.Close()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, digest.Hex, hex.EncodeToString(h.Sum(nil)))
+	testutil.CheckDeepEqual(t, size, n)
+
+	rc, err = pulled.Uncompressed()
+	testutil.CheckNoError(t, err)
+	h = sha256.New()
+	_, err = io.Copy(h, rc)
+	rc.Close()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, diffID.Hex, hex.EncodeToString(h.Sum(nil)))
+}
+
+func TestDoBuild_StreamLayers(t *testing.T) {
+	build := func(t *testing.T, stream bool) v1.Hash {
+		testDir, fn := setupMultistageTests(t)
+		defer fn()
+		dockerFile := "FROM scratch\nCOPY foo/bam.txt exec /copied/"
+		if err := os.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		image, err := DoBuild(&config.KanikoOptions{
+			DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+			SrcContext:     filepath.Join(testDir, "workspace"),
+			SnapshotMode:   constants.SnapshotModeFull,
+			SingleSnapshot: true,
+			StreamLayers:   stream,
+		})
+		testutil.CheckNoError(t, err)
+		layers, err := image.Layers()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 1, len(layers))
+		_, streamed := layers[0].(*streamedLayer)
+		testutil.CheckDeepEqual(t, stream, streamed)
+		// the digests are computed before the filesystem of the test is removed
+		diffID, err := layers[0].DiffID()
+		testutil.CheckNoError(t, err)
+		return diffID
+	}
+	// the streamed layer has the same content as the one written to disk
+	testutil.CheckDeepEqual(t, build(t, false), build(t, true))
+}
+
+// BenchmarkStreamedLayer compares pushing a layer written to disk first, like the
+// snapshots of kaniko, with streaming it, disk-B/op are the bytes written to disk.
+func BenchmarkStreamedLayer(b *testing.B) {
+	dir := setupStreamedFiles(b, 1<<20)
+	open, _ := streamDirectory(b, dir)
+
+	b.Run("disk", func(b *testing.B) {
+		var written int64
+		for range b.N {
+			f, err := os.CreateTemp(b.TempDir(), "layer")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := util.CreateTarballOfDirectory(dir, f); err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+			fi, err := os.Stat(f.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			written += fi.Size()
+			layer, err := tarball.LayerFromFile(f.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			pushLayer(b, layer)
+		}
+		b.ReportMetric(float64(written)/float64(b.N), "disk-B/op")
+	})
+
+	b.Run("streamed", func(b *testing.B) {
+		for range b.N {
+			pushLayer(b, newStreamedLayer(open, 0, types.OCILayer))
+		}
+		b.ReportMetric(0, "disk-B/op")
+	})
+}
+
+// pushLayer reads the layer like a push does, its digest first and then its content.
+func pushLayer(b *testing.B, layer v1.Layer) {
+	if _, err := layer.Digest(); err != nil {
+		b.Fatal(err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -19,7 +19,9 @@ package image
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"sync"
 
 	"github.com/osscontainertools/kaniko/pkg/cache"
 	"github.com/osscontainertools/kaniko/pkg/config"
@@ -34,6 +36,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,21 +44,32 @@ var (
 	// RetrieveRemoteImage downloads an image from a remote location
 	RetrieveRemoteImage = remote.RetrieveRemoteImage
 	retrieveTarImage    = tarballImage
+
+	// for testing
+	newSignatureVerifier = remote.NewSignatureVerifier
+	// digests of base images whose signature was verified already, base images
+	// are retrieved concurrently
+	verifiedImages   = map[string]bool{}
+	verifiedImagesMu sync.Mutex
 )
 
-// RetrieveSourceImage returns the base image of the stage at index
-func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (v1.Image, error) {
-	t := timing.Start("Retrieving Source Image")
-	defer timing.DefaultRun.Stop(t)
+// BaseImageName returns the name of the base image of stage with the build args replaced.
+func BaseImageName(stage config.KanikoStage, opts *config.KanikoOptions) (string, error) {
 	var buildArgs []string
-
 	for _, marg := range stage.MetaArgs {
 		for _, arg := range marg.Args {
 			buildArgs = append(buildArgs, fmt.Sprintf("%s=%s", arg.Key, arg.ValueString()))
 		}
 	}
 	buildArgs = append(buildArgs, opts.BuildArgs...)
-	currentBaseName, err := util.ResolveEnvironmentReplacement(stage.BaseName, buildArgs, false)
+	return util.ResolveEnvironmentReplacement(stage.BaseName, buildArgs, false)
+}
+
+// RetrieveSourceImage returns the base image of the stage at index
+func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (v1.Image, error) {
+	t := timing.Start("Retrieving Source Image")
+	defer timing.DefaultRun.Stop(t)
+	currentBaseName, err := BaseImageName(stage, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +90,8 @@ func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (
 
 	// Finally, check if local caching is enabled
 	// If so, look in the local cache before trying the remote registry
-	if opts.Cache && opts.CacheDir != "" {
+	var image v1.Image
+	if opts.Cache && (opts.CacheDir != "" || opts.CacheBackend != "") {
 		cachedImage, err := cachedImage(opts, currentBaseName)
 		if err != nil {
 			switch {
@@ -88,12 +103,84 @@ func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (
 				logrus.Errorf("Error while retrieving image from cache: %v %v", currentBaseName, err)
 			}
 		} else if cachedImage != nil {
-			return cachedImage, nil
+			image = cachedImage
 		}
 	}
 
 	// Otherwise, initialize image as usual
-	return RetrieveRemoteImage(currentBaseName, opts.RegistryOptions, opts.CustomPlatform)
+	if image == nil {
+		image, err = RetrieveRemoteImage(currentBaseName, opts.RegistryOptions, opts.CustomPlatform)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := verifyBaseDigest(currentBaseName, image, opts); err != nil {
+		return nil, err
+	}
+	if err := verifyBaseSignature(currentBaseName, image, opts); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// verifyBaseDigest returns an error unless the digest the base image resolved to is
+// one of --allowed-base-digest. A base pinned by the digest of a multi-platform
+// index is allowed by that digest as well, the digest of a tag isn't looked up
+// again as the tag may have moved since the image was pulled.
+func verifyBaseDigest(baseName string, image v1.Image, opts *config.KanikoOptions) error {
+	if len(opts.AllowedBaseDigests) == 0 {
+		return nil
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	digests := []string{digest.String()}
+	if ref, err := name.ParseReference(util.RewriteReference(opts.RegistryOptions, baseName), name.WeakValidation); err == nil {
+		if d, ok := ref.(name.Digest); ok {
+			digests = append(digests, d.DigestStr())
+		}
+	}
+	for _, d := range digests {
+		if slices.Contains(opts.AllowedBaseDigests, d) {
+			logrus.Infof("Base image %s resolved to the allowed digest %s", baseName, d)
+			return nil
+		}
+	}
+	return util.UserError(fmt.Errorf("base image %s resolved to %s, which is not one of --allowed-base-digest", baseName, digest))
+}
+
+// verifyBaseSignature verifies the signature of the base image with --verify-base-signatures.
+// Cached images are verified as well, by their digest.
+func verifyBaseSignature(baseName string, image v1.Image, opts *config.KanikoOptions) error {
+	if opts.VerifyBaseSignatures == "" {
+		return nil
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	verifiedImagesMu.Lock()
+	verified := verifiedImages[digest.String()]
+	verifiedImagesMu.Unlock()
+	if verified {
+		return nil
+	}
+	ref, err := name.ParseReference(util.RewriteReference(opts.RegistryOptions, baseName), name.WeakValidation)
+	if err != nil {
+		return err
+	}
+	verifier, err := newSignatureVerifier(opts.VerifyBaseSignatures, opts.RegistryOptions)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(ref, image); err != nil {
+		return errors.Wrapf(err, "verifying signature of base image %s", baseName)
+	}
+	verifiedImagesMu.Lock()
+	verifiedImages[digest.String()] = true
+	verifiedImagesMu.Unlock()
+	return nil
 }
 
 func tarballImage(index int) (v1.Image, error) {
@@ -18,16 +18,24 @@ package image
 
 import (
 	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/moby/buildkit/frontend/dockerfile/linter"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 
 	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/image/remote"
+	"github.com/osscontainertools/kaniko/pkg/util"
 	"github.com/osscontainertools/kaniko/testutil"
+	"golang.org/x/sync/errgroup"
 )
 
 var (
@@ -112,6 +120,175 @@ func Test_ScratchImageFromMirror(t *testing.T) {
 	testutil.CheckErrorAndDeepEqual(t, false, err, expected, actual)
 }
 
+// fakeVerifier accepts the images with a signature in signed.
+type fakeVerifier struct {
+	signed map[v1.Hash]bool
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeVerifier) Verify(ref name.Reference, image v1.Image) error {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	if !f.signed[digest] {
+		return fmt.Errorf("no valid signature found for %s", ref)
+	}
+	return nil
+}
+
+func Test_RetrieveSourceImage_verifyBaseSignatures(t *testing.T) {
+	stages, err := parse(dockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedDigest, err := signed.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originalRetrieve, originalVerifier := RetrieveRemoteImage, newSignatureVerifier
+	defer func() {
+		RetrieveRemoteImage, newSignatureVerifier = originalRetrieve, originalVerifier
+		verifiedImages = map[string]bool{}
+	}()
+	verifier := &fakeVerifier{signed: map[v1.Hash]bool{signedDigest: true}}
+	newSignatureVerifier = func(policy string, opts config.RegistryOptions) (remote.SignatureVerifier, error) {
+		return verifier, nil
+	}
+	opts := &config.KanikoOptions{VerifyBaseSignatures: "/cosign.pub"}
+
+	RetrieveRemoteImage = func(image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return signed, nil
+	}
+	actual, err := RetrieveSourceImage(config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, signed, actual)
+	// verified images are not verified again
+	_, err = RetrieveSourceImage(config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 1, verifier.calls)
+
+	RetrieveRemoteImage = func(image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return unsigned, nil
+	}
+	_, err = RetrieveSourceImage(config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckError(t, true, err)
+
+	// scratch has nothing to verify
+	_, err = RetrieveSourceImage(config.KanikoStage{Stage: stages[1]}, opts)
+	testutil.CheckNoError(t, err)
+}
+
+func Test_RetrieveSourceImage_verifyBaseSignatures_parallel(t *testing.T) {
+	stages, err := parse(dockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images []v1.Image
+	signed := map[v1.Hash]bool{}
+	for i := 0; i < 4; i++ {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, img)
+		signed[digest] = true
+	}
+
+	originalRetrieve, originalVerifier := RetrieveRemoteImage, newSignatureVerifier
+	defer func() {
+		RetrieveRemoteImage, newSignatureVerifier = originalRetrieve, originalVerifier
+		verifiedImages = map[string]bool{}
+	}()
+	verifier := &fakeVerifier{signed: signed}
+	newSignatureVerifier = func(policy string, opts config.RegistryOptions) (remote.SignatureVerifier, error) {
+		return verifier, nil
+	}
+	var next atomic.Int32
+	RetrieveRemoteImage = func(image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return images[int(next.Add(1))%len(images)], nil
+	}
+	opts := &config.KanikoOptions{VerifyBaseSignatures: "/cosign.pub"}
+
+	// base images of several stages are retrieved concurrently
+	var g errgroup.Group
+	for i := 0; i < 32; i++ {
+		g.Go(func() error {
+			_, err := RetrieveSourceImage(config.KanikoStage{Stage: stages[0]}, opts)
+			return err
+		})
+	}
+	testutil.CheckNoError(t, g.Wait())
+	testutil.CheckDeepEqual(t, len(images), len(verifiedImages))
+}
+
+func Test_RetrieveSourceImage_allowedBaseDigests(t *testing.T) {
+	stages, err := parse(dockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disallowed, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvedDigest, err := approved.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := RetrieveRemoteImage
+	defer func() {
+		RetrieveRemoteImage = original
+	}()
+	opts := &config.KanikoOptions{AllowedBaseDigests: []string{approvedDigest.String()}}
+
+	// gcr.io/distroless/base:latest resolves to the approved digest
+	RetrieveRemoteImage = func(image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return approved, nil
+	}
+	actual, err := RetrieveSourceImage(config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, approved, actual)
+
+	// the tag moved to an image that is not approved
+	RetrieveRemoteImage = func(image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return disallowed, nil
+	}
+	_, err = RetrieveSourceImage(config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckError(t, true, err)
+	testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+
+	// a base pinned by an approved digest of an index resolves to an image of the index
+	pinned := stages[0]
+	pinned.BaseName = "gcr.io/distroless/base@" + approvedDigest.String()
+	_, err = RetrieveSourceImage(config.KanikoStage{Stage: pinned}, opts)
+	testutil.CheckNoError(t, err)
+
+	// scratch has no digest to check
+	_, err = RetrieveSourceImage(config.KanikoStage{Stage: stages[1]}, opts)
+	testutil.CheckNoError(t, err)
+}
+
 // parse parses the contents of a Dockerfile and returns a list of commands
 func parse(s string) ([]instructions.Stage, error) {
 	p, err := parser.Parse(bytes.NewReader([]byte(s)))
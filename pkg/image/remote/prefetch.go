@@ -0,0 +1,138 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PrefetchRemoteImage downloads the layers of image to dir, so that RetrieveRemoteImage
+// returns it from the cache with layers that are read from dir instead of the registry.
+// Cancelling ctx aborts the prefetch.
+func PrefetchRemoteImage(ctx context.Context, image string, opts config.RegistryOptions, customPlatform string, dir string) error {
+	remoteImage, err := retrieveRemoteImage(ctx, image, opts, customPlatform)
+	if err != nil {
+		return err
+	}
+	if _, ok := remoteImage.(*prefetchedImage); ok {
+		return nil
+	}
+	layers, err := remoteImage.Layers()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	prefetched := &prefetchedImage{Image: remoteImage, layers: make([]v1.Layer, 0, len(layers))}
+	for _, l := range layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		digest, err := l.Digest()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, digest.Hex)
+		if err := downloadLayer(l, path); err != nil {
+			return errors.Wrapf(err, "prefetching layer %s of %s", digest, image)
+		}
+		var layer v1.Layer = &prefetchedLayer{Layer: l, path: path}
+		// keep the layer mountable, so that pushing it can still mount it from the base repository
+		if ml, ok := l.(*remote.MountableLayer); ok {
+			layer = &remote.MountableLayer{Layer: layer, Reference: ml.Reference}
+		}
+		prefetched.layers = append(prefetched.layers, layer)
+	}
+	logrus.Infof("Prefetched %d layers of %s", len(layers), image)
+	cacheManifest(image, prefetched)
+	return nil
+}
+
+func downloadLayer(l v1.Layer, path string) error {
+	rc, err := l.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prefetchedImage is a remote image whose layers were downloaded by PrefetchRemoteImage.
+type prefetchedImage struct {
+	v1.Image
+	layers []v1.Layer
+}
+
+func (i *prefetchedImage) Layers() ([]v1.Layer, error) {
+	return i.layers, nil
+}
+
+func (i *prefetchedImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	for _, l := range i.layers {
+		if digest, err := l.Digest(); err == nil && digest == h {
+			return l, nil
+		}
+	}
+	return i.Image.LayerByDigest(h)
+}
+
+func (i *prefetchedImage) LayerByDiffID(h v1.Hash) (v1.Layer, error) {
+	for _, l := range i.layers {
+		if diffID, err := l.DiffID(); err == nil && diffID == h {
+			return l, nil
+		}
+	}
+	return i.Image.LayerByDiffID(h)
+}
+
+// prefetchedLayer is a remote layer whose compressed content was downloaded to path.
+type prefetchedLayer struct {
+	v1.Layer
+	path string
+}
+
+func (l *prefetchedLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+func (l *prefetchedLayer) Uncompressed() (io.ReadCloser, error) {
+	// decompresses the content returned by Compressed
+	layer, err := partial.CompressedToLayer(l)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Uncompressed()
+}
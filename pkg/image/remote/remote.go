@@ -17,8 +17,12 @@ limitations under the License.
 package remote
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/creds"
@@ -27,24 +31,85 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	manifestCache   = make(map[string]v1.Image)
+	manifestCacheMu sync.Mutex
 	remoteImageFunc = remote.Image
+	// notFoundCache holds the images the registry confirmed don't exist, so
+	// that the stages and destinations of a build don't request them again.
+	notFoundCache = make(map[string]notFound)
 )
 
+// notFoundTTL is how long an image that wasn't found isn't requested again.
+const notFoundTTL = time.Minute
+
+type notFound struct {
+	err     error
+	expires time.Time
+}
+
+func cachedManifest(image string) v1.Image {
+	manifestCacheMu.Lock()
+	defer manifestCacheMu.Unlock()
+	return manifestCache[image]
+}
+
+func cacheManifest(image string, remoteImage v1.Image) {
+	manifestCacheMu.Lock()
+	defer manifestCacheMu.Unlock()
+	manifestCache[image] = remoteImage
+}
+
+func cachedNotFound(image string) error {
+	manifestCacheMu.Lock()
+	defer manifestCacheMu.Unlock()
+	if nf, ok := notFoundCache[image]; ok && time.Now().Before(nf.expires) {
+		return nf.err
+	}
+	return nil
+}
+
+func cacheNotFound(image string, err error) {
+	manifestCacheMu.Lock()
+	defer manifestCacheMu.Unlock()
+	notFoundCache[image] = notFound{err: err, expires: time.Now().Add(notFoundTTL)}
+}
+
+// isNotFound returns true if err is the registry confirming that the image doesn't
+// exist, which unlike transient errors isn't worth retrying.
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
+
+func isRetryable(err error) bool {
+	return !isNotFound(err)
+}
+
 // RetrieveRemoteImage retrieves the manifest for the specified image from the specified registry
 func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatform string) (v1.Image, error) {
+	return retrieveRemoteImage(context.Background(), image, opts, customPlatform)
+}
+
+func retrieveRemoteImage(ctx context.Context, image string, opts config.RegistryOptions, customPlatform string) (v1.Image, error) {
+	image = util.RewriteReference(opts, image)
 	logrus.Infof("Retrieving image manifest %s", image)
 
-	cachedRemoteImage := manifestCache[image]
+	cachedRemoteImage := cachedManifest(image)
 	if cachedRemoteImage != nil {
 		logrus.Infof("Returning cached image manifest")
 		return cachedRemoteImage, nil
 	}
+	if err := cachedNotFound(image); err != nil {
+		logrus.Infof("Image %s was not found before, not requesting it again", image)
+		return nil, err
+	}
 
 	ref, err := name.ParseReference(image, name.WeakValidation)
 	if err != nil {
@@ -55,6 +120,10 @@ func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatfo
 		for _, registryMapping := range newRegURLs {
 
 			regToMapTo, repositoryPrefix := parseRegistryMapping(registryMapping)
+			if err := util.CheckRegistryAllowed(opts, regToMapTo); err != nil {
+				logrus.Warnf("Skipping mapped registry %s for image %s: %s", regToMapTo, ref, err)
+				continue
+			}
 
 			insecurePull := opts.InsecurePull || opts.InsecureRegistries.Contains(regToMapTo)
 
@@ -67,16 +136,16 @@ func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatfo
 
 			logrus.Infof("Retrieving image %s from mapped registry %s", remappedRef, regToMapTo)
 			retryFunc := func() (v1.Image, error) {
-				return remoteImageFunc(remappedRef, remoteOptions(regToMapTo, opts, customPlatform)...)
+				return remoteImageFunc(remappedRef, remoteOptions(ctx, regToMapTo, opts, customPlatform)...)
 			}
 
 			var remoteImage v1.Image
-			if remoteImage, err = util.RetryWithResult(retryFunc, opts.ImageDownloadRetry, 1000); err != nil {
+			if remoteImage, err = util.RetryWithResultIf(retryFunc, isRetryable, opts.ImageDownloadRetry, 1000); err != nil {
 				logrus.Warnf("Failed to retrieve image %s from remapped registry %s: %s. Will try with the next registry, or fallback to the original registry.", remappedRef, regToMapTo, err)
 				continue
 			}
 
-			manifestCache[image] = remoteImage
+			cacheManifest(image, remoteImage)
 
 			return remoteImage, nil
 		}
@@ -87,6 +156,9 @@ func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatfo
 	}
 
 	registryName := ref.Context().RegistryStr()
+	if err := util.CheckRegistryAllowed(opts, registryName); err != nil {
+		return nil, fmt.Errorf("retrieving image %s: %w", image, err)
+	}
 	if opts.InsecurePull || opts.InsecureRegistries.Contains(registryName) {
 		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
 		if err != nil {
@@ -98,12 +170,14 @@ func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatfo
 	logrus.Infof("Retrieving image %s from registry %s", ref, registryName)
 
 	retryFunc := func() (v1.Image, error) {
-		return remoteImageFunc(ref, remoteOptions(registryName, opts, customPlatform)...)
+		return remoteImageFunc(ref, remoteOptions(ctx, registryName, opts, customPlatform)...)
 	}
 
 	var remoteImage v1.Image
-	if remoteImage, err = util.RetryWithResult(retryFunc, opts.ImageDownloadRetry, 1000); remoteImage != nil {
-		manifestCache[image] = remoteImage
+	if remoteImage, err = util.RetryWithResultIf(retryFunc, isRetryable, opts.ImageDownloadRetry, 1000); remoteImage != nil {
+		cacheManifest(image, remoteImage)
+	} else if isNotFound(err) {
+		cacheNotFound(image, err)
 	}
 
 	return remoteImage, err
@@ -144,10 +218,10 @@ func setNewRegistry(ref name.Reference, newReg name.Registry) name.Reference {
 	}
 }
 
-func remoteOptions(registryName string, opts config.RegistryOptions, customPlatform string) []remote.Option {
-	tr, err := util.MakeTransport(opts, registryName)
+func remoteOptions(ctx context.Context, registryName string, opts config.RegistryOptions, customPlatform string) []remote.Option {
+	tr, err := util.MakePullTransport(opts, registryName)
 
-	// The MakeTransport function will only return errors if there was a problem
+	// The MakePullTransport function will only return errors if there was a problem
 	// with registry certificates (Verification or mTLS)
 	if err != nil {
 		logrus.Fatalf("Unable to setup transport for registry %q: %v", customPlatform, err)
@@ -159,7 +233,7 @@ func remoteOptions(registryName string, opts config.RegistryOptions, customPlatf
 		logrus.Fatalf("Invalid platform %q: %v", customPlatform, err)
 	}
 
-	return []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain(&opts)), remote.WithPlatform(*platform)}
+	return []remote.Option{remote.WithContext(ctx), remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain(&opts)), remote.WithPlatform(*platform)}
 }
 
 // Parse the registry mapping
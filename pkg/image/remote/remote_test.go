@@ -18,13 +18,24 @@ package remote
 
 import (
 	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
 )
 
 const image string = "debian"
@@ -216,6 +227,45 @@ func Test_RetrieveRemoteImage_skipFallback(t *testing.T) {
 	}
 }
 
+func Test_RetrieveRemoteImage_allowedRegistries(t *testing.T) {
+	opts := config.RegistryOptions{
+		AllowedRegistries: []string{"*.gcr.io", "docker.io"},
+	}
+	var retrieved []string
+	remoteImageFunc = func(ref name.Reference, options ...remote.Option) (v1.Image, error) {
+		retrieved = append(retrieved, ref.Context().RegistryStr())
+		return &mockImage{}, nil
+	}
+	manifestCache = make(map[string]v1.Image)
+
+	if _, err := RetrieveRemoteImage("quay.io/foo/bar", opts, ""); !errors.Is(err, util.ErrRegistryNotAllowed) {
+		t.Fatalf("Expected call to fail because the registry is not allowed, got %v", err)
+	}
+	if len(retrieved) != 0 {
+		t.Fatalf("Expected no request to a disallowed registry, got %v", retrieved)
+	}
+
+	for _, image := range []string{"eu.gcr.io/foo/bar", "debian"} {
+		if _, err := RetrieveRemoteImage(image, opts, ""); err != nil {
+			t.Fatalf("Expected call to succeed for %s: %v", image, err)
+		}
+	}
+	if len(retrieved) != 2 {
+		t.Fatalf("Expected 2 requests, got %v", retrieved)
+	}
+
+	// mappings to disallowed registries are skipped
+	retrieved = nil
+	manifestCache = make(map[string]v1.Image)
+	opts.RegistryMaps = map[string][]string{name.DefaultRegistry: {"mirror.example.com"}}
+	if _, err := RetrieveRemoteImage(image, opts, ""); err != nil {
+		t.Fatalf("Expected call to succeed from the default registry: %v", err)
+	}
+	if len(retrieved) != 1 || retrieved[0] != name.DefaultRegistry {
+		t.Fatalf("Expected the mirror to be skipped, got %v", retrieved)
+	}
+}
+
 func Test_RetryRetrieveRemoteImageSucceeds(t *testing.T) {
 	opts := config.RegistryOptions{
 		ImageDownloadRetry: 2,
@@ -310,3 +360,143 @@ func Test_ParseRegistryMapping(t *testing.T) {
 		})
 	}
 }
+
+func Test_RetrieveRemoteImage_rewriteReference(t *testing.T) {
+	opts := config.RegistryOptions{
+		ReferenceRewrites: map[string]string{"docker.io": "mirror.example.com"},
+	}
+	var retrieved []string
+	remoteImageFunc = func(ref name.Reference, options ...remote.Option) (v1.Image, error) {
+		retrieved = append(retrieved, ref.Name())
+		return &mockImage{}, nil
+	}
+	manifestCache = make(map[string]v1.Image)
+
+	if _, err := RetrieveRemoteImage("docker.io/library/alpine", opts, ""); err != nil {
+		t.Fatal(err)
+	}
+	// registry maps apply to the rewritten reference
+	opts.RegistryMaps = map[string][]string{"mirror.example.com": {"other.example.com"}}
+	if _, err := RetrieveRemoteImage("debian:12", opts, ""); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, []string{"mirror.example.com/library/alpine:latest", "other.example.com/library/debian:12"}, retrieved)
+}
+
+func Test_RetrieveRemoteImage_notFoundCache(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if repo, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/"); ok {
+			mu.Lock()
+			requests[repo]++
+			mu.Unlock()
+			if repo == "flaky" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	original := remoteImageFunc
+	defer func() { remoteImageFunc = original }()
+	remoteImageFunc = remote.Image
+	manifestCache = make(map[string]v1.Image)
+	notFoundCache = make(map[string]notFound)
+
+	opts := config.RegistryOptions{ImageDownloadRetry: 1}
+	// the stages and the prefetcher of a build look up the same base image
+	for range 3 {
+		_, err := RetrieveRemoteImage(host+"/missing:latest", opts, "")
+		if !isNotFound(err) {
+			t.Fatalf("expected a not found error, got %v", err)
+		}
+	}
+	testutil.CheckDeepEqual(t, 1, requests["missing"])
+
+	// transient errors are not cached
+	opts.ImageDownloadRetry = 0
+	if _, err := RetrieveRemoteImage(host+"/flaky:latest", opts, ""); err == nil {
+		t.Fatal("expected the lookup to fail")
+	}
+	perLookup := requests["flaky"]
+	if _, err := RetrieveRemoteImage(host+"/flaky:latest", opts, ""); err == nil {
+		t.Fatal("expected the lookup to fail")
+	}
+	testutil.CheckDeepEqual(t, 2*perLookup, requests["flaky"])
+}
+
+func Test_RetrieveRemoteImage_errorClass(t *testing.T) {
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/slow/") {
+			// a registry that doesn't answer in time
+			<-r.Context().Done()
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	original := remoteImageFunc
+	defer func() { remoteImageFunc = original }()
+	remoteImageFunc = remote.Image
+	manifestCache = make(map[string]v1.Image)
+	notFoundCache = make(map[string]notFound)
+
+	opts := config.RegistryOptions{PullTimeout: 100 * time.Millisecond}
+	_, err := RetrieveRemoteImage(host+"/slow:latest", opts, "")
+	testutil.CheckDeepEqual(t, util.ErrorClassInfra, util.ClassifyError(err))
+
+	_, err = RetrieveRemoteImage(host+"/missing:latest", opts, "")
+	testutil.CheckDeepEqual(t, util.ErrorClassUser, util.ClassifyError(err))
+}
+
+func Test_RetrieveRemoteImage_registryHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var userAgents, teams []string
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.UserAgent())
+		teams = append(teams, r.Header.Get("X-Team"))
+		mu.Unlock()
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	ref, err := name.NewTag(host + "/base:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, empty.Image); err != nil {
+		t.Fatal(err)
+	}
+
+	original := remoteImageFunc
+	defer func() { remoteImageFunc = original }()
+	remoteImageFunc = remote.Image
+	manifestCache = make(map[string]v1.Image)
+	mu.Lock()
+	userAgents, teams = nil, nil
+	mu.Unlock()
+
+	opts := config.RegistryOptions{
+		RegistryHeaders:   map[string]string{"X-Team": "builds"},
+		RegistryUserAgent: "corp-builder/1.0",
+	}
+	_, err = RetrieveRemoteImage(ref.String(), opts, "")
+	testutil.CheckNoError(t, err)
+	if len(userAgents) == 0 {
+		t.Fatal("expected requests to the registry")
+	}
+	for i := range userAgents {
+		testutil.CheckDeepEqual(t, "corp-builder/1.0", userAgents[i])
+		testutil.CheckDeepEqual(t, "builds", teams[i])
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/creds"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SignaturePolicyKeyless is reserved for keyless verification, which is not supported yet
+	SignaturePolicyKeyless = "keyless"
+
+	cosignSignatureMediaType  types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureAnnotation                 = "dev.cosignproject.cosign/signature"
+	cosignSignatureTagSuffix                  = ".sig"
+)
+
+// SignatureVerifier verifies that an image is signed before it is used as base image.
+type SignatureVerifier interface {
+	Verify(ref name.Reference, image v1.Image) error
+}
+
+// NewSignatureVerifier returns the verifier for the policy set with --verify-base-signatures,
+// which is the path to a cosign public key.
+func NewSignatureVerifier(policy string, opts config.RegistryOptions) (SignatureVerifier, error) {
+	if policy == SignaturePolicyKeyless {
+		return nil, errors.New("keyless signature verification is not supported, provide the path to a cosign public key")
+	}
+	b, err := os.ReadFile(policy)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading public key")
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", policy)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing public key %s", policy)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return &cosignKeyVerifier{key: key, opts: opts}, nil
+}
+
+// cosignKeyVerifier verifies cosign signatures, stored as `sha256-<digest>.sig` tag
+// next to the image, with a public key.
+type cosignKeyVerifier struct {
+	key  crypto.PublicKey
+	opts config.RegistryOptions
+}
+
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+func (v *cosignKeyVerifier) Verify(ref name.Reference, image v1.Image) error {
+	registryName := ref.Context().RegistryStr()
+	if err := util.CheckRegistryAllowed(v.opts, registryName); err != nil {
+		return err
+	}
+	repo := ref.Context()
+	if v.opts.InsecurePull || v.opts.InsecureRegistries.Contains(registryName) {
+		reg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
+		if err != nil {
+			return err
+		}
+		repo.Registry = reg
+	}
+	tr, err := util.MakePullTransport(v.opts, registryName)
+	if err != nil {
+		return errors.Wrapf(err, "making transport for registry %q", registryName)
+	}
+	remoteOpts := []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain(&v.opts))}
+
+	// multi-platform images are usually signed by the digest of their index,
+	// so the digest the reference pointed to is accepted as well.
+	imageDigest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	digests := []v1.Hash{imageDigest}
+	if d, ok := ref.(name.Digest); ok {
+		if h, err := v1.NewHash(d.DigestStr()); err == nil && h != imageDigest {
+			digests = append(digests, h)
+		}
+	} else if desc, err := remote.Head(repo.Tag(ref.Identifier()), remoteOpts...); err == nil && desc.Digest != imageDigest {
+		digests = append(digests, desc.Digest)
+	}
+
+	for _, digest := range digests {
+		sigTag := repo.Tag(fmt.Sprintf("%s-%s%s", digest.Algorithm, digest.Hex, cosignSignatureTagSuffix))
+		sigs, err := remote.Image(sigTag, remoteOpts...)
+		if err != nil {
+			logrus.Debugf("No signatures found at %s: %v", sigTag, err)
+			continue
+		}
+		ok, err := v.verifySignatures(sigs, digest)
+		if err != nil {
+			return errors.Wrapf(err, "verifying signatures %s", sigTag)
+		}
+		if ok {
+			logrus.Infof("Verified signature of %s", ref)
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid signature found for %s", ref)
+}
+
+// verifySignatures returns true if any of the signatures is valid for digest.
+func (v *cosignKeyVerifier) verifySignatures(sigs v1.Image, digest v1.Hash) (bool, error) {
+	mfst, err := sigs.Manifest()
+	if err != nil {
+		return false, err
+	}
+	for _, desc := range mfst.Layers {
+		if desc.MediaType != cosignSignatureMediaType {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(desc.Annotations[cosignSignatureAnnotation])
+		if err != nil {
+			continue
+		}
+		layer, err := sigs.LayerByDigest(desc.Digest)
+		if err != nil {
+			return false, err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return false, err
+		}
+		payload, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return false, err
+		}
+		if !v.verify(payload, sig) {
+			continue
+		}
+		// the signed payload names the image it belongs to
+		var p cosignPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			continue
+		}
+		if p.Critical.Image.DockerManifestDigest == digest.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (v *cosignKeyVerifier) verify(payload, sig []byte) bool {
+	h := sha256.Sum256(payload)
+	switch key := v.key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, h[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func writePublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// pushSignature pushes a cosign signature of signedDigest to the signature tag of tagDigest.
+func pushSignature(t *testing.T, key *ecdsa.PrivateKey, repo name.Repository, signedDigest, tagDigest v1.Hash) {
+	t.Helper()
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, repo, signedDigest))
+	h := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, cosignSignatureMediaType),
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := repo.Tag(fmt.Sprintf("%s-%s.sig", tagDigest.Algorithm, tagDigest.Hex))
+	if err := remote.Write(tag, sigs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pushImage(t *testing.T, ref name.Tag) (v1.Image, v1.Hash) {
+	t.Helper()
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, digest
+}
+
+func Test_cosignKeyVerifier(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(server.URL, "http://") + "/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, signedDigest := pushImage(t, repo.Tag("signed"))
+	pushSignature(t, key, repo, signedDigest, signedDigest)
+	unsigned, _ := pushImage(t, repo.Tag("unsigned"))
+	// a valid signature of another image does not sign this one
+	replayed, replayedDigest := pushImage(t, repo.Tag("replayed"))
+	pushSignature(t, key, repo, signedDigest, replayedDigest)
+	otherSigned, otherSignedDigest := pushImage(t, repo.Tag("other"))
+	pushSignature(t, otherKey, repo, otherSignedDigest, otherSignedDigest)
+
+	verifier, err := NewSignatureVerifier(writePublicKey(t, key), config.RegistryOptions{})
+	testutil.CheckNoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		ref     name.Reference
+		image   v1.Image
+		wantErr bool
+	}{
+		{name: "signed by tag", ref: repo.Tag("signed"), image: signed},
+		{name: "signed by digest", ref: repo.Digest(signedDigest.String()), image: signed},
+		{name: "unsigned", ref: repo.Tag("unsigned"), image: unsigned, wantErr: true},
+		{name: "signature of another image", ref: repo.Tag("replayed"), image: replayed, wantErr: true},
+		{name: "signed with another key", ref: repo.Tag("other"), image: otherSigned, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifier.Verify(tc.ref, tc.image)
+			testutil.CheckError(t, tc.wantErr, err)
+		})
+	}
+}
+
+func Test_NewSignatureVerifier_invalid(t *testing.T) {
+	_, err := NewSignatureVerifier(SignaturePolicyKeyless, config.RegistryOptions{})
+	testutil.CheckError(t, true, err)
+
+	notAKey := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(notAKey, []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewSignatureVerifier(notAKey, config.RegistryOptions{})
+	testutil.CheckError(t, true, err)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RedactedValue replaces the matches of the secret patterns in the logs
+const RedactedValue = "***"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []*regexp.Regexp
+)
+
+// Redact makes the standard logger replace the matches of patterns in the messages
+// and fields it logs with RedactedValue. It has to be called after Configure, which
+// sets the formatter it wraps.
+func Redact(patterns []string) error {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return errors.Wrapf(err, "parsing secret pattern %q", p)
+		}
+		compiled = append(compiled, re)
+	}
+	secretsMu.Lock()
+	secrets = compiled
+	secretsMu.Unlock()
+	if len(compiled) == 0 {
+		return nil
+	}
+	logger := logrus.StandardLogger()
+	if _, ok := logger.Formatter.(*redactingFormatter); !ok {
+		logger.SetFormatter(&redactingFormatter{Formatter: logger.Formatter})
+	}
+	return nil
+}
+
+// RedactString returns s with the matches of the patterns set with Redact replaced.
+func RedactString(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, re := range secrets {
+		s = re.ReplaceAllLiteralString(s, RedactedValue)
+	}
+	return s
+}
+
+// redactingFormatter redacts entries before they are formatted, as formatters
+// like the JSON one escape the values that would have to be matched otherwise.
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	entry.Message = RedactString(entry.Message)
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			entry.Data[k] = RedactString(v)
+		case error:
+			entry.Data[k] = RedactString(v.Error())
+		}
+	}
+	return f.Formatter.Format(entry)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/commands"
+	"github.com/osscontainertools/kaniko/pkg/dockerfile"
+	"github.com/osscontainertools/kaniko/pkg/util"
+	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedact(t *testing.T) {
+	logger := logrus.StandardLogger()
+	formatter, out, level := logger.Formatter, logger.Out, logger.Level
+	defer func() {
+		logger.SetFormatter(formatter)
+		logger.SetOutput(out)
+		logger.SetLevel(level)
+		testutil.CheckNoError(t, Redact(nil))
+	}()
+
+	cmds, err := dockerfile.ParseCommands([]string{
+		"RUN curl -H 'Authorization: Bearer s3cr3t-t0ken' https://example.com",
+		"COPY --chown=s3cr3t-t0ken a b",
+	})
+	testutil.CheckNoError(t, err)
+
+	for _, format := range []string{FormatText, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			testutil.CheckNoError(t, Configure("info", format, false))
+			testutil.CheckNoError(t, Redact([]string{`s3cr3t-t0ken`, `pass(word)?=\S+`}))
+			var buf bytes.Buffer
+			logger.SetOutput(&buf)
+
+			for _, cmd := range cmds {
+				c, err := commands.GetCommand(cmd, util.FileContext{}, false, false, false)
+				testutil.CheckNoError(t, err)
+				logrus.Info(c.String())
+			}
+			logrus.WithField("arg", "password=hunter2").WithError(errors.New("s3cr3t-t0ken rejected")).Warn("login failed")
+
+			logs := buf.String()
+			for _, secret := range []string{"s3cr3t-t0ken", "hunter2"} {
+				if strings.Contains(logs, secret) {
+					t.Errorf("logs contain %q:\n%s", secret, logs)
+				}
+			}
+			for _, want := range []string{"Bearer ***", "--chown=***", "***", "login failed"} {
+				if !strings.Contains(logs, want) {
+					t.Errorf("logs don't contain %q:\n%s", want, logs)
+				}
+			}
+		})
+	}
+
+	testutil.CheckDeepEqual(t, "token *** and ***", RedactString("token s3cr3t-t0ken and pass=x"))
+	testutil.CheckError(t, true, Redact([]string{"("}))
+}
@@ -0,0 +1,357 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/osscontainertools/kaniko/pkg/version"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Standard locations of the package databases inside a rootfs.
+	dpkgStatusPath    = "var/lib/dpkg/status"
+	dpkgStatusDirPath = "var/lib/dpkg/status.d"
+	apkInstalledPath  = "lib/apk/db/installed"
+	rpmDBDirPath      = "var/lib/rpm"
+
+	// Locations of the os-release file, the first one found is used.
+	osReleasePath    = "etc/os-release"
+	libOSReleasePath = "usr/lib/os-release"
+
+	bomFormat   = "CycloneDX"
+	specVersion = "1.5"
+)
+
+// Package is a single OS package found in the rootfs.
+type Package struct {
+	Name         string
+	Version      string
+	Architecture string
+	// Type is the package manager the package was found in, ie. deb or apk.
+	Type string
+	// Namespace is the ID of the distribution in its os-release, ie. debian.
+	Namespace string
+	// Distro is the ID and VERSION_ID of the distribution, ie. debian-12.
+	Distro string
+}
+
+// PURL returns the package url of p, see https://github.com/package-url/purl-spec
+func (p Package) PURL() string {
+	name := p.Name
+	if p.Namespace != "" {
+		name = p.Namespace + "/" + p.Name
+	}
+	purl := fmt.Sprintf("pkg:%s/%s@%s", p.Type, name, p.Version)
+	// qualifiers are sorted by key
+	var qualifiers []string
+	if p.Architecture != "" {
+		qualifiers = append(qualifiers, "arch="+p.Architecture)
+	}
+	if p.Distro != "" {
+		qualifiers = append(qualifiers, "distro="+p.Distro)
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + strings.Join(qualifiers, "&")
+	}
+	return purl
+}
+
+// Scan detects the OS packages installed in the rootfs at root.
+// Packages are returned sorted by type, name and version.
+func Scan(root string) ([]Package, error) {
+	var pkgs []Package
+
+	dpkg, err := scanDpkg(root)
+	if err != nil {
+		return nil, err
+	}
+	pkgs = append(pkgs, dpkg...)
+
+	apk, err := scanFile(filepath.Join(root, apkInstalledPath), parseApkInstalled)
+	if err != nil {
+		return nil, err
+	}
+	pkgs = append(pkgs, apk...)
+
+	if _, err := os.Stat(filepath.Join(root, rpmDBDirPath)); err == nil {
+		// The rpm database is stored in a binary format (bdb, ndb or sqlite).
+		logrus.Warnf("Found rpm database at /%s, rpm packages are not yet included in the SBOM", rpmDBDirPath)
+	}
+
+	id, versionID, err := readOSRelease(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pkgs {
+		pkgs[i].Namespace = id
+		pkgs[i].Distro = id
+		if id != "" && versionID != "" {
+			pkgs[i].Distro = id + "-" + versionID
+		}
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Version < pkgs[j].Version
+	})
+	return pkgs, nil
+}
+
+func scanDpkg(root string) ([]Package, error) {
+	pkgs, err := scanFile(filepath.Join(root, dpkgStatusPath), parseDpkgStatus)
+	if err != nil {
+		return nil, err
+	}
+	// distroless images don't ship dpkg, but keep one status file per package
+	entries, err := os.ReadDir(filepath.Join(root, dpkgStatusDirPath))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "reading dpkg status directory")
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p, err := scanFile(filepath.Join(root, dpkgStatusDirPath, e.Name()), parseDpkgStatus)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p...)
+	}
+	return pkgs, nil
+}
+
+// readOSRelease returns the ID and VERSION_ID of the distribution of the rootfs
+// at root, empty if it has no os-release.
+func readOSRelease(root string) (id, versionID string, err error) {
+	for _, path := range []string{osReleasePath, libOSReleasePath} {
+		f, err := os.Open(filepath.Join(root, path))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", "", errors.Wrapf(err, "opening /%s", path)
+		}
+		defer f.Close()
+		fields, err := parseOSRelease(f)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "parsing /%s", path)
+		}
+		return strings.ToLower(fields["ID"]), fields["VERSION_ID"], nil
+	}
+	return "", "", nil
+}
+
+// parseOSRelease parses the os-release format, KEY=value lines whose values may be quoted.
+func parseOSRelease(r io.Reader) (map[string]string, error) {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(v); err == nil && strings.HasPrefix(v, `"`) {
+			v = unquoted
+		} else {
+			v = strings.Trim(v, `'`)
+		}
+		fields[k] = v
+	}
+	return fields, scanner.Err()
+}
+
+func scanFile(path string, parse func(io.Reader) ([]Package, error)) ([]Package, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+	logrus.Debugf("Reading package database %s", path)
+	pkgs, err := parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+	return pkgs, nil
+}
+
+// parseDpkgStatus parses the dpkg status file format, a list of RFC 822 style
+// stanzas separated by empty lines. Only packages that are actually installed are returned.
+func parseDpkgStatus(r io.Reader) ([]Package, error) {
+	var pkgs []Package
+	fields := map[string]string{}
+	flush := func() {
+		if fields["Package"] != "" && (fields["Status"] == "" || strings.HasSuffix(fields["Status"], " installed")) {
+			pkgs = append(pkgs, Package{
+				Name:         fields["Package"],
+				Version:      fields["Version"],
+				Architecture: fields["Architecture"],
+				Type:         "deb",
+			})
+		}
+		fields = map[string]string{}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		// continuation lines, ie. of the Description field
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[k] = strings.TrimSpace(v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return pkgs, nil
+}
+
+// parseApkInstalled parses the alpine package database, a list of
+// single letter prefixed lines with one block per package.
+func parseApkInstalled(r io.Reader) ([]Package, error) {
+	var pkgs []Package
+	var current Package
+	flush := func() {
+		if current.Name != "" {
+			current.Type = "apk"
+			pkgs = append(pkgs, current)
+		}
+		current = Package{}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "P":
+			current.Name = v
+		case "V":
+			current.Version = v
+		case "A":
+			current.Architecture = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return pkgs, nil
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Tools []cycloneDXTool `json:"tools"`
+}
+
+type cycloneDXTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cycloneDXComponent struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	PURL    string `json:"purl"`
+}
+
+// Write writes the packages as a CycloneDX JSON document to w.
+func Write(w io.Writer, pkgs []Package) error {
+	doc := cycloneDXDocument{
+		BOMFormat:   bomFormat,
+		SpecVersion: specVersion,
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Tools: []cycloneDXTool{{Name: "kaniko", Version: version.Version()}},
+		},
+		Components: []cycloneDXComponent{},
+	}
+	for _, p := range pkgs {
+		doc.Components = append(doc.Components, cycloneDXComponent{
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL(),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// WriteFile scans the rootfs at root and writes the resulting SBOM to path.
+func WriteFile(path, root string) error {
+	pkgs, err := Scan(root)
+	if err != nil {
+		return errors.Wrap(err, "scanning rootfs for packages")
+	}
+	logrus.Infof("Found %d packages, writing SBOM to %s", len(pkgs), path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Write(f, pkgs)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Priority: optional
+Architecture: amd64
+Version: 2.36-9+deb12u4
+Description: GNU C Library: Shared libraries
+ Contains the standard libraries that are used by nearly all programs on
+ the system.
+
+Package: removed-pkg
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: base-files
+Status: install ok installed
+Architecture: amd64
+Version: 12.4+deb12u5
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+T:the musl c library
+
+P:busybox
+V:1.36.1-r5
+A:x86_64
+`
+
+const debianOSRelease = `PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+VERSION_CODENAME=bookworm
+ID=debian
+`
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected []Package
+	}{
+		{
+			name:     "empty rootfs",
+			files:    map[string]string{},
+			expected: nil,
+		},
+		{
+			name: "dpkg status",
+			files: map[string]string{
+				dpkgStatusPath: dpkgStatus,
+			},
+			expected: []Package{
+				{Name: "base-files", Version: "12.4+deb12u5", Architecture: "amd64", Type: "deb"},
+				{Name: "libc6", Version: "2.36-9+deb12u4", Architecture: "amd64", Type: "deb"},
+			},
+		},
+		{
+			name: "distroless dpkg status directory",
+			files: map[string]string{
+				filepath.Join(dpkgStatusDirPath, "tzdata"): "Package: tzdata\nVersion: 2024a-0+deb12u1\nArchitecture: all\n",
+			},
+			expected: []Package{
+				{Name: "tzdata", Version: "2024a-0+deb12u1", Architecture: "all", Type: "deb"},
+			},
+		},
+		{
+			name: "apk installed",
+			files: map[string]string{
+				apkInstalledPath: apkInstalled,
+			},
+			expected: []Package{
+				{Name: "busybox", Version: "1.36.1-r5", Architecture: "x86_64", Type: "apk"},
+				{Name: "musl", Version: "1.2.4-r2", Architecture: "x86_64", Type: "apk"},
+			},
+		},
+		{
+			name: "dpkg status with os-release",
+			files: map[string]string{
+				dpkgStatusPath: dpkgStatus,
+				osReleasePath:  debianOSRelease,
+			},
+			expected: []Package{
+				{Name: "base-files", Version: "12.4+deb12u5", Architecture: "amd64", Type: "deb", Namespace: "debian", Distro: "debian-12"},
+				{Name: "libc6", Version: "2.36-9+deb12u4", Architecture: "amd64", Type: "deb", Namespace: "debian", Distro: "debian-12"},
+			},
+		},
+		{
+			name: "apk installed with os-release in /usr/lib",
+			files: map[string]string{
+				apkInstalledPath: apkInstalled,
+				libOSReleasePath: "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.19.1\n",
+			},
+			expected: []Package{
+				{Name: "busybox", Version: "1.36.1-r5", Architecture: "x86_64", Type: "apk", Namespace: "alpine", Distro: "alpine-3.19.1"},
+				{Name: "musl", Version: "1.2.4-r2", Architecture: "x86_64", Type: "apk", Namespace: "alpine", Distro: "alpine-3.19.1"},
+			},
+		},
+		{
+			name: "os-release without VERSION_ID",
+			files: map[string]string{
+				filepath.Join(dpkgStatusDirPath, "tzdata"): "Package: tzdata\nVersion: 2024a-0+deb12u1\nArchitecture: all\n",
+				osReleasePath: "ID='debian'\n",
+			},
+			expected: []Package{
+				{Name: "tzdata", Version: "2024a-0+deb12u1", Architecture: "all", Type: "deb", Namespace: "debian", Distro: "debian"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := testutil.SetupFiles(root, tt.files); err != nil {
+				t.Fatal(err)
+			}
+			pkgs, err := Scan(root)
+			testutil.CheckErrorAndDeepEqual(t, false, err, tt.expected, pkgs)
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	root := t.TempDir()
+	if err := testutil.SetupFiles(root, map[string]string{dpkgStatusPath: dpkgStatus, osReleasePath: debianOSRelease}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "out", "sbom.json")
+	if err := WriteFile(path, root); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc cycloneDXDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, bomFormat, doc.BOMFormat)
+	testutil.CheckDeepEqual(t, []cycloneDXComponent{
+		{Type: "library", Name: "base-files", Version: "12.4+deb12u5", PURL: "pkg:deb/debian/base-files@12.4+deb12u5?arch=amd64&distro=debian-12"},
+		{Type: "library", Name: "libc6", Version: "2.36-9+deb12u4", PURL: "pkg:deb/debian/libc6@2.36-9+deb12u4?arch=amd64&distro=debian-12"},
+	}, doc.Components)
+}
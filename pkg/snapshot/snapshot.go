@@ -19,6 +19,8 @@ package snapshot
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -30,10 +32,18 @@ import (
 	"github.com/osscontainertools/kaniko/pkg/timing"
 	"github.com/osscontainertools/kaniko/pkg/util"
 
+	"github.com/moby/go-archive"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
 
+const (
+	// WhiteoutIndividual whites out every deleted file on its own
+	WhiteoutIndividual = "individual"
+	// WhiteoutOpaque whites out a directory whose files were all deleted with an opaque whiteout
+	WhiteoutOpaque = "opaque"
+)
+
 // For testing
 var snapshotPathPrefix = ""
 
@@ -42,6 +52,10 @@ type Snapshotter struct {
 	l          *LayeredMap
 	directory  string
 	ignorelist []util.IgnoreListEntry
+	// retries is how often a file that vanished during the snapshot is retried
+	retries int
+	// opaqueWhiteouts replaces the whiteouts of all files of a directory by an opaque whiteout
+	opaqueWhiteouts bool
 }
 
 // NewSnapshotter creates a new snapshotter rooted at d
@@ -49,6 +63,25 @@ func NewSnapshotter(l *LayeredMap, d string) *Snapshotter {
 	return &Snapshotter{l: l, directory: d, ignorelist: util.IgnoreList()}
 }
 
+// SetRetries sets how often a file that vanishes during the snapshot is retried before it is skipped
+func (s *Snapshotter) SetRetries(retries int) {
+	s.retries = retries
+}
+
+// SetWhiteoutStrategy sets how deleted files are represented in the layer, one of
+// WhiteoutIndividual (the default) or WhiteoutOpaque.
+func (s *Snapshotter) SetWhiteoutStrategy(strategy string) error {
+	switch strategy {
+	case "", WhiteoutIndividual:
+		s.opaqueWhiteouts = false
+	case WhiteoutOpaque:
+		s.opaqueWhiteouts = true
+	default:
+		return fmt.Errorf("%s is not a valid whiteout strategy", strategy)
+	}
+	return nil
+}
+
 // Init initializes a new snapshotter
 func (s *Snapshotter) Init() error {
 	logrus.Info("Initializing snapshotter ...")
@@ -61,6 +94,11 @@ func (s *Snapshotter) Key() (string, error) {
 	return s.l.Key()
 }
 
+// Paths returns the paths of the filesystem as of the last snapshot
+func (s *Snapshotter) Paths() map[string]struct{} {
+	return s.l.GetCurrentPaths()
+}
+
 // TakeSnapshot takes a snapshot of the specified files, avoiding directories in the ignorelist, and creates
 // a tarball of the changed files. Return contents of the tarball, and whether or not any files were changed
 func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string, error) {
@@ -75,6 +113,7 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 	defer f.Close()
 
 	s.l.Snapshot()
+	lowerPaths := s.lowerPaths()
 
 	filesToAdd, err := filesystem.ResolvePaths(files, s.ignorelist)
 	if err != nil {
@@ -87,10 +126,9 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 	logrus.Debugf("Adding to layer: %v", filesToAdd)
 
 	// Add files to current layer.
-	for _, file := range filesToAdd {
-		if err := s.l.Add(file); err != nil {
-			return "", fmt.Errorf("Unable to add file %s to layered map: %w", file, err)
-		}
+	filesToAdd, err = s.addFiles(filesToAdd)
+	if err != nil {
+		return "", err
 	}
 
 	// Get whiteout paths
@@ -98,7 +136,7 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 	if shdCheckDelete {
 		_, deletedFiles, err := util.WalkFS(s.directory, s.l.GetCurrentPaths(), func(s string) (bool, error) {
 			return true, nil
-		})
+		}, s.retries)
 		if err != nil {
 			return "", err
 		}
@@ -111,7 +149,7 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 			}
 		}
 
-		filesToWhiteout = removeObsoleteWhiteouts(deletedFiles)
+		filesToWhiteout = s.whiteouts(lowerPaths, removeObsoleteWhiteouts(deletedFiles))
 		sort.Strings(filesToWhiteout)
 	}
 
@@ -150,6 +188,27 @@ func (s *Snapshotter) TakeSnapshotFS() (string, error) {
 	return f.Name(), nil
 }
 
+// StreamSnapshotFS scans the filesystem like TakeSnapshotFS, but instead of writing
+// the tarball of the changed files to disk it returns a function that generates the
+// tarball each time it is called. The files must not change until it is read, their
+// access times are left out as reading them for one tarball may change them.
+func (s *Snapshotter) StreamSnapshotFS() (func() (io.ReadCloser, error), error) {
+	filesToAdd, filesToWhiteOut, err := s.scanFullFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	return func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			t := util.NewStableTar(pw)
+			err := writeToTar(t, filesToAdd, filesToWhiteOut)
+			t.Close()
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}, nil
+}
+
 func (s *Snapshotter) getSnashotPathPrefix() string {
 	if snapshotPathPrefix == "" {
 		return config.KanikoLayersDir
@@ -189,7 +248,8 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 
 	logrus.Debugf("Current image filesystem: %v", s.l.currentImage)
 
-	changedPaths, deletedPaths, err := util.WalkFS(s.directory, s.l.GetCurrentPaths(), s.l.CheckFileChange)
+	lowerPaths := s.lowerPaths()
+	changedPaths, deletedPaths, err := util.WalkFS(s.directory, s.l.GetCurrentPaths(), s.l.CheckFileChange, s.retries)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -212,10 +272,9 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 	logrus.Debugf("Deleting in layer: %v", deletedPaths)
 
 	// Add files to the layered map
-	for _, file := range filesToAdd {
-		if err := s.l.Add(file); err != nil {
-			return nil, nil, fmt.Errorf("Unable to add file %s to layered map: %w", file, err)
-		}
+	filesToAdd, err = s.addFiles(filesToAdd)
+	if err != nil {
+		return nil, nil, err
 	}
 	for file := range deletedPaths {
 		if err := s.l.AddDelete(file); err != nil {
@@ -223,7 +282,7 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 		}
 	}
 
-	filesToWhiteout := removeObsoleteWhiteouts(deletedPaths)
+	filesToWhiteout := s.whiteouts(lowerPaths, removeObsoleteWhiteouts(deletedPaths))
 	timing.DefaultRun.Stop(timer)
 
 	sort.Strings(filesToAdd)
@@ -232,6 +291,22 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 	return filesToAdd, filesToWhiteout, nil
 }
 
+// addFiles adds files to the layered map and returns the files that were added,
+// files that vanished in the meantime are skipped.
+func (s *Snapshotter) addFiles(files []string) ([]string, error) {
+	added := make([]string, 0, len(files))
+	for _, file := range files {
+		if err := s.l.Add(file); errors.Is(err, fs.ErrNotExist) {
+			logrus.Warnf("File %s vanished during the snapshot, skipping it", file)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to add file %s to layered map: %w", file, err)
+		}
+		added = append(added, file)
+	}
+	return added, nil
+}
+
 // removeObsoleteWhiteouts filters deleted files according to their parents delete status.
 func removeObsoleteWhiteouts(deletedFiles map[string]struct{}) (filesToWhiteout []string) {
 
@@ -247,6 +322,46 @@ func removeObsoleteWhiteouts(deletedFiles map[string]struct{}) (filesToWhiteout
 	return filesToWhiteout
 }
 
+// lowerPaths returns the paths of the previous layers, they are only needed for opaque whiteouts.
+func (s *Snapshotter) lowerPaths() map[string]struct{} {
+	if !s.opaqueWhiteouts {
+		return nil
+	}
+	return s.l.GetCurrentPaths()
+}
+
+// whiteouts returns the whiteouts to write for the deleted files. With opaque whiteouts
+// the whiteouts of a directory whose files of the previous layers were all deleted are
+// replaced by the opaque whiteout `<dir>/.wh..wh..opq`.
+func (s *Snapshotter) whiteouts(lowerPaths map[string]struct{}, deleted []string) []string {
+	if !s.opaqueWhiteouts {
+		return deleted
+	}
+	lowerChildren := map[string]int{}
+	for path := range lowerPaths {
+		lowerChildren[filepath.Dir(path)]++
+	}
+	deletedChildren := map[string]int{}
+	for _, path := range deleted {
+		deletedChildren[filepath.Dir(path)]++
+	}
+
+	whiteouts := []string{}
+	for _, path := range deleted {
+		dir := filepath.Dir(path)
+		if dir == s.directory || deletedChildren[dir] != lowerChildren[dir] {
+			whiteouts = append(whiteouts, path)
+		}
+	}
+	for dir, n := range deletedChildren {
+		if dir != s.directory && n == lowerChildren[dir] {
+			logrus.Tracef("Adding opaque whiteout for %s", dir)
+			whiteouts = append(whiteouts, filepath.Join(dir, archive.WhiteoutOpaqueDir))
+		}
+	}
+	return whiteouts
+}
+
 func writeToTar(t util.Tar, files, whiteouts []string) error {
 	timer := timing.Start("Writing tar file")
 	defer timing.DefaultRun.Stop(timer)
@@ -267,6 +382,12 @@ func writeToTar(t util.Tar, files, whiteouts []string) error {
 		if err := addParentDirectories(t, addedPaths, path); err != nil {
 			return err
 		}
+		if filepath.Base(path) == archive.WhiteoutOpaqueDir {
+			if err := t.OpaqueWhiteout(filepath.Dir(path)); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := t.Whiteout(path); err != nil {
 			return err
 		}
@@ -279,6 +400,10 @@ func writeToTar(t util.Tar, files, whiteouts []string) error {
 		if _, pathAdded := addedPaths[path]; pathAdded {
 			continue
 		}
+		if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			logrus.Warnf("File %s vanished during the snapshot, skipping it", path)
+			continue
+		}
 		if err := t.AddFileToTar(path); err != nil {
 			return err
 		}
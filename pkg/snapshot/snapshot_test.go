@@ -18,12 +18,16 @@ package snapshot
 
 import (
 	"archive/tar"
+	"crypto/sha256"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/util"
@@ -96,6 +100,118 @@ func TestSnapshotFSFileChange(t *testing.T) {
 	}
 }
 
+func TestSnapshotFSFileVanishes(t *testing.T) {
+	testDir, snapshotter, cleanup, err := setUpTest(t)
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFiles := map[string]string{
+		"new/a": "a",
+		"new/b": "b",
+	}
+	if err := testutil.SetupFiles(testDir, newFiles); err != nil {
+		t.Fatalf("Error setting up fs: %s", err)
+	}
+	// new/b is removed while the walk is in progress
+	hasher := snapshotter.l.hasher
+	snapshotter.l.hasher = func(p string) (string, error) {
+		if p == filepath.Join(testDir, "new/a") {
+			if err := os.Remove(filepath.Join(testDir, "new/b")); err != nil {
+				return "", err
+			}
+		}
+		return hasher(p)
+	}
+
+	tarPath, err := snapshotter.TakeSnapshotFS()
+	if err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	actualFiles, err := listFilesInTar(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDirWithoutLeadingSlash := strings.TrimLeft(testDir, "/")
+	expectedFiles := []string{filepath.Join(testDirWithoutLeadingSlash, "new/a")}
+	for _, path := range util.ParentDirectoriesWithoutLeadingSlash(filepath.Join(testDirWithoutLeadingSlash, "new/a")) {
+		if path == config.RootDir {
+			continue
+		}
+		expectedFiles = append(expectedFiles, path+"/")
+	}
+	sort.Strings(expectedFiles)
+	sort.Strings(actualFiles)
+	testutil.CheckDeepEqual(t, expectedFiles, actualFiles)
+}
+
+func TestSnapshotFSWhiteoutStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		strategy  string
+		whiteouts []string
+	}{
+		{
+			strategy:  WhiteoutIndividual,
+			whiteouts: []string{"dir/.wh.a", "dir/.wh.b", "dir/.wh.sub", "other/.wh.x"},
+		},
+		{
+			strategy:  WhiteoutOpaque,
+			whiteouts: []string{"dir/.wh..wh..opq", "other/.wh.x"},
+		},
+	} {
+		t.Run(tc.strategy, func(t *testing.T) {
+			testDir, snapshotter, cleanup, err := setUpTest(t)
+			defer cleanup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckNoError(t, snapshotter.SetWhiteoutStrategy(tc.strategy))
+			newFiles := map[string]string{
+				"dir/a":     "a",
+				"dir/b":     "b",
+				"dir/sub/c": "c",
+				"other/x":   "x",
+				"other/y":   "y",
+			}
+			if err := testutil.SetupFiles(testDir, newFiles); err != nil {
+				t.Fatalf("Error setting up fs: %s", err)
+			}
+			if _, err := snapshotter.TakeSnapshotFS(); err != nil {
+				t.Fatalf("Error taking snapshot of fs: %s", err)
+			}
+
+			// delete the contents of dir, but keep dir itself
+			for _, f := range []string{"dir/a", "dir/b", "dir/sub", "other/x"} {
+				if err := os.RemoveAll(filepath.Join(testDir, f)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tarPath, err := snapshotter.TakeSnapshotFS()
+			if err != nil {
+				t.Fatalf("Error taking snapshot of fs: %s", err)
+			}
+			actualFiles, err := listFilesInTar(tarPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testDirWithoutLeadingSlash := strings.TrimLeft(testDir, "/")
+			whiteouts := []string{}
+			for _, f := range actualFiles {
+				if strings.HasPrefix(filepath.Base(f), ".wh.") {
+					whiteouts = append(whiteouts, strings.TrimPrefix(f, testDirWithoutLeadingSlash+"/"))
+				}
+			}
+			sort.Strings(whiteouts)
+			testutil.CheckDeepEqual(t, tc.whiteouts, whiteouts)
+		})
+	}
+}
+
+func TestSetWhiteoutStrategy_invalid(t *testing.T) {
+	snapshotter := NewSnapshotter(NewLayeredMap(util.Hasher()), t.TempDir())
+	testutil.CheckError(t, true, snapshotter.SetWhiteoutStrategy("aufs"))
+}
+
 func TestSnapshotFSIsReproducible(t *testing.T) {
 	testDir, snapshotter, cleanup, err := setUpTest(t)
 	defer cleanup()
@@ -126,6 +242,78 @@ func TestSnapshotFSIsReproducible(t *testing.T) {
 	}
 }
 
+func TestStreamSnapshotFS(t *testing.T) {
+	testDir, snapshotter, cleanup, err := setUpTest(t)
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFiles := map[string]string{
+		"foo":     "newbaz1",
+		"bar/bat": "baz",
+	}
+	if err := testutil.SetupFiles(testDir, newFiles); err != nil {
+		t.Fatalf("Error setting up fs: %s", err)
+	}
+	layersBefore, _ := os.ReadDir(config.KanikoLayersDir)
+	open, err := snapshotter.StreamSnapshotFS()
+	if err != nil {
+		t.Fatalf("Error streaming snapshot of fs: %s", err)
+	}
+	// nothing is written to disk, the tarball is generated each time it is read
+	layersAfter, _ := os.ReadDir(config.KanikoLayersDir)
+	testutil.CheckDeepEqual(t, len(layersBefore), len(layersAfter))
+
+	// the tarball is generated again for the push, reading the files in between
+	// may update their access times on relatime mounts
+	var tars [][]byte
+	var digests []string
+	for i := range 2 {
+		if i > 0 {
+			for path := range newFiles {
+				p := filepath.Join(testDir, path)
+				fi, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(p, time.Now().Add(time.Hour), fi.ModTime()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		rc, err := open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tars = append(tars, b)
+		digests = append(digests, fmt.Sprintf("%x", sha256.Sum256(b)))
+	}
+	testutil.CheckDeepEqual(t, digests[0], digests[1])
+
+	var filesInTar []string
+	tr := tar.NewReader(strings.NewReader(string(tars[0])))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		filesInTar = append(filesInTar, hdr.Name)
+	}
+	for path := range newFiles {
+		if !slices.Contains(filesInTar, strings.TrimPrefix(filepath.Join(testDir, path), "/")) {
+			t.Errorf("expected %s in the streamed snapshot, got %v", path, filesInTar)
+		}
+	}
+}
+
 func TestSnapshotFSChangePermissions(t *testing.T) {
 	testDir, snapshotter, cleanup, err := setUpTest(t)
 	testDirWithoutLeadingSlash := strings.TrimLeft(testDir, "/")
@@ -278,6 +466,32 @@ func TestSnapshotFiles(t *testing.T) {
 	testutil.CheckErrorAndDeepEqual(t, false, nil, expectedFiles, actualFiles)
 }
 
+func TestSnapshotFilesEmptyDir(t *testing.T) {
+	testDir, snapshotter, cleanup, err := setUpTest(t)
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// COPY emptydir/ dest/ only snapshots dest, it must still be in the layer
+	dest := filepath.Join(testDir, "dest")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tarPath, err := snapshotter.TakeSnapshot([]string{dest}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tarPath)
+
+	actualFiles, err := listFilesInTar(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(actualFiles, strings.TrimLeft(dest, "/")+"/") {
+		t.Errorf("expected the empty directory %s in the layer, got %v", dest, actualFiles)
+	}
+}
+
 func TestEmptySnapshotFS(t *testing.T) {
 	_, snapshotter, cleanup, err := setUpTest(t)
 	if err != nil {
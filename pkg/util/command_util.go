@@ -34,6 +34,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 )
 
 // for testing
@@ -98,15 +99,84 @@ func ResolveEnvAndWildcards(sd instructions.SourcesAndDest, fileContext FileCont
 	}
 	dest := dests[0]
 	sd.DestPath = dest
+	if fileContext.StrictSources {
+		if err := checkSourcesMatch(resolvedEnvs, fileContext); err != nil {
+			return nil, "", err
+		}
+	}
 	// Resolve wildcards and get a list of resolved sources
 	srcs, err := ResolveSources(resolvedEnvs, fileContext.Root)
 	if err != nil {
 		return nil, "", errors.Wrap(err, "failed to resolve sources")
 	}
+	if fileContext.RootedSymlinks {
+		srcs, err = resolveSourcesInRoot(srcs, fileContext.Root)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to resolve symlinks in sources")
+		}
+	}
+	// overlapping wildcards can match the same source more than once
+	srcs = UniquePaths(srcs)
 	err = IsSrcsValid(sd, srcs, fileContext)
 	return srcs, dest, err
 }
 
+// UniquePaths returns paths without the entries that are equal to an entry before them
+// once cleaned, the order of the first occurrences is kept.
+func UniquePaths(paths []string) []string {
+	seen := make(map[string]struct{}, len(paths))
+	unique := make([]string, 0, len(paths))
+	for _, p := range paths {
+		key := p
+		if !IsSrcRemoteFileURL(p) {
+			key = filepath.Clean(p)
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, p)
+	}
+	return unique
+}
+
+// CaseCollisions returns the pairs of paths, of which the first one is in paths,
+// that are in the same directory and differ only by case. Each pair is returned once.
+func CaseCollisions(paths []string) ([][2]string, error) {
+	dirs := map[string]map[string][]string{}
+	seen := map[[2]string]struct{}{}
+	var collisions [][2]string
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		dir, base := filepath.Split(p)
+		names, ok := dirs[dir]
+		if !ok {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			names = map[string][]string{}
+			for _, e := range entries {
+				lower := strings.ToLower(e.Name())
+				names[lower] = append(names[lower], e.Name())
+			}
+			dirs[dir] = names
+		}
+		for _, other := range names[strings.ToLower(base)] {
+			if other == base {
+				continue
+			}
+			otherPath := filepath.Join(dir, other)
+			if _, ok := seen[[2]string{otherPath, p}]; ok {
+				continue
+			}
+			seen[[2]string{p, otherPath}] = struct{}{}
+			collisions = append(collisions, [2]string{p, otherPath})
+		}
+	}
+	return collisions, nil
+}
+
 // ContainsWildcards returns true if any entry in paths contains wildcards
 func ContainsWildcards(paths []string) bool {
 	for _, path := range paths {
@@ -137,6 +207,75 @@ func ResolveSources(srcs []string, root string) ([]string, error) {
 	return resolved, nil
 }
 
+// checkSourcesMatch returns an error naming the first of srcs that matches no file
+// or only excluded ones. Directories count as a match, they may contain files that
+// are not excluded.
+func checkSourcesMatch(srcs []string, fileContext FileContext) error {
+	var files []string
+	for _, src := range srcs {
+		if IsSrcRemoteFileURL(src) {
+			continue
+		}
+		matches := []string{src}
+		if ContainsWildcards(matches) {
+			var err error
+			if files == nil {
+				if files, err = RelativeFiles("", fileContext.Root); err != nil {
+					return errors.Wrap(err, "resolving sources")
+				}
+			}
+			if matches, err = matchSources(matches, files); err != nil {
+				return errors.Wrap(err, "matching sources")
+			}
+		}
+		matched := false
+		for _, m := range matches {
+			fi, err := fileContext.LstatSource(filepath.Join(fileContext.Root, m))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() || !fileContext.ExcludesFile(m) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("source %s matches no files and --strict-context is set", src)
+		}
+	}
+	return nil
+}
+
+// resolveSourcesInRoot resolves the symlinks in the parent directories of srcs inside root.
+// The sources themselves are kept, so that a symlink is copied as symlink.
+func resolveSourcesInRoot(srcs []string, root string) ([]string, error) {
+	resolved := make([]string, 0, len(srcs))
+	for _, src := range srcs {
+		if IsSrcRemoteFileURL(src) {
+			resolved = append(resolved, src)
+			continue
+		}
+		cleaned := filepath.Clean(src)
+		dir, _, err := ResolvePathInRoot(root, filepath.Dir(cleaned))
+		if err != nil {
+			return nil, err
+		}
+		dir, err = filepath.Rel(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		p := filepath.Join(dir, filepath.Base(cleaned))
+		if strings.HasSuffix(src, pathSeparator) && !strings.HasSuffix(p, pathSeparator) {
+			p += pathSeparator
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
 // matchSources returns a list of sources that match wildcards
 func matchSources(srcs, files []string) ([]string, error) {
 	var matchedSources []string
@@ -150,6 +289,10 @@ func matchSources(srcs, files []string) ([]string, error) {
 			if filepath.IsAbs(src) {
 				file = filepath.Join(config.RootDir, file)
 			}
+			// the root of the context is only copied by name, * doesn't match it
+			if file == "." && src != "." {
+				continue
+			}
 			matched, err := filepath.Match(src, file)
 			if err != nil {
 				return nil, err
@@ -181,7 +324,7 @@ func IsDestDir(path string) bool {
 //
 // If source is a dir:
 //
-//	Assume dest is also a dir, and copy to dest/
+//	Assume dest is also a dir, and copy to dest/, it fails if dest is an existing file
 //
 // If dest is not an absolute filepath, add /cwd to the beginning
 func DestinationFilepath(src, dest, cwd string) (string, error) {
@@ -190,8 +333,9 @@ func DestinationFilepath(src, dest, cwd string) (string, error) {
 
 	if !filepath.IsAbs(newDest) {
 		newDest = filepath.Join(cwd, newDest)
-		// join call clean on all results.
-		if strings.HasSuffix(dest, pathSeparator) || strings.HasSuffix(dest, ".") {
+		// join call clean on all results, a dest of . or .. is a directory
+		// but a file name may end in a dot as well.
+		if base := filepath.Base(dest); strings.HasSuffix(dest, pathSeparator) || base == "." || base == ".." {
 			newDest += pathSeparator
 		}
 	}
@@ -199,8 +343,13 @@ func DestinationFilepath(src, dest, cwd string) (string, error) {
 		newDest = filepath.Join(newDest, srcFileName)
 	}
 
-	if len(srcFileName) <= 0 && !strings.HasSuffix(newDest, pathSeparator) {
-		newDest += pathSeparator
+	if len(srcFileName) <= 0 {
+		if fi, err := os.Stat(filepath.Clean(newDest)); err == nil && !fi.IsDir() {
+			return "", UserError(errors.Errorf("cannot copy a directory to %s: not a directory (a file exists)", filepath.Clean(newDest)))
+		}
+		if !strings.HasSuffix(newDest, pathSeparator) {
+			newDest += pathSeparator
+		}
 	}
 
 	return newDest, nil
@@ -256,7 +405,7 @@ func IsSrcsValid(srcsAndDest instructions.SourcesAndDest, resolvedSources []stri
 			return nil
 		}
 		path := filepath.Join(fileContext.Root, resolvedSources[0])
-		fi, err := os.Lstat(path)
+		fi, err := fileContext.LstatSource(path)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("failed to get fileinfo for %v", path))
 		}
@@ -272,6 +421,12 @@ func IsSrcsValid(srcsAndDest instructions.SourcesAndDest, resolvedSources []stri
 			continue
 		}
 		src = filepath.Clean(src)
+		if _, ok, err := fileContext.ProvidedSource(filepath.Join(fileContext.Root, src)); err != nil {
+			return err
+		} else if ok {
+			totalFiles++
+			continue
+		}
 		files, err := RelativeFiles(src, fileContext.Root)
 		if err != nil {
 			return errors.Wrap(err, "failed to get relative files")
@@ -315,11 +470,13 @@ func UpdateConfigEnv(envVars []instructions.KeyValuePair, config *v1.Config, rep
 
 	// First, convert config.Env array to []instruction.KeyValuePair
 	var kvps []instructions.KeyValuePair
+	// Entries of the base image without "=" are kept as they are
 	for _, env := range config.Env {
-		entry := strings.SplitN(env, "=", 2)
+		key, value, found := strings.Cut(env, "=")
 		kvps = append(kvps, instructions.KeyValuePair{
-			Key:   entry[0],
-			Value: entry[1],
+			Key:     key,
+			Value:   value,
+			NoDelim: !found,
 		})
 	}
 	// Iterate through new environment variables, and replace existing keys
@@ -341,6 +498,9 @@ Loop:
 	envArray := []string{}
 	for _, kvp := range kvps {
 		entry := kvp.Key + "=" + kvp.Value
+		if kvp.NoDelim {
+			entry = kvp.Key
+		}
 		envArray = append(envArray, entry)
 	}
 	config.Env = envArray
@@ -348,18 +508,31 @@ Loop:
 }
 
 func GetActiveUserGroup(configUser string, chownStr string, replacementEnvs []string) (int64, int64, error) {
+	return activeUserGroup(configUser, chownStr, replacementEnvs, GetUserGroup)
+}
+
+// ResolveActiveUserGroup returns the same ids as GetActiveUserGroup without warning
+// about ids that are not in the passwd or group file, for cache keys computed ahead of
+// the command.
+func ResolveActiveUserGroup(configUser string, chownStr string, replacementEnvs []string) (int64, int64, error) {
+	return activeUserGroup(configUser, chownStr, replacementEnvs, func(chownStr string, env []string) (int64, int64, error) {
+		return resolveUserGroupIn(config.RootDir, chownStr, env, false)
+	})
+}
+
+func activeUserGroup(configUser string, chownStr string, replacementEnvs []string, getUserGroup func(string, []string) (int64, int64, error)) (int64, int64, error) {
 	user, err := user.Current()
 	if err != nil {
 		return DoNotChangeUID, DoNotChangeGID, errors.Wrapf(err, "failed to lookup current user")
 	}
-	uid32, gid32, err := getUIDAndGIDFunc(user.Uid, user.Gid)
+	uid32, gid32, err := getUIDAndGIDFunc(config.RootDir, user.Uid, user.Gid)
 	if err != nil {
 		return DoNotChangeUID, DoNotChangeGID, errors.Wrapf(err, "Failed parsing uid and gid %s:%s", user.Uid, user.Gid)
 	}
 	uid, gid := int64(uid32), int64(gid32)
 
 	if configUser != "" {
-		ouid, ogid, err := GetUserGroup(configUser, replacementEnvs)
+		ouid, ogid, err := getUserGroup(configUser, replacementEnvs)
 		if err != nil {
 			return DoNotChangeUID, DoNotChangeGID, errors.Wrapf(err, "identifying uid and gid for user %s", configUser)
 		}
@@ -372,7 +545,7 @@ func GetActiveUserGroup(configUser string, chownStr string, replacementEnvs []st
 	}
 
 	if chownStr != "" {
-		ouid, ogid, err := GetUserGroup(chownStr, replacementEnvs)
+		ouid, ogid, err := getUserGroup(chownStr, replacementEnvs)
 		if err != nil {
 			return DoNotChangeUID, DoNotChangeGID, errors.Wrap(err, "getting user group from chown")
 		}
@@ -387,7 +560,25 @@ func GetActiveUserGroup(configUser string, chownStr string, replacementEnvs []st
 	return uid, gid, nil
 }
 
+// GetUserGroup returns the uid and gid of chownStr, names are resolved with the
+// passwd and group files of the stage being built.
 func GetUserGroup(chownStr string, env []string) (int64, int64, error) {
+	return GetUserGroupIn(config.RootDir, chownStr, env)
+}
+
+// GetUserGroupIn returns the uid and gid of chownStr, names are resolved with the
+// passwd and group files of the filesystem at root, ie. the one of a COPY --from stage.
+func GetUserGroupIn(root string, chownStr string, env []string) (int64, int64, error) {
+	return resolveUserGroupIn(root, chownStr, env, true)
+}
+
+// ResolveUserGroupIn returns the same ids as GetUserGroupIn without warning about ids
+// that are not in the passwd or group file.
+func ResolveUserGroupIn(root string, chownStr string, env []string) (int64, int64, error) {
+	return resolveUserGroupIn(root, chownStr, env, false)
+}
+
+func resolveUserGroupIn(root string, chownStr string, env []string, warn bool) (int64, int64, error) {
 	if chownStr == "" {
 		return DoNotChangeUID, DoNotChangeGID, nil
 	}
@@ -397,14 +588,60 @@ func GetUserGroup(chownStr string, env []string) (int64, int64, error) {
 		return -1, -1, err
 	}
 
-	uid32, gid32, err := getUIDAndGIDFromString(chown)
+	uid32, gid32, err := getUIDAndGIDFromString(root, chown)
 	if err != nil {
 		return -1, -1, err
 	}
+	if !warn {
+		return int64(uid32), int64(gid32), nil
+	}
+	if unresolved := unresolvedIDs(root, chown); len(unresolved) > 0 {
+		diagnostics.Warnf(diagnostics.ChownUnresolved, "%s of %s not found, using the numeric ids %d:%d", strings.Join(unresolved, " and "), chown, uid32, gid32)
+	}
 
 	return int64(uid32), int64(gid32), nil
 }
 
+// IsNumericUserGroup returns true if the user and group of userGroupString are ids,
+// which resolve to the same uid and gid whatever the passwd and group files.
+func IsNumericUserGroup(userGroupString string, env []string) bool {
+	userGroup, err := ResolveEnvironmentReplacement(userGroupString, env, false)
+	if err != nil {
+		return false
+	}
+	userStr, groupStr, _ := strings.Cut(userGroup, ":")
+	for _, id := range []string{userStr, groupStr} {
+		if id == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// unresolvedIDs returns the user and group of userGroupString that are neither known by
+// name nor by id, ie. numeric ids that are not in the passwd or group file of root.
+func unresolvedIDs(root string, userGroupString string) []string {
+	var unresolved []string
+	userStr, groupStr, _ := strings.Cut(userGroupString, ":")
+	if _, err := lookupUserIn(root, userStr); err != nil {
+		unresolved = append(unresolved, "user "+userStr)
+	}
+	if groupStr != "" {
+		if _, err := lookupGroupIn(root, groupStr); err != nil {
+			unresolved = append(unresolved, "group "+groupStr)
+		}
+	}
+	return unresolved
+}
+
+// ChmodPreserve is the --chmod value that keeps the mode of the sources, like no
+// --chmod does. Any other spelling of zero, like 000, is the explicit mode 0000.
+const ChmodPreserve = "0"
+
+// GetChmod returns the mode of --chmod, useDefault is true if the sources keep their mode.
 func GetChmod(chmodStr string, env []string) (chmod fs.FileMode, useDefault bool, err error) {
 	if chmodStr == "" {
 		return fs.FileMode(0o644), true, nil
@@ -414,6 +651,9 @@ func GetChmod(chmodStr string, env []string) (chmod fs.FileMode, useDefault bool
 	if err != nil {
 		return 0, false, err
 	}
+	if chmodStr == ChmodPreserve {
+		return fs.FileMode(0o644), true, nil
+	}
 
 	mode, err := strconv.ParseUint(chmodStr, 8, 32)
 	if err != nil {
@@ -424,33 +664,37 @@ func GetChmod(chmodStr string, env []string) (chmod fs.FileMode, useDefault bool
 }
 
 // Extract user and group id from a string formatted 'user:group'.
-// UserID and GroupID don't need to be present on the system.
-func getUIDAndGIDFromString(userGroupString string) (uint32, uint32, error) {
+// UserID and GroupID don't need to be present in the filesystem at root.
+func getUIDAndGIDFromString(root string, userGroupString string) (uint32, uint32, error) {
 	userAndGroup := strings.Split(userGroupString, ":")
 	userStr := userAndGroup[0]
 	var groupStr string
 	if len(userAndGroup) > 1 {
 		groupStr = userAndGroup[1]
 	}
-	return getUIDAndGIDFunc(userStr, groupStr)
+	return getUIDAndGIDFunc(root, userStr, groupStr)
 }
 
-func getUIDAndGID(userStr string, groupStr string) (uint32, uint32, error) {
-	user, err := LookupUser(userStr)
+// getUIDAndGID returns the ids of the user and group, names are looked up in the
+// passwd and group files of the filesystem at root. Without a group the gid is the uid.
+func getUIDAndGID(root string, userStr string, groupStr string) (uint32, uint32, error) {
+	uidStr, err := lookupUserIn(root, userStr)
 	if err != nil {
-		return 0, 0, err
+		uidStr = userStr
 	}
-	uid32, err := getUID(user.Uid)
+	uid32, err := getUID(uidStr)
 	if err != nil {
-		return 0, 0, err
+		// at this point, the user does not exist and the userStr is not a valid number.
+		return 0, 0, fmt.Errorf("user %v is not a uid and does not exist on the system", userStr)
 	}
 
 	if groupStr != "" {
-		gid32, err := getGIDFromName(groupStr)
+		gidStr, err := lookupGroupIn(root, groupStr)
+		if err != nil {
+			gidStr = groupStr
+		}
+		gid32, err := getGID(gidStr)
 		if err != nil {
-			if errors.Is(err, fallbackToUIDError) {
-				return uid32, uid32, nil
-			}
 			return 0, 0, err
 		}
 		return uid32, gid32, nil
@@ -468,31 +712,6 @@ func getGID(groupStr string) (uint32, error) {
 	return uint32(gid), nil
 }
 
-// getGIDFromName tries to parse the groupStr into an existing group.
-func getGIDFromName(groupStr string) (uint32, error) {
-	group, err := user.LookupGroup(groupStr)
-	if err != nil {
-		// unknown group error could relate to a non existing group
-		var groupErr user.UnknownGroupError
-		if errors.As(err, &groupErr) {
-			return getGID(groupStr)
-		}
-		group, err = user.LookupGroupId(groupStr)
-		if err != nil {
-			return getGID(groupStr)
-		}
-	}
-	return getGID(group.Gid)
-}
-
-var fallbackToUIDError = new(fallbackToUIDErrorType)
-
-type fallbackToUIDErrorType struct{}
-
-func (e fallbackToUIDErrorType) Error() string {
-	return "fallback to uid"
-}
-
 // LookupUser will try to lookup the userStr inside the passwd file.
 // If the user does not exists, the function will fallback to parsing the userStr as an uid.
 func LookupUser(userStr string) (*user.User, error) {
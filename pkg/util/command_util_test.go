@@ -19,14 +19,18 @@ package util
 import (
 	"fmt"
 	"io/fs"
+	"os"
 	"os/user"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/testutil"
 )
 
@@ -199,6 +203,24 @@ var destinationFilepathTests = []struct {
 		dest:             ".",
 		expectedFilepath: "/test/foo",
 	},
+	{
+		src:              "context/foo",
+		cwd:              "/test",
+		dest:             "dir/..",
+		expectedFilepath: "/test/foo",
+	},
+	{
+		src:              "context/a b.txt",
+		cwd:              "/test",
+		dest:             "weird.",
+		expectedFilepath: "/test/weird.",
+	},
+	{
+		src:              "context/weird.",
+		cwd:              "/test",
+		dest:             "café/",
+		expectedFilepath: "/test/café/weird.",
+	},
 }
 
 func Test_DestinationFilepath(t *testing.T) {
@@ -281,6 +303,16 @@ var matchSourcesTests = []struct {
 			testURL,
 		},
 	},
+	{
+		srcs:          []string{"*", "."},
+		files:         []string{".", "a b.txt", "café.txt", "weird."},
+		expectedFiles: []string{".", "a b.txt", "café.txt", "weird."},
+	},
+	{
+		srcs:          []string{"*"},
+		files:         []string{".", "a b.txt", "café.txt", "weird."},
+		expectedFiles: []string{"a b.txt", "café.txt", "weird."},
+	},
 }
 
 func Test_MatchSources(t *testing.T) {
@@ -292,6 +324,11 @@ func Test_MatchSources(t *testing.T) {
 	}
 }
 
+func Test_UniquePaths(t *testing.T) {
+	paths := []string{"pkg/b", "pkg/a", "./pkg/b", "pkg/a/", testURL, "pkg/c", testURL}
+	testutil.CheckDeepEqual(t, []string{"pkg/b", "pkg/a", testURL, "pkg/c"}, UniquePaths(paths))
+}
+
 var updateConfigEnvTests = []struct {
 	name            string
 	envVars         []instructions.KeyValuePair
@@ -348,6 +385,19 @@ var updateConfigEnvTests = []struct {
 		config:          &v1.Config{Env: []string{"bob=used", "more=test"}},
 		replacementEnvs: []string{},
 		expectedEnv:     []string{"bob=cool", "more=test", "alice=nice"},
+	}, {
+		name: "test env config update with base image entries without value",
+		envVars: []instructions.KeyValuePair{
+			{
+				Key:   "bob",
+				Value: "cool",
+				// ENV bob cool
+				NoDelim: true,
+			},
+		},
+		config:          &v1.Config{Env: []string{"alice", "bob", "more=a=b"}},
+		replacementEnvs: []string{},
+		expectedEnv:     []string{"alice", "bob=cool", "more=a=b"},
 	},
 }
 
@@ -549,7 +599,7 @@ func TestGetUserGroup(t *testing.T) {
 		description  string
 		chown        string
 		env          []string
-		mockIDGetter func(userStr string, groupStr string) (uint32, uint32, error)
+		mockIDGetter func(root string, userStr string, groupStr string) (uint32, uint32, error)
 		// needed, in case uid is a valid number, but group is a name
 		mockGroupIDGetter func(groupStr string) (*user.Group, error)
 		expectedU         int64
@@ -560,7 +610,7 @@ func TestGetUserGroup(t *testing.T) {
 			description: "non empty chown",
 			chown:       "some:some",
 			env:         []string{},
-			mockIDGetter: func(string, string) (uint32, uint32, error) {
+			mockIDGetter: func(string, string, string) (uint32, uint32, error) {
 				return 100, 1000, nil
 			},
 			expectedU: 100,
@@ -570,7 +620,7 @@ func TestGetUserGroup(t *testing.T) {
 			description: "non empty chown with env replacement",
 			chown:       "some:$foo",
 			env:         []string{"foo=key"},
-			mockIDGetter: func(userStr string, groupStr string) (uint32, uint32, error) {
+			mockIDGetter: func(_ string, userStr string, groupStr string) (uint32, uint32, error) {
 				if userStr == "some" && groupStr == "key" {
 					return 10, 100, nil
 				}
@@ -581,7 +631,7 @@ func TestGetUserGroup(t *testing.T) {
 		},
 		{
 			description: "empty chown string",
-			mockIDGetter: func(string, string) (uint32, uint32, error) {
+			mockIDGetter: func(string, string, string) (uint32, uint32, error) {
 				return 0, 0, fmt.Errorf("should not be called")
 			},
 			expectedU: -1,
@@ -602,6 +652,42 @@ func TestGetUserGroup(t *testing.T) {
 	}
 }
 
+func TestGetUserGroup_rootfs(t *testing.T) {
+	original := config.RootDir
+	defer func() { config.RootDir = original }()
+	config.RootDir = t.TempDir()
+	if err := testutil.SetupFiles(config.RootDir, map[string]string{
+		"etc/passwd": "# users of the image\nroot:x:0:0:root:/root:/bin/sh\nkaniko-app:x:4321:4321::/home/app:/bin/sh\n",
+		"etc/group":  "root:x:0:\nkaniko-grp:x:5432:kaniko-app\n",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the names are those of the image, not of the host
+	uid, gid, err := GetUserGroup("kaniko-app:kaniko-grp", nil)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, int64(4321), uid)
+	testutil.CheckDeepEqual(t, int64(5432), gid)
+	uid, gid, err = GetUserGroup("kaniko-app", nil)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, int64(4321), uid)
+	testutil.CheckDeepEqual(t, int64(4321), gid)
+	testutil.CheckDeepEqual(t, []string(nil), unresolvedIDs(config.RootDir, "4321:5432"))
+	testutil.CheckDeepEqual(t, []string{"user 1", "group 2"}, unresolvedIDs(config.RootDir, "1:2"))
+
+	_, _, err = GetUserGroup("kaniko-missing", nil)
+	testutil.CheckError(t, true, err)
+
+	// another root, ie. the one of a COPY --from stage, has its own users
+	other := t.TempDir()
+	if err := testutil.SetupFiles(other, map[string]string{"etc/passwd": "kaniko-app:x:99:99::/:/bin/sh\n"}); err != nil {
+		t.Fatal(err)
+	}
+	uid, _, err = GetUserGroupIn(other, "kaniko-app", nil)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, int64(99), uid)
+}
+
 func TestGetChmod(t *testing.T) {
 	tests := []struct {
 		description string
@@ -626,6 +712,27 @@ func TestGetChmod(t *testing.T) {
 			description: "empty chmod string",
 			expected:    fs.FileMode(0o600),
 		},
+		{
+			description: "zero keeps the source mode",
+			chmod:       "0",
+			expected:    fs.FileMode(0o600),
+		},
+		{
+			description: "zero from env keeps the source mode",
+			chmod:       "$mode",
+			env:         []string{"mode=0"},
+			expected:    fs.FileMode(0o600),
+		},
+		{
+			description: "explicit zero mode",
+			chmod:       "000",
+			expected:    fs.FileMode(0),
+		},
+		{
+			description: "explicit zero mode with four digits",
+			chmod:       "0000",
+			expected:    fs.FileMode(0),
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.description, func(t *testing.T) {
@@ -803,7 +910,7 @@ func Test_GetUIDAndGIDFromString(t *testing.T) {
 		},
 	}
 	for _, tt := range testCases {
-		uid, gid, err := getUIDAndGIDFromString(tt.args.userGroupStr)
+		uid, gid, err := getUIDAndGIDFromString("/", tt.args.userGroupStr)
 		testutil.CheckError(t, tt.wantErr, err)
 		if uid != tt.expected.userID || gid != tt.expected.groupID {
 			t.Errorf("%v failed. Could not correctly decode %s to uid/gid %d:%d. Result: %d:%d",
@@ -902,3 +1009,58 @@ func TestIsSrcRemoteFileURL(t *testing.T) {
 		)
 	}
 }
+
+func Test_CaseCollisions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"File", "file", "other", "Other.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collisions, err := CaseCollisions([]string{filepath.Join(dir, "file"), filepath.Join(dir, "File"), filepath.Join(dir, "other")})
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, [][2]string{{filepath.Join(dir, "file"), filepath.Join(dir, "File")}}, collisions)
+}
+
+func TestResolveEnvAndWildcards_StrictSources(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{"app/main.go", "docs/ignored.md"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		srcs       []string
+		lenientErr bool
+		strictErr  bool
+	}{
+		{name: "matching sources", srcs: []string{"app/main.go", "app/*.go"}},
+		{name: "non matching literal source", srcs: []string{"app/typo.go"}, lenientErr: true, strictErr: true},
+		{name: "empty glob", srcs: []string{"app/main.go", "app/*.txt"}, strictErr: true},
+		{name: "glob matching excluded files", srcs: []string{"app/main.go", "docs/*.md"}, strictErr: true},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s strict %v", tt.name, strict), func(t *testing.T) {
+				fileContext := FileContext{Root: root, ExcludedFiles: []string{"docs/*.md"}, StrictSources: strict}
+				sd := instructions.SourcesAndDest{SourcePaths: tt.srcs, DestPath: "/dest/"}
+				_, _, err := ResolveEnvAndWildcards(sd, fileContext, nil)
+				wantErr := tt.lenientErr
+				if strict {
+					wantErr = tt.strictErr
+				}
+				testutil.CheckError(t, wantErr, err)
+				if strict && err != nil {
+					if want := tt.srcs[len(tt.srcs)-1]; !strings.Contains(err.Error(), want) {
+						t.Errorf("expected the error to name %s, got %v", want, err)
+					}
+				}
+			})
+		}
+	}
+}
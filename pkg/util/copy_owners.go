@@ -0,0 +1,80 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// OwnerRule gives the copied files below the destinations matching Pattern the
+// owner Chown instead of the one of the command, see --copy-owner.
+type OwnerRule struct {
+	Pattern string
+	Chown   string
+	// UID and GID are Chown resolved for the command the files are copied by.
+	UID, GID int64
+}
+
+// ParseOwnerRules parses --copy-owner values of the form pattern=user[:group].
+func ParseOwnerRules(specs []string) ([]OwnerRule, error) {
+	var rules []OwnerRule
+	for _, spec := range specs {
+		pattern, chown, ok := strings.Cut(spec, "=")
+		if !ok || pattern == "" || chown == "" {
+			return nil, fmt.Errorf("--copy-owner must be of the form pattern=user[:group], got %q", spec)
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("--copy-owner pattern %q must be an absolute path", pattern)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "--copy-owner pattern %q", pattern)
+		}
+		rules = append(rules, OwnerRule{Pattern: filepath.Clean(pattern), Chown: chown, UID: DoNotChangeUID, GID: DoNotChangeGID})
+	}
+	return rules, nil
+}
+
+// OwnerFor returns the owner of the last rule of c whose pattern matches the
+// path of dest in the image or one of its parent directories, or uid and gid if
+// none does.
+func (c FileContext) OwnerFor(dest string, uid, gid int64) (int64, int64) {
+	if len(c.OwnerRules) == 0 {
+		return uid, gid
+	}
+	rel, err := filepath.Rel(config.RootDir, dest)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return uid, gid
+	}
+	path := filepath.Join("/", rel)
+	for i := len(c.OwnerRules) - 1; i >= 0; i-- {
+		rule := c.OwnerRules[i]
+		for p := path; ; p = filepath.Dir(p) {
+			if ok, _ := filepath.Match(rule.Pattern, p); ok {
+				return rule.UID, rule.GID
+			}
+			if p == "/" {
+				break
+			}
+		}
+	}
+	return uid, gid
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_ParseOwnerRules(t *testing.T) {
+	rules, err := ParseOwnerRules([]string{"/app/data/=www:www", "/app/*/cache=1000"})
+	testutil.CheckErrorAndDeepEqual(t, false, err, []OwnerRule{
+		{Pattern: "/app/data", Chown: "www:www", UID: DoNotChangeUID, GID: DoNotChangeGID},
+		{Pattern: "/app/*/cache", Chown: "1000", UID: DoNotChangeUID, GID: DoNotChangeGID},
+	}, rules)
+
+	for spec, msg := range map[string]string{
+		"/app":         "must be of the form pattern=user[:group]",
+		"=www":         "must be of the form pattern=user[:group]",
+		"app/data=www": "must be an absolute path",
+		"/app/[a=www":  "syntax error in pattern",
+	} {
+		_, err := ParseOwnerRules([]string{spec})
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q to fail with %q, got %v", spec, msg, err)
+		}
+	}
+}
+
+func Test_FileContext_ownerFor(t *testing.T) {
+	root := t.TempDir()
+	original := config.RootDir
+	config.RootDir = root
+	defer func() { config.RootDir = original }()
+
+	context := FileContext{OwnerRules: []OwnerRule{
+		{Pattern: "/app/*", UID: 1, GID: 1},
+		{Pattern: "/app/data", UID: 2, GID: 3},
+	}}
+	for path, expected := range map[string][2]int64{
+		"/app":                {-1, -1},
+		"/app/main.txt":       {1, 1},
+		"/app/data":           {2, 3},
+		"/app/data/sub/a.txt": {2, 3},
+		"/other/data":         {-1, -1},
+	} {
+		uid, gid := context.OwnerFor(filepath.Join(root, path), -1, -1)
+		testutil.CheckDeepEqual(t, expected, [2]int64{uid, gid})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// availableBytes returns the bytes available to unprivileged users on the filesystem of path.
+var availableBytes = func(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// CheckDiskSpace returns an error if the filesystem dest is on has less than
+// required bytes available. dest doesn't have to exist yet, its closest existing
+// parent is checked instead.
+func CheckDiskSpace(dest string, required uint64) error {
+	if required == 0 {
+		return nil
+	}
+	available, err := availableDiskSpace(dest)
+	if err != nil {
+		return err
+	}
+	if required > available {
+		return insufficientDiskSpace(dest, required, available)
+	}
+	return nil
+}
+
+func availableDiskSpace(dest string) (uint64, error) {
+	dir := filepath.Clean(dest)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	available, err := availableBytes(dir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "checking disk space of %s", dir)
+	}
+	return available, nil
+}
+
+func insufficientDiskSpace(dest string, required, available uint64) error {
+	return errors.Errorf("insufficient disk space on %s: %d bytes required, %d bytes available", dest, required, available)
+}
+
+// sourceSize returns the total size of the regular files among files, relative
+// to src, that a copy of src would copy.
+func sourceSize(src string, files []string, context FileContext) uint64 {
+	var size uint64
+	for _, file := range files {
+		fullPath := filepath.Join(src, file)
+		if context.ExcludesFile(fullPath) {
+			continue
+		}
+		fi, err := os.Lstat(fullPath)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		size += uint64(fi.Size())
+	}
+	return size
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	mockv1 "github.com/osscontainertools/kaniko/pkg/mocks/go-containerregistry/mockv1"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+// fakeAvailableBytes makes the filesystem appear to have available bytes left.
+func fakeAvailableBytes(t *testing.T, available uint64) {
+	t.Helper()
+	original := availableBytes
+	t.Cleanup(func() { availableBytes = original })
+	availableBytes = func(string) (uint64, error) {
+		return available, nil
+	}
+}
+
+func Test_CopyDir_checks_disk_space(t *testing.T) {
+	src := t.TempDir()
+	if err := testutil.SetupFiles(src, map[string]string{
+		"a":   strings.Repeat("a", 60),
+		"b/c": strings.Repeat("c", 40),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		check     bool
+		available uint64
+		wantErr   bool
+	}{
+		{name: "fits", check: true, available: 100},
+		{name: "doesn't fit", check: true, available: 99, wantErr: true},
+		{name: "not checked", available: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeAvailableBytes(t, tc.available)
+			dest := filepath.Join(t.TempDir(), "dest")
+			_, err := CopyDir(src, dest, FileContext{CheckDiskSpace: tc.check}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+			testutil.CheckError(t, tc.wantErr, err)
+			if !tc.wantErr {
+				return
+			}
+			if !strings.Contains(err.Error(), "insufficient disk space") || !strings.Contains(err.Error(), "100 bytes required, 99 bytes available") {
+				t.Errorf("unexpected error %v", err)
+			}
+			// nothing is copied before the check
+			if _, err := os.Lstat(dest); !os.IsNotExist(err) {
+				t.Errorf("expected %s to not exist, got %v", dest, err)
+			}
+		})
+	}
+}
+
+func Test_GetFSFromLayers_checks_disk_space(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, f := range []string{"first", "second"} {
+		content := strings.Repeat("x", 50)
+		if err := tw.WriteHeader(&tar.Header{Name: f, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeAvailableBytes(t, 80)
+	ctrl := gomock.NewController(t)
+	layer := mockv1.NewMockLayer(ctrl)
+	layer.EXPECT().MediaType().Return(types.OCILayer, nil)
+	layer.EXPECT().Uncompressed().Return(io.NopCloser(buf), nil)
+
+	root := t.TempDir()
+	_, err := GetFSFromLayers(root, []v1.Layer{layer}, ExtractFunc(writeExtract), CheckExtractDiskSpace())
+	if err == nil || !strings.Contains(err.Error(), "insufficient disk space on "+filepath.Join(root, "second")) {
+		t.Fatalf("expected insufficient disk space error for second, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "first")); err != nil {
+		t.Errorf("expected first to be extracted: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "second")); !os.IsNotExist(err) {
+		t.Errorf("expected second to not exist, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os/exec"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ErrorClass is the kind of failure an error is, kaniko can exit with a
+// distinct code for each of them, see --exit-code.
+type ErrorClass string
+
+const (
+	// ErrorClassInternal are failures that are not classified
+	ErrorClassInternal ErrorClass = "internal"
+	// ErrorClassUser are failures caused by the build, like an invalid
+	// Dockerfile, a missing COPY source or an image that doesn't exist
+	ErrorClassUser ErrorClass = "user"
+	// ErrorClassInfra are failures of the infrastructure the build depends
+	// on, like a timeout or an unavailable registry
+	ErrorClassInfra ErrorClass = "infra"
+	// ErrorClassCommand are RUN commands that exit with a non-zero code
+	ErrorClassCommand ErrorClass = "command"
+)
+
+// ErrorClasses are the classes errors are classified as.
+var ErrorClasses = []ErrorClass{ErrorClassInternal, ErrorClassUser, ErrorClassInfra, ErrorClassCommand}
+
+type classifiedError struct {
+	class ErrorClass
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+func (e *classifiedError) Unwrap() error { return e.err }
+func (e *classifiedError) Cause() error  { return e.err }
+
+// UserError marks err as caused by the build, it returns nil if err is nil.
+func UserError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrorClassUser, err: err}
+}
+
+// InfraError marks err as a failure of the infrastructure, it returns nil if err is nil.
+func InfraError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrorClassInfra, err: err}
+}
+
+// ClassifyError returns the class of err. Failing RUN commands and failures of
+// the network are recognized anywhere in the chain of err, even if they are
+// wrapped in an error marked with UserError, like a COPY of a URL that times out.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassInternal
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ErrorClassCommand
+	}
+	if isInfraError(err) {
+		return ErrorClassInfra
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return ErrorClassUser
+	}
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+	return ErrorClassInternal
+}
+
+func isInfraError(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode >= http.StatusInternalServerError ||
+			terr.StatusCode == http.StatusTooManyRequests ||
+			terr.StatusCode == http.StatusRequestTimeout
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
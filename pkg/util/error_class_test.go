@@ -0,0 +1,56 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/osscontainertools/kaniko/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	runErr := exec.Command("sh", "-c", "exit 3").Run()
+	for _, tc := range []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "unclassified", err: errors.New("boom"), want: ErrorClassInternal},
+		{name: "user", err: errors.Wrap(UserError(errors.New("bad Dockerfile")), "parsing"), want: ErrorClassUser},
+		{name: "infra", err: InfraError(errors.New("disk unavailable")), want: ErrorClassInfra},
+		{name: "failed RUN", err: errors.Wrap(runErr, "running command"), want: ErrorClassCommand},
+		{name: "timeout", err: errors.Wrap(context.DeadlineExceeded, "pulling"), want: ErrorClassInfra},
+		{name: "timeout of a user error", err: UserError(errors.Wrap(context.DeadlineExceeded, "downloading context")), want: ErrorClassInfra},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: ErrorClassInfra},
+		{name: "registry unavailable", err: &transport.Error{StatusCode: http.StatusServiceUnavailable}, want: ErrorClassInfra},
+		{name: "rate limited", err: &transport.Error{StatusCode: http.StatusTooManyRequests}, want: ErrorClassInfra},
+		{name: "image not found", err: errors.Wrap(&transport.Error{StatusCode: http.StatusNotFound}, "retrieving image"), want: ErrorClassUser},
+		{name: "unauthorized", err: &transport.Error{StatusCode: http.StatusUnauthorized}, want: ErrorClassUser},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, tc.want, ClassifyError(tc.err))
+		})
+	}
+	testutil.CheckDeepEqual(t, nil, UserError(nil))
+}
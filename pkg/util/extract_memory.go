@@ -0,0 +1,142 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// extractBufferSize is the size of the buffer file contents are copied with while extracting layers
+	extractBufferSize = 32 * 1024
+	// extractMemory is the memory an extraction of a gzip or uncompressed layer takes,
+	// the copy buffer plus the state of tar and gzip readers.
+	extractMemory = 256 * 1024
+)
+
+var (
+	extractBuffers = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, extractBufferSize)
+			return &b
+		},
+	}
+
+	extractLimitMu   sync.Mutex
+	extractLimit     int64
+	extractSemaphore *semaphore.Weighted
+)
+
+// SetExtractMemoryLimit caps the memory used to decompress and copy layers while they are
+// extracted. Extractions that would exceed it wait for other extractions to finish, zstd
+// layers are decompressed within the limit. 0 means no limit.
+func SetExtractMemoryLimit(limit int64) {
+	extractLimitMu.Lock()
+	defer extractLimitMu.Unlock()
+	extractLimit = limit
+	extractSemaphore = nil
+	if limit > 0 {
+		extractSemaphore = semaphore.NewWeighted(limit)
+	}
+}
+
+// uncompressedLayer returns the uncompressed content of l. With a memory limit the memory
+// to decompress l is reserved until the returned reader is closed.
+func uncompressedLayer(l v1.Layer) (io.ReadCloser, error) {
+	extractLimitMu.Lock()
+	limit, sem := extractLimit, extractSemaphore
+	extractLimitMu.Unlock()
+	if sem == nil {
+		return l.Uncompressed()
+	}
+
+	mt, err := l.MediaType()
+	if err != nil {
+		mt = ""
+	}
+	reserved := min(int64(extractMemory), limit)
+	if mt == types.OCILayerZStd {
+		// a zstd window can be much larger than a gzip one, the decoder gets the whole limit
+		reserved = limit
+	}
+	if err := sem.Acquire(context.Background(), reserved); err != nil {
+		return nil, err
+	}
+	release := func() { sem.Release(reserved) }
+
+	if mt != types.OCILayerZStd {
+		rc, err := l.Uncompressed()
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return &releasingReadCloser{ReadCloser: rc, release: release}, nil
+	}
+
+	rc, err := l.Compressed()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	zr, err := zstd.NewReader(rc,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderMaxMemory(uint64(limit)),
+		zstd.WithDecoderMaxWindow(uint64(max(limit, zstd.MinWindowSize))),
+	)
+	if err != nil {
+		rc.Close()
+		release()
+		return nil, err
+	}
+	return &releasingReadCloser{
+		ReadCloser: zr.IOReadCloser(),
+		release: func() {
+			rc.Close()
+			release()
+		},
+	}, nil
+}
+
+// releasingReadCloser releases the memory reserved for an extraction once it is closed.
+type releasingReadCloser struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// copyFileContent copies the content of a file of a layer with a pooled buffer,
+// which bounds the memory of the copy.
+func copyFileContent(dst io.Writer, src io.Reader) (int64, error) {
+	b := extractBuffers.Get().(*[]byte)
+	defer extractBuffers.Put(b)
+	// hide ReaderFrom and WriterTo, they would bypass the buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *b)
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/osscontainertools/kaniko/pkg/mocks/go-containerregistry/mockv1"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+// streamingLayer returns a layer with a single file of size bytes that is generated while it is read.
+func streamingLayer(t *testing.T, ctrl *gomock.Controller, name string, size int64) v1.Layer {
+	t.Helper()
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     size,
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+			ModTime:  time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		chunk := make([]byte, 1<<20)
+		for written := int64(0); written < size; {
+			n := min(int64(len(chunk)), size-written)
+			if _, err := tw.Write(chunk[:n]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			written += n
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	layer := mockv1.NewMockLayer(ctrl)
+	layer.EXPECT().MediaType().Return(types.DockerLayer, nil).AnyTimes()
+	layer.EXPECT().Uncompressed().Return(pr, nil)
+	return layer
+}
+
+func Test_GetFSFromLayers_memory_is_bounded(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	const size = 64 << 20
+	root := t.TempDir()
+	ctrl := gomock.NewController(t)
+	layers := []v1.Layer{streamingLayer(t, ctrl, "large", size)}
+
+	// a whiteout in a second layer still removes the large file
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: ".wh.large", Typeflag: tar.TypeReg, Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	whiteout := mockv1.NewMockLayer(ctrl)
+	whiteout.EXPECT().MediaType().Return(types.DockerLayer, nil).AnyTimes()
+	whiteout.EXPECT().Uncompressed().Return(io.NopCloser(buf), nil)
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+	peak := baseline
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	files, err := GetFSFromLayers(root, layers, ExtractFunc(ExtractFile))
+	close(done)
+	<-sampled
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string{filepath.Join(root, "large")}, files)
+	fi, err := os.Stat(filepath.Join(root, "large"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, int64(size), fi.Size())
+	if peak-baseline > 16<<20 {
+		t.Errorf("expected extracting %d bytes to take less than 16MiB of heap, took %d bytes", size, peak-baseline)
+	}
+
+	files, err = GetFSFromLayers(root, []v1.Layer{whiteout}, ExtractFunc(ExtractFile), IncludeWhiteout())
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string{filepath.Join(root, ".wh.large")}, files)
+	if _, err := os.Lstat(filepath.Join(root, "large")); !os.IsNotExist(err) {
+		t.Errorf("expected large to be whited out, got %v", err)
+	}
+}
+
+func Test_SetExtractMemoryLimit(t *testing.T) {
+	SetExtractMemoryLimit(extractMemory)
+	defer SetExtractMemoryLimit(0)
+	ctrl := gomock.NewController(t)
+
+	layer := func() v1.Layer {
+		l := mockv1.NewMockLayer(ctrl)
+		l.EXPECT().MediaType().Return(types.DockerLayer, nil)
+		l.EXPECT().Uncompressed().Return(io.NopCloser(new(bytes.Buffer)), nil)
+		return l
+	}
+	first, err := uncompressedLayer(layer())
+	testutil.CheckNoError(t, err)
+
+	// the second extraction waits until the first one releases its memory
+	opened := make(chan io.ReadCloser)
+	go func() {
+		second, err := uncompressedLayer(layer())
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- second
+	}()
+	select {
+	case <-opened:
+		t.Fatal("expected the second extraction to wait for the first one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	testutil.CheckNoError(t, first.Close())
+	select {
+	case second := <-opened:
+		testutil.CheckNoError(t, second.Close())
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second extraction to start after the first one")
+	}
+}
+
+func Test_uncompressedLayer_zstd(t *testing.T) {
+	SetExtractMemoryLimit(8 << 20)
+	defer SetExtractMemoryLimit(0)
+
+	content := bytes.Repeat([]byte("kaniko"), 1<<16)
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}, tarball.WithCompression("zstd"), tarball.WithMediaType(types.OCILayerZStd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := uncompressedLayer(layer)
+	testutil.CheckNoError(t, err)
+	b, err := io.ReadAll(rc)
+	testutil.CheckNoError(t, err)
+	testutil.CheckNoError(t, rc.Close())
+	testutil.CheckDeepEqual(t, content, b)
+}
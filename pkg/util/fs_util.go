@@ -27,7 +27,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -36,6 +38,7 @@ import (
 	"github.com/moby/patternmatcher"
 	"github.com/moby/patternmatcher/ignorefile"
 	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/timing"
 	otiai10Cpy "github.com/otiai10/copy"
 	"github.com/pkg/errors"
@@ -45,7 +48,8 @@ import (
 
 // for testing
 var (
-	FSys fs.FS = NoAtimeFS{}
+	FSys          fs.FS = NoAtimeFS{}
+	relativeFiles       = RelativeFiles
 )
 
 const (
@@ -58,6 +62,9 @@ const (
 	defaultTimeout  = "90m"
 )
 
+// snapshotRetryDelay is the delay before the first retry of a file that vanished during the snapshot
+var snapshotRetryDelay = 100 * time.Millisecond
+
 type IgnoreListEntry struct {
 	Path            string
 	PrefixMatchOnly bool
@@ -84,11 +91,110 @@ var defaultIgnoreList = []IgnoreListEntry{
 
 var ignorelist = append([]IgnoreListEntry{}, defaultIgnoreList...)
 
+// ignorelistMu guards ignorelist and volumes, images may be extracted concurrently.
+var ignorelistMu sync.RWMutex
+
 var volumes = []string{}
 
 type FileContext struct {
 	Root          string
 	ExcludedFiles []string
+	// DefaultDirMode and DefaultFileMode are applied to copied directories
+	// and files when no chmod is given. If unset the source mode is kept.
+	DefaultDirMode  fs.FileMode
+	DefaultFileMode fs.FileMode
+	// MaxMode are the permission bits a copy may grant with chmod,
+	// if unset any bits are allowed. See --max-copy-mode.
+	MaxMode fs.FileMode
+	// NamedContexts maps the names of additional build contexts
+	// that are directories to their root.
+	NamedContexts map[string]string
+	// RootedSymlinks resolves symlinks in the parent directories of sources
+	// inside Root, as Root is the filesystem of another stage.
+	RootedSymlinks bool
+	// SkipUnchanged leaves files and directories that copying wouldn't change out
+	// of the copied files, see --skip-unchanged-copies.
+	SkipUnchanged bool
+	// BestEffort skips sources that vanished or can't be read instead of failing
+	// the copy, they are noted in Skipped if it is set. See --copy-best-effort.
+	BestEffort bool
+	Skipped    *SkippedSources
+	// PreserveOwnership keeps the uid and gid of sources copied without chown
+	// instead of using the active user, see --preserve-source-ownership.
+	PreserveOwnership bool
+	// CheckDiskSpace fails a copy of a directory early if it doesn't fit on the
+	// destination filesystem, see --check-disk-space.
+	CheckDiskSpace bool
+	// CopyAsRoot makes sources copied from the context without chown owned
+	// by root instead of the active user, see --copy-as-root.
+	CopyAsRoot bool
+	// RunUmask is the umask RUN commands are started with, if nil they
+	// inherit the umask of kaniko. See --run-umask.
+	RunUmask *int
+	// HermeticRun runs RUN commands without network access, see --hermetic-run.
+	HermeticRun bool
+	// ArchiveCache keeps the decompressed tars of the archives ADD unpacks, if
+	// it is nil they are not cached. See --cache-decompressed-archives.
+	ArchiveCache *ArchiveCache
+	// Sources supplies the sources of COPY and ADD, they are read from
+	// the filesystem if it is nil or doesn't know a source.
+	Sources SourceProvider
+	// CaseCollisions is what a copy does about copied paths that differ from
+	// another path only by case, see --case-collisions.
+	CaseCollisions config.CaseCollisionPolicy
+	// DuplicateDestinations is what a copy does about sources it copies to the
+	// same destination file, see --duplicate-destinations.
+	DuplicateDestinations config.DuplicateDestinationPolicy
+	// FollowDirSymlinks copies the content of the directories symlinks in a
+	// copied directory point to instead of the symlinks, see --follow-context-symlinks.
+	FollowDirSymlinks bool
+	// PreserveInodeFlags keeps the immutable and append-only inode flags of
+	// copied files, see --preserve-inode-flags.
+	PreserveInodeFlags bool
+	// StrictSources fails a copy if any of its sources, wildcards included,
+	// matches no file that isn't excluded, see --strict-context.
+	StrictSources bool
+	// PreserveAtime copies the access times of source files to the copied files
+	// instead of the time they were copied, see --preserve-access-times.
+	PreserveAtime bool
+	// MaxSymlinkDepth is how many symlinks resolving the destination of a copy
+	// follows, if unset it is constants.DefaultMaxSymlinkDepth. See --max-symlink-depth.
+	MaxSymlinkDepth int
+	// Fsync flushes the copied files to stable storage before their layer is
+	// snapshotted, see --fsync-copies.
+	Fsync bool
+	// Transforms run on the copied files they match and add the files they write
+	// to the layer of the copy, see --copy-transform.
+	Transforms []CopyTransform
+	// OwnerRules own the copied files below the destinations they match instead
+	// of the owner of the command, see --copy-owner.
+	OwnerRules []OwnerRule
+	// CommitTimes are the mtimes copied files get instead of the mtime of their
+	// source, keyed by the path of the source. See --git-commit-mtimes.
+	CommitTimes map[string]time.Time
+}
+
+// SkippedSources collects the sources a best effort copy skipped.
+type SkippedSources struct {
+	paths []string
+}
+
+// Paths returns the skipped sources in the order they were skipped.
+func (s *SkippedSources) Paths() []string {
+	return s.paths
+}
+
+// SkipSource returns true if src is skipped because err means it vanished or can't be read
+// and the copy is best effort.
+func (c FileContext) SkipSource(src string, err error) bool {
+	if !c.BestEffort || !(errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)) {
+		return false
+	}
+	logrus.Warnf("Skipping %s: %v", src, err)
+	if c.Skipped != nil {
+		c.Skipped.paths = append(c.Skipped.paths, src)
+	}
+	return true
 }
 
 type ExtractFunction func(string, *tar.Header, string, io.Reader) error
@@ -96,15 +202,27 @@ type ExtractFunction func(string, *tar.Header, string, io.Reader) error
 type FSConfig struct {
 	includeWhiteout bool
 	extractFunc     ExtractFunction
+	// includePaths limits the extraction to these paths, see IncludePaths
+	includePaths []string
+	// excludePaths are left out of the extraction, see ExcludePaths
+	excludePaths []string
+	// checkDiskSpace fails the extraction before a file that doesn't fit, see CheckExtractDiskSpace
+	checkDiskSpace bool
+	// keepIgnoreList leaves the ignore list and the volumes as they are, see KeepIgnoreList
+	keepIgnoreList bool
 }
 
 type FSOpt func(*FSConfig)
 
 func IgnoreList() []IgnoreListEntry {
+	ignorelistMu.RLock()
+	defer ignorelistMu.RUnlock()
 	return ignorelist
 }
 
 func AddToIgnoreList(entry IgnoreListEntry) {
+	ignorelistMu.Lock()
+	defer ignorelistMu.Unlock()
 	ignorelist = append(ignorelist, IgnoreListEntry{
 		Path:            filepath.Clean(entry.Path),
 		PrefixMatchOnly: entry.PrefixMatchOnly,
@@ -130,9 +248,87 @@ func ExtractFunc(extractFunc ExtractFunction) FSOpt {
 	}
 }
 
+// IncludePaths only extracts the given paths of the layers, what is below them and
+// their parent directories. Whiteouts are applied only within these paths as well.
+// The paths are absolute paths in the layer, independent of the extraction root.
+func IncludePaths(paths ...string) FSOpt {
+	return func(opts *FSConfig) {
+		for _, p := range paths {
+			opts.includePaths = append(opts.includePaths, filepath.Clean(filepath.Join("/", p)))
+		}
+	}
+}
+
+// ExcludePaths leaves the given paths of the layers and what is below them out of the
+// extraction, whiteouts of these paths are skipped as there is nothing to remove.
+// The paths are absolute paths in the layer, independent of the extraction root.
+func ExcludePaths(paths ...string) FSOpt {
+	return func(opts *FSConfig) {
+		for _, p := range paths {
+			opts.excludePaths = append(opts.excludePaths, filepath.Clean(filepath.Join("/", p)))
+		}
+	}
+}
+
+// CheckExtractDiskSpace fails the extraction with a clear error before a file is
+// written that doesn't fit on the filesystem of root anymore.
+func CheckExtractDiskSpace() FSOpt {
+	return func(c *FSConfig) {
+		c.checkDiskSpace = true
+	}
+}
+
+// KeepIgnoreList extracts the layers with the ignore list and the volumes as they are instead
+// of resetting them first, for extractions that run concurrently after ResetIgnoreList.
+func KeepIgnoreList() FSOpt {
+	return func(c *FSConfig) {
+		c.keepIgnoreList = true
+	}
+}
+
+// excluded returns true if the layer path p is one of the excluded paths or below one.
+func (c *FSConfig) excluded(p string) bool {
+	for _, exclude := range c.excludePaths {
+		if p == exclude || exclude == "/" || strings.HasPrefix(p, exclude+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// included returns true if the layer path p is extracted.
+func (c *FSConfig) included(p string) bool {
+	if c.excluded(p) {
+		return false
+	}
+	if c.within(p) {
+		return true
+	}
+	for _, include := range c.includePaths {
+		// the parent directories of the included paths
+		if p == "/" || strings.HasPrefix(include, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// within returns true if the layer path p is one of the included paths or below one.
+func (c *FSConfig) within(p string) bool {
+	if len(c.includePaths) == 0 {
+		return true
+	}
+	for _, include := range c.includePaths {
+		if p == include || include == "/" || strings.HasPrefix(p, include+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // GetFSFromImage extracts the layers of img to root
 // It returns a list of all files extracted
-func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]string, error) {
+func GetFSFromImage(root string, img v1.Image, extract ExtractFunction, opts ...FSOpt) ([]string, error) {
 	if img == nil {
 		return nil, errors.New("image cannot be nil")
 	}
@@ -142,21 +338,22 @@ func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]strin
 		return nil, err
 	}
 
-	return GetFSFromLayers(root, layers, ExtractFunc(extract))
+	return GetFSFromLayers(root, layers, append([]FSOpt{ExtractFunc(extract)}, opts...)...)
 }
 
 func GetFSFromLayers(root string, layers []v1.Layer, opts ...FSOpt) ([]string, error) {
-	volumes = []string{}
 	cfg := new(FSConfig)
-	if err := InitIgnoreList(); err != nil {
-		return nil, errors.Wrap(err, "initializing filesystem ignore list")
-	}
-	logrus.Debugf("Ignore list: %v", ignorelist)
-
 	for _, opt := range opts {
 		opt(cfg)
 	}
 
+	if !cfg.keepIgnoreList {
+		if err := ResetIgnoreList(); err != nil {
+			return nil, err
+		}
+	}
+	logrus.Debugf("Ignore list: %v", IgnoreList())
+
 	if cfg.extractFunc == nil {
 		return nil, errors.New("must supply an extract function")
 	}
@@ -169,64 +366,235 @@ func GetFSFromLayers(root string, layers []v1.Layer, opts ...FSOpt) ([]string, e
 			logrus.Tracef("Extracting layer %d", i)
 		}
 
-		r, err := l.Uncompressed()
+		files, err := extractLayer(root, i, l, cfg)
 		if err != nil {
 			return nil, err
 		}
-		defer r.Close()
+		extractedFiles = append(extractedFiles, files...)
+	}
+	return extractedFiles, nil
+}
 
-		tr := tar.NewReader(r)
-		for {
-			hdr, err := tr.Next()
-			if errors.Is(err, io.EOF) {
-				break
-			}
+// extractLayer extracts layer i to root, the layer is streamed and closed once it is extracted.
+func extractLayer(root string, i int, l v1.Layer, cfg *FSConfig) ([]string, error) {
+	r, err := uncompressedLayer(l)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
 
-			if err != nil {
-				return nil, errors.Wrap(err, fmt.Sprintf("error reading tar %d", i))
-			}
+	var available uint64
+	if cfg.checkDiskSpace {
+		if available, err = availableDiskSpace(root); err != nil {
+			return nil, err
+		}
+	}
 
-			cleanedName := filepath.Clean(hdr.Name)
-			path := filepath.Join(root, cleanedName)
-			base := filepath.Base(path)
-			dir := filepath.Dir(path)
+	extractedFiles := []string{}
+	layerFiles := map[string]struct{}{}
+	tr := tar.NewReader(r)
+	for {
+		if err := CheckInterrupted(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 
-			if strings.HasPrefix(base, archive.WhiteoutPrefix) {
-				logrus.Tracef("Whiting out %s", path)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error reading tar %d", i))
+		}
 
-				name := strings.TrimPrefix(base, archive.WhiteoutPrefix)
-				path := filepath.Join(dir, name)
+		cleanedName := filepath.Clean(hdr.Name)
+		path := filepath.Join(root, cleanedName)
+		base := filepath.Base(path)
+		dir := filepath.Dir(path)
+
+		// the whiteouts are filtered by the path they white out
+		layerPath := filepath.Join("/", cleanedName)
+		if base == archive.WhiteoutOpaqueDir {
+			layerPath = filepath.Dir(layerPath)
+		} else if strings.HasPrefix(base, archive.WhiteoutPrefix) {
+			layerPath = filepath.Join(filepath.Dir(layerPath), strings.TrimPrefix(base, archive.WhiteoutPrefix))
+		}
+		if !cfg.included(layerPath) {
+			logrus.Tracef("Not extracting %s, as it's not included", hdr.Name)
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink && cfg.excluded(filepath.Join("/", filepath.Clean(hdr.Linkname))) {
+			logrus.Warnf("Not extracting %s, it is a hard link to the excluded %s", hdr.Name, hdr.Linkname)
+			continue
+		}
 
-				if CheckCleanedPathAgainstIgnoreList(path) {
-					logrus.Tracef("Not deleting %s, as it's ignored", path)
-					continue
-				}
-				if childDirInIgnoreList(path) {
-					logrus.Tracef("Not deleting %s, as it contains a ignored path", path)
-					continue
-				}
+		if base == archive.WhiteoutOpaqueDir {
+			logrus.Tracef("Whiting out contents of %s", dir)
+			if err := removeOpaqueDir(root, dir, layerFiles, cfg); err != nil {
+				return nil, errors.Wrapf(err, "removing opaque whiteout %s", hdr.Name)
+			}
+			if !cfg.includeWhiteout {
+				logrus.Trace("Not including whiteout files")
+				continue
+			}
+		} else if strings.HasPrefix(base, archive.WhiteoutPrefix) {
+			logrus.Tracef("Whiting out %s", path)
 
-				if err := os.RemoveAll(path); err != nil {
-					return nil, errors.Wrapf(err, "removing whiteout %s", hdr.Name)
-				}
+			name := strings.TrimPrefix(base, archive.WhiteoutPrefix)
+			path := filepath.Join(dir, name)
 
-				if !cfg.includeWhiteout {
-					logrus.Trace("Not including whiteout files")
-					continue
+			if CheckCleanedPathAgainstIgnoreList(path) {
+				logrus.Tracef("Not deleting %s, as it's ignored", path)
+				continue
+			}
+			if childDirInIgnoreList(path) {
+				logrus.Tracef("Not deleting %s, as it contains a ignored path", path)
+				continue
+			}
+
+			for _, p := range cfg.whiteoutPaths(root, path, layerPath) {
+				if err := os.RemoveAll(p); err != nil {
+					return nil, errors.Wrapf(err, "removing whiteout %s", hdr.Name)
 				}
+			}
 
+			if !cfg.includeWhiteout {
+				logrus.Trace("Not including whiteout files")
+				continue
 			}
 
-			if err := cfg.extractFunc(root, hdr, cleanedName, tr); err != nil {
-				return nil, err
+		}
+
+		if cfg.checkDiskSpace && hdr.Typeflag == tar.TypeReg {
+			size := uint64(hdr.Size)
+			if size > available {
+				return nil, insufficientDiskSpace(path, size, available)
 			}
+			available -= size
+		}
 
-			extractedFiles = append(extractedFiles, filepath.Join(root, cleanedName))
+		if err := cfg.extractFunc(root, hdr, cleanedName, tr); err != nil {
+			return nil, err
 		}
+
+		extractedFiles = append(extractedFiles, filepath.Join(root, cleanedName))
+		layerFiles[filepath.Join(root, cleanedName)] = struct{}{}
 	}
 	return extractedFiles, nil
 }
 
+// whiteoutPaths returns the paths to remove for the whiteout of path. If only paths
+// below it are included, only these are removed.
+func (c *FSConfig) whiteoutPaths(root, path, layerPath string) []string {
+	if c.within(layerPath) {
+		return []string{path}
+	}
+	var paths []string
+	for _, include := range c.includePaths {
+		if layerPath == "/" || strings.HasPrefix(include, layerPath+"/") {
+			paths = append(paths, filepath.Join(root, include))
+		}
+	}
+	return paths
+}
+
+// removeOpaqueDir removes the contents of dir for an opaque whiteout, files extracted
+// from the same layer are kept as the whiteout only hides the previous layers.
+// Contents outside of the included paths are kept as well.
+func removeOpaqueDir(root, dir string, layerFiles map[string]struct{}, cfg *FSConfig) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if _, ok := layerFiles[path]; ok {
+			continue
+		}
+		if rel, err := filepath.Rel(root, path); err == nil && !cfg.within(filepath.Join("/", rel)) {
+			continue
+		}
+		if CheckCleanedPathAgainstIgnoreList(path) {
+			logrus.Tracef("Not deleting %s, as it's ignored", path)
+			continue
+		}
+		if childDirInIgnoreList(path) {
+			logrus.Tracef("Not deleting %s, as it contains a ignored path", path)
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsync flushes f to stable storage, replaced in tests
+var fsync = func(f *os.File) error {
+	return f.Sync()
+}
+
+// SyncPath flushes path to stable storage. For a directory all files and directories
+// below it are flushed. The directory containing path is flushed as well, so that the
+// entry of path itself is durable.
+func SyncPath(path string) error {
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		return syncFile(p)
+	})
+	if err != nil {
+		return err
+	}
+	return syncFile(filepath.Dir(path))
+}
+
+// SyncFiles flushes the files and directories at paths to stable storage, and the
+// directories containing them so that their entries are durable. Symlinks and
+// paths that don't exist, like those a layer deleted, are skipped.
+func SyncFiles(paths []string) error {
+	dirs := map[string]struct{}{}
+	for _, p := range paths {
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dirs[filepath.Dir(p)] = struct{}{}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			continue
+		}
+		if err := syncFile(p); err != nil {
+			return err
+		}
+	}
+	for dir := range dirs {
+		if err := syncFile(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := fsync(f); err != nil {
+		return errors.Wrapf(err, "syncing %s", path)
+	}
+	return nil
+}
+
 // DeleteFilesystem deletes the extracted image file system
 func DeleteFilesystem() error {
 	logrus.Info("Deleting filesystem...")
@@ -268,7 +636,7 @@ func isExist(path string) bool {
 
 // childDirInIgnoreList returns true if there is a child file or directory of the path in the ignorelist
 func childDirInIgnoreList(path string) bool {
-	for _, d := range ignorelist {
+	for _, d := range IgnoreList() {
 		if HasFilepathPrefix(d.Path, path, d.PrefixMatchOnly) {
 			return true
 		}
@@ -342,7 +710,8 @@ func ExtractFile(dest string, hdr *tar.Header, cleanedName string, tr io.Reader)
 			return err
 		}
 
-		if _, err = io.Copy(currFile, tr); err != nil {
+		if _, err = copyFileContent(currFile, tr); err != nil {
+			currFile.Close()
 			return err
 		}
 
@@ -372,14 +741,16 @@ func ExtractFile(dest string, hdr *tar.Header, cleanedName string, tr io.Reader)
 
 	case tar.TypeLink:
 		logrus.Tracef("Link from %s to %s", hdr.Linkname, path)
-		abs, err := filepath.Abs(hdr.Linkname)
-		if err != nil {
-			return err
-		}
-		if CheckCleanedPathAgainstIgnoreList(abs) {
+		// The link target is relative to the root of the layer, not to the working directory
+		link := filepath.Clean(filepath.Join(dest, hdr.Linkname))
+		if CheckCleanedPathAgainstIgnoreList(link) && !checkIgnoreListRoot(dest) {
 			logrus.Tracef("Skipping link from %s to %s because %s is ignored", hdr.Linkname, path, hdr.Linkname)
 			return nil
 		}
+		if link == path {
+			logrus.Tracef("Skipping link from %s to itself", path)
+			return nil
+		}
 		// The base directory for a link may not exist before it is created.
 		if err := os.MkdirAll(dir, 0o755); err != nil {
 			return err
@@ -391,9 +762,8 @@ func ExtractFile(dest string, hdr *tar.Header, cleanedName string, tr io.Reader)
 				return errors.Wrapf(err, "error removing %s to make way for new link", hdr.Name)
 			}
 		}
-		link := filepath.Clean(filepath.Join(dest, hdr.Linkname))
 		if err := os.Link(link, path); err != nil {
-			return err
+			return errors.Wrapf(err, "error creating hardlink %s to %s", hdr.Name, hdr.Linkname)
 		}
 
 	case tar.TypeSymlink:
@@ -428,11 +798,11 @@ func IsInProvidedIgnoreList(path string, wl []IgnoreListEntry) bool {
 }
 
 func IsInIgnoreList(path string) bool {
-	return IsInProvidedIgnoreList(path, ignorelist)
+	return IsInProvidedIgnoreList(path, IgnoreList())
 }
 
 func CheckCleanedPathAgainstProvidedIgnoreList(path string, wl []IgnoreListEntry) bool {
-	for _, wl := range ignorelist {
+	for _, wl := range IgnoreList() {
 		if hasCleanedFilepathPrefix(path, wl.Path, wl.PrefixMatchOnly) {
 			return true
 		}
@@ -446,7 +816,7 @@ func CheckIgnoreList(path string) bool {
 }
 
 func CheckCleanedPathAgainstIgnoreList(path string) bool {
-	return CheckCleanedPathAgainstProvidedIgnoreList(path, ignorelist)
+	return CheckCleanedPathAgainstProvidedIgnoreList(path, IgnoreList())
 }
 
 func checkIgnoreListRoot(root string) bool {
@@ -463,18 +833,30 @@ func checkIgnoreListRoot(root string) bool {
 // Where (5) is the mount point relative to the process's root
 // From: https://www.kernel.org/doc/Documentation/filesystems/proc.txt
 func DetectFilesystemIgnoreList(path string) error {
+	entries, err := detectFilesystemIgnoreList(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		AddToIgnoreList(entry)
+	}
+	return nil
+}
+
+func detectFilesystemIgnoreList(path string) ([]IgnoreListEntry, error) {
 	logrus.Trace("Detecting filesystem ignore list")
 	f, err := FSys.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
+	var entries []IgnoreListEntry
 	reader := bufio.NewReader(f)
 	for {
 		line, err := reader.ReadString('\n')
 		logrus.Tracef("Read the following line from %s: %s", path, line)
 		if err != nil && !errors.Is(err, io.EOF) {
-			return err
+			return nil, err
 		}
 		lineArr := strings.Split(line, " ")
 		if len(lineArr) < 5 {
@@ -486,8 +868,8 @@ func DetectFilesystemIgnoreList(path string) error {
 		}
 		if lineArr[4] != config.RootDir {
 			logrus.Tracef("Adding ignore list entry %s from line: %s", lineArr[4], line)
-			AddToIgnoreList(IgnoreListEntry{
-				Path:            lineArr[4],
+			entries = append(entries, IgnoreListEntry{
+				Path:            filepath.Clean(lineArr[4]),
 				PrefixMatchOnly: false,
 			})
 		}
@@ -496,7 +878,7 @@ func DetectFilesystemIgnoreList(path string) error {
 			break
 		}
 	}
-	return nil
+	return entries, nil
 }
 
 // RelativeFiles returns a list of all files at the filepath relative to root
@@ -523,13 +905,79 @@ func RelativeFiles(fp string, root string) ([]string, error) {
 	return files, err
 }
 
+// RelativeFilesFollowingSymlinks returns all files at root relative to it like
+// RelativeFiles, but walks the directories that symlinks at root point to as if
+// they were there. Symlinks to one of their own parent directories are not
+// followed, as the walk would never end. It also returns the symlinks it followed.
+func RelativeFilesFollowingSymlinks(root string) ([]string, map[string]bool, error) {
+	logrus.Debugf("Getting files and contents at root %s following symlinks", root)
+	files := []string{"."}
+	followed := map[string]bool{}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = walkFollowingSymlinks(root, ".", map[string]bool{realRoot: true}, &files, followed)
+	return files, followed, err
+}
+
+// walkFollowingSymlinks adds the files in the directory rel of root to files,
+// parents are the real paths of the directories the walk is in.
+func walkFollowingSymlinks(root, rel string, parents map[string]bool, files *[]string, followed map[string]bool) error {
+	cleanedRoot := filepath.Clean(root)
+	entries, err := os.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		relPath := filepath.Join(rel, entry.Name())
+		path := filepath.Join(root, relPath)
+		if !CheckCleanedPathAgainstIgnoreList(path) || hasCleanedFilepathPrefix(filepath.Clean(path), cleanedRoot, false) {
+			*files = append(*files, relPath)
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// dangling symlinks and symlinks to files are copied as symlinks
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				continue
+			}
+			if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+				continue
+			}
+			if parents[target] {
+				logrus.Warnf("Not following symlink %s, it points to its parent directory %s", path, target)
+				continue
+			}
+			followed[relPath] = true
+			parents[target] = true
+			err = walkFollowingSymlinks(root, relPath, parents, files, followed)
+			delete(parents, target)
+			if err != nil {
+				return err
+			}
+		} else if entry.IsDir() {
+			realPath, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return err
+			}
+			parents[realPath] = true
+			err = walkFollowingSymlinks(root, relPath, parents, files, followed)
+			delete(parents, realPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // ParentDirectories returns a list of paths to all parent directories
 // Ex. /some/temp/dir -> [/, /some, /some/temp, /some/temp/dir]
 func ParentDirectories(path string) []string {
 	dir := filepath.Clean(path)
 	var paths []string
 	for {
-		if dir == filepath.Clean(config.RootDir) || dir == "" || dir == "." {
+		if dir == filepath.Clean(config.RootDir) || dir == "" || dir == "." || dir == "/" {
 			break
 		}
 		dir, _ = filepath.Split(dir)
@@ -607,12 +1055,78 @@ func CreateFile(path string, reader io.Reader, perm os.FileMode, uid uint32, gid
 		return errors.Wrap(err, "creating file")
 	}
 	defer dest.Close()
-	if _, err := io.Copy(dest, reader); err != nil {
+	// copies from files keep the copy_file_range and sendfile paths of io.Copy,
+	// only streams like tar readers are retried
+	copyFile := copyRetrying
+	if _, ok := reader.(*os.File); ok {
+		copyFile = io.Copy
+	}
+	if _, err := copyFile(dest, reader); err != nil {
 		return errors.Wrap(err, "copying file")
 	}
 	return setFilePermissions(path, perm, int(uid), int(gid))
 }
 
+// transientRetries is how often in a row copyRetrying retries a read or write that
+// failed with a transient error before it gives up.
+const transientRetries = 5
+
+// transientRetryDelay is how long copyRetrying waits before retrying after EAGAIN.
+var transientRetryDelay = 10 * time.Millisecond
+
+// isTransient returns true if err is EINTR or EAGAIN, which some kernels and
+// filesystems return for large reads and writes that succeed when retried.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// copyRetrying copies src to dst like io.Copy, but retries reads and writes that fail
+// with a transient error, keeping the bytes they transferred before failing.
+func copyRetrying(dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var written int64
+	retries := 0
+	retry := func(err error) error {
+		if !isTransient(err) || retries >= transientRetries {
+			return err
+		}
+		retries++
+		if errors.Is(err, syscall.EAGAIN) {
+			time.Sleep(transientRetryDelay)
+		}
+		return nil
+	}
+	for {
+		n, rerr := src.Read(buf)
+		for data := buf[:n]; len(data) > 0; {
+			w, werr := dst.Write(data)
+			written += int64(w)
+			data = data[w:]
+			if w > 0 {
+				retries = 0
+			}
+			if werr != nil {
+				if err := retry(werr); err != nil {
+					return written, err
+				}
+			} else if w == 0 {
+				return written, io.ErrShortWrite
+			}
+		}
+		if n > 0 {
+			retries = 0
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			if err := retry(rerr); err != nil {
+				return written, err
+			}
+		}
+	}
+}
+
 // AddVolumePath adds the given path to the volume ignorelist.
 func AddVolumePathToIgnoreList(path string) {
 	logrus.Infof("Adding volume %s to ignorelist", path)
@@ -620,7 +1134,9 @@ func AddVolumePathToIgnoreList(path string) {
 		Path:            path,
 		PrefixMatchOnly: true,
 	})
+	ignorelistMu.Lock()
 	volumes = append(volumes, path)
+	ignorelistMu.Unlock()
 }
 
 // DownloadFileToDest downloads the file at rawurl to the given dest for the ADD command
@@ -655,50 +1171,113 @@ func DownloadFileToDest(rawurl, dest string, uid, gid int64, chmod fs.FileMode)
 // DetermineTargetFileOwnership returns the user provided uid/gid combination.
 // If they are set to -1, the uid/gid from the original file is used.
 func DetermineTargetFileOwnership(fi os.FileInfo, uid, gid int64) (int64, int64) {
+	// sources supplied by a SourceProvider may not be owned by anyone
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		stat = &syscall.Stat_t{}
+	}
 	if uid <= DoNotChangeUID {
-		uid = int64(fi.Sys().(*syscall.Stat_t).Uid)
+		uid = int64(stat.Uid)
 	}
 	if gid <= DoNotChangeGID {
-		gid = int64(fi.Sys().(*syscall.Stat_t).Gid)
+		gid = int64(stat.Gid)
 	}
 	return uid, gid
 }
 
+// specialModeBits are the setuid, setgid and sticky bits of a file mode
+const specialModeBits = fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
 type timestampUpdate struct {
 	src, dest string
 }
 
 // CopyDir copies the file or directory at src to dest
-// It returns a list of files it copied over
+// It returns a list of files it copied over, sorted by path so that
+// the resulting layer does not depend on the filesystem iteration order
 func CopyDir(src, dest string, context FileContext, uid, gid int64, chmod fs.FileMode, useDefaultChmod bool) ([]string, error) {
-	files, err := RelativeFiles("", src)
+	var files []string
+	var followed map[string]bool
+	var err error
+	if context.FollowDirSymlinks {
+		files, followed, err = RelativeFilesFollowingSymlinks(src)
+	} else {
+		files, err = relativeFiles("", src)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "copying dir")
 	}
+	sort.Strings(files)
+	if context.CheckDiskSpace {
+		if err := CheckDiskSpace(dest, sourceSize(src, files, context)); err != nil {
+			return nil, err
+		}
+	}
+	included := includedParents(src, files, context)
 	var copiedFiles []string
 	var updates []timestampUpdate
 	for _, file := range files {
 		fullPath := filepath.Join(src, file)
-		if context.ExcludesFile(fullPath) {
+		if context.ExcludesFile(fullPath) && !included[file] {
 			logrus.Debugf("%s found in .dockerignore, ignoring", src)
 			continue
 		}
 		fi, err := os.Lstat(fullPath)
 		if err != nil {
+			if context.SkipSource(fullPath, err) {
+				continue
+			}
 			return nil, errors.Wrap(err, "copying dir")
 		}
+		timestampSrc := fullPath
+		if followed[file] {
+			// the symlink is copied as the directory it points to
+			if timestampSrc, err = filepath.EvalSymlinks(fullPath); err != nil {
+				return nil, errors.Wrap(err, "copying dir")
+			}
+			if fi, err = os.Stat(timestampSrc); err != nil {
+				return nil, errors.Wrap(err, "copying dir")
+			}
+		}
 		destPath := filepath.Join(dest, file)
+		uid, gid := context.OwnerFor(destPath, uid, gid)
 		if file == "." {
-			logrus.Tracef("Creating directory %s", destPath)
-
+			mode := fs.FileMode(0755)
+			if useDefaultChmod && context.DefaultDirMode != 0 {
+				mode = context.DefaultDirMode
+			}
 			uid, gid := DetermineTargetFileOwnership(fi, uid, gid)
-			if err := MkdirAllWithPermissions(destPath, 0755, uid, gid); err != nil {
+			// MkdirAll keeps the mode of an existing directory
+			if context.SkipUnchanged && unchangedDir(destPath, 0, uid, gid) {
+				logrus.Debugf("Directory %s is unchanged, not copying it", destPath)
+				continue
+			}
+			logrus.Tracef("Creating directory %s", destPath)
+			// missing parents of dest are owned like dest, as they are for copied files,
+			// and created where the symlinks among them point to, like /lib -> usr/lib
+			resolvedPath, err := resolveParents(destPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := createParentDirectory(resolvedPath, int(uid), int(gid)); err != nil {
+				return nil, err
+			}
+			if err := MkdirAllWithPermissions(destPath, mode, uid, gid); err != nil {
 				return nil, err
 			}
 		} else if fi.IsDir() {
-			logrus.Tracef("Creating directory %s", destPath)
-
 			uid, gid := DetermineTargetFileOwnership(fi, uid, gid)
+			var mode fs.FileMode
+			if !useDefaultChmod {
+				mode = chmod
+			} else if context.DefaultDirMode != 0 {
+				mode = context.DefaultDirMode
+			}
+			if context.SkipUnchanged && unchangedDir(destPath, mode, uid, gid) {
+				logrus.Debugf("Directory %s is unchanged, not copying it", destPath)
+				continue
+			}
+			logrus.Tracef("Creating directory %s", destPath)
 			if err := MkdirAllWithPermissions(destPath, fi.Mode(), uid, gid); err != nil {
 				return nil, err
 			}
@@ -708,12 +1287,25 @@ func CopyDir(src, dest string, context FileContext, uid, gid int64, chmod fs.Fil
 				if err = os.Chmod(destPath, chmod); err != nil {
 					return nil, err
 				}
+			} else if context.DefaultDirMode != 0 {
+				if err = os.Chmod(destPath, context.DefaultDirMode); err != nil {
+					return nil, err
+				}
+			} else if fi.Mode()&specialModeBits != 0 {
+				// mkdir ignores the setuid and setgid bits, set them like on src
+				if err = os.Chmod(destPath, fi.Mode()&(fs.ModePerm|specialModeBits)); err != nil {
+					return nil, err
+				}
 			}
 		} else if IsSymlink(fi) {
 			// If file is a symlink, we want to create the same relative symlink
-			if _, err := CopySymlink(fullPath, destPath, context); err != nil {
+			skipped, err := CopySymlink(fullPath, destPath, context)
+			if err != nil {
 				return nil, err
 			}
+			if skipped {
+				continue
+			}
 		} else {
 			// ... Else, we want to copy over a file
 			mode := chmod
@@ -721,12 +1313,16 @@ func CopyDir(src, dest string, context FileContext, uid, gid int64, chmod fs.Fil
 				mode = fs.FileMode(0o600)
 			}
 
-			if _, err := CopyFile(fullPath, destPath, context, uid, gid, mode, useDefaultChmod); err != nil {
+			skipped, err := CopyFile(fullPath, destPath, context, uid, gid, mode, useDefaultChmod)
+			if err != nil {
 				return nil, err
 			}
+			if skipped {
+				continue
+			}
 		}
 		if !IsSymlink(fi) {
-			updates = append(updates, timestampUpdate{src: fullPath, dest: destPath})
+			updates = append(updates, timestampUpdate{src: timestampSrc, dest: destPath})
 		}
 		copiedFiles = append(copiedFiles, destPath)
 	}
@@ -739,12 +1335,39 @@ func CopyDir(src, dest string, context FileContext, uid, gid int64, chmod fs.Fil
 	return copiedFiles, nil
 }
 
-// CopySymlink copies the symlink at src to dest.
+// includedParents returns the directories of files that contain a file which is not
+// excluded. Like in docker, they are copied even though they are excluded themselves,
+// ie. by `**` followed by `!keep/**`, which doesn't match `keep`.
+func includedParents(src string, files []string, context FileContext) map[string]bool {
+	included := map[string]bool{}
+	if len(context.ExcludedFiles) == 0 {
+		return included
+	}
+	for _, file := range files {
+		if included[file] || context.ExcludesFile(filepath.Join(src, file)) {
+			continue
+		}
+		for dir := filepath.Dir(file); dir != "." && !included[dir]; dir = filepath.Dir(dir) {
+			included[dir] = true
+		}
+	}
+	return included
+}
+
+// CopySymlink copies the symlink at src to dest. It returns true if the symlink was not copied,
+// because it is excluded or, with context.SkipUnchanged, dest is the same symlink already.
 func CopySymlink(src, dest string, context FileContext) (bool, error) {
 	if context.ExcludesFile(src) {
 		logrus.Debugf("%s found in .dockerignore, ignoring", src)
 		return true, nil
 	}
+	if context.SkipUnchanged {
+		link, err := os.Readlink(src)
+		if destLink, destErr := os.Readlink(dest); err == nil && destErr == nil && link == destLink {
+			logrus.Debugf("%s links to %s already, not copying it", dest, link)
+			return true, nil
+		}
+	}
 	if FilepathExists(dest) {
 		if err := os.RemoveAll(dest); err != nil {
 			return false, err
@@ -760,7 +1383,9 @@ func CopySymlink(src, dest string, context FileContext) (bool, error) {
 	return false, os.Symlink(link, dest)
 }
 
-// CopyFile copies the file at src to dest
+// CopyFile copies the file at src to dest. It returns true if the file was not copied,
+// because it is excluded, with context.SkipUnchanged, dest has the same content already
+// or, with context.BestEffort, src can't be read.
 func CopyFile(src, dest string, context FileContext, uid, gid int64, chmod fs.FileMode, useDefaultChmod bool) (bool, error) {
 	if context.ExcludesFile(src) {
 		logrus.Debugf("%s found in .dockerignore, ignoring", src)
@@ -772,22 +1397,48 @@ func CopyFile(src, dest string, context FileContext, uid, gid int64, chmod fs.Fi
 		// See iusse #904 for an example.
 		return false, nil
 	}
-	fi, err := os.Stat(src)
-	if err != nil {
+	uid, gid = context.OwnerFor(dest, uid, gid)
+	if fi, ok, err := context.ProvidedSource(src); err != nil {
 		return false, err
+	} else if ok {
+		return false, copyProvidedFile(src, dest, fi, context, uid, gid, chmod, useDefaultChmod)
 	}
-	logrus.Debugf("Copying file %s to %s", src, dest)
-	srcFile, err := FSys.Open(src)
+	fi, err := os.Stat(src)
 	if err != nil {
+		if context.SkipSource(src, err) {
+			return true, nil
+		}
 		return false, err
 	}
-	defer srcFile.Close()
 	uid, gid = DetermineTargetFileOwnership(fi, uid, gid)
 
 	mode := chmod
 	if useDefaultChmod {
 		mode = fi.Mode()
+		if context.DefaultFileMode != 0 {
+			mode = context.DefaultFileMode
+		}
 	}
+	if context.SkipUnchanged {
+		unchanged, err := unchangedFile(src, fi, dest, mode, uid, gid)
+		if err != nil {
+			return false, err
+		}
+		if unchanged {
+			logrus.Debugf("%s has the content of %s already, not copying it", dest, src)
+			return true, nil
+		}
+	}
+
+	logrus.Debugf("Copying file %s to %s", src, dest)
+	srcFile, err := FSys.Open(src)
+	if err != nil {
+		if context.SkipSource(src, err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer srcFile.Close()
 
 	err = CreateFile(dest, srcFile, mode, uint32(uid), uint32(gid))
 	if err != nil {
@@ -798,8 +1449,87 @@ func CopyFile(src, dest string, context FileContext, uid, gid int64, chmod fs.Fi
 	if err != nil {
 		return false, err
 	}
+	if context.PreserveAtime {
+		if err := CopyAccessTime(src, dest); err != nil {
+			return false, err
+		}
+	}
+	if mtime, ok := context.CommitTimes[src]; ok {
+		if err := os.Chtimes(dest, time.Time{}, mtime); err != nil {
+			return false, err
+		}
+	}
+
+	if err := CopyCapabilities(src, dest); err != nil {
+		return false, err
+	}
+	if context.PreserveInodeFlags {
+		return false, CopyInodeFlags(src, dest)
+	}
+	return false, nil
+}
+
+const permissionBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// unchangedFile returns true if dest is a regular file with the content of src, mode and
+// ownership, so that copying src over it would only change its timestamps.
+func unchangedFile(src string, srcInfo os.FileInfo, dest string, mode fs.FileMode, uid, gid int64) (bool, error) {
+	destInfo, err := os.Lstat(dest)
+	if err != nil || !destInfo.Mode().IsRegular() || destInfo.Size() != srcInfo.Size() {
+		return false, nil //nolint:nilerr
+	}
+	if destInfo.Mode()&permissionBits != mode&permissionBits || !sameOwner(destInfo, uid, gid) {
+		return false, nil
+	}
+	return sameContent(src, dest)
+}
 
-	return false, CopyCapabilities(src, dest)
+// unchangedDir returns true if dest is a directory with ownership and, unless it is 0, mode.
+func unchangedDir(dest string, mode fs.FileMode, uid, gid int64) bool {
+	destInfo, err := os.Lstat(dest)
+	if err != nil || !destInfo.IsDir() {
+		return false
+	}
+	if mode != 0 && destInfo.Mode()&permissionBits != mode&permissionBits {
+		return false
+	}
+	return sameOwner(destInfo, uid, gid)
+}
+
+func sameOwner(fi os.FileInfo, uid, gid int64) bool {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int64(stat.Uid) == uid && int64(stat.Gid) == gid
+}
+
+func sameContent(a, b string) (bool, error) {
+	fa, err := FSys.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
 }
 
 func NewFileContextFromDockerfile(dockerfilePath, buildcontext string) (FileContext, error) {
@@ -877,9 +1607,40 @@ func hasCleanedFilepathPrefix(path, prefix string, prefixMatchOnly bool) bool {
 }
 
 func Volumes() []string {
+	ignorelistMu.RLock()
+	defer ignorelistMu.RUnlock()
 	return volumes
 }
 
+// CheckParentDirectories returns a descriptive error if one of the parent directories
+// of path, which are created if they don't exist, is a file.
+func CheckParentDirectories(path string) error {
+	var parents []string
+	for dir := filepath.Dir(filepath.Clean(path)); dir != "/" && dir != "." && dir != ""; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		fi, err := os.Lstat(parents[i])
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(parents[i]); os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if !fi.IsDir() {
+			return errors.Errorf("cannot create directory %s: not a directory (a file exists)", parents[i])
+		}
+	}
+	return nil
+}
+
 func MkdirAllWithPermissions(path string, mode os.FileMode, uid, gid int64) error {
 	// Check if a file already exists on the path, if yes then delete it
 	info, err := os.Lstat(path)
@@ -988,6 +1749,54 @@ func EvalSymLink(path string) (string, error) {
 	return filepath.EvalSymlinks(path)
 }
 
+// ResolvePathInRoot resolves the symlinks in path as if root was the filesystem root,
+// absolute link targets and ".." are kept inside root. It returns the resolved path
+// joined with root and the symlinks it followed, relative to root.
+// Components that don't exist are kept as they are.
+func ResolvePathInRoot(root, path string) (string, []string, error) {
+	const maxLinks = 255
+	resolved := "/"
+	links := []string{}
+	parts := strings.Split(filepath.Clean("/"+path), "/")
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if !IsSymlink(fi) {
+			resolved = next
+			continue
+		}
+		if len(links) == maxLinks {
+			return "", nil, fmt.Errorf("too many levels of symbolic links resolving %s", path)
+		}
+		link, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", nil, err
+		}
+		links = append(links, strings.TrimPrefix(next, "/"))
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(link, "/"), parts...)
+	}
+	return filepath.Join(root, resolved), links, nil
+}
+
 func getSymlink(path string) error {
 	fi, err := os.Lstat(path)
 	if err != nil {
@@ -1107,6 +1916,94 @@ func CopyCapabilities(src string, dest string) error {
 	return nil
 }
 
+const (
+	// inode flags of linux/fs.h that are not defined by x/sys/unix
+	fsImmutableFl = 0x00000010
+	fsAppendFl    = 0x00000020
+
+	preservedInodeFlags = fsImmutableFl | fsAppendFl
+)
+
+// inodeFlagged are the files CopyInodeFlags set inode flags on.
+var inodeFlagged = struct {
+	sync.Mutex
+	paths []string
+}{}
+
+// CopyInodeFlags copies the immutable and append-only inode flags from src to dest,
+// it has to be called once dest won't be changed anymore. Setting them requires
+// CAP_LINUX_IMMUTABLE, without it or on filesystems without inode flags they are
+// skipped with a warning. ClearInodeFlags clears them again.
+func CopyInodeFlags(src string, dest string) error {
+	flags, err := inodeFlags(src)
+	if err == syscall.ENOTTY || err == syscall.EOPNOTSUPP || err == syscall.EINVAL {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "getting inode flags from src")
+	}
+	if flags&preservedInodeFlags == 0 {
+		return nil
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	destFlags, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err == nil {
+		err = unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, destFlags|flags&preservedInodeFlags)
+	}
+	switch err {
+	case nil:
+		inodeFlagged.Lock()
+		inodeFlagged.paths = append(inodeFlagged.paths, dest)
+		inodeFlagged.Unlock()
+		return nil
+	case syscall.EPERM:
+		diagnostics.Warnf(diagnostics.InodeFlagsSkipped, "not preserving the inode flags of %s, setting them requires CAP_LINUX_IMMUTABLE", src)
+		return nil
+	case syscall.ENOTTY, syscall.EOPNOTSUPP, syscall.EINVAL:
+		diagnostics.Warnf(diagnostics.InodeFlagsSkipped, "not preserving the inode flags of %s, the filesystem of %s doesn't support them", src, dest)
+		return nil
+	}
+	return errors.Wrap(err, "setting inode flags on dest")
+}
+
+// ClearInodeFlags clears the inode flags CopyInodeFlags set, so that no immutable or
+// append-only files are left in the filesystem of the build once a stage is built,
+// as kaniko changes and deletes its files afterwards.
+func ClearInodeFlags() error {
+	inodeFlagged.Lock()
+	defer inodeFlagged.Unlock()
+	for _, path := range inodeFlagged.paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		flags, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+		if err == nil {
+			err = unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, flags&^preservedInodeFlags)
+		}
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "clearing inode flags of %s", path)
+		}
+	}
+	inodeFlagged.paths = nil
+	return nil
+}
+
+func inodeFlags(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+}
+
 // CopyTimestamps copies the file timestamps from src to dest
 func CopyTimestamps(src string, dest string) error {
 	fi, err := os.Lstat(src)
@@ -1126,6 +2023,49 @@ func CopyTimestamps(src string, dest string) error {
 	return nil
 }
 
+// CopyAccessTime copies the access time of src to dest, leaving the modification
+// time of dest alone. Linux has no syscall to set the birth time of a file, so dest
+// keeps the birth time of its creation.
+func CopyAccessTime(src string, dest string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		logrus.Debugf("Not copying the access time of %s, it has none", src)
+		return nil
+	}
+	atime := time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+	if err := os.Chtimes(dest, atime, time.Time{}); err != nil {
+		return fmt.Errorf("failed to copy access time: %w", err)
+	}
+	return nil
+}
+
+// resolveParents returns path with the symlinks among the existing parents of it
+// resolved, its missing parents and its last element are kept as they are.
+func resolveParents(path string) (string, error) {
+	parent, missing := filepath.Dir(path), []string{filepath.Base(path)}
+	for {
+		if _, err := os.Lstat(parent); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		if parent == filepath.Dir(parent) {
+			return path, nil
+		}
+		missing = append([]string{filepath.Base(parent)}, missing...)
+		parent = filepath.Dir(parent)
+	}
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving parents of %s", path)
+	}
+	return filepath.Join(append([]string{resolved}, missing...)...), nil
+}
+
 func createParentDirectory(path string, uid int, gid int) error {
 	baseDir := filepath.Dir(path)
 	if info, err := os.Lstat(baseDir); os.IsNotExist(err) {
@@ -1168,17 +2108,32 @@ func createParentDirectory(path string, uid int, gid int) error {
 	return nil
 }
 
+// ResetIgnoreList forgets the volumes and initializes the ignore list again, as
+// every extraction of an image does unless it keeps them, see KeepIgnoreList.
+func ResetIgnoreList() error {
+	ignorelistMu.Lock()
+	volumes = []string{}
+	ignorelistMu.Unlock()
+	if err := InitIgnoreList(); err != nil {
+		return errors.Wrap(err, "initializing filesystem ignore list")
+	}
+	return nil
+}
+
 // InitIgnoreList will initialize the ignore list using:
 // - defaultIgnoreList
 // - mounted paths via DetectFilesystemIgnoreList()
 func InitIgnoreList() error {
 	logrus.Trace("Initializing ignore list")
-	ignorelist = append([]IgnoreListEntry{}, defaultIgnoreList...)
-
-	if err := DetectFilesystemIgnoreList(config.MountInfoPath); err != nil {
+	entries, err := detectFilesystemIgnoreList(config.MountInfoPath)
+	if err != nil {
 		return errors.Wrap(err, "checking filesystem mount paths for ignore list")
 	}
 
+	// the list is replaced at once, so concurrent extractions never see it partially initialized
+	ignorelistMu.Lock()
+	defer ignorelistMu.Unlock()
+	ignorelist = append(append([]IgnoreListEntry{}, defaultIgnoreList...), entries...)
 	return nil
 }
 
@@ -1192,12 +2147,15 @@ type walkFSResult struct {
 // returns a list of changed files determined by `changeFunc` and a list
 // of deleted files. Input existingPaths is changed inside this function and
 // returned as deleted files map.
+// Files that vanish during the walk are retried up to retries times and
+// then skipped, they are left in the deleted files.
 // It timesout after 90 mins which can be configured via setting an environment variable
 // SNAPSHOT_TIMEOUT in the kaniko pod definition.
 func WalkFS(
 	dir string,
 	existingPaths map[string]struct{},
 	changeFunc func(string) (bool, error),
+	retries int,
 ) ([]string, map[string]struct{}, error) {
 	timeOutStr := os.Getenv(snapshotTimeout)
 	if timeOutStr == "" {
@@ -1213,7 +2171,7 @@ func WalkFS(
 	ch := make(chan walkFSResult, 1)
 
 	go func() {
-		filesAdded, existingPaths, err := gowalkDir(dir, existingPaths, changeFunc)
+		filesAdded, existingPaths, err := gowalkDir(dir, existingPaths, changeFunc, retries)
 		ch <- walkFSResult{filesAdded, existingPaths, err}
 	}()
 
@@ -1229,13 +2187,18 @@ func WalkFS(
 	}
 }
 
-func gowalkDir(dir string, existingPaths map[string]struct{}, changeFunc func(string) (bool, error)) ([]string, map[string]struct{}, error) {
+func gowalkDir(dir string, existingPaths map[string]struct{}, changeFunc func(string) (bool, error), retries int) ([]string, map[string]struct{}, error) {
 	foundPaths := make([]string, 0)
 	deletedFiles := existingPaths // Make a reference.
 
 	callback := func(path string, info fs.DirEntry, err error) error {
 		logrus.Tracef("Analyzing path '%s'", path)
 		if err != nil {
+			// a directory removed while it is walked, its entries are gone as well
+			if path != dir && errors.Is(err, fs.ErrNotExist) {
+				logrus.Warnf("Directory %s vanished during the snapshot, skipping it", path)
+				return nil
+			}
 			return err
 		}
 
@@ -1247,12 +2210,23 @@ func gowalkDir(dir string, existingPaths map[string]struct{}, changeFunc func(st
 			return nil
 		}
 
+		isChanged, err := changeFunc(path)
+		for i := 0; errors.Is(err, fs.ErrNotExist) && i < retries; i++ {
+			sleepDuration := time.Duration(1<<i) * snapshotRetryDelay
+			logrus.Debugf("Retrying %s after %s due to %v", path, sleepDuration, err)
+			time.Sleep(sleepDuration)
+			isChanged, err = changeFunc(path)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			logrus.Warnf("File %s vanished during the snapshot, skipping it", path)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
 		// File is existing on disk, remove it from deleted files.
 		delete(deletedFiles, path)
-
-		if isChanged, err := changeFunc(path); err != nil {
-			return err
-		} else if isChanged {
+		if isChanged {
 			foundPaths = append(foundPaths, path)
 		}
 
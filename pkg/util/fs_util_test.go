@@ -19,6 +19,7 @@ package util
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -26,7 +27,9 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -35,8 +38,11 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/osscontainertools/kaniko/pkg/constants"
+	"github.com/osscontainertools/kaniko/pkg/diagnostics"
 	"github.com/osscontainertools/kaniko/pkg/mocks/go-containerregistry/mockv1"
 	"github.com/osscontainertools/kaniko/testutil"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sys/unix"
 )
 
 func Test_DetectFilesystemSkiplist(t *testing.T) {
@@ -875,6 +881,44 @@ func TestCopySymlink(t *testing.T) {
 	}
 }
 
+func TestResolvePathInRoot(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "usr", "lib"), 0o755)
+	os.WriteFile(filepath.Join(root, "usr", "lib", "file"), nil, 0o644)
+	os.Symlink("/usr/lib", filepath.Join(root, "abs"))
+	os.Symlink("usr/lib", filepath.Join(root, "rel"))
+	os.Symlink("../../../../lib", filepath.Join(root, "usr", "escape"))
+	os.Symlink("/abs/file", filepath.Join(root, "chain"))
+	os.Symlink("loop", filepath.Join(root, "loop"))
+
+	tests := []struct {
+		name      string
+		path      string
+		want      string
+		wantLinks []string
+		wantErr   bool
+	}{
+		{name: "no symlink", path: "usr/lib/file", want: "usr/lib/file", wantLinks: []string{}},
+		{name: "absolute symlink stays in root", path: "/abs/file", want: "usr/lib/file", wantLinks: []string{"abs"}},
+		{name: "relative symlink", path: "rel/file", want: "usr/lib/file", wantLinks: []string{"rel"}},
+		{name: "dot dot stays in root", path: "usr/escape", want: "lib", wantLinks: []string{"usr/escape"}},
+		{name: "chain of symlinks", path: "chain", want: "usr/lib/file", wantLinks: []string{"chain", "abs"}},
+		{name: "missing path is kept", path: "abs/missing/file", want: "usr/lib/missing/file", wantLinks: []string{"abs"}},
+		{name: "symlink loop", path: "loop", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, links, err := ResolvePathInRoot(root, tt.path)
+			testutil.CheckError(t, tt.wantErr, err)
+			if tt.wantErr {
+				return
+			}
+			testutil.CheckDeepEqual(t, filepath.Join(root, tt.want), got)
+			testutil.CheckDeepEqual(t, tt.wantLinks, links)
+		})
+	}
+}
+
 func Test_childDirInSkiplist(t *testing.T) {
 	type args struct {
 		path       string
@@ -972,6 +1016,101 @@ func Test_correctDockerignoreFileIsUsed(t *testing.T) {
 	}
 }
 
+// flakyReader returns err instead of reading every nth call.
+type flakyReader struct {
+	r     io.Reader
+	err   error
+	n     int
+	calls int
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	f.calls++
+	if f.calls%f.n == 0 {
+		return 0, f.err
+	}
+	// short reads, so that the copy needs many of them
+	if len(p) > 1024 {
+		p = p[:1024]
+	}
+	return f.r.Read(p)
+}
+
+// flakyWriter writes half of the bytes of every other call and fails with err.
+type flakyWriter struct {
+	bytes.Buffer
+	err   error
+	calls int
+}
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	f.calls++
+	if f.calls%2 == 0 && len(p) > 1 {
+		n, _ := f.Buffer.Write(p[:len(p)/2])
+		return n, f.err
+	}
+	return f.Buffer.Write(p)
+}
+
+func Test_copyRetrying(t *testing.T) {
+	original := transientRetryDelay
+	transientRetryDelay = 0
+	defer func() { transientRetryDelay = original }()
+	content := bytes.Repeat([]byte("0123456789"), 10*1024)
+
+	for _, transient := range []error{syscall.EINTR, syscall.EAGAIN} {
+		t.Run(transient.Error(), func(t *testing.T) {
+			var dst bytes.Buffer
+			n, err := copyRetrying(&dst, &flakyReader{r: bytes.NewReader(content), err: transient, n: 3})
+			testutil.CheckErrorAndDeepEqual(t, false, err, int64(len(content)), n)
+			testutil.CheckDeepEqual(t, true, bytes.Equal(content, dst.Bytes()))
+
+			flaky := &flakyWriter{err: &os.PathError{Op: "write", Path: "dest", Err: transient}}
+			n, err = copyRetrying(flaky, &flakyReader{r: bytes.NewReader(content), err: transient, n: 3})
+			testutil.CheckErrorAndDeepEqual(t, false, err, int64(len(content)), n)
+			testutil.CheckDeepEqual(t, true, bytes.Equal(content, flaky.Bytes()))
+		})
+	}
+
+	// genuine errors fail the copy
+	var dst bytes.Buffer
+	_, err := copyRetrying(&dst, &flakyReader{r: bytes.NewReader(content), err: syscall.EIO, n: 3})
+	testutil.CheckDeepEqual(t, true, errors.Is(err, syscall.EIO))
+
+	// as do transient errors that don't go away
+	_, err = copyRetrying(&dst, &flakyReader{r: bytes.NewReader(content), err: syscall.EINTR, n: 1})
+	testutil.CheckDeepEqual(t, true, errors.Is(err, syscall.EINTR))
+}
+
+func Test_CreateFile_retries_EINTR(t *testing.T) {
+	content := bytes.Repeat([]byte("meow"), 64*1024)
+	dest := filepath.Join(t.TempDir(), "dest")
+	err := CreateFile(dest, &flakyReader{r: bytes.NewReader(content), err: syscall.EINTR, n: 2}, 0644, uint32(os.Getuid()), uint32(os.Getgid()))
+	testutil.CheckNoError(t, err)
+	b, err := os.ReadFile(dest)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, bytes.Equal(content, b))
+}
+
+func Test_CreateFile_fromFile(t *testing.T) {
+	content := bytes.Repeat([]byte("meow"), 64*1024)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dest := filepath.Join(dir, "dest")
+	testutil.CheckNoError(t, CreateFile(dest, f, 0644, uint32(os.Getuid()), uint32(os.Getgid())))
+	b, err := os.ReadFile(dest)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, bytes.Equal(content, b))
+}
+
 func Test_CopyFile_skips_self(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
@@ -1007,6 +1146,101 @@ func Test_CopyFile_skips_self(t *testing.T) {
 	}
 }
 
+func Test_CopyFile_skips_unchanged(t *testing.T) {
+	tests := []struct {
+		name        string
+		destContent string
+		destMode    fs.FileMode
+		wantSkipped bool
+	}{
+		{name: "same content and mode", destContent: "bar", destMode: 0o644, wantSkipped: true},
+		{name: "different content", destContent: "baz", destMode: 0o644},
+		{name: "different size", destContent: "barbar", destMode: 0o644},
+		{name: "different mode", destContent: "bar", destMode: 0o600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			src := filepath.Join(tempDir, "src")
+			dest := filepath.Join(tempDir, "dest")
+			if err := os.WriteFile(src, []byte("bar"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(dest, []byte(tt.destContent), tt.destMode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dest, tt.destMode); err != nil {
+				t.Fatal(err)
+			}
+
+			skipped, err := CopyFile(src, dest, FileContext{SkipUnchanged: true}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, tt.wantSkipped, skipped)
+			content, err := os.ReadFile(dest)
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, "bar", string(content))
+		})
+	}
+}
+
+func setInodeFlags(path string, flags int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, flags)
+}
+
+func Test_CopyFile_preserves_inode_flags(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	dest := filepath.Join(tempDir, "dest")
+	if err := os.WriteFile(src, []byte("bar"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	flags, err := inodeFlags(src)
+	if err != nil {
+		t.Skipf("filesystem doesn't support inode flags: %v", err)
+	}
+	if err := setInodeFlags(src, flags|fsImmutableFl); err != nil {
+		t.Skipf("can't set the immutable flag: %v", err)
+	}
+	// immutable files can't be removed by the cleanup of the temp dir
+	t.Cleanup(func() {
+		for _, p := range []string{src, dest} {
+			if f, err := inodeFlags(p); err == nil {
+				setInodeFlags(p, f&^preservedInodeFlags)
+			}
+		}
+	})
+	diagnostics.Reset()
+	defer diagnostics.Reset()
+
+	_, err = CopyFile(src, dest, FileContext{PreserveInodeFlags: true}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+	testutil.CheckNoError(t, err)
+	destFlags, err := inodeFlags(dest)
+	testutil.CheckNoError(t, err)
+	if destFlags&fsImmutableFl != 0 {
+		// no immutable files are left in the filesystem once the stage is built
+		testutil.CheckNoError(t, ClearInodeFlags())
+		destFlags, err = inodeFlags(dest)
+		testutil.CheckNoError(t, err)
+		if destFlags&preservedInodeFlags != 0 {
+			t.Errorf("expected the inode flags of dest to be cleared, got %x", destFlags)
+		}
+		return
+	}
+	// without CAP_LINUX_IMMUTABLE for dest the flag is skipped with a warning
+	var skipped bool
+	for _, d := range diagnostics.All() {
+		skipped = skipped || d.Code == diagnostics.InodeFlagsSkipped
+	}
+	if !skipped {
+		t.Errorf("expected dest to be immutable or a %s warning, got flags %x", diagnostics.InodeFlagsSkipped, destFlags)
+	}
+}
+
 func fakeExtract(_ string, _ *tar.Header, _ string, _ io.Reader) error {
 	return nil
 }
@@ -1231,6 +1465,234 @@ func Test_GetFSFromLayers_with_whiteouts_include_whiteout_disabled(t *testing.T)
 	}
 }
 
+// writeExtract writes regular files and directories to disk, without changing ownership
+func writeExtract(root string, hdr *tar.Header, cleanedName string, r io.Reader) error {
+	path := filepath.Join(root, cleanedName)
+	if hdr.Typeflag == tar.TypeDir {
+		return os.MkdirAll(path, 0o755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// mockFilesLayer returns a layer of empty files, names ending in / are directories
+func mockFilesLayer(t *testing.T, ctrl *gomock.Controller, files ...string) v1.Layer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f, Mode: 0o644, Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f, "/") {
+			hdr = &tar.Header{Name: f, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l := mockv1.NewMockLayer(ctrl)
+	l.EXPECT().MediaType().Return(types.OCILayer, nil)
+	l.EXPECT().Uncompressed().Return(io.NopCloser(buf), nil)
+	return l
+}
+
+func Test_GetFSFromLayers_KeepIgnoreList(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	ctrl := gomock.NewController(t)
+
+	if err := ResetIgnoreList(); err != nil {
+		t.Fatal(err)
+	}
+	AddVolumePathToIgnoreList("/data")
+	defer func() {
+		if err := ResetIgnoreList(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the images are extracted concurrently without touching the ignore list
+	var g errgroup.Group
+	for i := 0; i < 8; i++ {
+		root := t.TempDir()
+		layer := mockFilesLayer(t, ctrl, "app/", "app/file")
+		g.Go(func() error {
+			_, err := GetFSFromLayers(root, []v1.Layer{layer}, ExtractFunc(writeExtract), KeepIgnoreList())
+			return err
+		})
+	}
+	testutil.CheckNoError(t, g.Wait())
+	testutil.CheckDeepEqual(t, []string{"/data"}, Volumes())
+
+	// extractions without it start over
+	_, err := GetFSFromLayers(t.TempDir(), []v1.Layer{mockFilesLayer(t, ctrl, "file")}, ExtractFunc(writeExtract))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string{}, Volumes())
+}
+
+func Test_GetFSFromLayers_include_paths(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	tests := []struct {
+		name     string
+		include  string
+		existing []string
+		layer    []string
+		expected []string
+		removed  []string
+		kept     []string
+	}{
+		{
+			name:     "subtree",
+			include:  "/app",
+			existing: []string{"app/old", "gone"},
+			layer:    []string{"app/", "app/a", "app/sub/b", "appendix/c", "other/d", "app/.wh.old", ".wh.gone"},
+			expected: []string{"app", "app/a", "app/sub/b"},
+			removed:  []string{"app/old", "appendix/c", "other/d"},
+			kept:     []string{"gone"},
+		},
+		{
+			name:     "whiteout of a parent directory",
+			include:  "/app/sub",
+			existing: []string{"app/sub/old", "app/keep"},
+			layer:    []string{".wh.app", "app/", "app/sub/", "app/sub/new"},
+			expected: []string{"app", "app/sub", "app/sub/new"},
+			removed:  []string{"app/sub/old"},
+			kept:     []string{"app/keep"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			root := t.TempDir()
+			for _, f := range tc.existing {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(root, f)), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(root, f), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			actualFiles, err := GetFSFromLayers(root, []v1.Layer{mockFilesLayer(t, ctrl, tc.layer...)}, ExtractFunc(writeExtract), IncludePaths(tc.include))
+
+			var expectedFiles []string
+			for _, f := range tc.expected {
+				expectedFiles = append(expectedFiles, filepath.Join(root, f))
+			}
+			assertGetFSFromLayers(t, actualFiles, expectedFiles, err, false)
+			for _, f := range tc.removed {
+				if _, err := os.Lstat(filepath.Join(root, f)); !os.IsNotExist(err) {
+					t.Errorf("expected %s to not exist, got %v", f, err)
+				}
+			}
+			for _, f := range tc.kept {
+				if _, err := os.Lstat(filepath.Join(root, f)); err != nil {
+					t.Errorf("expected %s to exist: %v", f, err)
+				}
+			}
+		})
+	}
+}
+
+func Test_GetFSFromLayers_exclude_paths(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	ctrl := gomock.NewController(t)
+	root := t.TempDir()
+	layer := mockFilesLayer(t, ctrl, "usr/", "usr/share/", "usr/share/doc/", "usr/share/doc/README", "usr/share/docs", "usr/share/man/", "usr/share/man/ls.1", "usr/bin/", "usr/bin/ls", "usr/share/.wh.doc")
+	actualFiles, err := GetFSFromLayers(root, []v1.Layer{layer}, ExtractFunc(writeExtract), ExcludePaths("/usr/share/doc", "usr/share/man/"))
+
+	var expectedFiles []string
+	for _, f := range []string{"usr", "usr/share", "usr/share/docs", "usr/bin", "usr/bin/ls"} {
+		expectedFiles = append(expectedFiles, filepath.Join(root, f))
+	}
+	assertGetFSFromLayers(t, actualFiles, expectedFiles, err, false)
+	for _, f := range []string{"usr/share/doc", "usr/share/man"} {
+		if _, err := os.Lstat(filepath.Join(root, f)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to not exist, got %v", f, err)
+		}
+	}
+	for _, f := range []string{"usr/share/docs", "usr/bin/ls"} {
+		if _, err := os.Lstat(filepath.Join(root, f)); err != nil {
+			t.Errorf("expected %s to exist: %v", f, err)
+		}
+	}
+}
+
+func Test_GetFSFromLayers_with_opaque_whiteouts(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	for _, includeWhiteout := range []bool{false, true} {
+		t.Run(fmt.Sprintf("include whiteout %t", includeWhiteout), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			root := t.TempDir()
+			opts := []FSOpt{ExtractFunc(writeExtract)}
+			if includeWhiteout {
+				opts = append(opts, IncludeWhiteout())
+			}
+			layers := []v1.Layer{
+				mockFilesLayer(t, ctrl, "dir/a", "dir/b", "keep/c"),
+				mockFilesLayer(t, ctrl, "dir/new", "dir/.wh..wh..opq", "dir/newer"),
+			}
+
+			actualFiles, err := GetFSFromLayers(root, layers, opts...)
+
+			expectedFiles := []string{
+				filepath.Join(root, "dir/a"),
+				filepath.Join(root, "dir/b"),
+				filepath.Join(root, "keep/c"),
+				filepath.Join(root, "dir/new"),
+			}
+			if includeWhiteout {
+				expectedFiles = append(expectedFiles, filepath.Join(root, "dir/.wh..wh..opq"))
+			}
+			expectedFiles = append(expectedFiles, filepath.Join(root, "dir/newer"))
+			assertGetFSFromLayers(t, actualFiles, expectedFiles, err, false)
+
+			// only the contents of the previous layers are whited out
+			for _, f := range []string{"dir/a", "dir/b"} {
+				if _, err := os.Lstat(filepath.Join(root, f)); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be whited out, got %v", f, err)
+				}
+			}
+			for _, f := range []string{"keep/c", "dir/new", "dir/newer"} {
+				if _, err := os.Lstat(filepath.Join(root, f)); err != nil {
+					t.Errorf("expected %s to exist: %v", f, err)
+				}
+			}
+		})
+	}
+}
+
 func Test_GetFSFromLayers_ignorelist(t *testing.T) {
 	_original := FSys
 	FSys = OSFS{}
@@ -1442,6 +1904,76 @@ func Test_GetFSFromLayers(t *testing.T) {
 	)
 }
 
+func Test_GetFSFromLayers_hardlinks(t *testing.T) {
+	_original := FSys
+	FSys = OSFS{}
+	defer func() { FSys = _original }()
+
+	resetMountInfoFile := provideEmptyMountinfoFile()
+	defer resetMountInfoFile()
+
+	ctrl := gomock.NewController(t)
+
+	root := t.TempDir()
+
+	layer := func(hdrs ...*tar.Header) v1.Layer {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for _, hdr := range hdrs {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				if _, err := tw.Write([]byte("gzip-binary")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		mockLayer := mockv1.NewMockLayer(ctrl)
+		mockLayer.EXPECT().MediaType().Return(types.OCILayer, nil)
+		mockLayer.EXPECT().Uncompressed().Return(io.NopCloser(buf), nil)
+		return mockLayer
+	}
+
+	layers := []v1.Layer{
+		layer(
+			fileHeader("bin/gzip", "gzip-binary", 0o755, time.Now()),
+			hardlinkHeader("bin/gunzip", "bin/gzip"),
+			// the parent directory of a link may not exist yet
+			hardlinkHeader("usr/bin/zcat", "/bin/gzip"),
+		),
+		// links may point to files extracted from a previous layer
+		layer(hardlinkHeader("bin/uncompress", "bin/gzip")),
+	}
+
+	actualFiles, err := GetFSFromLayers(root, layers, ExtractFunc(ExtractFile))
+	assertGetFSFromLayers(
+		t,
+		actualFiles,
+		[]string{
+			filepath.Join(root, "bin/gzip"),
+			filepath.Join(root, "bin/gunzip"),
+			filepath.Join(root, "usr/bin/zcat"),
+			filepath.Join(root, "bin/uncompress"),
+		},
+		err,
+		false,
+	)
+	for _, link := range []string{"bin/gunzip", "usr/bin/zcat", "bin/uncompress"} {
+		filesAreHardlinks(link, "bin/gzip")(root, t)
+	}
+	fi, err := os.Stat(filepath.Join(root, "bin/gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nlink := getSyscallStatT(fi).Nlink; nlink != 4 {
+		t.Errorf("expected bin/gzip to have 4 links, got %d", nlink)
+	}
+}
+
 func assertGetFSFromLayers(
 	t *testing.T,
 	actualFiles []string,
@@ -1574,3 +2106,416 @@ func Test_setFileTimes(t *testing.T) {
 		})
 	}
 }
+
+func Test_CheckParentDirectories(t *testing.T) {
+	root := t.TempDir()
+	if err := testutil.SetupFiles(root, map[string]string{"dir/file": "meow"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir", filepath.Join(root, "dirlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(root, "filelink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path    string
+		notADir string
+	}{
+		{path: "dir/file"},
+		{path: "dir/new/file"},
+		{path: "dirlink/new/file"},
+		{path: "dangling/file"},
+		{path: "dir/file/new", notADir: "dir/file"},
+		{path: "dir/file/sub/new", notADir: "dir/file"},
+		{path: "filelink/new", notADir: "filelink"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			err := CheckParentDirectories(filepath.Join(root, tc.path))
+			if tc.notADir == "" {
+				testutil.CheckNoError(t, err)
+				return
+			}
+			testutil.CheckError(t, true, err)
+			testutil.CheckDeepEqual(t, fmt.Sprintf("cannot create directory %s: not a directory (a file exists)", filepath.Join(root, tc.notADir)), err.Error())
+		})
+	}
+}
+
+func Test_CopyDir_is_independent_of_walk_order(t *testing.T) {
+	src := t.TempDir()
+	if err := testutil.SetupFiles(src, map[string]string{
+		"a":       "a",
+		"b/c":     "c",
+		"b/d/e":   "e",
+		"b-f":     "f",
+		"g/h/i/j": "j",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	layerFor := func(walk func(string, string) ([]string, error)) ([]string, string) {
+		original := relativeFiles
+		defer func() { relativeFiles = original }()
+		relativeFiles = walk
+
+		dest := filepath.Join(t.TempDir(), "dest")
+		copied, err := CopyDir(src, dest, FileContext{}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rel []string
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for _, f := range copied {
+			r, err := filepath.Rel(dest, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rel = append(rel, r)
+			fi, err := os.Lstat(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdr := &tar.Header{Name: r, Mode: int64(fi.Mode().Perm())}
+			var content []byte
+			if fi.Mode().IsRegular() {
+				if content, err = os.ReadFile(f); err != nil {
+					t.Fatal(err)
+				}
+				hdr.Size = int64(len(content))
+			} else {
+				hdr.Typeflag = tar.TypeDir
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := SHA256(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rel, digest
+	}
+
+	reversed := func(fp string, root string) ([]string, error) {
+		files, err := RelativeFiles(fp, root)
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(files)))
+		return files, nil
+	}
+
+	expectedFiles, expectedDigest := layerFor(RelativeFiles)
+	actualFiles, actualDigest := layerFor(reversed)
+	testutil.CheckDeepEqual(t, expectedFiles, actualFiles)
+	testutil.CheckDeepEqual(t, expectedDigest, actualDigest)
+}
+
+func Test_WalkFS_retries_vanished_files(t *testing.T) {
+	original := snapshotRetryDelay
+	snapshotRetryDelay = time.Millisecond
+	defer func() { snapshotRetryDelay = original }()
+
+	dir := t.TempDir()
+	for _, f := range []string{"flaky", "gone"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// flaky appears to be missing once, gone never comes back
+	attempts := map[string]int{}
+	changeFunc := func(path string) (bool, error) {
+		attempts[filepath.Base(path)]++
+		if filepath.Base(path) == "gone" || (filepath.Base(path) == "flaky" && attempts["flaky"] == 1) {
+			return false, os.ErrNotExist
+		}
+		return true, nil
+	}
+
+	for _, tc := range []struct {
+		retries  int
+		expected []string
+	}{
+		{retries: 0, expected: []string{dir}},
+		{retries: 2, expected: []string{dir, filepath.Join(dir, "flaky")}},
+	} {
+		t.Run(strconv.Itoa(tc.retries), func(t *testing.T) {
+			attempts = map[string]int{}
+			existing := map[string]struct{}{filepath.Join(dir, "gone"): {}}
+			changed, deleted, err := WalkFS(dir, existing, changeFunc, tc.retries)
+			testutil.CheckErrorAndDeepEqual(t, false, err, tc.expected, changed)
+			testutil.CheckDeepEqual(t, map[string]struct{}{filepath.Join(dir, "gone"): {}}, deleted)
+			testutil.CheckDeepEqual(t, tc.retries+1, attempts["gone"])
+		})
+	}
+}
+
+func Test_SyncPath(t *testing.T) {
+	original := fsync
+	defer func() { fsync = original }()
+	var synced []string
+	fsync = func(f *os.File) error {
+		synced = append(synced, f.Name())
+		return original(f)
+	}
+
+	dir := t.TempDir()
+	layout := filepath.Join(dir, "layout")
+	if err := os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"index.json", "blobs/sha256/abc"} {
+		if err := os.WriteFile(filepath.Join(layout, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("index.json", filepath.Join(layout, "index.link")); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CheckNoError(t, SyncPath(layout))
+	sort.Strings(synced)
+	testutil.CheckDeepEqual(t, []string{
+		dir,
+		layout,
+		filepath.Join(layout, "blobs"),
+		filepath.Join(layout, "blobs", "sha256"),
+		filepath.Join(layout, "blobs", "sha256", "abc"),
+		filepath.Join(layout, "index.json"),
+	}, synced)
+
+	synced = nil
+	testutil.CheckNoError(t, SyncPath(filepath.Join(layout, "index.json")))
+	testutil.CheckDeepEqual(t, []string{filepath.Join(layout, "index.json"), layout}, synced)
+
+	testutil.CheckError(t, true, SyncPath(filepath.Join(dir, "missing")))
+}
+
+func Test_SyncFiles(t *testing.T) {
+	original := fsync
+	defer func() { fsync = original }()
+	var synced []string
+	fsync = func(f *os.File) error {
+		synced = append(synced, f.Name())
+		return original(f)
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dest", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"dest/a.txt", "dest/sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "dest", "a.link")); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CheckNoError(t, SyncFiles([]string{
+		filepath.Join(dir, "dest", "a.txt"),
+		filepath.Join(dir, "dest", "a.link"),
+		filepath.Join(dir, "dest", "sub", "b.txt"),
+		filepath.Join(dir, "dest", "deleted.txt"),
+	}))
+	sort.Strings(synced)
+	testutil.CheckDeepEqual(t, []string{
+		filepath.Join(dir, "dest"),
+		filepath.Join(dir, "dest", "a.txt"),
+		filepath.Join(dir, "dest", "sub"),
+		filepath.Join(dir, "dest", "sub", "b.txt"),
+	}, synced)
+}
+
+func Test_CopyDir_preserves_setgid_of_directories(t *testing.T) {
+	src := t.TempDir()
+	if err := testutil.SetupFiles(src, map[string]string{
+		"shared/file": "content",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "shared"), 0o775|fs.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		chmod           fs.FileMode
+		useDefaultChmod bool
+		want            fs.FileMode
+	}{
+		{
+			name:            "default chmod keeps setgid",
+			chmod:           fs.FileMode(0o600),
+			useDefaultChmod: true,
+			want:            fs.ModeDir | fs.ModeSetgid | 0o775,
+		},
+		{
+			name:  "explicit chmod overrides setgid",
+			chmod: fs.FileMode(0o750),
+			want:  fs.ModeDir | 0o750,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			if _, err := CopyDir(src, dest, FileContext{}, DoNotChangeUID, DoNotChangeGID, tc.chmod, tc.useDefaultChmod); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(filepath.Join(dest, "shared"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckDeepEqual(t, tc.want, fi.Mode())
+		})
+	}
+}
+
+func Test_CopyDir_into_symlinked_parents(t *testing.T) {
+	src := t.TempDir()
+	if err := testutil.SetupFiles(src, map[string]string{
+		"file": "content",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dest := range []string{"lib/app", "lib/missing/app"} {
+		t.Run(dest, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(root, "usr/lib"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			// like /lib -> usr/lib of merged /usr distributions
+			if err := os.Symlink("usr/lib", filepath.Join(root, "lib")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CopyDir(src, filepath.Join(root, dest), FileContext{}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filepath.Join(root, "usr", dest, "file"))
+			testutil.CheckErrorAndDeepEqual(t, false, err, "content", string(b))
+			fi, err := os.Lstat(filepath.Join(root, "lib"))
+			testutil.CheckNoError(t, err)
+			if !IsSymlink(fi) {
+				t.Error("the symlinked parent was replaced")
+			}
+		})
+	}
+}
+
+func Test_CopyDir_follows_directory_symlinks(t *testing.T) {
+	dir := t.TempDir()
+	targets := filepath.Join(dir, "targets")
+	if err := testutil.SetupFiles(targets, map[string]string{
+		"tree/file":        "content",
+		"tree/nested/file": "nested",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	context := filepath.Join(dir, "context")
+	if err := testutil.SetupFiles(context, map[string]string{"Dockerfile": "FROM scratch"}); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"tree":           filepath.Join(targets, "tree"),
+		"Dockerfile.lnk": "Dockerfile",
+		"dangling":       "missing",
+		// a cycle, which is copied as a symlink
+		"tree-loop": ".",
+	} {
+		if err := os.Symlink(target, filepath.Join(context, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..", filepath.Join(targets, "tree", "nested", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "dest")
+	files, err := CopyDir(context, dest, FileContext{FollowDirSymlinks: true}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+	testutil.CheckNoError(t, err)
+	var rel []string
+	for _, file := range files {
+		r, err := filepath.Rel(dest, file)
+		testutil.CheckNoError(t, err)
+		rel = append(rel, r)
+	}
+	testutil.CheckDeepEqual(t, []string{".", "Dockerfile", "Dockerfile.lnk", "dangling", "tree", "tree-loop", "tree/file", "tree/nested", "tree/nested/file", "tree/nested/up"}, rel)
+
+	// the symlinked tree is copied as a directory with its content
+	fi, err := os.Lstat(filepath.Join(dest, "tree"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, fi.IsDir())
+	content, err := os.ReadFile(filepath.Join(dest, "tree", "nested", "file"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "nested", string(content))
+	for link, target := range map[string]string{
+		"Dockerfile.lnk": "Dockerfile",
+		"dangling":       "missing",
+		"tree-loop":      ".",
+		"tree/nested/up": "..",
+	} {
+		got, err := os.Readlink(filepath.Join(dest, link))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, target, got)
+	}
+
+	// without the option the symlink is copied
+	dest = filepath.Join(t.TempDir(), "dest")
+	_, err = CopyDir(context, dest, FileContext{}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+	testutil.CheckNoError(t, err)
+	fi, err = os.Lstat(filepath.Join(dest, "tree"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, true, IsSymlink(fi))
+}
+
+func Test_CopyDir_preserves_access_times(t *testing.T) {
+	src := t.TempDir()
+	if err := testutil.SetupFiles(src, map[string]string{"dir/file": "content"}); err != nil {
+		t.Fatal(err)
+	}
+	atime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	mtime := time.Date(2002, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "dir", "file"), atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got := accessTime(t, filepath.Join(src, "dir", "file")); !got.Equal(atime) {
+		t.Skipf("the filesystem doesn't keep access times, got %v", got)
+	}
+
+	for _, preserve := range []bool{false, true} {
+		t.Run(fmt.Sprintf("preserve=%t", preserve), func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			_, err := CopyDir(src, dest, FileContext{PreserveAtime: preserve}, DoNotChangeUID, DoNotChangeGID, fs.FileMode(0o600), true)
+			testutil.CheckNoError(t, err)
+			// reading the source file doesn't change its access time
+			testutil.CheckDeepEqual(t, atime, accessTime(t, filepath.Join(src, "dir", "file")))
+			testutil.CheckDeepEqual(t, preserve, accessTime(t, filepath.Join(dest, "dir", "file")).Equal(atime))
+			fi, err := os.Stat(filepath.Join(dest, "dir", "file"))
+			testutil.CheckNoError(t, err)
+			testutil.CheckDeepEqual(t, true, fi.ModTime().Equal(mtime))
+		})
+	}
+}
+
+func accessTime(t *testing.T, path string) time.Time {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := fi.Sys().(*syscall.Stat_t)
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec)).UTC()
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInterrupted is the cause of a build that was cancelled, ie. by SIGTERM
+var ErrInterrupted = errors.New("build interrupted")
+
+var (
+	buildCtxMu            sync.Mutex
+	buildCtx, cancelBuild = context.WithCancelCause(context.Background())
+)
+
+// BuildContext returns the context of the build, it is done once the build is cancelled.
+func BuildContext() context.Context {
+	buildCtxMu.Lock()
+	defer buildCtxMu.Unlock()
+	return buildCtx
+}
+
+// CancelBuild cancels the build with cause, which should wrap ErrInterrupted.
+func CancelBuild(cause error) {
+	buildCtxMu.Lock()
+	defer buildCtxMu.Unlock()
+	cancelBuild(cause)
+}
+
+// ResetBuildContext replaces a cancelled build context with a new one.
+func ResetBuildContext() {
+	buildCtxMu.Lock()
+	defer buildCtxMu.Unlock()
+	cancelBuild(nil)
+	buildCtx, cancelBuild = context.WithCancelCause(context.Background())
+}
+
+// CheckInterrupted returns the cause of the cancellation if the build was cancelled.
+func CheckInterrupted() error {
+	ctx := BuildContext()
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
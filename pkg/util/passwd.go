@@ -0,0 +1,92 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// passwdEntry is a user or group of a passwd or group file.
+type passwdEntry struct {
+	name string
+	id   string
+}
+
+// readIDFile returns the name and id of each entry of the passwd or group file at
+// path in the filesystem at root, it returns none if the file doesn't exist.
+func readIDFile(root, path string) []passwdEntry {
+	f, err := FSys.Open(filepath.Join(root, path))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []passwdEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// comments and blank space are allowed like by the glibc parser
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// root:x:0:0:root:/root:/bin/sh or root:x:0:
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		entries = append(entries, passwdEntry{name: parts[0], id: parts[2]})
+	}
+	return entries
+}
+
+// lookupIDIn returns the id of the entry named name, or with the id name, of the
+// passwd or group file at path in the filesystem at root.
+func lookupIDIn(root, path, name string) (string, bool) {
+	entries := readIDFile(root, path)
+	for _, e := range entries {
+		if e.name == name {
+			return e.id, true
+		}
+	}
+	for _, e := range entries {
+		if e.id == name {
+			return e.id, true
+		}
+	}
+	return "", false
+}
+
+// lookupUserIn looks up the user name, or uid, in the /etc/passwd of the filesystem
+// at root, so that names resolve to the ids of the image being built and not to
+// those of the host.
+func lookupUserIn(root, name string) (string, error) {
+	if uid, ok := lookupIDIn(root, "/etc/passwd", name); ok {
+		return uid, nil
+	}
+	return "", user.UnknownUserError(name)
+}
+
+// lookupGroupIn looks up the group name, or gid, in the /etc/group of the filesystem
+// at root.
+func lookupGroupIn(root, name string) (string, error) {
+	if gid, ok := lookupIDIn(root, "/etc/group", name); ok {
+		return gid, nil
+	}
+	return "", user.UnknownGroupError(name)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceProvider supplies the sources of COPY and ADD instead of the filesystem,
+// for example to fetch them from an artifact store on demand. Paths are the
+// resolved sources, joined with the root of the context. Wildcards are only
+// matched against the filesystem.
+type SourceProvider interface {
+	// Lstat returns the metadata of the source at path. An error wrapping
+	// fs.ErrNotExist makes the source be read from the filesystem.
+	Lstat(path string) (fs.FileInfo, error)
+	// Open returns the content of the source at path, which Lstat reported
+	// as regular file.
+	Open(path string) (io.ReadCloser, error)
+}
+
+// ProvidedSource returns the metadata of the source at path if the
+// SourceProvider of the context supplies it.
+func (c FileContext) ProvidedSource(path string) (fs.FileInfo, bool, error) {
+	if c.Sources == nil {
+		return nil, false, nil
+	}
+	fi, err := c.Sources.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return fi, true, nil
+}
+
+// LstatSource returns the metadata of the source at path, supplied by the
+// SourceProvider of the context or read from the filesystem.
+func (c FileContext) LstatSource(path string) (fs.FileInfo, error) {
+	fi, ok, err := c.ProvidedSource(path)
+	if err != nil || ok {
+		return fi, err
+	}
+	return os.Lstat(path)
+}
+
+// copyProvidedFile copies the source at src, supplied by the SourceProvider
+// of context, to dest.
+func copyProvidedFile(src, dest string, fi fs.FileInfo, context FileContext, uid, gid int64, chmod fs.FileMode, useDefaultChmod bool) error {
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("provided source %s is not a regular file", src)
+	}
+	uid, gid = DetermineTargetFileOwnership(fi, uid, gid)
+	mode := chmod
+	if useDefaultChmod {
+		mode = fi.Mode()
+		if context.DefaultFileMode != 0 {
+			mode = context.DefaultFileMode
+		}
+	}
+
+	logrus.Debugf("Copying provided file %s to %s", src, dest)
+	r, err := context.Sources.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "opening provided source %s", src)
+	}
+	defer r.Close()
+	if err := CreateFile(dest, r, mode, uint32(uid), uint32(gid)); err != nil {
+		return err
+	}
+	if mtime := fi.ModTime(); !mtime.IsZero() {
+		return os.Chtimes(dest, mtime, mtime)
+	}
+	return nil
+}
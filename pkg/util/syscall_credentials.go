@@ -22,12 +22,13 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/osscontainertools/kaniko/pkg/config"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 func SyscallCredentials(userStr string) (*syscall.Credential, error) {
-	uid, gid, err := getUIDAndGIDFromString(userStr)
+	uid, gid, err := getUIDAndGIDFromString(config.RootDir, userStr)
 	if err != nil {
 		return nil, errors.Wrap(err, "get uid/gid")
 	}
@@ -18,8 +18,11 @@ package util
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/bzip2"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -27,6 +30,7 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/moby/go-archive"
 	"github.com/osscontainertools/kaniko/pkg/config"
@@ -40,6 +44,8 @@ var ErrNotSupportedPlatform = errors.New("platform and architecture is not suppo
 type Tar struct {
 	hardlinks map[uint64]string
 	w         *tar.Writer
+	// noAccessTimes leaves the access and change times out of the headers
+	noAccessTimes bool
 }
 
 // NewTar will create an instance of Tar that can write files to the writer at f.
@@ -51,6 +57,15 @@ func NewTar(f io.Writer) Tar {
 	}
 }
 
+// NewStableTar is like NewTar, but leaves the access and change times of the files
+// out of the headers. Reading the files, ie. to write the tar, can change them, so
+// that a tar generated again from the same files would differ otherwise.
+func NewStableTar(f io.Writer) Tar {
+	t := NewTar(f)
+	t.noAccessTimes = true
+	return t
+}
+
 func CreateTarballOfDirectory(pathToDir string, f io.Writer) error {
 	if !filepath.IsAbs(pathToDir) {
 		return errors.New("pathToDir is not absolute")
@@ -120,6 +135,10 @@ func (t *Tar) AddFileToTar(p string) error {
 	hdr.Gname = ""
 	// use PAX format to preserve accurate mtime (match Docker behavior)
 	hdr.Format = tar.FormatPAX
+	if t.noAccessTimes {
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+	}
 
 	hardlink, linkDst := t.checkHardlink(p, i)
 	if hardlink {
@@ -195,6 +214,17 @@ func (t *Tar) Whiteout(p string) error {
 	return nil
 }
 
+// OpaqueWhiteout adds an opaque whiteout for the directory dir, which hides
+// the contents of dir in the previous layers.
+func (t *Tar) OpaqueWhiteout(dir string) error {
+	th := &tar.Header{
+		// Docker uses no leading / in the tarball
+		Name: strings.TrimLeft(filepath.Join(dir, archive.WhiteoutOpaqueDir), "/"),
+		Size: 0,
+	}
+	return t.w.WriteHeader(th)
+}
+
 // Returns true if path is hardlink, and the link destination
 func (t *Tar) checkHardlink(p string, i os.FileInfo) (bool, string) {
 	hardlink := false
@@ -228,76 +258,183 @@ func getSyscallStatT(i os.FileInfo) *syscall.Stat_t {
 // UnpackLocalTarArchive unpacks the tar archive at path to the directory dest
 // Returns the files extracted from the tar archive
 func UnpackLocalTarArchive(path, dest string) ([]string, error) {
-	// First, we need to check if the path is a local tar archive
-	if compressed, compressionLevel := fileIsCompressedTar(path); compressed {
-		file, err := FSys.Open(path)
-		if err != nil {
-			return nil, err
-		}
-		defer file.Close()
-		if compressionLevel == archive.Gzip {
-			gzr, err := gzip.NewReader(file)
-			if err != nil {
-				return nil, err
-			}
-			defer gzr.Close()
-			return UnTar(gzr, dest)
-		} else if compressionLevel == archive.Bzip2 {
-			bzr := bzip2.NewReader(file)
-			return UnTar(bzr, dest)
-		}
+	r, closeFn, err := openLocalTarArchive(path)
+	if err != nil {
+		return nil, err
 	}
-	if fileIsUncompressedTar(path) {
-		file, err := FSys.Open(path)
+	defer closeFn()
+	return UnTar(r, dest)
+}
+
+// decompressArchive decompresses the archives ArchiveCache doesn't have yet, for testing
+var decompressArchive = decompressedTar
+
+// ArchiveCache keeps the decompressed tars of the compressed archives ADD unpacks
+// in Dir, named by the digest of the archive, so that later builds unpacking the
+// same archive don't decompress it again, they still extract its files.
+// See --cache-decompressed-archives.
+type ArchiveCache struct {
+	Dir string
+	// TTL is how long a cached tar is used after it was written.
+	TTL time.Duration
+}
+
+// Unpack unpacks the tar archive at path to the directory dest like
+// UnpackLocalTarArchive, reading the decompressed tar from the cache if it has it.
+// Failing to write the cache doesn't fail the unpacking.
+func (c *ArchiveCache) Unpack(path, dest string) ([]string, error) {
+	file, err := FSys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	header, _ := br.Peek(10)
+	if archive.DetectCompression(header) == archive.Uncompressed {
+		// there's nothing to decompress
+		return UnTar(br, dest)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, br); err != nil {
+		return nil, err
+	}
+	cached := filepath.Join(c.Dir, "sha256-"+hex.EncodeToString(h.Sum(nil))+".tar")
+	if fi, err := os.Stat(cached); err == nil && time.Since(fi.ModTime()) < c.TTL {
+		logrus.Infof("Found decompressed archive %s in local cache", path)
+		f, err := os.Open(cached)
 		if err != nil {
 			return nil, err
 		}
-		defer file.Close()
-		return UnTar(file, dest)
+		defer f.Close()
+		return UnTar(f, dest)
+	}
+
+	if seeker, ok := file.(io.Seeker); !ok {
+		return nil, errors.Errorf("rereading %s", path)
+	} else if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r, closeFn, err := decompressArchive(file)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	tmp, err := c.create()
+	if err != nil {
+		logrus.Warnf("Not caching decompressed archive %s: %v", path, err)
+		return UnTar(r, dest)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	cache := &cacheWriter{w: tmp, path: path}
+	tr := io.TeeReader(r, cache)
+	files, err := UnTar(tr, dest)
+	if err != nil {
+		return nil, err
+	}
+	// the padding after the end of the tar belongs to it as well
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		return nil, err
+	}
+	if cache.err != nil {
+		return files, nil
+	}
+	if err := tmp.Close(); err != nil {
+		logrus.Warnf("Not caching decompressed archive %s: %v", path, err)
+	} else if err := os.Rename(tmp.Name(), cached); err != nil {
+		logrus.Warnf("Not caching decompressed archive %s: %v", path, err)
 	}
-	return nil, errors.New("path does not lead to local tar archive")
+	return files, nil
 }
 
-// IsFileLocalTarArchive returns true if the file is a local tar archive
-func IsFileLocalTarArchive(src string) bool {
-	compressed, _ := fileIsCompressedTar(src)
-	uncompressed := fileIsUncompressedTar(src)
-	return compressed || uncompressed
+// cacheWriter writes the decompressed archive to the cache while it is unpacked. It
+// drops the cached copy after the first error writing it, which must not fail the
+// unpacking.
+type cacheWriter struct {
+	w    io.Writer
+	path string
+	err  error
 }
 
-func fileIsCompressedTar(src string) (bool, archive.Compression) {
-	r, err := FSys.Open(src)
-	if err != nil {
-		return false, -1
+func (c *cacheWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return len(p), nil
 	}
-	defer r.Close()
-	buf, err := io.ReadAll(r)
-	if err != nil {
-		return false, -1
+	if _, err := c.w.Write(p); err != nil {
+		c.err = err
+		logrus.Warnf("Not caching decompressed archive %s: %v", c.path, err)
 	}
-	compressionLevel := archive.DetectCompression(buf)
-	return (compressionLevel > 0), compressionLevel
+	return len(p), nil
 }
 
-func fileIsUncompressedTar(src string) bool {
-	r, err := FSys.Open(src)
+// create creates a temporary file in the cache, that is renamed once complete.
+func (c *ArchiveCache) create() (*os.File, error) {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(c.Dir, "archive-")
+}
+
+// IsFileLocalTarArchive returns true if the file is a local tar archive.
+// Like docker, this is decided by the content of the file and not by its name,
+// a compressed file is only an archive if it decompresses to a tar.
+func IsFileLocalTarArchive(src string) bool {
+	r, closeFn, err := openLocalTarArchive(src)
 	if err != nil {
 		return false
 	}
-	defer r.Close()
-	fi, err := os.Lstat(src)
+	defer closeFn()
+	_, err = tar.NewReader(r).Next()
+	return err == nil
+}
+
+// openLocalTarArchive opens the file at path, decompressing it if its content
+// is compressed with a supported algorithm.
+func openLocalTarArchive(path string) (io.Reader, func(), error) {
+	file, err := FSys.Open(path)
 	if err != nil {
-		return false
+		return nil, nil, err
 	}
-	if fi.Size() == 0 {
-		return false
+	r, closeFn, err := decompressedTar(file)
+	if err != nil {
+		file.Close()
+		logrus.Warnf("Not extracting %s: %v", path, err)
+		return nil, nil, err
 	}
-	tr := tar.NewReader(r)
-	if tr == nil {
-		return false
+	return r, func() { closeFn(); file.Close() }, nil
+}
+
+// decompressedTar returns a reader of the tar in r, decompressing it if its content
+// is compressed with a supported algorithm.
+func decompressedTar(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	// Peek returns fewer bytes for short streams, which is fine for detection
+	header, _ := br.Peek(10)
+	switch compression := archive.DetectCompression(header); compression {
+	case archive.Uncompressed:
+		return br, func() {}, nil
+	case archive.Gzip:
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gzr, func() { gzr.Close() }, nil
+	case archive.Bzip2:
+		return bzip2.NewReader(br), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("%s compressed archives are not supported", compression.Extension())
 	}
-	_, err = tr.Next()
-	return err == nil
+}
+
+// UnpackTarStream unpacks the possibly compressed tar read from r to dest.
+// The stream is extracted while it is read, so it is never held in memory.
+func UnpackTarStream(r io.Reader, dest string) ([]string, error) {
+	tr, closeFn, err := decompressedTar(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	return UnTar(tr, dest)
 }
 
 // UnpackCompressedTar unpacks the compressed tar at path to dir
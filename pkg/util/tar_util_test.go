@@ -20,11 +20,14 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/osscontainertools/kaniko/testutil"
@@ -56,6 +59,94 @@ func Test_IsLocalTarArchive(t *testing.T) {
 	}
 }
 
+func Test_IsLocalTarArchive_compressedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzw := gzip.NewWriter(f)
+	if _, err := gzw.Write([]byte("compressed, but not a tar")); err != nil {
+		t.Fatal(err)
+	}
+	gzw.Close()
+	f.Close()
+
+	testutil.CheckDeepEqual(t, false, IsFileLocalTarArchive(path))
+	_, err = UnpackLocalTarArchive(path, t.TempDir())
+	testutil.CheckError(t, true, err)
+}
+
+func Test_ArchiveCache_Unpack(t *testing.T) {
+	testDir := t.TempDir()
+	if err := setUpFilesAndTars(testDir); err != nil {
+		t.Fatal(err)
+	}
+	var decompressions int
+	original := decompressArchive
+	defer func() { decompressArchive = original }()
+	decompressArchive = func(r io.Reader) (io.Reader, func(), error) {
+		decompressions++
+		return original(r)
+	}
+
+	cache := &ArchiveCache{Dir: filepath.Join(t.TempDir(), "archives"), TTL: time.Hour}
+	unpack := func(archive string) []string {
+		dest := t.TempDir()
+		files, err := cache.Unpack(filepath.Join(testDir, archive), dest)
+		testutil.CheckNoError(t, err)
+		var rel []string
+		for _, file := range files {
+			r, err := filepath.Rel(dest, file)
+			testutil.CheckNoError(t, err)
+			rel = append(rel, r)
+		}
+		return rel
+	}
+
+	first := unpack("compressed.tar.gz")
+	testutil.CheckDeepEqual(t, true, len(first) > 0)
+	testutil.CheckDeepEqual(t, 1, decompressions)
+	// the second build unpacks the cached tar without decompressing the archive
+	testutil.CheckDeepEqual(t, first, unpack("compressed.tar.gz"))
+	testutil.CheckDeepEqual(t, 1, decompressions)
+	// the cached tar is named by the digest of the content, not of the name
+	unpack("compressed")
+	testutil.CheckDeepEqual(t, 1, decompressions)
+
+	// a stale cache is not used
+	cache.TTL = 0
+	testutil.CheckDeepEqual(t, first, unpack("compressed.tar.gz"))
+	testutil.CheckDeepEqual(t, 2, decompressions)
+
+	// uncompressed archives are not cached
+	unpack("uncompressed.tar")
+	entries, err := os.ReadDir(cache.Dir)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 1, len(entries))
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("no space left on device")
+}
+
+func Test_cacheWriter_drops_the_cache_after_an_error(t *testing.T) {
+	failing := &failingWriter{}
+	cache := &cacheWriter{w: failing, path: "archive.tar.gz"}
+	var out bytes.Buffer
+	// the unpacking reads through the cache writer, which must not fail it
+	_, err := io.Copy(&out, io.TeeReader(iotest.OneByteReader(strings.NewReader("content")), cache))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "content", out.String())
+	testutil.CheckError(t, true, cache.err)
+	testutil.CheckDeepEqual(t, 1, failing.writes)
+}
+
 func Test_AddFileToTar(t *testing.T) {
 	testDir := t.TempDir()
 
@@ -114,6 +205,12 @@ func setUpFilesAndTars(testDir string) error {
 		if err := createTar(testDir, gzr); err != nil {
 			return err
 		}
+		if err := gzr.Close(); err != nil {
+			return err
+		}
+		if err := tarFile.Close(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,180 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// FileTransform transforms the copied regular file at path and returns the paths
+// of the files it wrote next to it, which are added to the layer of the copy.
+type FileTransform func(path string) ([]string, error)
+
+// GzipTransform is the name of the transform writing a gzip compressed copy of a
+// file next to it, ie. app.css.gz for app.css.
+const GzipTransform = "gzip"
+
+var (
+	fileTransformsMu sync.Mutex
+	fileTransforms   = map[string]FileTransform{
+		GzipTransform: gzipFile,
+	}
+)
+
+// RegisterFileTransform makes transform available to --copy-transform by name, for
+// programs embedding kaniko to transform copied files in other ways, ie. brotli.
+// Registering a name twice replaces the transform registered before.
+func RegisterFileTransform(name string, transform FileTransform) {
+	fileTransformsMu.Lock()
+	defer fileTransformsMu.Unlock()
+	fileTransforms[name] = transform
+}
+
+// CopyTransform runs the transform named Transform on the copied files matching
+// Pattern, see --copy-transform.
+type CopyTransform struct {
+	Pattern   string
+	Transform string
+}
+
+// ParseCopyTransforms parses --copy-transform values of the form pattern=transform.
+func ParseCopyTransforms(specs []string) ([]CopyTransform, error) {
+	var transforms []CopyTransform
+	for _, spec := range specs {
+		pattern, transform, ok := strings.Cut(spec, "=")
+		if !ok || pattern == "" || transform == "" {
+			return nil, fmt.Errorf("--copy-transform must be of the form pattern=transform, got %q", spec)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "--copy-transform pattern %q", pattern)
+		}
+		fileTransformsMu.Lock()
+		_, known := fileTransforms[transform]
+		names := make([]string, 0, len(fileTransforms))
+		for n := range fileTransforms {
+			names = append(names, n)
+		}
+		fileTransformsMu.Unlock()
+		if !known {
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown --copy-transform %q, registered are %s", transform, strings.Join(names, ", "))
+		}
+		transforms = append(transforms, CopyTransform{Pattern: pattern, Transform: transform})
+	}
+	return transforms, nil
+}
+
+// matches returns true if the pattern matches the name of the file at path, or the
+// path of the file in the image if the pattern contains a slash.
+func (t CopyTransform) matches(path string) bool {
+	name := filepath.Base(path)
+	if strings.Contains(t.Pattern, "/") {
+		rel, err := filepath.Rel(config.RootDir, path)
+		if err != nil {
+			return false
+		}
+		name = filepath.Join("/", rel)
+	}
+	ok, _ := filepath.Match(t.Pattern, name)
+	return ok
+}
+
+// TransformFiles runs the transforms on the regular files among files they match,
+// in the order they are given, and returns the files the transforms wrote.
+func TransformFiles(files []string, transforms []CopyTransform) ([]string, error) {
+	var outputs []string
+	for _, f := range files {
+		fi, err := os.Lstat(f)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		for _, t := range transforms {
+			if !t.matches(f) {
+				continue
+			}
+			fileTransformsMu.Lock()
+			transform := fileTransforms[t.Transform]
+			fileTransformsMu.Unlock()
+			written, err := transform(f)
+			if err != nil {
+				return nil, errors.Wrapf(err, "running the %s transform on %s", t.Transform, f)
+			}
+			logrus.Debugf("Transformed %s with %s to %v", f, t.Transform, written)
+			outputs = append(outputs, written...)
+		}
+	}
+	return outputs, nil
+}
+
+// gzipFile writes path compressed with gzip to path.gz, with the mode, owner and
+// mtime of path. The gzip header carries no name or time, so that the compressed
+// file only depends on the content of path.
+func gzipFile(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dest := path + ".gz"
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	gz, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(gz, src); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	// the mode of an existing file isn't changed by opening it
+	if err := os.Chmod(dest, fi.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(dest, int(stat.Uid), int(stat.Gid)); err != nil {
+			return nil, err
+		}
+	}
+	if err := CopyTimestamps(path, dest); err != nil {
+		return nil, err
+	}
+	return []string{dest}, nil
+}
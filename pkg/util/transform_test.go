@@ -0,0 +1,91 @@
+/*
+Copyright 2026 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/osscontainertools/kaniko/testutil"
+)
+
+func Test_ParseCopyTransforms(t *testing.T) {
+	transforms, err := ParseCopyTransforms([]string{"*.css=gzip", "/static/*.js=gzip"})
+	testutil.CheckErrorAndDeepEqual(t, false, err, []CopyTransform{
+		{Pattern: "*.css", Transform: GzipTransform},
+		{Pattern: "/static/*.js", Transform: GzipTransform},
+	}, transforms)
+
+	for spec, msg := range map[string]string{
+		"*.css":        "must be of the form pattern=transform",
+		"=gzip":        "must be of the form pattern=transform",
+		"[.css=gzip":   "syntax error in pattern",
+		"*.css=brotli": `unknown --copy-transform "brotli", registered are gzip`,
+	} {
+		_, err := ParseCopyTransforms([]string{spec})
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q to fail with %q, got %v", spec, msg, err)
+		}
+	}
+}
+
+func Test_TransformFiles(t *testing.T) {
+	root := t.TempDir()
+	original := config.RootDir
+	config.RootDir = root
+	defer func() { config.RootDir = original }()
+
+	var files []string
+	for _, f := range []string{"static/app.js", "lib/app.js", "lib/app.css"} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0640); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	RegisterFileTransform("upper", func(path string) ([]string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path + ".upper"}, os.WriteFile(path+".upper", []byte(strings.ToUpper(string(b))), 0644)
+	})
+	defer func() {
+		fileTransformsMu.Lock()
+		delete(fileTransforms, "upper")
+		fileTransformsMu.Unlock()
+	}()
+
+	// patterns with a slash match the path in the image, others the name of the file
+	written, err := TransformFiles(append(files, filepath.Join(root, "static")), []CopyTransform{
+		{Pattern: "/static/*.js", Transform: GzipTransform},
+		{Pattern: "*.css", Transform: "upper"},
+	})
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{
+		filepath.Join(root, "static/app.js.gz"),
+		filepath.Join(root, "lib/app.css.upper"),
+	}, written)
+	fi, err := os.Stat(filepath.Join(root, "static/app.js.gz"))
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, os.FileMode(0640), fi.Mode().Perm())
+}
@@ -17,18 +17,26 @@ limitations under the License.
 package util
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"net/http"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/osscontainertools/kaniko/pkg/config"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+var ErrRegistryNotAllowed = errors.New("registry is not allowed")
+
 type CertPool interface {
 	value() *x509.CertPool
 	append(path string) error
@@ -79,18 +87,27 @@ func init() {
 	systemKeyPairLoader = &X509KeyPairLoader{}
 }
 
+// MakeTransport returns the transport used to push to and read from registryName,
+// it sends the headers of --registry-header and --registry-user-agent if set.
 func MakeTransport(opts config.RegistryOptions, registryName string) (http.RoundTripper, error) {
-	// Create a transport to set our user-agent.
-	var tr http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+	tr, err := makeHTTPTransport(opts, registryName)
+	if err != nil {
+		return nil, err
+	}
+	return withRegistryHeaders(opts, tr), nil
+}
+
+func makeHTTPTransport(opts config.RegistryOptions, registryName string) (*http.Transport, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
 	if opts.SkipTLSVerify || opts.SkipTLSVerifyRegistries.Contains(registryName) {
-		tr.(*http.Transport).TLSClientConfig = &tls.Config{
+		tr.TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: true,
 		}
 	} else if certificatePath := opts.RegistriesCertificates[registryName]; certificatePath != "" {
 		if err := systemCertLoader.append(certificatePath); err != nil {
 			return nil, fmt.Errorf("failed to load certificate %s for %s: %w", certificatePath, registryName, err)
 		}
-		tr.(*http.Transport).TLSClientConfig = &tls.Config{
+		tr.TLSClientConfig = &tls.Config{
 			RootCAs: systemCertLoader.value(),
 		}
 	}
@@ -104,8 +121,161 @@ func MakeTransport(opts config.RegistryOptions, registryName string) (http.Round
 		if err != nil {
 			return nil, fmt.Errorf("failed to load client certificate/key '%s' for %s: %w", clientCertificatePath, registryName, err)
 		}
-		tr.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+		tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
 	}
 
 	return tr, nil
 }
+
+// withRegistryHeaders returns tr sending the headers of --registry-header and the
+// user agent of --registry-user-agent with each request, or tr if there are none.
+func withRegistryHeaders(opts config.RegistryOptions, tr http.RoundTripper) http.RoundTripper {
+	if len(opts.RegistryHeaders) == 0 && opts.RegistryUserAgent == "" {
+		return tr
+	}
+	return &headerTransport{inner: tr, headers: opts.RegistryHeaders, userAgent: opts.RegistryUserAgent}
+}
+
+// headerTransport sets headers on the requests to a registry, the user agent
+// replaces the one set by the transports wrapping it.
+type headerTransport struct {
+	inner     http.RoundTripper
+	headers   map[string]string
+	userAgent string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.inner.RoundTrip(req)
+}
+
+// MakePullTransport returns the transport used to pull images and read the cache from
+// registryName. It is bounded by --pull-timeout, --pull-tls-handshake-timeout and
+// --pull-max-idle-conns on top of the settings of MakeTransport.
+func MakePullTransport(opts config.RegistryOptions, registryName string) (http.RoundTripper, error) {
+	t, err := makeHTTPTransport(opts, registryName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PullTLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = opts.PullTLSHandshakeTimeout
+	}
+	if opts.PullMaxIdleConns > 0 {
+		t.MaxIdleConns = opts.PullMaxIdleConns
+		t.MaxIdleConnsPerHost = opts.PullMaxIdleConns
+	}
+	if opts.PullTimeout > 0 {
+		return withRegistryHeaders(opts, &timeoutTransport{inner: t, timeout: opts.PullTimeout}), nil
+	}
+	return withRegistryHeaders(opts, t), nil
+}
+
+// timeoutTransport cancels a request, including reading its response body,
+// once timeout has passed. The context of the request is respected as well.
+type timeoutTransport struct {
+	inner   http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.inner.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// CheckRegistryAllowed returns an error if --allowed-registry is set and registryName
+// matches none of its patterns. Patterns are host names that may contain wildcards,
+// ie. `*.gcr.io` or `registry.example.com:*`.
+func CheckRegistryAllowed(opts config.RegistryOptions, registryName string) error {
+	if len(opts.AllowedRegistries) == 0 {
+		return nil
+	}
+	for _, pattern := range opts.AllowedRegistries {
+		if registryMatches(pattern, registryName) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s does not match any of %s", ErrRegistryNotAllowed, registryName, strings.Join(opts.AllowedRegistries, ", "))
+}
+
+func registryMatches(pattern, registryName string) bool {
+	pattern = strings.ToLower(pattern)
+	if !strings.ContainsAny(pattern, "*?[") {
+		// normalize ie. docker.io to index.docker.io
+		if reg, err := name.NewRegistry(pattern, name.WeakValidation); err == nil {
+			pattern = reg.RegistryStr()
+		}
+	}
+	ok, err := path.Match(pattern, strings.ToLower(registryName))
+	return err == nil && ok
+}
+
+// RewriteReference rewrites image with the --rewrite-reference rule whose prefix
+// matches the most path components of its fully qualified name, ie. with the rule
+// `docker.io=mirror.example.com` `alpine:3` is rewritten to `mirror.example.com/library/alpine:3`.
+// image is returned unchanged if no rule matches or it isn't a valid reference.
+func RewriteReference(opts config.RegistryOptions, image string) string {
+	if len(opts.ReferenceRewrites) == 0 {
+		return image
+	}
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return image
+	}
+	repo := ref.Context().Name()
+	match, replacement := "", ""
+	for prefix, r := range opts.ReferenceRewrites {
+		prefix = normalizeReferencePrefix(prefix)
+		if (repo == prefix || strings.HasPrefix(repo, prefix+"/")) && len(prefix) > len(match) {
+			match, replacement = prefix, r
+		}
+	}
+	if match == "" {
+		return image
+	}
+	rewritten := strings.TrimSuffix(replacement, "/") + strings.TrimPrefix(repo, match)
+	switch r := ref.(type) {
+	case name.Tag:
+		rewritten += ":" + r.TagStr()
+	case name.Digest:
+		rewritten += "@" + r.DigestStr()
+	}
+	logrus.Infof("Rewriting reference %s to %s", image, rewritten)
+	return rewritten
+}
+
+// normalizeReferencePrefix normalizes the registry of prefix, ie. docker.io to index.docker.io.
+func normalizeReferencePrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	registry, repo, _ := strings.Cut(prefix, "/")
+	reg, err := name.NewRegistry(registry, name.WeakValidation)
+	if err != nil {
+		return prefix
+	}
+	if repo == "" {
+		return reg.Name()
+	}
+	return reg.Name() + "/" + repo
+}
@@ -17,11 +17,17 @@ limitations under the License.
 package util
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/osscontainertools/kaniko/pkg/config"
 )
@@ -173,3 +179,126 @@ func Test_makeTransport(t *testing.T) {
 
 	}
 }
+
+func Test_CheckRegistryAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		registry string
+		want     bool
+	}{
+		{name: "no allowlist", allowed: nil, registry: "quay.io", want: true},
+		{name: "exact match", allowed: []string{"quay.io"}, registry: "quay.io", want: true},
+		{name: "case insensitive", allowed: []string{"Quay.IO"}, registry: "quay.io", want: true},
+		{name: "docker hub alias", allowed: []string{"docker.io"}, registry: "index.docker.io", want: true},
+		{name: "wildcard subdomain", allowed: []string{"*.gcr.io"}, registry: "eu.gcr.io", want: true},
+		{name: "wildcard does not match parent", allowed: []string{"*.gcr.io"}, registry: "gcr.io", want: false},
+		{name: "wildcard port", allowed: []string{"localhost:*"}, registry: "localhost:5000", want: true},
+		{name: "port must match", allowed: []string{"localhost"}, registry: "localhost:5000", want: false},
+		{name: "second pattern", allowed: []string{"quay.io", "ghcr.io"}, registry: "ghcr.io", want: true},
+		{name: "not allowed", allowed: []string{"*.gcr.io"}, registry: "evil.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRegistryAllowed(config.RegistryOptions{AllowedRegistries: tt.allowed}, tt.registry)
+			if tt.want && err != nil {
+				t.Errorf("expected %s to be allowed, got %v", tt.registry, err)
+			}
+			if !tt.want && !errors.Is(err, ErrRegistryNotAllowed) {
+				t.Errorf("expected %s to be rejected, got %v", tt.registry, err)
+			}
+		})
+	}
+}
+
+func Test_MakePullTransport(t *testing.T) {
+	tr, err := MakePullTransport(config.RegistryOptions{PullTLSHandshakeTimeout: 3 * time.Second, PullMaxIdleConns: 2}, "gcr.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ht, ok := tr.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected an *http.Transport without --pull-timeout, got %T", tr)
+	}
+	if ht.TLSHandshakeTimeout != 3*time.Second {
+		t.Errorf("expected TLS handshake timeout of 3s, got %s", ht.TLSHandshakeTimeout)
+	}
+	if ht.MaxIdleConns != 2 || ht.MaxIdleConnsPerHost != 2 {
+		t.Errorf("expected 2 idle connections, got %d and %d per host", ht.MaxIdleConns, ht.MaxIdleConnsPerHost)
+	}
+}
+
+func Test_MakePullTransport_timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// a slow body, the timeout must cover reading it as well
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tr, err := MakePullTransport(config.RegistryOptions{PullTimeout: 100 * time.Millisecond}, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: tr}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the pull to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the pull to be cancelled after 100ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := client.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled context to be respected, got %v", err)
+	}
+}
+
+func Test_RewriteReference(t *testing.T) {
+	rewrites := map[string]string{
+		"docker.io":                   "mirror.example.com",
+		"docker.io/library/busybox":   "mirror.example.com/busybox",
+		"gcr.io/project":              "mirror.example.com/gcr/",
+		"registry.example.com:5000/a": "mirror.example.com/b",
+	}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "docker.io/library/alpine", want: "mirror.example.com/library/alpine:latest"},
+		{image: "alpine:3", want: "mirror.example.com/library/alpine:3"},
+		{image: "index.docker.io/foo/bar@sha256:" + strings.Repeat("a", 64), want: "mirror.example.com/foo/bar@sha256:" + strings.Repeat("a", 64)},
+		{image: "busybox:1", want: "mirror.example.com/busybox:1"},
+		{image: "gcr.io/project/image:v1", want: "mirror.example.com/gcr/image:v1"},
+		{image: "gcr.io/project2/image:v1", want: "gcr.io/project2/image:v1"},
+		{image: "registry.example.com:5000/a/b:c", want: "mirror.example.com/b/b:c"},
+		{image: "quay.io/foo/bar", want: "quay.io/foo/bar"},
+		{image: "Not A Reference", want: "Not A Reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got := RewriteReference(config.RegistryOptions{ReferenceRewrites: rewrites}, tt.image)
+			if got != tt.want {
+				t.Errorf("expected %s to be rewritten to %s, got %s", tt.image, tt.want, got)
+			}
+		})
+	}
+}
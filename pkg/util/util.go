@@ -199,9 +199,15 @@ func Retry(operation retryFunc, retryCount int, initialDelayMilliseconds int) er
 
 // Retry retries an operation with a return value
 func RetryWithResult[T any](operation func() (T, error), retryCount int, initialDelayMilliseconds int) (result T, err error) {
+	return RetryWithResultIf(operation, func(error) bool { return true }, retryCount, initialDelayMilliseconds)
+}
+
+// RetryWithResultIf retries an operation with a return value as long as retryable
+// returns true for its error, other errors are returned right away.
+func RetryWithResultIf[T any](operation func() (T, error), retryable func(error) bool, retryCount int, initialDelayMilliseconds int) (result T, err error) {
 	result, err = operation()
-	if err == nil {
-		return result, nil
+	if err == nil || !retryable(err) {
+		return result, err
 	}
 	for i := 0; i < retryCount; i++ {
 		sleepDuration := time.Millisecond * time.Duration(int(math.Pow(2, float64(i)))*initialDelayMilliseconds)
@@ -209,8 +215,8 @@ func RetryWithResult[T any](operation func() (T, error), retryCount int, initial
 		time.Sleep(sleepDuration)
 
 		result, err = operation()
-		if err == nil {
-			return result, nil
+		if err == nil || !retryable(err) {
+			return result, err
 		}
 	}
 
@@ -102,3 +102,18 @@ func TestRetryWithResult(t *testing.T) {
 		t.Fatalf("Got result %d and error: %v", result, err)
 	}
 }
+
+func TestRetryWithResultIf(t *testing.T) {
+	retryable := func(error) bool { return true }
+	result, err := RetryWithResultIf(makeRetryFuncWithResult(2), retryable, 2, 10)
+	if err != nil || result != 2 {
+		t.Fatalf("Got result %d and error: %v", result, err)
+	}
+
+	// errors that aren't retryable are returned right away
+	permanent := func(error) bool { return false }
+	result, err = RetryWithResultIf(makeRetryFuncWithResult(2), permanent, 2, 10)
+	if err == nil || result != 0 {
+		t.Fatalf("Got result %d and error: %v", result, err)
+	}
+}